@@ -22,7 +22,6 @@ package main
 import (
 	"github.com/ubuntu-core/snappy/i18n"
 	"github.com/ubuntu-core/snappy/logger"
-	"github.com/ubuntu-core/snappy/progress"
 	"github.com/ubuntu-core/snappy/snappy"
 )
 
@@ -56,5 +55,5 @@ func (x *cmdActivate) Execute(args []string) error {
 }
 
 func (x *cmdActivate) doActivate() error {
-	return snappy.SetActive(x.Args.Snap, x.activate, progress.MakeProgressBar())
+	return snappy.SetActive(x.Args.Snap, x.activate, newMeter("activate"))
 }
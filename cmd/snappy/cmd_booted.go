@@ -22,6 +22,8 @@ package main
 import (
 	"github.com/ubuntu-core/snappy/logger"
 	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/progress"
+	"github.com/ubuntu-core/snappy/release"
 	"github.com/ubuntu-core/snappy/snappy"
 )
 
@@ -43,6 +45,24 @@ func (x *cmdBooted) Execute(args []string) error {
 }
 
 func (x *cmdBooted) doBooted() error {
+	if err := snappy.CheckStorageLocations(&progress.NullProgress{}); err != nil {
+		logger.Noticef("%v", err)
+	}
+
+	// regenerate what snappy generated for the snaps once after an
+	// upgrade to a new series (or flavor)
+	results, err := snappy.MigrateGeneratedState(snappy.GeneratedStateRelease(), release.Get().String(), &progress.NullProgress{})
+	if err != nil {
+		logger.Noticef("Failed to regenerate the generated files of the snaps: %v", err)
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			logger.Noticef("Failed to regenerate the generated files of %s: %v", result.Snap, result.Err)
+		} else {
+			logger.Noticef("Regenerated the generated files of %s", result.Snap)
+		}
+	}
+
 	parts, err := snappy.ActiveSnapsByType(pkg.TypeCore)
 	if err != nil {
 		return err
@@ -0,0 +1,75 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/ubuntu-core/snappy/i18n"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/snappy"
+)
+
+type cmdGC struct {
+	Keep   int  `long:"keep"`
+	DryRun bool `long:"dry-run"`
+}
+
+var (
+	shortGCHelp = i18n.G("Remove the old inactive versions of the installed packages")
+	longGCHelp  = i18n.G(`Remove the old inactive versions of the listed packages (or of all the installed ones), keeping the newest ones to roll back to.`)
+)
+
+func init() {
+	arg, err := parser.AddCommand("gc",
+		shortGCHelp,
+		longGCHelp,
+		&cmdGC{})
+	if err != nil {
+		logger.Panicf("Unable to gc: %v", err)
+	}
+	addOptionDescription(arg, "keep", fmt.Sprintf(i18n.G("The number of inactive versions of each package to keep (default %d)."), snappy.DefaultGCKeep))
+	addOptionDescription(arg, "dry-run", i18n.G("Only list the versions that would be removed."))
+}
+
+func (x *cmdGC) Execute(args []string) error {
+	return withMutexAndRetry(func() error {
+		return x.doGC(args)
+	})
+}
+
+func (x *cmdGC) doGC(args []string) error {
+	removed, err := snappy.GarbageCollectAll(args, &snappy.GCOptions{
+		Keep:   x.Keep,
+		DryRun: x.DryRun,
+		Meter:  newMeter("gc"),
+	})
+	for _, part := range removed {
+		if x.DryRun {
+			// TRANSLATORS: the first %s is a pkgname, the second its version
+			fmt.Fprintf(humanOutput(), i18n.G("Would remove %s %s\n"), snappy.QualifiedName(part), part.Version())
+		} else {
+			// TRANSLATORS: the first %s is a pkgname, the second its version
+			fmt.Fprintf(humanOutput(), i18n.G("Removed %s %s\n"), snappy.QualifiedName(part), part.Version())
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,70 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/ubuntu-core/snappy/i18n"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/snappy"
+)
+
+type cmdHold struct {
+	Clear bool `long:"clear"`
+}
+
+var (
+	shortHoldHelp = i18n.G("Hold the listed packages at their current version")
+	longHoldHelp  = i18n.G(`Hold the listed packages at their current version: "snappy update" leaves them out until the hold is cleared with --clear. Without packages, list the held ones.`)
+)
+
+func init() {
+	arg, err := parser.AddCommand("hold",
+		shortHoldHelp,
+		longHoldHelp,
+		&cmdHold{})
+	if err != nil {
+		logger.Panicf("Unable to hold: %v", err)
+	}
+	addOptionDescription(arg, "clear", i18n.G("Clear the hold, for the packages to get updates again."))
+}
+
+func (x *cmdHold) Execute(args []string) error {
+	if len(args) == 0 {
+		held, err := snappy.HeldSnaps()
+		if err != nil {
+			return err
+		}
+		for _, qn := range held {
+			fmt.Println(qn)
+		}
+		return nil
+	}
+
+	return withMutexAndRetry(func() error {
+		for _, name := range args {
+			if err := snappy.SetHold(name, !x.Clear); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
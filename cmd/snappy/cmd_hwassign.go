@@ -55,10 +55,10 @@ func (x *cmdHWAssign) Execute(args []string) error {
 }
 
 func (x *cmdHWAssign) doHWAssign() error {
-	if err := snappy.AddHWAccess(x.Positional.PackageName, x.Positional.DevicePath); err != nil {
+	if err := snappy.AddHWAccess(x.Positional.PackageName, x.Positional.DevicePath, newMeter("hw-assign")); err != nil {
 		if err == snappy.ErrHWAccessAlreadyAdded {
 			// TRANSLATORS: the first %s is a pkgname, the second %s is a path
-			fmt.Printf(i18n.G("'%s' previously allowed access to '%s'. Skipping\n"), x.Positional.PackageName, x.Positional.DevicePath)
+			fmt.Fprintf(humanOutput(), i18n.G("'%s' previously allowed access to '%s'. Skipping\n"), x.Positional.PackageName, x.Positional.DevicePath)
 			return nil
 		}
 
@@ -66,6 +66,6 @@ func (x *cmdHWAssign) doHWAssign() error {
 	}
 
 	// TRANSLATORS: the first %s is a pkgname, the second %s is a path
-	fmt.Printf(i18n.G("'%s' is now allowed to access '%s'\n"), x.Positional.PackageName, x.Positional.DevicePath)
+	fmt.Fprintf(humanOutput(), i18n.G("'%s' is now allowed to access '%s'\n"), x.Positional.PackageName, x.Positional.DevicePath)
 	return nil
 }
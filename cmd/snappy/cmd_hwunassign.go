@@ -55,11 +55,11 @@ func (x *cmdHWUnassign) Execute(args []string) error {
 }
 
 func (x *cmdHWUnassign) doHWUnassign() error {
-	if err := snappy.RemoveHWAccess(x.Positional.PackageName, x.Positional.DevicePath); err != nil {
+	if err := snappy.RemoveHWAccess(x.Positional.PackageName, x.Positional.DevicePath, newMeter("hw-unassign")); err != nil {
 		return err
 	}
 
 	// TRANSLATORS: the first %s is a pkgname, the second %s is a path
-	fmt.Printf(i18n.G("'%s' is no longer allowed to access '%s'\n"), x.Positional.PackageName, x.Positional.DevicePath)
+	fmt.Fprintf(humanOutput(), i18n.G("'%s' is no longer allowed to access '%s'\n"), x.Positional.PackageName, x.Positional.DevicePath)
 	return nil
 }
@@ -111,10 +111,22 @@ func ubuntuCoreChannel() string {
 	return "unknown"
 }
 
+// activeFullNames returns the full names of the active snaps of the
+// given type
+func activeFullNames(t pkg.Type) []string {
+	parts, _ := snappy.InstalledByType(snappy.InstalledOptions{ActiveOnly: true}, t)
+	names := make([]string, len(parts))
+	for i, part := range parts {
+		names[i] = snappy.FullName(part)
+	}
+
+	return names
+}
+
 func info() error {
 	release := ubuntuCoreChannel()
-	frameworks, _ := snappy.ActiveSnapIterByType(snappy.FullName, pkg.TypeFramework)
-	apps, _ := snappy.ActiveSnapIterByType(snappy.FullName, pkg.TypeApp)
+	frameworks := activeFullNames(pkg.TypeFramework)
+	apps := activeFullNames(pkg.TypeApp)
 
 	// TRANSLATORS: the %s release string
 	fmt.Printf(i18n.G("release: %s\n"), release)
@@ -22,17 +22,26 @@ package main
 import (
 	"errors"
 	"fmt"
-	"os"
 
 	"github.com/ubuntu-core/snappy/i18n"
 	"github.com/ubuntu-core/snappy/logger"
-	"github.com/ubuntu-core/snappy/progress"
 	"github.com/ubuntu-core/snappy/snappy"
 )
 
 type cmdInstall struct {
-	AllowUnauthenticated bool `long:"allow-unauthenticated"`
-	DisableGC            bool `long:"no-gc"`
+	AllowUnauthenticated bool    `long:"allow-unauthenticated"`
+	DevMode              bool    `long:"devmode"`
+	DisableGC            bool    `long:"no-gc"`
+	Version              string  `long:"version"`
+	Channel              string  `long:"channel"`
+	FromDir              string  `long:"from-dir"`
+	Buy                  string  `long:"buy"`
+	MaxPrice             float64 `long:"max-price"`
+	Debug                bool    `long:"debug"`
+	CheckStoreName       bool    `long:"check-store-name"`
+	Force                bool    `long:"force"`
+	DryRun               bool    `long:"dry-run"`
+	Sha512               string  `long:"sha512"`
 	Positional           struct {
 		PackageName string `positional-arg-name:"package name"`
 		ConfigFile  string `positional-arg-name:"config file"`
@@ -48,8 +57,19 @@ func init() {
 		logger.Panicf("Unable to install: %v", err)
 	}
 	addOptionDescription(arg, "allow-unauthenticated", i18n.G("Install snaps even if the signature can not be verified."))
+	addOptionDescription(arg, "devmode", i18n.G("Install the snap in developer mode, with its security policy in complain mode (implies --allow-unauthenticated)."))
 	addOptionDescription(arg, "no-gc", i18n.G("Do not clean up old versions of the package."))
-	addOptionDescription(arg, "package name", i18n.G("The Package to install (name or path)"))
+	addOptionDescription(arg, "version", i18n.G("Install the given version from the store instead of the latest one."))
+	addOptionDescription(arg, "channel", i18n.G("Install from the given channel (and update from it thereafter) instead of the channel of the system."))
+	addOptionDescription(arg, "from-dir", i18n.G("Install from the given directory of snaps (and their manifests) instead of the store."))
+	addOptionDescription(arg, "buy", i18n.G("Buy the package, if it needs buying, in the given currency (e.g. USD), once you agree to its price."))
+	addOptionDescription(arg, "max-price", i18n.G("Buy the package without asking if it costs no more than this (in the currency of --buy)."))
+	addOptionDescription(arg, "debug", i18n.G("Record a trace of the install for bug reports."))
+	addOptionDescription(arg, "check-store-name", i18n.G("Refuse to install a local snap if the store has a package of the same name (this asks the store)."))
+	addOptionDescription(arg, "force", i18n.G("Install a local snap even if the store has a package of the same name (with --check-store-name)."))
+	addOptionDescription(arg, "sha512", i18n.G("Check the package installed from an http or https URL against the given sha512."))
+	addOptionDescription(arg, "dry-run", i18n.G("Only show what would be downloaded and restarted, without changing anything."))
+	addOptionDescription(arg, "package name", i18n.G("The Package to install (name, path or http(s) URL)"))
 	addOptionDescription(arg, "config file", i18n.G("The configuration for the given install"))
 }
 
@@ -73,10 +93,36 @@ func (x *cmdInstall) doInstall() error {
 	if x.AllowUnauthenticated {
 		flags |= snappy.AllowUnauthenticated
 	}
+	opts := snappy.InstallOptions{
+		Flags:    flags,
+		Version:  x.Version,
+		Channel:  x.Channel,
+		DevMode:  x.DevMode,
+		SnapDir:  x.FromDir,
+		Currency: x.Buy,
+		MaxPrice: x.MaxPrice,
+		Meter:    newMeter("install"),
+		Debug:    x.Debug,
+		// a sideloaded snap must not take the name of one in
+		// the store by accident (but asking the store is not
+		// for every device)
+		CheckStoreName: x.CheckStoreName,
+		Force:          x.Force,
+		Sha512:         x.Sha512,
+	}
+	if x.DryRun {
+		plan, err := snappy.PlanInstall(pkgName, opts)
+		if err != nil {
+			return err
+		}
+		showPlan(plan, humanOutput())
+		return nil
+	}
+
 	// TRANSLATORS: the %s is a pkgname
-	fmt.Printf(i18n.G("Installing %s\n"), pkgName)
+	fmt.Fprintf(humanOutput(), i18n.G("Installing %s\n"), pkgName)
 
-	realPkgName, err := snappy.Install(pkgName, flags, progress.MakeProgressBar())
+	realPkgName, err := snappy.InstallWithOptions(pkgName, opts)
 	if err != nil {
 		return err
 	}
@@ -93,7 +139,7 @@ func (x *cmdInstall) doInstall() error {
 		return err
 	}
 
-	showInstalledList(installed, os.Stdout)
+	showInstalledList(installed, humanOutput())
 
 	return nil
 }
@@ -183,6 +183,11 @@ func unpackAndDropPrivs(snapFile, targetDir, rootDir string) error {
 		}
 	}
 
+	if f := clickdeb.ProgressFile(); f != nil {
+		defer f.Close()
+		return d.UnpackReporting(targetDir, f)
+	}
+
 	return d.Unpack(targetDir)
 }
 
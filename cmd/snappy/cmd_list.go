@@ -23,6 +23,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"strings"
 	"text/tabwriter"
 	"time"
 
@@ -33,8 +34,9 @@ import (
 )
 
 type cmdList struct {
-	Updates bool `short:"u" long:"updates"`
-	Verbose bool `short:"v" long:"verbose"`
+	Updates   bool `short:"u" long:"updates"`
+	Verbose   bool `short:"v" long:"verbose"`
+	Changelog bool `long:"changelog"`
 }
 
 var shortListHelp = i18n.G("List active components installed on a snappy system")
@@ -59,6 +61,7 @@ func init() {
 	cmd.Aliases = append(cmd.Aliases, "li")
 	addOptionDescription(cmd, "updates", i18n.G("Show available updates (requires network)"))
 	addOptionDescription(cmd, "verbose", i18n.G("Show channel information and expand all fields"))
+	addOptionDescription(cmd, "changelog", i18n.G("Show what changed in the available updates (with --updates)"))
 }
 
 func (x *cmdList) Execute(args []string) (err error) {
@@ -77,6 +80,9 @@ func (x cmdList) list() error {
 			return err
 		}
 		showUpdatesList(installed, updates, os.Stdout)
+		if x.Changelog {
+			showChangelogs(updates, os.Stdout)
+		}
 	} else if x.Verbose {
 		showVerboseList(installed, os.Stdout)
 	} else {
@@ -126,6 +132,47 @@ func showVerboseList(installed []snappy.Part, o io.Writer) {
 	showRebootMessage(installed, o)
 }
 
+// showPlan shows what an install or update would do (see --dry-run)
+func showPlan(plan *snappy.Plan, o io.Writer) {
+	w := tabwriter.NewWriter(o, 5, 3, 1, ' ', 0)
+
+	fmt.Fprintln(w, i18n.G("Name\tFrom\tTo\tDownload\tRestarts\t"))
+	for _, change := range plan.Changes {
+		from := change.From
+		if from == "" {
+			from = "-"
+		}
+		restarts := strings.Join(change.Restarts, ",")
+		if restarts == "" {
+			restarts = "-"
+		}
+		fmt.Fprintln(w, fmt.Sprintf("%s\t%s\t%s\t%d\t%s\t", change.Snap, from, change.To, change.DownloadSize, restarts))
+	}
+	w.Flush()
+
+	for _, change := range plan.Changes {
+		if len(change.Frameworks) > 0 {
+			// TRANSLATORS: the first %s is a pkgname, the second a comma separated list of frameworks
+			fmt.Fprintf(o, i18n.G("%s needs the frameworks %s, which are not installed\n"), change.Snap, strings.Join(change.Frameworks, ", "))
+		}
+	}
+	for _, removal := range plan.Removals {
+		if removal.Reason == snappy.RemovalUnpublished {
+			// TRANSLATORS: the first %s is a pkgname, the second its version
+			fmt.Fprintf(o, i18n.G("%s %s would be removed, as it was unpublished\n"), removal.Snap, removal.Version)
+		} else {
+			// TRANSLATORS: the first %s is a pkgname, the second its version
+			fmt.Fprintf(o, i18n.G("%s %s would be garbage collected\n"), removal.Snap, removal.Version)
+		}
+	}
+	for _, name := range plan.Unpublished {
+		// TRANSLATORS: the %s is a pkgname
+		fmt.Fprintf(o, i18n.G("%s was unpublished, and gets no updates\n"), name)
+	}
+	// TRANSLATORS: the %d is a number of bytes
+	fmt.Fprintf(o, i18n.G("Download size: %d bytes\n"), plan.DownloadSize())
+}
+
 func showRebootMessage(installed []snappy.Part, o io.Writer) {
 	// Initialise to handle systems without a provisioned "other"
 	otherVersion := "0"
@@ -181,7 +228,11 @@ func showUpdatesList(installed []snappy.Part, updates []snappy.Part, o io.Writer
 		ver := part.Version()
 		date := part.Date()
 		update := snappy.FindSnapsByName(part.Name(), updates)
-		if len(update) == 1 {
+		if len(update) == 1 && snappy.IsUnpublished(update[0]) {
+			// TRANSLATORS: shown after the version of a snap that
+			//              will not get updates anymore
+			ver += " " + i18n.G("(unpublished)")
+		} else if len(update) == 1 {
 			hasUpdate = "*"
 			ver = update[0].Version()
 			date = update[0].Date()
@@ -189,3 +240,16 @@ func showUpdatesList(installed []snappy.Part, updates []snappy.Part, o io.Writer
 		fmt.Fprintln(w, fmt.Sprintf("%s%s\t%v\t%s\t", part.Name(), hasUpdate, formatDate(date), ver))
 	}
 }
+
+func showChangelogs(updates []snappy.Part, o io.Writer) {
+	changelogs := snappy.Changelogs(updates)
+	for _, part := range updates {
+		changelog, ok := changelogs[snappy.QualifiedName(part)]
+		if !ok {
+			continue
+		}
+		// TRANSLATORS: the first %s is a pkgname, the second a version
+		fmt.Fprintf(o, i18n.G("\nChanges in %s %s:\n"), part.Name(), part.Version())
+		fmt.Fprintln(o, strings.TrimRight(changelog, "\n"))
+	}
+}
@@ -24,7 +24,6 @@ import (
 
 	"github.com/ubuntu-core/snappy/i18n"
 	"github.com/ubuntu-core/snappy/logger"
-	"github.com/ubuntu-core/snappy/progress"
 	"github.com/ubuntu-core/snappy/snappy"
 )
 
@@ -62,9 +61,9 @@ func (x *cmdPurge) doPurge(args []string) error {
 
 	for _, part := range args {
 		// TRANSLATORS: the %s is a pkgname
-		fmt.Printf(i18n.G("Purging %s\n"), part)
+		fmt.Fprintf(humanOutput(), i18n.G("Purging %s\n"), part)
 
-		if err := snappy.Purge(part, flags, progress.MakeProgressBar()); err != nil {
+		if err := snappy.Purge(part, flags, newMeter("purge")); err != nil {
 			return err
 		}
 	}
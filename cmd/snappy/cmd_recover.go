@@ -0,0 +1,94 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package main
+
+import (
+	"fmt"
+
+	"github.com/ubuntu-core/snappy/i18n"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/snappy"
+)
+
+type cmdRecover struct {
+	args []string
+}
+
+var recoverHelp = i18n.G(`Repair a system whose install, remove or activation is broken
+
+These are low-level operations for the emergency shell (see the console
+command). They do what they can and log what they can not do; do not use
+them when the normal commands work.
+
+Operations:
+  force-remove <package.origin> <version>
+      remove the version, even if it is active or broken
+  force-activate <package.origin> <version>
+      make the version active, even if the active one is broken
+  rebuild-wrappers <package>
+      write the binary wrappers of the active version again
+  reset-security <package>
+      generate the security profiles of the active version again
+`)
+
+func init() {
+	_, err := parser.AddCommand("recover",
+		i18n.G("Repair a system whose install, remove or activation is broken"),
+		recoverHelp,
+		&cmdRecover{})
+	if err != nil {
+		logger.Panicf("Unable to recover: %v", err)
+	}
+}
+
+func (x *cmdRecover) Execute(args []string) error {
+	x.args = args
+	return withMutexAndRetry(x.doRecover)
+}
+
+func (x *cmdRecover) doRecover() error {
+	if len(x.args) == 0 {
+		return fmt.Errorf(i18n.G("recovery operation is required"))
+	}
+	op, args := x.args[0], x.args[1:]
+
+	switch op {
+	case "force-remove", "force-activate":
+		if len(args) != 2 {
+			// TRANSLATORS: the %s is the recovery operation
+			return fmt.Errorf(i18n.G("%s needs a package (with its origin) and a version"), op)
+		}
+		if op == "force-remove" {
+			return snappy.ForceRemove(args[0], args[1], newMeter("recover"))
+		}
+		return snappy.ForceActivate(args[0], args[1], newMeter("recover"))
+	case "rebuild-wrappers", "reset-security":
+		if len(args) != 1 {
+			// TRANSLATORS: the %s is the recovery operation
+			return fmt.Errorf(i18n.G("%s needs a package"), op)
+		}
+		if op == "rebuild-wrappers" {
+			return snappy.RebuildWrappers(args[0])
+		}
+		return snappy.ResetSecurity(args[0], newMeter("recover"))
+	}
+
+	// TRANSLATORS: the %s is what the user gave as recovery operation
+	return fmt.Errorf(i18n.G("unknown recovery operation %q"), op)
+}
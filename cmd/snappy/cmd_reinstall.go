@@ -0,0 +1,68 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/ubuntu-core/snappy/i18n"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/snappy"
+)
+
+type cmdReinstall struct {
+	Positional struct {
+		PackageName string `positional-arg-name:"package name"`
+	} `positional-args:"yes"`
+}
+
+var shortReinstallHelp = i18n.G("Reinstall the active version of a package")
+
+var longReinstallHelp = i18n.G("Gets the active version of a package again and installs it over the current one, regenerating its security profiles and services, to repair a broken install. The data of the package is kept.\n")
+
+func init() {
+	arg, err := parser.AddCommand("reinstall",
+		shortReinstallHelp,
+		longReinstallHelp,
+		&cmdReinstall{})
+	if err != nil {
+		logger.Panicf("Unable to reinstall: %v", err)
+	}
+	addOptionDescription(arg, "package name", i18n.G("The package to reinstall"))
+}
+
+func (x *cmdReinstall) Execute(args []string) (err error) {
+	return withMutexAndRetry(x.doReinstall)
+}
+
+func (x *cmdReinstall) doReinstall() error {
+	pkg := x.Positional.PackageName
+	if pkg == "" {
+		return errNeedPackageName
+	}
+
+	if err := snappy.Reinstall(pkg, newMeter("reinstall")); err != nil {
+		return err
+	}
+	// TRANSLATORS: the %s is a pkgname
+	fmt.Fprintf(humanOutput(), i18n.G("Reinstalled %s\n"), pkg)
+
+	return nil
+}
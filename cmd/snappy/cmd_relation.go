@@ -0,0 +1,130 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ubuntu-core/snappy/i18n"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/snappy"
+)
+
+type cmdRelation struct {
+	Withdraw bool `long:"withdraw"`
+	args     []string
+}
+
+var relationHelp = i18n.G(`Publish or show the data of a relation between packages
+
+With key=value pairs the package publishes them under the relation it
+provides; without, the data published for the relation the package
+requires is shown.
+
+Example:
+  relation postgres db user=app password=secret
+  relation webapp db
+`)
+
+func init() {
+	arg, err := parser.AddCommand("relation",
+		i18n.G("Publish or show the data of a relation between packages"),
+		relationHelp,
+		&cmdRelation{})
+	if err != nil {
+		logger.Panicf("Unable to relation: %v", err)
+	}
+	addOptionDescription(arg, "withdraw", i18n.G("Withdraw the data the package published under the relation"))
+}
+
+func (x *cmdRelation) Execute(args []string) error {
+	x.args = args
+	return withMutexAndRetry(x.doRelation)
+}
+
+func (x *cmdRelation) doRelation() error {
+	if len(x.args) < 2 {
+		return fmt.Errorf(i18n.G("package name and relation are required"))
+	}
+	pkgName, relation, pairs := x.args[0], x.args[1], x.args[2:]
+
+	if x.Withdraw {
+		return snappy.SetRelationData(pkgName, relation, nil)
+	}
+
+	if len(pairs) > 0 {
+		data, err := parseRelationPairs(pairs)
+		if err != nil {
+			return err
+		}
+		return snappy.SetRelationData(pkgName, relation, data)
+	}
+
+	data, err := snappy.RelationData(pkgName, relation)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 5, 3, 1, ' ', 0)
+	defer w.Flush()
+
+	fmt.Fprintln(w, i18n.G("Provider\tKey\tValue\t"))
+	for _, provider := range sortedKeys(data) {
+		values := data[provider]
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "%s\t%s\t%s\t\n", provider, k, values[k])
+		}
+	}
+
+	return nil
+}
+
+func sortedKeys(data map[string]map[string]string) []string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func parseRelationPairs(pairs []string) (map[string]string, error) {
+	data := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		l := strings.SplitN(pair, "=", 2)
+		if len(l) != 2 || l[0] == "" {
+			// TRANSLATORS: the %s is what the user gave instead of key=value
+			return nil, fmt.Errorf(i18n.G("expected key=value, got %q"), pair)
+		}
+		data[l[0]] = l[1]
+	}
+
+	return data, nil
+}
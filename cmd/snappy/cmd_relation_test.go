@@ -0,0 +1,35 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *CmdTestSuite) TestParseRelationPairs(c *C) {
+	data, err := parseRelationPairs([]string{"user=app", "password=a=b", "empty="})
+	c.Assert(err, IsNil)
+	c.Check(data, DeepEquals, map[string]string{"user": "app", "password": "a=b", "empty": ""})
+
+	_, err = parseRelationPairs([]string{"user"})
+	c.Check(err, ErrorMatches, `expected key=value, got "user"`)
+	_, err = parseRelationPairs([]string{"=app"})
+	c.Check(err, NotNil)
+}
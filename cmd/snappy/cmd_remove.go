@@ -24,12 +24,12 @@ import (
 
 	"github.com/ubuntu-core/snappy/i18n"
 	"github.com/ubuntu-core/snappy/logger"
-	"github.com/ubuntu-core/snappy/progress"
 	"github.com/ubuntu-core/snappy/snappy"
 )
 
 type cmdRemove struct {
 	DisableGC bool `long:"no-gc"`
+	Purge     bool `long:"purge"`
 }
 
 func init() {
@@ -41,6 +41,7 @@ func init() {
 		logger.Panicf("Unable to remove: %v", err)
 	}
 	addOptionDescription(arg, "no-gc", i18n.G("Do not clean up old versions of the package."))
+	addOptionDescription(arg, "purge", i18n.G("Remove all the data of the package too, for good."))
 }
 
 func (x *cmdRemove) Execute(args []string) (err error) {
@@ -54,12 +55,15 @@ func (x *cmdRemove) doRemove(args []string) error {
 	if x.DisableGC {
 		flags = 0
 	}
+	if x.Purge {
+		flags |= snappy.DoRemovePurge
+	}
 
 	for _, part := range args {
 		// TRANSLATORS: the %s is a pkgname
-		fmt.Printf(i18n.G("Removing %s\n"), part)
+		fmt.Fprintf(humanOutput(), i18n.G("Removing %s\n"), part)
 
-		if err := snappy.Remove(part, flags, progress.MakeProgressBar()); err != nil {
+		if err := snappy.Remove(part, flags, newMeter("remove")); err != nil {
 			return err
 		}
 	}
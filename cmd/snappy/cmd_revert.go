@@ -0,0 +1,78 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/ubuntu-core/snappy/i18n"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/snappy"
+)
+
+type cmdRevert struct {
+	Positional struct {
+		PackageName string `positional-arg-name:"package name"`
+	} `positional-args:"yes"`
+}
+
+var shortRevertHelp = i18n.G("Revert a package to the version active before")
+
+var longRevertHelp = i18n.G("Reverts a package to the version that was active before the current one, and marks the current one as bad so that updates do not bring it back. Rolling back to it explicitly clears the mark.\n")
+
+func init() {
+	arg, err := parser.AddCommand("revert",
+		shortRevertHelp,
+		longRevertHelp,
+		&cmdRevert{})
+	if err != nil {
+		logger.Panicf("Unable to revert: %v", err)
+	}
+	addOptionDescription(arg, "package name", i18n.G("The package to revert"))
+}
+
+func (x *cmdRevert) Execute(args []string) (err error) {
+	return withMutexAndRetry(x.doRevert)
+}
+
+func (x *cmdRevert) doRevert() error {
+	pkg := x.Positional.PackageName
+	if pkg == "" {
+		return errNeedPackageName
+	}
+
+	nowVersion, err := snappy.Revert(pkg, newMeter("revert"))
+	if err != nil {
+		return err
+	}
+	// TRANSLATORS: the first %s is a pkgname, the second %s is the new version
+	fmt.Fprintf(humanOutput(), i18n.G("Setting %s to version %s\n"), pkg, nowVersion)
+
+	m := snappy.NewMetaRepository()
+	installed, err := m.Installed()
+	if err != nil {
+		return err
+	}
+
+	parts := snappy.FindSnapsByNameAndVersion(pkg, nowVersion, installed)
+	showVerboseList(parts, humanOutput())
+
+	return nil
+}
@@ -21,11 +21,9 @@ package main
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/ubuntu-core/snappy/i18n"
 	"github.com/ubuntu-core/snappy/logger"
-	"github.com/ubuntu-core/snappy/progress"
 	"github.com/ubuntu-core/snappy/snappy"
 )
 
@@ -63,12 +61,12 @@ func (x *cmdRollback) doRollback() error {
 		return errNeedPackageName
 	}
 
-	nowVersion, err := snappy.Rollback(pkg, version, progress.MakeProgressBar())
+	nowVersion, err := snappy.Rollback(pkg, version, newMeter("rollback"))
 	if err != nil {
 		return err
 	}
 	// TRANSLATORS: the first %s is a pkgname, the second %s is the new version
-	fmt.Printf(i18n.G("Setting %s to version %s\n"), pkg, nowVersion)
+	fmt.Fprintf(humanOutput(), i18n.G("Setting %s to version %s\n"), pkg, nowVersion)
 
 	m := snappy.NewMetaRepository()
 	installed, err := m.Installed()
@@ -77,7 +75,7 @@ func (x *cmdRollback) doRollback() error {
 	}
 
 	parts := snappy.FindSnapsByNameAndVersion(pkg, nowVersion, installed)
-	showVerboseList(parts, os.Stdout)
+	showVerboseList(parts, humanOutput())
 
 	return nil
 }
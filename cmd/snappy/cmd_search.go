@@ -22,6 +22,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
 	"text/tabwriter"
 
 	"github.com/ubuntu-core/snappy/i18n"
@@ -31,7 +32,16 @@ import (
 )
 
 type cmdSearch struct {
-	ShowAll bool `long:"show-all"`
+	ShowAll bool   `long:"show-all"`
+	Sort    string `long:"sort"`
+}
+
+var searchSorts = map[string]snappy.SearchSort{
+	"":          snappy.SortByRelevance,
+	"relevance": snappy.SortByRelevance,
+	"updated":   snappy.SortByLastUpdated,
+	"rating":    snappy.SortByRating,
+	"name":      snappy.SortByName,
 }
 
 func init() {
@@ -45,14 +55,23 @@ func init() {
 
 	cmd.Aliases = append(cmd.Aliases, "se")
 	addOptionDescription(cmd, "show-all", i18n.G("Show all available forks of a package"))
+	addOptionDescription(cmd, "sort", i18n.G("Order of the results (relevance, updated, rating or name)"))
 }
 
 func (x *cmdSearch) Execute(args []string) (err error) {
-	return search(args, x.ShowAll)
+	sort, ok := searchSorts[x.Sort]
+	if !ok {
+		return fmt.Errorf(i18n.G("unknown search order: %q"), x.Sort)
+	}
+
+	return search(args, x.ShowAll, sort)
 }
 
-func search(args []string, allVariants bool) error {
-	results, err := snappy.Search(args)
+func search(args []string, allVariants bool, sort snappy.SearchSort) error {
+	results, err := snappy.SearchWithOptions(snappy.SearchOptions{
+		Query: strings.Join(args, ","),
+		Sort:  sort,
+	})
 	if err != nil {
 		return err
 	}
@@ -62,7 +81,8 @@ func search(args []string, allVariants bool) error {
 
 	forkHelp := false
 	fmt.Fprintln(w, i18n.G("Name\tVersion\tSummary\t"))
-	for _, sharedName := range results {
+	for _, name := range results.Names() {
+		sharedName := results[name]
 		if part := sharedName.Alias; !allVariants && part != nil {
 			if len(sharedName.Parts) > 1 {
 				n := len(sharedName.Parts) - 1
@@ -21,13 +21,11 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"text/tabwriter"
 
 	"github.com/ubuntu-core/snappy/helpers"
 	"github.com/ubuntu-core/snappy/i18n"
 	"github.com/ubuntu-core/snappy/logger"
-	"github.com/ubuntu-core/snappy/progress"
 	"github.com/ubuntu-core/snappy/snappy"
 )
 
@@ -79,7 +77,7 @@ const (
 )
 
 func (s *svcBase) doExecute(cmd int) ([]string, error) {
-	actor, err := snappy.FindServices(s.Args.Snap, s.Args.Service, progress.MakeProgressBar())
+	actor, err := snappy.FindServices(s.Args.Snap, s.Args.Service, newMeter("service"))
 	if err != nil {
 		return nil, err
 	}
@@ -110,7 +108,7 @@ func (s *svcStatus) Execute(args []string) error {
 		return err
 	}
 
-	w := tabwriter.NewWriter(os.Stdout, 0, 8, 1, '\t', 0)
+	w := tabwriter.NewWriter(humanOutput(), 0, 8, 1, '\t', 0)
 
 	ws, _ := helpers.GetTermWinsize()
 	rows := int(ws.Row) - 2
@@ -142,7 +140,7 @@ func (s *svcLogs) Execute([]string) error {
 		}
 
 		for i := range logs {
-			fmt.Println(logs[i])
+			fmt.Fprintln(humanOutput(), logs[i])
 		}
 
 		return nil
@@ -25,7 +25,6 @@ import (
 
 	"github.com/ubuntu-core/snappy/i18n"
 	"github.com/ubuntu-core/snappy/logger"
-	"github.com/ubuntu-core/snappy/progress"
 	"github.com/ubuntu-core/snappy/snappy"
 )
 
@@ -63,7 +62,7 @@ func (x *cmdSet) doSet() (err error) {
 		return err
 	}
 
-	return snappy.SetProperty(pkgname, progress.MakeProgressBar(), args...)
+	return snappy.SetProperty(pkgname, newMeter("set"), args...)
 }
 
 func parseSetPropertyCmdline(args ...string) (pkgname string, out []string, err error) {
@@ -0,0 +1,101 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/ubuntu-core/snappy/i18n"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/snappy"
+)
+
+type cmdSnapshot struct {
+	List       bool   `long:"list"`
+	Restore    string `long:"restore"`
+	Positional struct {
+		PackageName string `positional-arg-name:"package name"`
+	} `positional-args:"yes"`
+}
+
+var shortSnapshotHelp = i18n.G("Take a snapshot of the data of a package")
+
+var longSnapshotHelp = i18n.G("Archives the data of the active version of a package (that of the system and of the users), e.g. before a risky update, so that it can be restored later with --restore. With --list, list the snapshots (of the package, if given).\n")
+
+func init() {
+	arg, err := parser.AddCommand("snapshot",
+		shortSnapshotHelp,
+		longSnapshotHelp,
+		&cmdSnapshot{})
+	if err != nil {
+		logger.Panicf("Unable to snapshot: %v", err)
+	}
+	addOptionDescription(arg, "list", i18n.G("List the snapshots"))
+	addOptionDescription(arg, "restore", i18n.G("Restore the data of the package from the snapshot with the given id"))
+	addOptionDescription(arg, "package name", i18n.G("The package to take a snapshot of"))
+}
+
+func (x *cmdSnapshot) Execute(args []string) error {
+	pkg := x.Positional.PackageName
+
+	if x.List {
+		snapshots, err := snappy.Snapshots(pkg)
+		if err != nil {
+			return err
+		}
+		showSnapshots(snapshots, humanOutput())
+		return nil
+	}
+
+	if pkg == "" {
+		return errNeedPackageName
+	}
+
+	return withMutexAndRetry(func() error {
+		if x.Restore != "" {
+			if err := snappy.RestoreSnapshot(pkg, x.Restore, newMeter("restore")); err != nil {
+				return err
+			}
+			// TRANSLATORS: the first %s is a pkgname, the second %s is a snapshot id
+			fmt.Fprintf(humanOutput(), i18n.G("Restored the data of %s from %s\n"), pkg, x.Restore)
+			return nil
+		}
+
+		snapshot, err := snappy.Snapshot(pkg)
+		if err != nil {
+			return err
+		}
+		// TRANSLATORS: the first %s is a pkgname, the second %s is a snapshot id
+		fmt.Fprintf(humanOutput(), i18n.G("Took a snapshot of the data of %s: %s\n"), pkg, snapshot.ID)
+		return nil
+	})
+}
+
+func showSnapshots(snapshots []*snappy.SnapshotInfo, o io.Writer) {
+	w := tabwriter.NewWriter(o, 5, 3, 1, ' ', 0)
+
+	fmt.Fprintln(w, i18n.G("Id\tName\tVersion\tDate\t"))
+	for _, snapshot := range snapshots {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t\n", snapshot.ID, snapshot.Name, snapshot.Version, formatDate(snapshot.TakenAt()))
+	}
+	w.Flush()
+}
@@ -21,19 +21,31 @@ package main
 
 import (
 	"fmt"
-	"os"
 	"os/exec"
 	"strings"
 
 	"github.com/ubuntu-core/snappy/i18n"
 	"github.com/ubuntu-core/snappy/logger"
-	"github.com/ubuntu-core/snappy/progress"
 	"github.com/ubuntu-core/snappy/snappy"
 )
 
 type cmdUpdate struct {
-	DisableGC  bool `long:"no-gc"`
-	AutoReboot bool `long:"automatic-reboot"`
+	DisableGC   bool   `long:"no-gc"`
+	AutoReboot  bool   `long:"automatic-reboot"`
+	Unpublished string `long:"unpublished"`
+	Downloads   int    `long:"parallel-downloads"`
+	Channel     string `long:"channel"`
+	FromDir     string `long:"from-dir"`
+	Auto        bool   `long:"auto"`
+	Schedule    string `long:"schedule"`
+	DryRun      bool   `long:"dry-run"`
+}
+
+var unpublishedPolicies = map[string]snappy.UnpublishedPolicy{
+	"":       snappy.UnpublishedWarn,
+	"warn":   snappy.UnpublishedWarn,
+	"keep":   snappy.UnpublishedKeep,
+	"remove": snappy.UnpublishedRemove,
 }
 
 func init() {
@@ -46,6 +58,13 @@ func init() {
 	}
 	addOptionDescription(arg, "no-gc", i18n.G("Do not clean up old versions of the package."))
 	addOptionDescription(arg, "automatic-reboot", i18n.G("Reboot if necessary to be on the latest running system."))
+	addOptionDescription(arg, "unpublished", i18n.G("What to do with the packages that were unpublished from the store (warn, keep or remove)."))
+	addOptionDescription(arg, "parallel-downloads", i18n.G("Download this many packages at once before installing them."))
+	addOptionDescription(arg, "channel", i18n.G("Update all the packages from the given channel (and from it thereafter)."))
+	addOptionDescription(arg, "from-dir", i18n.G("Update from the given directory of snaps (and their manifests) instead of the store."))
+	addOptionDescription(arg, "auto", i18n.G("Run as the automatic refresh: only within its schedule, recording the outcome."))
+	addOptionDescription(arg, "schedule", i18n.G("Set the windows the automatic refresh runs in (e.g. 02:00-04:00,13:00-14:00), or off."))
+	addOptionDescription(arg, "dry-run", i18n.G("Only show what would be downloaded and restarted, without changing anything."))
 }
 
 const (
@@ -60,21 +79,63 @@ func (x *cmdUpdate) Execute(args []string) (err error) {
 }
 
 func (x *cmdUpdate) doUpdate() error {
+	if x.Schedule != "" {
+		schedule := x.Schedule
+		if schedule == "off" {
+			schedule = ""
+		}
+		return snappy.SetAutoRefreshSchedule(schedule, newMeter("update"))
+	}
+
+	if x.Auto {
+		if _, err := snappy.RunAutoRefresh(newMeter("update")); err != nil {
+			return err
+		}
+		return x.autoReboot()
+	}
+
 	// FIXME: handle (more?) args
 	flags := snappy.DoInstallGC
 	if x.DisableGC {
 		flags = 0
 	}
 
-	updates, err := snappy.Update(flags, progress.MakeProgressBar())
+	unpublished, ok := unpublishedPolicies[x.Unpublished]
+	if !ok {
+		return fmt.Errorf(i18n.G("unknown policy for the unpublished packages: %q"), x.Unpublished)
+	}
+
+	opts := snappy.InstallOptions{
+		Flags:           flags,
+		Meter:           newMeter("update"),
+		Unpublished:     unpublished,
+		DownloadWorkers: x.Downloads,
+		Channel:         x.Channel,
+		SnapDir:         x.FromDir,
+	}
+	if x.DryRun {
+		plan, err := snappy.PlanUpdate(opts)
+		if err != nil {
+			return err
+		}
+		showPlan(plan, humanOutput())
+		return nil
+	}
+
+	updates, err := snappy.UpdateWithOptions(opts)
 	if err != nil {
 		return err
 	}
 
 	if len(updates) > 0 {
-		showVerboseList(updates, os.Stdout)
+		showVerboseList(updates, humanOutput())
 	}
 
+	return x.autoReboot()
+}
+
+// autoReboot reboots into the updated system if asked to and needed
+func (x *cmdUpdate) autoReboot() error {
 	if x.AutoReboot {
 		installed, err := snappy.ListInstalled()
 		if err != nil {
@@ -91,7 +152,7 @@ func (x *cmdUpdate) doUpdate() error {
 		if len(rebootTriggers) != 0 {
 			// TRANSLATORS: the %s shows a comma separated list
 			//              of package names
-			fmt.Printf(i18n.G("Rebooting to satisfy updates for %s\n"), strings.Join(rebootTriggers, ", "))
+			fmt.Fprintf(humanOutput(), i18n.G("Rebooting to satisfy updates for %s\n"), strings.Join(rebootTriggers, ", "))
 			cmd := exec.Command(shutdownCmd, shutdownTimeout, "-r", shutdownMsg)
 			if out, err := cmd.CombinedOutput(); err != nil {
 				return fmt.Errorf("failed to auto reboot: %s", out)
@@ -21,6 +21,8 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"strings"
 	"time"
@@ -28,6 +30,8 @@ import (
 	"github.com/ubuntu-core/snappy/i18n"
 	"github.com/ubuntu-core/snappy/logger"
 	"github.com/ubuntu-core/snappy/priv"
+	"github.com/ubuntu-core/snappy/progress"
+	"github.com/ubuntu-core/snappy/snappy"
 
 	"github.com/jessevdk/go-flags"
 )
@@ -48,7 +52,11 @@ func isAutoPilotRunning() bool {
 // automatic re-try and helpful messages if the lock is already taken
 func withMutexAndRetry(f func() error) error {
 	for {
-		err := priv.WithMutex(snappyLockFile, f)
+		// the system lock of snappy is also taken by whatever else
+		// changes the installed snaps
+		err := priv.WithMutex(snappyLockFile, func() error {
+			return snappy.WithSystemLock(f)
+		})
 		// if already locked, auto-retry
 		if err == priv.ErrAlreadyLocked {
 			var msg string
@@ -98,3 +106,43 @@ func addOptionDescription(arg *flags.Command, longName, description string) {
 
 	logger.Panicf("can not set option description for %#v", longName)
 }
+
+// outputFormat is the format of the progress of operations, set
+// with the global --output option
+type outputFormat string
+
+const outputJSON outputFormat = "json"
+
+// UnmarshalFlag makes sure the output format is one we know
+func (o *outputFormat) UnmarshalFlag(value string) error {
+	if outputFormat(value) != outputJSON {
+		return fmt.Errorf(i18n.G("unknown output format %q (only %q is supported)"), value, outputJSON)
+	}
+	*o = outputFormat(value)
+
+	return nil
+}
+
+// newMeter returns the meter to show the progress of the operation op:
+// a progress bar, or a stream of JSON progress events on stdout if so
+// asked for with --output=json (reading the answers to its agreement
+// events from stdin)
+func newMeter(op string) progress.Meter {
+	if optionsData.Output == outputJSON {
+		meter := progress.NewJSONProgress(os.Stdout, op)
+		meter.SetInput(os.Stdin)
+		return meter
+	}
+
+	return progress.MakeProgressBar()
+}
+
+// humanOutput is where the text for humans goes: stdout, or stderr
+// when stdout is the stream of JSON progress events
+func humanOutput() io.Writer {
+	if optionsData.Output == outputJSON {
+		return os.Stderr
+	}
+
+	return os.Stdout
+}
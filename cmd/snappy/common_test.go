@@ -20,12 +20,14 @@
 package main
 
 import (
+	"os"
 	"testing"
 
 	"github.com/jessevdk/go-flags"
 	. "gopkg.in/check.v1"
 
 	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/progress"
 )
 
 // Hook up check.v1 into the "go test" runner
@@ -79,3 +81,30 @@ func (s *CmdTestSuite) TestAddOptionDescriptionOrPanicWillPanic(c *C) {
 	}
 	c.Assert(f, PanicMatches, "can not set option description for \"package name\"")
 }
+
+func (s *CmdTestSuite) TestOutputFormat(c *C) {
+	var o outputFormat
+	c.Check(o.UnmarshalFlag("json"), IsNil)
+	c.Check(o, Equals, outputJSON)
+	c.Check(o.UnmarshalFlag("xml"), ErrorMatches, `unknown output format "xml".*`)
+	c.Check(o, Equals, outputJSON)
+}
+
+func (s *CmdTestSuite) TestNewMeter(c *C) {
+	defer func() { optionsData.Output = "" }()
+
+	c.Check(newMeter("install"), Not(FitsTypeOf), &progress.JSONProgress{})
+
+	optionsData.Output = outputJSON
+	c.Check(newMeter("install"), FitsTypeOf, &progress.JSONProgress{})
+}
+
+func (s *CmdTestSuite) TestHumanOutput(c *C) {
+	defer func() { optionsData.Output = "" }()
+
+	c.Check(humanOutput(), Equals, os.Stdout)
+
+	// stdout is the JSON stream
+	optionsData.Output = outputJSON
+	c.Check(humanOutput(), Equals, os.Stderr)
+}
@@ -23,15 +23,17 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/ubuntu-core/snappy/i18n"
 	"github.com/ubuntu-core/snappy/logger"
 	"github.com/ubuntu-core/snappy/priv"
+	"github.com/ubuntu-core/snappy/progress"
 	"github.com/ubuntu-core/snappy/snappy"
 
 	"github.com/jessevdk/go-flags"
 )
 
 type options struct {
-	// No global options yet
+	Output outputFormat `long:"output" description:"Show the progress of operations as JSON lines (use --output=json)"`
 }
 
 var optionsData options
@@ -43,6 +45,8 @@ func init() {
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "WARNING: failed to activate logging: %s\n", err)
 	}
+
+	progress.Translate = i18n.G
 }
 
 func main() {
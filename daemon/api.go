@@ -53,6 +53,7 @@ var api = []*Command{
 	packageSvcsCmd,
 	packageSvcLogsCmd,
 	operationCmd,
+	operationProgressCmd,
 }
 
 var (
@@ -117,6 +118,11 @@ var (
 		GET:    getOpInfo,
 		DELETE: deleteOp,
 	}
+
+	operationProgressCmd = &Command{
+		Path: "/1.0/operations/{uuid}/progress",
+		GET:  getOpProgress,
+	}
 )
 
 func v1Get(c *Command, r *http.Request) Response {
@@ -521,6 +527,16 @@ func getOpInfo(c *Command, r *http.Request) Response {
 	return SyncResponse(task.Map(route))
 }
 
+func getOpProgress(c *Command, r *http.Request) Response {
+	id := muxVars(r)["uuid"]
+	task := c.d.GetTask(id)
+	if task == nil || task.progress == nil {
+		return NotFound
+	}
+
+	return progressResponse{task: task}
+}
+
 func deleteOp(c *Command, r *http.Request) Response {
 	id := muxVars(r)["uuid"]
 	err := c.d.DeleteTask(id)
@@ -576,6 +592,9 @@ func (inst *packageInstruction) update() interface{} {
 
 	for _, part := range parts {
 		if snappy.QualifiedName(part) == inst.pkg {
+			if snappy.IsUnpublished(part) {
+				return "package is unpublished"
+			}
 			if _, err := part.Install(inst.prog, flags); err != nil {
 				return err
 			}
@@ -654,23 +673,21 @@ func postPackage(c *Command, r *http.Request) Response {
 
 	vars := muxVars(r)
 	inst.pkg = vars["name"] + "." + vars["origin"]
-	inst.prog = &progress.NullProgress{}
 
 	f := pkgActionDispatch(&inst)
 	if f == nil {
 		return BadRequest(nil, "unknown action %s", inst.Action)
 	}
 
-	return AsyncResponse(c.d.AddTask(f).Map(route))
+	return AsyncResponse(c.d.AddTaskWithMeter(func(meter progress.Meter) interface{} {
+		inst.prog = meter
+		return f()
+	}).Map(route))
 }
 
 const maxReadBuflen = 1024 * 1024
 
-func newSnapImpl(filename string, origin string, unsignedOk bool) (snappy.Part, error) {
-	return snappy.NewSnapPartFromSnapFile(filename, origin, unsignedOk)
-}
-
-var newSnap = newSnapImpl
+var sideload = snappy.Sideload
 
 func sideloadPackage(c *Command, r *http.Request) Response {
 	route := c.d.router.Get(operationCmd.Path)
@@ -732,15 +749,10 @@ func sideloadPackage(c *Command, r *http.Request) Response {
 		return InternalError(err, "can't copy request into tempfile: %v", err)
 	}
 
-	return AsyncResponse(c.d.AddTask(func() interface{} {
+	return AsyncResponse(c.d.AddTaskWithMeter(func(meter progress.Meter) interface{} {
 		defer os.Remove(tmpf.Name())
 
-		part, err := newSnap(tmpf.Name(), snappy.SideloadedOrigin, unsignedOk)
-		if err != nil {
-			return err
-		}
-
-		name, err := part.Install(&progress.NullProgress{}, 0)
+		name, err := sideload(tmpf.Name(), snappy.SideloadOptions{AllowUnauthenticated: unsignedOk}, meter)
 		if err != nil {
 			return err
 		}
@@ -276,8 +276,8 @@ func (s *apiSuite) TestListIncludesAll(c *check.C) {
 		"muxVars",
 		"newRemoteRepo",
 		"newSystemRepo",
-		"newSnap",
 		"pkgActionDispatch",
+		"sideload",
 	}
 	c.Check(found, check.Equals, len(api)+len(exceptions),
 		check.Commentf(`At a glance it looks like you've not added all the Commands defined in api to the api list. If that is not the case, please add the exception to the "exceptions" list in this test.`))
@@ -487,6 +487,53 @@ func (s *apiSuite) TestGetOpInfoIntegration(c *check.C) {
 	c.Check(tf1 < tf2, check.Equals, true)
 }
 
+func (s *apiSuite) TestGetOpProgressNotFound(c *check.C) {
+	newTestDaemon()
+
+	s.vars = map[string]string{"uuid": "42"}
+	rsp := getOpProgress(operationProgressCmd, nil).Self(nil, nil).(*resp)
+	c.Check(rsp.Type, check.Equals, ResponseTypeError)
+	c.Check(rsp.Status, check.Equals, http.StatusNotFound)
+}
+
+func (s *apiSuite) TestGetOpProgress(c *check.C) {
+	d := newTestDaemon()
+
+	ch := make(chan struct{})
+	t := d.AddTaskWithMeter(func(meter progress.Meter) interface{} {
+		meter.Start("foo", 2)
+		<-ch
+		meter.Set(2)
+		meter.Notify("done")
+		return nil
+	})
+
+	s.vars = map[string]string{"uuid": t.UUID()}
+	rsp := getOpProgress(operationProgressCmd, nil)
+	c.Assert(rsp, check.FitsTypeOf, progressResponse{})
+
+	rec := httptest.NewRecorder()
+	go func() {
+		time.Sleep(time.Millisecond)
+		close(ch)
+	}()
+	// returns once the task is done
+	rsp.ServeHTTP(rec, nil)
+
+	c.Check(rec.Code, check.Equals, http.StatusOK)
+	c.Check(rec.HeaderMap.Get("Content-Type"), check.Equals, "application/x-json-stream")
+
+	var events []progress.Event
+	c.Assert(progress.ReadEvents(rec.Body, func(ev *progress.Event) {
+		events = append(events, *ev)
+	}), check.IsNil)
+	c.Check(events, check.DeepEquals, []progress.Event{
+		{Op: t.UUID(), Phase: progress.PhaseStart, Snap: "foo"},
+		{Op: t.UUID(), Phase: progress.PhaseProgress, Snap: "foo", Percent: 100},
+		{Op: t.UUID(), Phase: progress.PhaseNotify, Snap: "foo", Message: "done"},
+	})
+}
+
 func (s *apiSuite) TestPostPackageBadRequest(c *check.C) {
 	s.vars = map[string]string{"uuid": "42"}
 	rsp := getOpInfo(operationCmd, nil).Self(nil, nil).(*resp)
@@ -889,9 +936,8 @@ func (s *apiSuite) sideloadCheck(c *check.C, content string, unsignedExpected bo
 
 	// setup done
 
-	newSnap = func(fn string, origin string, unauthOk bool) (snappy.Part, error) {
-		c.Check(origin, check.Equals, snappy.SideloadedOrigin)
-		c.Check(unauthOk, check.Equals, unsignedExpected)
+	sideload = func(fn string, opts snappy.SideloadOptions, meter progress.Meter) (string, error) {
+		c.Check(opts.AllowUnauthenticated, check.Equals, unsignedExpected)
 
 		bs, err := ioutil.ReadFile(fn)
 		c.Check(err, check.IsNil)
@@ -899,9 +945,9 @@ func (s *apiSuite) sideloadCheck(c *check.C, content string, unsignedExpected bo
 
 		ch <- struct{}{}
 
-		return &tP{}, nil
+		return "foo", nil
 	}
-	defer func() { newSnap = newSnapImpl }()
+	defer func() { sideload = snappy.Sideload }()
 
 	req, err := http.NewRequest("POST", "/1.0/packages", tmpfile)
 	c.Assert(err, check.IsNil)
@@ -32,6 +32,7 @@ import (
 	"gopkg.in/tomb.v2"
 
 	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/progress"
 )
 
 // A Daemon listens for requests and routes them to the right command
@@ -163,7 +164,16 @@ func (d *Daemon) Dying() <-chan struct{} {
 
 // AddTask runs the given function as a task
 func (d *Daemon) AddTask(f func() interface{}) *Task {
-	t := RunTask(f)
+	return d.addTask(RunTask(f))
+}
+
+// AddTaskWithMeter runs the given function as a task, with a meter
+// whose progress can be followed via the operation's progress resource
+func (d *Daemon) AddTaskWithMeter(f func(progress.Meter) interface{}) *Task {
+	return d.addTask(RunTaskWithMeter(f))
+}
+
+func (d *Daemon) addTask(t *Task) *Task {
 	d.Lock()
 	defer d.Unlock()
 	d.tasks[t.UUID()] = t
@@ -163,6 +163,60 @@ func (f FileResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, string(f))
 }
 
+// A progressResponse's ServeHTTP method streams the JSON progress of a
+// task, as it happens, until the task is done
+type progressResponse struct {
+	task *Task
+}
+
+// Self from the Response interface
+func (p progressResponse) Self(*Command, *http.Request) Response { return p }
+
+// ServeHTTP from the Response interface
+func (p progressResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var gone <-chan bool
+	if cn, ok := w.(http.CloseNotifier); ok {
+		gone = cn.CloseNotify()
+	}
+
+	w.Header().Set("Content-Type", "application/x-json-stream")
+	w.WriteHeader(http.StatusOK)
+
+	offset := 0
+	send := func() (<-chan struct{}, error) {
+		bs, changed := p.task.progress.since(offset)
+		if len(bs) == 0 {
+			return changed, nil
+		}
+		offset += len(bs)
+		if _, err := w.Write(bs); err != nil {
+			return nil, err
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+
+		return changed, nil
+	}
+
+	for {
+		changed, err := send()
+		if err != nil {
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-p.task.tomb.Dead():
+			// whatever was written before the task died
+			send()
+			return
+		case <-gone:
+			return
+		}
+	}
+}
+
 // ErrorResponseFunc is a callable error Response.
 // So you can return e.g. InternalError, or InternalError(err, "something broke"), etc.
 type ErrorResponseFunc func(error, string, ...interface{}) Response
@@ -20,20 +20,63 @@
 package daemon
 
 import (
+	"bytes"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
 	"gopkg.in/tomb.v2"
+
+	"github.com/ubuntu-core/snappy/progress"
 )
 
 // A Task encapsulates an asynchronous operation.
 type Task struct {
-	id     UUID
-	tomb   tomb.Tomb
-	t0     time.Time
-	tf     time.Time
-	output interface{}
+	id       UUID
+	tomb     tomb.Tomb
+	t0       time.Time
+	tf       time.Time
+	output   interface{}
+	progress *progressLog
+}
+
+// progressLog keeps the JSON progress stream of a task, for clients to
+// follow as it grows
+type progressLog struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	// changed is closed (and replaced) on every write
+	changed chan struct{}
+}
+
+func newProgressLog() *progressLog {
+	return &progressLog{changed: make(chan struct{})}
+}
+
+func (l *progressLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n, err := l.buf.Write(p)
+	close(l.changed)
+	l.changed = make(chan struct{})
+
+	return n, err
+}
+
+// since returns what was written to the log after the first offset
+// bytes, and a channel that is closed when more is written
+func (l *progressLog) since(offset int) ([]byte, <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var bs []byte
+	if offset < l.buf.Len() {
+		bs = append(bs, l.buf.Bytes()[offset:]...)
+	}
+
+	return bs, l.changed
 }
 
 // A task can be in one of three states
@@ -115,19 +158,30 @@ func (t *Task) Map(route *mux.Route) map[string]interface{} {
 
 // RunTask creates a Task for the given function and runs it.
 func RunTask(f func() interface{}) *Task {
+	return RunTaskWithMeter(func(progress.Meter) interface{} {
+		return f()
+	})
+}
+
+// RunTaskWithMeter creates a Task for the given function and runs it,
+// passing it a meter that records the progress of the task as a JSON
+// progress stream (see progress.JSONProgress).
+func RunTaskWithMeter(f func(progress.Meter) interface{}) *Task {
 	id := UUID4()
 	t0 := time.Now()
 	t := &Task{
-		id: id,
-		t0: t0,
-		tf: t0,
+		id:       id,
+		t0:       t0,
+		tf:       t0,
+		progress: newProgressLog(),
 	}
+	meter := progress.NewJSONProgress(t.progress, id.String())
 
 	t.tomb.Go(func() error {
 		defer func() {
 			t.tf = time.Now()
 		}()
-		out := f()
+		out := f(meter)
 		t.output = out
 
 		if err, ok := out.(error); ok {
@@ -25,6 +25,8 @@ import (
 
 	"github.com/gorilla/mux"
 	"gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/progress"
 )
 
 type taskSuite struct{}
@@ -84,3 +86,23 @@ func (s *taskSuite) TestFails(c *check.C) {
 		Str: err.Error(),
 	})
 }
+
+func (s *taskSuite) TestTaskWithMeter(c *check.C) {
+	t := RunTaskWithMeter(func(meter progress.Meter) interface{} {
+		meter.Start("foo", 10)
+		meter.Set(5)
+		meter.Finished()
+		return nil
+	})
+
+	c.Assert(t.tomb.Wait(), check.IsNil)
+
+	bs, _ := t.progress.since(0)
+	c.Check(string(bs), check.Equals, `{"op":"`+t.UUID()+`","phase":"start","snap":"foo","percent":0}
+{"op":"`+t.UUID()+`","phase":"progress","snap":"foo","percent":50}
+{"op":"`+t.UUID()+`","phase":"finished","snap":"foo","percent":100}
+`)
+
+	more, _ := t.progress.since(len(bs))
+	c.Check(more, check.HasLen, 0)
+}
@@ -30,15 +30,32 @@ var (
 	SnapDataDir      string
 	SnapDataHomeGlob string
 	SnapAppArmorDir  string
+	SnapProfilesDir  string
 	SnapSeccompDir   string
 	SnapUdevRulesDir string
 	LocaleDir        string
 	SnapIconsDir     string
 	SnapMetaDir      string
+	SnapTrashDir     string
+	SnapSnapshotsDir string
+	SnapSELinuxDir   string
+	SnapLockFile     string
+	SnapSystemLock   string
+	SnapDownloadsDir string
+	SnapCacheDir     string
 
-	SnapBinariesDir  string
-	SnapServicesDir  string
-	SnapBusPolicyDir string
+	SnapExportedDataDir string
+	SnapRelationsDir    string
+	SnapStoreCacheDir   string
+
+	SnapAppArmorCacheDir string
+
+	SnapBinariesDir         string
+	SnapExportedBinariesDir string
+	SnapServicesDir         string
+	SnapBusPolicyDir        string
+	SnapJournaldConfDir     string
+	SnapStoreConfigFile     string
 
 	ClickSystemHooksDir string
 	CloudMetaDataFile   string
@@ -56,13 +73,28 @@ func SetRootDir(rootdir string) {
 	SnapDataDir = filepath.Join(rootdir, "/var/lib/apps")
 	SnapDataHomeGlob = filepath.Join(rootdir, "/home/*/apps/")
 	SnapAppArmorDir = filepath.Join(rootdir, "/var/lib/apparmor/clicks")
+	SnapProfilesDir = filepath.Join(rootdir, "/var/lib/apparmor/profiles")
+	SnapAppArmorCacheDir = filepath.Join(rootdir, "/var/cache/apparmor")
 	SnapSeccompDir = filepath.Join(rootdir, SnappyDir, "seccomp", "profiles")
 	SnapIconsDir = filepath.Join(rootdir, SnappyDir, "icons")
 	SnapMetaDir = filepath.Join(rootdir, SnappyDir, "meta")
+	SnapTrashDir = filepath.Join(rootdir, SnappyDir, "trash")
+	SnapSnapshotsDir = filepath.Join(rootdir, SnappyDir, "snapshots")
+	SnapSELinuxDir = filepath.Join(rootdir, SnappyDir, "selinux")
+	SnapLockFile = filepath.Join(rootdir, SnappyDir, "snaps.lock")
+	SnapSystemLock = filepath.Join(rootdir, SnappyDir, "system.lock")
+	SnapDownloadsDir = filepath.Join(rootdir, SnappyDir, "downloads")
+	SnapCacheDir = filepath.Join(rootdir, SnappyDir, "cache")
+	SnapExportedDataDir = filepath.Join(rootdir, SnappyDir, "exported-data")
+	SnapRelationsDir = filepath.Join(rootdir, SnappyDir, "relations")
+	SnapStoreCacheDir = filepath.Join(rootdir, SnappyDir, "store-cache")
 
 	SnapBinariesDir = filepath.Join(SnapAppsDir, "bin")
+	SnapExportedBinariesDir = filepath.Join(SnapAppsDir, "exported")
 	SnapServicesDir = filepath.Join(rootdir, "/etc/systemd/system")
 	SnapBusPolicyDir = filepath.Join(rootdir, "/etc/dbus-1/system.d")
+	SnapJournaldConfDir = filepath.Join(rootdir, "/etc/systemd")
+	SnapStoreConfigFile = filepath.Join(rootdir, "/etc/snappy/store.yaml")
 
 	ClickSystemHooksDir = filepath.Join(rootdir, "/usr/share/click/hooks")
 
@@ -114,13 +114,31 @@ type UnpackTarTransformFunc func(path string) (newPath string, err error)
 
 var mknod = syscall.Mknod
 
+// UnpackTarProgressFunc is told the number of entries and bytes written
+// so far during unpack
+type UnpackTarProgressFunc func(entries int, written int64)
+
 // UnpackTar unpacks the given tar file into the target directory
 func UnpackTar(r io.Reader, targetDir string, fn UnpackTarTransformFunc) error {
+	return UnpackTarWithProgress(r, targetDir, fn, nil)
+}
+
+// UnpackTarWithProgress is UnpackTar, calling progress (if not nil)
+// after each entry is written
+func UnpackTarWithProgress(r io.Reader, targetDir string, fn UnpackTarTransformFunc, progress UnpackTarProgressFunc) error {
 	// ensure we we extract with the original permissions
 	oldUmask := syscall.Umask(0)
 	defer syscall.Umask(oldUmask)
 
+	var entries int
+	var written int64
+
 	return TarIterate(r, func(tr *tar.Reader, hdr *tar.Header) (err error) {
+		entries++
+		if progress != nil {
+			defer func() { progress(entries, written) }()
+		}
+
 		// run tar transform func
 		name := hdr.Name
 		if fn != nil {
@@ -164,7 +182,8 @@ func UnpackTar(r io.Reader, targetDir string, fn UnpackTarTransformFunc) error {
 				return err
 			}
 			defer out.Close()
-			_, err = io.Copy(out, tr)
+			n, err := io.Copy(out, tr)
+			written += n
 			if err != nil {
 				return err
 			}
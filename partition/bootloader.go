@@ -70,6 +70,9 @@ type bootLoader interface {
 	// Return the value of the specified bootloader variable
 	GetBootVar(name string) (string, error)
 
+	// Set the specified bootloader variable to the given value
+	SetBootVar(name, value string) error
+
 	// Return the 1-character name corresponding to the
 	// rootfs that will be used on _next_ boot.
 	//
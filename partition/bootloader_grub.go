@@ -111,6 +111,10 @@ func (g *grub) setBootVar(name, value string) (err error) {
 	return runCommand(bootloaderGrubEnvCmd, bootloaderGrubEnvFile, "set", arg)
 }
 
+func (g *grub) SetBootVar(name, value string) error {
+	return g.setBootVar(name, value)
+}
+
 func (g *grub) GetNextBootRootFSName() (label string, err error) {
 	return g.GetBootVar(bootloaderRootfsVar)
 }
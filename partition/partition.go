@@ -27,6 +27,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 	"syscall"
@@ -77,6 +78,8 @@ type Interface interface {
 	//        to expose even less implementation details?
 	SyncBootloaderFiles(bootAssets map[string]string) error
 	IsNextBootOther() bool
+	// set variables in the environment of the named bootloader
+	SetBootVars(bootloaderName string, vars map[string]string) error
 
 	// run the function f with the otherRoot mounted
 	RunWithOther(rw MountOption, f func(otherRoot string) (err error)) (err error)
@@ -344,6 +347,32 @@ func (p *Partition) MarkBootSuccessful() (err error) {
 	return bootloader.MarkCurrentBootSuccessful(currentRootfs)
 }
 
+// SetBootVars sets the given variables in the environment of the
+// bootloader, which has to be the named one (grub or u-boot)
+func (p *Partition) SetBootVars(bootloaderName string, vars map[string]string) error {
+	bootloader, err := bootloader(p)
+	if err != nil {
+		return err
+	}
+	if string(bootloader.Name()) != bootloaderName {
+		return fmt.Errorf("the bootloader is %s, not %s", bootloader.Name(), bootloaderName)
+	}
+
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if err := bootloader.SetBootVar(name, vars[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // IsNextBootOther return true if the next boot will use the other rootfs
 // partition.
 func (p *Partition) IsNextBootOther() bool {
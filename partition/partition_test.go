@@ -369,6 +369,7 @@ type mockBootloader struct {
 	HandleAssetsCalled              bool
 	MarkCurrentBootSuccessfulCalled bool
 	SyncBootFilesCalled             bool
+	BootVars                        map[string]string
 }
 
 func (b *mockBootloader) Name() bootloaderName {
@@ -389,6 +390,13 @@ func (b *mockBootloader) HandleAssets() error {
 func (b *mockBootloader) GetBootVar(name string) (string, error) {
 	return "", nil
 }
+func (b *mockBootloader) SetBootVar(name, value string) error {
+	if b.BootVars == nil {
+		b.BootVars = make(map[string]string)
+	}
+	b.BootVars[name] = value
+	return nil
+}
 func (b *mockBootloader) GetNextBootRootFSName() (string, error) {
 	return "", nil
 }
@@ -400,6 +408,20 @@ func (b *mockBootloader) BootDir() string {
 	return ""
 }
 
+func (s *PartitionTestSuite) TestSetBootVars(c *C) {
+	b := &mockBootloader{}
+	bootloader = func(p *Partition) (bootLoader, error) {
+		return b, nil
+	}
+
+	p := New()
+	c.Assert(p.SetBootVars("mocky", map[string]string{"a": "1", "b": "2"}), IsNil)
+	c.Check(b.BootVars, DeepEquals, map[string]string{"a": "1", "b": "2"})
+
+	c.Check(p.SetBootVars("grub", map[string]string{"c": "3"}), ErrorMatches, "the bootloader is mocky, not grub")
+	c.Check(b.BootVars, HasLen, 2)
+}
+
 func (s *PartitionTestSuite) TestToggleBootloaderRootfs(c *C) {
 	runCommand = mockRunCommand
 	b := &mockBootloader{}
@@ -0,0 +1,203 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package partitiontest contains test support code: it breaks the
+// other rootfs so that the A/B failover (booting back into the current
+// rootfs when the other one does not boot) can be exercised by image
+// tests. It is not for snappy itself to use.
+package partitiontest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/partition"
+)
+
+// BootFailure is a way to make the other rootfs fail to boot
+type BootFailure string
+
+const (
+	// BootFailureSystemdLoop adds a service that deadlocks systemd
+	// (and one that reboots the system once the boot is stuck)
+	BootFailureSystemdLoop BootFailure = "systemd-loop"
+
+	// BootFailureZeroSizeSystemd replaces the systemd binary with an
+	// empty file
+	BootFailureZeroSizeSystemd BootFailure = "zero-size-systemd"
+
+	// BootFailureRCLocalCrash makes rc.local crash the kernel
+	BootFailureRCLocalCrash BootFailure = "rc-local-crash"
+)
+
+// ErrUnknownBootFailure is returned when asked to inject a boot failure
+// of an unknown kind
+var ErrUnknownBootFailure = errors.New("unknown boot failure")
+
+const (
+	bootFailureDeadlockService = `[Unit]
+Before=sysinit.target
+DefaultDependencies=no
+
+[Service]
+Type=oneshot
+ExecStartPre=-/bin/sh -c "echo 'DEBUG: $(date): deadlocked system' >/dev/console"
+ExecStartPre=-/bin/sh -c "echo 'DEBUG: $(date): deadlocked system' >/dev/ttyS0"
+ExecStart=/bin/systemctl start snappy-boot-failure-deadlock.service
+RemainAfterExit=yes
+
+[Install]
+RequiredBy=sysinit.target
+`
+	bootFailureRebootService = `[Unit]
+DefaultDependencies=no
+Description=Hack to force reboot if booting did not finish after 20s
+
+[Service]
+Type=oneshot
+ExecStartPre=/bin/sleep 20
+ExecStart=-/bin/sh -c 'if ! systemctl is-active default.target; then wall "EMERGENCY REBOOT"; reboot -f; fi'
+
+[Install]
+RequiredBy=sysinit.target
+`
+	bootFailureRCLocal = "#!/bin/sh\nprintf c > /proc/sysrq-trigger\n"
+
+	// the suffix of the files moved out of the way by a boot failure
+	bootFailureBackupSuffix = ".snappy-boot-failure"
+	// the suffix of the marks of the files a boot failure created
+	bootFailureNewSuffix = ".snappy-boot-failure-new"
+
+	systemdUnitDir     = "lib/systemd/system"
+	systemdRequiresDir = "lib/systemd/system/sysinit.target.requires"
+	systemdBinary      = "lib/systemd/systemd"
+	rcLocalFile        = "etc/rc.local"
+)
+
+var bootFailureServices = map[string]string{
+	"snappy-boot-failure-deadlock.service": bootFailureDeadlockService,
+	"snappy-boot-failure-reboot.service":   bootFailureRebootService,
+}
+
+// InjectBootFailure breaks the other rootfs of the partition in the
+// given way, so that booting it fails and the system falls back to the
+// current rootfs
+func InjectBootFailure(p *partition.Partition, kind BootFailure) error {
+	return p.RunWithOther(partition.RW, func(otherRoot string) error {
+		return injectBootFailure(otherRoot, kind)
+	})
+}
+
+// ClearBootFailure undoes all the boot failures injected into the
+// other rootfs of the partition
+func ClearBootFailure(p *partition.Partition) error {
+	return p.RunWithOther(partition.RW, clearBootFailure)
+}
+
+func injectBootFailure(root string, kind BootFailure) error {
+	switch kind {
+	case BootFailureSystemdLoop:
+		if err := os.MkdirAll(filepath.Join(root, systemdRequiresDir), 0755); err != nil {
+			return err
+		}
+		for name, content := range bootFailureServices {
+			if err := helpers.AtomicWriteFile(filepath.Join(root, systemdUnitDir, name), []byte(content), 0644, 0); err != nil {
+				return err
+			}
+			// relative, so that it works when booted
+			if err := os.Symlink(filepath.Join("..", name), filepath.Join(root, systemdRequiresDir, name)); err != nil && !os.IsExist(err) {
+				return err
+			}
+		}
+		return nil
+	case BootFailureZeroSizeSystemd:
+		return replaceWithBackup(filepath.Join(root, systemdBinary), nil)
+	case BootFailureRCLocalCrash:
+		return replaceWithBackup(filepath.Join(root, rcLocalFile), []byte(bootFailureRCLocal))
+	}
+
+	return ErrUnknownBootFailure
+}
+
+// replaceWithBackup moves the file out of the way (or marks it as new,
+// if there was none) and puts the given content in its place, keeping
+// its mode
+func replaceWithBackup(fn string, content []byte) error {
+	backup := fn + bootFailureBackupSuffix
+	if helpers.FileExists(backup) || helpers.FileExists(fn+bootFailureNewSuffix) {
+		// already injected
+		return nil
+	}
+
+	mode := os.FileMode(0755)
+	if st, err := os.Stat(fn); err == nil {
+		mode = st.Mode().Perm()
+		if err := os.Rename(fn, backup); err != nil {
+			return err
+		}
+	} else if os.IsNotExist(err) {
+		if err := helpers.AtomicWriteFile(fn+bootFailureNewSuffix, nil, 0644, 0); err != nil {
+			return err
+		}
+	} else {
+		return err
+	}
+
+	return helpers.AtomicWriteFile(fn, content, mode, 0)
+}
+
+// restoreBackup undoes replaceWithBackup
+func restoreBackup(fn string) error {
+	backup := fn + bootFailureBackupSuffix
+	if helpers.FileExists(backup) {
+		return os.Rename(backup, fn)
+	}
+
+	// there was nothing before
+	mark := fn + bootFailureNewSuffix
+	if helpers.FileExists(mark) {
+		if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return os.Remove(mark)
+	}
+
+	return nil
+}
+
+func clearBootFailure(root string) error {
+	for name := range bootFailureServices {
+		for _, fn := range []string{
+			filepath.Join(root, systemdRequiresDir, name),
+			filepath.Join(root, systemdUnitDir, name),
+		} {
+			if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	if err := restoreBackup(filepath.Join(root, systemdBinary)); err != nil {
+		return err
+	}
+
+	return restoreBackup(filepath.Join(root, rcLocalFile))
+}
@@ -0,0 +1,111 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package partitiontest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+func Test(t *testing.T) { TestingT(t) }
+
+type BootFailureTestSuite struct{}
+
+var _ = Suite(&BootFailureTestSuite{})
+
+func makeFakeOtherRoot(c *C) string {
+	root := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(root, "lib", "systemd", "system"), 0755), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(root, "etc"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(root, "lib", "systemd", "systemd"), []byte("ELF"), 0755), IsNil)
+
+	return root
+}
+
+func (s *BootFailureTestSuite) TestInjectBootFailureSystemdLoop(c *C) {
+	root := makeFakeOtherRoot(c)
+
+	c.Assert(injectBootFailure(root, BootFailureSystemdLoop), IsNil)
+	for name := range bootFailureServices {
+		c.Check(helpers.FileExists(filepath.Join(root, "lib", "systemd", "system", "sysinit.target.requires", name)), Equals, true)
+	}
+
+	c.Assert(clearBootFailure(root), IsNil)
+	matches, err := filepath.Glob(filepath.Join(root, "lib", "systemd", "system", "*", "snappy-boot-failure-*"))
+	c.Assert(err, IsNil)
+	c.Check(matches, HasLen, 0)
+}
+
+func (s *BootFailureTestSuite) TestInjectBootFailureZeroSizeSystemd(c *C) {
+	root := makeFakeOtherRoot(c)
+	systemd := filepath.Join(root, "lib", "systemd", "systemd")
+
+	c.Assert(injectBootFailure(root, BootFailureZeroSizeSystemd), IsNil)
+	st, err := os.Stat(systemd)
+	c.Assert(err, IsNil)
+	c.Check(st.Size(), Equals, int64(0))
+	c.Check(st.Mode().Perm(), Equals, os.FileMode(0755))
+
+	// injecting twice does not lose the original
+	c.Assert(injectBootFailure(root, BootFailureZeroSizeSystemd), IsNil)
+
+	c.Assert(clearBootFailure(root), IsNil)
+	content, err := ioutil.ReadFile(systemd)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "ELF")
+}
+
+func (s *BootFailureTestSuite) TestInjectBootFailureZeroSizeSystemdNoneBefore(c *C) {
+	root := makeFakeOtherRoot(c)
+	systemd := filepath.Join(root, "lib", "systemd", "systemd")
+	c.Assert(os.Remove(systemd), IsNil)
+
+	c.Assert(injectBootFailure(root, BootFailureZeroSizeSystemd), IsNil)
+	c.Check(helpers.FileExists(systemd), Equals, true)
+
+	// no empty file is left behind
+	c.Assert(clearBootFailure(root), IsNil)
+	c.Check(helpers.FileExists(systemd), Equals, false)
+	c.Check(helpers.FileExists(systemd+bootFailureNewSuffix), Equals, false)
+}
+
+func (s *BootFailureTestSuite) TestInjectBootFailureRCLocalCrash(c *C) {
+	root := makeFakeOtherRoot(c)
+	rcLocal := filepath.Join(root, "etc", "rc.local")
+
+	c.Assert(injectBootFailure(root, BootFailureRCLocalCrash), IsNil)
+	content, err := ioutil.ReadFile(rcLocal)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, bootFailureRCLocal)
+
+	// there was no rc.local before
+	c.Assert(clearBootFailure(root), IsNil)
+	c.Check(helpers.FileExists(rcLocal), Equals, false)
+}
+
+func (s *BootFailureTestSuite) TestInjectBootFailureUnknown(c *C) {
+	c.Check(injectBootFailure(c.MkDir(), BootFailure("meteor")), Equals, ErrUnknownBootFailure)
+}
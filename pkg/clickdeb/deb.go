@@ -34,6 +34,7 @@ import (
 	"time"
 
 	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/progress"
 
 	"github.com/blakesmith/ar"
 )
@@ -228,20 +229,7 @@ func (d *ClickDeb) ExtractHashes(dir string) error {
 // with click specific verification, i.e. no files will be extracted outside
 // of the targetdir (no ".." inside the data.tar is allowed)
 func (d *ClickDeb) Unpack(targetDir string) error {
-	var err error
-
-	if _, err := d.file.Seek(0, 0); err != nil {
-		return err
-	}
-
-	arReader := ar.NewReader(d.file)
-	dataReader, err := skipToArMember(arReader, "data.tar")
-	if err != nil {
-		return err
-	}
-
-	// and unpack
-	return helpers.UnpackTar(dataReader, targetDir, clickVerifyContentFn)
+	return d.unpack(targetDir, nil)
 }
 
 // FIXME: this should move into the "ar" library itself
@@ -459,37 +447,41 @@ func (d *ClickDeb) Build(sourceDir string, dataTarFinishedCallback func(dataName
 }
 
 func skipToArMember(arReader *ar.Reader, memberPrefix string) (io.Reader, error) {
-	var err error
+	header, err := nextArMember(arReader, memberPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	return decompressingReader(header.Name, arReader)
+}
 
-	// find the right ar member
-	var header *ar.Header
+// nextArMember skips to the ar member with the given prefix
+func nextArMember(arReader *ar.Reader, memberPrefix string) (*ar.Header, error) {
 	for {
-		header, err = arReader.Next()
+		header, err := arReader.Next()
 		if err != nil {
 			return nil, err
 		}
 		if strings.HasPrefix(header.Name, memberPrefix) {
-			break
+			return header, nil
 		}
 	}
+}
 
+// decompressingReader returns a reader of the decompressed content of
+// the ar member with the given name
+func decompressingReader(name string, r io.Reader) (io.Reader, error) {
 	// figure out what compression to use
-	var dataReader io.Reader
 	switch {
-	case strings.HasSuffix(header.Name, ".gz"):
-		dataReader, err = gzip.NewReader(arReader)
-		if err != nil {
-			return nil, err
-		}
-	case strings.HasSuffix(header.Name, ".bz2"):
-		dataReader = bzip2.NewReader(arReader)
-	case strings.HasSuffix(header.Name, ".xz"):
-		dataReader = xzPipeReader(arReader)
+	case strings.HasSuffix(name, ".gz"):
+		return gzip.NewReader(r)
+	case strings.HasSuffix(name, ".bz2"):
+		return bzip2.NewReader(r), nil
+	case strings.HasSuffix(name, ".xz"):
+		return xzPipeReader(r), nil
 	default:
-		return nil, fmt.Errorf("Can not handle %s", header.Name)
+		return nil, fmt.Errorf("Can not handle %s", name)
 	}
-
-	return dataReader, nil
 }
 
 // UnpackWithDropPrivs will unapck the ClickDeb content into the
@@ -497,22 +489,46 @@ func skipToArMember(arReader *ar.Reader, memberPrefix string) (io.Reader, error)
 //
 // To do this reliably in go we need to exec a helper as we can not
 // just fork() and drop privs in the child (no support for stock fork in go)
-func (d *ClickDeb) UnpackWithDropPrivs(instDir, rootdir string) error {
+//
+// The progress of the unpack is shown on pbar (if not nil).
+func (d *ClickDeb) UnpackWithDropPrivs(instDir, rootdir string, pbar progress.Meter) error {
+	meter := newUnpackMeter(d.Name(), pbar)
+
 	// no need to drop privs, we are not root
 	if !helpers.ShouldDropPrivs() {
-		return d.Unpack(instDir)
+		if err := d.unpack(instDir, meter.report); err != nil {
+			return err
+		}
+		meter.finish()
+		return nil
+	}
+
+	// the helper reports its progress on a pipe
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
 	}
+	defer r.Close()
 
 	cmd := exec.Command("snappy", "internal-unpack", d.Name(), instDir, rootdir)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
-	if err := cmd.Run(); err != nil {
+	cmd.ExtraFiles = []*os.File{w}
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", ProgressFDEnv, 3))
+	err = cmd.Start()
+	w.Close()
+	if err == nil {
+		readUnpackReports(r, meter.report)
+		err = cmd.Wait()
+	}
+	if err != nil {
 		return &ErrUnpackFailed{
 			snapFile: d.Name(),
 			instDir:  instDir,
 			origErr:  err,
 		}
 	}
+	meter.finish()
 
 	return nil
 }
@@ -20,6 +20,7 @@
 package clickdeb
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -33,6 +34,7 @@ import (
 	. "gopkg.in/check.v1"
 
 	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/progress"
 )
 
 // Hook up check.v1 into the "go test" runner.
@@ -162,6 +164,87 @@ func (s *ClickDebTestSuite) TestSnapDebUnpack(c *C) {
 	}
 }
 
+// recordingMeter remembers what it was told
+type recordingMeter struct {
+	progress.NullProgress
+	started  []string
+	total    float64
+	current  float64
+	finished bool
+	notified []string
+}
+
+func (m *recordingMeter) Start(pkg string, total float64) {
+	m.started = append(m.started, pkg)
+	m.total = total
+}
+func (m *recordingMeter) Set(current float64) { m.current = current }
+func (m *recordingMeter) Finished()           { m.finished = true }
+func (m *recordingMeter) Notify(msg string)   { m.notified = append(m.notified, msg) }
+
+func (s *ClickDebTestSuite) TestSnapDebUnpackReportsProgress(c *C) {
+	targetDir := c.MkDir()
+	debName := makeTestDeb(c, "xz")
+	d, err := Open(debName)
+	c.Assert(err, IsNil)
+
+	var lastRead, lastTotal, lastWritten int64
+	var lastEntries int
+	err = d.unpack(targetDir, func(read, total int64, entries int, written int64) {
+		c.Check(read >= lastRead, Equals, true)
+		lastRead, lastTotal, lastEntries, lastWritten = read, total, entries, written
+	})
+	c.Assert(err, IsNil)
+	c.Check(lastTotal > 0, Equals, true)
+	c.Check(lastRead, Equals, lastTotal)
+	c.Check(lastEntries > 0, Equals, true)
+	c.Check(lastWritten > 0, Equals, true)
+}
+
+func (s *ClickDebTestSuite) TestSnapDebUnpackReportingRoundtrip(c *C) {
+	targetDir := c.MkDir()
+	debName := makeTestDeb(c, "gzip")
+	d, err := Open(debName)
+	c.Assert(err, IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(d.UnpackReporting(targetDir, &buf), IsNil)
+	c.Check(helpers.FileExists(filepath.Join(targetDir, "usr", "bin", "foo")), Equals, true)
+
+	meter := &recordingMeter{}
+	m := newUnpackMeter("foo", meter)
+	readUnpackReports(strings.NewReader(buf.String()+"garbage\n"), m.report)
+	m.finish()
+
+	c.Check(meter.started, DeepEquals, []string{"foo"})
+	c.Check(meter.total > 0, Equals, true)
+	c.Check(meter.current, Equals, meter.total)
+	c.Check(meter.finished, Equals, true)
+	// the progress is all there is, no notification
+	c.Check(meter.notified, HasLen, 0)
+}
+
+func (s *ClickDebTestSuite) TestUnpackMeterNil(c *C) {
+	m := newUnpackMeter("foo", nil)
+	m.report(1, 2, 3, 4)
+	m.finish()
+	c.Check(m.started, Equals, false)
+}
+
+func (s *ClickDebTestSuite) TestProgressFile(c *C) {
+	os.Setenv(ProgressFDEnv, "")
+	defer os.Unsetenv(ProgressFDEnv)
+	c.Check(ProgressFile(), IsNil)
+
+	// a file of our own: the one ProgressFile returns closes it
+	w, err := os.Create(filepath.Join(c.MkDir(), "progress"))
+	c.Assert(err, IsNil)
+	os.Setenv(ProgressFDEnv, fmt.Sprint(w.Fd()))
+	f := ProgressFile()
+	c.Assert(f, NotNil)
+	c.Check(f.Fd(), Equals, w.Fd())
+}
+
 func (s *ClickDebTestSuite) TestClickVerifyContentFnSimple(c *C) {
 	newPath, err := clickVerifyContentFn("foo")
 	c.Assert(err, IsNil)
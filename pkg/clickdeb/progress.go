@@ -0,0 +1,158 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package clickdeb
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/blakesmith/ar"
+
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// ProgressFDEnv is the environment variable that tells the unpack
+// helper which file descriptor to report its progress on
+const ProgressFDEnv = "SNAPPY_UNPACK_PROGRESS_FD"
+
+// unpackReport is told, as the data of a snap gets unpacked, how much
+// of the (compressed) data was read out of the total, and how many
+// entries and bytes were written so far
+type unpackReport func(read, total int64, entries int, written int64)
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+	// read is called after each read
+	read func()
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.read != nil {
+		c.read()
+	}
+
+	return n, err
+}
+
+// unpack unpacks the data of the snap into the target directory,
+// reporting its progress to report (if not nil); the size of the
+// compressed data is known upfront, so that is what the progress is
+// measured in
+func (d *ClickDeb) unpack(targetDir string, report unpackReport) error {
+	if _, err := d.file.Seek(0, 0); err != nil {
+		return err
+	}
+
+	arReader := ar.NewReader(d.file)
+	header, err := nextArMember(arReader, "data.tar")
+	if err != nil {
+		return err
+	}
+
+	var entries int
+	var written int64
+	counter := &countingReader{r: arReader}
+	if report != nil {
+		counter.read = func() { report(counter.n, header.Size, entries, written) }
+	}
+
+	dataReader, err := decompressingReader(header.Name, counter)
+	if err != nil {
+		return err
+	}
+
+	return helpers.UnpackTarWithProgress(dataReader, targetDir, clickVerifyContentFn, func(e int, w int64) {
+		entries, written = e, w
+		if report != nil {
+			report(counter.n, header.Size, entries, written)
+		}
+	})
+}
+
+// UnpackReporting unpacks the data of the snap into the target
+// directory, writing its progress to w (for UnpackWithDropPrivs to
+// read)
+func (d *ClickDeb) UnpackReporting(targetDir string, w io.Writer) error {
+	return d.unpack(targetDir, func(read, total int64, entries int, written int64) {
+		fmt.Fprintf(w, "%d %d %d %d\n", read, total, entries, written)
+	})
+}
+
+// readUnpackReports reads the progress written by UnpackReporting
+// until it is done, handing it to report
+func readUnpackReports(r io.Reader, report unpackReport) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		var read, total, written int64
+		var entries int
+		if _, err := fmt.Sscanf(scanner.Text(), "%d %d %d %d", &read, &total, &entries, &written); err != nil {
+			continue
+		}
+		report(read, total, entries, written)
+	}
+}
+
+// unpackMeter shows the progress of an unpack on a progress.Meter
+type unpackMeter struct {
+	name    string
+	pbar    progress.Meter
+	started bool
+}
+
+func newUnpackMeter(name string, pbar progress.Meter) *unpackMeter {
+	return &unpackMeter{name: name, pbar: pbar}
+}
+
+func (m *unpackMeter) report(read, total int64, entries int, written int64) {
+	if m.pbar == nil {
+		return
+	}
+
+	if !m.started {
+		m.pbar.Start(m.name, float64(total))
+		m.started = true
+	}
+	m.pbar.Set(float64(read))
+}
+
+// finish ends the progress
+func (m *unpackMeter) finish() {
+	if m.pbar != nil && m.started {
+		m.pbar.Finished()
+	}
+}
+
+// ProgressFile returns the file the unpack helper reports its progress
+// on (see UnpackReporting), if it was given one
+func ProgressFile() *os.File {
+	var fd uintptr
+	if _, err := fmt.Sscanf(os.Getenv(ProgressFDEnv), "%d", &fd); err != nil {
+		return nil
+	}
+
+	return os.NewFile(fd, "progress")
+}
@@ -186,19 +186,21 @@ func find(name string, origin string) map[string]*PartBag {
 
 			// if oems were removable, there'd be know way of
 			// telling the kind of a removed origin-less package
+			typ := s.typ
+			inst := s.inst
 			if s.typ == pkg.TypeFramework && helpers.FileExists(filepath.Join(dirs.SnapOemDir, name)) {
-				s.typ = pkg.TypeOem
-				s.inst = dirs.SnapOemDir
+				typ = gadgetType(name, versions)
+				inst = dirs.SnapOemDir
 			}
 
 			bag := &PartBag{
 				Name:     name,
 				Origin:   origin,
-				Type:     s.typ,
+				Type:     typ,
 				Versions: versions,
 			}
 
-			bag.concrete = NewConcrete(bag, s.inst)
+			bag.concrete = NewConcrete(bag, inst)
 
 			bags[bag.QualifiedName()] = bag
 		}
@@ -207,6 +209,19 @@ func find(name string, origin string) map[string]*PartBag {
 	return bags
 }
 
+// gadgetType tells a gadget snap from a legacy oem one by the type in
+// the package.yaml of its versions (oem if none of them can tell)
+func gadgetType(name string, versions []string) pkg.Type {
+	for _, version := range versions {
+		yamlPath := filepath.Join(dirs.SnapOemDir, name, version, "meta", "package.yaml")
+		if part, err := snappy.NewInstalledSnapPart(yamlPath, ""); err == nil {
+			return part.Type()
+		}
+	}
+
+	return pkg.TypeOem
+}
+
 // A PartBag is a lightweight object that represents and knows how to
 // load a Part on demand.
 type PartBag struct {
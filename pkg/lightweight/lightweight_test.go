@@ -392,6 +392,17 @@ func (s *lightweightSuite) TestLoadOem(c *check.C) {
 	c.Check(p.Version(), check.Equals, "3")
 }
 
+func (s *lightweightSuite) TestLoadGadget(c *check.C) {
+	s.MkInstalled(c, pkg.TypeGadget, dirs.SnapOemDir, "gizmo", "", "1", false)
+
+	gadget := PartBagByName("gizmo", "whatever")
+	c.Assert(gadget, check.NotNil)
+	c.Check(gadget.Type, check.Equals, pkg.TypeGadget)
+	p, err := gadget.Load(0)
+	c.Check(err, check.IsNil)
+	c.Check(p.Type(), check.Equals, pkg.TypeGadget)
+}
+
 type mockrepo struct{ p snappy.Part }
 
 func (r mockrepo) All() ([]snappy.Part, error) {
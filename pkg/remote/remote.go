@@ -23,24 +23,52 @@ import (
 	"github.com/ubuntu-core/snappy/pkg"
 )
 
+// the Status of a Snap in the store
+const (
+	StatusPublished   = "Published"
+	StatusUnpublished = "Unpublished"
+)
+
 // A Snap encapsulates the data sent to us from the store.
 type Snap struct {
-	Alias           string             `json:"alias,omitempty"`
-	AnonDownloadURL string             `json:"anon_download_url,omitempty"`
-	Channel         string             `json:"channel,omitempty"`
-	DownloadSha512  string             `json:"download_sha512,omitempty"`
-	Description     string             `json:"description,omitempty"`
-	DownloadSize    int64              `json:"binary_filesize,omitempty"`
-	DownloadURL     string             `json:"download_url,omitempty"`
-	IconURL         string             `json:"icon_url"`
-	LastUpdated     string             `json:"last_updated,omitempty"`
-	Name            string             `json:"package_name"`
-	Origin          string             `json:"origin"`
-	Prices          map[string]float64 `json:"prices,omitempty"`
-	Publisher       string             `json:"publisher,omitempty"`
-	RatingsAverage  float64            `json:"ratings_average,omitempty"`
-	SupportURL      string             `json:"support_url"`
-	Title           string             `json:"title"`
-	Type            pkg.Type           `json:"content,omitempty"`
-	Version         string             `json:"version"`
+	Alias           string `json:"alias,omitempty"`
+	AnonDownloadURL string `json:"anon_download_url,omitempty"`
+	// AllowUnauthenticated is nil if the store did not say
+	AllowUnauthenticated *bool              `json:"allow_unauthenticated,omitempty"`
+	Changelog            string             `json:"changelog,omitempty"`
+	Channel              string             `json:"channel,omitempty"`
+	Confinement          pkg.Confinement    `json:"confinement,omitempty"`
+	Deltas               []Delta            `json:"deltas,omitempty"`
+	DownloadSha512       string             `json:"download_sha512,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	DownloadSize         int64              `json:"binary_filesize,omitempty"`
+	DownloadURL          string             `json:"download_url,omitempty"`
+	Epoch                int                `json:"epoch,omitempty"`
+	Frameworks           []string           `json:"framework,omitempty"`
+	DownloadMirrors      []string           `json:"download_mirrors,omitempty"`
+	IconURL              string             `json:"icon_url"`
+	LastUpdated          string             `json:"last_updated,omitempty"`
+	Name                 string             `json:"package_name"`
+	Origin               string             `json:"origin"`
+	Prices               map[string]float64 `json:"prices,omitempty"`
+	Publisher            string             `json:"publisher,omitempty"`
+	RatingsAverage       float64            `json:"ratings_average,omitempty"`
+	ScreenshotURLs       []string           `json:"screenshot_urls,omitempty"`
+	Status               string             `json:"status,omitempty"`
+	SupportURL           string             `json:"support_url"`
+	Title                string             `json:"title"`
+	Type                 pkg.Type           `json:"content,omitempty"`
+	Version              string             `json:"version"`
+	VideoURLs            []string           `json:"video_urls,omitempty"`
+}
+
+// A Delta is a binary diff, sent by the store, that turns an older
+// version of a snap into the one it comes with
+type Delta struct {
+	FromVersion     string `json:"from_version"`
+	Format          string `json:"format"`
+	AnonDownloadURL string `json:"anon_download_url,omitempty"`
+	DownloadURL     string `json:"download_url,omitempty"`
+	DownloadSha512  string `json:"download_sha512,omitempty"`
+	DownloadSize    int64  `json:"binary_filesize,omitempty"`
 }
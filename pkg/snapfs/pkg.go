@@ -28,6 +28,7 @@ import (
 	"strings"
 
 	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/progress"
 )
 
 // Snap is the squashfs based snap
@@ -66,7 +67,10 @@ func (s *Snap) ExtractHashes(dir string) error {
 }
 
 // UnpackWithDropPrivs unpacks the meta and puts stuff in place - COMAPT
-func (s *Snap) UnpackWithDropPrivs(instDir, rootdir string) error {
+//
+// unsquashfs does not tell how far it got, so there is no progress
+// for pbar
+func (s *Snap) UnpackWithDropPrivs(instDir, rootdir string, pbar progress.Meter) error {
 	// FIXME: actually drop privs
 	return s.Unpack("*", instDir)
 }
@@ -25,7 +25,7 @@ import (
 	"encoding/json"
 )
 
-// Type represents the kind of snap (app, core, frameworks, oem)
+// Type represents the kind of snap (app, core, frameworks, gadget)
 type Type string
 
 // The various types of snap parts we support
@@ -33,7 +33,31 @@ const (
 	TypeApp       Type = "app"
 	TypeCore      Type = "core"
 	TypeFramework Type = "framework"
-	TypeOem       Type = "oem"
+	TypeGadget    Type = "gadget"
+	// TypeOem is the legacy name of TypeGadget; still read, but
+	// deprecated
+	TypeOem Type = "oem"
+)
+
+// IsGadget returns true for gadget snaps, of either the gadget or the
+// legacy oem type
+func (m Type) IsGadget() bool {
+	return m == TypeGadget || m == TypeOem
+}
+
+// Confinement is how much a snap expects to be confined (strict,
+// devmode, classic)
+type Confinement string
+
+// The confinement levels snaps can ask for
+const (
+	// ConfinementStrict snaps run confined by their security policy
+	ConfinementStrict Confinement = "strict"
+	// ConfinementDevMode snaps run with their security policy in
+	// complain mode, for developing them
+	ConfinementDevMode Confinement = "devmode"
+	// ConfinementClassic snaps expect to run unconfined
+	ConfinementClassic Confinement = "classic"
 )
 
 // MarshalJSON returns *m as the JSON encoding of m.
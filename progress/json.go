@@ -0,0 +1,250 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package progress
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+)
+
+// the phases of an operation in the events of a JSONProgress
+const (
+	PhaseStart    = "start"
+	PhaseProgress = "progress"
+	PhaseSpin     = "spin"
+	PhaseFinished = "finished"
+	PhaseNotify   = "notify"
+	PhaseAgree    = "agree"
+)
+
+// Event is a single line of the JSON progress stream (JSON lines: one
+// event, as a JSON object, per line)
+type Event struct {
+	// Op identifies the operation the event is part of
+	Op string `json:"op,omitempty"`
+	// Phase is one of the Phase constants
+	Phase string `json:"phase"`
+	// Snap is what the progress is about (as given to Start)
+	Snap string `json:"snap,omitempty"`
+	// Percent is how much of the current step is done
+	Percent float64 `json:"percent"`
+	// Message is the text of a spin or a notification (or the intro
+	// of an agreement), ID and Params are those of the message, if it
+	// is one (see Message)
+	Message string    `json:"message,omitempty"`
+	ID      MessageID `json:"id,omitempty"`
+	Params  []string  `json:"params,omitempty"`
+	// License is what an agreement event asks to agree to
+	License string `json:"license,omitempty"`
+	// Done and Total are the bytes of the download the progress is
+	// of (if it is of one), Rate its rate in bytes per second and ETA
+	// the seconds it has left (0 if unknown)
+	Done  int64   `json:"done,omitempty"`
+	Total int64   `json:"total,omitempty"`
+	Rate  float64 `json:"rate,omitempty"`
+	ETA   float64 `json:"eta,omitempty"`
+}
+
+// JSONProgress is a Meter that writes its progress as a stream of
+// Events, for frontends that are not a terminal (remote UIs, scripts);
+// it is safe to use from several goroutines
+type JSONProgress struct {
+	mu      sync.Mutex
+	enc     *json.Encoder
+	op      string
+	snap    string
+	total   float64
+	current float64
+	// percent is that of the last progress event, to not flood the
+	// stream with events that change nothing
+	percent float64
+	// in is where the answers to the agreement events are read
+	// from, if anywhere
+	in *bufio.Reader
+}
+
+// NewJSONProgress returns a JSONProgress writing the events of the
+// operation op to w
+func NewJSONProgress(w io.Writer, op string) *JSONProgress {
+	return &JSONProgress{enc: json.NewEncoder(w), op: op}
+}
+
+// SetInput makes the meter read the answers to its agreement events
+// from r, a line each ("y" or "yes" agrees)
+func (t *JSONProgress) SetInput(r io.Reader) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.in = bufio.NewReader(r)
+}
+
+// emit writes the event, with the mutex held
+func (t *JSONProgress) emit(ev Event) {
+	ev.Op = t.op
+	ev.Snap = t.snap
+	// nothing to do if the frontend went away
+	t.enc.Encode(ev)
+}
+
+func (t *JSONProgress) percentDone() float64 {
+	if t.total <= 0 {
+		return 0
+	}
+	percent := float64(int(t.current*1000/t.total)) / 10
+	if percent > 100 {
+		percent = 100
+	}
+
+	return percent
+}
+
+// Start starts a step of the operation
+func (t *JSONProgress) Start(pkg string, total float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.snap, t.total, t.current, t.percent = pkg, total, 0, 0
+	t.emit(Event{Phase: PhaseStart})
+}
+
+// Set sets the progress of the step; an event is only written once the
+// progress changed by a percent
+func (t *JSONProgress) Set(current float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.current = current
+	if percent := t.percentDone(); percent-t.percent >= 1 || (percent == 100 && t.percent < 100) {
+		t.percent = percent
+		t.emit(Event{Phase: PhaseProgress, Percent: percent})
+	}
+}
+
+// SetTotal sets the total of the step
+func (t *JSONProgress) SetTotal(total float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.total = total
+}
+
+// Finished ends the step
+func (t *JSONProgress) Finished() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.emit(Event{Phase: PhaseFinished, Percent: 100})
+}
+
+// Write counts the bytes as progress of the step, so the meter can be
+// used to show the progress of io operations
+func (t *JSONProgress) Write(p []byte) (n int, err error) {
+	t.mu.Lock()
+	current := t.current + float64(len(p))
+	t.mu.Unlock()
+
+	t.Set(current)
+
+	return len(p), nil
+}
+
+// Transferred writes the progress of the download of the step, with its
+// rate and ETA
+func (t *JSONProgress) Transferred(tr *Transfer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.emit(Event{
+		Phase:   PhaseProgress,
+		Percent: t.percentDone(),
+		Done:    tr.Done,
+		Total:   tr.Total,
+		Rate:    tr.Rate,
+		ETA:     tr.ETA.Seconds(),
+	})
+}
+
+// Spin tells that the step is busy for an unknown while
+func (t *JSONProgress) Spin(msg string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.emit(Event{Phase: PhaseSpin, Message: msg})
+}
+
+// Agreed writes an agreement event and reads the answer from the input
+// (see SetInput); without one there is nobody to ask, and it does not
+// agree
+func (t *JSONProgress) Agreed(intro, license string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.in == nil {
+		return false
+	}
+	t.emit(Event{Phase: PhaseAgree, Message: intro, License: license})
+
+	answer, err := t.in.ReadString('\n')
+	if err != nil && (err != io.EOF || answer == "") {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+
+	return answer == "y" || answer == "yes"
+}
+
+// Notify writes the notification
+func (t *JSONProgress) Notify(msg string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.emit(Event{Phase: PhaseNotify, Message: msg})
+}
+
+// NotifyMessage writes the notification, with its ID and parameters
+// for the frontend to match (or translate)
+func (t *JSONProgress) NotifyMessage(msg *Message) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.emit(Event{Phase: PhaseNotify, Message: msg.String(), ID: msg.ID, Params: msg.Params})
+}
+
+// ReadEvents reads a JSON progress stream, calling f for each event
+// until the stream ends
+func ReadEvents(r io.Reader, f func(*Event)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var ev Event
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return err
+		}
+		f(&ev)
+	}
+
+	return scanner.Err()
+}
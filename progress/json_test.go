@@ -0,0 +1,122 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package progress
+
+import (
+	"bytes"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type JSONProgressTestSuite struct{}
+
+var _ = Suite(&JSONProgressTestSuite{})
+
+func (ts *JSONProgressTestSuite) TestStream(c *C) {
+	var buf bytes.Buffer
+	var meter Meter = NewJSONProgress(&buf, "op-1")
+
+	meter.Start("foo.bar", 1000)
+	for i := 0; i < 1000; i++ {
+		meter.Write([]byte{0})
+	}
+	meter.Finished()
+	meter.Spin("thinking")
+	NotifyMessage(meter, NewMessage(MsgInstalling, "foo", "1.0"))
+	meter.Notify("plain")
+
+	var events []*Event
+	c.Assert(ReadEvents(&buf, func(ev *Event) { events = append(events, ev) }), IsNil)
+
+	// start, 100 progress events (one per percent), finished, spin
+	// and two notifications
+	c.Assert(events, HasLen, 105)
+	c.Check(events[0], DeepEquals, &Event{Op: "op-1", Phase: PhaseStart, Snap: "foo.bar"})
+	c.Check(events[1], DeepEquals, &Event{Op: "op-1", Phase: PhaseProgress, Snap: "foo.bar", Percent: 1})
+	c.Check(events[100].Percent, Equals, float64(100))
+	c.Check(events[101], DeepEquals, &Event{Op: "op-1", Phase: PhaseFinished, Snap: "foo.bar", Percent: 100})
+	c.Check(events[102].Message, Equals, "thinking")
+	c.Check(events[103], DeepEquals, &Event{
+		Op:      "op-1",
+		Phase:   PhaseNotify,
+		Snap:    "foo.bar",
+		Message: "Installing foo (1.0)",
+		ID:      MsgInstalling,
+		Params:  []string{"foo", "1.0"},
+	})
+	c.Check(events[104].Message, Equals, "plain")
+	c.Check(events[104].ID, Equals, MessageID(""))
+}
+
+func (ts *JSONProgressTestSuite) TestOneEventPerLine(c *C) {
+	var buf bytes.Buffer
+	meter := NewJSONProgress(&buf, "")
+	meter.Notify("multi\nline")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	c.Check(lines, DeepEquals, []string{`{"phase":"notify","percent":0,"message":"multi\nline"}`})
+}
+
+func (ts *JSONProgressTestSuite) TestNoTotal(c *C) {
+	var buf bytes.Buffer
+	meter := NewJSONProgress(&buf, "")
+	meter.Start("foo", 0)
+	meter.Set(10)
+	meter.Finished()
+
+	var phases []string
+	c.Assert(ReadEvents(&buf, func(ev *Event) { phases = append(phases, ev.Phase) }), IsNil)
+	c.Check(phases, DeepEquals, []string{PhaseStart, PhaseFinished})
+}
+
+func (ts *JSONProgressTestSuite) TestReadEventsGarbage(c *C) {
+	err := ReadEvents(strings.NewReader("{\"phase\":\"start\"}\nnope\n"), func(*Event) {})
+	c.Check(err, NotNil)
+}
+
+func (ts *JSONProgressTestSuite) TestAgreed(c *C) {
+	for _, t := range []struct {
+		input  string
+		agreed bool
+	}{
+		{"y\n", true},
+		{"Yes\n", true},
+		{"yes", true},
+		{"n\n", false},
+		{"\n", false},
+		{"", false},
+	} {
+		var buf bytes.Buffer
+		meter := NewJSONProgress(&buf, "op-1")
+		meter.SetInput(strings.NewReader(t.input))
+		c.Check(meter.Agreed("intro", "the license"), Equals, t.agreed, Commentf("%q", t.input))
+
+		var events []*Event
+		c.Assert(ReadEvents(&buf, func(ev *Event) { events = append(events, ev) }), IsNil)
+		c.Assert(events, HasLen, 1)
+		c.Check(events[0], DeepEquals, &Event{Op: "op-1", Phase: PhaseAgree, Message: "intro", License: "the license"})
+	}
+
+	// nobody to ask
+	var buf bytes.Buffer
+	c.Check(NewJSONProgress(&buf, "op-1").Agreed("intro", "the license"), Equals, false)
+	c.Check(buf.Len(), Equals, 0)
+}
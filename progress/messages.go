@@ -0,0 +1,145 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package progress
+
+import (
+	"fmt"
+)
+
+// MessageID is the stable identifier of a notification, it does not
+// change when the text of the notification does (or gets translated)
+type MessageID string
+
+// the notifications snappy sends
+const (
+	MsgWaitingForStop       MessageID = "waiting-for-stop"
+	MsgKillingService       MessageID = "killing-service"
+	MsgStopFailed           MessageID = "stop-failed"
+	MsgRestartFailed        MessageID = "restart-failed"
+	MsgStopOldFailed        MessageID = "stop-old-failed"
+	MsgRestartOldFailed     MessageID = "restart-old-failed"
+	MsgInstalling           MessageID = "installing"
+	MsgUpdating             MessageID = "updating"
+	MsgActivateFailed       MessageID = "activate-failed"
+	MsgDeactivateFailed     MessageID = "deactivate-failed"
+	MsgPurgeFailed          MessageID = "purge-failed"
+	MsgPurgeContinues       MessageID = "purge-continues"
+	MsgSyncingBootFiles     MessageID = "syncing-boot-files"
+	MsgUpdatingBootFiles    MessageID = "updating-boot-files"
+	MsgSystemImageApplyDone MessageID = "system-image-apply-done"
+	MsgExportedData         MessageID = "exported-data"
+	MsgTraceRecorded        MessageID = "trace-recorded"
+	MsgUnpublished          MessageID = "unpublished"
+	MsgRemovingUnpublished  MessageID = "removing-unpublished"
+	MsgSideloadNameTaken    MessageID = "sideload-name-taken"
+	MsgBlueGreenSwitched    MessageID = "blue-green-switched"
+	MsgDownloadingUpdates   MessageID = "downloading-updates"
+	MsgBuying               MessageID = "buying"
+)
+
+// the (English) format of the notifications, the parameters of the
+// message are in the order of the verbs
+var messageFormats = map[MessageID]string{
+	MsgWaitingForStop:       "Waiting for %s to stop.",
+	MsgKillingService:       "%s refused to stop, killing.",
+	MsgStopFailed:           "unable to stop %s; aborting install: %s",
+	MsgRestartFailed:        "unable to restart %s; aborting install: %s",
+	MsgStopOldFailed:        "unable to stop %s with the old %s: %s",
+	MsgRestartOldFailed:     "unable to restart %s with the old %s: %s",
+	MsgInstalling:           "Installing %s (%s)",
+	MsgUpdating:             "Updating %s (%s)",
+	MsgActivateFailed:       "Unable to activate %s: %s",
+	MsgDeactivateFailed:     "Unable to deactivate %s: %s",
+	MsgPurgeFailed:          "unable to purge %s version %s: %s",
+	MsgPurgeContinues:       "Purge continues.",
+	MsgSyncingBootFiles:     "Syncing boot files",
+	MsgUpdatingBootFiles:    "Updating boot files",
+	MsgSystemImageApplyDone: "\nApply done",
+	MsgExportedData:         "Exported the data of %s to %s",
+	MsgTraceRecorded:        "Recorded the trace %s",
+	MsgUnpublished:          "%s was unpublished from the store, it will not get updates",
+	MsgRemovingUnpublished:  "Removing %s, it was unpublished from the store",
+	MsgSideloadNameTaken:    "%s is available in the store (from %s), the sideloaded one blocks installing it",
+	MsgBlueGreenSwitched:    "Switched %s over to version %s",
+	MsgDownloadingUpdates:   "Downloading %d updates, %d at a time",
+	MsgBuying:               "Buying %s for %s %s",
+}
+
+// Translate localizes the format of a message; frontends set it to
+// their gettext (it does nothing by default)
+var Translate = func(format string) string {
+	return format
+}
+
+// Message is a notification that frontends can localize and scripts
+// can match by its ID
+type Message struct {
+	ID     MessageID `json:"id"`
+	Params []string  `json:"params,omitempty"`
+}
+
+// NewMessage returns the message with the given ID and parameters, the
+// parameters are formatted with %v
+func NewMessage(id MessageID, params ...interface{}) *Message {
+	msg := &Message{ID: id}
+	for _, param := range params {
+		msg.Params = append(msg.Params, fmt.Sprint(param))
+	}
+
+	return msg
+}
+
+// String returns the localized text of the message
+func (m *Message) String() string {
+	format, ok := messageFormats[m.ID]
+	if !ok {
+		return fmt.Sprint(m.ID, m.Params)
+	}
+
+	params := make([]interface{}, len(m.Params))
+	for i, param := range m.Params {
+		params[i] = param
+	}
+
+	return fmt.Sprintf(Translate(format), params...)
+}
+
+// Notifier is what gets notified of miscellaneous events (all Meters
+// are)
+type Notifier interface {
+	Notify(string)
+}
+
+// MessageNotifier is implemented by the Notifiers that want the
+// messages themselves rather than their text
+type MessageNotifier interface {
+	NotifyMessage(*Message)
+}
+
+// NotifyMessage sends the message to the notifier, as text unless it
+// is a MessageNotifier
+func NotifyMessage(n Notifier, msg *Message) {
+	if mn, ok := n.(MessageNotifier); ok {
+		mn.NotifyMessage(msg)
+		return
+	}
+
+	n.Notify(msg.String())
+}
@@ -0,0 +1,80 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package progress
+
+import (
+	"errors"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+type notifier struct {
+	notified []string
+}
+
+func (n *notifier) Notify(msg string) {
+	n.notified = append(n.notified, msg)
+}
+
+type messageNotifier struct {
+	notifier
+	messages []*Message
+}
+
+func (n *messageNotifier) NotifyMessage(msg *Message) {
+	n.messages = append(n.messages, msg)
+}
+
+func (ts *ProgressTestSuite) TestNewMessage(c *C) {
+	msg := NewMessage(MsgPurgeFailed, "foo.bar", "1.0", errors.New("boom"))
+	c.Check(msg.ID, Equals, MsgPurgeFailed)
+	c.Check(msg.Params, DeepEquals, []string{"foo.bar", "1.0", "boom"})
+	c.Check(msg.String(), Equals, "unable to purge foo.bar version 1.0: boom")
+}
+
+func (ts *ProgressTestSuite) TestMessageFormats(c *C) {
+	c.Check(NewMessage(MessageID("no-such-message"), "foo").String(), Equals, "no-such-message[foo]")
+
+	for id := range messageFormats {
+		c.Check(string(id), Matches, "[a-z-]+")
+	}
+}
+
+func (ts *ProgressTestSuite) TestMessageTranslate(c *C) {
+	oldTranslate := Translate
+	Translate = func(format string) string {
+		return strings.Replace(format, "Waiting for", "Warte auf", 1)
+	}
+	defer func() { Translate = oldTranslate }()
+
+	c.Check(NewMessage(MsgWaitingForStop, "foo").String(), Equals, "Warte auf foo to stop.")
+}
+
+func (ts *ProgressTestSuite) TestNotifyMessage(c *C) {
+	n := new(notifier)
+	NotifyMessage(n, NewMessage(MsgInstalling, "foo", "1.0"))
+	c.Check(n.notified, DeepEquals, []string{"Installing foo (1.0)"})
+
+	mn := new(messageNotifier)
+	NotifyMessage(mn, NewMessage(MsgInstalling, "foo", "1.0"))
+	c.Check(mn.notified, HasLen, 0)
+	c.Check(mn.messages, DeepEquals, []*Message{{ID: MsgInstalling, Params: []string{"foo", "1.0"}}})
+}
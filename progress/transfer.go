@@ -0,0 +1,125 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package progress
+
+import (
+	"io"
+	"time"
+)
+
+// Transfer is the progress of a download (or any other transfer of
+// bytes)
+type Transfer struct {
+	// Total is how many bytes get transferred (0 if unknown)
+	Total int64
+	// Done is how many of them are transferred already
+	Done int64
+	// Rate is the current rate of the transfer, in bytes per second
+	Rate float64
+	// ETA is the estimated time left (0 if unknown)
+	ETA time.Duration
+}
+
+// TransferMeter is implemented by the Meters that show the rate and
+// the time left of the transfers, not just the bytes written
+type TransferMeter interface {
+	Transferred(*Transfer)
+}
+
+// NotifyTransfer tells the meter about the transfer, if it is a
+// TransferMeter
+func NotifyTransfer(meter Meter, t *Transfer) {
+	if tm, ok := meter.(TransferMeter); ok {
+		tm.Transferred(t)
+	}
+}
+
+var (
+	// transferInterval is how often a TransferMeter is told about
+	// a transfer (and so the period the rate is measured over)
+	transferInterval = 500 * time.Millisecond
+	// rateSmoothing is the weight of the last rate measured in the
+	// rate of the transfer, for the ETA not to jump around
+	rateSmoothing = 0.3
+
+	timeNow = time.Now
+)
+
+// transferWriter writes to a Meter, telling it about the transfer
+type transferWriter struct {
+	meter Meter
+	t     Transfer
+	// when and at how many bytes done the rate was last measured
+	last     time.Time
+	lastDone int64
+}
+
+// NewTransferWriter returns a writer that writes to the meter and, if
+// it is a TransferMeter, tells it about the transfer of total bytes
+// (total is 0 if unknown), of which done are already transferred
+func NewTransferWriter(meter Meter, done, total int64) io.Writer {
+	if total < done {
+		total = 0
+	}
+
+	return &transferWriter{
+		meter:    meter,
+		t:        Transfer{Total: total, Done: done},
+		last:     timeNow(),
+		lastDone: done,
+	}
+}
+
+func (w *transferWriter) Write(p []byte) (int, error) {
+	n, err := w.meter.Write(p)
+	w.t.Done += int64(n)
+
+	if _, ok := w.meter.(TransferMeter); ok {
+		now := timeNow()
+		elapsed := now.Sub(w.last)
+		if elapsed >= transferInterval || w.t.Done == w.t.Total {
+			w.measure(elapsed)
+			w.last, w.lastDone = now, w.t.Done
+			t := w.t
+			NotifyTransfer(w.meter, &t)
+		}
+	}
+
+	return n, err
+}
+
+// measure updates the rate and the ETA of the transfer, elapsed since
+// they were last measured
+func (w *transferWriter) measure(elapsed time.Duration) {
+	if elapsed > 0 {
+		rate := float64(w.t.Done-w.lastDone) / elapsed.Seconds()
+		if w.t.Rate == 0 {
+			w.t.Rate = rate
+		} else {
+			w.t.Rate = rateSmoothing*rate + (1-rateSmoothing)*w.t.Rate
+		}
+	}
+
+	w.t.ETA = 0
+	if w.t.Total > 0 && w.t.Rate > 0 {
+		left := float64(w.t.Total - w.t.Done)
+		w.t.ETA = time.Duration(left / w.t.Rate * float64(time.Second))
+	}
+}
@@ -0,0 +1,123 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package progress
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type TransferTestSuite struct {
+	now time.Time
+}
+
+var _ = Suite(&TransferTestSuite{})
+
+func (ts *TransferTestSuite) SetUpTest(c *C) {
+	ts.now = time.Date(2015, 7, 1, 12, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return ts.now }
+}
+
+func (ts *TransferTestSuite) TearDownTest(c *C) {
+	timeNow = time.Now
+}
+
+type mockTransferMeter struct {
+	NullProgress
+	written   int
+	transfers []Transfer
+}
+
+func (m *mockTransferMeter) Write(p []byte) (int, error) {
+	m.written += len(p)
+	return len(p), nil
+}
+
+func (m *mockTransferMeter) Transferred(t *Transfer) {
+	m.transfers = append(m.transfers, *t)
+}
+
+func (ts *TransferTestSuite) TestTransferWriter(c *C) {
+	meter := &mockTransferMeter{}
+	w := NewTransferWriter(meter, 1000, 5000)
+
+	// too soon to measure
+	w.Write(make([]byte, 100))
+	c.Check(meter.transfers, HasLen, 0)
+
+	ts.now = ts.now.Add(time.Second)
+	w.Write(make([]byte, 900))
+	c.Assert(meter.transfers, HasLen, 1)
+	c.Check(meter.transfers[0], DeepEquals, Transfer{
+		Total: 5000,
+		Done:  2000,
+		Rate:  1000,
+		ETA:   3 * time.Second,
+	})
+
+	// the rate is smoothed
+	ts.now = ts.now.Add(time.Second)
+	w.Write(make([]byte, 2000))
+	c.Assert(meter.transfers, HasLen, 2)
+	c.Check(meter.transfers[1].Rate, Equals, 0.3*2000+0.7*1000)
+
+	// the end is always told
+	w.Write(make([]byte, 1000))
+	c.Assert(meter.transfers, HasLen, 3)
+	c.Check(meter.transfers[2].Done, Equals, int64(5000))
+	c.Check(meter.transfers[2].ETA, Equals, time.Duration(0))
+
+	c.Check(meter.written, Equals, 4000)
+}
+
+func (ts *TransferTestSuite) TestTransferWriterUnknownTotal(c *C) {
+	meter := &mockTransferMeter{}
+	w := NewTransferWriter(meter, 0, -1)
+
+	ts.now = ts.now.Add(time.Second)
+	w.Write(make([]byte, 100))
+	c.Assert(meter.transfers, HasLen, 1)
+	c.Check(meter.transfers[0], DeepEquals, Transfer{Done: 100, Rate: 100})
+}
+
+func (ts *TransferTestSuite) TestTransferWriterPlainMeter(c *C) {
+	var buf bytes.Buffer
+	meter := NewJSONProgress(&buf, "")
+	meter.Start("foo", 100)
+
+	// a JSONProgress gets the rate and ETA in its progress events
+	w := NewTransferWriter(meter, 0, 100)
+	ts.now = ts.now.Add(time.Second)
+	_, err := io.Copy(w, bytes.NewReader(make([]byte, 50)))
+	c.Assert(err, IsNil)
+
+	var last *Event
+	c.Assert(ReadEvents(&buf, func(ev *Event) { last = ev }), IsNil)
+	c.Check(last, DeepEquals, &Event{Phase: PhaseProgress, Snap: "foo", Percent: 50, Done: 50, Total: 100, Rate: 50, ETA: 1})
+
+	// other meters just get the bytes written
+	null := &NullProgress{}
+	n, err := NewTransferWriter(null, 0, 100).Write(make([]byte, 10))
+	c.Check(err, IsNil)
+	c.Check(n, Equals, 10)
+}
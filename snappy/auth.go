@@ -158,3 +158,49 @@ func ReadStoreToken() (*StoreToken, error) {
 
 	return &readStoreToken, nil
 }
+
+// Authenticator adds the credentials of the user to the requests to
+// the store, for the snaps they bought (or that are private); it
+// returns ErrAuthenticationNeeded if it has none
+type Authenticator interface {
+	Authenticate(req *http.Request) error
+}
+
+// storeTokenAuthenticator authenticates with the token saved by "snappy
+// login", the default
+type storeTokenAuthenticator struct{}
+
+func (storeTokenAuthenticator) Authenticate(req *http.Request) error {
+	token, err := ReadStoreToken()
+	if err != nil {
+		return ErrAuthenticationNeeded
+	}
+	req.Header.Set("Authorization", oauth.MakePlaintextSignature(&token.Token))
+
+	return nil
+}
+
+// authenticate authenticates the request with the given Authenticator,
+// or the default one if nil
+func authenticate(auth Authenticator, req *http.Request) error {
+	if auth == nil {
+		auth = storeTokenAuthenticator{}
+	}
+
+	return auth.Authenticate(req)
+}
+
+// SetAuthenticator makes the requests to the store, and the downloads of
+// the snaps it returns, authenticated by the given Authenticator
+// instead of the token saved by "snappy login"
+func (s *SnapUbuntuStoreRepository) SetAuthenticator(auth Authenticator) {
+	s.auth = auth
+}
+
+// authenticated returns true if the download of the snap is made with
+// the credentials of the user
+func (s *RemoteSnapPart) authenticated() bool {
+	req, err := http.NewRequest("GET", s.pkg.DownloadURL, nil)
+
+	return err == nil && authenticate(s.auth, req) == nil
+}
@@ -24,6 +24,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"path/filepath"
 
@@ -146,3 +147,78 @@ func (s *SnapTestSuite) TestReadStoreToken(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(readToken, DeepEquals, &mockStoreToken)
 }
+
+// mockAuthenticator authenticates with the given macaroon, if any
+type mockAuthenticator struct {
+	macaroon string
+}
+
+func (a *mockAuthenticator) Authenticate(req *http.Request) error {
+	if a.macaroon == "" {
+		return ErrAuthenticationNeeded
+	}
+	req.Header.Set("Authorization", "Macaroon "+a.macaroon)
+
+	return nil
+}
+
+func (s *SnapTestSuite) TestSetUbuntuStoreHeadersAuthenticator(c *C) {
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	c.Assert(err, IsNil)
+
+	setUbuntuStoreHeaders(req, &mockAuthenticator{macaroon: "m"})
+	c.Check(req.Header.Get("Authorization"), Equals, "Macaroon m")
+}
+
+func (s *SnapTestSuite) TestSetUbuntuStoreHeadersNoCredentials(c *C) {
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	c.Assert(err, IsNil)
+
+	setUbuntuStoreHeaders(req, &mockAuthenticator{})
+	c.Check(req.Header.Get("Authorization"), Equals, "")
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryAuthenticator(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("Authorization"), Equals, "Macaroon m")
+		io.WriteString(w, MockDetailsJSON)
+	}))
+	defer mockServer.Close()
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+	repo := NewUbuntuStoreSnapRepository()
+	auth := &mockAuthenticator{macaroon: "m"}
+	repo.SetAuthenticator(auth)
+
+	parts, err := repo.Details(funkyAppName, funkyAppOrigin)
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 1)
+	// and so are the downloads
+	c.Check(parts[0].(*RemoteSnapPart).auth, Equals, auth)
+}
+
+func (s *SnapTestSuite) TestDownloadURLsAuthenticated(c *C) {
+	snap := &RemoteSnapPart{}
+	snap.pkg.AnonDownloadURL = "http://store.example/anon"
+	snap.pkg.DownloadURL = "http://store.example/auth"
+
+	snap.auth = &mockAuthenticator{}
+	c.Check(snap.downloadURLs(), DeepEquals, []string{"http://store.example/anon"})
+
+	// bought, say
+	snap.auth = &mockAuthenticator{macaroon: "m"}
+	c.Check(snap.downloadURLs(), DeepEquals, []string{"http://store.example/auth"})
+}
+
+func (s *SnapTestSuite) TestDownloadNeedsAuthentication(c *C) {
+	snap := &RemoteSnapPart{}
+	snap.pkg.DownloadURL = "http://store.example/auth"
+	allow := false
+	snap.pkg.AllowUnauthenticated = &allow
+	snap.auth = &mockAuthenticator{}
+
+	_, err := snap.Download(&MockProgressMeter{})
+	c.Check(err, Equals, ErrAuthenticationNeeded)
+}
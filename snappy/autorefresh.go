@@ -0,0 +1,333 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// the units of the automatic refresh
+const (
+	autoRefreshTimer   = "snappy-autorefresh.timer"
+	autoRefreshService = "snappy-autorefresh.service"
+)
+
+// autoRefreshCmd is what the service of the automatic refresh runs
+var autoRefreshCmd = "/usr/bin/snappy update --auto --automatic-reboot"
+
+// autoRefreshNow is the time the automatic refresh checks the schedule
+// against
+var autoRefreshNow = time.Now
+
+// autoRefreshUpgrade is what the automatic refresh runs
+var autoRefreshUpgrade = UpgradeAll
+
+// refreshWindow is a time of the day the automatic refresh may run
+// in, as offsets from midnight; it ends the next day if end < start
+type refreshWindow struct {
+	start time.Duration
+	end   time.Duration
+}
+
+func (w refreshWindow) length() time.Duration {
+	if w.end < w.start {
+		return 24*time.Hour - w.start + w.end
+	}
+
+	return w.end - w.start
+}
+
+func (w refreshWindow) contains(t time.Time) bool {
+	h, m, s := t.Clock()
+	d := time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+	if w.end < w.start {
+		return d >= w.start || d < w.end
+	}
+
+	return d >= w.start && d < w.end
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseRefreshSchedule parses a comma separated list of windows, like
+// "02:00-04:00,22:30-23:00"
+func parseRefreshSchedule(schedule string) ([]refreshWindow, error) {
+	var windows []refreshWindow
+	for _, spec := range strings.Split(schedule, ",") {
+		bounds := strings.Split(strings.TrimSpace(spec), "-")
+		if len(bounds) != 2 {
+			return nil, &ErrInvalidRefreshSchedule{Schedule: schedule}
+		}
+
+		start, err := parseTimeOfDay(bounds[0])
+		if err != nil {
+			return nil, &ErrInvalidRefreshSchedule{Schedule: schedule}
+		}
+		end, err := parseTimeOfDay(bounds[1])
+		if err != nil || end == start {
+			return nil, &ErrInvalidRefreshSchedule{Schedule: schedule}
+		}
+
+		windows = append(windows, refreshWindow{start: start, end: end})
+	}
+
+	return windows, nil
+}
+
+// AutoRefreshResult is the outcome of the automatic refresh of a snap
+type AutoRefreshResult struct {
+	Snap string `yaml:"snap"`
+	From string `yaml:"from,omitempty"`
+	To   string `yaml:"to"`
+	// Error of the refresh ("" if it succeeded)
+	Error string `yaml:"error,omitempty"`
+}
+
+// AutoRefresh is the state of the automatic refresh
+type AutoRefresh struct {
+	// Schedule is the windows the refresh runs in ("" if it is not
+	// enabled)
+	Schedule string `yaml:"schedule,omitempty"`
+	// Last is the time of the last refresh
+	Last time.Time `yaml:"last,omitempty"`
+	// Error of the last refresh ("" if it succeeded)
+	Error string `yaml:"error,omitempty"`
+	// Results of the last refresh, one for each snap it refreshed
+	Results []AutoRefreshResult `yaml:"results,omitempty"`
+}
+
+func autoRefreshFile() string {
+	return filepath.Join(dirs.GlobalRootDir, dirs.SnappyDir, "auto-refresh.yaml")
+}
+
+// AutoRefreshState returns the schedule and the outcome of the last
+// run of the automatic refresh
+func AutoRefreshState() (*AutoRefresh, error) {
+	content, err := ioutil.ReadFile(autoRefreshFile())
+	if os.IsNotExist(err) {
+		return &AutoRefresh{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var state AutoRefresh
+	if err := yaml.Unmarshal(content, &state); err != nil {
+		return nil, err
+	}
+
+	return &state, nil
+}
+
+func writeAutoRefreshState(state *AutoRefresh) error {
+	content, err := yaml.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(autoRefreshFile()), 0755); err != nil {
+		return err
+	}
+
+	return helpers.AtomicWriteFile(autoRefreshFile(), content, 0644, 0)
+}
+
+// autoRefreshAccuracyShare is the share of the shortest window the
+// timer may fire late by; the rest of the window is left for the
+// refresh to start in
+const autoRefreshAccuracyShare = 2
+
+// genAutoRefreshTimer returns the timer unit that starts the automatic
+// refresh at the start of each window (give or take half the length of
+// the shortest one, for the devices not to all hit the store at once)
+func genAutoRefreshTimer(windows []refreshWindow) string {
+	shortest := windows[0].length()
+	var calendar []string
+	for _, w := range windows {
+		if w.length() < shortest {
+			shortest = w.length()
+		}
+		calendar = append(calendar, fmt.Sprintf("OnCalendar=*-*-* %02d:%02d:00", int(w.start.Hours()), int(w.start.Minutes())%60))
+	}
+	accuracy := fmt.Sprintf("%ds", int((shortest / autoRefreshAccuracyShare).Seconds()))
+	if shortest%(autoRefreshAccuracyShare*time.Minute) == 0 {
+		accuracy = fmt.Sprintf("%dmin", int((shortest / autoRefreshAccuracyShare).Minutes()))
+	}
+
+	return fmt.Sprintf(`[Unit]
+Description=Ubuntu Core Snappy automatic refresh
+X-Snappy=yes
+
+[Timer]
+%s
+AccuracySec=%s
+Unit=%s
+
+[Install]
+WantedBy=multi-user.target
+`, strings.Join(calendar, "\n"), accuracy, autoRefreshService)
+}
+
+func genAutoRefreshService() string {
+	return fmt.Sprintf(`[Unit]
+Description=Ubuntu Core Snappy automatic refresh
+After=network.target
+X-Snappy=yes
+
+[Service]
+Type=oneshot
+ExecStart=%s
+`, autoRefreshCmd)
+}
+
+// SetAutoRefreshSchedule sets the windows the automatic refresh runs
+// in (see parseRefreshSchedule), generating and enabling its timer; an
+// empty schedule disables it
+func SetAutoRefreshSchedule(schedule string, meter progress.Meter) error {
+	var windows []refreshWindow
+	if schedule != "" {
+		var err error
+		if windows, err = parseRefreshSchedule(schedule); err != nil {
+			return err
+		}
+	}
+
+	state, err := AutoRefreshState()
+	if err != nil {
+		return err
+	}
+
+	sysd := newSystemd(meter)
+	timer := filepath.Join(dirs.SnapServicesDir, autoRefreshTimer)
+	service := filepath.Join(dirs.SnapServicesDir, autoRefreshService)
+	if len(windows) == 0 {
+		if helpers.FileExists(timer) {
+			if err := sysd.Disable(autoRefreshTimer); err != nil {
+				return err
+			}
+			if err := sysd.Stop(autoRefreshTimer, time.Minute); err != nil {
+				return err
+			}
+		}
+		for _, unit := range []string{timer, service} {
+			if err := os.Remove(unit); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	} else {
+		if err := os.MkdirAll(dirs.SnapServicesDir, 0755); err != nil {
+			return err
+		}
+		if err := helpers.AtomicWriteFile(service, []byte(genAutoRefreshService()), 0644, 0); err != nil {
+			return err
+		}
+		if err := helpers.AtomicWriteFile(timer, []byte(genAutoRefreshTimer(windows)), 0644, 0); err != nil {
+			return err
+		}
+	}
+
+	if err := sysd.DaemonReload(); err != nil {
+		return err
+	}
+	if len(windows) > 0 {
+		if err := sysd.Enable(autoRefreshTimer); err != nil {
+			return err
+		}
+		if err := sysd.Start(autoRefreshTimer); err != nil {
+			return err
+		}
+	}
+
+	state.Schedule = schedule
+	return writeAutoRefreshState(state)
+}
+
+// RunAutoRefresh upgrades all the snaps that have updates (see
+// UpgradeAll) and records the results, if it is run within a window of
+// the schedule; it returns nil results if it is not. Either way the
+// expired snaps get purged from the trash
+func RunAutoRefresh(meter progress.Meter) ([]UpgradeResult, error) {
+	state, err := AutoRefreshState()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := PurgeExpiredTrash(); err != nil {
+		logger.Noticef("Failed to purge the trash: %v", err)
+	}
+
+	if state.Schedule != "" {
+		windows, err := parseRefreshSchedule(state.Schedule)
+		if err != nil {
+			return nil, err
+		}
+		now := autoRefreshNow()
+		inWindow := false
+		for _, w := range windows {
+			if w.contains(now) {
+				inWindow = true
+				break
+			}
+		}
+		if !inWindow {
+			logger.Noticef("Not refreshing outside of the schedule %q", state.Schedule)
+			return nil, nil
+		}
+	}
+
+	results, err := autoRefreshUpgrade(meter)
+
+	state.Last = autoRefreshNow().UTC()
+	state.Error = ""
+	if err != nil {
+		state.Error = err.Error()
+	}
+	state.Results = nil
+	for _, r := range results {
+		result := AutoRefreshResult{Snap: r.Snap, From: r.From, To: r.To}
+		if r.Err != nil {
+			result.Error = r.Err.Error()
+		}
+		state.Results = append(state.Results, result)
+	}
+	if err := writeAutoRefreshState(state); err != nil {
+		logger.Noticef("Cannot record the automatic refresh: %v", err)
+	}
+
+	return results, err
+}
@@ -0,0 +1,209 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+func (s *SnapTestSuite) TestParseRefreshSchedule(c *C) {
+	windows, err := parseRefreshSchedule("02:00-04:30, 23:00-01:00")
+	c.Assert(err, IsNil)
+	c.Check(windows, DeepEquals, []refreshWindow{
+		{start: 2 * time.Hour, end: 4*time.Hour + 30*time.Minute},
+		{start: 23 * time.Hour, end: time.Hour},
+	})
+	c.Check(windows[0].length(), Equals, 2*time.Hour+30*time.Minute)
+	c.Check(windows[1].length(), Equals, 2*time.Hour)
+
+	for _, schedule := range []string{"", "02:00", "02:00-25:00", "02:00-02:00", "2am-4am", "02:00-04:00,"} {
+		_, err := parseRefreshSchedule(schedule)
+		c.Check(err, FitsTypeOf, &ErrInvalidRefreshSchedule{}, Commentf(schedule))
+	}
+}
+
+func (s *SnapTestSuite) TestRefreshWindowContains(c *C) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2015, 10, 1, hour, min, 0, 0, time.Local)
+	}
+
+	w := refreshWindow{start: 2 * time.Hour, end: 4 * time.Hour}
+	c.Check(w.contains(at(2, 0)), Equals, true)
+	c.Check(w.contains(at(3, 59)), Equals, true)
+	c.Check(w.contains(at(4, 0)), Equals, false)
+	c.Check(w.contains(at(1, 59)), Equals, false)
+
+	w = refreshWindow{start: 23 * time.Hour, end: time.Hour}
+	c.Check(w.contains(at(23, 30)), Equals, true)
+	c.Check(w.contains(at(0, 30)), Equals, true)
+	c.Check(w.contains(at(1, 0)), Equals, false)
+	c.Check(w.contains(at(12, 0)), Equals, false)
+}
+
+func (s *SnapTestSuite) TestAutoRefreshTimerAccuracy(c *C) {
+	// the timer fires well within the shortest window
+	for _, t := range []struct {
+		schedule string
+		accuracy string
+	}{
+		{"02:00-04:00", "60min"},
+		{"02:00-04:00,13:15-13:45", "15min"},
+		{"02:00-02:01", "30s"},
+		{"02:00-02:03", "90s"},
+	} {
+		windows, err := parseRefreshSchedule(t.schedule)
+		c.Assert(err, IsNil)
+		c.Check(genAutoRefreshTimer(windows), Matches, "(?s).*\nAccuracySec="+t.accuracy+"\n.*", Commentf(t.schedule))
+	}
+}
+
+func (s *SnapTestSuite) TestSetAutoRefreshSchedule(c *C) {
+	var cmds []string
+	s.backend.systemctl = func(cmd ...string) ([]byte, error) {
+		cmds = append(cmds, strings.Join(cmd, " "))
+		return []byte("ActiveState=inactive\n"), nil
+	}
+
+	c.Assert(SetAutoRefreshSchedule("02:00-04:00,13:15-13:45", s.meter()), IsNil)
+
+	timer, err := ioutil.ReadFile(filepath.Join(dirs.SnapServicesDir, autoRefreshTimer))
+	c.Assert(err, IsNil)
+	c.Check(string(timer), Matches, "(?s).*\nOnCalendar=\\*-\\*-\\* 02:00:00\nOnCalendar=\\*-\\*-\\* 13:15:00\nAccuracySec=15min\nUnit=snappy-autorefresh.service\n.*")
+	service, err := ioutil.ReadFile(filepath.Join(dirs.SnapServicesDir, autoRefreshService))
+	c.Assert(err, IsNil)
+	c.Check(string(service), Matches, "(?s).*\nExecStart="+autoRefreshCmd+"\n.*")
+	_, err = os.Lstat(filepath.Join(dirs.SnapServicesDir, "multi-user.target.wants", autoRefreshTimer))
+	c.Check(err, IsNil)
+	c.Check(cmds, DeepEquals, []string{"daemon-reload", "start " + autoRefreshTimer})
+
+	state, err := AutoRefreshState()
+	c.Assert(err, IsNil)
+	c.Check(state.Schedule, Equals, "02:00-04:00,13:15-13:45")
+
+	// and disabled again
+	cmds = nil
+	c.Assert(SetAutoRefreshSchedule("", s.meter()), IsNil)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapServicesDir, autoRefreshTimer)), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapServicesDir, autoRefreshService)), Equals, false)
+	c.Check(cmds, DeepEquals, []string{"--root " + dirs.GlobalRootDir + " disable " + autoRefreshTimer, "stop " + autoRefreshTimer, "show --property=ActiveState " + autoRefreshTimer, "daemon-reload"})
+
+	state, err = AutoRefreshState()
+	c.Assert(err, IsNil)
+	c.Check(state.Schedule, Equals, "")
+}
+
+func (s *SnapTestSuite) TestSetAutoRefreshScheduleInvalid(c *C) {
+	c.Check(SetAutoRefreshSchedule("whenever", s.meter()), FitsTypeOf, &ErrInvalidRefreshSchedule{})
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapServicesDir, autoRefreshTimer)), Equals, false)
+}
+
+func (s *SnapTestSuite) TestRunAutoRefreshOutsideSchedule(c *C) {
+	c.Assert(writeAutoRefreshState(&AutoRefresh{Schedule: "02:00-04:00"}), IsNil)
+	autoRefreshNow = func() time.Time {
+		return time.Date(2015, 10, 1, 12, 0, 0, 0, time.Local)
+	}
+	autoRefreshUpgrade = func(progress.Meter) ([]UpgradeResult, error) {
+		c.Fatal("unexpected upgrade outside of the schedule")
+		return nil, nil
+	}
+
+	results, err := RunAutoRefresh(s.meter())
+	c.Assert(err, IsNil)
+	c.Check(results, IsNil)
+
+	state, err := AutoRefreshState()
+	c.Assert(err, IsNil)
+	c.Check(state.Last.IsZero(), Equals, true)
+}
+
+func (s *SnapTestSuite) TestRunAutoRefreshRecordsOutcome(c *C) {
+	c.Assert(writeAutoRefreshState(&AutoRefresh{Schedule: "02:00-04:00"}), IsNil)
+	now := time.Date(2015, 10, 1, 3, 0, 0, 0, time.Local)
+	autoRefreshNow = func() time.Time { return now }
+	autoRefreshUpgrade = func(progress.Meter) ([]UpgradeResult, error) {
+		return []UpgradeResult{
+			{Snap: "foo.sideload", From: "1", To: "2"},
+			{Snap: "bar.sideload", From: "1", To: "2", Err: errors.New("boom")},
+		}, ErrUpgradeFailed{"bar.sideload"}
+	}
+
+	results, err := RunAutoRefresh(s.meter())
+	c.Check(err, DeepEquals, ErrUpgradeFailed{"bar.sideload"})
+	c.Check(results, HasLen, 2)
+
+	state, err := AutoRefreshState()
+	c.Assert(err, IsNil)
+	c.Check(state.Last.Equal(now), Equals, true)
+	c.Check(state.Error, Equals, "failed to upgrade: bar.sideload")
+	c.Check(state.Schedule, Equals, "02:00-04:00")
+	c.Check(state.Results, DeepEquals, []AutoRefreshResult{
+		{Snap: "foo.sideload", From: "1", To: "2"},
+		{Snap: "bar.sideload", From: "1", To: "2", Error: "boom"},
+	})
+}
+
+func (s *SnapTestSuite) TestRunAutoRefreshPurgesExpiredTrash(c *C) {
+	makeTwoTestSnaps(c, pkg.TypeApp)
+	c.Assert(Remove("foo", 0, s.meter()), IsNil)
+
+	oldRetention := TrashRetention
+	TrashRetention = -time.Second
+	defer func() { TrashRetention = oldRetention }()
+
+	// also outside of the schedule
+	c.Assert(writeAutoRefreshState(&AutoRefresh{Schedule: "02:00-04:00"}), IsNil)
+	autoRefreshNow = func() time.Time {
+		return time.Date(2015, 10, 1, 12, 0, 0, 0, time.Local)
+	}
+
+	_, err := RunAutoRefresh(s.meter())
+	c.Assert(err, IsNil)
+	trashed, err := TrashedSnaps()
+	c.Assert(err, IsNil)
+	c.Check(trashed, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestRunAutoRefreshWithoutSchedule(c *C) {
+	upgraded := false
+	autoRefreshUpgrade = func(progress.Meter) ([]UpgradeResult, error) {
+		upgraded = true
+		return nil, nil
+	}
+
+	_, err := RunAutoRefresh(s.meter())
+	c.Assert(err, IsNil)
+	c.Check(upgraded, Equals, true)
+
+	state, err := AutoRefreshState()
+	c.Assert(err, IsNil)
+	c.Check(state.Last.IsZero(), Equals, false)
+}
@@ -0,0 +1,175 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/progress"
+	"github.com/ubuntu-core/snappy/systemd"
+)
+
+// Backend runs the system tools snappy drives. Operations use the
+// Backend carried by their meter (see WithBackend), so concurrent
+// operations can each use their own.
+type Backend interface {
+	// Systemctl runs systemctl with the given args
+	systemd.Backend
+	// UdevAdm runs udevadm with the given args, returning its output
+	UdevAdm(args ...string) ([]byte, error)
+	// RunUdevAdm runs udevadm with the given args, streaming its
+	// output
+	RunUdevAdm(args ...string) error
+	// AaClickHook runs aa-clickhook with the given args, returning
+	// its (combined) output
+	AaClickHook(args ...string) ([]byte, error)
+	// ScFilterGen runs sc-filtergen with the given args, returning
+	// the generated seccomp policy
+	ScFilterGen(args ...string) ([]byte, error)
+	// UpdateTimestamp touches the given file
+	UpdateTimestamp(path string) error
+	// MACSystem returns the mandatory access control system that
+	// confines the snaps, "apparmor" or "selinux"
+	MACSystem() string
+	// RunMACCmd runs a tool of the MAC system (like apparmor_parser
+	// or semodule) with the given argv
+	RunMACCmd(argv ...string) error
+	// RunSelfTest runs the self-test (or health check) cmd, killing
+	// it if it takes longer than timeout, returning its output
+	RunSelfTest(cmd *exec.Cmd, timeout time.Duration) ([]byte, error)
+	// RunHook runs the hook of a snap under the given AppArmor
+	// profile, with the given environment
+	RunHook(hook, appArmorProfile string, env []string) error
+}
+
+// systemBackend is the Backend that runs the tools of the system
+type systemBackend struct {
+	systemd.SystemBackend
+}
+
+func (systemBackend) UdevAdm(args ...string) ([]byte, error) {
+	return exec.Command("udevadm", args...).Output()
+}
+
+func (systemBackend) RunUdevAdm(args ...string) error {
+	cmd := exec.Command("udevadm", args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+func (systemBackend) AaClickHook(args ...string) ([]byte, error) {
+	return exec.Command("aa-clickhook", args...).CombinedOutput()
+}
+
+func (systemBackend) ScFilterGen(args ...string) ([]byte, error) {
+	return exec.Command("sc-filtergen", args...).Output()
+}
+
+func (systemBackend) UpdateTimestamp(path string) error {
+	return helpers.UpdateTimestamp(path)
+}
+
+func (systemBackend) MACSystem() string {
+	return detectMACSystem()
+}
+
+func (systemBackend) RunMACCmd(argv ...string) error {
+	return runMACCmd(argv...)
+}
+
+func (systemBackend) RunSelfTest(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+	return runSelfTestCmd(cmd, timeout)
+}
+
+func (systemBackend) RunHook(hook, appArmorProfile string, env []string) error {
+	return runHookCmd(hook, appArmorProfile, env)
+}
+
+// defaultBackend is used by the operations whose meter carries no
+// Backend
+var defaultBackend Backend = systemBackend{}
+
+// backendCarrier is an operation context (e.g. a meter) that brings
+// its own Backend
+type backendCarrier interface {
+	Backend() Backend
+}
+
+// backendOf returns the Backend of the operation context
+func backendOf(ctx interface{}) Backend {
+	if carrier, ok := ctx.(backendCarrier); ok {
+		return carrier.Backend()
+	}
+
+	return defaultBackend
+}
+
+// backendMeter is a progress.Meter that carries a Backend
+type backendMeter struct {
+	progress.Meter
+	backend Backend
+}
+
+func (m *backendMeter) Backend() Backend {
+	return m.backend
+}
+
+func (m *backendMeter) NotifyMessage(msg *progress.Message) {
+	progress.NotifyMessage(m.Meter, msg)
+}
+
+func (m *backendMeter) Transferred(t *progress.Transfer) {
+	progress.NotifyTransfer(m.Meter, t)
+}
+
+// WithBackend returns a meter for an operation that makes it run the
+// system tools through the given Backend
+func WithBackend(meter progress.Meter, backend Backend) progress.Meter {
+	return &backendMeter{Meter: meter, backend: backend}
+}
+
+// systemdReporter hands the Backend of the operation to systemd
+type systemdReporter struct {
+	rep progress.Notifier
+	Backend
+}
+
+func (r *systemdReporter) Notify(msg string) {
+	if r.rep != nil {
+		r.rep.Notify(msg)
+	}
+}
+
+func (r *systemdReporter) NotifyMessage(msg *progress.Message) {
+	if r.rep != nil {
+		progress.NotifyMessage(r.rep, msg)
+	}
+}
+
+// newSystemd returns a Systemd for the operation rep reports to
+func newSystemd(rep progress.Notifier) systemd.Systemd {
+	return systemd.New(dirs.GlobalRootDir, &systemdReporter{rep: rep, Backend: backendOf(rep)})
+}
@@ -0,0 +1,55 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+func (s *SnapTestSuite) TestBackendOf(c *C) {
+	c.Check(backendOf(&progress.NullProgress{}), Equals, defaultBackend)
+	c.Check(backendOf(nil), Equals, defaultBackend)
+
+	backend := newMockBackend()
+	c.Check(backendOf(WithBackend(&progress.NullProgress{}, backend)), Equals, backend)
+}
+
+func (s *SnapTestSuite) TestNewSystemdUsesBackendOfMeter(c *C) {
+	var called [][]string
+	backend := newMockBackend()
+	backend.systemctl = func(args ...string) ([]byte, error) {
+		called = append(called, args)
+		return nil, nil
+	}
+
+	c.Assert(newSystemd(WithBackend(&MockProgressMeter{}, backend)).Start("foo.service"), IsNil)
+	c.Check(called, DeepEquals, [][]string{{"start", "foo.service"}})
+	// the default backend was not used
+	c.Assert(newSystemd(&MockProgressMeter{}).Start("foo.service"), IsNil)
+	c.Check(called, HasLen, 1)
+}
+
+func (s *SnapTestSuite) TestMeterWithAgreerKeepsBackend(c *C) {
+	backend := newMockBackend()
+	meter := &meterWithAgreer{Meter: WithBackend(&progress.NullProgress{}, backend)}
+	c.Check(backendOf(meter), Equals, backend)
+}
@@ -0,0 +1,584 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/progress"
+	"github.com/ubuntu-core/snappy/systemd"
+)
+
+// the ways the services of a snap get upgraded
+const (
+	// UpgradeModeStopStart stops the old service before the new one
+	// starts (the default)
+	UpgradeModeStopStart = "stop-start"
+	// UpgradeModeBlueGreen starts the new service alongside the old
+	// one and moves the traffic over once it is healthy
+	UpgradeModeBlueGreen = "blue-green"
+)
+
+// DefaultHealthCheckTimeout is how long a new blue/green service has to
+// get healthy when it does not specify a health-check-timeout
+var DefaultHealthCheckTimeout = Timeout(30 * time.Second)
+
+// healthCheckInterval is how long to wait between health checks
+var healthCheckInterval = time.Second
+
+// proxyStopTimeout is how long the proxy has to stop
+const proxyStopTimeout = 10 * time.Second
+
+// blueGreenState is where the negotiated ports of the blue/green
+// services are, and which version each proxy forwards to
+type blueGreenState struct {
+	// Ports is the port of each service unit (e.g. "foo_web_1.0")
+	Ports map[string]int `yaml:"ports,omitempty"`
+	// Fronts is the service unit the proxy of each service (e.g.
+	// "foo_web") forwards to
+	Fronts map[string]string `yaml:"fronts,omitempty"`
+}
+
+func blueGreenStateFile() string {
+	return filepath.Join(dirs.GlobalRootDir, dirs.SnappyDir, "blue-green.yaml")
+}
+
+func readBlueGreenState() (*blueGreenState, error) {
+	st := &blueGreenState{
+		Ports:  make(map[string]int),
+		Fronts: make(map[string]string),
+	}
+
+	content, err := ioutil.ReadFile(blueGreenStateFile())
+	if os.IsNotExist(err) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(content, st); err != nil {
+		return nil, err
+	}
+	if st.Ports == nil {
+		st.Ports = make(map[string]int)
+	}
+	if st.Fronts == nil {
+		st.Fronts = make(map[string]string)
+	}
+
+	return st, nil
+}
+
+func (st *blueGreenState) save() error {
+	content, err := yaml.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	fn := blueGreenStateFile()
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+
+	return helpers.AtomicWriteFile(fn, content, 0644, 0)
+}
+
+func (service ServiceYaml) isBlueGreen() bool {
+	return service.UpgradeMode == UpgradeModeBlueGreen
+}
+
+// portNumber returns the number of a port like "8080/tcp"
+func portNumber(port string) (int, error) {
+	n, err := strconv.Atoi(strings.SplitN(port, "/", 2)[0])
+	if err != nil || n <= 0 || n > 65535 {
+		return 0, fmt.Errorf("invalid port %q", port)
+	}
+
+	return n, nil
+}
+
+// frontPort returns the (only) external port of a blue/green service,
+// the one the proxy listens on
+func (service ServiceYaml) frontPort() (string, Port) {
+	for name, port := range service.Ports.External {
+		return name, port
+	}
+
+	return "", Port{}
+}
+
+// verifyUpgradeMode checks that the service can be upgraded the way it
+// asks for: a blue/green service needs a health check and a single
+// negotiable external port for the proxy to move between the versions
+func verifyUpgradeMode(service ServiceYaml) error {
+	switch service.UpgradeMode {
+	case "", UpgradeModeStopStart:
+		return nil
+	case UpgradeModeBlueGreen:
+		// carry on
+	default:
+		return fmt.Errorf("invalid upgrade-mode %q for service %s", service.UpgradeMode, service.Name)
+	}
+
+	if service.HealthCheck == "" {
+		return fmt.Errorf("blue-green service %s needs a health-check", service.Name)
+	}
+	if service.Socket {
+		return fmt.Errorf("blue-green service %s can not be socket activated", service.Name)
+	}
+	if service.Ports == nil || len(service.Ports.External) != 1 {
+		return fmt.Errorf("blue-green service %s needs exactly one external port", service.Name)
+	}
+
+	name, port := service.frontPort()
+	if !port.Negotiable {
+		return fmt.Errorf("the port %s of blue-green service %s is not negotiable", name, service.Name)
+	}
+	_, err := portNumber(port.Port)
+
+	return err
+}
+
+// serviceUnit is the name of the unit of the service, without the
+// ".service"
+func serviceUnit(m *packageYaml, service ServiceYaml) string {
+	return strings.TrimSuffix(filepath.Base(generateServiceFileName(m, service)), ".service")
+}
+
+// proxyName is what the proxy of the service is called, for all the
+// versions of the snap
+func proxyName(m *packageYaml, service ServiceYaml) string {
+	return fmt.Sprintf("%s_%s", m.Name, service.Name)
+}
+
+func generateProxySocketFileName(m *packageYaml, service ServiceYaml) string {
+	return filepath.Join(dirs.SnapServicesDir, proxyName(m, service)+".proxy.socket")
+}
+
+func generateProxyServiceFileName(m *packageYaml, service ServiceYaml) string {
+	return filepath.Join(dirs.SnapServicesDir, proxyName(m, service)+".proxy.service")
+}
+
+// the proxy of each version forwards from a unix socket to the port of
+// the version; the front proxy forwards to the one of these the socket
+// of the service links to
+func generateVersionProxySocketFileName(m *packageYaml, service ServiceYaml) string {
+	return filepath.Join(dirs.SnapServicesDir, serviceUnit(m, service)+".proxy.socket")
+}
+
+func generateVersionProxyServiceFileName(m *packageYaml, service ServiceYaml) string {
+	return filepath.Join(dirs.SnapServicesDir, serviceUnit(m, service)+".proxy.service")
+}
+
+func blueGreenSocketsDir() string {
+	return filepath.Join(dirs.GlobalRootDir, dirs.SnappyDir, "blue-green")
+}
+
+// frontSocketPath is the symlink to the socket of the version the
+// front proxy forwards to
+func frontSocketPath(m *packageYaml, service ServiceYaml) string {
+	return filepath.Join(blueGreenSocketsDir(), proxyName(m, service)+".sock")
+}
+
+func versionSocketPath(m *packageYaml, service ServiceYaml) string {
+	return filepath.Join(blueGreenSocketsDir(), serviceUnit(m, service)+".sock")
+}
+
+var freePort = freePortImpl
+
+// freePortImpl returns a port nothing listens on right now
+func freePortImpl() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// negotiatedPorts returns the ports of the unit of a blue/green
+// service (picking them the first time), nil for other services
+func negotiatedPorts(m *packageYaml, service ServiceYaml) (map[string]int, error) {
+	if !service.isBlueGreen() {
+		return nil, nil
+	}
+
+	st, err := readBlueGreenState()
+	if err != nil {
+		return nil, err
+	}
+
+	unit := serviceUnit(m, service)
+	port, ok := st.Ports[unit]
+	if !ok {
+		if port, err = freePort(); err != nil {
+			return nil, err
+		}
+		st.Ports[unit] = port
+		if err := st.save(); err != nil {
+			return nil, err
+		}
+	}
+	name, _ := service.frontPort()
+
+	return map[string]int{name: port}, nil
+}
+
+// writeProxy writes the units of a proxy that listens on listen and
+// forwards to target
+func writeProxy(sysd systemd.Systemd, desc, socketFileName, serviceFileName, listen, target string) error {
+	socketContent := sysd.GenProxySocketFile(&systemd.ServiceDescription{
+		Description:  desc,
+		ListenStream: listen,
+	})
+	serviceContent := sysd.GenProxyServiceFile(&systemd.ServiceDescription{
+		Description:    desc,
+		SocketFileName: filepath.Base(socketFileName),
+		ProxyTarget:    target,
+	})
+
+	if err := helpers.AtomicWriteFile(socketFileName, []byte(socketContent), 0644, 0); err != nil {
+		return err
+	}
+
+	return helpers.AtomicWriteFile(serviceFileName, []byte(serviceContent), 0644, 0)
+}
+
+// pointProxy makes the proxy of the service forward to this version
+// of it, creating the proxy if needed. The front proxy never restarts:
+// the link to the socket of the version it forwards to is swapped, so
+// the new connections go to this version while the ones in flight
+// carry on with the old one
+func (m *packageYaml) pointProxy(service ServiceYaml, inhibitHooks bool, inter interacter) error {
+	st, err := readBlueGreenState()
+	if err != nil {
+		return err
+	}
+
+	unit := serviceUnit(m, service)
+	port, ok := st.Ports[unit]
+	if !ok {
+		return fmt.Errorf("no port negotiated for %s", unit)
+	}
+	_, front := service.frontPort()
+	frontPort, err := portNumber(front.Port)
+	if err != nil {
+		return err
+	}
+
+	desc := service.Description
+	if desc == "" {
+		desc = fmt.Sprintf("service %s for package %s", service.Name, m.Name)
+	}
+	socketFileName := generateProxySocketFileName(m, service)
+	versionSocketFileName := generateVersionProxySocketFileName(m, service)
+
+	if err := os.MkdirAll(dirs.SnapServicesDir, 0755); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(blueGreenSocketsDir(), 0755); err != nil {
+		return err
+	}
+
+	sysd := newSystemd(inter)
+	if err := writeProxy(sysd, desc, versionSocketFileName, generateVersionProxyServiceFileName(m, service),
+		stripGlobalRootDir(versionSocketPath(m, service)), fmt.Sprintf("127.0.0.1:%d", port)); err != nil {
+		return err
+	}
+	if err := writeProxy(sysd, desc, socketFileName, generateProxyServiceFileName(m, service),
+		strconv.Itoa(frontPort), stripGlobalRootDir(frontSocketPath(m, service))); err != nil {
+		return err
+	}
+
+	// the sockets are always enabled, like the services
+	for _, name := range []string{versionSocketFileName, socketFileName} {
+		if err := sysd.Enable(filepath.Base(name)); err != nil {
+			return err
+		}
+	}
+	if !inhibitHooks {
+		if err := sysd.DaemonReload(); err != nil {
+			return err
+		}
+		// this version listens before the front proxy forwards to it
+		for _, name := range []string{versionSocketFileName, socketFileName} {
+			if err := sysd.Start(filepath.Base(name)); err != nil {
+				return err
+			}
+		}
+	}
+
+	link := frontSocketPath(m, service)
+	tmp := link + ".new"
+	os.Remove(tmp)
+	if err := os.Symlink(filepath.Base(versionSocketPath(m, service)), tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	st.Fronts[proxyName(m, service)] = unit
+
+	return st.save()
+}
+
+// addProxy sets up the proxy of a blue/green service when the service
+// gets added; the proxy only moves over to this version right away if
+// no other version of the service is running (otherwise that happens
+// in switchBlueGreen, once this version is healthy)
+func (m *packageYaml) addProxy(service ServiceYaml, inhibitHooks bool, inter interacter) error {
+	st, err := readBlueGreenState()
+	if err != nil {
+		return err
+	}
+
+	front := st.Fronts[proxyName(m, service)]
+	if front != "" && front != serviceUnit(m, service) {
+		if _, err := os.Stat(filepath.Join(dirs.SnapServicesDir, front+".service")); err == nil {
+			return nil
+		}
+	}
+
+	return m.pointProxy(service, inhibitHooks, inter)
+}
+
+// removeUnits disables, stops and removes the given units
+func removeUnits(sysd systemd.Systemd, fileNames ...string) {
+	for _, fn := range fileNames {
+		name := filepath.Base(fn)
+		if err := sysd.Disable(name); err != nil {
+			logger.Noticef("Failed to disable %q: %v", name, err)
+		}
+		if err := sysd.Stop(name, proxyStopTimeout); err != nil {
+			logger.Noticef("Failed to stop %q: %v", name, err)
+		}
+		if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+			logger.Noticef("Failed to remove %q: %v", fn, err)
+		}
+	}
+}
+
+// removeProxy removes the proxy of this version of a blue/green
+// service (and the front proxy, if it forwards to this version), and
+// forgets the port of this version
+func (m *packageYaml) removeProxy(service ServiceYaml, inter interacter) error {
+	st, err := readBlueGreenState()
+	if err != nil {
+		return err
+	}
+
+	sysd := newSystemd(inter)
+	unit := serviceUnit(m, service)
+	delete(st.Ports, unit)
+	if st.Fronts[proxyName(m, service)] == unit {
+		delete(st.Fronts, proxyName(m, service))
+
+		removeUnits(sysd, generateProxySocketFileName(m, service), generateProxyServiceFileName(m, service))
+		if err := os.Remove(frontSocketPath(m, service)); err != nil && !os.IsNotExist(err) {
+			logger.Noticef("Failed to remove %q: %v", frontSocketPath(m, service), err)
+		}
+	}
+	removeUnits(sysd, generateVersionProxySocketFileName(m, service), generateVersionProxyServiceFileName(m, service))
+	if err := os.Remove(versionSocketPath(m, service)); err != nil && !os.IsNotExist(err) {
+		logger.Noticef("Failed to remove %q: %v", versionSocketPath(m, service), err)
+	}
+
+	return st.save()
+}
+
+// blueGreenHandover returns the services of the old version that keep
+// running while the new version starts: the ones that are blue/green
+// in both and that the proxy forwards to
+func blueGreenHandover(oldM, newM *packageYaml) map[string]bool {
+	st, err := readBlueGreenState()
+	if err != nil {
+		return nil
+	}
+
+	var keep map[string]bool
+	for _, service := range newM.ServiceYamls {
+		if !service.isBlueGreen() {
+			continue
+		}
+		for _, oldService := range oldM.ServiceYamls {
+			if oldService.Name != service.Name || !oldService.isBlueGreen() {
+				continue
+			}
+			if st.Fronts[proxyName(oldM, oldService)] != serviceUnit(oldM, oldService) {
+				continue
+			}
+			if keep == nil {
+				keep = make(map[string]bool)
+			}
+			keep[service.Name] = true
+		}
+	}
+
+	return keep
+}
+
+var errHealthCheckTimeout = errors.New("timed out")
+
+// healthCheckCmd returns the command that checks the health of the
+// service, confined like the service itself
+func healthCheckCmd(m *packageYaml, service ServiceYaml, baseDir string, ports map[string]int) (*exec.Cmd, error) {
+	argv := strings.Fields(service.HealthCheck)
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("empty health-check for %s", service.Name)
+	}
+
+	aaProfile, err := getSecurityProfile(m, service.Name, baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	origin := originFromBasedir(baseDir)
+	envData := struct {
+		AppName     string
+		AppArch     string
+		AppPath     string
+		Version     string
+		UdevAppName string
+		Origin      string
+	}{
+		AppName:     m.Name,
+		AppArch:     helpers.UbuntuArchitecture(),
+		AppPath:     baseDir,
+		Version:     m.Version,
+		UdevAppName: m.qualifiedName(origin),
+		Origin:      origin,
+	}
+
+	args := append([]string{envData.UdevAppName, aaProfile, filepath.Join(baseDir, argv[0])}, argv[1:]...)
+	cmd := exec.Command("ubuntu-core-launcher", args...)
+	cmd.Dir = baseDir
+	cmd.Env = append(os.Environ(), helpers.GetBasicSnapEnvVars(envData)...)
+	for name, port := range ports {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", systemd.PortEnvVar(name), port))
+	}
+
+	return cmd, nil
+}
+
+// waitHealthy runs the health check of the service through the given
+// Backend until it passes, or its timeout is up
+func (s *SnapPart) waitHealthy(service ServiceYaml, backend Backend) error {
+	timeout := time.Duration(service.HealthCheckTimeout)
+	if timeout == 0 {
+		timeout = time.Duration(DefaultHealthCheckTimeout)
+	}
+	deadline := time.Now().Add(timeout)
+
+	ports, err := negotiatedPorts(s.m, service)
+	if err != nil {
+		return err
+	}
+
+	for {
+		cmd, err := healthCheckCmd(s.m, service, s.basedir, ports)
+		if err != nil {
+			return err
+		}
+		output, err := backend.RunSelfTest(cmd, deadline.Sub(time.Now()))
+		if err == nil {
+			return nil
+		}
+		if err == errSelfTestTimeout || time.Now().Add(healthCheckInterval).After(deadline) {
+			if err == errSelfTestTimeout {
+				err = errHealthCheckTimeout
+			}
+			return &ErrHealthCheckFailed{Service: service.Name, Output: string(output), Err: err}
+		}
+		time.Sleep(healthCheckInterval)
+	}
+}
+
+// switchBlueGreen moves the blue/green services the old version kept
+// running over to this version: once the new service is healthy the
+// proxy forwards to it and the old one is stopped. Nothing is left to
+// switch if the old version kept no service running (or the hooks are
+// inhibited)
+func (s *SnapPart) switchBlueGreen(oldPart *SnapPart, inhibitHooks bool, inter interacter) error {
+	if oldPart == nil || inhibitHooks || len(oldPart.keepServices) == 0 {
+		return nil
+	}
+
+	sysd := newSystemd(inter)
+	switched := 0
+	for _, service := range s.m.ServiceYamls {
+		if !oldPart.keepServices[service.Name] {
+			continue
+		}
+
+		if err := s.waitHealthy(service, backendOf(inter)); err != nil {
+			return err
+		}
+		if err := s.m.pointProxy(service, false, inter); err != nil {
+			return err
+		}
+
+		// the old one is not needed anymore
+		for _, oldService := range oldPart.m.ServiceYamls {
+			if oldService.Name != service.Name {
+				continue
+			}
+			oldName := filepath.Base(generateServiceFileName(oldPart.m, oldService))
+			if err := oldPart.m.stopService(oldName, oldService, inter); err != nil {
+				return err
+			}
+			if err := sysd.Disable(oldName); err != nil {
+				return err
+			}
+			if err := os.Remove(generateServiceFileName(oldPart.m, oldService)); err != nil && !os.IsNotExist(err) {
+				logger.Noticef("Failed to remove service file for %q: %v", oldName, err)
+			}
+			if err := oldPart.m.removeProxy(oldService, inter); err != nil {
+				return err
+			}
+		}
+		delete(oldPart.keepServices, service.Name)
+		switched++
+
+		progress.NotifyMessage(inter, progress.NewMessage(progress.MsgBlueGreenSwitched, service.Name, s.Version()))
+	}
+
+	if switched == 0 {
+		return nil
+	}
+
+	return sysd.DaemonReload()
+}
@@ -0,0 +1,276 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+const packageBlueGreen = `name: web
+version: %s
+vendor: foo
+services:
+ - name: ui
+   start: bin/ui
+   upgrade-mode: blue-green
+   health-check: bin/ui-check
+   ports:
+     external:
+       http:
+         port: 8080/tcp
+         negotiable: yes
+`
+
+// mockBlueGreen installs the two versions of a snap with a blue/green
+// service, records the systemctl calls and hands out ports from 40000
+func (s *SnapTestSuite) mockBlueGreen(c *C) (v1, v2 *SnapPart, systemctl *[]string) {
+	var calls []string
+	s.backend.systemctl = func(args ...string) ([]byte, error) {
+		calls = append(calls, strings.Join(args, " "))
+		return []byte("ActiveState=inactive\n"), nil
+	}
+
+	port := 40000
+	freePort = func() (int, error) {
+		port++
+		return port, nil
+	}
+
+	parts := make([]*SnapPart, 2)
+	for i, version := range []string{"1.0", "2.0"} {
+		yamlFile, err := makeInstalledMockSnap(s.tempdir, strings.Replace(packageBlueGreen, "%s", version, 1))
+		c.Assert(err, IsNil)
+		parts[i], err = NewInstalledSnapPart(yamlFile, testOrigin)
+		c.Assert(err, IsNil)
+	}
+
+	return parts[0], parts[1], &calls
+}
+
+func (s *SnapTestSuite) TestVerifyUpgradeMode(c *C) {
+	negotiable := &Ports{External: map[string]Port{"http": {Port: "8080/tcp", Negotiable: true}}}
+	fixed := &Ports{External: map[string]Port{"http": {Port: "8080/tcp"}}}
+
+	for _, t := range []struct {
+		service ServiceYaml
+		err     string
+	}{
+		{ServiceYaml{Name: "ui"}, ""},
+		{ServiceYaml{Name: "ui", UpgradeMode: "stop-start"}, ""},
+		{ServiceYaml{Name: "ui", UpgradeMode: "blue-green", HealthCheck: "bin/check", Ports: negotiable}, ""},
+		{ServiceYaml{Name: "ui", UpgradeMode: "red-black"}, `invalid upgrade-mode "red-black" for service ui`},
+		{ServiceYaml{Name: "ui", UpgradeMode: "blue-green", Ports: negotiable}, `blue-green service ui needs a health-check`},
+		{ServiceYaml{Name: "ui", UpgradeMode: "blue-green", HealthCheck: "bin/check", Ports: negotiable, Socket: true}, `blue-green service ui can not be socket activated`},
+		{ServiceYaml{Name: "ui", UpgradeMode: "blue-green", HealthCheck: "bin/check"}, `blue-green service ui needs exactly one external port`},
+		{ServiceYaml{Name: "ui", UpgradeMode: "blue-green", HealthCheck: "bin/check", Ports: fixed}, `the port http of blue-green service ui is not negotiable`},
+		{ServiceYaml{Name: "ui", UpgradeMode: "blue-green", HealthCheck: "bin/check", Ports: &Ports{External: map[string]Port{"http": {Port: "http", Negotiable: true}}}}, `invalid port "http"`},
+	} {
+		err := verifyUpgradeMode(t.service)
+		if t.err == "" {
+			c.Check(err, IsNil)
+		} else {
+			c.Check(err, ErrorMatches, t.err)
+		}
+	}
+}
+
+func (s *SnapTestSuite) TestBlueGreenServiceFileHasNegotiatedPort(c *C) {
+	v1, _, _ := s.mockBlueGreen(c)
+
+	service := v1.m.ServiceYamls[0]
+	content, err := generateSnapServicesFile(service, v1.basedir, "aa", v1.m)
+	c.Assert(err, IsNil)
+	c.Check(content, Matches, `(?s).*"SNAP_PORT_HTTP=40001"\n.*`)
+
+	// the port sticks
+	content, err = generateSnapServicesFile(service, v1.basedir, "aa", v1.m)
+	c.Assert(err, IsNil)
+	c.Check(content, Matches, `(?s).*"SNAP_PORT_HTTP=40001"\n.*`)
+}
+
+func (s *SnapTestSuite) TestBlueGreenFirstInstallPointsProxy(c *C) {
+	v1, _, systemctl := s.mockBlueGreen(c)
+
+	c.Assert(v1.m.addPackageServices(v1.basedir, false, s.meter()), IsNil)
+
+	// the front proxy forwards to the socket the link points to
+	content, err := ioutil.ReadFile(filepath.Join(dirs.SnapServicesDir, "web_ui.proxy.service"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Matches, `(?s).*ExecStart=/lib/systemd/systemd-socket-proxyd /var/lib/snappy/blue-green/web_ui.sock\n`)
+	content, err = ioutil.ReadFile(filepath.Join(dirs.SnapServicesDir, "web_ui.proxy.socket"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Matches, `(?s).*ListenStream=8080\n.*`)
+	target, err := os.Readlink(filepath.Join(blueGreenSocketsDir(), "web_ui.sock"))
+	c.Assert(err, IsNil)
+	c.Check(target, Equals, "web_ui_1.0.sock")
+
+	// and the one of the version to its port
+	content, err = ioutil.ReadFile(filepath.Join(dirs.SnapServicesDir, "web_ui_1.0.proxy.service"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Matches, `(?s).*ExecStart=/lib/systemd/systemd-socket-proxyd 127.0.0.1:40001\n`)
+	content, err = ioutil.ReadFile(filepath.Join(dirs.SnapServicesDir, "web_ui_1.0.proxy.socket"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Matches, `(?s).*ListenStream=/var/lib/snappy/blue-green/web_ui_1.0.sock\n.*`)
+
+	c.Check(*systemctl, DeepEquals, []string{
+		"daemon-reload",
+		"start web_ui_1.0.service",
+		"daemon-reload",
+		"start web_ui_1.0.proxy.socket",
+		"start web_ui.proxy.socket",
+	})
+
+	// and it goes away with the service
+	c.Assert(v1.m.removePackageServices(v1.basedir, s.meter()), IsNil)
+	for _, name := range []string{"web_ui.proxy.service", "web_ui.proxy.socket", "web_ui_1.0.proxy.service", "web_ui_1.0.proxy.socket"} {
+		_, err = os.Stat(filepath.Join(dirs.SnapServicesDir, name))
+		c.Check(os.IsNotExist(err), Equals, true, Commentf(name))
+	}
+	_, err = os.Lstat(filepath.Join(blueGreenSocketsDir(), "web_ui.sock"))
+	c.Check(os.IsNotExist(err), Equals, true)
+	st, err := readBlueGreenState()
+	c.Assert(err, IsNil)
+	c.Check(st.Ports, HasLen, 0)
+	c.Check(st.Fronts, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestBlueGreenSwitch(c *C) {
+	v1, v2, systemctl := s.mockBlueGreen(c)
+
+	var checked []string
+	s.backend.runSelfTest = func(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+		checked = append(checked, strings.Join(cmd.Args, " "))
+		for _, env := range cmd.Env {
+			if env == "SNAP_PORT_HTTP=40002" {
+				return nil, nil
+			}
+		}
+		return nil, errors.New("wrong port")
+	}
+
+	c.Assert(v1.m.addPackageServices(v1.basedir, false, s.meter()), IsNil)
+
+	// the old version keeps running while the new one starts
+	v1.keepServices = blueGreenHandover(v1.m, v2.m)
+	c.Check(v1.keepServices, DeepEquals, map[string]bool{"ui": true})
+	c.Assert(v1.m.removePackageServicesKeeping(v1.basedir, v1.keepServices, s.meter()), IsNil)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapServicesDir, "web_ui_1.0.service")), Equals, true)
+
+	*systemctl = nil
+	c.Assert(v2.m.addPackageServices(v2.basedir, false, s.meter()), IsNil)
+	st, err := readBlueGreenState()
+	c.Assert(err, IsNil)
+	c.Check(st.Fronts, DeepEquals, map[string]string{"web_ui": "web_ui_1.0"})
+
+	inter := s.meter()
+	c.Assert(v2.switchBlueGreen(v1, false, inter), IsNil)
+	c.Check(checked, HasLen, 1)
+	c.Check(checked[0], Matches, `ubuntu-core-launcher web.* .*/apps/web.*/2.0/bin/ui-check`)
+
+	st, err = readBlueGreenState()
+	c.Assert(err, IsNil)
+	c.Check(st.Fronts, DeepEquals, map[string]string{"web_ui": "web_ui_2.0"})
+	c.Check(st.Ports, DeepEquals, map[string]int{"web_ui_2.0": 40002})
+
+	// the front proxy now forwards to the new version
+	target, err := os.Readlink(filepath.Join(blueGreenSocketsDir(), "web_ui.sock"))
+	c.Assert(err, IsNil)
+	c.Check(target, Equals, "web_ui_2.0.sock")
+	content, err := ioutil.ReadFile(filepath.Join(dirs.SnapServicesDir, "web_ui_2.0.proxy.service"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Matches, `(?s).*127.0.0.1:40002\n`)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapServicesDir, "web_ui_1.0.service")), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapServicesDir, "web_ui_1.0.proxy.service")), Equals, false)
+
+	// the new version listens before the old one stops, and the front
+	// proxy does not restart
+	calls := strings.Join(*systemctl, "\n")
+	c.Check(strings.Index(calls, "start web_ui_2.0.proxy.socket") >= 0, Equals, true)
+	c.Check(strings.Index(calls, "start web_ui_2.0.proxy.socket") < strings.Index(calls, "stop web_ui_1.0.service"), Equals, true)
+	c.Check(calls, Not(Matches), `(?s).*(stop|restart) web_ui.proxy.*`)
+	c.Check(inter.notified[len(inter.notified)-1], Equals, "Switched ui over to version 2.0")
+}
+
+func (s *SnapTestSuite) TestBlueGreenSwitchNothingKept(c *C) {
+	v1, v2, systemctl := s.mockBlueGreen(c)
+	s.backend.runSelfTest = func(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+		c.Fatal("no health check should run")
+		return nil, nil
+	}
+
+	// no daemon-reload either
+	c.Assert(v2.switchBlueGreen(v1, false, s.meter()), IsNil)
+	c.Check(*systemctl, HasLen, 0)
+
+	v1.keepServices = blueGreenHandover(v1.m, v2.m)
+	c.Assert(v2.switchBlueGreen(v1, true, s.meter()), IsNil)
+	c.Check(*systemctl, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestBlueGreenSwitchUnhealthy(c *C) {
+	v1, v2, _ := s.mockBlueGreen(c)
+
+	oldTimeout, oldInterval := DefaultHealthCheckTimeout, healthCheckInterval
+	DefaultHealthCheckTimeout, healthCheckInterval = Timeout(50*time.Millisecond), 10*time.Millisecond
+	defer func() { DefaultHealthCheckTimeout, healthCheckInterval = oldTimeout, oldInterval }()
+
+	tries := 0
+	s.backend.runSelfTest = func(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+		tries++
+		return []byte("503"), errors.New("exit status 1")
+	}
+
+	c.Assert(v1.m.addPackageServices(v1.basedir, false, s.meter()), IsNil)
+	v1.keepServices = blueGreenHandover(v1.m, v2.m)
+	c.Assert(v1.m.removePackageServicesKeeping(v1.basedir, v1.keepServices, s.meter()), IsNil)
+	c.Assert(v2.m.addPackageServices(v2.basedir, false, s.meter()), IsNil)
+
+	err := v2.switchBlueGreen(v1, false, s.meter())
+	c.Assert(err, FitsTypeOf, &ErrHealthCheckFailed{})
+	c.Check(err.(*ErrHealthCheckFailed).Output, Equals, "503")
+	c.Check(tries > 1, Equals, true)
+
+	// the old version still gets the traffic
+	st, err := readBlueGreenState()
+	c.Assert(err, IsNil)
+	c.Check(st.Fronts, DeepEquals, map[string]string{"web_ui": "web_ui_1.0"})
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapServicesDir, "web_ui_1.0.service")), Equals, true)
+}
+
+func (s *SnapTestSuite) TestBlueGreenHandoverNeedsBlueGreenOld(c *C) {
+	v1, v2, _ := s.mockBlueGreen(c)
+
+	// nothing runs yet
+	c.Check(blueGreenHandover(v1.m, v2.m), IsNil)
+
+	v1.m.ServiceYamls[0].UpgradeMode = ""
+	c.Check(blueGreenHandover(v1.m, v2.m), IsNil)
+}
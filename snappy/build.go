@@ -33,6 +33,8 @@ import (
 	"text/template"
 
 	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/pkg"
 	"github.com/ubuntu-core/snappy/pkg/clickdeb"
 	"github.com/ubuntu-core/snappy/pkg/snapfs"
 
@@ -205,24 +207,47 @@ func handleServices(buildDir string, m *packageYaml) error {
 	return nil
 }
 
-func handleConfigHookApparmor(buildDir string, m *packageYaml) error {
-	configHookFile := filepath.Join(buildDir, "meta", "hooks", "config")
-	if !helpers.FileExists(configHookFile) {
-		return nil
-	}
+// the hooks in meta/hooks that snappy runs confined, and the name of
+// their integration
+var snappyHooks = []struct {
+	file string
+	name string
+}{
+	{"config", "snappy-config"},
+	{"export-data", "snappy-export-data"},
+}
 
-	hookName := "snappy-config"
-	s := &SecurityDefinitions{}
-	content, err := s.generateApparmorJSONContent()
-	if err != nil {
-		return err
+func handleSnappyHooksApparmor(buildDir string, m *packageYaml) error {
+	for _, hook := range snappyHooks {
+		if !helpers.FileExists(filepath.Join(buildDir, "meta", "hooks", hook.file)) {
+			continue
+		}
+
+		s := &SecurityDefinitions{}
+		content, err := s.generateApparmorJSONContent()
+		if err != nil {
+			return err
+		}
+		hookApparmorJSONFile := filepath.Join("meta", hook.name+".apparmor")
+		if err := ioutil.WriteFile(filepath.Join(buildDir, hookApparmorJSONFile), content, 0644); err != nil {
+			return err
+		}
+		m.Integration[hook.name] = make(map[string]string)
+		m.Integration[hook.name]["apparmor"] = hookApparmorJSONFile
 	}
-	configApparmorJSONFile := filepath.Join("meta", hookName+".apparmor")
-	if err := ioutil.WriteFile(filepath.Join(buildDir, configApparmorJSONFile), content, 0644); err != nil {
-		return err
+
+	// and those the package.yaml declares
+	for hook := range m.hooks() {
+		s := &SecurityDefinitions{}
+		content, err := s.generateApparmorJSONContent()
+		if err != nil {
+			return err
+		}
+		hookApparmorJSONFile := filepath.Join("meta", hookIntegration(hook)+".apparmor")
+		if err := ioutil.WriteFile(filepath.Join(buildDir, hookApparmorJSONFile), content, 0644); err != nil {
+			return err
+		}
 	}
-	m.Integration[hookName] = make(map[string]string)
-	m.Integration[hookName]["apparmor"] = configApparmorJSONFile
 
 	return nil
 }
@@ -389,6 +414,7 @@ func writeClickManifest(buildDir string, m *packageYaml) error {
 		Architecture:  m.Architectures,
 		Framework:     m.FrameworksForClick(),
 		Type:          m.Type,
+		Confinement:   m.Confinement,
 		Icon:          m.Icon,
 		InstalledSize: installedSize,
 		Title:         title,
@@ -498,6 +524,10 @@ func prepare(sourceDir, targetDir, buildDir string) (snapName string, err error)
 		return "", err
 	}
 
+	if m.Type == pkg.TypeOem {
+		logger.Noticef(`Use of deprecated "oem" type in yaml, use "gadget" (with a "gadget" stanza) instead`)
+	}
+
 	if m.ExplicitLicenseAgreement {
 		if err := licenseChecker(sourceDir); err != nil {
 			return "", err
@@ -508,6 +538,10 @@ func prepare(sourceDir, targetDir, buildDir string) (snapName string, err error)
 		return "", err
 	}
 
+	if err := verifyArchVariants(sourceDir, m); err != nil {
+		return "", err
+	}
+
 	if err := copyToBuildDir(sourceDir, buildDir); err != nil {
 		return "", err
 	}
@@ -522,8 +556,8 @@ func prepare(sourceDir, targetDir, buildDir string) (snapName string, err error)
 		return "", err
 	}
 
-	// generate config hook apparmor
-	if err := handleConfigHookApparmor(buildDir, m); err != nil {
+	// generate config (and export-data) hook apparmor
+	if err := handleSnappyHooksApparmor(buildDir, m); err != nil {
 		return "", err
 	}
 
@@ -0,0 +1,181 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+)
+
+// downloadCacheSize is how many snaps the download cache keeps, enough
+// to reinstall (or roll back to) the last few without downloading them
+// again; the snaps the active ones were installed from are kept on top
+// of those, the deltas apply to them
+const downloadCacheSize = 5
+
+var errNotCached = errors.New("snap not in the download cache")
+
+// cachePath returns the path of the snap with the given sha512 in the
+// download cache
+func cachePath(sha512 string) string {
+	return filepath.Join(dirs.SnapCacheDir, sha512)
+}
+
+// linkOrCopy hard links src to dst, or copies it if it cannot (e.g.
+// across filesystems)
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	return helpers.CopyFile(src, dst, helpers.CopyFlagOverwrite)
+}
+
+// fromCache returns a copy (for the caller to remove) of the snap from
+// the download cache, after checking it is still what the store says it
+// is
+func (s *RemoteSnapPart) fromCache() (string, error) {
+	sha512 := s.pkg.DownloadSha512
+	if sha512 == "" {
+		return "", errNotCached
+	}
+
+	cached := cachePath(sha512)
+	if !helpers.FileExists(cached) {
+		return "", errNotCached
+	}
+
+	got, err := helpers.Sha512sum(cached)
+	if err != nil {
+		return "", err
+	}
+	if got != sha512 {
+		os.Remove(cached)
+		return "", &ErrHashMismatch{Snap: s.Name(), Expected: sha512, Got: got}
+	}
+
+	if err := os.MkdirAll(dirs.SnapDownloadsDir, 0700); err != nil {
+		return "", err
+	}
+
+	w, err := ioutil.TempFile(dirs.SnapDownloadsDir, s.pkg.Name)
+	if err != nil {
+		return "", err
+	}
+	fn := w.Name()
+	w.Close()
+	os.Remove(fn)
+
+	if err := linkOrCopy(cached, fn); err != nil {
+		return "", err
+	}
+
+	// the most recently used are the last to be pruned
+	now := time.Now()
+	os.Chtimes(cached, now, now)
+
+	return fn, nil
+}
+
+// addToCache adds the downloaded (and verified) snap to the download
+// cache, pruning the least recently used ones
+func (s *RemoteSnapPart) addToCache(fn string) error {
+	sha512 := s.pkg.DownloadSha512
+	if sha512 == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(dirs.SnapCacheDir, 0700); err != nil {
+		return err
+	}
+
+	cached := cachePath(sha512)
+	if !helpers.FileExists(cached) {
+		if err := linkOrCopy(fn, cached); err != nil {
+			os.Remove(cached)
+			return err
+		}
+	}
+
+	return pruneCache(downloadCacheSize)
+}
+
+type byModTime []os.FileInfo
+
+func (a byModTime) Len() int           { return len(a) }
+func (a byModTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a byModTime) Less(i, j int) bool { return a[i].ModTime().Before(a[j].ModTime()) }
+
+// pruneCache removes the least recently used snaps from the download
+// cache until it holds at most keep of them (besides the delta bases
+// of the active snaps)
+func pruneCache(keep int) error {
+	entries, err := ioutil.ReadDir(dirs.SnapCacheDir)
+	if err != nil {
+		return err
+	}
+
+	bases := activeDeltaBases()
+	var prunable []os.FileInfo
+	for _, fi := range entries {
+		if !bases[fi.Name()] {
+			prunable = append(prunable, fi)
+		}
+	}
+	if len(prunable) <= keep {
+		return nil
+	}
+
+	sort.Sort(byModTime(prunable))
+	for _, fi := range prunable[:len(prunable)-keep] {
+		if err := os.Remove(filepath.Join(dirs.SnapCacheDir, fi.Name())); err != nil {
+			logger.Noticef("Failed to prune %q from the download cache: %v", fi.Name(), err)
+		}
+	}
+
+	return nil
+}
+
+// activeDeltaBases returns the names (the sha512) of the download cache
+// entries the active snaps were installed from
+func activeDeltaBases() map[string]bool {
+	bases := make(map[string]bool)
+
+	installed, err := NewMetaLocalRepository().Installed()
+	if err != nil {
+		logger.Noticef("Failed to get the installed snaps: %v", err)
+		return bases
+	}
+	for _, part := range installed {
+		if sha512 := downloadSha512(part); part.IsActive() && sha512 != "" {
+			bases[sha512] = true
+		}
+	}
+
+	return bases
+}
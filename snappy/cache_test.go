@@ -0,0 +1,110 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+)
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadFillsCache(c *C) {
+	var ranges []string
+	mockServer := mockResumableStore(0, &ranges)
+	defer mockServer.Close()
+
+	snap := s.resumableSnapPart(mockServer.URL + "/snap")
+	fn, err := snap.Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	defer os.Remove(fn)
+
+	content, err := ioutil.ReadFile(cachePath(snap.pkg.DownloadSha512))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, resumableSnap)
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadFromCache(c *C) {
+	var ranges []string
+	mockServer := mockResumableStore(0, &ranges)
+	defer mockServer.Close()
+
+	snap := s.resumableSnapPart(mockServer.URL + "/snap")
+	c.Assert(os.MkdirAll(dirs.SnapCacheDir, 0700), IsNil)
+	c.Assert(ioutil.WriteFile(cachePath(snap.pkg.DownloadSha512), []byte(resumableSnap), 0600), IsNil)
+
+	fn, err := snap.Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	c.Check(ranges, HasLen, 0)
+
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, resumableSnap)
+
+	// what the caller removes is not the cache
+	c.Assert(os.Remove(fn), IsNil)
+	content, err = ioutil.ReadFile(cachePath(snap.pkg.DownloadSha512))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, resumableSnap)
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadCorruptCache(c *C) {
+	var ranges []string
+	mockServer := mockResumableStore(0, &ranges)
+	defer mockServer.Close()
+
+	snap := s.resumableSnapPart(mockServer.URL + "/snap")
+	c.Assert(os.MkdirAll(dirs.SnapCacheDir, 0700), IsNil)
+	c.Assert(ioutil.WriteFile(cachePath(snap.pkg.DownloadSha512), []byte("corrupt"), 0600), IsNil)
+
+	fn, err := snap.Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	defer os.Remove(fn)
+	c.Check(ranges, HasLen, 1)
+
+	content, err := ioutil.ReadFile(cachePath(snap.pkg.DownloadSha512))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, resumableSnap)
+}
+
+func (s *SnapTestSuite) TestPruneCache(c *C) {
+	c.Assert(os.MkdirAll(dirs.SnapCacheDir, 0700), IsNil)
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		fn := filepath.Join(dirs.SnapCacheDir, fmt.Sprint(i))
+		c.Assert(ioutil.WriteFile(fn, nil, 0600), IsNil)
+		mtime := now.Add(time.Duration(i) * time.Minute)
+		c.Assert(os.Chtimes(fn, mtime, mtime), IsNil)
+	}
+
+	c.Assert(pruneCache(2), IsNil)
+
+	entries, err := filepath.Glob(filepath.Join(dirs.SnapCacheDir, "*"))
+	c.Assert(err, IsNil)
+	c.Check(entries, DeepEquals, []string{
+		filepath.Join(dirs.SnapCacheDir, "2"),
+		filepath.Join(dirs.SnapCacheDir, "3"),
+	})
+}
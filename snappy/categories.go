@@ -0,0 +1,159 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Category is a department of the store (e.g. "Games")
+type Category struct {
+	// Name is the (human readable) name of the department
+	Name string `json:"name"`
+	// Slug identifies the department in SearchByCategory
+	Slug string `json:"slug"`
+	// HasChildren is true if the department has sub-departments
+	HasChildren bool `json:"has_children"`
+}
+
+type departmentsResults struct {
+	Payload struct {
+		Departments []Category `json:"clickindex:department"`
+	} `json:"_embedded"`
+}
+
+// SearchSort is the order of the results of a search
+type SearchSort string
+
+// the orders of the results of a search
+const (
+	// SortByRelevance is the default order of the store
+	SortByRelevance SearchSort = ""
+	// SortByLastUpdated puts the last updated snaps first
+	SortByLastUpdated SearchSort = "last_updated"
+	// SortByRating puts the best rated snaps first
+	SortByRating SearchSort = "ratings_average"
+	// SortByName sorts the snaps alphabetically
+	SortByName SearchSort = "name"
+)
+
+// searchSortParams are the values of the sort parameter of the search
+// of the store for each SearchSort
+var searchSortParams = map[SearchSort]string{
+	SortByLastUpdated: "last_updated:desc",
+	SortByRating:      "ratings_average:desc",
+	SortByName:        "name:asc",
+}
+
+// SearchOptions narrow down a SearchWithOptions or a SearchByCategory
+type SearchOptions struct {
+	// Query is an (optional) search term
+	Query string
+	// Page is the page of the results to return (starting at 1, 0
+	// for the store's default)
+	Page int
+	// Size is how many results a page has (0 for the store's default)
+	Size int
+	// Sort is the order of the results (see SharedNames.Names)
+	Sort SearchSort
+}
+
+// setQuery sets the page, size and sort parameters of the options in q
+func (opts *SearchOptions) setQuery(q url.Values) error {
+	if opts.Page > 0 {
+		q.Set("page", strconv.Itoa(opts.Page))
+	}
+	if opts.Size > 0 {
+		q.Set("size", strconv.Itoa(opts.Size))
+	}
+	if opts.Sort != SortByRelevance {
+		param, ok := searchSortParams[opts.Sort]
+		if !ok {
+			return fmt.Errorf("invalid search order %q", opts.Sort)
+		}
+		q.Set("sort", param)
+	}
+
+	return nil
+}
+
+// Categories returns the departments of the store
+func (s *SnapUbuntuStoreRepository) Categories() ([]Category, error) {
+	req, err := newStoreRequest(s.ctx, "GET", s.departmentsURI.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// set headers
+	setStoreConfigHeaders(req)
+	setUbuntuStoreHeaders(req, s.auth)
+	setDeviceIdentityHeaders(req)
+	acceptStoreAPIs(req, storeAPIv1)
+
+	client := s.trace.httpClient(s.timeout, s.proxy, s.tlsConfig)
+	resp, err := doStoreRequest(client, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := storeError(resp, "departments"); err != nil {
+		return nil, err
+	}
+
+	return decodeDepartments(resp)
+}
+
+// SearchByCategory searches the given department (the Slug of a
+// Category) of the store
+func (s *SnapUbuntuStoreRepository) SearchByCategory(dept string, opts SearchOptions) (SharedNames, error) {
+	if dept == "" || strings.ContainsAny(dept, " ,:") {
+		return nil, fmt.Errorf("invalid category %q", dept)
+	}
+
+	// a copy, so the other searches are not affected
+	searchURI := *s.searchURI
+	terms := []string{"department:" + dept}
+	if opts.Query != "" {
+		terms = append(terms, opts.Query)
+	}
+
+	q := searchURI.Query()
+	q.Set("q", strings.Join(terms, " "))
+	if err := opts.setQuery(q); err != nil {
+		return nil, err
+	}
+	searchURI.RawQuery = q.Encode()
+
+	return s.search(&searchURI)
+}
+
+// Categories returns the departments of the store
+func Categories() ([]Category, error) {
+	return NewUbuntuStoreSnapRepository().Categories()
+}
+
+// SearchByCategory searches the given department of the store
+func SearchByCategory(dept string, opts SearchOptions) (SharedNames, error) {
+	return NewUbuntuStoreSnapRepository().SearchByCategory(dept, opts)
+}
@@ -0,0 +1,134 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	. "gopkg.in/check.v1"
+)
+
+/* acquired via:
+curl -s -H 'accept: application/hal+json' "https://search.apps.ubuntu.com/api/v1/departments" | python -m json.tool
+*/
+const MockDepartmentsJSON = `{
+    "_embedded": {
+        "clickindex:department": [
+            {
+                "_links": {
+                    "self": {
+                        "href": "https://search.apps.ubuntu.com/api/v1/departments/food-drink"
+                    }
+                },
+                "has_children": false,
+                "name": "Food & Drink",
+                "slug": "food-drink"
+            },
+            {
+                "_links": {
+                    "self": {
+                        "href": "https://search.apps.ubuntu.com/api/v1/departments/games"
+                    }
+                },
+                "has_children": true,
+                "name": "Games",
+                "slug": "games"
+            }
+        ]
+    },
+    "_links": {
+        "self": {
+            "href": "https://search.apps.ubuntu.com/api/v1/departments"
+        }
+    }
+}
+`
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryCategories(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, Equals, "/departments")
+		io.WriteString(w, MockDepartmentsJSON)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	var err error
+	storeDepartmentsURI, err = url.Parse(mockServer.URL + "/departments")
+	c.Assert(err, IsNil)
+	snap := NewUbuntuStoreSnapRepository()
+	c.Assert(snap, NotNil)
+
+	categories, err := snap.Categories()
+	c.Assert(err, IsNil)
+	c.Check(categories, DeepEquals, []Category{
+		{Name: "Food & Drink", Slug: "food-drink"},
+		{Name: "Games", Slug: "games", HasChildren: true},
+	})
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryCategoriesError(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	var err error
+	storeDepartmentsURI, err = url.Parse(mockServer.URL + "/departments")
+	c.Assert(err, IsNil)
+
+	_, err = NewUbuntuStoreSnapRepository().Categories()
+	c.Assert(err, ErrorMatches, ".*unexpected http statusCode 500.*")
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositorySearchByCategory(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Query().Get("q"), Equals, "department:food-drink hello")
+		c.Check(r.URL.Query().Get("page"), Equals, "2")
+		c.Check(r.URL.Query().Get("size"), Equals, "")
+		c.Check(r.URL.Query().Get("sort"), Equals, "name:asc")
+		c.Check(r.URL.Query().Get("fields"), Equals, "package_name")
+		io.WriteString(w, MockSearchJSON)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	var err error
+	storeSearchURI, err = url.Parse(mockServer.URL + "/search?fields=package_name")
+	c.Assert(err, IsNil)
+	snap := NewUbuntuStoreSnapRepository()
+	c.Assert(snap, NotNil)
+
+	results, err := snap.SearchByCategory("food-drink", SearchOptions{Query: "hello", Page: 2, Sort: SortByName})
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[funkyAppName].Parts, HasLen, 1)
+
+	// the search URI of the repository is left alone
+	c.Check(snap.searchURI.Query().Get("q"), Equals, "")
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositorySearchByCategoryInvalid(c *C) {
+	_, err := NewUbuntuStoreSnapRepository().SearchByCategory("food drink", SearchOptions{})
+	c.Assert(err, ErrorMatches, `invalid category "food drink"`)
+}
@@ -48,6 +48,7 @@ import (
 	"github.com/ubuntu-core/snappy/pkg"
 	"github.com/ubuntu-core/snappy/pkg/clickdeb"
 	"github.com/ubuntu-core/snappy/progress"
+	"github.com/ubuntu-core/snappy/release"
 	"github.com/ubuntu-core/snappy/systemd"
 
 	"github.com/mvo5/goconfigparser"
@@ -60,6 +61,7 @@ type clickManifest struct {
 	Version       string                  `json:"version"`
 	Architecture  []string                `json:"architecture,omitempty"`
 	Type          pkg.Type                `json:"type,omitempty"`
+	Confinement   pkg.Confinement         `json:"confinement,omitempty"`
 	Framework     string                  `json:"framework,omitempty"`
 	Description   string                  `json:"description,omitempty"`
 	Icon          string                  `json:"icon,omitempty"`
@@ -186,7 +188,7 @@ type iterHooksFunc func(src, dst string, systemHook clickHook) error
 
 // iterHooks will run the callback "f" for the given manifest
 // so that the call back can arrange e.g. a new link
-func iterHooks(m *packageYaml, origin string, inhibitHooks bool, f iterHooksFunc) error {
+func iterHooks(m *packageYaml, origin string, inhibitHooks bool, backend Backend, f iterHooksFunc) error {
 	systemHooks, err := systemClickHooks()
 	if err != nil {
 		return err
@@ -200,6 +202,11 @@ func iterHooks(m *packageYaml, origin string, inhibitHooks bool, f iterHooksFunc
 			if ignoreHooks[hookName] {
 				continue
 			}
+			// the AppArmor profiles are only generated where
+			// AppArmor is the MAC backend
+			if hookName == "apparmor" && backend.MACSystem() != "apparmor" {
+				continue
+			}
 
 			systemHook, ok := systemHooks[hookName]
 			if !ok {
@@ -232,8 +239,8 @@ func iterHooks(m *packageYaml, origin string, inhibitHooks bool, f iterHooksFunc
 	return nil
 }
 
-func installClickHooks(targetDir string, m *packageYaml, origin string, inhibitHooks bool) error {
-	return iterHooks(m, origin, inhibitHooks, func(src, dst string, systemHook clickHook) error {
+func installClickHooks(targetDir string, m *packageYaml, origin string, inhibitHooks bool, backend Backend) error {
+	return iterHooks(m, origin, inhibitHooks, backend, func(src, dst string, systemHook clickHook) error {
 		// setup the new link target here, iterHooks will take
 		// care of running the hook
 		realSrc := stripGlobalRootDir(filepath.Join(targetDir, src))
@@ -245,8 +252,8 @@ func installClickHooks(targetDir string, m *packageYaml, origin string, inhibitH
 	})
 }
 
-func removeClickHooks(m *packageYaml, origin string, inhibitHooks bool) (err error) {
-	return iterHooks(m, origin, inhibitHooks, func(src, dst string, systemHook clickHook) error {
+func removeClickHooks(m *packageYaml, origin string, inhibitHooks bool, backend Backend) (err error) {
+	return iterHooks(m, origin, inhibitHooks, backend, func(src, dst string, systemHook clickHook) error {
 		// nothing we need to do here, the iterHookss will remove
 		// the hook symlink and call the hook itself
 		return nil
@@ -279,7 +286,7 @@ func generateBinaryName(m *packageYaml, binary Binary) string {
 }
 
 func binPathForBinary(pkgPath string, binary Binary) string {
-	return filepath.Join(pkgPath, binary.Exec)
+	return filepath.Join(pkgPath, archSpecificCommand(pkgPath, binary.Exec))
 }
 
 func verifyBinariesYaml(binary Binary) error {
@@ -302,11 +309,13 @@ export SNAPP_OLD_PWD="$(pwd)"
 
 # app info
 {{.NewAppVars}}
-
+{{if .ExportedPath}}export PATH="{{.ExportedPath}}:$PATH"
+{{end}}
 if [ ! -d "$SNAP_APP_USER_DATA_PATH" ]; then
-   mkdir -p "$SNAP_APP_USER_DATA_PATH"
+   {{if .UserData}}(umask 077 && mkdir -p "$SNAP_APP_USER_DATA_PATH"){{else}}mkdir -p "$SNAP_APP_USER_DATA_PATH"{{end}}
 fi
-export HOME="$SNAP_APP_USER_DATA_PATH"
+{{if .UserData}}export SNAP_USER_DATA="$SNAP_APP_USER_DATA_PATH"
+{{end}}export HOME="$SNAP_APP_USER_DATA_PATH"
 
 # export old pwd
 export SNAP_OLD_PWD="$(pwd)"
@@ -327,17 +336,19 @@ ubuntu-core-launcher {{.UdevAppName}} {{.AaProfile}} {{.Target}} "$@"
 	var templateOut bytes.Buffer
 	t := template.Must(template.New("wrapper").Parse(wrapperTemplate))
 	wrapperData := struct {
-		AppName     string
-		AppArch     string
-		AppPath     string
-		Version     string
-		UdevAppName string
-		Origin      string
-		Home        string
-		Target      string
-		AaProfile   string
-		OldAppVars  string
-		NewAppVars  string
+		AppName      string
+		AppArch      string
+		AppPath      string
+		Version      string
+		UdevAppName  string
+		Origin       string
+		Home         string
+		Target       string
+		AaProfile    string
+		OldAppVars   string
+		NewAppVars   string
+		UserData     bool
+		ExportedPath string
 	}{
 		AppName:     m.Name,
 		AppArch:     helpers.UbuntuArchitecture(),
@@ -348,6 +359,10 @@ ubuntu-core-launcher {{.UdevAppName}} {{.AaProfile}} {{.Target}} "$@"
 		Home:        "$HOME",
 		Target:      actualBinPath,
 		AaProfile:   aaProfile,
+		// keep the data of the human users on personal apart
+		UserData: release.Get().Flavor == "personal",
+		// binaries exported by the frameworks in use
+		ExportedPath: m.exportedBinariesPath(),
 	}
 
 	oldVars := []string{}
@@ -384,15 +399,21 @@ func verifyStructStringsAgainstWhitelist(s interface{}, whitelist *regexp.Regexp
 		if t.Field(i).PkgPath != "" {
 			continue
 		}
+		// nested structs are checked in place, the fields that
+		// come after them still need checking
 		if v.Field(i).Kind() == reflect.Ptr {
 			vi := v.Field(i).Elem()
 			if vi.Kind() == reflect.Struct {
-				return verifyStructStringsAgainstWhitelist(vi.Interface(), whitelist)
+				if err := verifyStructStringsAgainstWhitelist(vi.Interface(), whitelist); err != nil {
+					return err
+				}
 			}
 		}
 		if v.Field(i).Kind() == reflect.Struct {
 			vi := v.Field(i).Interface()
-			return verifyStructStringsAgainstWhitelist(vi, whitelist)
+			if err := verifyStructStringsAgainstWhitelist(vi, whitelist); err != nil {
+				return err
+			}
 		}
 		if v.Field(i).Kind() == reflect.String {
 			key := t.Field(i).Name
@@ -411,6 +432,15 @@ func verifyStructStringsAgainstWhitelist(s interface{}, whitelist *regexp.Regexp
 }
 
 func verifyServiceYaml(service ServiceYaml) error {
+	if service.LogLimit != nil {
+		if err := service.LogLimit.verify(); err != nil {
+			return err
+		}
+	}
+	if err := verifyUpgradeMode(service); err != nil {
+		return err
+	}
+
 	return verifyStructStringsAgainstWhitelist(service, servicesBinariesStringsWhitelist)
 }
 
@@ -431,16 +461,36 @@ func generateSnapServicesFile(service ServiceYaml, baseDir string, aaProfile str
 		socketFileName = filepath.Base(generateSocketFileName(m, service))
 	}
 
-	return systemd.New(dirs.GlobalRootDir, nil).GenServiceFile(
+	var logNS string
+	var logInterval time.Duration
+	var logBurst int
+	if service.LogLimit != nil {
+		if service.LogLimit.namespaced() {
+			logNS = logNamespace(m, originFromBasedir(baseDir))
+		}
+		logInterval = time.Duration(service.LogLimit.RateInterval)
+		logBurst = service.LogLimit.RateBurst
+	}
+
+	// baseDir is relative to the global root dir, the variants of
+	// the commands are looked up on the disk
+	pkgPath := filepath.Join(dirs.GlobalRootDir, baseDir)
+
+	ports, err := negotiatedPorts(m, service)
+	if err != nil {
+		return "", err
+	}
+
+	return newSystemd(nil).GenServiceFile(
 		&systemd.ServiceDescription{
 			AppName:        m.Name,
 			ServiceName:    service.Name,
 			Version:        m.Version,
 			Description:    desc,
 			AppPath:        baseDir,
-			Start:          service.Start,
-			Stop:           service.Stop,
-			PostStop:       service.PostStop,
+			Start:          archSpecificCommand(pkgPath, service.Start),
+			Stop:           archSpecificCommand(pkgPath, service.Stop),
+			PostStop:       archSpecificCommand(pkgPath, service.PostStop),
 			StopTimeout:    time.Duration(service.StopTimeout),
 			AaProfile:      aaProfile,
 			IsFramework:    m.Type == pkg.TypeFramework,
@@ -450,6 +500,13 @@ func generateSnapServicesFile(service ServiceYaml, baseDir string, aaProfile str
 			UdevAppName:    udevPartName,
 			Socket:         service.Socket,
 			SocketFileName: socketFileName,
+			NeedsNetwork:   service.NeedsNetwork,
+			NeedsTimeSync:  service.NeedsTimeSync,
+
+			LogNamespace:         logNS,
+			LogRateLimitInterval: logInterval,
+			LogRateLimitBurst:    logBurst,
+			Ports:                ports,
 		}), nil
 }
 func generateSnapSocketFile(service ServiceYaml, baseDir string, aaProfile string, m *packageYaml) (string, error) {
@@ -459,7 +516,7 @@ func generateSnapSocketFile(service ServiceYaml, baseDir string, aaProfile strin
 
 	serviceFileName := filepath.Base(generateServiceFileName(m, service))
 
-	return systemd.New(dirs.GlobalRootDir, nil).GenSocketFile(
+	return newSystemd(nil).GenSocketFile(
 		&systemd.ServiceDescription{
 			ServiceFileName: serviceFileName,
 			ListenStream:    service.ListenStream,
@@ -495,6 +552,10 @@ func stripGlobalRootDirImpl(dir string) string {
 }
 
 func (m *packageYaml) addPackageServices(baseDir string, inhibitHooks bool, inter interacter) error {
+	if err := m.writeJournaldConf(originFromBasedir(baseDir)); err != nil {
+		return err
+	}
+
 	for _, service := range m.ServiceYamls {
 		aaProfile, err := getSecurityProfile(m, service.Name, baseDir)
 		if err != nil {
@@ -512,7 +573,7 @@ func (m *packageYaml) addPackageServices(baseDir string, inhibitHooks bool, inte
 		}
 		serviceFilename := generateServiceFileName(m, service)
 		os.MkdirAll(filepath.Dir(serviceFilename), 0755)
-		if err := ioutil.WriteFile(serviceFilename, []byte(content), 0644); err != nil {
+		if err := helpers.AtomicWriteFile(serviceFilename, []byte(content), 0644, 0); err != nil {
 			return err
 		}
 		// Generate systemd socket file if needed
@@ -523,7 +584,7 @@ func (m *packageYaml) addPackageServices(baseDir string, inhibitHooks bool, inte
 			}
 			socketFilename := generateSocketFileName(m, service)
 			os.MkdirAll(filepath.Dir(socketFilename), 0755)
-			if err := ioutil.WriteFile(socketFilename, []byte(content), 0644); err != nil {
+			if err := helpers.AtomicWriteFile(socketFilename, []byte(content), 0644, 0); err != nil {
 				return err
 			}
 		}
@@ -536,7 +597,7 @@ func (m *packageYaml) addPackageServices(baseDir string, inhibitHooks bool, inte
 			}
 			policyFilename := generateBusPolicyFileName(m, service)
 			os.MkdirAll(filepath.Dir(policyFilename), 0755)
-			if err := ioutil.WriteFile(policyFilename, []byte(content), 0644); err != nil {
+			if err := helpers.AtomicWriteFile(policyFilename, []byte(content), 0644, 0); err != nil {
 				return err
 			}
 		}
@@ -546,7 +607,7 @@ func (m *packageYaml) addPackageServices(baseDir string, inhibitHooks bool, inte
 		//
 		// *but* always run enable (which just sets a symlink)
 		serviceName := filepath.Base(generateServiceFileName(m, service))
-		sysd := systemd.New(dirs.GlobalRootDir, inter)
+		sysd := newSystemd(inter)
 		if !inhibitHooks {
 			if err := sysd.DaemonReload(); err != nil {
 				return err
@@ -577,27 +638,55 @@ func (m *packageYaml) addPackageServices(baseDir string, inhibitHooks bool, inte
 				}
 			}
 		}
+
+		if service.isBlueGreen() {
+			if err := m.addProxy(service, inhibitHooks, inter); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// stopService stops the service, killing it if it does not stop in
+// time
+func (m *packageYaml) stopService(serviceName string, service ServiceYaml, inter interacter) error {
+	sysd := newSystemd(inter)
+	if err := sysd.Stop(serviceName, time.Duration(service.StopTimeout)); err != nil {
+		if !systemd.IsTimeout(err) {
+			return err
+		}
+		progress.NotifyMessage(inter, progress.NewMessage(progress.MsgKillingService, serviceName))
+		// ignore errors for kill; nothing we'd do differently at this point
+		sysd.Kill(serviceName, "TERM")
+		time.Sleep(killWait)
+		sysd.Kill(serviceName, "KILL")
 	}
 
 	return nil
 }
 
 func (m *packageYaml) removePackageServices(baseDir string, inter interacter) error {
-	sysd := systemd.New(dirs.GlobalRootDir, inter)
+	return m.removePackageServicesKeeping(baseDir, nil, inter)
+}
+
+// removePackageServicesKeeping removes the services of the snap, but
+// the ones in keep (the blue/green services that keep running until
+// the new version takes over)
+func (m *packageYaml) removePackageServicesKeeping(baseDir string, keep map[string]bool, inter interacter) error {
+	sysd := newSystemd(inter)
 	for _, service := range m.ServiceYamls {
+		if keep[service.Name] {
+			continue
+		}
+
 		serviceName := filepath.Base(generateServiceFileName(m, service))
 		if err := sysd.Disable(serviceName); err != nil {
 			return err
 		}
-		if err := sysd.Stop(serviceName, time.Duration(service.StopTimeout)); err != nil {
-			if !systemd.IsTimeout(err) {
-				return err
-			}
-			inter.Notify(fmt.Sprintf("%s refused to stop, killing.", serviceName))
-			// ignore errors for kill; nothing we'd do differently at this point
-			sysd.Kill(serviceName, "TERM")
-			time.Sleep(killWait)
-			sysd.Kill(serviceName, "KILL")
+		if err := m.stopService(serviceName, service, inter); err != nil {
+			return err
 		}
 
 		if err := os.Remove(generateServiceFileName(m, service)); err != nil && !os.IsNotExist(err) {
@@ -612,8 +701,16 @@ func (m *packageYaml) removePackageServices(baseDir string, inter interacter) er
 		if err := os.Remove(generateBusPolicyFileName(m, service)); err != nil && !os.IsNotExist(err) {
 			logger.Noticef("Failed to remove bus policy file for service %q: %v", serviceName, err)
 		}
+
+		if service.isBlueGreen() {
+			if err := m.removeProxy(service, inter); err != nil {
+				return err
+			}
+		}
 	}
 
+	m.removeJournaldConf(originFromBasedir(baseDir))
+
 	// only reload if we actually had services
 	if len(m.ServiceYamls) > 0 {
 		if err := sysd.DaemonReload(); err != nil {
@@ -644,7 +741,7 @@ func (m *packageYaml) addPackageBinaries(baseDir string) error {
 			return err
 		}
 
-		if err := ioutil.WriteFile(generateBinaryName(m, binary), []byte(content), 0755); err != nil {
+		if err := helpers.AtomicWriteFile(generateBinaryName(m, binary), []byte(content), 0755, 0); err != nil {
 			return err
 		}
 	}
@@ -660,37 +757,46 @@ func (m *packageYaml) removePackageBinaries(baseDir string) error {
 	return nil
 }
 
-func (m *packageYaml) addOneSecurityPolicy(name string, sd SecurityDefinitions, baseDir string) error {
+func (m *packageYaml) addOneSecurityPolicy(name string, sd SecurityDefinitions, baseDir string, backend Backend) error {
 	profileName, err := getSecurityProfile(m, filepath.Base(name), baseDir)
 	if err != nil {
 		return err
 	}
-	content, err := generateSeccompPolicy(baseDir, name, sd)
-	if err != nil {
-		return err
+	var content []byte
+	if m.confinement() == pkg.ConfinementClassic {
+		content = []byte(seccompUnrestricted + "\n")
+	} else {
+		content, err = generateSeccompPolicy(backend, baseDir, name, withConnectedCaps(m.Name, sd))
+		if err != nil {
+			return err
+		}
+	}
+	if m.confinement() == pkg.ConfinementDevMode {
+		// the launcher only logs what the policy denies
+		content = append([]byte(seccompComplain+"\n"), content...)
 	}
 
 	fn := filepath.Join(dirs.SnapSeccompDir, profileName)
-	if err := ioutil.WriteFile(fn, content, 0644); err != nil {
+	if err := helpers.AtomicWriteFile(fn, content, 0644, 0); err != nil {
 		return err
 	}
 
-	return nil
+	return macBackendOf(backend).addPolicy(m, name, withConnectedCaps(m.Name, sd), baseDir)
 }
 
-func (m *packageYaml) addSecurityPolicy(baseDir string) error {
+func (m *packageYaml) addSecurityPolicy(baseDir string, backend Backend) error {
 	// TODO: move apparmor policy generation here too, its currently
 	//       done via the click hooks but we really want to generate
 	//       it all here
 
 	for _, svc := range m.ServiceYamls {
-		if err := m.addOneSecurityPolicy(svc.Name, svc.SecurityDefinitions, baseDir); err != nil {
+		if err := m.addOneSecurityPolicy(svc.Name, svc.SecurityDefinitions, baseDir, backend); err != nil {
 			return err
 		}
 	}
 
 	for _, bin := range m.Binaries {
-		if err := m.addOneSecurityPolicy(bin.Name, bin.SecurityDefinitions, baseDir); err != nil {
+		if err := m.addOneSecurityPolicy(bin.Name, bin.SecurityDefinitions, baseDir, backend); err != nil {
 			return err
 		}
 	}
@@ -698,7 +804,7 @@ func (m *packageYaml) addSecurityPolicy(baseDir string) error {
 	return nil
 }
 
-func (m *packageYaml) removeOneSecurityPolicy(name, baseDir string) error {
+func (m *packageYaml) removeOneSecurityPolicy(name, baseDir string, backend Backend) error {
 	profileName, err := getSecurityProfile(m, filepath.Base(name), baseDir)
 	if err != nil {
 		return err
@@ -708,19 +814,19 @@ func (m *packageYaml) removeOneSecurityPolicy(name, baseDir string) error {
 		return err
 	}
 
-	return nil
+	return macBackendOf(backend).removePolicy(m, name, baseDir)
 }
 
-func (m *packageYaml) removeSecurityPolicy(baseDir string) error {
+func (m *packageYaml) removeSecurityPolicy(baseDir string, backend Backend) error {
 	// TODO: move apparmor policy removal here
 	for _, service := range m.ServiceYamls {
-		if err := m.removeOneSecurityPolicy(service.Name, baseDir); err != nil {
+		if err := m.removeOneSecurityPolicy(service.Name, baseDir, backend); err != nil {
 			return err
 		}
 	}
 
 	for _, binary := range m.Binaries {
-		if err := m.removeOneSecurityPolicy(binary.Name, baseDir); err != nil {
+		if err := m.removeOneSecurityPolicy(binary.Name, baseDir, backend); err != nil {
 			return err
 		}
 	}
@@ -739,13 +845,19 @@ type interacter interface {
 
 // this rewrites the json manifest to include the origin in the on-disk
 // manifest.json to be compatible with click again
-func writeCompatManifestJSON(clickMetaDir string, manifestData []byte, origin string) error {
+func writeCompatManifestJSON(clickMetaDir string, manifestData []byte, origin string, confinement pkg.Confinement) error {
 	var cm clickManifest
 	if err := json.Unmarshal(manifestData, &cm); err != nil {
 		return err
 	}
 
-	if cm.Type != pkg.TypeFramework && cm.Type != pkg.TypeOem {
+	// the snap may get installed with another confinement than the
+	// one it asks for (i.e. in developer mode)
+	if confinement != "" {
+		cm.Confinement = confinement
+	}
+
+	if cm.Type != pkg.TypeFramework && !cm.Type.IsGadget() {
 		// add the origin to the name
 		cm.Name = fmt.Sprintf("%s.%s", cm.Name, origin)
 	}
@@ -766,8 +878,12 @@ func writeCompatManifestJSON(clickMetaDir string, manifestData []byte, origin st
 }
 
 func installClick(snapFile string, flags InstallFlags, inter progress.Meter, origin string) (name string, err error) {
-	allowUnauthenticated := (flags & AllowUnauthenticated) != 0
-	part, err := NewSnapPartFromSnapFile(snapFile, origin, allowUnauthenticated)
+	// before anything of the snap gets unpacked
+	if err := scanSnap(snapFile); err != nil {
+		return "", err
+	}
+
+	part, err := NewSnapPartFromSnapFile(snapFile, origin, sideloadOptionsFromFlags(flags))
 	if err != nil {
 		return "", err
 	}
@@ -826,23 +942,51 @@ func copySnapData(fullName, oldVersion, newVersion string) (err error) {
 	return nil
 }
 
-// Lowlevel copy the snap data (but never override existing data)
+// Lowlevel copy the snap data (but never override existing data); the
+// files are hardlinked so that even lots of data is copied quickly and
+// takes no more space, and only copied where they can not be (across
+// filesystems). The versions share the files, so the snap needs to
+// replace them rather than change them in place for the old version
+// to keep its data for a rollback.
 func copySnapDataDirectory(oldPath, newPath string) (err error) {
 	if _, err := os.Stat(oldPath); err == nil {
 		if _, err := os.Stat(newPath); err != nil {
-			// there is no golang "CopyFile"
-			cmd := exec.Command("cp", "-a", oldPath, newPath)
-			if err := cmd.Run(); err != nil {
-				if exitCode, err := helpers.ExitCode(err); err == nil {
-					return &ErrDataCopyFailed{
-						OldPath:  oldPath,
-						NewPath:  newPath,
-						ExitCode: exitCode}
-				}
-				return err
-			}
+			return cpData(oldPath, newPath, copyDataFlags(oldPath, filepath.Dir(newPath)))
+		}
+	}
+	return nil
+}
+
+// copyDataFlags returns the flags of cp that hardlink the data in
+// oldPath into newDir, or that copy it if they are not on the same
+// filesystem
+func copyDataFlags(oldPath, newDir string) string {
+	oldFS, err := fsID(oldPath)
+	if err != nil {
+		return "-a"
+	}
+	newFS, err := fsID(newDir)
+	if err != nil || newFS != oldFS {
+		return "-a"
+	}
+
+	return "-al"
+}
+
+// cpData runs cp with the given flags to copy the data in oldPath
+func cpData(oldPath, newPath string, flags ...string) error {
+	// there is no golang "CopyFile"
+	cmd := exec.Command("cp", append(flags, oldPath, newPath)...)
+	if err := cmd.Run(); err != nil {
+		if exitCode, err := helpers.ExitCode(err); err == nil {
+			return &ErrDataCopyFailed{
+				OldPath:  oldPath,
+				NewPath:  newPath,
+				ExitCode: exitCode}
 		}
+		return err
 	}
+
 	return nil
 }
 
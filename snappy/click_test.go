@@ -24,20 +24,21 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
 
 	"github.com/mvo5/goconfigparser"
 	. "gopkg.in/check.v1"
+	"gopkg.in/yaml.v2"
 
 	"github.com/ubuntu-core/snappy/dirs"
 	"github.com/ubuntu-core/snappy/helpers"
 	"github.com/ubuntu-core/snappy/pkg"
 	"github.com/ubuntu-core/snappy/pkg/clickdeb"
 	"github.com/ubuntu-core/snappy/policy"
-	"github.com/ubuntu-core/snappy/progress"
-	"github.com/ubuntu-core/snappy/systemd"
+	"github.com/ubuntu-core/snappy/release"
 )
 
 func (s *SnapTestSuite) TestReadManifest(c *C) {
@@ -151,7 +152,7 @@ Pattern: /var/lib/apparmor/click/${id}
 			},
 		},
 	}
-	err := installClickHooks(instDir, m, testOrigin, false)
+	err := installClickHooks(instDir, m, testOrigin, false, s.backend)
 	c.Assert(err, IsNil)
 	p := fmt.Sprintf("%s/%s.%s_%s_%s", testSymlinkDir, m.Name, testOrigin, "app", m.Version)
 	_, err = os.Stat(p)
@@ -168,7 +169,7 @@ Pattern: /var/lib/apparmor/click/${id}
 	c.Assert(symlinkTarget, Equals, filepath.Join(instDir, "path-to-apparmor-file"))
 
 	// now ensure we can remove
-	err = removeClickHooks(m, testOrigin, false)
+	err = removeClickHooks(m, testOrigin, false, s.backend)
 	c.Assert(err, IsNil)
 	_, err = os.Stat(fmt.Sprintf("%s/%s.%s_%s_%s", testSymlinkDir, m.Name, testOrigin, "app", m.Version))
 	c.Assert(err, NotNil)
@@ -176,7 +177,7 @@ Pattern: /var/lib/apparmor/click/${id}
 
 func (s *SnapTestSuite) testLocalSnapInstall(c *C) string {
 	snapFile := makeTestSnapPackage(c, "")
-	name, err := installClick(snapFile, 0, nil, testOrigin)
+	name, err := installClick(snapFile, 0, s.meter(), testOrigin)
 	c.Assert(err, IsNil)
 	c.Check(name, Equals, "foo")
 
@@ -209,7 +210,7 @@ func (s *SnapTestSuite) TestLocalSnapInstall(c *C) {
 func (s *SnapTestSuite) TestLocalSnapInstallFailsAlreadyInstalled(c *C) {
 	snapFile := s.testLocalSnapInstall(c)
 
-	_, err := installClick(snapFile, 0, nil, "originother")
+	_, err := installClick(snapFile, 0, s.meter(), "originother")
 	c.Assert(err, Equals, ErrPackageNameAlreadyInstalled)
 }
 
@@ -219,7 +220,7 @@ func (s *SnapTestSuite) TestLocalSnapInstallDebsigVerifyFails(c *C) {
 	defer func() { clickdeb.VerifyCmd = old }()
 
 	snapFile := makeTestSnapPackage(c, "")
-	_, err := installClick(snapFile, 0, nil, testOrigin)
+	_, err := installClick(snapFile, 0, s.meter(), testOrigin)
 	c.Assert(err, NotNil)
 
 	contentFile := filepath.Join(s.tempdir, "apps", fooComposedName, "1.0", "bin", "foo")
@@ -238,11 +239,11 @@ func (s *SnapTestSuite) TestLocalSnapInstallDebsigVerifyPassesUnauth(c *C) {
 	defer func() { clickdeb.VerifyCmd = old }()
 
 	snapFile := makeTestSnapPackage(c, "")
-	name, err := installClick(snapFile, AllowUnauthenticated, nil, testOrigin)
+	name, err := installClick(snapFile, AllowUnauthenticated, s.meter(), testOrigin)
 	c.Assert(err, IsNil)
 	c.Check(name, Equals, "foo")
 
-	_, err = installClick(snapFile, 0, nil, testOrigin)
+	_, err = installClick(snapFile, 0, s.meter(), testOrigin)
 	c.Assert(err, NotNil)
 }
 
@@ -254,7 +255,7 @@ name: foo
 version: 1.0
 vendor: foo
 explicit-license-agreement: Y`)
-	_, err := installClick(pkg, 0, nil, testOrigin)
+	_, err := installClick(pkg, 0, s.meter(), testOrigin)
 	c.Check(err, Equals, ErrLicenseNotAccepted)
 }
 
@@ -382,13 +383,13 @@ explicit-license-agreement: Y
 
 func (s *SnapTestSuite) TestSnapRemove(c *C) {
 	allSystemctl := []string{}
-	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+	s.backend.systemctl = func(cmd ...string) ([]byte, error) {
 		allSystemctl = append(allSystemctl, cmd[0])
 		return nil, nil
 	}
 
 	targetDir := filepath.Join(s.tempdir, "apps")
-	_, err := installClick(makeTestSnapPackage(c, ""), 0, nil, testOrigin)
+	_, err := installClick(makeTestSnapPackage(c, ""), 0, s.meter(), testOrigin)
 	c.Assert(err, IsNil)
 
 	instDir := filepath.Join(targetDir, fooComposedName, "1.0")
@@ -410,7 +411,7 @@ func (s *SnapTestSuite) TestSnapRemove(c *C) {
 
 func (s *SnapTestSuite) buildFramework(c *C) string {
 	allSystemctl := []string{}
-	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+	s.backend.systemctl = func(cmd ...string) ([]byte, error) {
 		allSystemctl = append(allSystemctl, cmd[0])
 		return nil, nil
 	}
@@ -442,7 +443,7 @@ type: framework
 		return writeHashes(tmpdir, dataTar)
 	}), IsNil)
 
-	_, err = installClick(snapName, 0, nil, testOrigin)
+	_, err = installClick(snapName, 0, s.meter(), testOrigin)
 	c.Assert(err, IsNil)
 
 	return snapName
@@ -460,7 +461,7 @@ func (s *SnapTestSuite) TestSnapInstallPackagePolicyDelta(c *C) {
 	// rename the policy
 	//poldir := filepath.Join(tmpdir, "meta", "framework-policy", "apparmor", "policygroups")
 
-	// _, err := installClick(snapName, 0, nil, testOrigin)
+	// _, err := installClick(snapName, 0, s.meter(), testOrigin)
 	// c.Assert(err, IsNil)
 	// appdir := filepath.Join(s.tempdir, "apps", "hello.testspacethename", "1.0.1")
 	// c.Assert(removeClick(appdir, nil), IsNil)
@@ -505,7 +506,7 @@ version: 1.0
 type: oem
 icon: foo.svg
 vendor: Foo Bar <foo@example.com>`)
-	_, err := installClick(snapFile, AllowOEM, nil, testOrigin)
+	_, err := installClick(snapFile, AllowOEM, s.meter(), testOrigin)
 	c.Assert(err, IsNil)
 
 	contentFile := filepath.Join(s.tempdir, "oem", "foo", "1.0", "bin", "foo")
@@ -521,7 +522,7 @@ version: 1.0
 type: oem
 icon: foo.svg
 vendor: Foo Bar <foo@example.com>`)
-	_, err := installClick(snapFile, AllowOEM, nil, testOrigin)
+	_, err := installClick(snapFile, AllowOEM, s.meter(), testOrigin)
 	c.Assert(err, IsNil)
 	c.Assert(storeMinimalRemoteManifest("foo", "foo", testOrigin, "1.0", "", "remote-channel"), IsNil)
 
@@ -537,7 +538,7 @@ version: 2.0
 type: oem
 icon: foo.svg
 vendor: Foo Bar <foo@example.com>`)
-	_, err = installClick(snapFile, 0, nil, testOrigin)
+	_, err = installClick(snapFile, 0, s.meter(), testOrigin)
 	c.Check(err, IsNil)
 	c.Assert(storeMinimalRemoteManifest("foo", "foo", testOrigin, "2.0", "", "remote-channel"), IsNil)
 
@@ -547,7 +548,7 @@ vendor: Foo Bar <foo@example.com>`)
 	//
 	// // different origin, this shows we have no origin support at this
 	// // level, but sideloading also works.
-	// _, err = installClick(snapFile, 0, nil, SideloadedOrigin)
+	// _, err = installClick(snapFile, 0, s.meter(), SideloadedOrigin)
 	// c.Check(err, IsNil)
 	// c.Assert(storeMinimalRemoteManifest("foo", "foo", SideloadedOrigin, "1.0", ""), IsNil)
 
@@ -557,11 +558,11 @@ version: 2.0
 type: oem
 icon: foo.svg
 vendor: Foo Bar <foo@example.com>`)
-	_, err = installClick(snapFile, 0, nil, testOrigin)
+	_, err = installClick(snapFile, 0, s.meter(), testOrigin)
 	c.Check(err, Equals, ErrOEMPackageInstall)
 
 	// this will cause chaos, but let's test if it works
-	_, err = installClick(snapFile, AllowOEM, nil, testOrigin)
+	_, err = installClick(snapFile, AllowOEM, s.meter(), testOrigin)
 	c.Check(err, IsNil)
 }
 
@@ -571,11 +572,11 @@ icon: foo.svg
 vendor: Foo Bar <foo@example.com>
 `
 	snapFile := makeTestSnapPackage(c, packageYaml+"version: 1.0")
-	_, err := installClick(snapFile, AllowUnauthenticated, nil, testOrigin)
+	_, err := installClick(snapFile, AllowUnauthenticated, s.meter(), testOrigin)
 	c.Assert(err, IsNil)
 
 	snapFile = makeTestSnapPackage(c, packageYaml+"version: 2.0")
-	_, err = installClick(snapFile, AllowUnauthenticated, nil, testOrigin)
+	_, err = installClick(snapFile, AllowUnauthenticated, s.meter(), testOrigin)
 	c.Assert(err, IsNil)
 
 	// ensure v2 is active
@@ -614,7 +615,7 @@ vendor: Foo Bar <foo@example.com>
 	canaryData := []byte("ni ni ni")
 
 	snapFile := makeTestSnapPackage(c, packageYaml+"version: 1.0")
-	_, err = installClick(snapFile, AllowUnauthenticated, nil, testOrigin)
+	_, err = installClick(snapFile, AllowUnauthenticated, s.meter(), testOrigin)
 	c.Assert(err, IsNil)
 	canaryDataFile := filepath.Join(dirs.SnapDataDir, appDir, "1.0", "canary.txt")
 	err = ioutil.WriteFile(canaryDataFile, canaryData, 0644)
@@ -623,7 +624,7 @@ vendor: Foo Bar <foo@example.com>
 	c.Assert(err, IsNil)
 
 	snapFile = makeTestSnapPackage(c, packageYaml+"version: 2.0")
-	_, err = installClick(snapFile, AllowUnauthenticated, nil, testOrigin)
+	_, err = installClick(snapFile, AllowUnauthenticated, s.meter(), testOrigin)
 	c.Assert(err, IsNil)
 	newCanaryDataFile := filepath.Join(dirs.SnapDataDir, appDir, "2.0", "canary.txt")
 	content, err := ioutil.ReadFile(newCanaryDataFile)
@@ -648,14 +649,14 @@ vendor: Foo Bar <foo@example.com>
 `
 	appDir := "foo." + testOrigin
 	snapFile := makeTestSnapPackage(c, packageYaml+"version: 1.0")
-	_, err := installClick(snapFile, AllowUnauthenticated, nil, testOrigin)
+	_, err := installClick(snapFile, AllowUnauthenticated, s.meter(), testOrigin)
 	c.Assert(err, IsNil)
 	canaryDataFile := filepath.Join(dirs.SnapDataDir, appDir, "1.0", "canary.txt")
 	err = ioutil.WriteFile(canaryDataFile, []byte(""), 0644)
 	c.Assert(err, IsNil)
 
 	snapFile = makeTestSnapPackage(c, packageYaml+"version: 2.0")
-	_, err = installClick(snapFile, AllowUnauthenticated, nil, testOrigin)
+	_, err = installClick(snapFile, AllowUnauthenticated, s.meter(), testOrigin)
 	c.Assert(err, IsNil)
 	_, err = os.Stat(filepath.Join(dirs.SnapDataDir, appDir, "2.0", "canary.txt"))
 	c.Assert(err, IsNil)
@@ -684,14 +685,14 @@ integration:
 	appDir := "bar." + testOrigin
 	// install 1.0 and then upgrade to 2.0
 	snapFile := makeTestSnapPackage(c, packageYaml+"version: 1.0")
-	_, err := installClick(snapFile, AllowUnauthenticated, nil, testOrigin)
+	_, err := installClick(snapFile, AllowUnauthenticated, s.meter(), testOrigin)
 	c.Assert(err, IsNil)
 	canaryDataFile := filepath.Join(dirs.SnapDataDir, appDir, "1.0", "canary.txt")
 	err = ioutil.WriteFile(canaryDataFile, []byte(""), 0644)
 	c.Assert(err, IsNil)
 
 	snapFile = makeTestSnapPackage(c, packageYaml+"version: 2.0")
-	_, err = installClick(snapFile, AllowUnauthenticated, nil, testOrigin)
+	_, err = installClick(snapFile, AllowUnauthenticated, s.meter(), testOrigin)
 	c.Assert(err, IsNil)
 	_, err = os.Stat(filepath.Join(dirs.SnapDataDir, appDir, "2.0", "canary.txt"))
 	c.Assert(err, IsNil)
@@ -729,14 +730,14 @@ integration:
 	appDir := "bar." + testOrigin
 	// install 1.0 and then upgrade to 2.0
 	snapFile := makeTestSnapPackage(c, packageYaml+"version: 1.0")
-	_, err := installClick(snapFile, AllowUnauthenticated, nil, testOrigin)
+	_, err := installClick(snapFile, AllowUnauthenticated, s.meter(), testOrigin)
 	c.Assert(err, IsNil)
 	canaryDataFile := filepath.Join(dirs.SnapDataDir, appDir, "1.0", "canary.txt")
 	err = ioutil.WriteFile(canaryDataFile, []byte(""), 0644)
 	c.Assert(err, IsNil)
 
 	snapFile = makeTestSnapPackage(c, packageYaml+"version: 2.0")
-	_, err = installClick(snapFile, AllowUnauthenticated, nil, testOrigin)
+	_, err = installClick(snapFile, AllowUnauthenticated, s.meter(), testOrigin)
 	c.Assert(err, NotNil)
 
 	// installing 2.0 will fail in the hooks,
@@ -818,6 +819,68 @@ func (s *SnapTestSuite) TestSnappyGenerateSnapBinaryWrapperFmk(c *C) {
 	c.Assert(generatedWrapper, Equals, expected)
 }
 
+func (s *SnapTestSuite) TestSnappyGenerateSnapBinaryWrapperPersonal(c *C) {
+	release.Override(release.Release{Flavor: "personal", Series: "15.04"})
+
+	binary := Binary{Name: "pastebinit", Exec: "bin/pastebinit"}
+	pkgPath := "/apps/pastebinit.mvo/1.4.0.0.1/"
+	aaProfile := "pastebinit.mvo_pastebinit_1.4.0.0.1"
+	m := packageYaml{Name: "pastebinit",
+		Version: "1.4.0.0.1"}
+
+	expected := strings.Replace(expectedWrapper, `
+   mkdir -p "$SNAP_APP_USER_DATA_PATH"
+fi
+`, `
+   (umask 077 && mkdir -p "$SNAP_APP_USER_DATA_PATH")
+fi
+export SNAP_USER_DATA="$SNAP_APP_USER_DATA_PATH"
+`, 1)
+	expected = fmt.Sprintf(expected, helpers.UbuntuArchitecture())
+
+	generatedWrapper, err := generateSnapBinaryWrapper(binary, pkgPath, aaProfile, &m)
+	c.Assert(err, IsNil)
+	c.Assert(generatedWrapper, Equals, expected)
+}
+
+func (s *SnapTestSuite) TestSnappyBinaryWrapperUserDataPersonal(c *C) {
+	release.Override(release.Release{Flavor: "personal", Series: "15.04"})
+
+	// a launcher that records what it gets to see
+	binDir := filepath.Join(s.tempdir, "bin")
+	c.Assert(os.MkdirAll(binDir, 0755), IsNil)
+	envFile := filepath.Join(s.tempdir, "launcher-env")
+	c.Assert(ioutil.WriteFile(filepath.Join(binDir, "ubuntu-core-launcher"), []byte("#!/bin/sh\necho \"$SNAP_USER_DATA\" > "+envFile+"\n"), 0755), IsNil)
+
+	pkgPath := filepath.Join(s.tempdir, "apps", "pastebinit.mvo", "1.4.0.0.1") + "/"
+	c.Assert(os.MkdirAll(pkgPath, 0755), IsNil)
+	binary := Binary{Name: "pastebinit", Exec: "bin/pastebinit"}
+	m := packageYaml{Name: "pastebinit", Version: "1.4.0.0.1"}
+	wrapper, err := generateSnapBinaryWrapper(binary, pkgPath, "pastebinit.mvo_pastebinit_1.4.0.0.1", &m)
+	c.Assert(err, IsNil)
+	wrapperFile := filepath.Join(s.tempdir, "pastebinit")
+	c.Assert(ioutil.WriteFile(wrapperFile, []byte(wrapper), 0755), IsNil)
+
+	home := filepath.Join(s.tempdir, "home")
+	c.Assert(os.MkdirAll(home, 0755), IsNil)
+	cmd := exec.Command("/bin/sh", wrapperFile)
+	cmd.Env = []string{"HOME=" + home, "PATH=" + binDir + ":/bin:/usr/bin"}
+	output, err := cmd.CombinedOutput()
+	c.Assert(err, IsNil, Commentf("%s", output))
+
+	userData := home + pkgPath
+	content, err := ioutil.ReadFile(envFile)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, userData+"\n")
+
+	// all the way down from the home of the user
+	for dir := filepath.Clean(userData); dir != home; dir = filepath.Dir(dir) {
+		st, err := os.Stat(dir)
+		c.Assert(err, IsNil)
+		c.Check(st.Mode().Perm(), Equals, os.FileMode(0700), Commentf(dir))
+	}
+}
+
 func (s *SnapTestSuite) TestSnappyGenerateSnapBinaryWrapperIllegalChars(c *C) {
 	binary := Binary{Name: "bin/pastebinit\nSomething nasty"}
 	pkgPath := "/apps/pastebinit.mvo/1.4.0.0.1/"
@@ -852,7 +915,7 @@ binaries:
  - name: bin/bar
 `
 	snapFile := makeTestSnapPackage(c, packageYaml+"version: 1.0")
-	_, err := installClick(snapFile, AllowUnauthenticated, nil, "mvo")
+	_, err := installClick(snapFile, AllowUnauthenticated, s.meter(), "mvo")
 	c.Assert(err, IsNil)
 
 	// ensure that the binary wrapper file go generated with the right
@@ -879,7 +942,7 @@ binaries:
  - name: bin/bar
 `
 	snapFile := makeTestSnapPackage(c, packageYaml+"version: 1.0")
-	_, err := installClick(snapFile, AllowUnauthenticated, nil, "mvo")
+	_, err := installClick(snapFile, AllowUnauthenticated, s.meter(), "mvo")
 	c.Assert(err, IsNil)
 
 	// ensure that the binary wrapper file go generated with the right
@@ -892,7 +955,7 @@ binaries:
 
 	// and that it gets updated on upgrade
 	snapFile = makeTestSnapPackage(c, packageYaml+"version: 2.0")
-	_, err = installClick(snapFile, AllowUnauthenticated, nil, "mvo")
+	_, err = installClick(snapFile, AllowUnauthenticated, s.meter(), "mvo")
 	c.Assert(err, IsNil)
 	newSnapBin := filepath.Join(dirs.SnapAppsDir[len(dirs.GlobalRootDir):], "foo.mvo", "2.0", "bin", "bar")
 	content, err = ioutil.ReadFile(binaryWrapper)
@@ -909,7 +972,7 @@ services:
    start: bin/hello
 `
 	snapFile := makeTestSnapPackage(c, packageYaml+"version: 1.0")
-	_, err := installClick(snapFile, AllowUnauthenticated, nil, "mvo")
+	_, err := installClick(snapFile, AllowUnauthenticated, s.meter(), "mvo")
 	c.Assert(err, IsNil)
 
 	servicesFile := filepath.Join(dirs.SnapServicesDir, "foo_service_1.0.service")
@@ -924,14 +987,14 @@ services:
 	yamlPath := filepath.Join(snapDir, "meta", "package.yaml")
 	part, err := NewInstalledSnapPart(yamlPath, testOrigin)
 	c.Assert(err, IsNil)
-	err = part.remove(&progress.NullProgress{})
+	err = part.remove(s.meter())
 	c.Assert(err, IsNil)
 	c.Assert(helpers.FileExists(servicesFile), Equals, false)
 	c.Assert(helpers.FileExists(snapDir), Equals, false)
 }
 
 func (s *SnapTestSuite) setupSnappyDependentServices(c *C) (string, *MockProgressMeter) {
-	inter := &MockProgressMeter{}
+	inter := s.meter()
 	fmkYaml := `name: fmk
 version: 1.0
 vendor: foo
@@ -966,7 +1029,7 @@ func (s *SnapTestSuite) TestSnappyHandleDependentServicesOnInstall(c *C) {
 	fmkYaml, inter := s.setupSnappyDependentServices(c)
 
 	var cmdlog []string
-	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+	s.backend.systemctl = func(cmd ...string) ([]byte, error) {
 		cmdlog = append(cmdlog, cmd[0])
 		return []byte("ActiveState=inactive\n"), nil
 	}
@@ -992,7 +1055,7 @@ func (s *SnapTestSuite) TestSnappyHandleDependentServicesOnInstallFailingToStop(
 
 	anError := errors.New("failure")
 	var cmdlog []string
-	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+	s.backend.systemctl = func(cmd ...string) ([]byte, error) {
 		cmdlog = append(cmdlog, cmd[0])
 		if len(cmdlog) == 3 && cmd[0] == "stop" {
 			return nil, anError
@@ -1020,7 +1083,7 @@ func (s *SnapTestSuite) TestSnappyHandleDependentServicesOnInstallFailingToStart
 
 	anError := errors.New("failure")
 	var cmdlog []string
-	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+	s.backend.systemctl = func(cmd ...string) ([]byte, error) {
 		cmdlog = append(cmdlog, cmd[0])
 		if len(cmdlog) == 6 && cmd[0] == "start" {
 			return nil, anError
@@ -1049,7 +1112,7 @@ func (s *SnapTestSuite) TestSnappyHandleDependentServicesOnInstallFailingToStart
 
 func (s *SnapTestSuite) TestSnappyHandleServicesOnInstallInhibit(c *C) {
 	allSystemctl := [][]string{}
-	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+	s.backend.systemctl = func(cmd ...string) ([]byte, error) {
 		allSystemctl = append(allSystemctl, cmd)
 		return []byte("ActiveState=inactive\n"), nil
 	}
@@ -1062,7 +1125,7 @@ services:
    start: bin/hello
 `
 	snapFile := makeTestSnapPackage(c, packageYaml+"version: 1.0")
-	_, err := installClick(snapFile, InhibitHooks, nil, testOrigin)
+	_, err := installClick(snapFile, InhibitHooks, s.meter(), testOrigin)
 	c.Assert(err, IsNil)
 
 	c.Assert(allSystemctl, HasLen, 0)
@@ -1092,7 +1155,7 @@ integration:
 	snapFile := makeTestSnapPackage(c, packageYaml+"version: 1.0")
 
 	// install it
-	_, err := installClick(snapFile, 0, nil, testOrigin)
+	_, err := installClick(snapFile, 0, s.meter(), testOrigin)
 	c.Assert(err, IsNil)
 
 	// verify we have the symlink
@@ -1124,7 +1187,7 @@ integration:
 	snapFile := makeTestSnapPackage(c, packageYaml+"version: 1.0")
 
 	// install it
-	_, err := installClick(snapFile, InhibitHooks, nil, testOrigin)
+	_, err := installClick(snapFile, InhibitHooks, s.meter(), testOrigin)
 	c.Assert(err, IsNil)
 
 	// verify we have the symlink
@@ -1144,7 +1207,7 @@ func (s *SnapTestSuite) TestAddPackageServicesStripsGlobalRootdir(c *C) {
 	m, err := parsePackageYamlFile(yamlFile)
 	c.Assert(err, IsNil)
 	baseDir := filepath.Dir(filepath.Dir(yamlFile))
-	err = m.addPackageServices(baseDir, false, nil)
+	err = m.addPackageServices(baseDir, false, s.meter())
 	c.Assert(err, IsNil)
 
 	content, err := ioutil.ReadFile(filepath.Join(s.tempdir, "/etc/systemd/system/hello-app_svc1_1.10.service"))
@@ -1173,7 +1236,7 @@ services:
 	m, err := parsePackageYamlFile(yamlFile)
 	c.Assert(err, IsNil)
 	baseDir := filepath.Dir(filepath.Dir(yamlFile))
-	err = m.addPackageServices(baseDir, false, nil)
+	err = m.addPackageServices(baseDir, false, s.meter())
 	c.Assert(err, IsNil)
 
 	content, err := ioutil.ReadFile(filepath.Join(s.tempdir, "/etc/dbus-1/system.d/foo_bar_1.conf"))
@@ -1195,7 +1258,7 @@ services:
 	m, err := parsePackageYamlFile(yamlFile)
 	c.Assert(err, IsNil)
 	baseDir := filepath.Dir(filepath.Dir(yamlFile))
-	err = m.addPackageServices(baseDir, false, nil)
+	err = m.addPackageServices(baseDir, false, s.meter())
 	c.Assert(err, IsNil)
 
 	_, err = ioutil.ReadFile(filepath.Join(s.tempdir, "/etc/dbus-1/system.d/foo_bar_1.conf"))
@@ -1272,6 +1335,20 @@ func (s *SnapTestSuite) TestSnappyGenerateSnapServiceTypeForking(c *C) {
 	c.Assert(generatedWrapper, Equals, expectedTypeForkingFmkWrapper)
 }
 
+func (s *SnapTestSuite) TestSnappyGenerateSnapServiceNeedsNetworkAndTimeSync(c *C) {
+	var service ServiceYaml
+	c.Assert(yaml.Unmarshal([]byte("name: xkcd-webserver\nstart: bin/foo start\nneeds-network: true\nneeds-time-sync: true\n"), &service), IsNil)
+	pkgPath := "/apps/xkcd-webserver.canonical/0.3.4/"
+	aaProfile := "xkcd-webserver.canonical_xkcd-webserver_0.3.4"
+	m := packageYaml{Name: "xkcd-webserver",
+		Version: "0.3.4"}
+
+	generatedWrapper, err := generateSnapServicesFile(service, pkgPath, aaProfile, &m)
+	c.Assert(err, IsNil)
+	c.Check(generatedWrapper, Matches, "(?ms).*^After=network-online.target\nWants=network-online.target$.*")
+	c.Check(generatedWrapper, Matches, "(?ms).*^After=time-sync.target\nWants=time-sync.target$.*")
+}
+
 func (s *SnapTestSuite) TestSnappyGenerateSnapServiceAppWrapper(c *C) {
 	service := ServiceYaml{
 		Name:        "xkcd-webserver",
@@ -1367,6 +1444,22 @@ func (s *SnapTestSuite) TestServiceWhitelistIllegal(c *C) {
 	c.Assert(verifyServiceYaml(ServiceYaml{PostStop: "foo\n"}), NotNil)
 }
 
+func (s *SnapTestSuite) TestServiceWhitelistAfterNestedStruct(c *C) {
+	c.Assert(verifyServiceYaml(ServiceYaml{
+		Ports:       &Ports{},
+		HealthCheck: "foo\n",
+	}), NotNil)
+	c.Assert(verifyServiceYaml(ServiceYaml{
+		LogLimit:    &LogLimit{Namespace: true},
+		UpgradeMode: "foo\n",
+	}), NotNil)
+	c.Assert(verifyServiceYaml(ServiceYaml{
+		LogLimit: &LogLimit{Namespace: true},
+		SecurityDefinitions: SecurityDefinitions{
+			SecurityTemplate: "foo\n"},
+	}), NotNil)
+}
+
 func (s *SnapTestSuite) TestServiceWhitelistError(c *C) {
 	err := verifyServiceYaml(ServiceYaml{Name: "x\n"})
 	c.Assert(err.Error(), Equals, "services description field 'Name' contains illegal 'x\n' (legal: '^[A-Za-z0-9/. _#:-]*$')")
@@ -1445,7 +1538,7 @@ binaries:
 		return writeHashes(tmpdir, dataTar)
 	}), IsNil)
 
-	_, err = installClick(snapName, 0, nil, testOrigin)
+	_, err = installClick(snapName, 0, s.meter(), testOrigin)
 	c.Assert(err, ErrorMatches, ".*binary and service both called foo.*")
 }
 
@@ -1457,7 +1550,7 @@ frameworks:
   - missing
 `
 	snapFile := makeTestSnapPackage(c, packageYaml)
-	_, err := installClick(snapFile, 0, nil, testOrigin)
+	_, err := installClick(snapFile, 0, s.meter(), testOrigin)
 	c.Assert(err, ErrorMatches, `.*missing framework.*`)
 }
 
@@ -1484,7 +1577,7 @@ Pattern: /var/lib/systemd/click/${id}
 		return s
 	}
 
-	err := installClickHooks(c.MkDir(), m, testOrigin, false)
+	err := installClickHooks(c.MkDir(), m, testOrigin, false, s.backend)
 	c.Assert(err, IsNil)
 	c.Assert(stripGlobalRootDirWasCalled, Equals, true)
 }
@@ -1502,7 +1595,7 @@ services:
 	c.Assert(err, IsNil)
 
 	dirs.SnapSeccompDir = c.MkDir()
-	err = m.addSecurityPolicy("/apps/foo.mvo/1.0/")
+	err = m.addSecurityPolicy("/apps/foo.mvo/1.0/", s.backend)
 	c.Assert(err, IsNil)
 
 	binSeccompContent, err := ioutil.ReadFile(filepath.Join(dirs.SnapSeccompDir, "foo.mvo_foo_1.0"))
@@ -1532,13 +1625,13 @@ services:
 	c.Assert(helpers.FileExists(serviceSeccomp), Equals, false)
 
 	// add it now
-	err = m.addSecurityPolicy("/apps/foo.mvo/1.0/")
+	err = m.addSecurityPolicy("/apps/foo.mvo/1.0/", s.backend)
 	c.Assert(err, IsNil)
 	c.Assert(helpers.FileExists(binSeccomp), Equals, true)
 	c.Assert(helpers.FileExists(serviceSeccomp), Equals, true)
 
 	// ensure that it removes the files on remove
-	err = m.removeSecurityPolicy("/apps/foo.mvo/1.0/")
+	err = m.removeSecurityPolicy("/apps/foo.mvo/1.0/", s.backend)
 	c.Assert(err, IsNil)
 	c.Assert(helpers.FileExists(binSeccomp), Equals, false)
 	c.Assert(helpers.FileExists(serviceSeccomp), Equals, false)
@@ -1547,7 +1640,7 @@ services:
 func (s *SnapTestSuite) TestRemovePackageServiceKills(c *C) {
 	// make Stop not work
 	var sysdLog [][]string
-	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+	s.backend.systemctl = func(cmd ...string) ([]byte, error) {
 		sysdLog = append(sysdLog, cmd)
 		return []byte("ActiveState=active\n"), nil
 	}
@@ -1562,7 +1655,7 @@ services:
 	c.Assert(err, IsNil)
 	m, err := parsePackageYamlFile(yamlFile)
 	c.Assert(err, IsNil)
-	inter := &MockProgressMeter{}
+	inter := s.meter()
 	c.Check(m.removePackageServices(filepath.Dir(filepath.Dir(yamlFile)), inter), IsNil)
 	c.Assert(len(inter.notified) > 0, Equals, true)
 	c.Check(inter.notified[len(inter.notified)-1], Equals, "wat_wat_42.service refused to stop, killing.")
@@ -1592,6 +1685,60 @@ func (s *SnapTestSuite) TestCopySnapDataDirectoryError(c *C) {
 	})
 }
 
+func (s *SnapTestSuite) TestCopySnapDataDirectoryHardlinks(c *C) {
+	oldPath := filepath.Join(c.MkDir(), "1.0")
+	c.Assert(os.MkdirAll(filepath.Join(oldPath, "db"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(oldPath, "db", "data"), []byte("lots"), 0644), IsNil)
+	newPath := filepath.Join(filepath.Dir(oldPath), "2.0")
+
+	c.Assert(copySnapDataDirectory(oldPath, newPath), IsNil)
+
+	oldInfo, err := os.Stat(filepath.Join(oldPath, "db", "data"))
+	c.Assert(err, IsNil)
+	newInfo, err := os.Stat(filepath.Join(newPath, "db", "data"))
+	c.Assert(err, IsNil)
+	c.Check(os.SameFile(oldInfo, newInfo), Equals, true)
+
+	// but the directories are the new version's own
+	oldInfo, err = os.Stat(filepath.Join(oldPath, "db"))
+	c.Assert(err, IsNil)
+	newInfo, err = os.Stat(filepath.Join(newPath, "db"))
+	c.Assert(err, IsNil)
+	c.Check(os.SameFile(oldInfo, newInfo), Equals, false)
+
+	// so a file the new version replaces is its own
+	replaced := filepath.Join(newPath, "db", "data.new")
+	c.Assert(ioutil.WriteFile(replaced, []byte("more"), 0644), IsNil)
+	c.Assert(os.Rename(replaced, filepath.Join(newPath, "db", "data")), IsNil)
+	content, err := ioutil.ReadFile(filepath.Join(oldPath, "db", "data"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "lots")
+}
+
+func (s *SnapTestSuite) TestCopySnapDataDirectoryAcrossFilesystems(c *C) {
+	oldPath := filepath.Join(c.MkDir(), "1.0")
+	c.Assert(os.MkdirAll(oldPath, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(oldPath, "data"), []byte("lots"), 0644), IsNil)
+	newPath := filepath.Join(c.MkDir(), "2.0")
+	fsID = func(path string) (uint64, error) {
+		if path == oldPath {
+			return 1, nil
+		}
+		return 2, nil
+	}
+
+	c.Assert(copySnapDataDirectory(oldPath, newPath), IsNil)
+
+	oldInfo, err := os.Stat(filepath.Join(oldPath, "data"))
+	c.Assert(err, IsNil)
+	newInfo, err := os.Stat(filepath.Join(newPath, "data"))
+	c.Assert(err, IsNil)
+	c.Check(os.SameFile(oldInfo, newInfo), Equals, false)
+	content, err := ioutil.ReadFile(filepath.Join(newPath, "data"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "lots")
+}
+
 func (s *SnapTestSuite) TestSnappyGenerateSnapSocket(c *C) {
 	service := ServiceYaml{Name: "xkcd-webserver",
 		Start:        "bin/foo start",
@@ -1653,7 +1800,7 @@ func (s *SnapTestSuite) TestWriteCompatManifestJSON(c *C) {
 `)
 	manifestJSON := filepath.Join(s.tempdir, "hello-world.some-origin.manifest")
 
-	err := writeCompatManifestJSON(s.tempdir, manifest, "some-origin")
+	err := writeCompatManifestJSON(s.tempdir, manifest, "some-origin", "")
 	c.Assert(err, IsNil)
 	c.Assert(helpers.FileExists(manifestJSON), Equals, true)
 }
@@ -1668,7 +1815,7 @@ func (s *SnapTestSuite) TestWriteCompatManifestJSONNoFollow(c *C) {
 	os.Symlink(symlinkTarget, manifestJSON)
 	c.Assert(helpers.FileExists(symlinkTarget), Equals, false)
 
-	err := writeCompatManifestJSON(s.tempdir, manifest, "some-origin")
+	err := writeCompatManifestJSON(s.tempdir, manifest, "some-origin", "")
 	c.Assert(err, IsNil)
 	c.Check(helpers.FileExists(manifestJSON), Equals, true)
 	c.Check(helpers.FileExists(symlinkTarget), Equals, false)
@@ -0,0 +1,201 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+)
+
+// maxClockSkew is how far off the system clock may be compared to the
+// clock of the store before it is considered wrong
+var maxClockSkew = time.Hour
+
+// timeSyncedFile exists once systemd-timesyncd synchronized the clock
+var timeSyncedFile = "/run/systemd/timesync/synchronized"
+
+// clockSkewStatus is the clock skew measured the last time the store
+// was talked to, kept on disk so the other snappy commands can use it
+type clockSkewStatus struct {
+	// Skew of the system clock (in seconds), positive if it is behind
+	Skew int64 `yaml:"skew"`
+}
+
+func clockSkewFile() string {
+	return filepath.Join(dirs.GlobalRootDir, dirs.SnappyDir, "clock-skew.yaml")
+}
+
+// knownClockSkew returns how far the system clock is behind the clock
+// of the store, as measured the last time the store was talked to
+func knownClockSkew() time.Duration {
+	if helpers.FileExists(filepath.Join(dirs.GlobalRootDir, timeSyncedFile)) {
+		return 0
+	}
+
+	content, err := ioutil.ReadFile(clockSkewFile())
+	if err != nil {
+		return 0
+	}
+
+	var status clockSkewStatus
+	if err := yaml.Unmarshal(content, &status); err != nil {
+		return 0
+	}
+
+	return time.Duration(status.Skew) * time.Second
+}
+
+func tooSkewed(skew time.Duration) bool {
+	return skew > maxClockSkew || skew < -maxClockSkew
+}
+
+// clockTrustworthy returns false if the system clock is known to be
+// off (i.e. the date based logic can not rely on it)
+func clockTrustworthy() bool {
+	return !tooSkewed(knownClockSkew())
+}
+
+// correctedNow returns the current time, corrected by the known clock
+// skew if the system clock is off
+func correctedNow() time.Time {
+	skew := knownClockSkew()
+	if !tooSkewed(skew) {
+		return time.Now()
+	}
+
+	return time.Now().Add(skew)
+}
+
+// clockSkew returns how far the system clock is behind the Date of the
+// response (false if the response has no Date)
+func clockSkew(resp *http.Response) (time.Duration, bool) {
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, false
+	}
+
+	return date.Sub(time.Now()), true
+}
+
+// recordClockSkew keeps the clock skew measured from the response on
+// disk, it only writes it when it changed from being fine to being off
+// (or the other way around)
+func recordClockSkew(skew time.Duration) {
+	if !tooSkewed(skew) && !tooSkewed(knownClockSkew()) {
+		return
+	}
+	if !tooSkewed(skew) {
+		skew = 0
+	}
+
+	content, err := yaml.Marshal(clockSkewStatus{Skew: int64(skew / time.Second)})
+	if err == nil {
+		fn := clockSkewFile()
+		if err = os.MkdirAll(filepath.Dir(fn), 0755); err == nil {
+			err = helpers.AtomicWriteFile(fn, content, 0644, 0)
+		}
+	}
+	if err != nil {
+		logger.Noticef("Failed to record the clock skew: %v", err)
+	}
+}
+
+// isClockError returns true if err is a TLS error that is (likely)
+// caused by a wrong system clock
+func isClockError(err error) bool {
+	if urlErr, ok := err.(*url.Error); ok {
+		err = urlErr.Err
+	}
+
+	certErr, ok := err.(x509.CertificateInvalidError)
+	return ok && certErr.Reason == x509.Expired
+}
+
+// measureClockSkew asks the server for its Date without verifying its
+// certificate; nothing but the Date of the response is used
+func measureClockSkew(u *url.URL) (time.Duration, bool) {
+	req, err := http.NewRequest("HEAD", u.String(), nil)
+	if err != nil {
+		return 0, false
+	}
+
+	client := &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, false
+	}
+	resp.Body.Close()
+
+	return clockSkew(resp)
+}
+
+// doStoreRequestOnce does the request, keeping track of the clock skew.
+// If the request fails and the system clock is off, ErrClockSkew is
+// returned (instead of some confusing TLS error or status code).
+func doStoreRequestOnce(client *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		if !isClockError(err) {
+			return nil, err
+		}
+
+		skew, ok := measureClockSkew(req.URL)
+		if ok {
+			recordClockSkew(skew)
+		}
+		if ok && !tooSkewed(skew) {
+			return nil, err
+		}
+
+		return nil, &ErrClockSkew{Delta: skew, Err: err}
+	}
+
+	skew, ok := clockSkew(resp)
+	if !ok {
+		return resp, nil
+	}
+	recordClockSkew(skew)
+
+	if resp.StatusCode >= 400 && resp.StatusCode != 404 && tooSkewed(skew) {
+		resp.Body.Close()
+		return nil, &ErrClockSkew{Delta: skew, Err: fmt.Errorf("unexpected http statusCode %v", resp.StatusCode)}
+	}
+
+	return resp, nil
+}
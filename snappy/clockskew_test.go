@@ -0,0 +1,129 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/pkg"
+)
+
+// mockStoreWithDate returns a store that is offset ahead of the system
+// clock and replies with the given status code
+func mockStoreWithDate(c *C, offset time.Duration, code int) *httptest.Server {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(offset).UTC().Format(http.TimeFormat))
+		w.WriteHeader(code)
+	}))
+	c.Assert(mockServer, NotNil)
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	return mockServer
+}
+
+func (s *SnapTestSuite) TestClockSkewDetected(c *C) {
+	mockServer := mockStoreWithDate(c, 48*time.Hour, 401)
+	defer mockServer.Close()
+
+	_, err := NewUbuntuStoreSnapRepository().Details("hello-world", "canonical")
+	skewErr, ok := err.(*ErrClockSkew)
+	c.Assert(ok, Equals, true, Commentf("unexpected error %v", err))
+	c.Check(skewErr.Delta > 47*time.Hour && skewErr.Delta <= 48*time.Hour, Equals, true)
+
+	c.Check(clockTrustworthy(), Equals, false)
+	c.Check(correctedNow().Sub(time.Now()) > 47*time.Hour, Equals, true)
+}
+
+func (s *SnapTestSuite) TestClockSkewSmallIsIgnored(c *C) {
+	mockServer := mockStoreWithDate(c, time.Minute, 401)
+	defer mockServer.Close()
+
+	_, err := NewUbuntuStoreSnapRepository().Details("hello-world", "canonical")
+	c.Assert(err, NotNil)
+	_, ok := err.(*ErrClockSkew)
+	c.Check(ok, Equals, false)
+
+	c.Check(clockTrustworthy(), Equals, true)
+	_, err = os.Stat(clockSkewFile())
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *SnapTestSuite) TestClockSkewNotFoundIsNotFound(c *C) {
+	mockServer := mockStoreWithDate(c, -48*time.Hour, 404)
+	defer mockServer.Close()
+
+	_, err := NewUbuntuStoreSnapRepository().Details("hello-world", "canonical")
+	c.Check(err, Equals, ErrPackageNotFound)
+	c.Check(clockTrustworthy(), Equals, false)
+}
+
+func (s *SnapTestSuite) TestClockSkewFixed(c *C) {
+	recordClockSkew(48 * time.Hour)
+	c.Check(clockTrustworthy(), Equals, false)
+
+	recordClockSkew(time.Second)
+	c.Check(clockTrustworthy(), Equals, true)
+	c.Check(knownClockSkew(), Equals, time.Duration(0))
+}
+
+func (s *SnapTestSuite) TestClockSkewTimeSynced(c *C) {
+	recordClockSkew(48 * time.Hour)
+
+	fn := filepath.Join(dirs.GlobalRootDir, timeSyncedFile)
+	c.Assert(os.MkdirAll(filepath.Dir(fn), 0755), IsNil)
+	c.Assert(os.Symlink("/dev/null", fn), IsNil)
+
+	c.Check(clockTrustworthy(), Equals, true)
+}
+
+func (s *SnapTestSuite) TestIsClockError(c *C) {
+	certErr := x509.CertificateInvalidError{Reason: x509.Expired}
+	c.Check(isClockError(&url.Error{Op: "Get", URL: "https://example.com", Err: certErr}), Equals, true)
+	c.Check(isClockError(certErr), Equals, true)
+	c.Check(isClockError(x509.CertificateInvalidError{Reason: x509.NotAuthorizedToSign}), Equals, false)
+	c.Check(isClockError(ErrPackageNotFound), Equals, false)
+}
+
+func (s *SnapTestSuite) TestPurgeExpiredTrashClockSkew(c *C) {
+	makeTwoTestSnaps(c, pkg.TypeApp)
+	c.Assert(Remove("foo", 0, s.meter()), IsNil)
+
+	oldRetention := TrashRetention
+	TrashRetention = -time.Second
+	defer func() { TrashRetention = oldRetention }()
+
+	recordClockSkew(-48 * time.Hour)
+	c.Assert(PurgeExpiredTrash(), IsNil)
+	trashed, err := TrashedSnaps()
+	c.Assert(err, IsNil)
+	c.Check(trashed, HasLen, 1)
+}
@@ -23,8 +23,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	. "gopkg.in/check.v1"
 	"gopkg.in/yaml.v2"
@@ -232,6 +234,15 @@ type MockProgressMeter struct {
 	intro   string
 	license string
 	y       bool
+	// the Backend of the operation (a fresh mockBackend if unset)
+	backend Backend
+}
+
+func (m *MockProgressMeter) Backend() Backend {
+	if m.backend == nil {
+		m.backend = newMockBackend()
+	}
+	return m.backend
 }
 
 func (m *MockProgressMeter) Start(pkg string, total float64) {
@@ -272,3 +283,137 @@ syscall2
 func mockRunScFilterGen(argv ...string) ([]byte, error) {
 	return []byte(scFilterGenFakeResult), nil
 }
+
+// mockBackend is a Backend whose tools the tests replace
+type mockBackend struct {
+	systemctl       func(args ...string) ([]byte, error)
+	udevAdm         func(args ...string) ([]byte, error)
+	aaClickHook     func(args ...string) ([]byte, error)
+	scFilterGen     func(args ...string) ([]byte, error)
+	updateTimestamp func(path string) error
+	macSystem       string
+	runMACCmd       func(argv ...string) error
+	runSelfTest     func(cmd *exec.Cmd, timeout time.Duration) ([]byte, error)
+	runHook         func(hook, appArmorProfile string, env []string) error
+}
+
+func mockNoOutput(args ...string) ([]byte, error) {
+	return nil, nil
+}
+
+// newMockBackend returns a Backend where all tools succeed (and
+// systemctl reports the services as inactive) on an AppArmor system;
+// the hooks run through the aa-exec of the test
+func newMockBackend() *mockBackend {
+	return &mockBackend{
+		systemctl: func(args ...string) ([]byte, error) {
+			return []byte("ActiveState=inactive\n"), nil
+		},
+		udevAdm:         mockNoOutput,
+		aaClickHook:     mockNoOutput,
+		scFilterGen:     mockRunScFilterGen,
+		updateTimestamp: helpers.UpdateTimestamp,
+		macSystem:       "apparmor",
+		runMACCmd: func(argv ...string) error {
+			return nil
+		},
+		runSelfTest: func(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+			return nil, nil
+		},
+		runHook: runHookCmd,
+	}
+}
+
+func (b *mockBackend) Systemctl(args ...string) ([]byte, error) {
+	return b.systemctl(args...)
+}
+
+func (b *mockBackend) UdevAdm(args ...string) ([]byte, error) {
+	return b.udevAdm(args...)
+}
+
+func (b *mockBackend) RunUdevAdm(args ...string) error {
+	_, err := b.udevAdm(args...)
+	return err
+}
+
+func (b *mockBackend) AaClickHook(args ...string) ([]byte, error) {
+	return b.aaClickHook(args...)
+}
+
+func (b *mockBackend) ScFilterGen(args ...string) ([]byte, error) {
+	return b.scFilterGen(args...)
+}
+
+func (b *mockBackend) UpdateTimestamp(path string) error {
+	return b.updateTimestamp(path)
+}
+
+func (b *mockBackend) MACSystem() string {
+	return b.macSystem
+}
+
+func (b *mockBackend) RunMACCmd(argv ...string) error {
+	return b.runMACCmd(argv...)
+}
+
+func (b *mockBackend) RunSelfTest(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+	return b.runSelfTest(cmd, timeout)
+}
+
+func (b *mockBackend) RunHook(hook, appArmorProfile string, env []string) error {
+	return b.runHook(hook, appArmorProfile, env)
+}
+
+// hostBackend stands in for the tools of the host in the tests: the
+// operations of the tests get their Backend from their meter (see
+// SnapTestSuite.meter), and must not run the tools of the host
+type hostBackend struct{}
+
+func errHostTool(tool string) error {
+	return fmt.Errorf("test ran %s of the host: use a meter with a Backend", tool)
+}
+
+func (hostBackend) Systemctl(args ...string) ([]byte, error) {
+	return nil, errHostTool("systemctl")
+}
+
+func (hostBackend) UdevAdm(args ...string) ([]byte, error) {
+	return nil, errHostTool("udevadm")
+}
+
+func (hostBackend) RunUdevAdm(args ...string) error {
+	return errHostTool("udevadm")
+}
+
+func (hostBackend) AaClickHook(args ...string) ([]byte, error) {
+	return nil, errHostTool("aa-clickhook")
+}
+
+func (hostBackend) ScFilterGen(args ...string) ([]byte, error) {
+	return nil, errHostTool("sc-filtergen")
+}
+
+func (hostBackend) UpdateTimestamp(path string) error {
+	return errHostTool("touch")
+}
+
+func (hostBackend) MACSystem() string {
+	return "apparmor"
+}
+
+func (hostBackend) RunMACCmd(argv ...string) error {
+	return errHostTool(argv[0])
+}
+
+func (hostBackend) RunSelfTest(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+	return nil, errHostTool(cmd.Path)
+}
+
+func (hostBackend) RunHook(hook, appArmorProfile string, env []string) error {
+	return errHostTool(hook)
+}
+
+func init() {
+	defaultBackend = hostBackend{}
+}
@@ -0,0 +1,141 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"sync"
+
+	"github.com/ubuntu-core/snappy/pkg"
+)
+
+// knownConfinement are the confinement levels a snap can ask for
+var knownConfinement = []pkg.Confinement{pkg.ConfinementStrict, pkg.ConfinementDevMode, pkg.ConfinementClassic}
+
+// defaultAllowedConfinement is what a device accepts unless its oem
+// package says otherwise
+var defaultAllowedConfinement = []pkg.Confinement{pkg.ConfinementStrict}
+
+func isKnownConfinement(confinement pkg.Confinement) bool {
+	return hasConfinement(knownConfinement, confinement)
+}
+
+func hasConfinement(levels []pkg.Confinement, confinement pkg.Confinement) bool {
+	for _, c := range levels {
+		if c == confinement {
+			return true
+		}
+	}
+
+	return false
+}
+
+// confinement returns the confinement the snap asks for, snaps that do
+// not say expect to be strictly confined
+func (m *packageYaml) confinement() pkg.Confinement {
+	if m.Confinement == "" {
+		return pkg.ConfinementStrict
+	}
+
+	return m.Confinement
+}
+
+// seccompComplain makes the launcher load a seccomp policy in complain
+// mode, when it is its first line
+const seccompComplain = "@complain"
+
+// seccompUnrestricted makes the launcher not load any seccomp policy,
+// when it is its first line
+const seccompUnrestricted = "@unrestricted"
+
+// enforced returns whether the security policy of the snap is
+// enforced: the one of snaps in developer mode only logs what it
+// would deny, and classic snaps run unconfined (with their AppArmor
+// profile, that the launcher always enters, in complain mode)
+func (m *packageYaml) enforced() bool {
+	switch m.confinement() {
+	case pkg.ConfinementDevMode, pkg.ConfinementClassic:
+		return false
+	}
+
+	return true
+}
+
+// Confinement returns how the snap is confined; a snap installed in
+// developer mode is in devmode confinement whatever it asks for
+func (s *SnapPart) Confinement() pkg.Confinement {
+	return s.m.confinement()
+}
+
+// DevMode returns whether the snap is in developer mode, i.e. its
+// security policy is in complain mode, logging what it would deny
+func (s *SnapPart) DevMode() bool {
+	return s.Confinement() == pkg.ConfinementDevMode
+}
+
+var (
+	allowedConfinementMu     sync.Mutex
+	allowedConfinementCached []pkg.Confinement
+)
+
+// AllowedConfinement returns the confinement levels of the snaps the
+// device accepts, as set by the oem package. It is read once, until
+// an oem package gets activated or deactivated.
+func AllowedConfinement() []pkg.Confinement {
+	allowedConfinementMu.Lock()
+	defer allowedConfinementMu.Unlock()
+
+	if allowedConfinementCached == nil {
+		allowedConfinementCached = loadAllowedConfinement()
+	}
+
+	return allowedConfinementCached
+}
+
+func loadAllowedConfinement() []pkg.Confinement {
+	oem, err := getOem()
+	if err != nil || len(oem.OEM.Software.AllowedConfinement) == 0 {
+		return defaultAllowedConfinement
+	}
+
+	return oem.OEM.Software.AllowedConfinement
+}
+
+// forgetAllowedConfinement makes AllowedConfinement read the oem
+// package again
+func forgetAllowedConfinement() {
+	allowedConfinementMu.Lock()
+	allowedConfinementCached = nil
+	allowedConfinementMu.Unlock()
+}
+
+// checkConfinement returns an error if the device does not accept
+// snaps with the given confinement
+func checkConfinement(name string, confinement pkg.Confinement) error {
+	if confinement == "" {
+		confinement = pkg.ConfinementStrict
+	}
+
+	allowed := AllowedConfinement()
+	if !hasConfinement(allowed, confinement) {
+		return &ErrConfinementNotAllowed{Snap: name, Confinement: confinement, Allowed: allowed}
+	}
+
+	return nil
+}
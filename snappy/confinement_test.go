@@ -0,0 +1,260 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/pkg/remote"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+func mockOemAllowedConfinement(confinement ...pkg.Confinement) func() {
+	getOem = func() (*packageYaml, error) {
+		return &packageYaml{OEM: OEM{Software: Software{AllowedConfinement: confinement}}}, nil
+	}
+	forgetAllowedConfinement()
+
+	return func() {
+		getOem = getOemImpl
+		forgetAllowedConfinement()
+	}
+}
+
+func (s *SnapTestSuite) TestAllowedConfinementDefault(c *C) {
+	c.Check(AllowedConfinement(), DeepEquals, []pkg.Confinement{pkg.ConfinementStrict})
+}
+
+func (s *SnapTestSuite) TestAllowedConfinementFromOem(c *C) {
+	defer mockOemAllowedConfinement(pkg.ConfinementStrict, pkg.ConfinementDevMode)()
+
+	c.Check(AllowedConfinement(), DeepEquals, []pkg.Confinement{pkg.ConfinementStrict, pkg.ConfinementDevMode})
+	c.Check(checkConfinement("foo", pkg.ConfinementDevMode), IsNil)
+	c.Check(checkConfinement("foo", pkg.ConfinementClassic), FitsTypeOf, &ErrConfinementNotAllowed{})
+}
+
+func (s *SnapTestSuite) TestAllowedConfinementReadOnce(c *C) {
+	read := 0
+	getOem = func() (*packageYaml, error) {
+		read++
+		return &packageYaml{OEM: OEM{Software: Software{AllowedConfinement: []pkg.Confinement{pkg.ConfinementClassic}}}}, nil
+	}
+	defer func() { getOem = getOemImpl }()
+
+	c.Check(AllowedConfinement(), DeepEquals, []pkg.Confinement{pkg.ConfinementClassic})
+	c.Check(AllowedConfinement(), DeepEquals, []pkg.Confinement{pkg.ConfinementClassic})
+	c.Check(read, Equals, 1)
+
+	// until an oem package gets activated
+	forgetAllowedConfinement()
+	AllowedConfinement()
+	c.Check(read, Equals, 2)
+}
+
+func (s *SnapTestSuite) TestCheckConfinement(c *C) {
+	c.Check(checkConfinement("foo", ""), IsNil)
+	c.Check(checkConfinement("foo", pkg.ConfinementStrict), IsNil)
+
+	err := checkConfinement("foo", pkg.ConfinementDevMode)
+	c.Check(err, ErrorMatches, "foo asks for devmode confinement, the device only accepts strict")
+}
+
+func (s *SnapTestSuite) TestPackageYamlConfinement(c *C) {
+	m, err := parsePackageYamlData([]byte(`name: foo
+version: 1.0
+vendor: foo
+`), false)
+	c.Assert(err, IsNil)
+	c.Check(m.confinement(), Equals, pkg.ConfinementStrict)
+
+	m, err = parsePackageYamlData([]byte(`name: foo
+version: 1.0
+vendor: foo
+confinement: devmode
+`), false)
+	c.Assert(err, IsNil)
+	c.Check(m.confinement(), Equals, pkg.ConfinementDevMode)
+}
+
+func (s *SnapTestSuite) TestPackageYamlConfinementInvalid(c *C) {
+	_, err := parsePackageYamlData([]byte(`name: foo
+version: 1.0
+vendor: foo
+confinement: loose
+`), false)
+	c.Check(err, ErrorMatches, `.*invalid confinement "loose".*`)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryConfinementHeader(c *C) {
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	c.Assert(err, IsNil)
+
+	setUbuntuStoreHeaders(req, nil)
+	c.Check(req.Header.Get("X-Ubuntu-Confinement"), Equals, "strict")
+
+	defer mockOemAllowedConfinement(pkg.ConfinementStrict, pkg.ConfinementClassic)()
+	setUbuntuStoreHeaders(req, nil)
+	c.Check(req.Header.Get("X-Ubuntu-Confinement"), Equals, "strict,classic")
+}
+
+func (s *SnapTestSuite) TestRemoteSnapPartInstallConfinementNotAllowed(c *C) {
+	part := &RemoteSnapPart{pkg: remote.Snap{Name: "foo", Confinement: pkg.ConfinementClassic}}
+
+	// refused before anything gets downloaded
+	_, err := part.Install(&progress.NullProgress{}, 0)
+	c.Check(err, FitsTypeOf, &ErrConfinementNotAllowed{})
+}
+
+func (s *SnapTestSuite) TestLocalSnapInstallConfinementNotAllowed(c *C) {
+	snapFile := makeTestSnapPackage(c, "name: foo\nversion: 1.0\nvendor: foo\nconfinement: devmode\n")
+
+	_, err := installClick(snapFile, AllowUnauthenticated, s.meter(), testOrigin)
+	c.Check(err, FitsTypeOf, &ErrConfinementNotAllowed{})
+
+	defer mockOemAllowedConfinement(pkg.ConfinementStrict, pkg.ConfinementDevMode)()
+	_, err = installClick(snapFile, AllowUnauthenticated, s.meter(), testOrigin)
+	c.Check(err, IsNil)
+}
+
+func (s *SnapTestSuite) TestDevModeSecurityPolicy(c *C) {
+	m, err := parsePackageYamlData([]byte(`name: foo
+version: 1.0
+vendor: foo
+binaries:
+ - name: foo
+`), false)
+	c.Assert(err, IsNil)
+
+	var cmds [][]string
+	s.backend.runMACCmd = func(argv ...string) error {
+		cmds = append(cmds, argv)
+		return nil
+	}
+	dirs.SnapSeccompDir = c.MkDir()
+	profile := filepath.Join(dirs.SnapProfilesDir, "click_foo.mvo_foo_1.0")
+	c.Assert(os.MkdirAll(dirs.SnapProfilesDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(profile, []byte("#include <tunables/global>\nprofile \"foo.mvo_foo_1.0\" (attach_disconnected) {\n}\n"), 0644), IsNil)
+
+	// strictly confined snaps are left alone
+	c.Assert(m.addSecurityPolicy("/apps/foo.mvo/1.0/", s.backend), IsNil)
+	content, err := ioutil.ReadFile(filepath.Join(dirs.SnapSeccompDir, "foo.mvo_foo_1.0"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, scFilterGenFakeResult)
+	c.Check(cmds, HasLen, 0)
+
+	m.Confinement = pkg.ConfinementDevMode
+	c.Assert(m.addSecurityPolicy("/apps/foo.mvo/1.0/", s.backend), IsNil)
+	content, err = ioutil.ReadFile(filepath.Join(dirs.SnapSeccompDir, "foo.mvo_foo_1.0"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "@complain\n"+scFilterGenFakeResult)
+	c.Check(cmds, DeepEquals, [][]string{{"apparmor_parser", "--replace", "--write-cache", "--cache-loc", dirs.SnapAppArmorCacheDir, profile}})
+
+	// in the profile itself, so that it stays in complain mode
+	content, err = ioutil.ReadFile(profile)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "#include <tunables/global>\nprofile \"foo.mvo_foo_1.0\" flags=(attach_disconnected,complain) {\n}\n")
+}
+
+func (s *SnapTestSuite) TestClassicSecurityPolicy(c *C) {
+	m, err := parsePackageYamlData([]byte(`name: foo
+version: 1.0
+vendor: foo
+confinement: classic
+binaries:
+ - name: foo
+`), false)
+	c.Assert(err, IsNil)
+	c.Check(m.enforced(), Equals, false)
+
+	var cmds [][]string
+	s.backend.runMACCmd = func(argv ...string) error {
+		cmds = append(cmds, argv)
+		return nil
+	}
+	s.backend.scFilterGen = func(args ...string) ([]byte, error) {
+		c.Fatal("no seccomp policy should be generated")
+		return nil, nil
+	}
+	dirs.SnapSeccompDir = c.MkDir()
+	profile := filepath.Join(dirs.SnapProfilesDir, "click_foo.mvo_foo_1.0")
+	c.Assert(os.MkdirAll(dirs.SnapProfilesDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(profile, []byte("profile \"foo.mvo_foo_1.0\" {\n}\n"), 0644), IsNil)
+
+	c.Assert(m.addSecurityPolicy("/apps/foo.mvo/1.0/", s.backend), IsNil)
+	content, err := ioutil.ReadFile(filepath.Join(dirs.SnapSeccompDir, "foo.mvo_foo_1.0"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "@unrestricted\n")
+	c.Check(cmds, HasLen, 1)
+	content, err = ioutil.ReadFile(profile)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "profile \"foo.mvo_foo_1.0\" flags=(complain) {\n}\n")
+}
+
+func (s *SnapTestSuite) TestAddComplainFlag(c *C) {
+	for _, t := range [][2]string{
+		{"profile foo {\n}", "profile foo flags=(complain) {\n}"},
+		{"profile foo (attach_disconnected) {", "profile foo flags=(attach_disconnected,complain) {"},
+		{"  profile foo flags=(attach_disconnected, mediate_deleted) {", "  profile foo flags=(attach_disconnected,mediate_deleted,complain) {"},
+		{"profile foo flags=(complain) {", "profile foo flags=(complain) {"},
+		{"# no profile here", "# no profile here"},
+	} {
+		c.Check(string(addComplainFlag([]byte(t[0]))), Equals, t[1])
+	}
+}
+
+func (s *SnapTestSuite) TestDevModeRefusedByDevice(c *C) {
+	m, err := parsePackageYamlData([]byte("name: foo\nversion: 1.0\nvendor: foo\n"), false)
+	c.Assert(err, IsNil)
+	part := &SnapPart{m: m, origin: testOrigin, basedir: filepath.Join(dirs.SnapAppsDir, "foo."+testOrigin, "1.0")}
+
+	_, err = part.Install(&MockProgressMeter{}, DeveloperMode|DryRun)
+	c.Assert(err, FitsTypeOf, &ErrConfinementNotAllowed{})
+	c.Check(err.(*ErrConfinementNotAllowed).Confinement, Equals, pkg.ConfinementDevMode)
+
+	defer mockOemAllowedConfinement(pkg.ConfinementStrict, pkg.ConfinementDevMode)()
+	_, err = part.Install(&MockProgressMeter{}, DeveloperMode|DryRun)
+	c.Check(err, IsNil)
+}
+
+func (s *SnapTestSuite) TestDevModeRecordedInManifest(c *C) {
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+
+	part, err := NewInstalledSnapPart(yamlPath, testOrigin)
+	c.Assert(err, IsNil)
+	c.Check(part.Confinement(), Equals, pkg.ConfinementStrict)
+	c.Check(part.DevMode(), Equals, false)
+
+	clickMetaDir := filepath.Join(part.basedir, ".click", "info")
+	c.Assert(os.MkdirAll(clickMetaDir, 0755), IsNil)
+	c.Assert(writeCompatManifestJSON(clickMetaDir, []byte(`{"name": "hello-app"}`), testOrigin, pkg.ConfinementDevMode), IsNil)
+
+	part, err = NewInstalledSnapPart(yamlPath, testOrigin)
+	c.Assert(err, IsNil)
+	c.Check(part.Confinement(), Equals, pkg.ConfinementDevMode)
+	c.Check(part.DevMode(), Equals, true)
+}
@@ -0,0 +1,56 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// SetContext makes the requests to the store, and the downloads of the
+// snaps it returns, stop when the given context is done (cancelled, or
+// past its deadline); nil is for them to never stop but on their
+// timeout, if any
+func (s *SnapUbuntuStoreRepository) SetContext(ctx context.Context) {
+	s.ctx = ctx
+}
+
+// newStoreRequest returns a new request that stops when the given
+// context (if not nil) is done
+func newStoreRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	return http.NewRequestWithContext(ctx, method, url, body)
+}
+
+// sleepContext sleeps for d, or until the context is done
+func sleepContext(ctx context.Context, d time.Duration) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,122 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/pkg/remote"
+)
+
+func (s *SnapTestSuite) TestStoreRequestsCancelled(c *C) {
+	requests := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		io.WriteString(w, `{"origin": "foo", "package_name": "bar", "version": "1.0"}`)
+	}))
+	defer mockServer.Close()
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	repo := NewUbuntuStoreSnapRepository()
+	repo.SetContext(ctx)
+
+	parts, err := repo.Details("bar", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 1)
+	// and so do the downloads
+	c.Check(parts[0].(*RemoteSnapPart).ctx, Equals, ctx)
+
+	cancel()
+	_, err = repo.Details("bar", "foo")
+	c.Check(err, ErrorMatches, ".*context canceled")
+	c.Check(requests, Equals, 1)
+}
+
+func (s *SnapTestSuite) TestStoreRequestsNoRetryOnceCancelled(c *C) {
+	requests := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer mockServer.Close()
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	retrySleep = func(context.Context, time.Duration) { cancel() }
+	repo := NewUbuntuStoreSnapRepository()
+	repo.SetContext(ctx)
+
+	_, err = repo.Details("bar", "foo")
+	c.Check(err, Equals, context.Canceled)
+	c.Check(requests, Equals, 1)
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadStalled(c *C) {
+	done := make(chan struct{})
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "1000")
+		io.WriteString(w, "a bit")
+		w.(http.Flusher).Flush()
+		// and nothing more
+		select {
+		case <-done:
+		case <-r.Context().Done():
+		}
+	}))
+	defer mockServer.Close()
+	defer close(done)
+
+	snap := NewRemoteSnapPart(remote.Snap{
+		Name:            "foo",
+		AnonDownloadURL: mockServer.URL + "/snap",
+		DownloadSha512:  sha512sum("foo"),
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	snap.ctx = ctx
+
+	start := time.Now()
+	_, err := snap.Download(&MockProgressMeter{})
+	c.Check(err, NotNil)
+	c.Check(time.Since(start) < 5*time.Second, Equals, true)
+}
+
+func (s *SnapTestSuite) TestSleepContext(c *C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	sleepContext(ctx, time.Hour)
+	c.Check(time.Since(start) < time.Minute, Equals, true)
+}
@@ -0,0 +1,192 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/ubuntu-core/snappy/dirs"
+)
+
+// DataMode is the permissions and group the data directory of a snap
+// is created with, for snaps that share their data with a group of the
+// system (e.g. "video")
+type DataMode struct {
+	// Mode is the octal permission bits (e.g. "0750")
+	Mode string `yaml:"mode,omitempty"`
+	// Group is the system group that owns the directory
+	Group string `yaml:"group,omitempty"`
+}
+
+// defaultDataDirMode is what data directories get unless the snap (or
+// the oem package) says otherwise
+const defaultDataDirMode os.FileMode = 0755
+
+// the group can not be one that would make the data of the snap
+// writable (or readable) by everything that is already privileged
+var forbiddenDataGroups = []string{"root"}
+
+var validDataGroup = regexp.MustCompile(`^[a-z_][a-z0-9_-]*$`)
+
+// groupFile is where the system groups are looked up
+var groupFile = "/etc/group"
+
+// chown is mocked in the tests, changing the group needs privileges
+var chown = os.Chown
+
+// perm returns the permissions of the data directory
+func (d *DataMode) perm() (os.FileMode, error) {
+	if d == nil || d.Mode == "" {
+		return defaultDataDirMode, nil
+	}
+
+	mode, err := strconv.ParseUint(d.Mode, 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid data mode %q", d.Mode)
+	}
+
+	perm := os.FileMode(mode) & os.ModePerm
+	if mode&02000 != 0 {
+		// setgid, so what gets created in it stays in the group
+		perm |= os.ModeSetgid
+	}
+
+	return perm, nil
+}
+
+// validate checks the data mode against the security model: the snap
+// keeps full access to its data, nothing but the snap and its group
+// can write to it, and nothing in it runs with other privileges
+func (d *DataMode) validate() error {
+	if d == nil {
+		return nil
+	}
+
+	perm, err := d.perm()
+	if err != nil {
+		return err
+	}
+	if mode, _ := strconv.ParseUint(d.Mode, 8, 32); mode&^02777 != 0 {
+		return fmt.Errorf("data mode %q can not be setuid or sticky", d.Mode)
+	}
+	if perm&0700 != 0700 {
+		return fmt.Errorf("data mode %q does not give the snap full access to its data", d.Mode)
+	}
+	if perm&0002 != 0 {
+		return fmt.Errorf("data mode %q makes the data writable by everyone", d.Mode)
+	}
+	if perm&0020 != 0 && d.Group == "" {
+		return fmt.Errorf("data mode %q is group writable but does not name a group", d.Mode)
+	}
+
+	if d.Group != "" {
+		if !validDataGroup.MatchString(d.Group) {
+			return fmt.Errorf("invalid data group %q", d.Group)
+		}
+		for _, g := range forbiddenDataGroups {
+			if d.Group == g {
+				return fmt.Errorf("data group %q is not allowed", d.Group)
+			}
+		}
+	}
+
+	return nil
+}
+
+// dataMode returns the data mode of the snap, if the snap does not say
+// it is the default of the oem package (nil if neither does)
+func (m *packageYaml) dataMode() *DataMode {
+	if m.DataMode != nil {
+		return m.DataMode
+	}
+
+	oem, err := getOem()
+	if err != nil {
+		return nil
+	}
+
+	return oem.OEM.Software.DataMode
+}
+
+// lookupGroup returns the id of the system group with the given name
+func lookupGroup(name string) (int, error) {
+	f, err := os.Open(filepath.Join(dirs.GlobalRootDir, groupFile))
+	if err != nil {
+		return -1, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// name:password:gid:members
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 3 || fields[0] != name {
+			continue
+		}
+
+		return strconv.Atoi(fields[2])
+	}
+	if err := scanner.Err(); err != nil {
+		return -1, err
+	}
+
+	return -1, &ErrUnknownGroup{Group: name}
+}
+
+// makeDataDir creates the (system) data directory of a snap, or sets
+// the permissions and group of it if it exists (e.g. copied from the
+// previous version), as the data mode says
+func makeDataDir(dataDir string, mode *DataMode) error {
+	if err := mode.validate(); err != nil {
+		return err
+	}
+
+	perm, err := mode.perm()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dataDir, perm); err != nil {
+		return err
+	}
+
+	// the data copied from the old version keeps its permissions, and
+	// MkdirAll is subject to the umask
+	if err := os.Chmod(dataDir, perm); err != nil {
+		return err
+	}
+
+	if mode == nil || mode.Group == "" {
+		return nil
+	}
+
+	gid, err := lookupGroup(mode.Group)
+	if err != nil {
+		return err
+	}
+
+	return chown(dataDir, -1, gid)
+}
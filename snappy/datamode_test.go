@@ -0,0 +1,161 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+)
+
+func mockChown(c *C) (chowned map[string]int, restore func()) {
+	chowned = make(map[string]int)
+	chown = func(name string, uid, gid int) error {
+		c.Check(uid, Equals, -1)
+		chowned[name] = gid
+		return nil
+	}
+
+	return chowned, func() { chown = os.Chown }
+}
+
+func mockGroupFile(c *C, content string) {
+	fn := filepath.Join(dirs.GlobalRootDir, groupFile)
+	c.Assert(os.MkdirAll(filepath.Dir(fn), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(fn, []byte(content), 0644), IsNil)
+}
+
+func (s *SnapTestSuite) TestDataModeValidate(c *C) {
+	for _, t := range []struct {
+		mode DataMode
+		err  string
+	}{
+		{DataMode{}, ""},
+		{DataMode{Mode: "0750"}, ""},
+		{DataMode{Mode: "2770", Group: "video"}, ""},
+		{DataMode{Mode: "0755", Group: "audio"}, ""},
+		{DataMode{Mode: "rwx"}, `invalid data mode "rwx"`},
+		{DataMode{Mode: "4755"}, `data mode "4755" can not be setuid or sticky`},
+		{DataMode{Mode: "1770"}, `data mode "1770" can not be setuid or sticky`},
+		{DataMode{Mode: "0550"}, `data mode "0550" does not give the snap full access to its data`},
+		{DataMode{Mode: "0777"}, `data mode "0777" makes the data writable by everyone`},
+		{DataMode{Mode: "0770"}, `data mode "0770" is group writable but does not name a group`},
+		{DataMode{Mode: "0770", Group: "root"}, `data group "root" is not allowed`},
+		{DataMode{Group: "Video:"}, `invalid data group "Video:"`},
+	} {
+		err := t.mode.validate()
+		if t.err == "" {
+			c.Check(err, IsNil, Commentf("%v", t.mode))
+		} else {
+			c.Check(err, ErrorMatches, t.err, Commentf("%v", t.mode))
+		}
+	}
+
+	var mode *DataMode
+	c.Check(mode.validate(), IsNil)
+}
+
+func (s *SnapTestSuite) TestPackageYamlDataMode(c *C) {
+	m, err := parsePackageYamlData([]byte(`name: foo
+version: 1.0
+vendor: foo
+data-mode:
+  mode: "2770"
+  group: video
+`), false)
+	c.Assert(err, IsNil)
+	c.Check(m.dataMode(), DeepEquals, &DataMode{Mode: "2770", Group: "video"})
+
+	_, err = parsePackageYamlData([]byte(`name: foo
+version: 1.0
+vendor: foo
+data-mode:
+  mode: "0777"
+`), false)
+	c.Check(err, ErrorMatches, `.*makes the data writable by everyone.*`)
+}
+
+func (s *SnapTestSuite) TestDataModeOemDefault(c *C) {
+	m := &packageYaml{}
+	c.Check(m.dataMode(), IsNil)
+
+	getOem = func() (*packageYaml, error) {
+		return &packageYaml{OEM: OEM{Software: Software{DataMode: &DataMode{Mode: "0750"}}}}, nil
+	}
+	defer func() { getOem = getOemImpl }()
+
+	c.Check(m.dataMode(), DeepEquals, &DataMode{Mode: "0750"})
+
+	m.DataMode = &DataMode{Mode: "0700"}
+	c.Check(m.dataMode(), DeepEquals, &DataMode{Mode: "0700"})
+}
+
+func (s *SnapTestSuite) TestMakeDataDirDefault(c *C) {
+	chowned, restore := mockChown(c)
+	defer restore()
+
+	dataDir := filepath.Join(dirs.SnapDataDir, "foo.bar", "1.0")
+	c.Assert(makeDataDir(dataDir, nil), IsNil)
+
+	st, err := os.Stat(dataDir)
+	c.Assert(err, IsNil)
+	c.Check(st.Mode(), Equals, os.ModeDir|0755)
+	c.Check(chowned, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestMakeDataDirGroup(c *C) {
+	chowned, restore := mockChown(c)
+	defer restore()
+	mockGroupFile(c, "root:x:0:\nvideo:x:44:ubuntu\n")
+
+	// an existing directory (copied from the old version) is fixed up
+	dataDir := filepath.Join(dirs.SnapDataDir, "foo.bar", "1.0")
+	c.Assert(os.MkdirAll(dataDir, 0755), IsNil)
+
+	c.Assert(makeDataDir(dataDir, &DataMode{Mode: "2770", Group: "video"}), IsNil)
+
+	st, err := os.Stat(dataDir)
+	c.Assert(err, IsNil)
+	c.Check(st.Mode(), Equals, os.ModeDir|os.ModeSetgid|0770)
+	c.Check(chowned, DeepEquals, map[string]int{dataDir: 44})
+}
+
+func (s *SnapTestSuite) TestMakeDataDirUnknownGroup(c *C) {
+	_, restore := mockChown(c)
+	defer restore()
+	mockGroupFile(c, "root:x:0:\n")
+
+	dataDir := filepath.Join(dirs.SnapDataDir, "foo.bar", "1.0")
+	err := makeDataDir(dataDir, &DataMode{Mode: "0750", Group: "video"})
+	c.Check(err, FitsTypeOf, &ErrUnknownGroup{})
+	c.Check(err, ErrorMatches, `unknown group "video"`)
+}
+
+func (s *SnapTestSuite) TestMakeDataDirInvalid(c *C) {
+	dataDir := filepath.Join(dirs.SnapDataDir, "foo.bar", "1.0")
+	c.Check(makeDataDir(dataDir, &DataMode{Mode: "0777"}), NotNil)
+
+	_, err := os.Stat(dataDir)
+	c.Check(os.IsNotExist(err), Equals, true)
+}
@@ -0,0 +1,191 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/pkg/remote"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// the formats of the deltas the store may send
+const (
+	deltaFormatXdelta3 = "xdelta3"
+)
+
+// errNoDelta is returned when there is no delta to update with
+var errNoDelta = errors.New("no delta")
+
+// supportedDeltaFormats returns the formats of the deltas that can be
+// applied on this system (the store is told about them)
+var supportedDeltaFormats = supportedDeltaFormatsImpl
+
+func supportedDeltaFormatsImpl() []string {
+	if _, err := exec.LookPath("xdelta3"); err != nil {
+		return nil
+	}
+
+	return []string{deltaFormatXdelta3}
+}
+
+func deltaFormatSupported(format string) bool {
+	for _, f := range supportedDeltaFormats() {
+		if f == format {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyDelta applies the delta (of the given format) to base, writing
+// the result to out
+var applyDelta = applyDeltaImpl
+
+func applyDeltaImpl(format, base, delta, out string) error {
+	switch format {
+	case deltaFormatXdelta3:
+		output, err := exec.Command("xdelta3", "-d", "-f", "-s", base, delta, out).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("xdelta3 failed: %v (%q)", err, output)
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unknown delta format %q", format)
+}
+
+// deltaBase returns the snap file the installed snap was installed
+// from, that the deltas apply to: its entry in the download cache
+// (that is kept for as long as the snap is active, see pruneCache), or
+// "" if there is none
+func deltaBase(installed Part) string {
+	sha512 := downloadSha512(installed)
+	if sha512 == "" {
+		return ""
+	}
+
+	return cachePath(sha512)
+}
+
+// downloadSha512 returns the sha512 of the snap file the part was
+// installed from, if the store said what it is
+func downloadSha512(part Part) string {
+	if s, ok := part.(*SnapPart); ok && s.remoteM != nil {
+		return s.remoteM.DownloadSha512
+	}
+
+	return ""
+}
+
+// delta returns the delta from the installed version of the snap to
+// this one, and the snap file to apply it to
+func (s *RemoteSnapPart) delta() (*remote.Delta, string) {
+	// the result could not be verified
+	if s.pkg.DownloadSha512 == "" {
+		return nil, ""
+	}
+
+	installed := ActiveSnapByName(s.Name())
+	if installed == nil || installed.Origin() != s.Origin() {
+		return nil, ""
+	}
+
+	base := deltaBase(installed)
+	if base == "" || !helpers.FileExists(base) {
+		return nil, ""
+	}
+
+	for i, d := range s.pkg.Deltas {
+		if d.FromVersion == installed.Version() && deltaFormatSupported(d.Format) {
+			return &s.pkg.Deltas[i], base
+		}
+	}
+
+	return nil, ""
+}
+
+// downloadDelta downloads the delta from the installed version of the
+// snap, if the store has one, and applies it; it returns the resulting
+// snap file, that is what the store says the snap is
+func (s *RemoteSnapPart) downloadDelta(pbar progress.Meter) (fn string, err error) {
+	d, base := s.delta()
+	if d == nil {
+		return "", errNoDelta
+	}
+
+	deltaFile, err := ioutil.TempFile("", s.pkg.Name+".delta")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(deltaFile.Name())
+	defer deltaFile.Close()
+
+	url := d.AnonDownloadURL
+	if url == "" || !s.AllowUnauthenticated() || (d.DownloadURL != "" && s.authenticated()) {
+		url = d.DownloadURL
+	}
+	if err := s.fetch(s.Name(), url, true, deltaFile, pbar); err != nil {
+		return "", err
+	}
+
+	if d.DownloadSha512 != "" {
+		sha512, err := helpers.Sha512sum(deltaFile.Name())
+		if err != nil {
+			return "", err
+		}
+		if sha512 != d.DownloadSha512 {
+			return "", &ErrHashMismatch{Snap: s.Name() + " delta", Expected: d.DownloadSha512, Got: sha512}
+		}
+	}
+
+	if err := os.MkdirAll(dirs.SnapDownloadsDir, 0700); err != nil {
+		return "", err
+	}
+	w, err := ioutil.TempFile(dirs.SnapDownloadsDir, s.pkg.Name)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		if err != nil {
+			os.Remove(w.Name())
+		}
+	}()
+	defer w.Close()
+
+	logger.Noticef("Updating %s from %s with a %s delta", s.Name(), d.FromVersion, d.Format)
+	if err := applyDelta(d.Format, base, deltaFile.Name(), w.Name()); err != nil {
+		return "", err
+	}
+
+	if err := s.verifyDownload(w); err != nil {
+		return "", err
+	}
+
+	return w.Name(), nil
+}
@@ -0,0 +1,203 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg/remote"
+)
+
+// mockDeltas makes deltas appendable: the result of a delta is the
+// base followed by the delta
+func mockDeltas(c *C) {
+	supportedDeltaFormats = func() []string {
+		return []string{deltaFormatXdelta3}
+	}
+	applyDelta = func(format, base, delta, out string) error {
+		c.Check(format, Equals, deltaFormatXdelta3)
+		b, err := ioutil.ReadFile(base)
+		c.Assert(err, IsNil)
+		d, err := ioutil.ReadFile(delta)
+		c.Assert(err, IsNil)
+
+		return ioutil.WriteFile(out, append(b, d...), 0600)
+	}
+}
+
+// mockDeltaStore serves the full snap, and the delta (from 1.0) to it
+func mockDeltaStore(fetched *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*fetched = append(*fetched, r.URL.Path)
+		switch r.URL.Path {
+		case "/snap":
+			io.WriteString(w, "old-new")
+		case "/delta":
+			io.WriteString(w, "-new")
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+}
+
+// installDeltaBase installs version 1.0 of foo, as if from its snap
+// file with the given content (that is in the download cache)
+func (s *SnapTestSuite) installDeltaBase(c *C, content string) {
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, "name: foo\nversion: 1.0\nvendor: foo\n")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	manifest, err := yaml.Marshal(remote.Snap{Name: "foo", Origin: testOrigin, Version: "1.0", DownloadSha512: sha512sum(content)})
+	c.Assert(err, IsNil)
+	c.Assert(os.MkdirAll(dirs.SnapMetaDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapMetaDir, "foo."+testOrigin+"_1.0.manifest"), manifest, 0644), IsNil)
+
+	c.Assert(os.MkdirAll(dirs.SnapCacheDir, 0700), IsNil)
+	c.Assert(ioutil.WriteFile(cachePath(sha512sum(content)), []byte(content), 0600), IsNil)
+}
+
+func (s *SnapTestSuite) deltaSnapPart(url string) *RemoteSnapPart {
+	snap := &RemoteSnapPart{}
+	snap.pkg.Name = "foo"
+	snap.pkg.Origin = testOrigin
+	snap.pkg.Version = "2.0"
+	snap.pkg.AnonDownloadURL = url + "/snap"
+	snap.pkg.DownloadSha512 = sha512sum("old-new")
+	snap.pkg.Deltas = []remote.Delta{{
+		FromVersion:     "1.0",
+		Format:          deltaFormatXdelta3,
+		AnonDownloadURL: url + "/delta",
+		DownloadSha512:  sha512sum("-new"),
+	}}
+
+	return snap
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadDelta(c *C) {
+	mockDeltas(c)
+	s.installDeltaBase(c, "old")
+
+	var fetched []string
+	mockServer := mockDeltaStore(&fetched)
+	defer mockServer.Close()
+
+	fn, err := s.deltaSnapPart(mockServer.URL).Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	defer os.Remove(fn)
+
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "old-new")
+	c.Check(fetched, DeepEquals, []string{"/delta"})
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadDeltaMismatchFallsBack(c *C) {
+	mockDeltas(c)
+	// not what the delta was made for
+	s.installDeltaBase(c, "older")
+
+	var fetched []string
+	mockServer := mockDeltaStore(&fetched)
+	defer mockServer.Close()
+
+	fn, err := s.deltaSnapPart(mockServer.URL).Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	defer os.Remove(fn)
+
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "old-new")
+	c.Check(fetched, DeepEquals, []string{"/delta", "/snap"})
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadNoDelta(c *C) {
+	mockDeltas(c)
+
+	var fetched []string
+	mockServer := mockDeltaStore(&fetched)
+	defer mockServer.Close()
+
+	// nothing installed
+	snap := s.deltaSnapPart(mockServer.URL)
+	fn, err := snap.Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	os.Remove(fn)
+	c.Check(fetched, DeepEquals, []string{"/snap"})
+	// not from the download cache either
+	c.Assert(os.RemoveAll(dirs.SnapCacheDir), IsNil)
+
+	// installed, but not a version there is a delta from
+	s.installDeltaBase(c, "old")
+	snap.pkg.Deltas[0].FromVersion = "0.9"
+	fn, err = snap.Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	os.Remove(fn)
+	c.Check(fetched, DeepEquals, []string{"/snap", "/snap"})
+	c.Assert(os.RemoveAll(dirs.SnapCacheDir), IsNil)
+
+	// no way to apply the delta
+	snap.pkg.Deltas[0].FromVersion = "1.0"
+	supportedDeltaFormats = func() []string { return nil }
+	fn, err = snap.Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	os.Remove(fn)
+	c.Check(fetched, DeepEquals, []string{"/snap", "/snap", "/snap"})
+}
+
+func (s *SnapTestSuite) TestPruneCacheKeepsDeltaBase(c *C) {
+	s.installDeltaBase(c, "old")
+	base := cachePath(sha512sum("old"))
+	// the least recently used
+	old := time.Now().Add(-time.Hour)
+	c.Assert(os.Chtimes(base, old, old), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapCacheDir, "other"), nil, 0600), IsNil)
+
+	c.Assert(pruneCache(1), IsNil)
+	c.Check(helpers.FileExists(base), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapCacheDir, "other")), Equals, true)
+
+	c.Assert(pruneCache(0), IsNil)
+	c.Check(helpers.FileExists(base), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapCacheDir, "other")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestDeltaFormatsHeader(c *C) {
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	c.Assert(err, IsNil)
+
+	supportedDeltaFormats = func() []string { return nil }
+	setUbuntuStoreHeaders(req, nil)
+	c.Check(req.Header.Get("X-Ubuntu-Delta-Formats"), Equals, "")
+
+	mockDeltas(c)
+	setUbuntuStoreHeaders(req, nil)
+	c.Check(req.Header.Get("X-Ubuntu-Delta-Formats"), Equals, "xdelta3")
+}
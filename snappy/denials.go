@@ -0,0 +1,209 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var kernelLog = kernelLogImpl
+
+// kernelLogImpl returns the kernel messages (which include the audit
+// messages) since the given time
+func kernelLogImpl(since time.Time) ([]byte, error) {
+	cmd := exec.Command("journalctl", "-k", "-o", "cat", "--since", fmt.Sprintf("@%d", since.Unix()))
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("can not read the kernel log: %v", err)
+	}
+
+	return output, nil
+}
+
+// SecurityDenial is an AppArmor denial of a snap app
+type SecurityDenial struct {
+	Time time.Time
+	// Snap is the name of the snap (name.origin for apps)
+	Snap    string
+	App     string
+	Version string
+	Profile string
+
+	Operation     string
+	Path          string
+	RequestedMask string
+	DeniedMask    string
+	Pid           int
+	Comm          string
+}
+
+// e.g. audit: type=1400 audit(1431384420.408:319): apparmor="DENIED" operation="mkdir" profile="foo_bar_0.1" name="/var/lib/foo" pid=637 comm="bar" requested_mask="c" denied_mask="c" fsuid=0 ouid=0
+var (
+	apparmorDenialRegexp = regexp.MustCompile(`audit\(([0-9]+)\.([0-9]+):[0-9]+\): apparmor="DENIED" (.*)`)
+	auditFieldRegexp     = regexp.MustCompile(`([a-z_]+)=("[^"]*"|[^ ]*)`)
+)
+
+// splitSecurityProfile splits a profile name like name.origin_app_version
+// into its parts; ok is false if it is not the profile of a snap app
+func splitSecurityProfile(profile string) (snap, app, version string, ok bool) {
+	// child profiles (e.g. of aa-exec) are attributed to the app
+	if i := strings.Index(profile, "//"); i >= 0 {
+		profile = profile[:i]
+	}
+	if strings.Contains(profile, "/") {
+		return "", "", "", false
+	}
+
+	first := strings.Index(profile, "_")
+	last := strings.LastIndex(profile, "_")
+	if first <= 0 || first == last || last == len(profile)-1 {
+		return "", "", "", false
+	}
+
+	return profile[:first], profile[first+1 : last], profile[last+1:], true
+}
+
+func parseSecurityDenials(log []byte) (denials []SecurityDenial) {
+	scanner := bufio.NewScanner(bytes.NewReader(log))
+	for scanner.Scan() {
+		match := apparmorDenialRegexp.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+
+		fields := make(map[string]string)
+		for _, field := range auditFieldRegexp.FindAllStringSubmatch(match[3], -1) {
+			fields[field[1]] = strings.Trim(field[2], `"`)
+		}
+
+		snap, app, version, ok := splitSecurityProfile(fields["profile"])
+		if !ok {
+			continue
+		}
+
+		sec, _ := strconv.ParseInt(match[1], 10, 64)
+		msec, _ := strconv.ParseInt(match[2], 10, 64)
+		pid, _ := strconv.Atoi(fields["pid"])
+
+		denials = append(denials, SecurityDenial{
+			Time:          time.Unix(sec, msec*int64(time.Millisecond)),
+			Snap:          snap,
+			App:           app,
+			Version:       version,
+			Profile:       fields["profile"],
+			Operation:     fields["operation"],
+			Path:          fields["name"],
+			RequestedMask: fields["requested_mask"],
+			DeniedMask:    fields["denied_mask"],
+			Pid:           pid,
+			Comm:          fields["comm"],
+		})
+	}
+
+	return denials
+}
+
+// SecurityDenials returns the AppArmor denials of the snap with the given
+// name (or of all snaps if the name is empty) since the given time. The
+// origin may be omitted from the name.
+func SecurityDenials(name string, since time.Time) ([]SecurityDenial, error) {
+	log, err := kernelLog(since)
+	if err != nil {
+		return nil, err
+	}
+
+	name, origin := SplitOrigin(name)
+
+	var denials []SecurityDenial
+	for _, denial := range parseSecurityDenials(log) {
+		if denial.Time.Before(since) {
+			continue
+		}
+		snapName, snapOrigin := SplitOrigin(denial.Snap)
+		if name != "" && (snapName != name || (origin != "" && snapOrigin != origin)) {
+			continue
+		}
+		denials = append(denials, denial)
+	}
+
+	return denials, nil
+}
+
+// SecurityDenialSummary aggregates the same denial of an app
+type SecurityDenialSummary struct {
+	Snap       string
+	App        string
+	Operation  string
+	Path       string
+	DeniedMask string
+
+	Count int
+	First time.Time
+	Last  time.Time
+}
+
+type byDenialCount []*SecurityDenialSummary
+
+func (ds byDenialCount) Len() int           { return len(ds) }
+func (ds byDenialCount) Swap(a, b int)      { ds[a], ds[b] = ds[b], ds[a] }
+func (ds byDenialCount) Less(a, b int) bool { return ds[a].Count > ds[b].Count }
+
+// SummarizeSecurityDenials groups the given denials by app, operation,
+// path and mask, most frequent first
+func SummarizeSecurityDenials(denials []SecurityDenial) []*SecurityDenialSummary {
+	var summaries []*SecurityDenialSummary
+	seen := make(map[SecurityDenialSummary]*SecurityDenialSummary)
+
+	for _, denial := range denials {
+		key := SecurityDenialSummary{
+			Snap:       denial.Snap,
+			App:        denial.App,
+			Operation:  denial.Operation,
+			Path:       denial.Path,
+			DeniedMask: denial.DeniedMask,
+		}
+		summary, ok := seen[key]
+		if !ok {
+			summary = &SecurityDenialSummary{}
+			*summary = key
+			summary.First = denial.Time
+			seen[key] = summary
+			summaries = append(summaries, summary)
+		}
+		summary.Count++
+		if denial.Time.Before(summary.First) {
+			summary.First = denial.Time
+		}
+		if denial.Time.After(summary.Last) {
+			summary.Last = denial.Time
+		}
+	}
+	sort.Stable(byDenialCount(summaries))
+
+	return summaries
+}
@@ -0,0 +1,95 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+const mockKernelLog = `audit: type=1400 audit(1431384420.408:319): apparmor="DENIED" operation="mkdir" profile="foo.mvo_bar_0.1" name="/var/lib/foo" pid=637 comm="bar" requested_mask="c" denied_mask="c" fsuid=0 ouid=0
+audit: type=1400 audit(1431384421.000:320): apparmor="DENIED" operation="open" profile="/usr/sbin/cupsd" name="/etc/shadow" pid=1 comm="cupsd" requested_mask="r" denied_mask="r" fsuid=0 ouid=0
+audit: type=1400 audit(1431384422.000:321): apparmor="DENIED" operation="mkdir" profile="foo.mvo_bar_0.1" name="/var/lib/foo" pid=638 comm="bar" requested_mask="c" denied_mask="c" fsuid=0 ouid=0
+audit: type=1400 audit(1431384423.000:322): apparmor="DENIED" operation="open" profile="fmk_svc_1.0//null-/bin/sh" name="/etc/passwd" pid=700 comm="sh" requested_mask="r" denied_mask="r" fsuid=0 ouid=0
+audit: type=1326 audit(1430766107.122:16): auid=1000 uid=1000 gid=1000 ses=15 pid=1491 comm="env" exe="/bin/bash" sig=31 arch=40000028 syscall=983045 compat=0 ip=0xb6fb0bd6 code=0x0
+`
+
+func (s *SnapTestSuite) TestSplitSecurityProfile(c *C) {
+	for _, t := range []struct {
+		profile, snap, app, version string
+		ok                          bool
+	}{
+		{"foo.mvo_bar_0.1", "foo.mvo", "bar", "0.1", true},
+		{"fmk_bin-baz_1.0//null-/bin/sh", "fmk", "bin-baz", "1.0", true},
+		{"/usr/sbin/cupsd", "", "", "", false},
+		{"foo_bar", "", "", "", false},
+	} {
+		snap, app, version, ok := splitSecurityProfile(t.profile)
+		c.Check(ok, Equals, t.ok, Commentf("%q", t.profile))
+		c.Check(snap, Equals, t.snap)
+		c.Check(app, Equals, t.app)
+		c.Check(version, Equals, t.version)
+	}
+}
+
+func (s *SnapTestSuite) TestSecurityDenials(c *C) {
+	kernelLog = func(since time.Time) ([]byte, error) {
+		return []byte(mockKernelLog), nil
+	}
+	defer func() { kernelLog = kernelLogImpl }()
+
+	denials, err := SecurityDenials("foo", time.Unix(0, 0))
+	c.Assert(err, IsNil)
+	c.Assert(denials, HasLen, 2)
+	c.Check(denials[0], DeepEquals, SecurityDenial{
+		Time:          time.Unix(1431384420, 408*int64(time.Millisecond)),
+		Snap:          "foo.mvo",
+		App:           "bar",
+		Version:       "0.1",
+		Profile:       "foo.mvo_bar_0.1",
+		Operation:     "mkdir",
+		Path:          "/var/lib/foo",
+		RequestedMask: "c",
+		DeniedMask:    "c",
+		Pid:           637,
+		Comm:          "bar",
+	})
+
+	denials, err = SecurityDenials("foo.other", time.Unix(0, 0))
+	c.Assert(err, IsNil)
+	c.Check(denials, HasLen, 0)
+
+	denials, err = SecurityDenials("", time.Unix(1431384421, 0))
+	c.Assert(err, IsNil)
+	c.Assert(denials, HasLen, 2)
+	c.Check(denials[1].Snap, Equals, "fmk")
+}
+
+func (s *SnapTestSuite) TestSummarizeSecurityDenials(c *C) {
+	summaries := SummarizeSecurityDenials(parseSecurityDenials([]byte(mockKernelLog)))
+	c.Assert(summaries, HasLen, 2)
+	c.Check(summaries[0].Snap, Equals, "foo.mvo")
+	c.Check(summaries[0].Count, Equals, 2)
+	c.Check(summaries[0].First, Equals, time.Unix(1431384420, 408*int64(time.Millisecond)))
+	c.Check(summaries[0].Last, Equals, time.Unix(1431384422, 0))
+	c.Check(summaries[1].Snap, Equals, "fmk")
+	c.Check(summaries[1].Count, Equals, 1)
+}
@@ -0,0 +1,96 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/ubuntu-core/snappy/logger"
+)
+
+// DeviceIdentity is the identity of the device that the requests to the
+// store carry, for the OEM stores to serve content specific to it
+type DeviceIdentity struct {
+	// Model of the device
+	Model string
+	// Serial number of the device
+	Serial string
+	// ImageChannel is the channel of the image the device runs
+	ImageChannel string
+}
+
+// DeviceIdentityProvider provides the identity of the device
+type DeviceIdentityProvider interface {
+	DeviceIdentity() (*DeviceIdentity, error)
+}
+
+// deviceIdentityHeaders are the headers of the identity of the device:
+// its model, serial number and image channel
+var deviceIdentityHeaders = []string{
+	"X-Ubuntu-Device-Model",
+	"X-Ubuntu-Device-Serial",
+	"X-Ubuntu-Image-Channel",
+}
+
+var (
+	// deviceIdentityProvider of the store requests (nil for none)
+	deviceIdentityProvider DeviceIdentityProvider
+	// deviceIdentityMu guards deviceIdentityProvider, set by the daemon
+	// while it makes requests
+	deviceIdentityMu sync.Mutex
+)
+
+// SetDeviceIdentityProvider makes the requests to the store carry the
+// identity of the device that the given provider provides; nil stops
+// sending it
+func SetDeviceIdentityProvider(provider DeviceIdentityProvider) {
+	deviceIdentityMu.Lock()
+	defer deviceIdentityMu.Unlock()
+
+	deviceIdentityProvider = provider
+}
+
+// setDeviceIdentityHeaders sets the headers of the identity of the
+// device, if there is a DeviceIdentityProvider. They are for the store
+// API only, not for the downloads (see setUbuntuStoreHeaders).
+func setDeviceIdentityHeaders(req *http.Request) {
+	deviceIdentityMu.Lock()
+	provider := deviceIdentityProvider
+	deviceIdentityMu.Unlock()
+	if provider == nil {
+		return
+	}
+
+	id, err := provider.DeviceIdentity()
+	if err != nil {
+		logger.Noticef("Failed to get the identity of the device: %v", err)
+		return
+	}
+	if id == nil {
+		return
+	}
+
+	for i, value := range []string{id.Model, id.Serial, id.ImageChannel} {
+		if value != "" {
+			req.Header.Set(deviceIdentityHeaders[i], value)
+		}
+	}
+}
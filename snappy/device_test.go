@@ -0,0 +1,94 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"net/http"
+
+	. "gopkg.in/check.v1"
+)
+
+type mockDeviceIdentityProvider struct {
+	id  *DeviceIdentity
+	err error
+}
+
+func (p *mockDeviceIdentityProvider) DeviceIdentity() (*DeviceIdentity, error) {
+	return p.id, p.err
+}
+
+func (s *SnapTestSuite) TestDeviceIdentityHeaders(c *C) {
+	SetDeviceIdentityProvider(&mockDeviceIdentityProvider{id: &DeviceIdentity{
+		Model:        "gizmo",
+		Serial:       "1234",
+		ImageChannel: "stable",
+	}})
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	c.Assert(err, IsNil)
+	setDeviceIdentityHeaders(req)
+
+	c.Check(req.Header.Get("X-Ubuntu-Device-Model"), Equals, "gizmo")
+	c.Check(req.Header.Get("X-Ubuntu-Device-Serial"), Equals, "1234")
+	c.Check(req.Header.Get("X-Ubuntu-Image-Channel"), Equals, "stable")
+}
+
+func (s *SnapTestSuite) TestDeviceIdentityHeadersPartial(c *C) {
+	SetDeviceIdentityProvider(&mockDeviceIdentityProvider{id: &DeviceIdentity{Model: "gizmo"}})
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	c.Assert(err, IsNil)
+	setDeviceIdentityHeaders(req)
+
+	c.Check(req.Header.Get("X-Ubuntu-Device-Model"), Equals, "gizmo")
+	_, ok := req.Header["X-Ubuntu-Device-Serial"]
+	c.Check(ok, Equals, false)
+}
+
+func (s *SnapTestSuite) TestDeviceIdentityHeadersNone(c *C) {
+	for _, provider := range []DeviceIdentityProvider{
+		nil,
+		&mockDeviceIdentityProvider{err: errors.New("no serial yet")},
+	} {
+		SetDeviceIdentityProvider(provider)
+
+		req, err := http.NewRequest("GET", "http://example.com", nil)
+		c.Assert(err, IsNil)
+		setDeviceIdentityHeaders(req)
+
+		c.Check(req.Header, HasLen, 0)
+	}
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreHeadersNoDeviceIdentity(c *C) {
+	SetDeviceIdentityProvider(&mockDeviceIdentityProvider{id: &DeviceIdentity{Model: "gizmo", Serial: "1234"}})
+
+	// the headers of the downloads too, which are not for the store API
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	c.Assert(err, IsNil)
+	setUbuntuStoreHeaders(req, nil)
+
+	c.Check(req.Header.Get("X-Ubuntu-Release"), Not(Equals), "")
+	for _, header := range deviceIdentityHeaders {
+		_, ok := req.Header[header]
+		c.Check(ok, Equals, false)
+	}
+}
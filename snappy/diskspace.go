@@ -0,0 +1,211 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"syscall"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+)
+
+// unpackedSizeFactor is how much bigger than the (compressed) snap it
+// is guessed to get once unpacked, for when its installed size is not
+// known yet
+const unpackedSizeFactor = 3
+
+// freeSpace returns the bytes available on the filesystem of path
+var freeSpace = freeSpaceImpl
+
+func freeSpaceImpl(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// fsID returns what tells the filesystem of path from the others
+var fsID = fsIDImpl
+
+func fsIDImpl(path string) (uint64, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return 0, err
+	}
+
+	return uint64(st.Dev), nil
+}
+
+// checkSpace fails with ErrInsufficientSpace if the filesystem any of
+// the directories is (or will be created) on does not have the bytes
+// needed in them free (added up for those on the same filesystem), so
+// that an install fails before it starts rather than half way through
+func checkSpace(snap string, needed map[string]int64) error {
+	var paths []string
+	for dir, n := range needed {
+		if n > 0 {
+			paths = append(paths, dir)
+		}
+	}
+	sort.Strings(paths)
+
+	var filesystems []string
+	fsNeeded := make(map[string]int64)
+	fsPath := make(map[string]string)
+	for _, dir := range paths {
+		n := needed[dir]
+		for !helpers.FileExists(dir) && dir != filepath.Dir(dir) {
+			dir = filepath.Dir(dir)
+		}
+
+		// a filesystem that is not known is taken on its own
+		fs := dir
+		if id, err := fsID(dir); err == nil {
+			fs = strconv.FormatUint(id, 10)
+		}
+		if _, ok := fsPath[fs]; !ok {
+			filesystems = append(filesystems, fs)
+			fsPath[fs] = dir
+		}
+		fsNeeded[fs] += n
+	}
+
+	for _, fs := range filesystems {
+		dir := fsPath[fs]
+		free, err := freeSpace(dir)
+		if err != nil {
+			// not knowing is no reason not to try
+			logger.Noticef("Failed to get the free space in %q: %v", dir, err)
+			continue
+		}
+		if free < fsNeeded[fs] {
+			return &ErrInsufficientSpace{Snap: snap, Path: dir, Needed: fsNeeded[fs], Free: free}
+		}
+	}
+
+	return nil
+}
+
+// treeSize returns the bytes the files in dir take (0 if there is no
+// dir)
+func treeSize(dir string) (size int64) {
+	filepath.Walk(dir, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		size += info.Size()
+		return nil
+	})
+
+	return size
+}
+
+// addDataCopySpace adds the bytes that copying the data of the active
+// version of the snap (that a new version starts from) takes to needed
+func addDataCopySpace(name string, needed map[string]int64) {
+	current := ActiveSnapByName(name)
+	if current == nil {
+		return
+	}
+
+	dataDirs, err := snapDataDirs(QualifiedName(current), current.Version())
+	if err != nil {
+		return
+	}
+	for _, dir := range dataDirs {
+		// the copy goes next to it
+		needed[filepath.Dir(dir)] += treeSize(dir)
+	}
+}
+
+// unpackedSize returns the size of the snap once unpacked, as its
+// manifest declares it, or 0 if it does not
+func (s *SnapPart) unpackedSize() int64 {
+	manifestData, err := s.deb.ControlMember("manifest")
+	if err != nil {
+		return 0
+	}
+
+	var manifest clickManifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return 0
+	}
+
+	// in KiB, as du reported it when building
+	kib, err := strconv.ParseInt(manifest.InstalledSize, 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return kib * 1024
+}
+
+// spaceNeeded returns the bytes unpacking the snap and copying the
+// data of its active version are guessed to take, by the directory
+// they go to
+func (s *SnapPart) spaceNeeded() map[string]int64 {
+	needed := map[string]int64{s.basedir: s.unpackedSize()}
+	addDataCopySpace(s.Name(), needed)
+
+	return needed
+}
+
+// spaceNeeded returns the bytes downloading (unless it is already) and
+// unpacking the snap, and copying the data of its active version, are
+// guessed to take, by the directory they go to
+func (s *RemoteSnapPart) spaceNeeded() map[string]int64 {
+	needed := make(map[string]int64)
+	if size := s.DownloadSize(); size > 0 {
+		needed[s.targetDir()] += size * unpackedSizeFactor
+		if s.downloaded == "" {
+			needed[s.downloadDir()] += size
+		}
+	}
+	addDataCopySpace(s.Name(), needed)
+
+	return needed
+}
+
+// downloadDir returns the directory the snap is downloaded to (see
+// openDownload)
+func (s *RemoteSnapPart) downloadDir() string {
+	if s.pkg.DownloadSha512 != "" {
+		return dirs.SnapDownloadsDir
+	}
+
+	return os.TempDir()
+}
+
+// targetDir returns the directory the snap is unpacked into
+func (s *RemoteSnapPart) targetDir() string {
+	if s.Type().IsGadget() {
+		return dirs.SnapOemDir
+	}
+
+	return dirs.SnapAppsDir
+}
@@ -0,0 +1,129 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/pkg/remote"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+func (s *SnapTestSuite) TestFreeSpace(c *C) {
+	free, err := freeSpace(s.tempdir)
+	c.Assert(err, IsNil)
+	c.Check(free > 0, Equals, true)
+}
+
+func (s *SnapTestSuite) TestCheckSpace(c *C) {
+	var asked string
+	freeSpace = func(path string) (int64, error) {
+		asked = path
+		return 100, nil
+	}
+
+	c.Check(checkSpace("foo", map[string]int64{s.tempdir: 100}), IsNil)
+	c.Check(checkSpace("foo", map[string]int64{s.tempdir: 0}), IsNil)
+
+	// the free space of a dir to be is that of its parent
+	err := checkSpace("foo", map[string]int64{filepath.Join(s.tempdir, "not", "there"): 101})
+	c.Assert(err, FitsTypeOf, &ErrInsufficientSpace{})
+	c.Check(err, DeepEquals, &ErrInsufficientSpace{Snap: "foo", Path: s.tempdir, Needed: 101, Free: 100})
+	c.Check(asked, Equals, s.tempdir)
+}
+
+func (s *SnapTestSuite) TestCheckSpaceByFilesystem(c *C) {
+	other := filepath.Join(s.tempdir, "other")
+	c.Assert(os.Mkdir(other, 0755), IsNil)
+
+	freeSpace = func(path string) (int64, error) {
+		return 100, nil
+	}
+	needed := map[string]int64{s.tempdir: 60, other: 60}
+
+	// on the same filesystem it adds up
+	err := checkSpace("foo", needed)
+	c.Assert(err, FitsTypeOf, &ErrInsufficientSpace{})
+	c.Check(err.(*ErrInsufficientSpace).Needed, Equals, int64(120))
+
+	fsID = func(path string) (uint64, error) {
+		if path == other {
+			return 2, nil
+		}
+		return 1, nil
+	}
+	c.Check(checkSpace("foo", needed), IsNil)
+}
+
+func (s *SnapTestSuite) TestRemoteSnapSpaceNeeded(c *C) {
+	r := NewRemoteSnapPart(remote.Snap{Name: "foo", DownloadSize: 10})
+	c.Check(r.spaceNeeded(), DeepEquals, map[string]int64{
+		dirs.SnapAppsDir: 10 * unpackedSizeFactor,
+		os.TempDir():     10,
+	})
+
+	// resumable downloads are not in TMPDIR
+	r = NewRemoteSnapPart(remote.Snap{Name: "foo", DownloadSize: 10, DownloadSha512: "abc"})
+	c.Check(r.spaceNeeded(), DeepEquals, map[string]int64{
+		dirs.SnapAppsDir:      10 * unpackedSizeFactor,
+		dirs.SnapDownloadsDir: 10,
+	})
+
+	r.downloaded = "/some/file.snap"
+	c.Check(r.spaceNeeded(), DeepEquals, map[string]int64{dirs.SnapAppsDir: 10 * unpackedSizeFactor})
+
+	c.Check(NewRemoteSnapPart(remote.Snap{Name: "foo"}).spaceNeeded(), HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestRemoteSnapSpaceNeededDataCopy(c *C) {
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	dataDir := filepath.Join(dirs.SnapDataDir, helloAppComposedName, "1.10")
+	c.Assert(os.MkdirAll(dataDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dataDir, "data"), make([]byte, 1000), 0644), IsNil)
+
+	// the data of the active version is copied for the new one
+	r := NewRemoteSnapPart(remote.Snap{Name: "hello-app", Origin: testOrigin})
+	c.Check(r.spaceNeeded()[filepath.Dir(dataDir)] >= 1000, Equals, true)
+}
+
+func (s *SnapTestSuite) TestRemoteSnapInstallInsufficientSpace(c *C) {
+	freeSpace = func(string) (int64, error) {
+		return 10, nil
+	}
+	// (with the download and the unpacked snap on one filesystem)
+	fsID = func(string) (uint64, error) {
+		return 1, nil
+	}
+
+	// the download would fail (there is nowhere to download
+	// from), but it does not even get tried
+	r := NewRemoteSnapPart(remote.Snap{Name: "foo", DownloadSize: 10})
+	_, err := r.Install(&progress.NullProgress{}, 0)
+	c.Assert(err, FitsTypeOf, &ErrInsufficientSpace{})
+	c.Check(err.(*ErrInsufficientSpace).Needed, Equals, int64(10+10*unpackedSizeFactor))
+}
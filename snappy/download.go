@@ -0,0 +1,94 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"os"
+	"sync"
+
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// byInstallOrder sorts the frameworks before the other snaps, that may
+// need them
+type byInstallOrder []Part
+
+func (a byInstallOrder) Len() int      { return len(a) }
+func (a byInstallOrder) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byInstallOrder) Less(i, j int) bool {
+	return a[i].Type() == pkg.TypeFramework && a[j].Type() != pkg.TypeFramework
+}
+
+// downloadAll downloads the snaps of the store among parts, workers of
+// them at once, for their installs not to wait on their downloads; a
+// failed download is only logged, as the install tries it again
+func downloadAll(parts []Part, workers int, meter progress.Meter) {
+	var remotes []*RemoteSnapPart
+	for _, part := range parts {
+		if r, ok := part.(*RemoteSnapPart); ok {
+			remotes = append(remotes, r)
+		}
+	}
+	if len(remotes) < 2 {
+		return
+	}
+	if workers > len(remotes) {
+		workers = len(remotes)
+	}
+
+	progress.NotifyMessage(meter, progress.NewMessage(progress.MsgDownloadingUpdates, len(remotes), workers))
+
+	todo := make(chan *RemoteSnapPart)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range todo {
+				// a progress bar per download would garble the output
+				fn, err := r.Download(&progress.NullProgress{})
+				if err != nil {
+					logger.Noticef("Failed to download %s ahead of its install: %v", r.Name(), err)
+					continue
+				}
+				r.downloaded = fn
+			}
+		}()
+	}
+
+	for _, r := range remotes {
+		todo <- r
+	}
+	close(todo)
+	wg.Wait()
+}
+
+// discardDownloads removes the downloads of downloadAll that did not
+// get installed
+func discardDownloads(parts []Part) {
+	for _, part := range parts {
+		if r, ok := part.(*RemoteSnapPart); ok && r.downloaded != "" {
+			os.Remove(r.downloaded)
+			r.downloaded = ""
+		}
+	}
+}
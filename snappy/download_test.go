@@ -0,0 +1,115 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"sync"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+func remoteSnapPartOfType(name string, typ pkg.Type) *RemoteSnapPart {
+	snap := &RemoteSnapPart{}
+	snap.pkg.Name = name
+	snap.pkg.Type = typ
+
+	return snap
+}
+
+func (s *SnapTestSuite) TestByInstallOrder(c *C) {
+	parts := []Part{
+		remoteSnapPartOfType("app1", pkg.TypeApp),
+		remoteSnapPartOfType("fmk1", pkg.TypeFramework),
+		remoteSnapPartOfType("app2", pkg.TypeApp),
+		remoteSnapPartOfType("fmk2", pkg.TypeFramework),
+	}
+	sort.Stable(byInstallOrder(parts))
+
+	var names []string
+	for _, part := range parts {
+		names = append(names, part.Name())
+	}
+	c.Check(names, DeepEquals, []string{"fmk1", "fmk2", "app1", "app2"})
+}
+
+func (s *SnapTestSuite) TestDownloadAll(c *C) {
+	var mu sync.Mutex
+	var fetched []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched = append(fetched, r.URL.Path)
+		mu.Unlock()
+		io.WriteString(w, r.URL.Path)
+	}))
+	defer mockServer.Close()
+
+	var parts []Part
+	for _, name := range []string{"foo", "bar", "baz"} {
+		snap := remoteSnapPartOfType(name, pkg.TypeApp)
+		snap.pkg.AnonDownloadURL = mockServer.URL + "/" + name
+		parts = append(parts, snap)
+	}
+
+	downloadAll(parts, 2, &progress.NullProgress{})
+	c.Check(fetched, HasLen, 3)
+
+	// the installs get the downloads without fetching them again
+	fn, err := parts[0].(*RemoteSnapPart).Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	defer os.Remove(fn)
+	c.Check(fetched, HasLen, 3)
+
+	var left []string
+	for _, part := range parts[1:] {
+		left = append(left, part.(*RemoteSnapPart).downloaded)
+	}
+	discardDownloads(parts)
+	for _, fn := range left {
+		c.Check(helpers.FileExists(fn), Equals, false)
+	}
+}
+
+func (s *SnapTestSuite) TestDownloadAllFailureIsLeftToInstall(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(500)
+	}))
+	defer mockServer.Close()
+
+	var parts []Part
+	for _, name := range []string{"foo", "bar"} {
+		snap := remoteSnapPartOfType(name, pkg.TypeApp)
+		snap.pkg.AnonDownloadURL = mockServer.URL + "/" + name
+		parts = append(parts, snap)
+	}
+
+	downloadAll(parts, 2, &progress.NullProgress{})
+	for _, part := range parts {
+		c.Check(part.(*RemoteSnapPart).downloaded, Equals, "")
+	}
+}
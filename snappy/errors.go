@@ -24,8 +24,10 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
 	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg"
 )
 
 var (
@@ -35,6 +37,10 @@ var (
 	// ErrServiceNotFound is returned when a service can not be found
 	ErrServiceNotFound = errors.New("snappy service not found")
 
+	// ErrAppNotFound is returned when a snap has no binary or service
+	// with the given name
+	ErrAppNotFound = errors.New("snappy app not found")
+
 	// ErrNeedRoot is returned when a command needs root privs but
 	// the caller is not root
 	ErrNeedRoot = errors.New("this command requires root access. Please re-run using 'sudo'")
@@ -47,6 +53,14 @@ var (
 	// getting configured
 	ErrConfigNotFound = errors.New("no config found for this snap")
 
+	// ErrExportDataNotFound is returned if the data of a snap without
+	// an export-data hook is getting exported
+	ErrExportDataNotFound = errors.New("no export-data hook found for this snap")
+
+	// ErrTraceNotFound is returned if the trace of an operation that
+	// was not run with the Debug option is requested
+	ErrTraceNotFound = errors.New("no trace found for this operation")
+
 	// ErrInvalidHWDevice is returned when a invalid hardware device
 	// is given in the hw-assign command
 	ErrInvalidHWDevice = errors.New("invalid hardware device")
@@ -107,6 +121,9 @@ var (
 	// ErrLicenseNotAccepted is returned when the user does not accept the
 	// license
 	ErrLicenseNotAccepted = errors.New("license not accepted")
+	// ErrPriceNotAccepted is returned when the user does not accept
+	// the price of a snap to buy
+	ErrPriceNotAccepted = errors.New("price not accepted")
 	// ErrLicenseBlank is returned when the package specifies that
 	// accepting license is required, but the license file was empty or
 	// blank
@@ -135,6 +152,43 @@ var (
 	// created with a custom enablement part.
 	ErrSideLoaded = errors.New("cannot update system that uses custom enablement")
 
+	// ErrReinstallSideloaded is returned when reinstalling a snap that
+	// was not installed from the store, as it can not be got again
+	ErrReinstallSideloaded = errors.New("cannot reinstall a sideloaded snap, install it from its file instead")
+
+	// ErrNotASnapFile is returned when sideloading something that is
+	// not a regular file
+	ErrNotASnapFile = errors.New("not a snap file")
+
+	// ErrSnapshotNotFound is returned when restoring a snapshot that
+	// does not exist (for the given snap)
+	ErrSnapshotNotFound = errors.New("no such snapshot")
+
+	// ErrSnapshotDamaged is returned when restoring a snapshot whose
+	// data does not match the hash it was taken with
+	ErrSnapshotDamaged = errors.New("snapshot data does not match its hash")
+
+	// ErrAuthenticationNeeded is returned when a snap can only be
+	// downloaded with store credentials, or the store only answers a
+	// request with them, and there are none or they expired.
+	ErrAuthenticationNeeded = errors.New("you need to log into the store")
+
+	// ErrCountryBlacklisted is returned when the store does not offer
+	// the snap in the country of the device
+	ErrCountryBlacklisted = errors.New("the snap is not available in your country")
+
+	// ErrPurchaseNeeded is returned when a snap can only be downloaded
+	// once bought, and it was not.
+	ErrPurchaseNeeded = errors.New("you need to buy this snap to download it")
+
+	// ErrPaymentDeclined is returned when the store refuses the
+	// payment of a snap.
+	ErrPaymentDeclined = errors.New("the store declined the payment")
+
+	// ErrCertificateNotPinned is returned when the store presents
+	// none of the certificates pinned with StoreTLS.
+	ErrCertificateNotPinned = errors.New("the store presented none of the pinned certificates")
+
 	// ErrPackageNameNotSupported is returned when installing legacy package such as those
 	// that have the origin specified in their package names.
 	ErrPackageNameNotSupported = errors.New("package name with origin not supported")
@@ -146,6 +200,14 @@ var (
 	ErrInvalidSeccompPolicy = errors.New("policy-version and policy-vendor must be specified together")
 	// ErrNoSeccompPolicy is returned when an expected seccomp policy is not provided.
 	ErrNoSeccompPolicy = errors.New("no seccomp policy provided")
+
+	// ErrExportNotFramework is returned when a snap that is not a
+	// framework tries to export binaries
+	ErrExportNotFramework = errors.New("only frameworks can export binaries")
+
+	// ErrNoLogNamespace is returned when asking for the log usage of
+	// a snap whose services do not log to a journal namespace
+	ErrNoLogNamespace = errors.New("snap services do not use a log namespace")
 )
 
 // ErrDownload represents a download error
@@ -158,6 +220,19 @@ func (e *ErrDownload) Error() string {
 	return fmt.Sprintf("received an unexpected http response code (%v) when trying to download %s", e.Code, e.URL)
 }
 
+// ErrPriceTooHigh is returned when a snap to buy costs more than the
+// most it is bought for
+type ErrPriceTooHigh struct {
+	Snap     string
+	Price    float64
+	MaxPrice float64
+	Currency string
+}
+
+func (e *ErrPriceTooHigh) Error() string {
+	return fmt.Sprintf("%s costs %.2f %s, more than %.2f %s", e.Snap, e.Price, e.Currency, e.MaxPrice, e.Currency)
+}
+
 // ErrArchitectureNotSupported is returned when trying to install a snappy package that
 // is not supported on the system
 type ErrArchitectureNotSupported struct {
@@ -238,6 +313,25 @@ func (e ErrNameClash) Error() string {
 	return fmt.Sprintf("you can't have a binary and service both called %s", string(e))
 }
 
+// ErrBinaryNameClash is returned if a binary of a snap would get the
+// name of a binary of the (active) snap Snap
+type ErrBinaryNameClash struct {
+	Binary string
+	Snap   string
+}
+
+func (e *ErrBinaryNameClash) Error() string {
+	return fmt.Sprintf("binary %q clashes with that of %s", e.Binary, e.Snap)
+}
+
+// ErrFrameworkCycle reports frameworks that depend on each other in a
+// cycle, so they can not be installed in any order
+type ErrFrameworkCycle []string
+
+func (e ErrFrameworkCycle) Error() string {
+	return fmt.Sprintf("frameworks depend on each other in a cycle: %s", strings.Join(e, ", "))
+}
+
 // ErrMissingFrameworks reports a conflict between the frameworks needed by an app and those installed in the system
 type ErrMissingFrameworks []string
 
@@ -273,3 +367,309 @@ func (e *ErrInvalidYaml) Error() string {
 	// %#v of string(yaml) so the yaml is presented as a human-readable string, but in a single greppable line
 	return fmt.Sprintf("can not parse %s: %v (from: %#v)", e.File, e.Err, string(e.Yaml))
 }
+
+// ErrAmbiguousName is returned if a name without origin matches packages
+// of several origins and none of them is a default origin or the alias
+// for the name
+type ErrAmbiguousName struct {
+	Name    string
+	Origins []string
+}
+
+func (e *ErrAmbiguousName) Error() string {
+	return fmt.Sprintf("%s is available from several origins (%s), please specify one", e.Name, strings.Join(e.Origins, ", "))
+}
+
+// ErrLockMismatch is returned if a snap that is available does not match
+// the version or hash of the lock file
+type ErrLockMismatch struct {
+	Snap     string
+	Expected string
+	Got      string
+}
+
+func (e *ErrLockMismatch) Error() string {
+	return fmt.Sprintf("%s does not match the lock file: expected %s, got %s", e.Snap, e.Expected, e.Got)
+}
+
+// ErrUpgradeFailed reports the snaps that failed to upgrade
+type ErrUpgradeFailed []string
+
+func (e ErrUpgradeFailed) Error() string {
+	return fmt.Sprintf("failed to upgrade: %s", strings.Join(e, ", "))
+}
+
+// ErrHashMismatch is returned if a downloaded snap does not match the
+// hash the store provided for it
+type ErrHashMismatch struct {
+	Snap     string
+	Expected string
+	Got      string
+}
+
+func (e *ErrHashMismatch) Error() string {
+	return fmt.Sprintf("sha512 of the download of %s does not match: expected %s, got %s", e.Snap, e.Expected, e.Got)
+}
+
+// ErrInvalidRecoveryTarget is returned if the name or version given to
+// a recovery operation is not that of a snap (e.g. it has a "/")
+type ErrInvalidRecoveryTarget struct {
+	Name    string
+	Version string
+}
+
+func (e *ErrInvalidRecoveryTarget) Error() string {
+	return fmt.Sprintf("invalid snap %q version %q", e.Name, e.Version)
+}
+
+// ErrInvalidStorageLocation is returned if a storage location is not
+// an absolute path of an existing directory
+type ErrInvalidStorageLocation struct {
+	Location string
+}
+
+func (e *ErrInvalidStorageLocation) Error() string {
+	return fmt.Sprintf("invalid storage location %q: must be an existing directory", e.Location)
+}
+
+// ErrInsufficientSpace is returned if the filesystem of Path does not
+// have the Needed bytes free to install a snap
+type ErrInsufficientSpace struct {
+	Snap   string
+	Path   string
+	Needed int64
+	Free   int64
+}
+
+func (e *ErrInsufficientSpace) Error() string {
+	return fmt.Sprintf("not enough free space in %s to install %s: %d bytes needed, %d available", e.Path, e.Snap, e.Needed, e.Free)
+}
+
+// ErrClockSkew is returned if talking to the store failed and the
+// system clock is off by Delta (compared to the clock of the store)
+type ErrClockSkew struct {
+	Delta time.Duration
+	Err   error
+}
+
+func (e *ErrClockSkew) Error() string {
+	if e.Delta == 0 {
+		// the skew could not be measured
+		return fmt.Sprintf("the system clock seems to be wrong, please set the correct time: %v", e.Err)
+	}
+
+	return fmt.Sprintf("the system clock is off by %v, please set the correct time: %v", e.Delta, e.Err)
+}
+
+// ErrVersionNotAvailable is returned if the store does not have the
+// requested version of a snap
+type ErrVersionNotAvailable struct {
+	Snap    string
+	Version string
+}
+
+func (e *ErrVersionNotAvailable) Error() string {
+	return fmt.Sprintf("version %s of %s is not available in the store", e.Version, e.Snap)
+}
+
+// ErrUdevAdm is returned if running udevadm fails (e.g. if udev does
+// not process the events for new rules in time)
+type ErrUdevAdm struct {
+	Args []string
+	Err  error
+}
+
+func (e *ErrUdevAdm) Error() string {
+	return fmt.Sprintf("%s failed: %v", strings.Join(e.Args, " "), e.Err)
+}
+
+// ErrSelfTestFailed is returned if the self-test of a binary that asks
+// for a rollback on failure fails
+type ErrSelfTestFailed struct {
+	Binary string
+	Output string
+	Err    error
+}
+
+func (e *ErrSelfTestFailed) Error() string {
+	return fmt.Sprintf("self-test of %s failed: %v", e.Binary, e.Err)
+}
+
+// ErrRelationNotDeclared is returned if a snap publishes data under a
+// relation it does not provide, or reads one it does not require
+type ErrRelationNotDeclared struct {
+	Snap     string
+	Relation string
+	Provides bool
+}
+
+func (e *ErrRelationNotDeclared) Error() string {
+	if e.Provides {
+		return fmt.Sprintf("%s does not provide the relation %q", e.Snap, e.Relation)
+	}
+
+	return fmt.Sprintf("%s does not require the relation %q", e.Snap, e.Relation)
+}
+
+// ErrSideloadNameTaken is returned if a snap is sideloaded under a
+// name the store has
+type ErrSideloadNameTaken struct {
+	Name    string
+	Origins []string
+}
+
+func (e *ErrSideloadNameTaken) Error() string {
+	return fmt.Sprintf("%s is available in the store (from %s), a sideloaded %s would block installing it", e.Name, strings.Join(e.Origins, ", "), e.Name)
+}
+
+// ErrArchVariantMissing is returned if a command of a snap has
+// variants per architecture but none for one of the architectures of
+// the snap (and no generic version to fall back to)
+type ErrArchVariantMissing struct {
+	Command  string
+	Arch     string
+	Variants []string
+}
+
+func (e *ErrArchVariantMissing) Error() string {
+	return fmt.Sprintf("%s has no variant for the architecture %q (only for %s) and no generic version", e.Command, e.Arch, strings.Join(e.Variants, ", "))
+}
+
+// ErrConfinementNotAllowed is returned if a snap asks for a confinement
+// the device does not accept
+type ErrConfinementNotAllowed struct {
+	Snap        string
+	Confinement pkg.Confinement
+	Allowed     []pkg.Confinement
+}
+
+func (e *ErrConfinementNotAllowed) Error() string {
+	allowed := make([]string, len(e.Allowed))
+	for i, c := range e.Allowed {
+		allowed[i] = string(c)
+	}
+
+	return fmt.Sprintf("%s asks for %s confinement, the device only accepts %s", e.Snap, e.Confinement, strings.Join(allowed, ", "))
+}
+
+// ErrNotRecorded is returned when replaying the store requests if the
+// request was not recorded
+type ErrNotRecorded struct {
+	Method string
+	URL    string
+}
+
+func (e *ErrNotRecorded) Error() string {
+	return fmt.Sprintf("no recorded response for %s %s", e.Method, e.URL)
+}
+
+// ErrUnknownGroup is returned if the data of a snap is to be owned by a
+// group the system does not have
+type ErrUnknownGroup struct {
+	Group string
+}
+
+func (e *ErrUnknownGroup) Error() string {
+	return fmt.Sprintf("unknown group %q", e.Group)
+}
+
+// ErrMalwareDetected is returned if the scanner found malware in a
+// snap that was to be installed
+type ErrMalwareDetected struct {
+	Snap      string
+	Signature string
+}
+
+func (e *ErrMalwareDetected) Error() string {
+	return fmt.Sprintf("%s is infected with %s, refusing to install it", e.Snap, e.Signature)
+}
+
+// ErrHealthCheckFailed is returned if the new version of a blue/green
+// service did not get healthy, the old version keeps serving
+type ErrHealthCheckFailed struct {
+	Service string
+	Output  string
+	Err     error
+}
+
+func (e *ErrHealthCheckFailed) Error() string {
+	return fmt.Sprintf("health check of %s failed: %v", e.Service, e.Err)
+}
+
+// ErrUnpackedFilesMismatch is returned if the files unpacked from a
+// snap are not the ones its hashes.yaml lists
+type ErrUnpackedFilesMismatch struct {
+	Snap string
+	// Unexpected are unpacked but not listed, Missing are listed
+	// but not unpacked, and Modified are not what is listed
+	Unexpected []string
+	Missing    []string
+	Modified   []string
+}
+
+func (e *ErrUnpackedFilesMismatch) Error() string {
+	var problems []string
+	for _, p := range []struct {
+		what  string
+		files []string
+	}{
+		{"unexpected", e.Unexpected},
+		{"missing", e.Missing},
+		{"modified", e.Modified},
+	} {
+		if len(p.files) > 0 {
+			problems = append(problems, fmt.Sprintf("%s %s", p.what, strings.Join(p.files, ", ")))
+		}
+	}
+
+	return fmt.Sprintf("the files of %s do not match its hashes.yaml: %s", e.Snap, strings.Join(problems, "; "))
+}
+
+// ErrStoreUnavailable is returned if a request to the store still
+// fails (transiently) after all the attempts
+type ErrStoreUnavailable struct {
+	URL      *url.URL
+	Attempts int
+	Err      error
+}
+
+func (e *ErrStoreUnavailable) Error() string {
+	return fmt.Sprintf("store unavailable (%s) after %d attempts: %v", e.URL, e.Attempts, e.Err)
+}
+
+// ErrInvalidRefreshSchedule is returned if the schedule of the
+// automatic refresh is not a comma separated list of HH:MM-HH:MM
+type ErrInvalidRefreshSchedule struct {
+	Schedule string
+}
+
+func (e *ErrInvalidRefreshSchedule) Error() string {
+	return fmt.Sprintf("invalid refresh schedule %q, expected a comma separated list of HH:MM-HH:MM", e.Schedule)
+}
+
+// ErrStore is returned if the store fails a request, with the
+// messages of its error reply (if any)
+type ErrStore struct {
+	What     string
+	Status   int
+	Messages []string
+}
+
+func (e *ErrStore) Error() string {
+	msg := fmt.Sprintf("SnapUbuntuStoreRepository: unexpected http statusCode %v for %s", e.Status, e.What)
+	if len(e.Messages) > 0 {
+		msg += ": " + strings.Join(e.Messages, "; ")
+	}
+
+	return msg
+}
+
+// ErrInvalidIntrospectionAddress is returned if the introspection
+// endpoint is asked to listen on an address that is not local
+type ErrInvalidIntrospectionAddress struct {
+	Addr string
+}
+
+func (e *ErrInvalidIntrospectionAddress) Error() string {
+	return fmt.Sprintf("invalid introspection address %q: must be a unix socket or a loopback host:port", e.Addr)
+}
@@ -0,0 +1,146 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/policy"
+)
+
+// exportedPolicyGroup is the name of the policy group (prefixed with
+// the framework name) that allows apps to execute the binaries a
+// framework exports
+const exportedPolicyGroup = "exported-binaries"
+
+// exportedBinariesDir returns the directory that holds the binaries the
+// given framework exports to its dependents
+func exportedBinariesDir(fmk string) string {
+	return filepath.Join(dirs.SnapExportedBinariesDir, fmk)
+}
+
+func exportedPolicyGroupFiles(fmk string) []string {
+	name := fmt.Sprintf("%s_%s", fmk, exportedPolicyGroup)
+
+	return []string{
+		filepath.Join(dirs.GlobalRootDir, policy.SecBase, "apparmor", "policygroups", name),
+		filepath.Join(dirs.GlobalRootDir, policy.SecBase, "seccomp", "policygroups", name),
+	}
+}
+
+func (m *packageYaml) exportedBinaries() (exported []Binary) {
+	for _, binary := range m.Binaries {
+		if binary.Export {
+			exported = append(exported, binary)
+		}
+	}
+
+	return exported
+}
+
+// addExportedBinaries makes the binaries the framework exports
+// available to its dependents, and writes the policy that allows
+// them to run these binaries inside their confinement
+func (m *packageYaml) addExportedBinaries(baseDir string) error {
+	exported := m.exportedBinaries()
+	if m.Type != pkg.TypeFramework || len(exported) == 0 {
+		return nil
+	}
+
+	exportDir := exportedBinariesDir(m.Name)
+	if err := os.MkdirAll(exportDir, 0755); err != nil {
+		return err
+	}
+
+	realBaseDir := stripGlobalRootDir(baseDir)
+	realExportDir := stripGlobalRootDir(exportDir)
+
+	var aa bytes.Buffer
+	fmt.Fprintf(&aa, "# binaries exported by the %s framework\n", m.Name)
+	fmt.Fprintf(&aa, "%s/ r,\n", realExportDir)
+	fmt.Fprintf(&aa, "%s/* r,\n", realExportDir)
+
+	for _, binary := range exported {
+		link := filepath.Join(exportDir, filepath.Base(binary.Name))
+		if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if err := os.Symlink(binPathForBinary(realBaseDir, binary), link); err != nil {
+			return err
+		}
+
+		// the version is a glob so the policy survives upgrades
+		fmt.Fprintf(&aa, "%s ixr,\n", binPathForBinary(filepath.Join(filepath.Dir(realBaseDir), "*"), binary))
+	}
+
+	files := exportedPolicyGroupFiles(m.Name)
+	contents := [][]byte{
+		aa.Bytes(),
+		// execve is in the default seccomp policy already
+		[]byte(fmt.Sprintf("# binaries exported by the %s framework\n", m.Name)),
+	}
+	for i, fn := range files {
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			return err
+		}
+		if err := helpers.AtomicWriteFile(fn, contents[i], 0644, 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removeExportedBinaries undoes addExportedBinaries
+func (m *packageYaml) removeExportedBinaries() error {
+	if m.Type != pkg.TypeFramework {
+		return nil
+	}
+
+	for _, fn := range exportedPolicyGroupFiles(m.Name) {
+		if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.RemoveAll(exportedBinariesDir(m.Name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// exportedBinariesPath returns the PATH entries with the binaries
+// exported by the frameworks the package uses
+func (m *packageYaml) exportedBinariesPath() string {
+	paths := make([]string, len(m.Frameworks))
+	for i, fmk := range m.Frameworks {
+		paths[i] = stripGlobalRootDir(exportedBinariesDir(fmk))
+	}
+
+	return strings.Join(paths, ":")
+}
@@ -0,0 +1,94 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+const exportingFmkYaml = `name: fmk
+version: 1.0
+vendor: Foo Bar <foo@example.com>
+type: framework
+binaries:
+ - name: foo
+   exec: bin/foo
+   export: true
+ - name: private
+   exec: bin/foo
+`
+
+func (s *SnapTestSuite) TestExportOnlyForFrameworks(c *C) {
+	_, err := parsePackageYamlData([]byte(`name: app
+version: 1.0
+vendor: Foo Bar <foo@example.com>
+binaries:
+ - name: foo
+   export: true
+`), false)
+	c.Assert(err, Equals, ErrExportNotFramework)
+}
+
+func (s *SnapTestSuite) TestExportedBinariesInstallRemove(c *C) {
+	snapFile := makeTestSnapPackage(c, exportingFmkYaml)
+	_, err := installClick(snapFile, AllowUnauthenticated, s.meter(), testOrigin)
+	c.Assert(err, IsNil)
+
+	link := filepath.Join(dirs.SnapExportedBinariesDir, "fmk", "foo")
+	target, err := os.Readlink(link)
+	c.Assert(err, IsNil)
+	c.Check(target, Equals, "/apps/fmk/1.0/bin/foo")
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapExportedBinariesDir, "fmk", "private")), Equals, false)
+
+	files := exportedPolicyGroupFiles("fmk")
+	content, err := ioutil.ReadFile(files[0])
+	c.Assert(err, IsNil)
+	c.Check(strings.Contains(string(content), "\n/apps/fmk/*/bin/foo ixr,\n"), Equals, true)
+	c.Check(helpers.FileExists(files[1]), Equals, true)
+
+	c.Assert(Remove("fmk", DoRemovePermanently, s.meter()), IsNil)
+	c.Check(helpers.FileExists(link), Equals, false)
+	c.Check(helpers.FileExists(files[0]), Equals, false)
+	c.Check(helpers.FileExists(files[1]), Equals, false)
+}
+
+func (s *SnapTestSuite) TestSnappyGenerateSnapBinaryWrapperExportedPath(c *C) {
+	binary := Binary{Name: "pastebinit", Exec: "bin/pastebinit"}
+	pkgPath := "/apps/pastebinit.mvo/1.4.0.0.1/"
+	aaProfile := "pastebinit.mvo_pastebinit_1.4.0.0.1"
+	m := packageYaml{Name: "pastebinit",
+		Version:    "1.4.0.0.1",
+		Frameworks: []string{"fmk1", "fmk2"},
+	}
+
+	generatedWrapper, err := generateSnapBinaryWrapper(binary, pkgPath, aaProfile, &m)
+	c.Assert(err, IsNil)
+	c.Check(generatedWrapper, Matches, `(?s).*
+export PATH="[^"]*/apps/exported/fmk1:[^"]*/apps/exported/fmk2:\$PATH"
+.*`)
+}
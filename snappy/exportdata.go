@@ -0,0 +1,101 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// exportDataHook returns the path of the export-data hook of the snap
+func (s *SnapPart) exportDataHook() string {
+	return filepath.Join(s.basedir, "meta", "hooks", "export-data")
+}
+
+// exportDataArchiveEnv is the variable of the environment of the
+// export-data hook that has the file it writes the archive to
+const exportDataArchiveEnv = "SNAP_EXPORT_DATA_ARCHIVE"
+
+// exportData makes the export-data hook of the snap, run through the
+// given Backend, write the archive of its data to target
+func (s *SnapPart) exportData(target string, backend Backend) error {
+	hook := s.exportDataHook()
+	if !helpers.FileExists(hook) {
+		return ErrExportDataNotFound
+	}
+
+	// the hook is confined, so it writes the archive to the data
+	// directory of the snap first
+	scratch := filepath.Join(dirs.SnapDataDir, QualifiedName(s), s.Version(), ".export-data")
+	if err := os.MkdirAll(scratch, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch)
+
+	archive := filepath.Join(scratch, "archive")
+	appArmorProfile := fmt.Sprintf("%s_%s_%s", QualifiedName(s), hookIntegration("export-data"), s.Version())
+	env := append(makeSnapHookEnv(s), exportDataArchiveEnv+"="+archive)
+	if err := backend.RunHook(hook, appArmorProfile, env); err != nil {
+		return err
+	}
+	if !helpers.FileExists(archive) {
+		return fmt.Errorf("export-data of %s did not write %s", s.Name(), archive)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+
+	return helpers.CopyFile(archive, target, helpers.CopyFlagSync|helpers.CopyFlagOverwrite)
+}
+
+// ExportData makes the export-data hook of the active snap with the
+// given name write a (portable) archive of the data of the snap to
+// target
+func ExportData(name, target string, meter progress.Meter) error {
+	part, ok := ActiveSnapByName(name).(*SnapPart)
+	if !ok {
+		return ErrPackageNotFound
+	}
+
+	return part.exportData(target, backendOf(meter))
+}
+
+// exportDataBeforePurge exports the data of the snap to the exported
+// data directory, if the snap has an export-data hook
+func (s *SnapPart) exportDataBeforePurge(meter progress.Meter) error {
+	if !helpers.FileExists(s.exportDataHook()) {
+		return nil
+	}
+
+	target := filepath.Join(dirs.SnapExportedDataDir, fmt.Sprintf("%s_%s_%d", QualifiedName(s), s.Version(), time.Now().Unix()))
+	if err := s.exportData(target, backendOf(meter)); err != nil {
+		return err
+	}
+	progress.NotifyMessage(meter, progress.NewMessage(progress.MsgExportedData, s.Name(), target))
+
+	return nil
+}
@@ -0,0 +1,137 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+// addExportDataHook gives the part an export-data hook, run by the
+// mock backend, that archives the data as "archived"
+func (s *purgeSuite) addExportDataHook(c *C, part *SnapPart) *[]string {
+	hook := part.exportDataHook()
+	c.Assert(os.MkdirAll(filepath.Dir(hook), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(hook, []byte("#!/bin/sh\n"), 0755), IsNil)
+
+	var profiles []string
+	s.backend.runHook = func(hook, appArmorProfile string, env []string) error {
+		profiles = append(profiles, appArmorProfile)
+		for _, kv := range env {
+			if strings.HasPrefix(kv, exportDataArchiveEnv+"=") {
+				return ioutil.WriteFile(strings.TrimPrefix(kv, exportDataArchiveEnv+"="), []byte("archived"), 0644)
+			}
+		}
+		return errors.New("no archive to write")
+	}
+
+	return &profiles
+}
+
+func (s *purgeSuite) TestExportData(c *C) {
+	inter := &MockProgressMeter{backend: s.backend}
+	ddir, part := s.mkpkg(c)
+	c.Assert(part.activate(true, inter), IsNil)
+	profiles := s.addExportDataHook(c, part)
+
+	target := filepath.Join(c.MkDir(), "backup", "hello-app.tar")
+	c.Assert(ExportData("hello-app", target, inter), IsNil)
+
+	content, err := ioutil.ReadFile(target)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "archived")
+	c.Check(*profiles, DeepEquals, []string{"hello-app." + testOrigin + "_snappy-export-data_1.10"})
+	// no leftovers in the data of the snap
+	c.Check(helpers.FileExists(filepath.Join(ddir, ".export-data")), Equals, false)
+}
+
+func (s *purgeSuite) TestExportDataNoHook(c *C) {
+	inter := &MockProgressMeter{backend: s.backend}
+	_, part := s.mkpkg(c)
+	c.Assert(part.activate(true, inter), IsNil)
+
+	c.Check(ExportData("hello-app", filepath.Join(c.MkDir(), "foo"), inter), Equals, ErrExportDataNotFound)
+}
+
+func (s *purgeSuite) TestExportDataNotInstalled(c *C) {
+	c.Check(ExportData("hello-app", filepath.Join(c.MkDir(), "foo"), &MockProgressMeter{backend: s.backend}), Equals, ErrPackageNotFound)
+}
+
+func (s *purgeSuite) TestPurgeExportsData(c *C) {
+	inter := &MockProgressMeter{backend: s.backend}
+	ddir, part := s.mkpkg(c)
+	s.addExportDataHook(c, part)
+
+	c.Assert(Purge("hello-app", 0, inter), IsNil)
+	c.Check(helpers.FileExists(ddir), Equals, false)
+
+	exported, err := filepath.Glob(filepath.Join(dirs.SnapExportedDataDir, "hello-app."+testOrigin+"_1.10_*"))
+	c.Assert(err, IsNil)
+	c.Assert(exported, HasLen, 1)
+	content, err := ioutil.ReadFile(exported[0])
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "archived")
+	c.Check(inter.notified, DeepEquals, []string{"Exported the data of hello-app to " + exported[0]})
+}
+
+func (s *purgeSuite) TestPurgeExportDataFails(c *C) {
+	inter := &MockProgressMeter{backend: s.backend}
+	ddir, part := s.mkpkg(c)
+	s.addExportDataHook(c, part)
+	s.backend.runHook = func(hook, appArmorProfile string, env []string) error {
+		return errors.New("disk full")
+	}
+
+	c.Check(Purge("hello-app", 0, inter), ErrorMatches, "disk full")
+	// the data is still there
+	c.Check(helpers.FileExists(filepath.Join(ddir, "canary.txt")), Equals, true)
+}
+
+func (s *purgeSuite) TestRemovePurgeExportsData(c *C) {
+	inter := &MockProgressMeter{backend: s.backend}
+	ddir, part := s.mkpkg(c)
+	c.Assert(part.activate(true, inter), IsNil)
+	profiles := s.addExportDataHook(c, part)
+
+	c.Assert(Remove("hello-app", DoRemovePurge, inter), IsNil)
+	c.Check(helpers.FileExists(filepath.Dir(ddir)), Equals, false)
+	c.Check(*profiles, DeepEquals, []string{"hello-app." + testOrigin + "_snappy-export-data_1.10"})
+
+	exported, err := filepath.Glob(filepath.Join(dirs.SnapExportedDataDir, "hello-app."+testOrigin+"_1.10_*"))
+	c.Assert(err, IsNil)
+	c.Assert(exported, HasLen, 1)
+	content, err := ioutil.ReadFile(exported[0])
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "archived")
+	c.Check(inter.notified, DeepEquals, []string{"Exported the data of hello-app to " + exported[0]})
+
+	// the removal of the data is metered, as that of Purge
+	c.Check(inter.total, Equals, 1.0)
+	c.Check(inter.progress, DeepEquals, []float64{1})
+	c.Check(inter.finished, Equals, true)
+}
@@ -28,6 +28,7 @@ import (
 	"path/filepath"
 
 	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
 	"github.com/ubuntu-core/snappy/pkg"
 
 	"gopkg.in/yaml.v2"
@@ -53,7 +54,7 @@ var activeSnapsByType = ActiveSnapsByType
 // OemConfig checks for an oem snap and if found applies the configuration
 // set there to the system
 func oemConfig() error {
-	oemSnap, err := activeSnapsByType(pkg.TypeOem)
+	oemSnap, err := activeSnapsByType(pkg.TypeGadget, pkg.TypeOem)
 	if err != nil {
 		return err
 	}
@@ -89,16 +90,32 @@ func oemConfig() error {
 }
 
 // FirstBoot checks whether it's the first boot, and if so enables the
-// first ethernet device and runs oemConfig (as well as flagging that
-// it run)
+// first ethernet device, installs the snaps of the image lock file that
+// are missing and runs oemConfig (flagging that it run once all of that
+// worked)
 func FirstBoot() error {
 	if firstBootHasRun() {
 		return ErrNotFirstBoot
 	}
-	defer stampFirstBoot()
-	defer enableFirstEther()
 
-	return oemConfig()
+	// the locked snaps come from the store
+	if err := enableFirstEther(); err != nil {
+		logger.Noticef("Failed to enable the first ethernet device: %v", err)
+	}
+
+	if err := installLockedOnFirstBoot(); err != nil {
+		return err
+	}
+	if err := oemConfig(); err != nil {
+		return err
+	}
+	if err := gadgetBootloaderConfig(); err != nil {
+		return err
+	}
+
+	// only once all went through, so what failed is retried on the
+	// next boot
+	return stampFirstBoot()
 }
 
 // NOTE: if you change stampFile, update the condition in
@@ -20,6 +20,7 @@
 package snappy
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -135,6 +136,16 @@ func (s *FirstBootTestSuite) TestNoErrorWhenNoOEM(c *C) {
 	c.Assert(err, IsNil)
 }
 
+func (s *FirstBootTestSuite) TestNoStampWhenOemConfigFails(c *C) {
+	activeSnapsByType = func(snapsTs ...pkg.Type) ([]Part, error) {
+		return nil, errors.New("no oem")
+	}
+
+	c.Assert(FirstBoot(), ErrorMatches, "no oem")
+	_, err := os.Stat(stampFile)
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
 func (s *FirstBootTestSuite) TestEnableFirstEther(c *C) {
 	c.Check(enableFirstEther(), IsNil)
 	fs, _ := filepath.Glob(filepath.Join(ethdir, "*"))
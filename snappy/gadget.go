@@ -0,0 +1,344 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/pkg"
+)
+
+// Gadget represents the structure inside the package.yaml for the gadget
+// component of a gadget package type. It is a superset of OEM, the
+// stanza of the legacy oem package type.
+type Gadget struct {
+	OEM `yaml:",inline"`
+
+	// Bootloader is how the device boots
+	Bootloader *GadgetBootloader `yaml:"bootloader,omitempty"`
+	// Connections are made between the snaps of the image when they
+	// are installed (see DefaultConnections)
+	Connections []GadgetConnection `yaml:"connections,omitempty"`
+}
+
+// GadgetBootloader is the bootloader of a gadget, and its configuration
+type GadgetBootloader struct {
+	// Name is the bootloader (grub or u-boot)
+	Name string `yaml:"name"`
+	// Env is set in the environment of the bootloader
+	Env map[string]string `yaml:"env,omitempty"`
+}
+
+// the bootloaders a gadget can use
+var gadgetBootloaders = []string{"grub", "u-boot"}
+
+// GadgetConnection connects the plug of a snap to the slot of another,
+// both given as snap:name
+type GadgetConnection struct {
+	Plug string `yaml:"plug"`
+	Slot string `yaml:"slot"`
+}
+
+func validConnectionEnd(s string) bool {
+	l := strings.Split(s, ":")
+	return len(l) == 2 && l[0] != "" && l[1] != ""
+}
+
+func (g *Gadget) validate() error {
+	if g.Bootloader != nil {
+		known := false
+		for _, name := range gadgetBootloaders {
+			if g.Bootloader.Name == name {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown bootloader %q (expected one of %s)", g.Bootloader.Name, strings.Join(gadgetBootloaders, ", "))
+		}
+	}
+
+	for _, c := range g.Connections {
+		if !validConnectionEnd(c.Plug) || !validConnectionEnd(c.Slot) {
+			return fmt.Errorf("invalid connection %q to %q (expected snap:name)", c.Plug, c.Slot)
+		}
+	}
+
+	return nil
+}
+
+// validateGadgetYaml checks that the snap describes the gadget in the
+// stanza of its type: gadget for the gadget type, oem for the (legacy)
+// oem type, and that the legacy stanza only has what the oem type
+// knows about
+func validateGadgetYaml(m *packageYaml) error {
+	switch m.Type {
+	case pkg.TypeGadget:
+		if !reflect.DeepEqual(m.OEM, OEM{}) {
+			return fmt.Errorf(`the gadget type uses the "gadget" stanza, not "oem"`)
+		}
+		if m.Gadget != nil {
+			return m.Gadget.validate()
+		}
+	case pkg.TypeOem:
+		if m.Gadget != nil {
+			return fmt.Errorf(`the oem type uses the "oem" stanza, not "gadget"`)
+		}
+		if m.OEM.Store.Pinned {
+			return fmt.Errorf(`store pinning needs the gadget type`)
+		}
+	}
+
+	return nil
+}
+
+// readGadget makes the two types read alike: the gadget of a gadget
+// snap is also its OEM (for the code that knows only about that one),
+// and the OEM of an oem snap is also its gadget
+func (m *packageYaml) readGadget() {
+	switch m.Type {
+	case pkg.TypeGadget:
+		if m.Gadget == nil {
+			m.Gadget = &Gadget{}
+		}
+		m.OEM = m.Gadget.OEM
+	case pkg.TypeOem:
+		m.Gadget = &Gadget{OEM: m.OEM}
+	}
+}
+
+// getGadget returns the gadget of the active gadget (or oem) snap
+func getGadget() (*Gadget, error) {
+	m, err := getOem()
+	if err != nil {
+		return nil, err
+	}
+	if m.Gadget == nil {
+		// the packageYaml of a mocked getOem
+		return &Gadget{OEM: m.OEM}, nil
+	}
+
+	return m.Gadget, nil
+}
+
+// DefaultConnections returns the connections the gadget asks for, if any
+func DefaultConnections() []GadgetConnection {
+	gadget, err := getGadget()
+	if err != nil {
+		return nil
+	}
+
+	return gadget.Connections
+}
+
+// Bootloader returns the bootloader configuration of the gadget, or nil
+// if the gadget does not set one
+func Bootloader() *GadgetBootloader {
+	gadget, err := getGadget()
+	if err != nil {
+		return nil
+	}
+
+	return gadget.Bootloader
+}
+
+// the snap whose slots are the policy groups of the system itself
+const coreSlotSnap = "ubuntu-core"
+
+// connectedCaps returns the policy groups the connections of the gadget
+// give to the snap with the given name: a slot of ubuntu-core is the
+// policy group of that name, the slot of a framework is the policy
+// group the framework ships (as framework_group)
+func connectedCaps(name string) []string {
+	var caps []string
+	for _, c := range DefaultConnections() {
+		plug := strings.Split(c.Plug, ":")
+		if plug[0] != name {
+			continue
+		}
+		slot := strings.Split(c.Slot, ":")
+		if slot[0] == coreSlotSnap {
+			caps = append(caps, slot[1])
+		} else {
+			caps = append(caps, slot[0]+"_"+slot[1])
+		}
+	}
+
+	return caps
+}
+
+// withConnectedCaps returns the security definitions of a binary or
+// service of the named snap with the policy groups of its connections
+// added to them
+func withConnectedCaps(name string, sd SecurityDefinitions) SecurityDefinitions {
+	caps := connectedCaps(name)
+	if len(caps) == 0 {
+		return sd
+	}
+
+	base := sd.SecurityCaps
+	if sd.SecurityTemplate == "" && base == nil {
+		base = defaultPolicyGroups
+	}
+	sd.SecurityCaps = append(append([]string{}, base...), caps...)
+
+	return sd
+}
+
+// connectSnap makes the connections of the gadget for the snap being
+// installed, for the AppArmor profiles the click hooks generate for it
+// (the seccomp ones get them from withConnectedCaps)
+func connectSnap(m *packageYaml, origin string) error {
+	caps := connectedCaps(m.Name)
+	if len(caps) == 0 {
+		return nil
+	}
+
+	qn := m.qualifiedName(origin)
+	appArmorAdditional, err := readHWAccessJSONFile(qn)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	connected := make(map[string]bool)
+	for _, cap := range appArmorAdditional.PolicyGroups {
+		connected[cap] = true
+	}
+	for _, cap := range caps {
+		if !connected[cap] {
+			appArmorAdditional.PolicyGroups = append(appArmorAdditional.PolicyGroups, cap)
+			connected[cap] = true
+		}
+	}
+
+	if err := os.MkdirAll(dirs.SnapAppArmorDir, 0755); err != nil {
+		return err
+	}
+
+	return writeHWAccessJSONFile(qn, appArmorAdditional)
+}
+
+// disconnectSnap undoes connectSnap
+func disconnectSnap(m *packageYaml, origin string) error {
+	caps := connectedCaps(m.Name)
+	if len(caps) == 0 {
+		return nil
+	}
+
+	qn := m.qualifiedName(origin)
+	appArmorAdditional, err := readHWAccessJSONFile(qn)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	connected := make(map[string]bool)
+	for _, cap := range caps {
+		connected[cap] = true
+	}
+	var kept []string
+	for _, cap := range appArmorAdditional.PolicyGroups {
+		if !connected[cap] {
+			kept = append(kept, cap)
+		}
+	}
+	appArmorAdditional.PolicyGroups = kept
+
+	return writeHWAccessJSONFile(qn, appArmorAdditional)
+}
+
+// gadgetBootloaderConfig sets up the environment of the bootloader as
+// the gadget asks for
+func gadgetBootloaderConfig() error {
+	bootloader := Bootloader()
+	if bootloader == nil || len(bootloader.Env) == 0 {
+		return nil
+	}
+
+	return newPartition().SetBootVars(bootloader.Name, bootloader.Env)
+}
+
+// StorePinned returns true if the gadget pins the device to its store,
+// so that it can not be changed (with UBUNTU_STORE_ID)
+func StorePinned() bool {
+	gadget, err := getGadget()
+	if err != nil {
+		return false
+	}
+
+	return gadget.Store.Pinned
+}
+
+// convertGadgetYaml rewrites the package.yaml data of a snap of type
+// from into one of type to, renaming the stanza describing the gadget
+// along; the rest of the yaml is kept as is, byte for byte
+func convertGadgetYaml(yamlData []byte, from, to pkg.Type) ([]byte, error) {
+	typeRe := regexp.MustCompile(`^type:\s*["']?` + string(from) + `["']?\s*$`)
+	stanzaRe := regexp.MustCompile(`^` + string(from) + `:`)
+
+	converted := false
+	lines := strings.Split(string(yamlData), "\n")
+	for i, line := range lines {
+		switch {
+		case typeRe.MatchString(line):
+			lines[i] = "type: " + string(to)
+			converted = true
+		case stanzaRe.MatchString(line):
+			lines[i] = string(to) + ":" + line[len(from)+1:]
+		}
+	}
+	if !converted {
+		return nil, fmt.Errorf("can not convert a snap that is not of type %q", from)
+	}
+
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// ConvertOemToGadget returns the package.yaml data of an oem snap as
+// that of the equivalent gadget snap
+func ConvertOemToGadget(yamlData []byte) ([]byte, error) {
+	return convertGadgetYaml(yamlData, pkg.TypeOem, pkg.TypeGadget)
+}
+
+// ConvertGadgetToOem returns the package.yaml data of a gadget snap as
+// that of the equivalent oem snap, for systems that do not know about
+// the gadget type yet; gadgets that use what the oem type lacks can not
+// be converted
+func ConvertGadgetToOem(yamlData []byte) ([]byte, error) {
+	m, err := parsePackageYamlData(yamlData, false)
+	if err != nil {
+		return nil, err
+	}
+	if m.Type != pkg.TypeGadget {
+		return nil, fmt.Errorf("can not convert a snap that is not of type %q", pkg.TypeGadget)
+	}
+	if m.Gadget.Bootloader != nil || len(m.Gadget.Connections) > 0 || m.Gadget.Store.Pinned {
+		return nil, fmt.Errorf("the gadget uses what the oem type does not support (bootloader, connections or store pinning)")
+	}
+
+	return convertGadgetYaml(yamlData, pkg.TypeGadget, pkg.TypeOem)
+}
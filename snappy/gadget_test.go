@@ -0,0 +1,230 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/partition"
+	"github.com/ubuntu-core/snappy/pkg"
+)
+
+const gadgetYaml = `name: gadget-test
+version: 1.0
+vendor: mvo
+type: gadget
+gadget:
+  store:
+    id: my-store
+    pinned: true
+  software:
+    built-in:
+      - foo
+  bootloader:
+    name: u-boot
+    env:
+      console: ttyS0
+  connections:
+    - plug: foo:network
+      slot: ubuntu-core:network
+`
+
+const oemYaml = `name: oem-test
+version: 1.0
+vendor: mvo
+type: oem
+oem:
+  store:
+    id: my-store
+  software:
+    built-in:
+      - foo
+`
+
+func (s *SnapTestSuite) TestGadgetTypeIsGadget(c *C) {
+	c.Check(pkg.TypeGadget.IsGadget(), Equals, true)
+	c.Check(pkg.TypeOem.IsGadget(), Equals, true)
+	c.Check(pkg.TypeApp.IsGadget(), Equals, false)
+	c.Check(pkg.TypeFramework.IsGadget(), Equals, false)
+}
+
+func (s *SnapTestSuite) TestGadgetYamlReadsAsOem(c *C) {
+	m, err := parsePackageYamlData([]byte(gadgetYaml), false)
+	c.Assert(err, IsNil)
+	c.Assert(m.Gadget, NotNil)
+	c.Check(m.Gadget.Store.ID, Equals, "my-store")
+	c.Check(m.Gadget.Bootloader, DeepEquals, &GadgetBootloader{Name: "u-boot", Env: map[string]string{"console": "ttyS0"}})
+	c.Check(m.Gadget.Connections, DeepEquals, []GadgetConnection{{Plug: "foo:network", Slot: "ubuntu-core:network"}})
+	// what only knows about oem sees it all the same
+	c.Check(m.OEM.Store.ID, Equals, "my-store")
+	c.Check(m.OEM.Software.BuiltIn, DeepEquals, []string{"foo"})
+	c.Check(m.qualifiedName("foo"), Equals, "gadget-test")
+}
+
+func (s *SnapTestSuite) TestOemYamlReadsAsGadget(c *C) {
+	m, err := parsePackageYamlData([]byte(oemYaml), false)
+	c.Assert(err, IsNil)
+	c.Assert(m.Gadget, NotNil)
+	c.Check(m.Gadget.Store.ID, Equals, "my-store")
+	c.Check(m.Gadget.Software.BuiltIn, DeepEquals, []string{"foo"})
+	c.Check(m.Gadget.Bootloader, IsNil)
+}
+
+func (s *SnapTestSuite) TestGadgetYamlInvalid(c *C) {
+	for _, t := range []struct {
+		yaml string
+		err  string
+	}{
+		{"type: gadget\noem:\n  store:\n    id: foo\n", `.*the gadget type uses the "gadget" stanza.*`},
+		{"type: oem\ngadget:\n  store:\n    id: foo\n", `.*the oem type uses the "oem" stanza.*`},
+		{"type: oem\noem:\n  store:\n    pinned: true\n", `.*store pinning needs the gadget type.*`},
+		{"type: gadget\ngadget:\n  bootloader:\n    name: lilo\n", `.*unknown bootloader "lilo".*`},
+		{"type: gadget\ngadget:\n  connections:\n    - plug: foo\n      slot: bar:baz\n", `.*invalid connection "foo" to "bar:baz".*`},
+	} {
+		_, err := parsePackageYamlData([]byte("name: foo\nversion: 1.0\nvendor: bar\n"+t.yaml), false)
+		c.Check(err, ErrorMatches, t.err, Commentf(t.yaml))
+	}
+}
+
+func (s *SnapTestSuite) TestInstalledGadget(c *C) {
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, gadgetYaml)
+	c.Assert(err, IsNil)
+	makeSnapActive(yamlPath)
+
+	c.Check(StoreID(), Equals, "my-store")
+	c.Check(StorePinned(), Equals, true)
+	c.Check(IsBuiltInSoftware("foo"), Equals, true)
+	c.Check(Bootloader().Name, Equals, "u-boot")
+	c.Check(DefaultConnections(), HasLen, 1)
+}
+
+func (s *SnapTestSuite) TestGadgetConnections(c *C) {
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, gadgetYaml)
+	c.Assert(err, IsNil)
+	makeSnapActive(yamlPath)
+
+	c.Check(connectedCaps("foo"), DeepEquals, []string{"network"})
+	c.Check(connectedCaps("bar"), HasLen, 0)
+
+	sd := withConnectedCaps("foo", SecurityDefinitions{})
+	c.Check(sd.SecurityCaps, DeepEquals, []string{"network-client", "network"})
+	sd = withConnectedCaps("foo", SecurityDefinitions{SecurityCaps: []string{"video"}})
+	c.Check(sd.SecurityCaps, DeepEquals, []string{"video", "network"})
+
+	m := &packageYaml{Name: "foo", Type: pkg.TypeApp}
+	c.Assert(connectSnap(m, testOrigin), IsNil)
+	additional, err := readHWAccessJSONFile("foo." + testOrigin)
+	c.Assert(err, IsNil)
+	c.Check(additional.PolicyGroups, DeepEquals, []string{"network"})
+
+	c.Assert(disconnectSnap(m, testOrigin), IsNil)
+	additional, err = readHWAccessJSONFile("foo." + testOrigin)
+	c.Assert(err, IsNil)
+	c.Check(additional.PolicyGroups, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestGadgetBootloaderConfig(c *C) {
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, gadgetYaml)
+	c.Assert(err, IsNil)
+	makeSnapActive(yamlPath)
+
+	p := new(MockPartition)
+	newPartition = func() partition.Interface {
+		return p
+	}
+
+	c.Assert(gadgetBootloaderConfig(), IsNil)
+	c.Check(p.bootloaderName, Equals, "u-boot")
+	c.Check(p.bootVars, DeepEquals, map[string]string{"console": "ttyS0"})
+}
+
+func (s *SnapTestSuite) TestNoGadget(c *C) {
+	c.Check(StorePinned(), Equals, false)
+	c.Check(Bootloader(), IsNil)
+	c.Check(DefaultConnections(), HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryPinnedStore(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("X-Ubuntu-Store"), Equals, "my-store")
+		w.WriteHeader(404)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, gadgetYaml)
+	c.Assert(err, IsNil)
+	makeSnapActive(yamlPath)
+
+	os.Setenv("UBUNTU_STORE_ID", "other-store")
+	defer os.Unsetenv("UBUNTU_STORE_ID")
+
+	storeDetailsURI, err = url.Parse(mockServer.URL)
+	c.Assert(err, IsNil)
+	repo := NewUbuntuStoreSnapRepository()
+	c.Assert(repo, NotNil)
+
+	repo.Details("xkcd", "")
+}
+
+func (s *SnapTestSuite) TestConvertOemToGadget(c *C) {
+	out, err := ConvertOemToGadget([]byte(oemYaml))
+	c.Assert(err, IsNil)
+	c.Check(string(out), Equals, `name: oem-test
+version: 1.0
+vendor: mvo
+type: gadget
+gadget:
+  store:
+    id: my-store
+  software:
+    built-in:
+      - foo
+`)
+
+	m, err := parsePackageYamlData(out, false)
+	c.Assert(err, IsNil)
+	c.Check(m.Type, Equals, pkg.TypeGadget)
+	c.Check(m.OEM.Store.ID, Equals, "my-store")
+
+	_, err = ConvertOemToGadget(out)
+	c.Check(err, ErrorMatches, `can not convert a snap that is not of type "oem"`)
+}
+
+func (s *SnapTestSuite) TestConvertGadgetToOem(c *C) {
+	gadget, err := ConvertOemToGadget([]byte(oemYaml))
+	c.Assert(err, IsNil)
+
+	out, err := ConvertGadgetToOem(gadget)
+	c.Assert(err, IsNil)
+	m, err := parsePackageYamlData(out, false)
+	c.Assert(err, IsNil)
+	c.Check(m.Type, Equals, pkg.TypeOem)
+	c.Check(m.OEM.Software.BuiltIn, DeepEquals, []string{"foo"})
+
+	// the oem type can not do it all
+	_, err = ConvertGadgetToOem([]byte(gadgetYaml))
+	c.Check(err, ErrorMatches, "the gadget uses what the oem type does not support.*")
+}
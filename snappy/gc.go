@@ -0,0 +1,113 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"sort"
+
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// DefaultGCKeep is the number of inactive versions of each snap that
+// GarbageCollectAll keeps by default
+const DefaultGCKeep = 2
+
+// GCOptions are the options of GarbageCollectAll
+type GCOptions struct {
+	// Keep is the number of inactive versions of each snap to keep
+	// (DefaultGCKeep if 0), for rolling back to
+	Keep int
+	// DryRun only works out the versions to remove
+	DryRun bool
+	// Meter to report progress to (defaults to no progress)
+	Meter progress.Meter
+}
+
+func (opts *GCOptions) keep() int {
+	if opts.Keep < 1 {
+		return DefaultGCKeep
+	}
+
+	return opts.Keep
+}
+
+// GarbageCollectAll removes the versions older than the active one of
+// the installed snaps (of the named ones, if any) but the newest
+// opts.Keep ones of each, along with their icons and manifests. It returns the versions
+// removed, or the ones it would remove on a dry run.
+func GarbageCollectAll(names []string, opts *GCOptions) ([]Part, error) {
+	if opts == nil {
+		opts = &GCOptions{}
+	}
+	meter := opts.Meter
+	if meter == nil {
+		meter = &progress.NullProgress{}
+	}
+
+	installed, err := NewMetaRepository().Installed()
+	if err != nil {
+		return nil, err
+	}
+
+	// the versions of each snap, by qualified name
+	snaps := make(map[string]BySnapVersion)
+	if len(names) == 0 {
+		for _, part := range installed {
+			qn := QualifiedName(part)
+			snaps[qn] = append(snaps[qn], part)
+		}
+	} else {
+		for _, name := range names {
+			found := FindSnapsByName(name, installed)
+			if len(found) == 0 {
+				return nil, ErrPackageNotFound
+			}
+			for _, part := range found {
+				qn := QualifiedName(part)
+				snaps[qn] = append(snaps[qn], part)
+			}
+		}
+	}
+
+	qns := make([]string, 0, len(snaps))
+	for qn := range snaps {
+		qns = append(qns, qn)
+	}
+	sort.Strings(qns)
+
+	var removed []Part
+	for _, qn := range qns {
+		parts, err := gcCandidates(snaps[qn], opts.keep())
+		if err != nil {
+			return removed, err
+		}
+
+		for _, part := range parts {
+			if !opts.DryRun {
+				if err := part.Uninstall(meter); err != nil {
+					return removed, ErrGarbageCollectImpossible(err.Error())
+				}
+			}
+			removed = append(removed, part)
+		}
+	}
+
+	return removed, nil
+}
@@ -0,0 +1,104 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+func (s *SnapTestSuite) makeGCMockSnaps(c *C, name string, versions ...string) {
+	for _, version := range versions {
+		yamlFile, err := makeInstalledMockSnap(s.tempdir, "name: "+name+"\nversion: "+version+"\nvendor: foo")
+		c.Assert(err, IsNil)
+		if version == versions[len(versions)-1] {
+			c.Assert(makeSnapActive(yamlFile), IsNil)
+		}
+	}
+}
+
+func gcVersions(parts []Part) []string {
+	versions := make([]string, len(parts))
+	for i, part := range parts {
+		versions[i] = QualifiedName(part) + " " + part.Version()
+	}
+
+	return versions
+}
+
+func (s *SnapTestSuite) TestGarbageCollectAllDryRun(c *C) {
+	s.makeGCMockSnaps(c, "foo", "1", "2", "3", "4")
+	s.makeGCMockSnaps(c, "bar", "1", "2")
+
+	removed, err := GarbageCollectAll(nil, &GCOptions{DryRun: true})
+	c.Assert(err, IsNil)
+	c.Check(gcVersions(removed), DeepEquals, []string{"foo." + testOrigin + " 1"})
+
+	// nothing was removed
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, "foo."+testOrigin, "1")), Equals, true)
+}
+
+func (s *SnapTestSuite) TestGarbageCollectAllKeep(c *C) {
+	s.makeGCMockSnaps(c, "foo", "1", "2", "3", "4")
+	s.makeGCMockSnaps(c, "bar", "1", "2")
+
+	removed, err := GarbageCollectAll(nil, &GCOptions{Keep: 1, Meter: s.meter()})
+	c.Assert(err, IsNil)
+	c.Check(gcVersions(removed), DeepEquals, []string{
+		"foo." + testOrigin + " 1",
+		"foo." + testOrigin + " 2",
+	})
+
+	for version, exists := range map[string]bool{"1": false, "2": false, "3": true, "4": true} {
+		c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, "foo."+testOrigin, version)), Equals, exists)
+	}
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapMetaDir, "foo."+testOrigin+"_1.manifest")), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, "bar."+testOrigin, "1")), Equals, true)
+}
+
+func (s *SnapTestSuite) TestGarbageCollectAllNamed(c *C) {
+	s.makeGCMockSnaps(c, "foo", "1", "2", "3", "4")
+	s.makeGCMockSnaps(c, "bar", "1", "2", "3")
+
+	removed, err := GarbageCollectAll([]string{"bar"}, &GCOptions{Keep: 1, Meter: s.meter()})
+	c.Assert(err, IsNil)
+	c.Check(gcVersions(removed), DeepEquals, []string{"bar." + testOrigin + " 1"})
+
+	_, err = GarbageCollectAll([]string{"baz"}, nil)
+	c.Check(err, Equals, ErrPackageNotFound)
+}
+
+func (s *SnapTestSuite) TestGarbageCollectKeepsNewerInactive(c *C) {
+	// 4 is left inactive, over the active 3; 2 is what a rollback
+	// goes back to
+	s.makeGCMockSnaps(c, "foo", "1", "2", "4", "3")
+
+	removed, err := GarbageCollectAll(nil, &GCOptions{Keep: 1, DryRun: true})
+	c.Assert(err, IsNil)
+	c.Check(gcVersions(removed), DeepEquals, []string{"foo." + testOrigin + " 1"})
+
+	removed, err = GarbageCollectAll(nil, &GCOptions{DryRun: true})
+	c.Assert(err, IsNil)
+	c.Check(removed, HasLen, 0)
+}
@@ -0,0 +1,176 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+// generatedFile is a file (or symlink) that snappy generated on the
+// system for the installed snaps
+type generatedFile struct {
+	Path   string        `yaml:"path"`
+	Kind   string        `yaml:"kind"`
+	Mode   *yamlFileMode `yaml:"mode"`
+	Sha512 string        `yaml:"sha512,omitempty"`
+	Target string        `yaml:"target,omitempty"`
+}
+
+type generatedFiles []*generatedFile
+
+func (f generatedFiles) Len() int           { return len(f) }
+func (f generatedFiles) Less(i, j int) bool { return f[i].Path < f[j].Path }
+func (f generatedFiles) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+
+// generatedState is what DumpGeneratedState writes
+type generatedState struct {
+	Files generatedFiles `yaml:"files"`
+}
+
+// generatedGlob is where to look for generated files of a kind
+type generatedGlob struct {
+	kind string
+	glob func() string
+	// generated returns true if the file was generated by snappy,
+	// for the directories that are shared with the system (nil if
+	// everything matching the glob was)
+	generated func(fn string) bool
+}
+
+var systemdUnitMarker = []byte("\nX-Snappy=yes\n")
+
+// isSnappyUnit returns true for the systemd units snappy generated
+func isSnappyUnit(fn string) bool {
+	content, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return false
+	}
+
+	return bytes.Contains(content, systemdUnitMarker)
+}
+
+// isSnappyUnitLink returns true for the symlinks that enable the
+// systemd units snappy generated
+func isSnappyUnitLink(fn string) bool {
+	target, err := os.Readlink(fn)
+	if err != nil {
+		return false
+	}
+
+	return isSnappyUnit(filepath.Join(dirs.SnapServicesDir, filepath.Base(target)))
+}
+
+var generatedGlobs = []generatedGlob{
+	{"unit", func() string { return filepath.Join(dirs.SnapServicesDir, "*") }, isSnappyUnit},
+	{"unit-link", func() string { return filepath.Join(dirs.SnapServicesDir, "*.wants", "*") }, isSnappyUnitLink},
+	{"wrapper", func() string { return filepath.Join(dirs.SnapBinariesDir, "*") }, nil},
+	{"exported", func() string { return filepath.Join(dirs.SnapExportedBinariesDir, "*") }, nil},
+	{"apparmor", func() string { return filepath.Join(dirs.SnapAppArmorDir, "*") }, nil},
+	{"seccomp", func() string { return filepath.Join(dirs.SnapSeccompDir, "*") }, nil},
+	{"selinux", func() string { return filepath.Join(dirs.SnapSELinuxDir, "*") }, nil},
+	{"udev", func() string { return filepath.Join(dirs.SnapUdevRulesDir, "*-snappy_*.rules") }, nil},
+	{"bus-policy", func() string { return filepath.Join(dirs.SnapBusPolicyDir, "*_*_*.conf") }, nil},
+	{"journald", func() string { return filepath.Join(dirs.SnapJournaldConfDir, "journald@*.conf") }, nil},
+	{"current", func() string { return filepath.Join(dirs.SnapAppsDir, "*", "current") }, nil},
+	{"current", func() string { return filepath.Join(dirs.SnapOemDir, "*", "current") }, nil},
+	{"current", func() string { return filepath.Join(dirs.SnapDataDir, "*", "current") }, nil},
+}
+
+func newGeneratedFile(fn, kind string) (*generatedFile, error) {
+	st, err := os.Lstat(fn)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &generatedFile{
+		Path: stripGlobalRootDir(fn),
+		Kind: kind,
+		Mode: newYamlFileMode(st.Mode()),
+	}
+
+	switch {
+	case st.Mode()&os.ModeSymlink != 0:
+		f.Target, err = os.Readlink(fn)
+	case st.Mode().IsRegular():
+		f.Sha512, err = helpers.Sha512sum(fn)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// collectGeneratedFiles returns the files matching the globs, sorted
+// by their path
+func collectGeneratedFiles(globs []generatedGlob) (generatedFiles, error) {
+	var files generatedFiles
+
+	for _, g := range globs {
+		matches, err := filepath.Glob(g.glob())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, fn := range matches {
+			if g.generated != nil && !g.generated(fn) {
+				continue
+			}
+
+			f, err := newGeneratedFile(fn, g.kind)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, f)
+		}
+	}
+	sort.Sort(files)
+
+	return files, nil
+}
+
+// DumpGeneratedState writes the paths and hashes of all the files
+// snappy generated for the installed snaps (units, wrappers, security
+// profiles, udev rules, symlinks, ...) as a single yaml document, so
+// the effect of a snappy upgrade on the same snaps can be diffed
+func DumpGeneratedState(w io.Writer) error {
+	files, err := collectGeneratedFiles(generatedGlobs)
+	if err != nil {
+		return err
+	}
+
+	content, err := yaml.Marshal(generatedState{Files: files})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(content)
+
+	return err
+}
@@ -0,0 +1,92 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+)
+
+func (s *SnapTestSuite) TestDumpGeneratedState(c *C) {
+	unit := filepath.Join(dirs.SnapServicesDir, "foo_bar_1.0.service")
+	c.Assert(ioutil.WriteFile(unit, []byte("[Unit]\nX-Snappy=yes\n\n[Service]\n"), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapServicesDir, "other.service"), []byte("[Unit]\n"), 0644), IsNil)
+	c.Assert(os.Symlink(unit, filepath.Join(dirs.SnapServicesDir, "multi-user.target.wants", "foo_bar_1.0.service")), IsNil)
+	c.Assert(os.Symlink("/lib/systemd/system/other.service", filepath.Join(dirs.SnapServicesDir, "multi-user.target.wants", "other.service")), IsNil)
+
+	c.Assert(os.MkdirAll(dirs.SnapBinariesDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapBinariesDir, "foo.hello"), []byte("#!/bin/sh\n"), 0755), IsNil)
+	c.Assert(os.MkdirAll(dirs.SnapUdevRulesDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapUdevRulesDir, "80-snappy_oem_foo.rules"), nil, 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapUdevRulesDir, "60-system.rules"), nil, 0644), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(dirs.SnapAppsDir, "foo.bar", "1.0"), 0755), IsNil)
+	c.Assert(os.Symlink("1.0", filepath.Join(dirs.SnapAppsDir, "foo.bar", "current")), IsNil)
+
+	var buf bytes.Buffer
+	c.Assert(DumpGeneratedState(&buf), IsNil)
+
+	var state struct {
+		Files []struct {
+			Path   string
+			Kind   string
+			Mode   string
+			Sha512 string
+			Target string
+		}
+	}
+	c.Assert(yaml.Unmarshal(buf.Bytes(), &state), IsNil)
+
+	c.Assert(state.Files, HasLen, 5)
+	c.Check(state.Files[0].Path, Equals, "/apps/bin/foo.hello")
+	c.Check(state.Files[0].Kind, Equals, "wrapper")
+	c.Check(state.Files[0].Mode, Equals, "frwxr-xr-x")
+	c.Check(state.Files[0].Sha512, Matches, "[0-9a-f]{128}")
+	c.Check(state.Files[1].Path, Equals, "/apps/foo.bar/current")
+	c.Check(state.Files[1].Kind, Equals, "current")
+	c.Check(state.Files[1].Target, Equals, "1.0")
+	c.Check(state.Files[2].Path, Equals, "/etc/systemd/system/foo_bar_1.0.service")
+	c.Check(state.Files[2].Kind, Equals, "unit")
+	c.Check(state.Files[3].Path, Equals, "/etc/systemd/system/multi-user.target.wants/foo_bar_1.0.service")
+	c.Check(state.Files[3].Kind, Equals, "unit-link")
+	c.Check(state.Files[4].Path, Equals, "/etc/udev/rules.d/80-snappy_oem_foo.rules")
+	c.Check(state.Files[4].Kind, Equals, "udev")
+}
+
+func (s *SnapTestSuite) TestDumpGeneratedStateStable(c *C) {
+	c.Assert(os.MkdirAll(dirs.SnapAppArmorDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapAppArmorDir, "foo.bar_hello_1.0"), []byte("profile"), 0644), IsNil)
+
+	var buf1, buf2 bytes.Buffer
+	c.Assert(DumpGeneratedState(&buf1), IsNil)
+	c.Assert(DumpGeneratedState(&buf2), IsNil)
+	c.Check(buf1.String(), Equals, buf2.String())
+
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapAppArmorDir, "foo.bar_hello_1.0"), []byte("changed profile"), 0644), IsNil)
+	buf2.Reset()
+	c.Assert(DumpGeneratedState(&buf2), IsNil)
+	c.Check(buf1.String(), Not(Equals), buf2.String())
+}
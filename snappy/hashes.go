@@ -21,7 +21,12 @@ package snappy
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v2"
 )
 
 type yamlFileMode struct {
@@ -119,3 +124,97 @@ type hashesYaml struct {
 	// the hashes for the files in the archive
 	Files []*fileHash
 }
+
+// matches returns true if the file described by got is the one
+// described by h: the same kind of file, and for regular files the
+// same size and content (the permissions are not compared, they are
+// those of the unpacked file)
+func (h *fileHash) matches(got *fileHash) bool {
+	if h.Mode == nil || got.Mode == nil {
+		return false
+	}
+	if h.Mode.mode&os.ModeType != got.Mode.mode&os.ModeType {
+		return false
+	}
+	if got.Mode.mode.IsRegular() {
+		if h.Size == nil || got.Size == nil || *h.Size != *got.Size {
+			return false
+		}
+		return h.Sha512 == got.Sha512
+	}
+
+	return true
+}
+
+// verifyUnpackedFiles checks that the files unpacked in dir are those
+// its meta/hashes.yaml lists: none more, none less, and with the
+// content it gives. The .click tree snappy writes for the legacy hooks
+// is not part of the archive either.
+//
+// Snaps without a hashes.yaml (snapfs ones) are not checked.
+func verifyUnpackedFiles(snap, dir string) error {
+	hashesFile := filepath.Join(dir, "meta", "hashes.yaml")
+	hashesData, err := ioutil.ReadFile(hashesFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var h hashesYaml
+	if err := yaml.Unmarshal(hashesData, &h); err != nil {
+		return &ErrInvalidYaml{File: "hashes.yaml", Err: err, Yaml: hashesData}
+	}
+
+	listed := make(map[string]*fileHash, len(h.Files))
+	for _, f := range h.Files {
+		listed[filepath.Clean(f.Name)] = f
+	}
+
+	mismatch := &ErrUnpackedFilesMismatch{Snap: snap}
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		// the hashes.yaml itself is not part of the archive
+		if path == dir || path == hashesFile {
+			return nil
+		}
+		if path == filepath.Join(dir, ".click") {
+			return filepath.SkipDir
+		}
+
+		got, err := hashForFile(dir, path, info)
+		if err != nil {
+			return err
+		}
+
+		want, ok := listed[got.Name]
+		if !ok {
+			mismatch.Unexpected = append(mismatch.Unexpected, got.Name)
+			return nil
+		}
+		delete(listed, got.Name)
+
+		if !want.matches(got) {
+			mismatch.Modified = append(mismatch.Modified, got.Name)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for name := range listed {
+		mismatch.Missing = append(mismatch.Missing, name)
+	}
+	sort.Strings(mismatch.Missing)
+
+	if len(mismatch.Unexpected)+len(mismatch.Missing)+len(mismatch.Modified) > 0 {
+		return mismatch
+	}
+
+	return nil
+}
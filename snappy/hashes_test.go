@@ -152,3 +152,68 @@ files:
   mode: frw-r--r--
 `)
 }
+
+// makeUnpackedSnap returns a directory that looks like an unpacked
+// snap, with a meta/hashes.yaml that lists its files
+func makeUnpackedSnap(c *C) string {
+	dir := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(dir, "meta"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "meta", "package.yaml"), []byte("name: foo"), 0644), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(dir, "bin"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "bin", "bar"), []byte("bar\n"), 0755), IsNil)
+	c.Assert(os.Symlink("bar", filepath.Join(dir, "bin", "baz")), IsNil)
+
+	dataTar := filepath.Join(c.MkDir(), "data.tar.gz")
+	c.Assert(ioutil.WriteFile(dataTar, nil, 0644), IsNil)
+	c.Assert(writeHashes(dir, dataTar), IsNil)
+	c.Assert(os.Rename(filepath.Join(dir, "DEBIAN", "hashes.yaml"), filepath.Join(dir, "meta", "hashes.yaml")), IsNil)
+	c.Assert(os.Remove(filepath.Join(dir, "DEBIAN")), IsNil)
+
+	return dir
+}
+
+func (s *SnapTestSuite) TestVerifyUnpackedFiles(c *C) {
+	dir := makeUnpackedSnap(c)
+
+	c.Check(verifyUnpackedFiles("foo", dir), IsNil)
+}
+
+func (s *SnapTestSuite) TestVerifyUnpackedFilesNoHashes(c *C) {
+	dir := makeUnpackedSnap(c)
+	c.Assert(os.Remove(filepath.Join(dir, "meta", "hashes.yaml")), IsNil)
+
+	c.Check(verifyUnpackedFiles("foo", dir), IsNil)
+}
+
+func (s *SnapTestSuite) TestVerifyUnpackedFilesSkipsClickTree(c *C) {
+	dir := makeUnpackedSnap(c)
+	c.Assert(os.MkdirAll(filepath.Join(dir, ".click", "info"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, ".click", "info", "foo.manifest"), []byte("{}"), 0644), IsNil)
+
+	c.Check(verifyUnpackedFiles("foo", dir), IsNil)
+}
+
+func (s *SnapTestSuite) TestVerifyUnpackedFilesMismatch(c *C) {
+	dir := makeUnpackedSnap(c)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "bin", "evil"), nil, 0755), IsNil)
+	c.Assert(os.Remove(filepath.Join(dir, "meta", "package.yaml")), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "bin", "bar"), []byte("rab\n"), 0755), IsNil)
+	c.Assert(os.Remove(filepath.Join(dir, "bin", "baz")), IsNil)
+	c.Assert(os.Mkdir(filepath.Join(dir, "bin", "baz"), 0755), IsNil)
+
+	err := verifyUnpackedFiles("foo", dir)
+	c.Assert(err, DeepEquals, &ErrUnpackedFilesMismatch{
+		Snap:       "foo",
+		Unexpected: []string{"bin/evil"},
+		Missing:    []string{"meta/package.yaml"},
+		Modified:   []string{"bin/bar", "bin/baz"},
+	})
+	c.Check(err, ErrorMatches, "the files of foo do not match its hashes.yaml: unexpected bin/evil; missing meta/package.yaml; modified bin/bar, bin/baz")
+}
+
+func (s *SnapTestSuite) TestVerifyUnpackedFilesInvalidHashes(c *C) {
+	dir := makeUnpackedSnap(c)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "meta", "hashes.yaml"), []byte("files: {"), 0644), IsNil)
+
+	c.Check(verifyUnpackedFiles("foo", dir), FitsTypeOf, &ErrInvalidYaml{})
+}
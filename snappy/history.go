@@ -0,0 +1,222 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+)
+
+// the operations kept in the history
+const (
+	historyInstall = "install"
+	historyUpdate  = "update"
+	// historyActivate is a version of a snap being made active, by
+	// an install or update as well as by a rollback or revert
+	historyActivate = "activate"
+)
+
+// historyMaxEntries is the number of operations kept in the history
+var historyMaxEntries = 500
+
+// historyEntry is a single install or update operation
+type historyEntry struct {
+	ID      string    `yaml:"id,omitempty"`
+	Time    time.Time `yaml:"time"`
+	Op      string    `yaml:"op"`
+	Snap    string    `yaml:"snap"`
+	Version string    `yaml:"version,omitempty"`
+	// Error of the operation ("" if it succeeded)
+	Error string `yaml:"error,omitempty"`
+	// Trace is the ID of the trace of the operation ("" if it was
+	// not run with the Debug option)
+	Trace string `yaml:"trace,omitempty"`
+}
+
+func historyFile() string {
+	return filepath.Join(dirs.GlobalRootDir, dirs.SnappyDir, "history.yaml")
+}
+
+func readHistory() ([]historyEntry, error) {
+	content, err := ioutil.ReadFile(historyFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var history []historyEntry
+	if err := yaml.Unmarshal(content, &history); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// recordOperation appends an operation to the history, dropping the
+// oldest entries (and their traces) once there are more than
+// historyMaxEntries
+func recordOperation(op, snap, version string, opErr error, trace *Trace) {
+	entry := historyEntry{
+		ID:      newOperationID(),
+		Time:    correctedNow().UTC(),
+		Op:      op,
+		Snap:    snap,
+		Version: version,
+		Trace:   trace.id(),
+	}
+	if opErr != nil {
+		entry.Error = opErr.Error()
+	}
+
+	history, err := readHistory()
+	if err != nil {
+		logger.Noticef("Failed to read the history, starting a new one: %v", err)
+	}
+	history = append(history, entry)
+	if len(history) > historyMaxEntries {
+		removeTraces(history[:len(history)-historyMaxEntries], history[len(history)-historyMaxEntries:])
+		history = history[len(history)-historyMaxEntries:]
+	}
+
+	content, err := yaml.Marshal(history)
+	if err == nil {
+		fn := historyFile()
+		if err = os.MkdirAll(filepath.Dir(fn), 0755); err == nil {
+			err = helpers.AtomicWriteFile(fn, content, 0644, 0)
+		}
+	}
+	if err != nil {
+		logger.Noticef("Failed to record %s of %s: %v", op, snap, err)
+	}
+}
+
+// previouslyActive returns the versions of the snap with the given
+// qualified name that were active before, the most recently active
+// first (and the given active version left out)
+func previouslyActive(qn, active string) ([]string, error) {
+	history, err := readHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []string
+	seen := map[string]bool{active: true}
+	for i := len(history) - 1; i >= 0; i-- {
+		entry := history[i]
+		if entry.Op != historyActivate || entry.Snap != qn || seen[entry.Version] {
+			continue
+		}
+		seen[entry.Version] = true
+		versions = append(versions, entry.Version)
+	}
+
+	return versions, nil
+}
+
+// removeTraces removes the traces of the dropped history entries that
+// none of the kept ones refer to
+func removeTraces(dropped, kept []historyEntry) {
+	keep := make(map[string]bool, len(kept))
+	for _, entry := range kept {
+		keep[entry.Trace] = true
+	}
+
+	for _, entry := range dropped {
+		if entry.Trace == "" || keep[entry.Trace] {
+			continue
+		}
+		if err := os.Remove(traceFile(entry.Trace)); err != nil && !os.IsNotExist(err) {
+			logger.Noticef("Failed to remove the trace %s: %v", entry.Trace, err)
+		}
+	}
+}
+
+// Change is a single operation in a ChangesFeed
+type Change struct {
+	// ID identifies the operation (see OperationTrace)
+	ID      string
+	Time    time.Time
+	Snap    string
+	Version string
+	// Error is the reason the operation failed ("" on success)
+	Error string
+}
+
+// ChangesFeed is what happened on the system since a given time, and
+// what is available to be updated now
+type ChangesFeed struct {
+	Since     time.Time
+	Installed []Change
+	Updated   []Change
+	Failed    []Change
+	Available []Part
+}
+
+// listUpdates is ListUpdates, mockable for the tests
+var listUpdates = ListUpdates
+
+// ChangesSince returns the feed of changes since the given time
+func ChangesSince(t time.Time) (*ChangesFeed, error) {
+	history, err := readHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	feed := &ChangesFeed{Since: t}
+	for _, entry := range history {
+		if entry.Time.Before(t) {
+			continue
+		}
+
+		change := Change{
+			ID:      entry.ID,
+			Time:    entry.Time,
+			Snap:    entry.Snap,
+			Version: entry.Version,
+			Error:   entry.Error,
+		}
+		switch {
+		case entry.Error != "":
+			feed.Failed = append(feed.Failed, change)
+		case entry.Op == historyInstall:
+			feed.Installed = append(feed.Installed, change)
+		case entry.Op == historyUpdate:
+			feed.Updated = append(feed.Updated, change)
+		}
+	}
+
+	available, err := listUpdates()
+	if err != nil {
+		return nil, err
+	}
+	feed.Available = publishedOnly(available)
+
+	return feed, nil
+}
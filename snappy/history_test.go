@@ -0,0 +1,87 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/pkg/remote"
+)
+
+func mockListUpdates(parts []Part) func() {
+	listUpdates = func() ([]Part, error) {
+		return parts, nil
+	}
+	return func() { listUpdates = ListUpdates }
+}
+
+func (s *SnapTestSuite) TestChangesSinceEmpty(c *C) {
+	defer mockListUpdates(nil)()
+
+	feed, err := ChangesSince(time.Time{})
+	c.Assert(err, IsNil)
+	c.Check(feed.Installed, HasLen, 0)
+	c.Check(feed.Updated, HasLen, 0)
+	c.Check(feed.Failed, HasLen, 0)
+	c.Check(feed.Available, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestChangesSince(c *C) {
+	available := []Part{&RemoteSnapPart{pkg: remote.Snap{Name: "foo", Version: "2.0"}}}
+	// unpublished snaps are no updates
+	unpublished := &RemoteSnapPart{pkg: remote.Snap{Name: "gone", Version: "1.0", Status: remote.StatusUnpublished}}
+	defer mockListUpdates(append(available, unpublished))()
+
+	recordOperation(historyInstall, "old.canonical", "1.0", nil, nil)
+	since := time.Now()
+	recordOperation(historyInstall, "foo.bar", "1.0", nil, nil)
+	recordOperation(historyUpdate, "baz.bar", "2.0", nil, nil)
+	recordOperation(historyUpdate, "fail.bar", "3.0", errors.New("boom"), nil)
+
+	feed, err := ChangesSince(since)
+	c.Assert(err, IsNil)
+	c.Check(feed.Since, Equals, since)
+	c.Assert(feed.Installed, HasLen, 1)
+	c.Check(feed.Installed[0].Snap, Equals, "foo.bar")
+	c.Assert(feed.Updated, HasLen, 1)
+	c.Check(feed.Updated[0].Version, Equals, "2.0")
+	c.Assert(feed.Failed, HasLen, 1)
+	c.Check(feed.Failed[0].Snap, Equals, "fail.bar")
+	c.Check(feed.Failed[0].Error, Equals, "boom")
+	c.Check(feed.Available, DeepEquals, available)
+}
+
+func (s *SnapTestSuite) TestRecordOperationTrimsHistory(c *C) {
+	defer func(n int) { historyMaxEntries = n }(historyMaxEntries)
+	historyMaxEntries = 2
+
+	recordOperation(historyInstall, "a.b", "1", nil, nil)
+	recordOperation(historyInstall, "c.d", "1", nil, nil)
+	recordOperation(historyInstall, "e.f", "1", nil, nil)
+
+	history, err := readHistory()
+	c.Assert(err, IsNil)
+	c.Assert(history, HasLen, 2)
+	c.Check(history[0].Snap, Equals, "c.d")
+	c.Check(history[1].Snap, Equals, "e.f")
+}
@@ -0,0 +1,91 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+)
+
+// holdFile returns the file that marks the snap with the given
+// qualified name as held, next to its manifests
+func holdFile(qn string) string {
+	return filepath.Join(dirs.SnapMetaDir, qn+".hold")
+}
+
+// SetHold holds the installed snap with the given name at its current
+// version, Updates leaves it out until the hold is cleared (hold false)
+func SetHold(name string, hold bool) error {
+	part := ActiveSnapByName(name)
+	if part == nil {
+		return ErrPackageNotFound
+	}
+	fn := holdFile(QualifiedName(part))
+
+	if !hold {
+		if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(dirs.SnapMetaDir, 0755); err != nil {
+		return err
+	}
+
+	return helpers.AtomicWriteFile(fn, []byte(part.Version()+"\n"), 0644, 0)
+}
+
+// removeHold removes the hold of the snap with the given qualified
+// name, that is gone with the last version of the snap (a new install
+// of it is not held)
+func removeHold(qn string) {
+	fn := holdFile(qn)
+	if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+		logger.Noticef("Failed to remove %q: %v", fn, err)
+	}
+}
+
+// IsHeld returns true if the snap is held (see SetHold)
+func IsHeld(part Part) bool {
+	return helpers.FileExists(holdFile(QualifiedName(part)))
+}
+
+// HeldSnaps returns the qualified names of the held snaps, sorted
+func HeldSnaps() ([]string, error) {
+	matches, err := filepath.Glob(holdFile("*"))
+	if err != nil {
+		return nil, err
+	}
+
+	held := make([]string, len(matches))
+	for i, fn := range matches {
+		held[i] = strings.TrimSuffix(filepath.Base(fn), ".hold")
+	}
+	sort.Strings(held)
+
+	return held, nil
+}
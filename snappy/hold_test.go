@@ -0,0 +1,77 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+func (s *SnapTestSuite) TestSetHold(c *C) {
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+	m := &MetaRepository{all: []Repository{NewSnapDirRepository(makeSnapDir(c))}}
+
+	c.Assert(SetHold("hello-app", true), IsNil)
+	held, err := HeldSnaps()
+	c.Assert(err, IsNil)
+	c.Check(held, DeepEquals, []string{helloAppComposedName})
+
+	updates, err := m.Updates()
+	c.Assert(err, IsNil)
+	c.Check(updates, HasLen, 0)
+
+	// holding it again is fine
+	c.Assert(SetHold("hello-app", true), IsNil)
+
+	c.Assert(SetHold("hello-app", false), IsNil)
+	held, err = HeldSnaps()
+	c.Assert(err, IsNil)
+	c.Check(held, HasLen, 0)
+
+	updates, err = m.Updates()
+	c.Assert(err, IsNil)
+	c.Assert(updates, HasLen, 1)
+	c.Check(updates[0].Version(), Equals, "1.11")
+
+	// and clearing it again too
+	c.Check(SetHold("hello-app", false), IsNil)
+}
+
+func (s *SnapTestSuite) TestSetHoldNotInstalled(c *C) {
+	c.Check(SetHold("no-such-snap", true), Equals, ErrPackageNotFound)
+}
+
+func (s *SnapTestSuite) TestHoldRemovedWithLastVersion(c *C) {
+	_, err := makeInstalledMockSnap(s.tempdir, "name: hello-app\nversion: 1.09\nvendor: foo")
+	c.Assert(err, IsNil)
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+	c.Assert(SetHold("hello-app", true), IsNil)
+
+	c.Assert(Remove("hello-app=1.09", DoRemovePermanently, s.meter()), IsNil)
+	c.Check(helpers.FileExists(holdFile(helloAppComposedName)), Equals, true)
+
+	c.Assert(Remove("hello-app=1.10", DoRemovePermanently, s.meter()), IsNil)
+	c.Check(helpers.FileExists(holdFile(helloAppComposedName)), Equals, false)
+}
@@ -0,0 +1,112 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+// Hooks are the executables (relative to the snap) that snappy runs,
+// confined, at points of the life cycle of the snap
+type Hooks struct {
+	// Install runs once the snap is unpacked and its data is set
+	// up, before it gets active; the install fails if it does
+	Install string `yaml:"install,omitempty"`
+	// Upgrade runs instead of Install when the snap replaces an
+	// older version, once the data is copied over, with the
+	// versions in $OLD_VERSION and $NEW_VERSION; the upgrade fails
+	// (and the old version is back) if it does
+	Upgrade string `yaml:"upgrade,omitempty"`
+	// Remove runs before the active snap gets removed; the removal
+	// fails if it does
+	Remove string `yaml:"remove,omitempty"`
+}
+
+// hooks returns the hooks the package.yaml declares, by name
+func (m *packageYaml) hooks() map[string]string {
+	hooks := make(map[string]string)
+	if m.Hooks.Install != "" {
+		hooks["install"] = m.Hooks.Install
+	}
+	if m.Hooks.Upgrade != "" {
+		hooks["upgrade"] = m.Hooks.Upgrade
+	}
+	if m.Hooks.Remove != "" {
+		hooks["remove"] = m.Hooks.Remove
+	}
+
+	return hooks
+}
+
+// hookIntegration returns the name of the integration (and so of the
+// apparmor profile) of the given hook
+func hookIntegration(hook string) string {
+	return "snappy-" + hook
+}
+
+// verifyHookYaml checks the executable of the hook is in the snap
+func verifyHookYaml(hook, exec string) error {
+	if filepath.IsAbs(exec) || strings.HasPrefix(filepath.Clean(exec), "..") {
+		return fmt.Errorf("%s hook %q is not in the snap", hook, exec)
+	}
+	if !servicesBinariesStringsWhitelist.MatchString(exec) {
+		return fmt.Errorf("%s hook %q contains illegal characters", hook, exec)
+	}
+
+	return nil
+}
+
+// runHookCmd runs the hook under the given apparmor profile
+func runHookCmd(hook, appArmorProfile string, env []string) error {
+	cmd := exec.Command(aaExec, "-p", appArmorProfile, hook)
+	cmd.Env = env
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		if exitCode, e := helpers.ExitCode(err); e == nil {
+			return &ErrHookFailed{
+				Cmd:      hook,
+				Output:   string(output),
+				ExitCode: exitCode,
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// runHook runs the hook of the snap with the given name, if it has
+// one, through the given Backend, with env added to the environment of
+// the snap
+func (s *SnapPart) runHook(name string, backend Backend, env ...string) error {
+	exec, ok := s.m.hooks()[name]
+	if !ok {
+		return nil
+	}
+
+	appArmorProfile := fmt.Sprintf("%s_%s_%s", QualifiedName(s), hookIntegration(name), s.Version())
+
+	return backend.RunHook(filepath.Join(s.basedir, exec), appArmorProfile, append(makeSnapHookEnv(s), env...))
+}
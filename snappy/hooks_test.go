@@ -0,0 +1,191 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+const hookedSnapYaml = `name: hello-app
+version: 2.0
+vendor: Foo Bar <foo@example.com>
+hooks:
+ install: bin/setup
+ upgrade: bin/migrate
+ remove: bin/teardown
+`
+
+func (s *SnapTestSuite) TestParseHooks(c *C) {
+	m, err := parsePackageYamlData([]byte(hookedSnapYaml), false)
+	c.Assert(err, IsNil)
+	c.Check(m.hooks(), DeepEquals, map[string]string{"install": "bin/setup", "upgrade": "bin/migrate", "remove": "bin/teardown"})
+	c.Check(m.Integration["snappy-install"], DeepEquals, clickAppHook{"apparmor": "meta/snappy-install.apparmor"})
+	c.Check(m.Integration["snappy-remove"], DeepEquals, clickAppHook{"apparmor": "meta/snappy-remove.apparmor"})
+
+	m, err = parsePackageYamlData([]byte(`name: foo
+version: 1.0
+vendor: foo
+`), false)
+	c.Assert(err, IsNil)
+	c.Check(m.hooks(), HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestParseHooksNotInSnap(c *C) {
+	for _, exec := range []string{"/bin/sh", "../../bin/sh", "bin/$(reboot)"} {
+		_, err := parsePackageYamlData([]byte("name: foo\nversion: 1.0\nvendor: foo\nhooks:\n install: "+exec+"\n"), false)
+		c.Check(err, FitsTypeOf, &ErrInvalidYaml{}, Commentf(exec))
+	}
+}
+
+func (s *SnapTestSuite) TestActivateRunsPendingHook(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, hookedSnapYaml)
+	c.Assert(err, IsNil)
+	part, err := NewInstalledSnapPart(yamlFile, testOrigin)
+	c.Assert(err, IsNil)
+
+	var ran []string
+	var env []string
+	s.backend.runHook = func(hook, appArmorProfile string, hookEnv []string) error {
+		ran = append(ran, hook, appArmorProfile)
+		env = hookEnv
+		return nil
+	}
+
+	// only installs run it
+	c.Assert(part.activate(false, s.meter()), IsNil)
+	c.Check(ran, HasLen, 0)
+	c.Assert(part.deactivate(false, s.meter()), IsNil)
+
+	part.pendingHook = "install"
+	c.Assert(part.activate(false, s.meter()), IsNil)
+	c.Check(ran, DeepEquals, []string{filepath.Join(part.basedir, "bin", "setup"), "hello-app." + testOrigin + "_snappy-install_2.0"})
+	envMap := helpers.MakeMapFromEnvList(env)
+	c.Check(envMap["SNAP_APP_PATH"], Equals, part.basedir)
+	c.Check(envMap["SNAP_VERSION"], Equals, "2.0")
+}
+
+func (s *SnapTestSuite) TestActivateFailedHookRollsBack(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, hookedSnapYaml)
+	c.Assert(err, IsNil)
+	part, err := NewInstalledSnapPart(yamlFile, testOrigin)
+	c.Assert(err, IsNil)
+
+	s.backend.runHook = func(hook, appArmorProfile string, env []string) error {
+		return &ErrHookFailed{Cmd: hook, ExitCode: 1}
+	}
+
+	part.pendingHook = "install"
+	c.Assert(part.activate(false, s.meter()), FitsTypeOf, &ErrHookFailed{})
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, "hello-app."+testOrigin, "current")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestReactivateAfterPartialDeactivation(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	part, err := NewInstalledSnapPart(yamlFile, testOrigin)
+	c.Assert(err, IsNil)
+	c.Assert(part.activate(true, s.meter()), IsNil)
+
+	// the deactivation removed the binaries, and failed before it
+	// got to the current symlink
+	wrapper := filepath.Join(dirs.SnapBinariesDir, "hello-app.hello")
+	c.Assert(os.Remove(wrapper), IsNil)
+
+	c.Assert(part.reactivate(true, s.meter()), IsNil)
+	c.Check(helpers.FileExists(wrapper), Equals, true)
+	current, err := filepath.EvalSymlinks(filepath.Join(part.basedir, "..", "current"))
+	c.Assert(err, IsNil)
+	c.Check(current, Equals, part.basedir)
+}
+
+func (s *SnapTestSuite) TestActivateFailedUpgradeHookRestoresOldVersion(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	oldPart, err := NewInstalledSnapPart(yamlFile, testOrigin)
+	c.Assert(err, IsNil)
+	c.Assert(oldPart.activate(true, s.meter()), IsNil)
+
+	yamlFile, err = makeInstalledMockSnap(s.tempdir, hookedSnapYaml)
+	c.Assert(err, IsNil)
+	part, err := NewInstalledSnapPart(yamlFile, testOrigin)
+	c.Assert(err, IsNil)
+
+	var ran string
+	var env map[string]string
+	s.backend.runHook = func(hook, appArmorProfile string, hookEnv []string) error {
+		ran = hook
+		env = helpers.MakeMapFromEnvList(hookEnv)
+		return &ErrHookFailed{Cmd: hook, ExitCode: 1}
+	}
+
+	part.pendingHook = "upgrade"
+	part.pendingHookEnv = []string{"OLD_VERSION=1.10", "NEW_VERSION=2.0"}
+	c.Assert(part.activate(false, s.meter()), FitsTypeOf, &ErrHookFailed{})
+	c.Check(ran, Equals, filepath.Join(part.basedir, "bin", "migrate"))
+	c.Check(env["OLD_VERSION"], Equals, "1.10")
+	c.Check(env["NEW_VERSION"], Equals, "2.0")
+	c.Check(env["SNAP_VERSION"], Equals, "2.0")
+
+	current, err := filepath.EvalSymlinks(filepath.Join(dirs.SnapAppsDir, "hello-app."+testOrigin, "current"))
+	c.Assert(err, IsNil)
+	c.Check(current, Equals, oldPart.basedir)
+}
+
+func (s *SnapTestSuite) TestUninstallRunsRemoveHook(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, hookedSnapYaml)
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+	part, err := NewInstalledSnapPart(yamlFile, testOrigin)
+	c.Assert(err, IsNil)
+
+	var ran []string
+	s.backend.runHook = func(hook, appArmorProfile string, env []string) error {
+		// the snap is still all there
+		c.Check(helpers.FileExists(filepath.Join(part.basedir, "meta", "package.yaml")), Equals, true)
+		ran = append(ran, hook, appArmorProfile)
+		return nil
+	}
+
+	c.Assert(part.uninstall(s.meter(), DoRemovePermanently), IsNil)
+	c.Check(ran, DeepEquals, []string{filepath.Join(part.basedir, "bin", "teardown"), "hello-app." + testOrigin + "_snappy-remove_2.0"})
+	c.Check(helpers.FileExists(part.basedir), Equals, false)
+}
+
+func (s *SnapTestSuite) TestUninstallFailedRemoveHook(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, hookedSnapYaml)
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+	part, err := NewInstalledSnapPart(yamlFile, testOrigin)
+	c.Assert(err, IsNil)
+
+	s.backend.runHook = func(hook, appArmorProfile string, env []string) error {
+		return &ErrHookFailed{Cmd: hook, ExitCode: 1}
+	}
+
+	c.Assert(part.uninstall(s.meter(), DoRemovePermanently), FitsTypeOf, &ErrHookFailed{})
+	c.Check(helpers.FileExists(part.basedir), Equals, true)
+	c.Check(ActiveSnapByName("hello-app"), NotNil)
+}
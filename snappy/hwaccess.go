@@ -25,21 +25,20 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
 	"github.com/ubuntu-core/snappy/dirs"
 	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/progress"
 )
 
 const udevDataGlob = "/run/udev/data/*"
 
-var aaClickHookCmd = "aa-clickhook"
-
 type appArmorAdditionalJSON struct {
-	WritePath []string `json:"write_path,omitempty"`
-	ReadPath  []string `json:"read_path,omitempty"`
+	WritePath    []string `json:"write_path,omitempty"`
+	ReadPath     []string `json:"read_path,omitempty"`
+	PolicyGroups []string `json:"policy_groups,omitempty"`
 }
 
 // return the json filename to add to the security json
@@ -98,8 +97,14 @@ func writeHWAccessJSONFile(snapname string, appArmorAdditional appArmorAdditiona
 	return nil
 }
 
-func regenerateAppArmorRulesImpl() error {
-	if output, err := exec.Command(aaClickHookCmd, "-f").CombinedOutput(); err != nil {
+// regenerateAppArmorRules makes aa-clickhook generate the AppArmor
+// profiles of the snaps again
+func regenerateAppArmorRules(backend Backend) error {
+	if backend.MACSystem() != "apparmor" {
+		return nil
+	}
+
+	if output, err := backend.AaClickHook("-f"); err != nil {
 		if exitCode, err := helpers.ExitCode(err); err == nil {
 			return &ErrApparmorGenerate{
 				ExitCode: exitCode,
@@ -109,7 +114,9 @@ func regenerateAppArmorRulesImpl() error {
 		return err
 	}
 
-	return nil
+	// the profiles of the snaps in developer mode got generated in
+	// enforce mode
+	return complainUnenforcedProfiles(backend)
 }
 
 func udevRulesPathForPart(partid string) string {
@@ -137,7 +144,7 @@ func addUdevRuleForSnap(snapname, newRule string) error {
 	return nil
 }
 
-func writeUdevRuleForDeviceCgroup(snapname, device string) error {
+func writeUdevRuleForDeviceCgroup(backend Backend, snapname, device string) error {
 	os.MkdirAll(dirs.SnapUdevRulesDir, 0755)
 
 	// the device cgroup/launcher etc support only the apps level,
@@ -156,14 +163,12 @@ KERNEL=="%v", TAG:="snappy-assign", ENV{SNAPPY_APP}:="%s"
 		return err
 	}
 
-	return activateOemHardwareUdevRules()
+	return activateOemHardwareUdevRules(backend)
 }
 
-var regenerateAppArmorRules = regenerateAppArmorRulesImpl
-
 // AddHWAccess allows the given snap package to access the given hardware
 // device
-func AddHWAccess(snapname, device string) error {
+func AddHWAccess(snapname, device string, meter progress.Meter) error {
 	if !validDevice(device) {
 		return ErrInvalidHWDevice
 	}
@@ -199,13 +204,15 @@ func AddHWAccess(snapname, device string) error {
 		return err
 	}
 
+	backend := backendOf(meter)
+
 	// add udev rule for device cgroup
-	if err := writeUdevRuleForDeviceCgroup(snapname, device); err != nil {
+	if err := writeUdevRuleForDeviceCgroup(backend, snapname, device); err != nil {
 		return err
 	}
 
 	// re-generate apparmor fules
-	return regenerateAppArmorRules()
+	return regenerateAppArmorRules(backend)
 }
 
 // ListHWAccess returns a list of hardware-device strings that the snap
@@ -264,7 +271,7 @@ func removeUdevRuleForSnap(snapname, device string) error {
 
 // RemoveHWAccess allows the given snap package to access the given hardware
 // device
-func RemoveHWAccess(snapname, device string) error {
+func RemoveHWAccess(snapname, device string, meter progress.Meter) error {
 	if !validDevice(device) {
 		return ErrInvalidHWDevice
 	}
@@ -296,16 +303,17 @@ func RemoveHWAccess(snapname, device string) error {
 		return err
 	}
 
-	if err := activateOemHardwareUdevRules(); err != nil {
+	backend := backendOf(meter)
+	if err := activateOemHardwareUdevRules(backend); err != nil {
 		return err
 	}
 
 	// re-generate apparmor rules
-	return regenerateAppArmorRules()
+	return regenerateAppArmorRules(backend)
 }
 
 // RemoveAllHWAccess removes all hw access from the given snap.
-func RemoveAllHWAccess(snapname string) error {
+func RemoveAllHWAccess(snapname string, meter progress.Meter) error {
 	for _, fn := range []string{
 		udevRulesPathForPart(snapname),
 		getHWAccessJSONFile(snapname),
@@ -315,5 +323,5 @@ func RemoveAllHWAccess(snapname string) error {
 		}
 	}
 
-	return regenerateAppArmorRules()
+	return regenerateAppArmorRules(backendOf(meter))
 }
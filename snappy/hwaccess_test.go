@@ -21,6 +21,7 @@ package snappy
 
 import (
 	"io/ioutil"
+	"os/exec"
 	"path/filepath"
 
 	. "gopkg.in/check.v1"
@@ -29,20 +30,20 @@ import (
 	"github.com/ubuntu-core/snappy/helpers"
 )
 
-func mockRegenerateAppArmorRules() *bool {
+func (s *SnapTestSuite) mockRegenerateAppArmorRules() *bool {
 	regenerateAppArmorRulesWasCalled := false
-	regenerateAppArmorRules = func() error {
+	s.backend.aaClickHook = func(args ...string) ([]byte, error) {
 		regenerateAppArmorRulesWasCalled = true
-		return nil
+		return nil, nil
 	}
 	return &regenerateAppArmorRulesWasCalled
 }
 
 func (s *SnapTestSuite) TestAddHWAccessSimple(c *C) {
 	makeInstalledMockSnap(s.tempdir, "")
-	regenerateAppArmorRulesWasCalled := mockRegenerateAppArmorRules()
+	regenerateAppArmorRulesWasCalled := s.mockRegenerateAppArmorRules()
 
-	err := AddHWAccess("hello-app", "/dev/ttyUSB0")
+	err := AddHWAccess("hello-app", "/dev/ttyUSB0", s.meter())
 	c.Assert(err, IsNil)
 	content, err := ioutil.ReadFile(filepath.Join(dirs.SnapAppArmorDir, "hello-app.json.additional"))
 	c.Assert(err, IsNil)
@@ -60,21 +61,20 @@ func (s *SnapTestSuite) TestAddHWAccessSimple(c *C) {
 }
 
 func (s *SnapTestSuite) TestAddHWAccessInvalidDevice(c *C) {
-	regenerateAppArmorRulesWasCalled := mockRegenerateAppArmorRules()
+	regenerateAppArmorRulesWasCalled := s.mockRegenerateAppArmorRules()
 	makeInstalledMockSnap(s.tempdir, "")
 
-	err := AddHWAccess("hello-app", "ttyUSB0")
+	err := AddHWAccess("hello-app", "ttyUSB0", s.meter())
 	c.Assert(err, Equals, ErrInvalidHWDevice)
 	c.Assert(*regenerateAppArmorRulesWasCalled, Equals, false)
 }
 
 func (s *SnapTestSuite) TestAddHWAccessMultiplePaths(c *C) {
-	aaClickHookCmd = "true"
 	makeInstalledMockSnap(s.tempdir, "")
 
-	err := AddHWAccess("hello-app", "/dev/ttyUSB0")
+	err := AddHWAccess("hello-app", "/dev/ttyUSB0", s.meter())
 	c.Assert(err, IsNil)
-	err = AddHWAccess("hello-app", "/sys/devices/gpio1")
+	err = AddHWAccess("hello-app", "/sys/devices/gpio1", s.meter())
 	c.Assert(err, IsNil)
 
 	content, err := ioutil.ReadFile(filepath.Join(dirs.SnapAppArmorDir, "hello-app.json.additional"))
@@ -93,12 +93,11 @@ func (s *SnapTestSuite) TestAddHWAccessMultiplePaths(c *C) {
 }
 
 func (s *SnapTestSuite) TestAddHWAccessAddSameDeviceTwice(c *C) {
-	aaClickHookCmd = "true"
 	makeInstalledMockSnap(s.tempdir, "")
 
-	err := AddHWAccess("hello-app", "/dev/ttyUSB0")
+	err := AddHWAccess("hello-app", "/dev/ttyUSB0", s.meter())
 	c.Assert(err, IsNil)
-	err = AddHWAccess("hello-app", "/dev/ttyUSB0")
+	err = AddHWAccess("hello-app", "/dev/ttyUSB0", s.meter())
 	c.Assert(err, Equals, ErrHWAccessAlreadyAdded)
 
 	writePaths, err := ListHWAccess("hello-app")
@@ -107,18 +106,20 @@ func (s *SnapTestSuite) TestAddHWAccessAddSameDeviceTwice(c *C) {
 }
 
 func (s *SnapTestSuite) TestAddHWAccessUnknownPackage(c *C) {
-	regenerateAppArmorRulesWasCalled := mockRegenerateAppArmorRules()
+	regenerateAppArmorRulesWasCalled := s.mockRegenerateAppArmorRules()
 
-	err := AddHWAccess("xxx", "/dev/ttyUSB0")
+	err := AddHWAccess("xxx", "/dev/ttyUSB0", s.meter())
 	c.Assert(err, Equals, ErrPackageNotFound)
 	c.Assert(*regenerateAppArmorRulesWasCalled, Equals, false)
 }
 
 func (s *SnapTestSuite) TestAddHWAccessHookFails(c *C) {
-	aaClickHookCmd = "false"
+	s.backend.aaClickHook = func(args ...string) ([]byte, error) {
+		return exec.Command("false").CombinedOutput()
+	}
 	makeInstalledMockSnap(s.tempdir, "")
 
-	err := AddHWAccess("hello-app", "/dev/ttyUSB0")
+	err := AddHWAccess("hello-app", "/dev/ttyUSB0", s.meter())
 	c.Assert(err.Error(), Equals, "apparmor generate fails with 1: ''")
 }
 
@@ -132,16 +133,16 @@ func (s *SnapTestSuite) TestListHWAccessNoAdditionalAccess(c *C) {
 
 func (s *SnapTestSuite) TestListHWAccess(c *C) {
 	makeInstalledMockSnap(s.tempdir, "")
-	err := AddHWAccess("hello-app", "/dev/ttyUSB0")
+	err := AddHWAccess("hello-app", "/dev/ttyUSB0", s.meter())
 	c.Assert(err, IsNil)
 
-	err = AddHWAccess("hello-app", "/sys/devices/gpio1")
+	err = AddHWAccess("hello-app", "/sys/devices/gpio1", s.meter())
 	c.Assert(err, IsNil)
 
-	err = AddHWAccess("hello-app", "/sys/class/gpio/export")
+	err = AddHWAccess("hello-app", "/sys/class/gpio/export", s.meter())
 	c.Assert(err, IsNil)
 
-	err = AddHWAccess("hello-app", "/sys/class/gpio/unexport")
+	err = AddHWAccess("hello-app", "/sys/class/gpio/unexport", s.meter())
 	c.Assert(err, IsNil)
 
 	writePaths, err := ListHWAccess("hello-app")
@@ -149,15 +150,14 @@ func (s *SnapTestSuite) TestListHWAccess(c *C) {
 }
 
 func (s *SnapTestSuite) TestRemoveHWAccessInvalidDevice(c *C) {
-	err := RemoveHWAccess("hello-app", "meep")
+	err := RemoveHWAccess("hello-app", "meep", s.meter())
 	c.Assert(err, Equals, ErrInvalidHWDevice)
 }
 
 func (s *SnapTestSuite) TestRemoveHWAccess(c *C) {
-	aaClickHookCmd = "true"
 
 	makeInstalledMockSnap(s.tempdir, "")
-	err := AddHWAccess("hello-app", "/dev/ttyUSB0")
+	err := AddHWAccess("hello-app", "/dev/ttyUSB0", s.meter())
 
 	// check that the udev rules file got created
 	udevRulesFilename := "70-snappy_hwassign_hello-app.rules"
@@ -167,8 +167,8 @@ func (s *SnapTestSuite) TestRemoveHWAccess(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(writePaths, DeepEquals, []string{"/dev/ttyUSB0"})
 
-	regenerateAppArmorRulesWasCalled := mockRegenerateAppArmorRules()
-	err = RemoveHWAccess("hello-app", "/dev/ttyUSB0")
+	regenerateAppArmorRulesWasCalled := s.mockRegenerateAppArmorRules()
+	err = RemoveHWAccess("hello-app", "/dev/ttyUSB0", s.meter())
 	c.Assert(err, IsNil)
 	c.Assert(*regenerateAppArmorRulesWasCalled, Equals, true)
 
@@ -186,12 +186,11 @@ func (s *SnapTestSuite) TestRemoveHWAccess(c *C) {
 }
 
 func (s *SnapTestSuite) TestRemoveHWAccessMultipleDevices(c *C) {
-	aaClickHookCmd = "true"
 	makeInstalledMockSnap(s.tempdir, "")
 
 	// setup
-	err := AddHWAccess("hello-app", "/dev/bar")
-	AddHWAccess("hello-app", "/dev/bar*")
+	err := AddHWAccess("hello-app", "/dev/bar", s.meter())
+	AddHWAccess("hello-app", "/dev/bar*", s.meter())
 	// ensure its there
 	writePaths, _ := ListHWAccess("hello-app")
 	c.Assert(writePaths, DeepEquals, []string{"/dev/bar", "/dev/bar*"})
@@ -219,7 +218,7 @@ KERNEL=="bar", TAG:="snappy-assign", ENV{SNAPPY_APP}:="hello-app"
 KERNEL=="bar*", TAG:="snappy-assign", ENV{SNAPPY_APP}:="hello-app"
 `)
 	// remove
-	err = RemoveHWAccess("hello-app", "/dev/bar")
+	err = RemoveHWAccess("hello-app", "/dev/bar", s.meter())
 	c.Assert(err, IsNil)
 
 	// ensure the right thing was removed
@@ -245,29 +244,30 @@ KERNEL=="bar*", TAG:="snappy-assign", ENV{SNAPPY_APP}:="hello-app"
 `)
 }
 
-func makeRunUdevAdmMock(a *[][]string) func(args ...string) error {
-	return func(args ...string) error {
+func makeRunUdevAdmMock(a *[][]string) func(args ...string) ([]byte, error) {
+	return func(args ...string) ([]byte, error) {
 		*a = append(*a, args)
-		return nil
+		return nil, nil
 	}
 }
 
 func verifyUdevAdmActivateRules(c *C, runUdevAdmCalls [][]string) {
-	c.Assert(runUdevAdmCalls, HasLen, 2)
-	c.Assert(runUdevAdmCalls[0], DeepEquals, []string{"udevadm", "control", "--reload-rules"})
-	c.Assert(runUdevAdmCalls[1], DeepEquals, []string{"udevadm", "trigger"})
+	c.Assert(runUdevAdmCalls, HasLen, 3)
+	c.Assert(runUdevAdmCalls[0], DeepEquals, []string{"control", "--reload-rules"})
+	c.Assert(runUdevAdmCalls[1], DeepEquals, []string{"trigger"})
+	c.Assert(runUdevAdmCalls[2], DeepEquals, []string{"settle", "--timeout=30"})
 }
 
 func (s *SnapTestSuite) TestRemoveHWAccessFail(c *C) {
 	var runUdevAdmCalls [][]string
-	runUdevAdm = makeRunUdevAdmMock(&runUdevAdmCalls)
+	s.backend.udevAdm = makeRunUdevAdmMock(&runUdevAdmCalls)
 
 	makeInstalledMockSnap(s.tempdir, "")
-	err := AddHWAccess("hello-app", "/dev/ttyUSB0")
+	err := AddHWAccess("hello-app", "/dev/ttyUSB0", s.meter())
 	c.Assert(err, IsNil)
 
-	regenerateAppArmorRulesWasCalled := mockRegenerateAppArmorRules()
-	err = RemoveHWAccess("hello-app", "/dev/something")
+	regenerateAppArmorRulesWasCalled := s.mockRegenerateAppArmorRules()
+	err = RemoveHWAccess("hello-app", "/dev/something", s.meter())
 	c.Assert(err, Equals, ErrHWAccessRemoveNotFound)
 	c.Assert(*regenerateAppArmorRulesWasCalled, Equals, false)
 	verifyUdevAdmActivateRules(c, runUdevAdmCalls)
@@ -275,10 +275,10 @@ func (s *SnapTestSuite) TestRemoveHWAccessFail(c *C) {
 
 func (s *SnapTestSuite) TestWriteUdevRulesForDeviceCgroup(c *C) {
 	var runUdevAdmCalls [][]string
-	runUdevAdm = makeRunUdevAdmMock(&runUdevAdmCalls)
+	s.backend.udevAdm = makeRunUdevAdmMock(&runUdevAdmCalls)
 
 	snapapp := "foo-app_meep_1.0"
-	err := writeUdevRuleForDeviceCgroup(snapapp, "/dev/ttyS0")
+	err := writeUdevRuleForDeviceCgroup(s.backend, snapapp, "/dev/ttyS0")
 	c.Assert(err, IsNil)
 
 	got, err := ioutil.ReadFile(filepath.Join(dirs.SnapUdevRulesDir, "70-snappy_hwassign_foo-app.rules"))
@@ -293,12 +293,12 @@ KERNEL=="ttyS0", TAG:="snappy-assign", ENV{SNAPPY_APP}:="foo-app"
 func (s *SnapTestSuite) TestRemoveAllHWAccess(c *C) {
 	makeInstalledMockSnap(s.tempdir, "")
 
-	err := AddHWAccess("hello-app", "/dev/ttyUSB0")
+	err := AddHWAccess("hello-app", "/dev/ttyUSB0", s.meter())
 	c.Assert(err, IsNil)
 
-	regenerateAppArmorRulesWasCalled := mockRegenerateAppArmorRules()
+	regenerateAppArmorRulesWasCalled := s.mockRegenerateAppArmorRules()
 	c.Check(*regenerateAppArmorRulesWasCalled, Equals, false)
-	c.Check(RemoveAllHWAccess("hello-app"), IsNil)
+	c.Check(RemoveAllHWAccess("hello-app", s.meter()), IsNil)
 
 	c.Check(helpers.FileExists(filepath.Join(dirs.SnapUdevRulesDir, "70-snappy_hwassign_foo-app.rules")), Equals, false)
 	c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppArmorDir, "hello-app.json.additional")), Equals, false)
@@ -312,9 +312,11 @@ exit 1`
 	mockFailHookFile := filepath.Join(c.MkDir(), "failing-aa-hook")
 	err := ioutil.WriteFile(mockFailHookFile, []byte(script), 0755)
 	c.Assert(err, IsNil)
-	aaClickHookCmd = mockFailHookFile
+	s.backend.aaClickHook = func(args ...string) ([]byte, error) {
+		return exec.Command(mockFailHookFile, args...).CombinedOutput()
+	}
 
-	err = regenerateAppArmorRulesImpl()
+	err = regenerateAppArmorRules(s.backend)
 	c.Assert(err, DeepEquals, &ErrApparmorGenerate{
 		ExitCode: 1,
 		Output:   []byte("meep\n"),
@@ -20,10 +20,14 @@
 package snappy
 
 import (
-	"fmt"
+	"context"
+	"net/url"
 	"os"
 	"sort"
 	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
 
 	"github.com/ubuntu-core/snappy/logger"
 	"github.com/ubuntu-core/snappy/partition"
@@ -44,27 +48,288 @@ const (
 	DoInstallGC
 	// AllowOEM allows the installation of OEM packages, this does not affect updates.
 	AllowOEM
+	// InhibitRestart will ensure the services of the dependents are not restarted
+	InhibitRestart
+	// LeaveInactive installs the snap but keeps the current version active
+	LeaveInactive
+	// DryRun only checks that the snap can be installed
+	DryRun
+	// CheckStoreName looks up the name of a sideloaded snap in the
+	// store, and refuses to install it if the store has it
+	CheckStoreName
+	// ForceSideload only warns if the store has the name of a
+	// sideloaded snap (with CheckStoreName)
+	ForceSideload
+	// DeveloperMode installs the snap with its security policy in
+	// complain mode (see SnapPart.DevMode)
+	DeveloperMode
+)
+
+// UnpublishedPolicy is what UpdateWithOptions does with the installed
+// snaps that got unpublished from the store
+type UnpublishedPolicy int
+
+const (
+	// UnpublishedWarn notifies that the snap will not get updates
+	UnpublishedWarn UnpublishedPolicy = iota
+	// UnpublishedKeep keeps the snap (only logging it)
+	UnpublishedKeep
+	// UnpublishedRemove removes the snap (to the trash)
+	UnpublishedRemove
 )
 
+// InstallOptions are the options for InstallWithOptions and
+// UpdateWithOptions
+type InstallOptions struct {
+	// Flags are additional InstallFlags
+	Flags InstallFlags
+	// Channel to install from or update to instead of the channel
+	// of the system
+	Channel string
+	// Version of the snap to install from the store instead of the
+	// latest one (if the store still has it)
+	Version string
+	// DevMode allows unauthenticated snaps and installs them in
+	// developer mode, with their security policy in complain mode
+	DevMode bool
+	// NoRestart does not restart the services of the dependents of
+	// the snap; they get the new security policy on their next restart
+	NoRestart bool
+	// DryRun only checks that the snap can be installed (note that
+	// snaps from the store still get downloaded for this)
+	DryRun bool
+	// LeaveInactive keeps the current version of the snap active
+	LeaveInactive bool
+	// CheckStoreName refuses to sideload a snap whose name the store
+	// has (as it would block the installs of it from the store)
+	CheckStoreName bool
+	// Force sideloads such a snap anyway, with a warning
+	Force bool
+	// GCKeep is the number of inactive versions to keep when garbage
+	// collecting after the install; 0 disables the garbage collection
+	// (unless DoInstallGC is in Flags, which keeps one version)
+	GCKeep int
+	// Timeout for the requests to the store (0 for none)
+	Timeout time.Duration
+	// Context stops the requests to the store and the downloads when
+	// done, and the updates that are not installed yet (nil for never)
+	Context context.Context
+	// Proxy for the requests to the store (instead of those of the
+	// environment, if any)
+	Proxy *url.URL
+	// Agreer is asked to agree to licenses (defaults to Meter)
+	Agreer agreer
+	// Meter to report progress to (defaults to no progress)
+	Meter progress.Meter
+	// Unpublished is what to do with the snaps that got unpublished
+	// from the store (warn by default)
+	Unpublished UnpublishedPolicy
+	// Debug traces the commands run, the requests sent to the store
+	// and the files changed; see OperationTrace
+	Debug bool
+	// DownloadWorkers is how many of the updates get downloaded at
+	// once, before installing them; 0 or 1 downloads each one as it
+	// gets installed
+	DownloadWorkers int
+	// SnapDir is a directory of snaps (see SnapDirRepository) to
+	// install and update from instead of the store, for the devices
+	// that are offline
+	SnapDir string
+	// Currency to buy the snap in (e.g. USD), if it needs buying;
+	// empty fails the install of such a snap with ErrPurchaseNeeded
+	Currency string
+	// MaxPrice is the most the snap is bought for (in Currency)
+	// without asking; 0 asks the Agreer to agree to its price
+	MaxPrice float64
+	// Sha512 is the hash a snap installed from an http or https URL
+	// is checked against (if set); such a snap is sideloaded
+	Sha512 string
+
+	// trace of the operation (nil unless Debug is set)
+	trace *Trace
+}
+
+func (opts *InstallOptions) flags() InstallFlags {
+	flags := opts.Flags
+	if opts.DevMode {
+		flags |= AllowUnauthenticated | DeveloperMode
+	}
+	if opts.NoRestart {
+		flags |= InhibitRestart
+	}
+	if opts.DryRun {
+		flags |= DryRun
+	}
+	if opts.LeaveInactive {
+		flags |= LeaveInactive
+	}
+	if opts.CheckStoreName {
+		flags |= CheckStoreName
+	}
+	if opts.Force {
+		flags |= ForceSideload
+	}
+
+	return flags
+}
+
+func (opts *InstallOptions) gcKeep() int {
+	if opts.GCKeep == 0 && (opts.Flags&DoInstallGC) != 0 {
+		return 1
+	}
+
+	return opts.GCKeep
+}
+
+// meterWithAgreer is a progress.Meter that asks another agreer
+type meterWithAgreer struct {
+	progress.Meter
+	agreer agreer
+}
+
+func (m *meterWithAgreer) Agreed(intro, license string) bool {
+	return m.agreer.Agreed(intro, license)
+}
+
+func (m *meterWithAgreer) Transferred(t *progress.Transfer) {
+	progress.NotifyTransfer(m.Meter, t)
+}
+
+func (m *meterWithAgreer) NotifyMessage(msg *progress.Message) {
+	progress.NotifyMessage(m.Meter, msg)
+}
+
+func (m *meterWithAgreer) Backend() Backend {
+	return backendOf(m.Meter)
+}
+
+func (opts *InstallOptions) meter() progress.Meter {
+	meter := opts.Meter
+	if meter == nil {
+		meter = &progress.NullProgress{}
+	}
+	if opts.Agreer != nil {
+		meter = &meterWithAgreer{Meter: meter, agreer: opts.Agreer}
+	}
+	if opts.trace != nil {
+		meter = WithBackend(meter, &tracingBackend{Backend: backendOf(meter), trace: opts.trace})
+	}
+
+	return meter
+}
+
+// startTrace starts the trace of the operation if Debug is set
+func (opts *InstallOptions) startTrace() *Trace {
+	if opts.Debug {
+		opts.trace = newTrace()
+	}
+
+	return opts.trace
+}
+
+// configureStore sets the channel and timeout of the store
+// repositories of the given MetaRepository, or replaces them with the
+// SnapDir repository
+func (opts *InstallOptions) configureStore(m *MetaRepository) *MetaRepository {
+	for i, repo := range m.all {
+		if store, ok := repo.(*SnapUbuntuStoreRepository); ok {
+			if opts.SnapDir != "" {
+				m.all[i] = NewSnapDirRepository(opts.SnapDir)
+				continue
+			}
+			store.SetChannel(opts.Channel)
+			store.timeout = opts.Timeout
+			store.SetProxy(opts.Proxy)
+			store.SetContext(opts.Context)
+			store.trace = opts.trace
+		}
+	}
+
+	return m
+}
+
+// handleUnpublished applies the UnpublishedPolicy to a snap that got
+// unpublished from the store
+func (opts *InstallOptions) handleUnpublished(part Part, meter progress.Meter) error {
+	switch opts.Unpublished {
+	case UnpublishedKeep:
+		logger.Noticef("Keeping %s, it was unpublished from the store", QualifiedName(part))
+	case UnpublishedRemove:
+		progress.NotifyMessage(meter, progress.NewMessage(progress.MsgRemovingUnpublished, QualifiedName(part)))
+		return Remove(part.Name(), DoRemoveGC, meter)
+	default:
+		progress.NotifyMessage(meter, progress.NewMessage(progress.MsgUnpublished, QualifiedName(part)))
+	}
+
+	return nil
+}
+
 // Update the installed snappy packages, it returns the updated Parts
 // if updates where available and an error and nil if any of the updates
 // fail to apply.
 func Update(flags InstallFlags, meter progress.Meter) ([]Part, error) {
-	updates, err := ListUpdates()
+	return UpdateWithOptions(InstallOptions{Flags: flags, Meter: meter})
+}
+
+// UpdateWithOptions is Update with InstallOptions
+func UpdateWithOptions(opts InstallOptions) ([]Part, error) {
+	trace := opts.startTrace()
+	flags := opts.flags()
+	meter := opts.meter()
+	defer trace.finish(meter)
+
+	// the (periodic) update is a good time to get rid of what was
+	// removed long enough ago
+	if flags&DryRun == 0 {
+		if err := PurgeExpiredTrash(); err != nil {
+			logger.Noticef("Failed to purge the trash: %v", err)
+		}
+	}
+
+	// what is recorded is the check, not how the update went
+	all, err := opts.configureStore(NewMetaRepository()).Updates()
+	recordUpdateCheck(all, err)
 	if err != nil {
 		return nil, err
 	}
 
+	var updates []Part
+	for _, part := range all {
+		if !IsUnpublished(part) {
+			updates = append(updates, part)
+			continue
+		}
+		if flags&DryRun != 0 {
+			continue
+		}
+		if err := opts.handleUnpublished(part, meter); err != nil {
+			return nil, err
+		}
+	}
+
+	// frameworks before the apps that need them
+	sort.Stable(byInstallOrder(updates))
+
+	if opts.DownloadWorkers > 1 {
+		downloadAll(updates, opts.DownloadWorkers, meter)
+		// the downloads that did not get installed
+		defer discardDownloads(updates)
+	}
+
 	for _, part := range updates {
-		meter.Notify(fmt.Sprintf("Updating %s (%s)", part.Name(), part.Version()))
+		if opts.Context != nil && opts.Context.Err() != nil {
+			return nil, opts.Context.Err()
+		}
 
-		if _, err := part.Install(meter, flags); err == ErrSideLoaded {
+		err := upgradePart(part, flags, opts.gcKeep(), meter)
+		if err == ErrSideLoaded {
 			logger.Noticef("Skipping sideloaded package: %s", part.Name())
 			continue
-		} else if err != nil {
-			return nil, err
 		}
-		if err := GarbageCollect(part.Name(), flags, meter); err != nil {
+		if err != nil || (flags&(DryRun|LeaveInactive)) == 0 {
+			recordOperation(historyUpdate, QualifiedName(part), part.Version(), err, trace)
+		}
+		if err != nil {
 			return nil, err
 		}
 	}
@@ -72,18 +337,144 @@ func Update(flags InstallFlags, meter progress.Meter) ([]Part, error) {
 	return updates, nil
 }
 
+// upgradePart installs the given update of a snap, and garbage
+// collects all but keep of its older versions
+func upgradePart(part Part, flags InstallFlags, keep int, meter progress.Meter) error {
+	progress.NotifyMessage(meter, progress.NewMessage(progress.MsgUpdating, part.Name(), part.Version()))
+
+	if _, err := part.Install(meter, flags); err != nil {
+		return err
+	}
+	if (flags & (DryRun | LeaveInactive)) != 0 {
+		return nil
+	}
+
+	return garbageCollect(part.Name(), keep, meter)
+}
+
+// UpgradeResult is the result of the upgrade of a single snap
+type UpgradeResult struct {
+	// Snap is the (qualified) name of the snap
+	Snap string
+	// From is the version before the upgrade ("" if none was active)
+	From string
+	// To is the version upgraded to
+	To       string
+	Duration time.Duration
+	// Err is the reason the upgrade failed (nil on success)
+	Err error
+}
+
+// UpgradeAll upgrades all snaps that have updates available. Unlike
+// Update it does not stop at the first snap that fails to upgrade; a
+// result is returned for each snap, and an error if any of them failed.
+func UpgradeAll(meter progress.Meter) ([]UpgradeResult, error) {
+	updates, err := ListUpdates()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]UpgradeResult, 0, len(updates))
+	var failed []string
+	for _, part := range updates {
+		if IsUnpublished(part) {
+			progress.NotifyMessage(meter, progress.NewMessage(progress.MsgUnpublished, QualifiedName(part)))
+			continue
+		}
+
+		result := UpgradeResult{
+			Snap: QualifiedName(part),
+			To:   part.Version(),
+		}
+		if current := ActiveSnapByName(part.Name()); current != nil {
+			result.From = current.Version()
+		}
+
+		start := time.Now()
+		err := upgradePart(part, DoInstallGC, 1, meter)
+		result.Duration = time.Since(start)
+
+		if err == ErrSideLoaded {
+			logger.Noticef("Skipping sideloaded package: %s", part.Name())
+			continue
+		}
+		if err != nil {
+			logger.Noticef("Failed to upgrade %s: %v", result.Snap, err)
+			result.Err = err
+			failed = append(failed, result.Snap)
+		}
+		recordOperation(historyUpdate, result.Snap, result.To, err, nil)
+
+		results = append(results, result)
+	}
+
+	if len(failed) > 0 {
+		err = ErrUpgradeFailed(failed)
+	}
+
+	return results, err
+}
+
 // Install the givens snap names provided via args. This can be local
 // files or snaps that are queried from the store
 func Install(name string, flags InstallFlags, meter progress.Meter) (string, error) {
-	name, err := doInstall(name, flags, meter)
+	return InstallWithOptions(name, InstallOptions{Flags: flags, Meter: meter})
+}
+
+// InstallWithOptions is Install with InstallOptions
+func InstallWithOptions(name string, opts InstallOptions) (string, error) {
+	trace := opts.startTrace()
+	flags := opts.flags()
+	meter := opts.meter()
+	defer trace.finish(meter)
+
+	// a snap from an URL is downloaded, and then installed as a
+	// local one
+	source := name
+	if u, ok := snapURL(name); ok {
+		snapFile, err := opts.downloadSnapURL(u, meter)
+		if err != nil {
+			err = &ErrInstallFailed{Snap: name, OrigErr: err}
+			recordOperation(historyInstall, name, "", err, trace)
+			return "", err
+		}
+		defer os.Remove(snapFile)
+		source = snapFile
+	}
+
+	mStore := opts.configureStore(NewMetaStoreRepository())
+	snapName, err := doInstall(source, opts.Version, flags, mStore, meter)
+	if e, ok := err.(*ErrInstallFailed); ok {
+		// the URL names the snap, not the download
+		e.Snap = name
+	}
+	if opts.Currency != "" && isPurchaseNeeded(err) {
+		// the store entitles the user to download it once bought
+		if err = buy(name, opts.Currency, opts.MaxPrice, mStore, meter); err != nil {
+			err = &ErrInstallFailed{Snap: name, OrigErr: err}
+		} else {
+			snapName, err = doInstall(name, opts.Version, flags, mStore, meter)
+		}
+	}
 	if err != nil {
+		if flags&DryRun == 0 {
+			recordOperation(historyInstall, name, "", err, trace)
+		}
 		return "", err
 	}
+	name = snapName
 
-	return name, GarbageCollect(name, flags, meter)
+	if (flags & (DryRun | LeaveInactive)) != 0 {
+		return name, nil
+	}
+	if part := ActiveSnapByName(name); part != nil {
+		recordOperation(historyInstall, QualifiedName(part), part.Version(), nil, trace)
+	}
+
+	return name, garbageCollect(name, opts.gcKeep(), meter)
 }
 
-func doInstall(name string, flags InstallFlags, meter progress.Meter) (snapName string, err error) {
+func doInstall(name, version string, flags InstallFlags, mStore *MetaRepository, meter progress.Meter) (snapName string, err error) {
 	defer func() {
 		if err != nil {
 			err = &ErrInstallFailed{Snap: name, OrigErr: err}
@@ -102,11 +493,20 @@ func doInstall(name string, flags InstallFlags, meter progress.Meter) (snapName
 			flags |= AllowUnauthenticated
 		}
 
+		if flags&CheckStoreName != 0 {
+			snapName, err := snapFileName(name)
+			if err != nil {
+				return "", err
+			}
+			if err := checkSideloadName(snapName, flags, mStore, meter); err != nil {
+				return "", err
+			}
+		}
+
 		return installClick(name, flags, meter, SideloadedOrigin)
 	}
 
 	// check repos next
-	mStore := NewMetaStoreRepository()
 	installed, err := NewMetaLocalRepository().Installed()
 	if err != nil {
 		return "", err
@@ -119,7 +519,12 @@ func doInstall(name string, flags InstallFlags, meter progress.Meter) (snapName
 		name = name[:idx]
 	}
 
-	found, err := mStore.Details(name, origin)
+	var found []Part
+	if version != "" {
+		found, err = mStore.DetailsRevision(name, origin, version)
+	} else {
+		found, err = mStore.Details(name, origin)
+	}
 	if err != nil {
 		return "", err
 	}
@@ -141,26 +546,114 @@ func doInstall(name string, flags InstallFlags, meter progress.Meter) (snapName
 	return "", ErrPackageNotFound
 }
 
+// snapFileName returns the name of the snap in the given file
+func snapFileName(snapFile string) (string, error) {
+	m, err := snapFileYaml(snapFile)
+	if err != nil {
+		return "", err
+	}
+
+	return m.Name, nil
+}
+
+// snapFileYaml returns the package.yaml of the snap in the given file
+func snapFileYaml(snapFile string) (*packageYaml, error) {
+	d, err := OpenPackageFile(snapFile)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	yamlData, err := d.MetaMember("package.yaml")
+	if err != nil {
+		return nil, err
+	}
+
+	var m packageYaml
+	if err := yaml.Unmarshal(yamlData, &m); err != nil {
+		return nil, &ErrInvalidYaml{File: "package.yaml", Err: err, Yaml: yamlData}
+	}
+
+	return &m, nil
+}
+
+// checkSideloadName fails if the store has a snap of the given name
+// (unless ForceSideload is set, then it only warns), as a sideloaded
+// snap blocks the installs of the one from the store. If the store can
+// not be reached the snap is installed.
+func checkSideloadName(name string, flags InstallFlags, mStore *MetaRepository, meter progress.Meter) error {
+	var origins []string
+	found, err := mStore.Details(name, "")
+	switch err := err.(type) {
+	case nil:
+		for _, part := range found {
+			origins = append(origins, part.Origin())
+		}
+	case *ErrAmbiguousName:
+		origins = err.Origins
+	default:
+		logger.Noticef("Failed to look up %s in the store: %v", name, err)
+		return nil
+	}
+	if len(origins) == 0 {
+		return nil
+	}
+
+	if flags&ForceSideload != 0 {
+		progress.NotifyMessage(meter, progress.NewMessage(progress.MsgSideloadNameTaken, name, strings.Join(origins, ", ")))
+		return nil
+	}
+
+	return &ErrSideloadNameTaken{Name: name, Origins: origins}
+}
+
 // GarbageCollect removes all versions two older than the current active
 // version, as long as NeedsReboot() is false on all the versions found, and
 // DoInstallGC is set.
 func GarbageCollect(name string, flags InstallFlags, pb progress.Meter) error {
-	var parts BySnapVersion
-
 	if (flags & DoInstallGC) == 0 {
 		return nil
 	}
 
+	return garbageCollect(name, 1, pb)
+}
+
+// garbageCollect removes all but the given number of the versions of
+// the snap older than the active one (keep must be at least 1)
+func garbageCollect(name string, keep int, pb progress.Meter) error {
+	if keep < 1 {
+		return nil
+	}
+
 	m := NewMetaRepository()
 	installed, err := m.Installed()
 	if err != nil {
 		return err
 	}
 
-	parts = FindSnapsByName(name, installed)
-	if len(parts) < 3 {
+	parts, err := gcCandidates(FindSnapsByName(name, installed), keep)
+	if err != nil {
+		return err
+	}
+
+	for _, part := range parts {
+		if err := part.Uninstall(pb); err != nil {
+			return ErrGarbageCollectImpossible(err.Error())
+		}
+	}
+
+	return nil
+}
+
+// gcCandidates returns the versions in parts (the versions of one
+// snap) older than the active one but the newest keep of those, the
+// oldest first; there are none unless a version is active and none
+// needs a reboot. The versions newer than the active one (e.g. left
+// inactive) are never collected.
+func gcCandidates(parts BySnapVersion, keep int) (BySnapVersion, error) {
+	if len(parts) < keep+2 {
 		// not enough things installed to do gc
-		return nil
+		return nil, nil
 	}
 
 	sort.Sort(parts)
@@ -169,25 +662,19 @@ func GarbageCollect(name string, flags InstallFlags, pb progress.Meter) error {
 	for i, part := range parts {
 		if part.IsActive() {
 			if active > -1 {
-				return ErrGarbageCollectImpossible("more than one active (should not happen).")
+				return nil, ErrGarbageCollectImpossible("more than one active (should not happen).")
 			}
 			active = i
 		}
 		if part.NeedsReboot() {
-			return nil // don't do gc on parts that need reboot.
+			return nil, nil // don't do gc on parts that need reboot.
 		}
 	}
 
-	if active < 1 {
-		// how was this an install?
-		return nil
+	if active < keep+1 {
+		// how was this an install? (or there is nothing to collect)
+		return nil, nil
 	}
 
-	for _, part := range parts[:active-1] {
-		if err := part.Uninstall(pb); err != nil {
-			return ErrGarbageCollectImpossible(err.Error())
-		}
-	}
-
-	return nil
+	return parts[:active-keep], nil
 }
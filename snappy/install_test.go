@@ -32,8 +32,9 @@ import (
 	. "gopkg.in/check.v1"
 
 	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
 	"github.com/ubuntu-core/snappy/partition"
-	"github.com/ubuntu-core/snappy/progress"
+	"github.com/ubuntu-core/snappy/pkg/remote"
 )
 
 func makeCloudInitMetaData(c *C, content string) string {
@@ -46,7 +47,7 @@ func makeCloudInitMetaData(c *C, content string) string {
 
 func (s *SnapTestSuite) TestInstallInstall(c *C) {
 	snapFile := makeTestSnapPackage(c, "")
-	name, err := Install(snapFile, AllowUnauthenticated|DoInstallGC, &progress.NullProgress{})
+	name, err := Install(snapFile, AllowUnauthenticated|DoInstallGC, s.meter())
 	c.Assert(err, IsNil)
 	c.Check(name, Equals, "foo")
 }
@@ -63,15 +64,15 @@ icon: foo.svg
 vendor: Foo Bar <foo@example.com>
 `
 	snapFile := makeTestSnapPackage(c, packageYaml+"version: 1.0")
-	_, err = Install(snapFile, flags, &progress.NullProgress{})
+	_, err = Install(snapFile, flags, s.meter())
 	c.Assert(err, IsNil)
 
 	snapFile = makeTestSnapPackage(c, packageYaml+"version: 2.0")
-	_, err = Install(snapFile, flags, &progress.NullProgress{})
+	_, err = Install(snapFile, flags, s.meter())
 	c.Assert(err, IsNil)
 
 	snapFile = makeTestSnapPackage(c, packageYaml+"version: 3.0")
-	_, err = Install(snapFile, flags, &progress.NullProgress{})
+	_, err = Install(snapFile, flags, s.meter())
 	c.Assert(err, IsNil)
 }
 
@@ -102,6 +103,77 @@ func (s *SnapTestSuite) TestClickInstallGCSuppressed(c *C) {
 	c.Check(globs, HasLen, 3+1) // +1 for "current"
 }
 
+func (s *SnapTestSuite) TestInstallWithOptionsDryRun(c *C) {
+	snapFile := makeTestSnapPackage(c, "")
+	name, err := InstallWithOptions(snapFile, InstallOptions{Flags: AllowUnauthenticated, Meter: s.meter(), DryRun: true})
+	c.Assert(err, IsNil)
+	c.Check(name, Equals, "foo")
+
+	globs, err := filepath.Glob(filepath.Join(dirs.SnapAppsDir, "foo.sideload", "*"))
+	c.Assert(err, IsNil)
+	c.Check(globs, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestRemoteSnapInstallDryRun(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Errorf("unexpected request for %s", r.URL.Path)
+	}))
+	defer mockServer.Close()
+
+	r := NewRemoteSnapPart(remote.Snap{
+		Name:            "foo",
+		Origin:          "bar",
+		Version:         "1.0",
+		AnonDownloadURL: mockServer.URL + "/foo.snap",
+		IconURL:         mockServer.URL + "/foo.png",
+	})
+	name, err := r.Install(s.meter(), DryRun)
+	c.Assert(err, IsNil)
+	c.Check(name, Equals, "foo")
+
+	// not even the manifest or the icon
+	c.Check(helpers.FileExists(RemoteManifestPath(r)), Equals, false)
+	c.Check(helpers.FileExists(iconPath(r)), Equals, false)
+}
+
+func (s *SnapTestSuite) TestInstallWithOptionsLeaveInactive(c *C) {
+	packageYaml := "name: foo\nvendor: Foo Bar <foo@example.com>\n"
+	_, err := Install(makeTestSnapPackage(c, packageYaml+"version: 1.0"), AllowUnauthenticated, s.meter())
+	c.Assert(err, IsNil)
+
+	// (sideloaded snaps get a version of their own)
+	first := ActiveSnapByName("foo")
+	c.Assert(first, NotNil)
+
+	_, err = InstallWithOptions(makeTestSnapPackage(c, packageYaml+"version: 2.0"), InstallOptions{Flags: AllowUnauthenticated, LeaveInactive: true, Meter: s.meter()})
+	c.Assert(err, IsNil)
+
+	installed, err := NewMetaRepository().Installed()
+	c.Assert(err, IsNil)
+	parts := FindSnapsByName("foo", installed)
+	c.Assert(parts, HasLen, 2)
+
+	// the first version stays active, the second one is all there
+	c.Check(ActiveSnapByName("foo").Version(), Equals, first.Version())
+	for _, part := range parts {
+		if part.Version() == first.Version() {
+			continue
+		}
+		c.Check(part.IsActive(), Equals, false)
+		c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, "foo.sideload", part.Version(), "meta", "package.yaml")), Equals, true)
+		c.Check(helpers.FileExists(filepath.Join(dirs.SnapDataDir, "foo.sideload", part.Version())), Equals, true)
+	}
+}
+
+func (s *SnapTestSuite) TestInstallOptionsFlags(c *C) {
+	opts := InstallOptions{Flags: InhibitHooks, DevMode: true, NoRestart: true}
+	c.Check(opts.flags(), Equals, InhibitHooks|AllowUnauthenticated|DeveloperMode|InhibitRestart)
+	c.Check(opts.gcKeep(), Equals, 0)
+
+	opts = InstallOptions{Flags: DoInstallGC}
+	c.Check(opts.gcKeep(), Equals, 1)
+}
+
 func (s *SnapTestSuite) TestInstallAppTwiceFails(c *C) {
 	snapPackage := makeTestSnapPackage(c, "name: foo\nversion: 2\nvendor: foo")
 	snapR, err := os.Open(snapPackage)
@@ -137,11 +209,11 @@ func (s *SnapTestSuite) TestInstallAppTwiceFails(c *C) {
 	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
 	c.Assert(err, IsNil)
 
-	name, err := Install("foo", 0, &progress.NullProgress{})
+	name, err := Install("foo", 0, s.meter())
 	c.Assert(err, IsNil)
 	c.Check(name, Equals, "foo")
 
-	_, err = Install("foo", 0, &progress.NullProgress{})
+	_, err = Install("foo", 0, s.meter())
 	c.Assert(err, ErrorMatches, ".*"+ErrAlreadyInstalled.Error())
 }
 
@@ -153,7 +225,7 @@ func (s *SnapTestSuite) TestInstallAppPackageNameFails(c *C) {
 
 	c.Assert(os.MkdirAll(filepath.Join(pkgdir, ".click", "info"), 0755), IsNil)
 	c.Assert(ioutil.WriteFile(filepath.Join(pkgdir, ".click", "info", "hello-app.manifest"), []byte(`{"name": "hello-app"}`), 0644), IsNil)
-	ag := &progress.NullProgress{}
+	ag := s.meter()
 	part, err := NewInstalledSnapPart(yamlFile, "potato")
 	c.Assert(err, IsNil)
 	c.Assert(part.activate(true, ag), IsNil)
@@ -186,7 +258,7 @@ func (s *SnapTestSuite) TestInstallAppPackageNameFails(c *C) {
 
 func (s *SnapTestSuite) TestUpdate(c *C) {
 	snapPackagev1 := makeTestSnapPackage(c, "name: foo\nversion: 1\nvendor: foo")
-	name, err := Install(snapPackagev1, AllowUnauthenticated|DoInstallGC, &progress.NullProgress{})
+	name, err := Install(snapPackagev1, AllowUnauthenticated|DoInstallGC, s.meter())
 	c.Assert(err, IsNil)
 	c.Assert(name, Equals, "foo")
 
@@ -265,9 +337,206 @@ func (s *SnapTestSuite) TestUpdate(c *C) {
 	systemImageServer = mockServer.URL
 
 	// the test
-	updates, err := Update(0, &progress.NullProgress{})
+	updates, err := Update(0, s.meter())
 	c.Assert(err, IsNil)
 	c.Assert(updates, HasLen, 1)
 	c.Check(updates[0].Name(), Equals, "foo")
 	c.Check(updates[0].Version(), Equals, "2")
 }
+
+func (s *SnapTestSuite) TestUpgradeAllPartialFailure(c *C) {
+	// from the store, sideloaded snaps would get a version of their
+	// own
+	for _, name := range []string{"foo", "bar"} {
+		_, err := installClick(makeTestSnapPackage(c, "name: "+name+"\nversion: 1\nvendor: foo"), AllowUnauthenticated, s.meter(), testOrigin)
+		c.Assert(err, IsNil)
+	}
+
+	snapR, err := os.Open(makeTestSnapPackage(c, "name: foo\nversion: 2\nvendor: foo"))
+	c.Assert(err, IsNil)
+	defer snapR.Close()
+
+	var baseURL string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/updates/":
+			io.WriteString(w, `[{
+	"package_name": "foo",
+	"version": "2",
+	"origin": "`+testOrigin+`",
+	"anon_download_url": "`+baseURL+`/dl",
+	"icon_url": "`+baseURL+`/icon"
+}, {
+	"package_name": "bar",
+	"version": "2",
+	"origin": "`+testOrigin+`",
+	"anon_download_url": "`+baseURL+`/missing",
+	"icon_url": "`+baseURL+`/icon"
+}]`)
+		case "/dl":
+			snapR.Seek(0, 0)
+			io.Copy(w, snapR)
+		case "/icon":
+			fmt.Fprintf(w, "")
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+	baseURL = mockServer.URL
+
+	storeBulkURI, err = url.Parse(mockServer.URL + "/updates/")
+	c.Assert(err, IsNil)
+
+	// system image
+	newPartition = func() (p partition.Interface) {
+		return new(MockPartition)
+	}
+	defer func() { newPartition = newPartitionImpl }()
+
+	makeFakeSystemImageChannelConfig(c, filepath.Join(dirs.GlobalRootDir, systemImageChannelConfig), "1")
+	makeFakeSystemImageChannelConfig(c, filepath.Join(dirs.GlobalRootDir, "other", systemImageChannelConfig), "2")
+
+	siServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, fmt.Sprintf(mockSystemImageIndexJSONTemplate, "1"))
+	}))
+	c.Assert(siServer, NotNil)
+	defer siServer.Close()
+	systemImageServer = siServer.URL
+
+	// the test
+	results, err := UpgradeAll(s.meter())
+	c.Check(err, DeepEquals, ErrUpgradeFailed{"bar." + testOrigin})
+	c.Assert(results, HasLen, 2)
+
+	c.Check(results[0].Snap, Equals, "foo."+testOrigin)
+	c.Check(results[0].From, Equals, "1")
+	c.Check(results[0].To, Equals, "2")
+	c.Check(results[0].Err, IsNil)
+
+	c.Check(results[1].Snap, Equals, "bar."+testOrigin)
+	c.Check(results[1].From, Equals, "1")
+	c.Check(results[1].To, Equals, "2")
+	c.Check(results[1].Err, FitsTypeOf, &ErrDownload{})
+}
+
+func (s *SnapTestSuite) TestInstallWithOptionsVersion(c *C) {
+	snapPackage := makeTestSnapPackage(c, "name: foo\nversion: 1\nvendor: foo")
+	snapR, err := os.Open(snapPackage)
+	c.Assert(err, IsNil)
+	defer snapR.Close()
+
+	var dlURL, iconURL string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/details/foo.test":
+			c.Check(r.URL.Query().Get("version"), Equals, "1")
+			io.WriteString(w, `{
+"package_name": "foo",
+"version": "1",
+"origin": "test",
+"anon_download_url": "`+dlURL+`",
+"icon_url": "`+iconURL+`"
+}`)
+		case "/dl":
+			snapR.Seek(0, 0)
+			io.Copy(w, snapR)
+		case "/icon":
+			fmt.Fprintf(w, "")
+		default:
+			panic("unexpected url path: " + r.URL.Path)
+		}
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	dlURL = mockServer.URL + "/dl"
+	iconURL = mockServer.URL + "/icon"
+
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	name, err := InstallWithOptions("foo.test", InstallOptions{Version: "1", Meter: s.meter()})
+	c.Assert(err, IsNil)
+	c.Check(name, Equals, "foo")
+	c.Check(ActiveSnapByName("foo").Version(), Equals, "1")
+}
+
+func (s *SnapTestSuite) TestInstallWithOptionsVersionNotAvailable(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a store that does not know about versions
+		io.WriteString(w, `{"package_name": "foo", "version": "2", "origin": "test"}`)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	_, err = InstallWithOptions("foo.test", InstallOptions{Version: "1", Meter: s.meter()})
+	c.Assert(err, FitsTypeOf, &ErrInstallFailed{})
+	c.Check(err.(*ErrInstallFailed).OrigErr, DeepEquals, &ErrVersionNotAvailable{Snap: "foo.test", Version: "1"})
+}
+
+func (s *SnapTestSuite) TestHandleUnpublishedWarns(c *C) {
+	part := &RemoteSnapPart{pkg: remote.Snap{Name: "foo", Origin: "bar", Status: remote.StatusUnpublished}}
+	meter := &MockProgressMeter{}
+
+	opts := InstallOptions{}
+	c.Assert(opts.handleUnpublished(part, meter), IsNil)
+	c.Check(meter.notified, DeepEquals, []string{"foo.bar was unpublished from the store, it will not get updates"})
+}
+
+func (s *SnapTestSuite) TestHandleUnpublishedKeeps(c *C) {
+	part := &RemoteSnapPart{pkg: remote.Snap{Name: "foo", Origin: "bar", Status: remote.StatusUnpublished}}
+	meter := &MockProgressMeter{}
+
+	opts := InstallOptions{Unpublished: UnpublishedKeep}
+	c.Assert(opts.handleUnpublished(part, meter), IsNil)
+	c.Check(meter.notified, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestHandleUnpublishedRemoves(c *C) {
+	part := &RemoteSnapPart{pkg: remote.Snap{Name: "foo", Origin: "bar", Status: remote.StatusUnpublished}}
+	meter := &MockProgressMeter{}
+
+	// foo is not installed, so there is nothing to remove
+	opts := InstallOptions{Unpublished: UnpublishedRemove}
+	c.Check(opts.handleUnpublished(part, meter), Equals, ErrPackageNotFound)
+	c.Check(meter.notified, DeepEquals, []string{"Removing foo.bar, it was unpublished from the store"})
+}
+
+func (s *SnapTestSuite) TestCheckSideloadNameTaken(c *C) {
+	mockServer := mockStoreWithOrigins(c)
+	defer mockServer.Close()
+
+	err := checkSideloadName("foo", CheckStoreName, NewMetaStoreRepository(), &MockProgressMeter{})
+	c.Check(err, DeepEquals, &ErrSideloadNameTaken{Name: "foo", Origins: []string{"alice"}})
+}
+
+func (s *SnapTestSuite) TestCheckSideloadNameForced(c *C) {
+	mockServer := mockStoreWithOrigins(c)
+	defer mockServer.Close()
+
+	meter := &MockProgressMeter{}
+	c.Assert(checkSideloadName("foo", CheckStoreName|ForceSideload, NewMetaStoreRepository(), meter), IsNil)
+	c.Check(meter.notified, DeepEquals, []string{"foo is available in the store (from alice), the sideloaded one blocks installing it"})
+}
+
+func (s *SnapTestSuite) TestCheckSideloadNameFree(c *C) {
+	mockServer := mockStoreWithOrigins(c)
+	defer mockServer.Close()
+
+	meter := &MockProgressMeter{}
+	c.Check(checkSideloadName("bar", CheckStoreName, NewMetaStoreRepository(), meter), IsNil)
+	c.Check(meter.notified, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestCheckSideloadNameStoreUnreachable(c *C) {
+	mockServer := mockStoreWithOrigins(c)
+	mockServer.Close()
+
+	c.Check(checkSideloadName("foo", CheckStoreName, NewMetaStoreRepository(), &MockProgressMeter{}), IsNil)
+}
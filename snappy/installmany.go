@@ -0,0 +1,245 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"os"
+	"strings"
+
+	"github.com/ubuntu-core/snappy/pkg"
+)
+
+// manyPart is one of the snaps of InstallMany
+type manyPart struct {
+	// name it was asked for by (or that of the framework)
+	name string
+	part Part
+	// snapFile is the file of a local snap ("" for one of the store)
+	snapFile string
+	// frameworks are the frameworks the part needs
+	frameworks []string
+}
+
+// manyParts orders the snaps of InstallMany (see installOrderOf)
+type manyParts []*manyPart
+
+func (a manyParts) Len() int                  { return len(a) }
+func (a manyParts) Name(i int) string         { return a[i].part.Name() }
+func (a manyParts) Type(i int) pkg.Type       { return a[i].part.Type() }
+func (a manyParts) Frameworks(i int) []string { return a[i].frameworks }
+
+// InstallMany installs the given snaps (names or files, as Install)
+// and the frameworks they need that are not installed, as one
+// operation: they all get resolved and downloaded before any gets
+// installed, the frameworks first (each after the frameworks it
+// needs), and if an install fails the ones done are removed again.
+// It returns the names of the snaps installed.
+func InstallMany(names []string, opts InstallOptions) (installed []string, err error) {
+	trace := opts.startTrace()
+	flags := opts.flags()
+	meter := opts.meter()
+	defer trace.finish(meter)
+
+	mStore := opts.configureStore(NewMetaStoreRepository())
+	resolved, err := resolveMany(names, mStore)
+	if err != nil {
+		return nil, err
+	}
+	order, err := installOrderOf(manyParts(resolved))
+	if err != nil {
+		return nil, err
+	}
+	parts := make([]*manyPart, len(order))
+	for i, j := range order {
+		parts[i] = resolved[j]
+	}
+
+	if flags&DryRun != 0 {
+		for _, p := range parts {
+			installed = append(installed, p.part.Name())
+		}
+		return installed, nil
+	}
+
+	// all the downloads before any install
+	var remotes []Part
+	defer func() { discardDownloads(remotes) }()
+	for _, p := range parts {
+		r, ok := p.part.(*RemoteSnapPart)
+		if !ok {
+			continue
+		}
+		remotes = append(remotes, r)
+		fn, err := r.Download(meter)
+		if err != nil {
+			return nil, &ErrInstallFailed{Snap: p.name, OrigErr: err}
+		}
+		r.downloaded = fn
+	}
+
+	tx := &transaction{op: "the install of many snaps"}
+	defer func() {
+		if err != nil {
+			tx.rollback()
+			installed = nil
+		}
+	}()
+	for _, p := range parts {
+		p := p
+		name := p.part.Name()
+		if err := tx.do("the install of "+name, func() error {
+			if p.snapFile != "" {
+				_, err := installClick(p.snapFile, flags, meter, SideloadedOrigin)
+				return err
+			}
+			_, err := p.part.Install(meter, flags)
+			return err
+		}, func() error {
+			// the failed install undid itself
+			if part := ActiveSnapByName(name); part != nil {
+				return part.Uninstall(meter)
+			}
+			return nil
+		}); err != nil {
+			recordOperation(historyInstall, p.name, "", err, trace)
+			return nil, &ErrInstallFailed{Snap: p.name, OrigErr: err}
+		}
+		installed = append(installed, name)
+	}
+
+	for _, name := range installed {
+		if part := ActiveSnapByName(name); part != nil {
+			recordOperation(historyInstall, QualifiedName(part), part.Version(), nil, trace)
+		}
+	}
+
+	return installed, nil
+}
+
+// resolveMany finds the snaps InstallMany installs: the ones asked for,
+// and the frameworks they need that are not installed. The store is
+// asked for the details of all the snaps at once (and then for those
+// of their frameworks, and so on).
+func resolveMany(names []string, mStore *MetaRepository) ([]*manyPart, error) {
+	installed, err := NewMetaLocalRepository().Installed()
+	if err != nil {
+		return nil, err
+	}
+
+	var asked []*manyPart
+	var storeNames []string
+	for _, name := range names {
+		p := &manyPart{name: name}
+		if fi, err := os.Stat(name); err == nil && fi.Mode().IsRegular() {
+			m, err := snapFileYaml(name)
+			if err != nil {
+				return nil, &ErrInstallFailed{Snap: name, OrigErr: err}
+			}
+			p.part = &SnapPart{m: m, origin: SideloadedOrigin}
+			p.snapFile = name
+		} else {
+			storeNames = append(storeNames, name)
+		}
+		asked = append(asked, p)
+	}
+	if err := detailsMany(asked, storeNames, installed, mStore); err != nil {
+		return nil, err
+	}
+
+	var parts []*manyPart
+	resolved := make(map[string]bool)
+	for _, p := range asked {
+		if resolved[p.part.Name()] {
+			continue
+		}
+		resolved[p.part.Name()] = true
+		parts = append(parts, p)
+	}
+
+	frameworks, err := ActiveSnapIterByType(BareName, pkg.TypeFramework)
+	if err != nil {
+		return nil, err
+	}
+	for _, fmk := range frameworks {
+		resolved[fmk] = true
+	}
+
+	// the frameworks of the frameworks get resolved as they get
+	// appended
+	for next := 0; next < len(parts); {
+		var missing []*manyPart
+		var fmkNames []string
+		for ; next < len(parts); next++ {
+			fmks, err := parts[next].part.Frameworks()
+			if err != nil {
+				return nil, err
+			}
+			parts[next].frameworks = fmks
+			for _, fmk := range fmks {
+				if resolved[fmk] {
+					continue
+				}
+				resolved[fmk] = true
+				missing = append(missing, &manyPart{name: fmk})
+				fmkNames = append(fmkNames, fmk)
+			}
+		}
+		if err := detailsMany(missing, fmkNames, installed, mStore); err != nil {
+			return nil, err
+		}
+		parts = append(parts, missing...)
+	}
+
+	return parts, nil
+}
+
+// detailsMany sets the part of the manyParts that have none to the one
+// of the store, asking it for the given names of them all at once
+func detailsMany(parts []*manyPart, names []string, installed []Part, mStore *MetaRepository) error {
+	if len(names) == 0 {
+		return nil
+	}
+
+	found, err := mStore.DetailsMany(names)
+	if err != nil {
+		return &ErrInstallFailed{Snap: strings.Join(names, ", "), OrigErr: err}
+	}
+
+	for _, p := range parts {
+		if p.part != nil {
+			continue
+		}
+		name, origin := SplitOrigin(p.name)
+		for _, part := range found {
+			if part.Name() == name && (origin == "" || part.Origin() == origin) {
+				p.part = part
+				break
+			}
+		}
+		if p.part == nil {
+			return &ErrInstallFailed{Snap: p.name, OrigErr: ErrPackageNotFound}
+		}
+		if err := checkInstallable(p.part, installed); err != nil {
+			return &ErrInstallFailed{Snap: p.name, OrigErr: err}
+		}
+	}
+
+	return nil
+}
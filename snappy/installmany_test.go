@@ -0,0 +1,118 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/pkg/remote"
+)
+
+// makeManySnapDir makes a snap dir with an app that needs a framework;
+// the snap of the framework is corrupt if badFmk is set
+func makeManySnapDir(c *C, badFmk bool) string {
+	corrupt := ""
+	if badFmk {
+		corrupt = "fmk"
+	}
+
+	return makeSnapDirOf(c, []remote.Snap{
+		{Name: "app", Origin: testOrigin, Version: "1.0", Frameworks: []string{"fmk"}},
+		{Name: "fmk", Version: "1.0", Type: pkg.TypeFramework},
+	}, corrupt)
+}
+
+// makeSnapDirOf makes a snap dir with the given snaps; the snap named
+// corrupt does not match its hash
+func makeSnapDirOf(c *C, snaps []remote.Snap, corrupt string) string {
+	dir := c.MkDir()
+	for _, snap := range snaps {
+		content := snap.Name + " " + snap.Version
+		snap.DownloadSha512 = sha512sum(content)
+		if snap.Name == corrupt {
+			content = "corrupt"
+		}
+		manifest, err := yaml.Marshal(snap)
+		c.Assert(err, IsNil)
+
+		base := filepath.Join(dir, snap.Name+"_"+snap.Version)
+		c.Assert(ioutil.WriteFile(base+".manifest", manifest, 0644), IsNil)
+		c.Assert(ioutil.WriteFile(base+".snap", []byte(content), 0644), IsNil)
+	}
+
+	return dir
+}
+
+func (s *SnapTestSuite) TestInstallManyResolvesFrameworks(c *C) {
+	installed, err := InstallMany([]string{"app"}, InstallOptions{SnapDir: makeManySnapDir(c, false), DryRun: true})
+	c.Assert(err, IsNil)
+	// the framework first
+	c.Check(installed, DeepEquals, []string{"fmk", "app"})
+}
+
+func (s *SnapTestSuite) TestInstallManyFrameworkOfFramework(c *C) {
+	snapDir := makeSnapDirOf(c, []remote.Snap{
+		{Name: "app", Origin: testOrigin, Version: "1.0", Frameworks: []string{"fmk-a"}},
+		{Name: "fmk-a", Version: "1.0", Type: pkg.TypeFramework, Frameworks: []string{"fmk-b"}},
+		{Name: "fmk-b", Version: "1.0", Type: pkg.TypeFramework},
+	}, "")
+
+	installed, err := InstallMany([]string{"app"}, InstallOptions{SnapDir: snapDir, DryRun: true})
+	c.Assert(err, IsNil)
+	// each framework after the one it needs
+	c.Check(installed, DeepEquals, []string{"fmk-b", "fmk-a", "app"})
+}
+
+func (s *SnapTestSuite) TestInstallManyFrameworkCycle(c *C) {
+	snapDir := makeSnapDirOf(c, []remote.Snap{
+		{Name: "app", Origin: testOrigin, Version: "1.0", Frameworks: []string{"fmk-a"}},
+		{Name: "fmk-a", Version: "1.0", Type: pkg.TypeFramework, Frameworks: []string{"fmk-b"}},
+		{Name: "fmk-b", Version: "1.0", Type: pkg.TypeFramework, Frameworks: []string{"fmk-a"}},
+	}, "")
+
+	_, err := InstallMany([]string{"app"}, InstallOptions{SnapDir: snapDir, DryRun: true})
+	c.Assert(err, DeepEquals, ErrFrameworkCycle{"fmk-a", "fmk-b"})
+}
+
+func (s *SnapTestSuite) TestInstallManyNotFound(c *C) {
+	_, err := InstallMany([]string{"app", "no-such-snap"}, InstallOptions{SnapDir: makeManySnapDir(c, false)})
+	c.Assert(err, FitsTypeOf, &ErrInstallFailed{})
+	c.Check(err.(*ErrInstallFailed).Snap, Equals, "no-such-snap")
+	c.Check(err.(*ErrInstallFailed).OrigErr, Equals, ErrPackageNotFound)
+}
+
+func (s *SnapTestSuite) TestInstallManyDownloadsFirst(c *C) {
+	_, err := InstallMany([]string{"app"}, InstallOptions{SnapDir: makeManySnapDir(c, true)})
+	c.Assert(err, FitsTypeOf, &ErrInstallFailed{})
+	c.Check(err.(*ErrInstallFailed).Snap, Equals, "fmk")
+	c.Check(err.(*ErrInstallFailed).OrigErr, FitsTypeOf, &ErrHashMismatch{})
+
+	// nothing got installed
+	for _, dir := range []string{"app." + testOrigin, "fmk"} {
+		c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, dir)), Equals, false)
+	}
+}
@@ -0,0 +1,142 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"sort"
+
+	"github.com/ubuntu-core/snappy/pkg"
+)
+
+// installOrderer is what installOrderOf needs to know of the snaps
+// it orders
+type installOrderer interface {
+	Len() int
+	Name(i int) string
+	Type(i int) pkg.Type
+	Frameworks(i int) []string
+}
+
+type lockedSnaps []LockedSnap
+
+func (a lockedSnaps) Len() int                  { return len(a) }
+func (a lockedSnaps) Name(i int) string         { return a[i].Name }
+func (a lockedSnaps) Type(i int) pkg.Type       { return a[i].Type }
+func (a lockedSnaps) Frameworks(i int) []string { return a[i].Frameworks }
+
+// installOrder returns the locked snaps in the order they need to be
+// installed in (see installOrderOf)
+func installOrder(snaps []LockedSnap) ([]LockedSnap, error) {
+	order, err := installOrderOf(lockedSnaps(snaps))
+	if err != nil {
+		return nil, err
+	}
+
+	ordered := make([]LockedSnap, len(order))
+	for i, j := range order {
+		ordered[i] = snaps[j]
+	}
+
+	return ordered, nil
+}
+
+// installOrderOf returns the indexes of the snaps in the order they
+// need to be installed in: the frameworks first, each one after the
+// frameworks it depends on, and then the other snaps in the given
+// order. Frameworks that depend on each other in a cycle are reported
+// as ErrFrameworkCycle.
+func installOrderOf(snaps installOrderer) ([]int, error) {
+	n := snaps.Len()
+	byName := make(map[string]int, n)
+	for i := 0; i < n; i++ {
+		byName[snaps.Name(i)] = i
+	}
+
+	// the frameworks are the snaps of type framework and the snaps
+	// others depend on (older locks do not have the type)
+	isFmk := make([]bool, n)
+	for i := 0; i < n; i++ {
+		if snaps.Type(i) == pkg.TypeFramework {
+			isFmk[i] = true
+		}
+		for _, fmk := range snaps.Frameworks(i) {
+			if j, ok := byName[fmk]; ok {
+				isFmk[j] = true
+			}
+		}
+	}
+
+	// pending[i] is the number of frameworks among the snaps that
+	// framework i still waits for
+	pending := make([]int, n)
+	dependents := make([][]int, n)
+	for i := 0; i < n; i++ {
+		if !isFmk[i] {
+			continue
+		}
+		for _, fmk := range snaps.Frameworks(i) {
+			if j, ok := byName[fmk]; ok && j != i {
+				pending[i]++
+				dependents[j] = append(dependents[j], i)
+			} else if ok {
+				return nil, ErrFrameworkCycle{snaps.Name(i)}
+			}
+		}
+	}
+
+	ordered := make([]int, 0, n)
+	done := make([]bool, n)
+	for found := true; found; {
+		found = false
+		// always pick the first ready framework, so the order is
+		// stable
+		for i := 0; i < n; i++ {
+			if !isFmk[i] || done[i] || pending[i] > 0 {
+				continue
+			}
+			done[i] = true
+			found = true
+			ordered = append(ordered, i)
+			for _, j := range dependents[i] {
+				pending[j]--
+			}
+			break
+		}
+	}
+
+	var cycle []string
+	for i := 0; i < n; i++ {
+		if isFmk[i] && !done[i] {
+			cycle = append(cycle, snaps.Name(i))
+		}
+	}
+	if len(cycle) > 0 {
+		sort.Strings(cycle)
+		return nil, ErrFrameworkCycle(cycle)
+	}
+
+	for i := 0; i < n; i++ {
+		if !isFmk[i] {
+			ordered = append(ordered, i)
+		}
+	}
+
+	return ordered, nil
+}
@@ -0,0 +1,87 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/pkg"
+)
+
+func lockedNames(snaps []LockedSnap) []string {
+	names := make([]string, len(snaps))
+	for i, snap := range snaps {
+		names[i] = snap.Name
+	}
+
+	return names
+}
+
+func (s *SnapTestSuite) TestInstallOrder(c *C) {
+	snaps := []LockedSnap{
+		{Name: "app1", Type: pkg.TypeApp, Frameworks: []string{"fmk-c"}},
+		{Name: "fmk-c", Type: pkg.TypeFramework, Frameworks: []string{"fmk-b", "fmk-a"}},
+		{Name: "app2", Type: pkg.TypeApp},
+		{Name: "fmk-a", Type: pkg.TypeFramework},
+		{Name: "fmk-b", Type: pkg.TypeFramework, Frameworks: []string{"fmk-a", "not-locked"}},
+	}
+
+	ordered, err := installOrder(snaps)
+	c.Assert(err, IsNil)
+	c.Check(lockedNames(ordered), DeepEquals, []string{"fmk-a", "fmk-b", "fmk-c", "app1", "app2"})
+}
+
+func (s *SnapTestSuite) TestInstallOrderUntyped(c *C) {
+	// locks written before the type was recorded
+	snaps := []LockedSnap{
+		{Name: "app1", Frameworks: []string{"fmk"}},
+		{Name: "fmk"},
+	}
+
+	ordered, err := installOrder(snaps)
+	c.Assert(err, IsNil)
+	c.Check(lockedNames(ordered), DeepEquals, []string{"fmk", "app1"})
+}
+
+func (s *SnapTestSuite) TestInstallOrderCycle(c *C) {
+	snaps := []LockedSnap{
+		{Name: "fmk-a", Type: pkg.TypeFramework, Frameworks: []string{"fmk-c"}},
+		{Name: "fmk-b", Type: pkg.TypeFramework, Frameworks: []string{"fmk-a"}},
+		{Name: "fmk-c", Type: pkg.TypeFramework, Frameworks: []string{"fmk-b"}},
+		{Name: "fmk-d", Type: pkg.TypeFramework},
+	}
+
+	_, err := installOrder(snaps)
+	c.Assert(err, DeepEquals, ErrFrameworkCycle{"fmk-a", "fmk-b", "fmk-c"})
+	c.Check(err, ErrorMatches, "frameworks depend on each other in a cycle: fmk-a, fmk-b, fmk-c")
+
+	_, err = installOrder([]LockedSnap{{Name: "fmk", Type: pkg.TypeFramework, Frameworks: []string{"fmk"}}})
+	c.Assert(err, DeepEquals, ErrFrameworkCycle{"fmk"})
+}
+
+func (s *SnapTestSuite) TestInstallLockedCycle(c *C) {
+	lock := &SnapsLock{Snaps: []LockedSnap{
+		{Name: "fmk-a", Frameworks: []string{"fmk-b"}},
+		{Name: "fmk-b", Frameworks: []string{"fmk-a"}},
+	}}
+
+	err := InstallLocked(lock, 0, s.meter())
+	c.Assert(err, DeepEquals, ErrFrameworkCycle{"fmk-a", "fmk-b"})
+}
@@ -0,0 +1,79 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// snapURL returns the URL to install the snap from, if name is an
+// http or https one
+func snapURL(name string) (*url.URL, bool) {
+	u, err := url.Parse(name)
+	if err != nil || u.Host == "" {
+		return nil, false
+	}
+
+	return u, u.Scheme == "http" || u.Scheme == "https"
+}
+
+// downloadSnapURL downloads the snap at the given URL to a temporary
+// file (that the caller removes), and checks it against opts.Sha512 if
+// that is set; it never sends the store headers, the URL is not the
+// store's
+func (opts *InstallOptions) downloadSnapURL(u *url.URL, meter progress.Meter) (fn string, err error) {
+	w, err := ioutil.TempFile("", "snappy-url-")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		w.Close()
+		if err != nil {
+			os.Remove(w.Name())
+		}
+	}()
+
+	t, err := transportFor(opts.Context, u, opts.trace.httpClient(opts.Timeout, opts.Proxy, nil), false, nil, false)
+	if err != nil {
+		return "", err
+	}
+	if err := t.Fetch(u.String(), u, w, meter); err != nil {
+		return "", err
+	}
+
+	if opts.Sha512 != "" {
+		sha512, err := helpers.Sha512sum(w.Name())
+		if err != nil {
+			return "", err
+		}
+		// the hash is hex, in either case
+		if !strings.EqualFold(sha512, opts.Sha512) {
+			return "", &ErrHashMismatch{Snap: u.String(), Expected: opts.Sha512, Got: sha512}
+		}
+	}
+
+	return w.Name(), nil
+}
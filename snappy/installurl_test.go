@@ -0,0 +1,87 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *SnapTestSuite) TestSnapURL(c *C) {
+	for name, ok := range map[string]bool{
+		"http://example.com/foo.snap":  true,
+		"https://example.com/foo.snap": true,
+		"ftp://example.com/foo.snap":   false,
+		"foo":                          false,
+		"foo.canonical":                false,
+		"/tmp/foo.snap":                false,
+	} {
+		_, isURL := snapURL(name)
+		c.Check(isURL, Equals, ok, Commentf(name))
+	}
+}
+
+func (s *SnapTestSuite) TestDownloadSnapURL(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, Equals, "/foo.snap")
+		// the store headers are not for anyone else
+		c.Check(r.Header.Get("X-Ubuntu-Device-Channel"), Equals, "")
+		io.WriteString(w, "the snap")
+	}))
+	defer mockServer.Close()
+
+	u, ok := snapURL(mockServer.URL + "/foo.snap")
+	c.Assert(ok, Equals, true)
+
+	opts := &InstallOptions{Sha512: sha512sum("the snap")}
+	fn, err := opts.downloadSnapURL(u, nil)
+	c.Assert(err, IsNil)
+	defer os.Remove(fn)
+
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "the snap")
+}
+
+func (s *SnapTestSuite) TestInstallFromURLHashMismatch(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "not the snap")
+	}))
+	defer mockServer.Close()
+
+	_, err := InstallWithOptions(mockServer.URL+"/foo.snap", InstallOptions{Sha512: sha512sum("the snap")})
+	c.Assert(err, FitsTypeOf, &ErrInstallFailed{})
+	c.Check(err.(*ErrInstallFailed).Snap, Equals, mockServer.URL+"/foo.snap")
+	c.Check(err.(*ErrInstallFailed).OrigErr, FitsTypeOf, &ErrHashMismatch{})
+}
+
+func (s *SnapTestSuite) TestInstallFromURLNotFound(c *C) {
+	mockServer := httptest.NewServer(http.NotFoundHandler())
+	defer mockServer.Close()
+
+	_, err := InstallWithOptions(mockServer.URL+"/foo.snap", InstallOptions{})
+	c.Assert(err, FitsTypeOf, &ErrInstallFailed{})
+	c.Check(err.(*ErrInstallFailed).OrigErr, FitsTypeOf, &ErrDownload{})
+}
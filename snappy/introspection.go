@@ -0,0 +1,254 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// updateCheckStatus is the outcome of the last update run, kept on disk
+// so other processes (like the introspection endpoint) can report it
+type updateCheckStatus struct {
+	// Time of the update check (in seconds since the epoch)
+	Time int64 `yaml:"time"`
+	// Error of the update run ("" if it succeeded)
+	Error string `yaml:"error,omitempty"`
+}
+
+func updateCheckStatusFile() string {
+	return filepath.Join(dirs.GlobalRootDir, dirs.SnappyDir, "last-update-check.yaml")
+}
+
+// recordUpdateCheck writes the outcome of a check for updates, given
+// the updates that were available (if the store was reached)
+func recordUpdateCheck(available []Part, checkErr error) {
+	if err := appendUpdateCheck(available, checkErr); err != nil {
+		logger.Noticef("Failed to record the update check in the history: %v", err)
+	}
+
+	status := updateCheckStatus{Time: time.Now().Unix()}
+	if checkErr != nil {
+		status.Error = checkErr.Error()
+	}
+
+	content, err := yaml.Marshal(status)
+	if err == nil {
+		fn := updateCheckStatusFile()
+		if err = os.MkdirAll(filepath.Dir(fn), 0755); err == nil {
+			err = helpers.AtomicWriteFile(fn, content, 0644, 0)
+		}
+	}
+	if err != nil {
+		logger.Noticef("Failed to record the update check: %v", err)
+	}
+}
+
+func readUpdateCheck() (*updateCheckStatus, error) {
+	content, err := ioutil.ReadFile(updateCheckStatusFile())
+	if err != nil {
+		return nil, err
+	}
+
+	var status updateCheckStatus
+	if err := yaml.Unmarshal(content, &status); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+func boolMetric(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// writeMetrics writes the metrics in the Prometheus text format
+func writeMetrics(w *bytes.Buffer, meter progress.Meter) error {
+	installed, err := NewMetaLocalRepository().Installed()
+	if err != nil {
+		return err
+	}
+
+	byType := make(map[pkg.Type]int)
+	rebootPending := false
+	for _, part := range installed {
+		byType[part.Type()]++
+		if part.NeedsReboot() {
+			rebootPending = true
+		}
+	}
+	types := make([]string, 0, len(byType))
+	for t := range byType {
+		types = append(types, string(t))
+	}
+	sort.Strings(types)
+
+	fmt.Fprintln(w, "# HELP snappy_installed_snaps Number of installed snaps (all versions).")
+	fmt.Fprintln(w, "# TYPE snappy_installed_snaps gauge")
+	for _, t := range types {
+		fmt.Fprintf(w, "snappy_installed_snaps{type=%q} %d\n", t, byType[pkg.Type(t)])
+	}
+
+	fmt.Fprintln(w, "# HELP snappy_reboot_pending Whether a reboot is needed to finish an update.")
+	fmt.Fprintln(w, "# TYPE snappy_reboot_pending gauge")
+	fmt.Fprintf(w, "snappy_reboot_pending %d\n", boolMetric(rebootPending))
+
+	if status, err := readUpdateCheck(); err == nil {
+		fmt.Fprintln(w, "# HELP snappy_last_update_check_timestamp_seconds Time of the last update run.")
+		fmt.Fprintln(w, "# TYPE snappy_last_update_check_timestamp_seconds gauge")
+		fmt.Fprintf(w, "snappy_last_update_check_timestamp_seconds %d\n", status.Time)
+		fmt.Fprintln(w, "# HELP snappy_last_update_check_success Whether the last update run succeeded.")
+		fmt.Fprintln(w, "# TYPE snappy_last_update_check_success gauge")
+		fmt.Fprintf(w, "snappy_last_update_check_success %d\n", boolMetric(status.Error == ""))
+	}
+
+	stati, err := serviceStati(meter)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, "# HELP snappy_service_active Whether the service of a snap is active.")
+	fmt.Fprintln(w, "# TYPE snappy_service_active gauge")
+	for _, status := range stati {
+		fmt.Fprintf(w, "snappy_service_active{snap=%q,service=%q} %d\n", status.PackageName, status.ServiceName, boolMetric(status.ActiveState == "active"))
+	}
+
+	return nil
+}
+
+func serviceStati(meter progress.Meter) ([]*PackageServiceStatus, error) {
+	actor, err := FindServices("", "", meter)
+	if err != nil {
+		return nil, err
+	}
+
+	return actor.ServiceStatus()
+}
+
+// introspection serves the metrics and the health of the services,
+// looking at the services through the Backend of its meter
+type introspection struct {
+	meter progress.Meter
+}
+
+func (i *introspection) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	var buf bytes.Buffer
+	if err := writeMetrics(&buf, i.meter); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(buf.Bytes())
+}
+
+// healthHandler reports the services of active snaps that are enabled
+// but not running
+func (i *introspection) healthHandler(w http.ResponseWriter, r *http.Request) {
+	stati, err := serviceStati(i.meter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var failed []string
+	for _, status := range stati {
+		if status.UnitFileState == "enabled" && status.ActiveState != "active" {
+			failed = append(failed, status.PackageName+"."+status.ServiceName)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain")
+	if len(failed) > 0 {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "not running: %s\n", strings.Join(failed, ", "))
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// IntrospectionHandler returns the read-only handler that serves the
+// metrics (/metrics, in the Prometheus text format) and the health of
+// the services (/health)
+func IntrospectionHandler(meter progress.Meter) http.Handler {
+	i := &introspection{meter: meter}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", i.metricsHandler)
+	mux.HandleFunc("/health", i.healthHandler)
+
+	return mux
+}
+
+// isLoopbackAddr checks that the given tcp host:port only listens on
+// the loopback interface (so an empty host, like ":9100", is not)
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+
+	return ip != nil && ip.IsLoopback()
+}
+
+// ServeIntrospection serves the IntrospectionHandler on the given address,
+// a path for a unix socket or a loopback host:port for tcp
+func ServeIntrospection(addr string, meter progress.Meter) error {
+	network := "tcp"
+	if strings.HasPrefix(addr, "/") {
+		network = "unix"
+		// stale socket of a previous run
+		if err := os.Remove(addr); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else if !isLoopbackAddr(addr) {
+		return &ErrInvalidIntrospectionAddress{Addr: addr}
+	}
+
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	return http.Serve(l, IntrospectionHandler(meter))
+}
@@ -0,0 +1,87 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *SnapTestSuite) TestIntrospectionMetrics(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+	recordUpdateCheck(nil, errors.New("no network"))
+
+	s.backend.systemctl = func(cmd ...string) ([]byte, error) {
+		return []byte("ActiveState=active\n"), nil
+	}
+
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	c.Assert(err, IsNil)
+	rec := httptest.NewRecorder()
+	IntrospectionHandler(s.meter()).ServeHTTP(rec, req)
+
+	c.Check(rec.Code, Equals, 200)
+	body := rec.Body.String()
+	c.Check(body, Matches, `(?s).*\nsnappy_installed_snaps{type="app"} 1\n.*`)
+	c.Check(body, Matches, `(?s).*\nsnappy_reboot_pending 0\n.*`)
+	c.Check(body, Matches, `(?s).*\nsnappy_last_update_check_timestamp_seconds [0-9]+\n.*`)
+	c.Check(body, Matches, `(?s).*\nsnappy_last_update_check_success 0\n.*`)
+	c.Check(body, Matches, `(?s).*\nsnappy_service_active{snap="hello-app",service="svc1"} 1\n.*`)
+}
+
+func (s *SnapTestSuite) TestIntrospectionHealth(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+
+	state := "active"
+	s.backend.systemctl = func(cmd ...string) ([]byte, error) {
+		return []byte("UnitFileState=enabled\nActiveState=" + state + "\n"), nil
+	}
+
+	req, err := http.NewRequest("GET", "/health", nil)
+	c.Assert(err, IsNil)
+	rec := httptest.NewRecorder()
+	IntrospectionHandler(s.meter()).ServeHTTP(rec, req)
+	c.Check(rec.Code, Equals, 200)
+	c.Check(rec.Body.String(), Equals, "ok\n")
+
+	state = "failed"
+	rec = httptest.NewRecorder()
+	IntrospectionHandler(s.meter()).ServeHTTP(rec, req)
+	c.Check(rec.Code, Equals, http.StatusServiceUnavailable)
+	c.Check(rec.Body.String(), Equals, "not running: hello-app.svc1\n")
+}
+
+func (s *SnapTestSuite) TestIntrospectionLoopbackOnly(c *C) {
+	for _, addr := range []string{":9100", "0.0.0.0:9100", "[::]:9100", "192.168.1.2:9100", "example.com:9100", "nope"} {
+		err := ServeIntrospection(addr, s.meter())
+		c.Check(err, FitsTypeOf, &ErrInvalidIntrospectionAddress{}, Commentf(addr))
+	}
+
+	for _, addr := range []string{"localhost:9100", "127.0.0.1:9100", "[::1]:9100"} {
+		c.Check(isLoopbackAddr(addr), Equals, true, Commentf(addr))
+	}
+}
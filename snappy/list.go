@@ -26,9 +26,12 @@ func ListInstalled() ([]Part, error) {
 	return m.Installed()
 }
 
-// ListUpdates returns all snaps with updates
+// ListUpdates returns all snaps with updates (and records the check)
 func ListUpdates() ([]Part, error) {
 	m := NewMetaRepository()
 
-	return m.Updates()
+	updates, err := m.Updates()
+	recordUpdateCheck(updates, err)
+
+	return updates, err
 }
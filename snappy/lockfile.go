@@ -0,0 +1,394 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// LockedSnap is a snap pinned to an exact version by a lock file
+type LockedSnap struct {
+	Name    string `yaml:"name"`
+	Origin  string `yaml:"origin"`
+	Version string `yaml:"version"`
+	// Sha512 is the hash of the snap as downloaded from the store
+	Sha512 string `yaml:"sha512"`
+	// Type and Frameworks decide the order the snaps get installed in
+	Type       pkg.Type `yaml:"type,omitempty"`
+	Frameworks []string `yaml:"frameworks,omitempty"`
+}
+
+// SnapsLock pins the snaps of an image to exact versions, so that
+// images can be built reproducibly
+type SnapsLock struct {
+	Snaps []LockedSnap `yaml:"snaps"`
+}
+
+// ReadLock reads the lock file at the given path
+func ReadLock(path string) (*SnapsLock, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lock SnapsLock
+	if err := yaml.Unmarshal(content, &lock); err != nil {
+		return nil, &ErrInvalidYaml{File: path, Err: err, Yaml: content}
+	}
+
+	return &lock, nil
+}
+
+// Write writes the lock file to the given path
+func (l *SnapsLock) Write(path string) error {
+	content, err := yaml.Marshal(l)
+	if err != nil {
+		return err
+	}
+
+	return helpers.AtomicWriteFile(path, content, 0644, 0)
+}
+
+// GenerateLock returns the lock for the active snaps of the system.
+// Sideloaded snaps (and snaps without a store manifest) can not be
+// reproduced from the store and are left out.
+func GenerateLock() (*SnapsLock, error) {
+	installed, err := NewMetaLocalRepository().Installed()
+	if err != nil {
+		return nil, err
+	}
+
+	lock := &SnapsLock{}
+	for _, part := range installed {
+		snap, ok := part.(*SnapPart)
+		if !ok || !snap.IsActive() {
+			continue
+		}
+		if snap.Origin() == SideloadedOrigin || snap.remoteM == nil {
+			logger.Noticef("Not locking %s: not installed from the store", QualifiedName(snap))
+			continue
+		}
+
+		lock.Snaps = append(lock.Snaps, LockedSnap{
+			Name:       snap.Name(),
+			Origin:     snap.Origin(),
+			Version:    snap.Version(),
+			Sha512:     snap.remoteM.DownloadSha512,
+			Type:       snap.Type(),
+			Frameworks: snap.m.Frameworks,
+		})
+	}
+
+	return lock, nil
+}
+
+// lockedFetchWorkers is how many locked snaps are fetched (downloaded,
+// verified and their metadata written) at the same time
+var lockedFetchWorkers = 4
+
+// lockedMetadataTimeout is how long installing the locked snaps waits
+// for their icons and store manifests to be written; slow (SD card)
+// storage should not hold up the installation of the snaps
+var lockedMetadataTimeout = 30 * time.Second
+
+// fetchedSnap is a locked snap that was downloaded from the store
+type fetchedSnap struct {
+	locked   LockedSnap
+	part     *RemoteSnapPart
+	snapFile string
+	err      error
+	// metadata gets the result of writing the icon and the store
+	// manifest, and cancel stops writing them
+	metadata chan error
+	cancel   context.CancelFunc
+}
+
+// fetchLocked downloads the exact version of the locked snap from the
+// store and checks it is the locked one; the requests and the download
+// stop when ctx is done
+func fetchLocked(ctx context.Context, locked LockedSnap) *fetchedSnap {
+	f := &fetchedSnap{locked: locked}
+
+	found, err := (&InstallOptions{Context: ctx}).configureStore(NewMetaStoreRepository()).DetailsRevision(locked.Name, locked.Origin, locked.Version)
+	if err != nil {
+		f.err = err
+		return f
+	}
+	if len(found) == 0 {
+		f.err = ErrPackageNotFound
+		return f
+	}
+
+	part, ok := found[0].(*RemoteSnapPart)
+	if !ok {
+		f.err = ErrPackageNotFound
+		return f
+	}
+	if part.Version() != locked.Version {
+		f.err = &ErrLockMismatch{Snap: locked.Name, Expected: locked.Version, Got: part.Version()}
+		return f
+	}
+
+	// the snaps are fetched in parallel, their progress would be
+	// garbled on a shared meter
+	downloadedSnap, err := part.Download(&progress.NullProgress{})
+	if err != nil {
+		f.err = err
+		return f
+	}
+
+	sha512, err := helpers.Sha512sum(downloadedSnap)
+	if err == nil && sha512 != locked.Sha512 {
+		err = &ErrLockMismatch{Snap: locked.Name, Expected: locked.Sha512, Got: sha512}
+	}
+	if err != nil {
+		os.Remove(downloadedSnap)
+		f.err = err
+		return f
+	}
+	f.part = part
+	f.snapFile = downloadedSnap
+
+	return f
+}
+
+// startMetadata starts writing the store manifest and the icon of the
+// installed snap in the background
+func (f *fetchedSnap) startMetadata() {
+	parent := f.part.ctx
+	if parent == nil {
+		parent = context.Background()
+	}
+	ctx, cancel := context.WithCancel(parent)
+	part := *f.part
+	part.ctx = ctx
+
+	f.cancel = cancel
+	f.metadata = make(chan error, 1)
+	go func() {
+		err := part.saveStoreManifest()
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err == nil {
+			err = part.downloadIcon(&progress.NullProgress{})
+		}
+		f.metadata <- err
+	}()
+}
+
+// waitMetadata waits (for up to the given timeout) for the icon and
+// the store manifest of the snap to be written, and stops writing them
+// after that; not having them does not make the snap any less
+// installed
+func (f *fetchedSnap) waitMetadata(timeout time.Duration) {
+	if f.metadata == nil {
+		return
+	}
+	defer f.cancel()
+
+	var err error
+	select {
+	case err = <-f.metadata:
+	case <-time.After(timeout):
+		logger.Noticef("Writing the metadata of %s took longer than %s, giving up", f.locked.Name, timeout)
+		f.cancel()
+		err = <-f.metadata
+	}
+	f.metadata = nil
+	if err != nil {
+		logger.Noticef("Failed to write the metadata of %s: %v", f.locked.Name, err)
+	}
+}
+
+// fetchAllLocked fetches the locked snaps in the background, up to
+// lockedFetchWorkers at a time and in order, and returns a channel for
+// each of the snaps that gets it once it is fetched. No more fetches
+// start once one fails or ctx is done (the snaps that are not fetched
+// then get context.Canceled), and the ones going on stop with ctx.
+func fetchAllLocked(ctx context.Context, snaps []LockedSnap) []chan *fetchedSnap {
+	fetched := make([]chan *fetchedSnap, len(snaps))
+	for i := range fetched {
+		fetched[i] = make(chan *fetchedSnap, 1)
+	}
+
+	var failOnce sync.Once
+	failed := make(chan struct{})
+	stopped := func() bool {
+		select {
+		case <-failed:
+			return true
+		case <-ctx.Done():
+			return true
+		default:
+			return false
+		}
+	}
+
+	workers := make(chan struct{}, lockedFetchWorkers)
+	go func() {
+		for i, locked := range snaps {
+			select {
+			case workers <- struct{}{}:
+			case <-failed:
+			case <-ctx.Done():
+			}
+			if stopped() {
+				for j := i; j < len(snaps); j++ {
+					fetched[j] <- &fetchedSnap{locked: snaps[j], err: context.Canceled}
+				}
+				return
+			}
+			go func(i int, locked LockedSnap) {
+				defer func() { <-workers }()
+				f := fetchLocked(ctx, locked)
+				if f.err != nil {
+					failOnce.Do(func() { close(failed) })
+				}
+				fetched[i] <- f
+			}(i, locked)
+		}
+	}()
+
+	return fetched
+}
+
+// InstallLocked installs the snaps of the lock that are not installed
+// in the locked version yet, frameworks first (in the order of their
+// dependencies on each other). The snaps are fetched in parallel but
+// installed one at a time, in that order.
+func InstallLocked(lock *SnapsLock, flags InstallFlags, meter progress.Meter) error {
+	snaps, err := installOrder(lock.Snaps)
+	if err != nil {
+		return err
+	}
+
+	installed, err := NewMetaLocalRepository().Installed()
+	if err != nil {
+		return err
+	}
+
+	var missing []LockedSnap
+	for _, locked := range snaps {
+		if len(FindSnapsByNameAndVersion(locked.qualifiedName(), locked.Version, installed)) > 0 {
+			continue
+		}
+		missing = append(missing, locked)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	fetched := fetchAllLocked(ctx, missing)
+	next := 0
+	defer func() {
+		// the ones that were not installed (after an error) are
+		// not fetched anymore, and the fetches going on stop
+		cancel()
+		for ; next < len(fetched); next++ {
+			if f := <-fetched[next]; f.snapFile != "" {
+				os.Remove(f.snapFile)
+			}
+		}
+	}()
+
+	// the metadata of the installed snaps is written while the next
+	// ones get installed
+	var done []*fetchedSnap
+	defer func() {
+		for _, f := range done {
+			f.waitMetadata(lockedMetadataTimeout)
+		}
+	}()
+
+	for ; next < len(fetched); next++ {
+		f := <-fetched[next]
+		qn := f.locked.qualifiedName()
+
+		progress.NotifyMessage(meter, progress.NewMessage(progress.MsgInstalling, qn, f.locked.Version))
+		if f.err != nil {
+			next++
+			return &ErrInstallFailed{Snap: qn, OrigErr: f.err}
+		}
+
+		_, err := installClick(f.snapFile, flags, meter, f.locked.Origin)
+		os.Remove(f.snapFile)
+		if err != nil {
+			next++
+			return &ErrInstallFailed{Snap: qn, OrigErr: err}
+		}
+		f.startMetadata()
+		done = append(done, f)
+	}
+
+	return nil
+}
+
+func (locked LockedSnap) qualifiedName() string {
+	if locked.Origin == "" {
+		return locked.Name
+	}
+
+	return locked.Name + "." + locked.Origin
+}
+
+// InstallInto installs the snaps of the lock into the image at rootDir
+// and puts the lock into the image, so the image can be reproduced
+func InstallInto(rootDir string, lock *SnapsLock, flags InstallFlags, meter progress.Meter) error {
+	oldRootDir := dirs.GlobalRootDir
+	dirs.SetRootDir(rootDir)
+	defer dirs.SetRootDir(oldRootDir)
+
+	if err := InstallLocked(lock, flags, meter); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dirs.SnapLockFile), 0755); err != nil {
+		return err
+	}
+
+	return lock.Write(dirs.SnapLockFile)
+}
+
+// installLockedOnFirstBoot installs the snaps of the lock of the image
+// that are missing (if the image has a lock)
+func installLockedOnFirstBoot() error {
+	if !helpers.FileExists(dirs.SnapLockFile) {
+		return nil
+	}
+
+	lock, err := ReadLock(dirs.SnapLockFile)
+	if err != nil {
+		return err
+	}
+
+	return InstallLocked(lock, 0, &progress.NullProgress{})
+}
@@ -0,0 +1,323 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"context"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/pkg/remote"
+)
+
+func (s *SnapTestSuite) TestGenerateLock(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+
+	lock, err := GenerateLock()
+	c.Assert(err, IsNil)
+	c.Check(lock.Snaps, DeepEquals, []LockedSnap{
+		{Name: "hello-app", Origin: testOrigin, Version: "1.10", Type: pkg.TypeApp},
+	})
+}
+
+func (s *SnapTestSuite) TestLockWriteRead(c *C) {
+	lock := &SnapsLock{Snaps: []LockedSnap{
+		{Name: "foo", Origin: "bar", Version: "1.0", Sha512: "deadbeef"},
+	}}
+	fn := filepath.Join(c.MkDir(), "snaps.lock")
+	c.Assert(lock.Write(fn), IsNil)
+
+	read, err := ReadLock(fn)
+	c.Assert(err, IsNil)
+	c.Check(read, DeepEquals, lock)
+}
+
+func (s *SnapTestSuite) TestInstallLockedSkipsInstalled(c *C) {
+	_, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+
+	// the store is not queried
+	lock := &SnapsLock{Snaps: []LockedSnap{
+		{Name: "hello-app", Origin: testOrigin, Version: "1.10"},
+	}}
+	c.Check(InstallLocked(lock, 0, s.meter()), IsNil)
+}
+
+func (s *SnapTestSuite) TestInstallLockedVersionNotAvailable(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(filepath.Base(r.URL.Path), Equals, funkyAppName+"."+funkyAppOrigin)
+		io.WriteString(w, MockDetailsJSON)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	lock := &SnapsLock{Snaps: []LockedSnap{
+		{Name: funkyAppName, Origin: funkyAppOrigin, Version: "41"},
+	}}
+	err = InstallLocked(lock, 0, s.meter())
+	c.Assert(err, FitsTypeOf, &ErrInstallFailed{})
+	c.Check(err.(*ErrInstallFailed).OrigErr, DeepEquals, &ErrVersionNotAvailable{Snap: funkyAppName + "." + funkyAppOrigin, Version: "41"})
+}
+
+// mockLockedStore returns a store with any version of any snap (1.0 is
+// the latest), whose downloads and icons take the given time
+func mockLockedStore(c *C, downloadDelay, iconDelay time.Duration) *httptest.Server {
+	return mockLockedStoreLog(c, func(string) time.Duration { return downloadDelay }, iconDelay, nil)
+}
+
+// mockLockedStoreLog is mockLockedStore with the time the download of
+// each snap takes, logging the snaps downloaded (if log is not nil)
+func mockLockedStoreLog(c *C, downloadDelay func(name string) time.Duration, iconDelay time.Duration, log *lockedStoreLog) *httptest.Server {
+	var mockServer *httptest.Server
+	mockServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := filepath.Base(r.URL.Path)
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/details/"):
+			name, origin := SplitOrigin(name)
+			version := r.URL.Query().Get("version")
+			if version == "" {
+				version = "1.0"
+			}
+			allow := true
+			json.NewEncoder(w).Encode(remote.Snap{
+				Name:                 name,
+				Origin:               origin,
+				Version:              version,
+				AnonDownloadURL:      mockServer.URL + "/download/" + name,
+				AllowUnauthenticated: &allow,
+				IconURL:              mockServer.URL + "/icon/" + name,
+			})
+		case strings.HasPrefix(r.URL.Path, "/download/"):
+			if log != nil {
+				log.add(name)
+			}
+			select {
+			case <-time.After(downloadDelay(name)):
+			case <-r.Context().Done():
+				return
+			}
+			io.WriteString(w, "snap "+name)
+		case strings.HasPrefix(r.URL.Path, "/icon/"):
+			time.Sleep(iconDelay)
+			io.WriteString(w, "icon "+name)
+		default:
+			c.Fatalf("unexpected request to %s", r.URL)
+		}
+	}))
+	c.Assert(mockServer, NotNil)
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	return mockServer
+}
+
+// lockedStoreLog is the log of the snaps downloaded from the store of
+// mockLockedStoreLog
+type lockedStoreLog struct {
+	mu         sync.Mutex
+	downloaded []string
+}
+
+func (l *lockedStoreLog) add(name string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.downloaded = append(l.downloaded, name)
+}
+
+func (l *lockedStoreLog) names() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.downloaded...)
+}
+
+func lockedSnapFor(name string) LockedSnap {
+	sum := sha512.Sum512([]byte("snap " + name))
+	return LockedSnap{Name: name, Origin: "example", Version: "1.0", Sha512: hex.EncodeToString(sum[:])}
+}
+
+func (s *SnapTestSuite) TestFetchAllLockedInParallel(c *C) {
+	mockServer := mockLockedStore(c, 200*time.Millisecond, 0)
+	defer mockServer.Close()
+
+	snaps := []LockedSnap{lockedSnapFor("a"), lockedSnapFor("b"), lockedSnapFor("c"), lockedSnapFor("d")}
+
+	start := time.Now()
+	fetched := fetchAllLocked(context.Background(), snaps)
+	for i, ch := range fetched {
+		f := <-ch
+		c.Assert(f.err, IsNil)
+		c.Check(f.locked, DeepEquals, snaps[i])
+		content, err := ioutil.ReadFile(f.snapFile)
+		c.Assert(err, IsNil)
+		c.Check(string(content), Equals, "snap "+snaps[i].Name)
+		os.Remove(f.snapFile)
+		f.waitMetadata(time.Second)
+	}
+
+	// one at a time would take 800ms
+	c.Check(time.Since(start) < 600*time.Millisecond, Equals, true)
+}
+
+func (s *SnapTestSuite) TestFetchAllLockedBoundedWorkers(c *C) {
+	mockServer := mockLockedStore(c, 100*time.Millisecond, 0)
+	defer mockServer.Close()
+
+	oldWorkers := lockedFetchWorkers
+	lockedFetchWorkers = 1
+	defer func() { lockedFetchWorkers = oldWorkers }()
+
+	start := time.Now()
+	for _, ch := range fetchAllLocked(context.Background(), []LockedSnap{lockedSnapFor("a"), lockedSnapFor("b"), lockedSnapFor("c")}) {
+		f := <-ch
+		c.Assert(f.err, IsNil)
+		os.Remove(f.snapFile)
+		f.waitMetadata(time.Second)
+	}
+
+	c.Check(time.Since(start) >= 300*time.Millisecond, Equals, true)
+}
+
+func (s *SnapTestSuite) TestFetchLockedMismatch(c *C) {
+	mockServer := mockLockedStore(c, 0, 0)
+	defer mockServer.Close()
+
+	locked := lockedSnapFor("a")
+	locked.Sha512 = "deadbeef"
+	f := fetchLocked(context.Background(), locked)
+	c.Check(f.err, FitsTypeOf, &ErrLockMismatch{})
+	c.Check(f.snapFile, Equals, "")
+}
+
+func (s *SnapTestSuite) TestFetchLockedOlderVersion(c *C) {
+	mockServer := mockLockedStore(c, 0, 0)
+	defer mockServer.Close()
+
+	locked := lockedSnapFor("a")
+	locked.Version = "0.9"
+	f := fetchLocked(context.Background(), locked)
+	c.Assert(f.err, IsNil)
+	os.Remove(f.snapFile)
+	f.waitMetadata(time.Second)
+}
+
+func (s *SnapTestSuite) TestFetchLockedWritesNoMetadata(c *C) {
+	mockServer := mockLockedStore(c, 0, 0)
+	defer mockServer.Close()
+
+	// only installing the snap does
+	f := fetchLocked(context.Background(), lockedSnapFor("a"))
+	c.Assert(f.err, IsNil)
+	defer os.Remove(f.snapFile)
+	c.Check(f.metadata, IsNil)
+	c.Check(helpers.FileExists(RemoteManifestPath(f.part)), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapIconsDir, "a.example_1.0.png")), Equals, false)
+
+	f.startMetadata()
+	f.waitMetadata(time.Second)
+	c.Check(helpers.FileExists(RemoteManifestPath(f.part)), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapIconsDir, "a.example_1.0.png")), Equals, true)
+}
+
+func (s *SnapTestSuite) TestFetchLockedMetadataTimeout(c *C) {
+	mockServer := mockLockedStore(c, 0, 500*time.Millisecond)
+	defer mockServer.Close()
+
+	f := fetchLocked(context.Background(), lockedSnapFor("a"))
+	c.Assert(f.err, IsNil)
+	defer os.Remove(f.snapFile)
+
+	// a slow icon does not hold up the installation, and is not
+	// written after all
+	f.startMetadata()
+	start := time.Now()
+	f.waitMetadata(50 * time.Millisecond)
+	c.Check(time.Since(start) < 400*time.Millisecond, Equals, true)
+	c.Check(f.metadata, IsNil)
+
+	time.Sleep(600 * time.Millisecond)
+	c.Check(helpers.FileExists(RemoteManifestPath(f.part)), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapIconsDir, "a.example_1.0.png")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestInstallLockedStopsFetchingOnFailure(c *C) {
+	log := &lockedStoreLog{}
+	mockServer := mockLockedStoreLog(c, func(string) time.Duration { return 0 }, 0, log)
+	defer mockServer.Close()
+
+	oldWorkers := lockedFetchWorkers
+	lockedFetchWorkers = 1
+	defer func() { lockedFetchWorkers = oldWorkers }()
+
+	bad := lockedSnapFor("a")
+	bad.Sha512 = "deadbeef"
+	lock := &SnapsLock{Snaps: []LockedSnap{bad, lockedSnapFor("b"), lockedSnapFor("c")}}
+	err := InstallLocked(lock, 0, s.meter())
+	c.Assert(err, FitsTypeOf, &ErrInstallFailed{})
+	c.Check(err.(*ErrInstallFailed).Snap, Equals, "a.example")
+
+	// the others are never downloaded
+	c.Check(log.names(), DeepEquals, []string{"a"})
+}
+
+func (s *SnapTestSuite) TestInstallLockedCancelsFetchesOnFailure(c *C) {
+	mockServer := mockLockedStoreLog(c, func(name string) time.Duration {
+		if name == "b" {
+			return time.Minute
+		}
+		return 0
+	}, 0, nil)
+	defer mockServer.Close()
+
+	bad := lockedSnapFor("a")
+	bad.Sha512 = "deadbeef"
+	lock := &SnapsLock{Snaps: []LockedSnap{bad, lockedSnapFor("b")}}
+
+	// the download of b is going on when a fails, and is not
+	// waited for
+	start := time.Now()
+	err := InstallLocked(lock, 0, s.meter())
+	c.Assert(err, FitsTypeOf, &ErrInstallFailed{})
+	c.Check(err.(*ErrInstallFailed).Snap, Equals, "a.example")
+	c.Check(time.Since(start) < 10*time.Second, Equals, true)
+}
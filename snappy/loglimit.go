@@ -0,0 +1,161 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+)
+
+// LogLimit limits how much a service may log, declared via the
+// log-limit: of a service in the package.yaml
+type LogLimit struct {
+	// Namespace makes the services of the snap log to a journal
+	// namespace of their own, so they can not exhaust the system
+	// journal
+	Namespace bool `yaml:"namespace,omitempty" json:"namespace,omitempty"`
+	// MaxSize is the disk space the journal namespace may use
+	// (e.g. "50M"), it implies Namespace
+	MaxSize string `yaml:"max-size,omitempty" json:"max-size,omitempty"`
+
+	// at most RateBurst messages are logged per RateInterval
+	RateInterval Timeout `yaml:"rate-interval,omitempty" json:"rate-interval,omitempty"`
+	RateBurst    int     `yaml:"rate-burst,omitempty" json:"rate-burst,omitempty"`
+}
+
+var logMaxSizeRegexp = regexp.MustCompile(`^[0-9]+[KMGT]?$`)
+
+// the directories journald keeps the (persistent and volatile) journals in
+var journalDirs = []string{"/var/log/journal", "/run/log/journal"}
+
+func (l *LogLimit) verify() error {
+	if l.MaxSize != "" && !logMaxSizeRegexp.MatchString(l.MaxSize) {
+		return fmt.Errorf("invalid log-limit max-size %q", l.MaxSize)
+	}
+	if l.RateInterval < 0 || l.RateBurst < 0 {
+		return fmt.Errorf("invalid log-limit rate")
+	}
+
+	return nil
+}
+
+func (l *LogLimit) namespaced() bool {
+	return l != nil && (l.Namespace || l.MaxSize != "")
+}
+
+// logNamespace returns the journal namespace of the snap
+func logNamespace(m *packageYaml, origin string) string {
+	return m.qualifiedName(origin)
+}
+
+func journaldConfFileName(namespace string) string {
+	return filepath.Join(dirs.SnapJournaldConfDir, fmt.Sprintf("journald@%s.conf", namespace))
+}
+
+// logMaxSize returns the max-size of the journal namespace of the
+// snap, if any service sets one
+func (m *packageYaml) logMaxSize() string {
+	for _, service := range m.ServiceYamls {
+		if service.LogLimit != nil && service.LogLimit.MaxSize != "" {
+			return service.LogLimit.MaxSize
+		}
+	}
+
+	return ""
+}
+
+// writeJournaldConf writes the configuration of the journal namespace
+// of the snap if its size is limited
+func (m *packageYaml) writeJournaldConf(origin string) error {
+	maxSize := m.logMaxSize()
+	if maxSize == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(dirs.SnapJournaldConfDir, 0755); err != nil {
+		return err
+	}
+	content := fmt.Sprintf("[Journal]\nSystemMaxUse=%s\nRuntimeMaxUse=%s\n", maxSize, maxSize)
+
+	return helpers.AtomicWriteFile(journaldConfFileName(logNamespace(m, origin)), []byte(content), 0644, 0)
+}
+
+func (m *packageYaml) removeJournaldConf(origin string) {
+	fn := journaldConfFileName(logNamespace(m, origin))
+	if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+		logger.Noticef("Failed to remove journald configuration %q: %v", fn, err)
+	}
+}
+
+// LogUsage returns the disk space (in bytes) used by the journal
+// namespace of the given (active) snap
+func LogUsage(name string) (int64, error) {
+	installed, err := NewMetaLocalRepository().Installed()
+	if err != nil {
+		return 0, err
+	}
+
+	var part *SnapPart
+	for _, p := range FindSnapsByName(name, installed) {
+		if p.IsActive() {
+			part, _ = p.(*SnapPart)
+			break
+		}
+	}
+	if part == nil {
+		return 0, ErrPackageNotFound
+	}
+
+	namespaced := false
+	for _, service := range part.m.ServiceYamls {
+		if service.LogLimit.namespaced() {
+			namespaced = true
+			break
+		}
+	}
+	if !namespaced {
+		return 0, ErrNoLogNamespace
+	}
+
+	// the journals of a namespace are in <machine-id>.<namespace>
+	var usage int64
+	for _, dir := range journalDirs {
+		glob := filepath.Join(dirs.GlobalRootDir, dir, "*."+logNamespace(part.m, part.origin), "*")
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return 0, err
+		}
+		for _, fn := range matches {
+			st, err := os.Stat(fn)
+			if err != nil {
+				return 0, err
+			}
+			usage += st.Size()
+		}
+	}
+
+	return usage, nil
+}
@@ -0,0 +1,113 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+)
+
+const packageHelloLogLimit = `name: hello-app
+version: 1.10
+vendor: Michael Vogt <mvo@ubuntu.com>
+services:
+ - name: svc1
+   start: bin/hello
+   log-limit:
+     max-size: 10M
+     rate-burst: 100
+`
+
+func (s *SnapTestSuite) TestGenerateSnapServiceWithLogLimit(c *C) {
+	service := ServiceYaml{
+		Name:     "xkcd-webserver",
+		Start:    "bin/foo start",
+		LogLimit: &LogLimit{Namespace: true, RateInterval: Timeout(30 * time.Second), RateBurst: 100},
+	}
+	pkgPath := "/apps/xkcd-webserver.canonical/0.3.4/"
+	aaProfile := "xkcd-webserver.canonical_xkcd-webserver_0.3.4"
+	m := packageYaml{Name: "xkcd-webserver", Version: "0.3.4"}
+
+	generated, err := generateSnapServicesFile(service, pkgPath, aaProfile, &m)
+	c.Assert(err, IsNil)
+	c.Check(generated, Matches, `(?s).*\nLogNamespace=xkcd-webserver.canonical\nLogRateLimitIntervalSec=30\nLogRateLimitBurst=100\n.*`)
+}
+
+func (s *SnapTestSuite) TestGenerateSnapServiceInvalidLogLimit(c *C) {
+	service := ServiceYaml{
+		Name:     "xkcd-webserver",
+		Start:    "bin/foo start",
+		LogLimit: &LogLimit{MaxSize: "lots"},
+	}
+	m := packageYaml{Name: "xkcd-webserver", Version: "0.3.4"}
+
+	_, err := generateSnapServicesFile(service, "/apps/xkcd-webserver.canonical/0.3.4/", "aa", &m)
+	c.Assert(err, ErrorMatches, `invalid log-limit max-size "lots"`)
+}
+
+func (s *SnapTestSuite) TestAddPackageServicesWritesJournaldConf(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, packageHelloLogLimit)
+	c.Assert(err, IsNil)
+	m, err := parsePackageYamlFile(yamlFile)
+	c.Assert(err, IsNil)
+	baseDir := filepath.Dir(filepath.Dir(yamlFile))
+
+	inter := &MockProgressMeter{}
+	c.Assert(m.addPackageServices(baseDir, true, inter), IsNil)
+
+	fn := filepath.Join(dirs.SnapJournaldConfDir, "journald@"+helloAppComposedName+".conf")
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "[Journal]\nSystemMaxUse=10M\nRuntimeMaxUse=10M\n")
+
+	c.Assert(m.removePackageServices(baseDir, inter), IsNil)
+	_, err = os.Stat(fn)
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *SnapTestSuite) TestLogUsage(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, packageHelloLogLimit)
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+
+	journalDir := filepath.Join(s.tempdir, "var", "log", "journal", "0123456789abcdef."+helloAppComposedName)
+	c.Assert(os.MkdirAll(journalDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(journalDir, "system.journal"), make([]byte, 100), 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(journalDir, "system@1.journal"), make([]byte, 23), 0644), IsNil)
+
+	usage, err := LogUsage("hello-app")
+	c.Assert(err, IsNil)
+	c.Check(usage, Equals, int64(123))
+}
+
+func (s *SnapTestSuite) TestLogUsageNoNamespace(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+
+	_, err = LogUsage("hello-app")
+	c.Assert(err, Equals, ErrNoLogNamespace)
+}
@@ -0,0 +1,391 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/pkg"
+)
+
+// macBackend generates the mandatory access control policy for the
+// binaries and services of a snap from their SecurityDefinitions
+type macBackend interface {
+	// Name of the backend, e.g. "apparmor"
+	Name() string
+	// addPolicy installs the policy for the given binary or service
+	addPolicy(m *packageYaml, name string, sd SecurityDefinitions, baseDir string) error
+	// removePolicy removes the policy of the given binary or service
+	removePolicy(m *packageYaml, name, baseDir string) error
+}
+
+// the AppArmor policy is (still) generated by the click hooks, so
+// there is little for the backend to do here
+type apparmorBackend struct {
+	backend Backend
+}
+
+func (apparmorBackend) Name() string {
+	return "apparmor"
+}
+
+// addPolicy puts the profile the click hook generated in complain mode
+// for the snaps whose policy is not enforced
+func (a apparmorBackend) addPolicy(m *packageYaml, name string, sd SecurityDefinitions, baseDir string) error {
+	if m.enforced() {
+		return nil
+	}
+
+	profileName, err := getSecurityProfile(m, filepath.Base(name), baseDir)
+	if err != nil {
+		return err
+	}
+
+	fn := filepath.Join(dirs.SnapProfilesDir, "click_"+profileName)
+	if !helpers.FileExists(fn) {
+		// e.g. the hooks were inhibited
+		logger.Noticef("No AppArmor profile %q to put in complain mode", fn)
+		return nil
+	}
+
+	return complainAppArmorProfile(fn, a.backend)
+}
+
+// apparmorProfileHeader matches the line that starts a profile, with its
+// flags (if any)
+var apparmorProfileHeader = regexp.MustCompile(`(?m)^([ \t]*profile[ \t]+\S+)[ \t]*(?:flags=)?(?:\(([^)]*)\))?[ \t]*\{`)
+
+// addComplainFlag adds the complain flag to the profiles of the given
+// AppArmor policy
+func addComplainFlag(policy []byte) []byte {
+	return apparmorProfileHeader.ReplaceAllFunc(policy, func(header []byte) []byte {
+		match := apparmorProfileHeader.FindSubmatch(header)
+
+		flags := []string{}
+		for _, flag := range strings.Split(string(match[2]), ",") {
+			flag = strings.TrimSpace(flag)
+			if flag == "complain" {
+				return header
+			}
+			if flag != "" {
+				flags = append(flags, flag)
+			}
+		}
+		flags = append(flags, "complain")
+
+		return []byte(fmt.Sprintf("%s flags=(%s) {", match[1], strings.Join(flags, ",")))
+	})
+}
+
+// complainAppArmorProfile puts the profile in the given file in complain
+// mode, in the file itself so that it stays in complain mode when it
+// gets loaded again (from the file or its cache, e.g. on boot), and
+// loads it
+func complainAppArmorProfile(fn string, backend Backend) error {
+	policy, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return err
+	}
+
+	if !apparmorProfileHeader.Match(policy) {
+		return fmt.Errorf("cannot put %q in complain mode: no profile found", fn)
+	}
+	if err := helpers.AtomicWriteFile(fn, addComplainFlag(policy), 0644, 0); err != nil {
+		return err
+	}
+
+	return backend.RunMACCmd("apparmor_parser", "--replace", "--write-cache", "--cache-loc", dirs.SnapAppArmorCacheDir, fn)
+}
+
+// complainUnenforcedProfiles puts the profiles of the active snaps
+// whose policy is not enforced in complain mode again, after the click
+// hook generated them all anew
+func complainUnenforcedProfiles(backend Backend) error {
+	if backend.MACSystem() != "apparmor" {
+		return nil
+	}
+
+	active, err := InstalledByType(InstalledOptions{ActiveOnly: true}, pkg.TypeApp, pkg.TypeFramework, pkg.TypeGadget, pkg.TypeOem)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range active {
+		part, ok := p.(*SnapPart)
+		if !ok || part.m.enforced() {
+			continue
+		}
+		for _, svc := range part.m.ServiceYamls {
+			if err := (apparmorBackend{backend}).addPolicy(part.m, svc.Name, svc.SecurityDefinitions, part.basedir); err != nil {
+				return err
+			}
+		}
+		for _, bin := range part.m.Binaries {
+			if err := (apparmorBackend{backend}).addPolicy(part.m, bin.Name, bin.SecurityDefinitions, part.basedir); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (apparmorBackend) removePolicy(*packageYaml, string, string) error {
+	return nil
+}
+
+// selinuxBackend generates a SELinux policy module per binary/service
+// for systems without AppArmor
+type selinuxBackend struct {
+	backend Backend
+}
+
+func (selinuxBackend) Name() string {
+	return "selinux"
+}
+
+var selinuxInvalidChars = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// selinuxModuleName turns a security profile name (like
+// foo.origin_bar_1.0) into a valid SELinux module name
+func selinuxModuleName(profileName string) string {
+	return "snappy_" + selinuxInvalidChars.ReplaceAllString(profileName, "_")
+}
+
+// selinuxCapRules maps the caps to the rules for the domain (that
+// replaces @{DOMAIN})
+var selinuxCapRules = map[string][]string{
+	"network-client": {
+		"allow @{DOMAIN} self:tcp_socket { create connect getattr getopt setopt read write shutdown };",
+		"allow @{DOMAIN} self:udp_socket { create connect getattr getopt setopt read write };",
+	},
+	"network-service": {
+		"allow @{DOMAIN} self:tcp_socket { create bind listen accept getattr getopt setopt read write shutdown };",
+		"allow @{DOMAIN} self:udp_socket { create bind getattr getopt setopt read write };",
+	},
+}
+
+func generateSELinuxModule(module string, sd SecurityDefinitions, confinement pkg.Confinement) []byte {
+	domain := module + "_t"
+	execType := module + "_exec_t"
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "module %s 1.0;\n\n", module)
+	buf.WriteString(`require {
+	attribute domain;
+	attribute file_type;
+	attribute exec_type;
+	type init_t;
+	type unconfined_t;
+	role system_r;
+	role unconfined_r;
+	class file { entrypoint execute getattr open read };
+	class process transition;
+	class tcp_socket { create connect bind listen accept getattr getopt setopt read write shutdown };
+	class udp_socket { create connect bind getattr getopt setopt read write };
+}
+
+`)
+	fmt.Fprintf(&buf, "type %s;\n", domain)
+	fmt.Fprintf(&buf, "typeattribute %s domain;\n", domain)
+	fmt.Fprintf(&buf, "type %s;\n", execType)
+	fmt.Fprintf(&buf, "typeattribute %s file_type, exec_type;\n", execType)
+	fmt.Fprintf(&buf, "role system_r types %s;\n", domain)
+	fmt.Fprintf(&buf, "role unconfined_r types %s;\n\n", domain)
+	if confinement == pkg.ConfinementDevMode {
+		// only log what the policy denies
+		fmt.Fprintf(&buf, "permissive %s;\n\n", domain)
+	}
+
+	// the domain is entered by running its executable, from init
+	// (for services) or from the session of the user (for binaries)
+	fmt.Fprintf(&buf, "allow %s %s:file { entrypoint execute getattr open read };\n", domain, execType)
+	for _, source := range []string{"init_t", "unconfined_t"} {
+		fmt.Fprintf(&buf, "allow %s %s:file { execute getattr open read };\n", source, execType)
+		fmt.Fprintf(&buf, "allow %s %s:process transition;\n", source, domain)
+		fmt.Fprintf(&buf, "type_transition %s %s:process %s;\n", source, execType, domain)
+	}
+	buf.WriteString("\n")
+
+	if sd.SecurityPolicy != nil || sd.SecurityOverride != nil {
+		// hand-crafted and overridden policies are AppArmor
+		// (and seccomp) specific, there is nothing to translate
+		logger.Noticef("Using the default SELinux policy for %s", module)
+	}
+
+	caps := sd.SecurityCaps
+	if sd.SecurityTemplate == "" && caps == nil {
+		caps = defaultPolicyGroups
+	}
+	for _, cap := range caps {
+		rules, ok := selinuxCapRules[cap]
+		if !ok {
+			fmt.Fprintf(&buf, "# cap %q has no SELinux equivalent\n", cap)
+			continue
+		}
+		for _, rule := range rules {
+			buf.WriteString(strings.Replace(rule, "@{DOMAIN}", domain, -1) + "\n")
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// selinuxEntrypoint returns the executable the binary or service with
+// the given name runs, or "" if it has none
+func selinuxEntrypoint(m *packageYaml, name, baseDir string) string {
+	command := ""
+	for _, bin := range m.Binaries {
+		if bin.Name == name {
+			command = bin.Exec
+		}
+	}
+	for _, svc := range m.ServiceYamls {
+		if svc.Name == name {
+			command = svc.Start
+		}
+	}
+
+	fields := strings.Fields(archSpecificCommand(baseDir, command))
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return filepath.Join(baseDir, fields[0])
+}
+
+// generateSELinuxFileContexts labels the entrypoint of the module with
+// its exec type
+func generateSELinuxFileContexts(module, entrypoint string) []byte {
+	if entrypoint == "" {
+		return nil
+	}
+
+	return []byte(fmt.Sprintf("%s\t--\tsystem_u:object_r:%s_exec_t:s0\n", regexp.QuoteMeta(stripGlobalRootDir(entrypoint)), module))
+}
+
+// runMACCmd runs a tool of the MAC system
+func runMACCmd(argv ...string) error {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v failed: %v (%q)", argv, err, output)
+	}
+
+	return nil
+}
+
+func (se selinuxBackend) addPolicy(m *packageYaml, name string, sd SecurityDefinitions, baseDir string) error {
+	if m.confinement() == pkg.ConfinementClassic {
+		// classic snaps stay in the domain they are run from
+		return nil
+	}
+
+	profileName, err := getSecurityProfile(m, filepath.Base(name), baseDir)
+	if err != nil {
+		return err
+	}
+	module := selinuxModuleName(profileName)
+
+	if err := os.MkdirAll(dirs.SnapSELinuxDir, 0755); err != nil {
+		return err
+	}
+	base := filepath.Join(dirs.SnapSELinuxDir, module)
+	if err := helpers.AtomicWriteFile(base+".te", generateSELinuxModule(module, sd, m.confinement()), 0644, 0); err != nil {
+		return err
+	}
+	entrypoint := selinuxEntrypoint(m, name, baseDir)
+	if err := helpers.AtomicWriteFile(base+".fc", generateSELinuxFileContexts(module, entrypoint), 0644, 0); err != nil {
+		return err
+	}
+
+	// compile, package and load the module, and label the
+	// entrypoint with the file contexts it brings
+	cmds := [][]string{
+		{"checkmodule", "-M", "-m", "-o", base + ".mod", base + ".te"},
+		{"semodule_package", "-o", base + ".pp", "-m", base + ".mod", "-f", base + ".fc"},
+		{"semodule", "-i", base + ".pp"},
+	}
+	if entrypoint != "" {
+		cmds = append(cmds, []string{"restorecon", entrypoint})
+	}
+	for _, argv := range cmds {
+		if err := se.backend.RunMACCmd(argv...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (se selinuxBackend) removePolicy(m *packageYaml, name, baseDir string) error {
+	profileName, err := getSecurityProfile(m, filepath.Base(name), baseDir)
+	if err != nil {
+		return err
+	}
+	module := selinuxModuleName(profileName)
+
+	base := filepath.Join(dirs.SnapSELinuxDir, module)
+	if !helpers.FileExists(base + ".pp") {
+		return nil
+	}
+	if err := se.backend.RunMACCmd("semodule", "-r", module); err != nil {
+		return err
+	}
+	for _, ext := range []string{".te", ".fc", ".mod", ".pp"} {
+		if err := os.Remove(base + ext); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// macBackendOf returns the MAC backend for the MAC system of the
+// given Backend
+func macBackendOf(backend Backend) macBackend {
+	if backend.MACSystem() == "selinux" {
+		return selinuxBackend{backend}
+	}
+
+	return apparmorBackend{backend}
+}
+
+// detectMACSystem picks SELinux only on systems that have it but no
+// AppArmor; AppArmor remains the default. Only the selected backend
+// generates policy: the AppArmor click hook and aa-clickhook are
+// skipped on SELinux systems
+func detectMACSystem() string {
+	root := dirs.GlobalRootDir
+	if !helpers.FileExists(filepath.Join(root, "/sys/kernel/security/apparmor")) && helpers.FileExists(filepath.Join(root, "/sys/fs/selinux")) {
+		return "selinux"
+	}
+
+	return "apparmor"
+}
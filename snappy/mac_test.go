@@ -0,0 +1,154 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg"
+)
+
+func (s *SnapTestSuite) TestSELinuxModuleName(c *C) {
+	c.Check(selinuxModuleName("foo.mvo_bar-baz_1.0"), Equals, "snappy_foo_mvo_bar_baz_1_0")
+}
+
+func (s *SnapTestSuite) TestGenerateSELinuxModuleDefaultCaps(c *C) {
+	content := string(generateSELinuxModule("snappy_foo", SecurityDefinitions{}, pkg.ConfinementStrict))
+
+	c.Check(strings.HasPrefix(content, "module snappy_foo 1.0;\n"), Equals, true)
+	c.Check(content, Matches, `(?s).*type snappy_foo_t;\n.*`)
+	c.Check(content, Matches, `(?s).*allow snappy_foo_t self:tcp_socket \{ create connect .*`)
+}
+
+func (s *SnapTestSuite) TestGenerateSELinuxModuleEntrypoint(c *C) {
+	content := string(generateSELinuxModule("snappy_foo", SecurityDefinitions{}, pkg.ConfinementStrict))
+
+	c.Check(content, Matches, `(?s).*type snappy_foo_exec_t;\ntypeattribute snappy_foo_exec_t file_type, exec_type;\n.*`)
+	c.Check(content, Matches, `(?s).*allow snappy_foo_t snappy_foo_exec_t:file \{ entrypoint .*`)
+	c.Check(content, Matches, `(?s).*type_transition init_t snappy_foo_exec_t:process snappy_foo_t;\n.*`)
+	c.Check(content, Matches, `(?s).*type_transition unconfined_t snappy_foo_exec_t:process snappy_foo_t;\n.*`)
+	c.Check(content, Matches, `(?s).*role system_r types snappy_foo_t;\n.*`)
+	c.Check(strings.Contains(content, "permissive"), Equals, false)
+
+	content = string(generateSELinuxModule("snappy_foo", SecurityDefinitions{}, pkg.ConfinementDevMode))
+	c.Check(content, Matches, `(?s).*\npermissive snappy_foo_t;\n.*`)
+}
+
+func (s *SnapTestSuite) TestGenerateSELinuxFileContexts(c *C) {
+	entrypoint := filepath.Join(dirs.SnapAppsDir, "foo.mvo", "1.0", "bin", "foo")
+	c.Check(string(generateSELinuxFileContexts("snappy_foo", entrypoint)), Equals, "/apps/foo\\.mvo/1\\.0/bin/foo\t--\tsystem_u:object_r:snappy_foo_exec_t:s0\n")
+	c.Check(generateSELinuxFileContexts("snappy_foo", ""), IsNil)
+}
+
+func (s *SnapTestSuite) TestGenerateSELinuxModuleUnknownCap(c *C) {
+	content := string(generateSELinuxModule("snappy_foo", SecurityDefinitions{SecurityCaps: []string{"foo-cap"}}, pkg.ConfinementStrict))
+
+	c.Check(content, Matches, `(?s).*# cap "foo-cap" has no SELinux equivalent\n.*`)
+	c.Check(strings.Contains(content, " self:"), Equals, false)
+}
+
+func (s *SnapTestSuite) TestDetectMACSystem(c *C) {
+	c.Check(detectMACSystem(), Equals, "apparmor")
+
+	c.Assert(os.MkdirAll(filepath.Join(s.tempdir, "sys", "fs", "selinux"), 0755), IsNil)
+	c.Check(detectMACSystem(), Equals, "selinux")
+
+	c.Assert(os.MkdirAll(filepath.Join(s.tempdir, "sys", "kernel", "security", "apparmor"), 0755), IsNil)
+	c.Check(detectMACSystem(), Equals, "apparmor")
+}
+
+func (s *SnapTestSuite) TestMACBackendOf(c *C) {
+	c.Check(macBackendOf(s.backend).Name(), Equals, "apparmor")
+
+	s.backend.macSystem = "selinux"
+	c.Check(macBackendOf(s.backend).Name(), Equals, "selinux")
+}
+
+func (s *SnapTestSuite) TestPackageYamlSELinuxPolicy(c *C) {
+	m, err := parsePackageYamlData([]byte(`name: foo
+version: 1.0
+vendor: foo
+binaries:
+ - name: foo
+`), false)
+	c.Assert(err, IsNil)
+
+	var cmds [][]string
+	s.backend.runMACCmd = func(argv ...string) error {
+		cmds = append(cmds, argv)
+		return nil
+	}
+	s.backend.macSystem = "selinux"
+	dirs.SnapSeccompDir = c.MkDir()
+
+	base := filepath.Join(dirs.SnapSELinuxDir, "snappy_foo_mvo_foo_1_0")
+	baseDir := filepath.Join(dirs.SnapAppsDir, "foo.mvo", "1.0")
+	c.Assert(m.addSecurityPolicy(baseDir, s.backend), IsNil)
+	c.Check(helpers.FileExists(base+".te"), Equals, true)
+	fc, err := ioutil.ReadFile(base + ".fc")
+	c.Assert(err, IsNil)
+	c.Check(string(fc), Matches, `/apps/foo\\\.mvo/1\\\.0/foo\t--\t.*:snappy_foo_mvo_foo_1_0_exec_t:s0\n`)
+	c.Assert(cmds, HasLen, 4)
+	c.Check(cmds[1], DeepEquals, []string{"semodule_package", "-o", base + ".pp", "-m", base + ".mod", "-f", base + ".fc"})
+	c.Check(cmds[2], DeepEquals, []string{"semodule", "-i", base + ".pp"})
+	c.Check(cmds[3], DeepEquals, []string{"restorecon", filepath.Join(baseDir, "foo")})
+
+	// pretend the module got packaged
+	c.Assert(ioutil.WriteFile(base+".pp", nil, 0644), IsNil)
+
+	cmds = nil
+	c.Assert(m.removeSecurityPolicy(baseDir, s.backend), IsNil)
+	c.Check(cmds, DeepEquals, [][]string{{"semodule", "-r", "snappy_foo_mvo_foo_1_0"}})
+	c.Check(helpers.FileExists(base+".te"), Equals, false)
+	c.Check(helpers.FileExists(base+".fc"), Equals, false)
+	c.Check(helpers.FileExists(base+".pp"), Equals, false)
+
+	// classic snaps get no module
+	m.Confinement = pkg.ConfinementClassic
+	c.Assert(m.addSecurityPolicy(baseDir, s.backend), IsNil)
+	c.Check(cmds, HasLen, 1)
+	c.Check(helpers.FileExists(base+".te"), Equals, false)
+}
+
+func (s *SnapTestSuite) TestSELinuxSkipsAppArmor(c *C) {
+	s.backend.macSystem = "selinux"
+	s.backend.aaClickHook = func(args ...string) ([]byte, error) {
+		c.Fatal("aa-clickhook should not run")
+		return nil, nil
+	}
+	c.Check(regenerateAppArmorRules(s.backend), IsNil)
+
+	makeClickHook(c, "Hook-Name: apparmor\nPattern: /var/lib/apparmor/click/${id}\n")
+	instDir := c.MkDir()
+	m := &packageYaml{
+		Name:        "foo",
+		Version:     "1.0",
+		Integration: map[string]clickAppHook{"app": {"apparmor": "path-to-apparmor-file"}},
+	}
+	c.Assert(installClickHooks(instDir, m, testOrigin, false, s.backend), IsNil)
+	c.Check(helpers.FileExists(filepath.Join(s.tempdir, "var", "lib", "apparmor", "click", "foo."+testOrigin+"_app_1.0")), Equals, false)
+}
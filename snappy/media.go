@@ -0,0 +1,99 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// ScreenshotURLs returns the URLs of the screenshots of the snap in the
+// store
+func (s *RemoteSnapPart) ScreenshotURLs() []string {
+	return s.pkg.ScreenshotURLs
+}
+
+// VideoURLs returns the URLs of the videos of the snap in the store
+func (s *RemoteSnapPart) VideoURLs() []string {
+	return s.pkg.VideoURLs
+}
+
+// screenshotPath returns the path in dir of the n-th screenshot of the
+// snap, which has the extension of the URL (png if none)
+func screenshotPath(s Part, dir, rawurl string, n int) string {
+	ext := ".png"
+	if u, err := url.Parse(rawurl); err == nil && path.Ext(u.Path) != "" {
+		ext = path.Ext(u.Path)
+	}
+
+	return filepath.Join(dir, fmt.Sprintf("%s_%s_%d%s", QualifiedName(s), s.Version(), n, ext))
+}
+
+// DownloadScreenshots downloads the screenshots of the snap into the
+// given directory (unless they are there already) and returns their
+// paths, in the order of ScreenshotURLs
+func (s *RemoteSnapPart) DownloadScreenshots(dir string, pbar progress.Meter) ([]string, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, len(s.pkg.ScreenshotURLs))
+	for i, rawurl := range s.pkg.ScreenshotURLs {
+		paths[i] = screenshotPath(s, dir, rawurl, i)
+		if helpers.FileExists(paths[i]) {
+			continue
+		}
+		if err := s.downloadScreenshot(rawurl, paths[i], pbar); err != nil {
+			return nil, err
+		}
+	}
+
+	return paths, nil
+}
+
+// downloadScreenshot downloads the screenshot at the given URL to the
+// given path, leaving nothing there if it fails
+func (s *RemoteSnapPart) downloadScreenshot(rawurl, target string, pbar progress.Meter) (err error) {
+	w, err := os.OpenFile(target+".partial", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		w.Close()
+		if err != nil {
+			os.Remove(w.Name())
+		}
+	}()
+
+	if err := s.fetch("screenshot for package", rawurl, false, w, pbar); err != nil {
+		return err
+	}
+	if err := w.Sync(); err != nil {
+		return err
+	}
+
+	return os.Rename(w.Name(), target)
+}
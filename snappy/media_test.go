@@ -0,0 +1,100 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/pkg/remote"
+)
+
+func (s *SnapTestSuite) TestRemoteSnapMediaURLs(c *C) {
+	var pkg remote.Snap
+	c.Assert(json.Unmarshal([]byte(`{
+  "package_name": "foo",
+  "screenshot_urls": ["https://example.com/1.png", "https://example.com/2.jpg"],
+  "video_urls": ["https://example.com/v.mp4"]
+}`), &pkg), IsNil)
+
+	snap := NewRemoteSnapPart(pkg)
+	c.Check(snap.ScreenshotURLs(), DeepEquals, []string{"https://example.com/1.png", "https://example.com/2.jpg"})
+	c.Check(snap.VideoURLs(), DeepEquals, []string{"https://example.com/v.mp4"})
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadScreenshots(c *C) {
+	var requests []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		io.WriteString(w, "screenshot "+r.URL.Path)
+	}))
+	defer mockServer.Close()
+
+	snap := NewRemoteSnapPart(remote.Snap{
+		Name:           "foo",
+		Origin:         testOrigin,
+		Version:        "1.0",
+		ScreenshotURLs: []string{mockServer.URL + "/1.jpg", mockServer.URL + "/2"},
+	})
+	dir := c.MkDir()
+
+	paths, err := snap.DownloadScreenshots(dir, &MockProgressMeter{})
+	c.Assert(err, IsNil)
+	c.Check(paths, DeepEquals, []string{
+		filepath.Join(dir, "foo.testspacethename_1.0_0.jpg"),
+		filepath.Join(dir, "foo.testspacethename_1.0_1.png"),
+	})
+	content, err := ioutil.ReadFile(paths[1])
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "screenshot /2")
+
+	// the second time they are there already
+	_, err = snap.DownloadScreenshots(dir, &MockProgressMeter{})
+	c.Assert(err, IsNil)
+	c.Check(requests, DeepEquals, []string{"/1.jpg", "/2"})
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadScreenshotsFails(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer mockServer.Close()
+
+	snap := NewRemoteSnapPart(remote.Snap{
+		Name:           "foo",
+		Origin:         testOrigin,
+		Version:        "1.0",
+		ScreenshotURLs: []string{mockServer.URL + "/1.png"},
+	})
+	dir := c.MkDir()
+
+	_, err := snap.DownloadScreenshots(dir, &MockProgressMeter{})
+	c.Assert(err, NotNil)
+
+	entries, err := ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Check(entries, HasLen, 0)
+}
@@ -0,0 +1,116 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/logger"
+)
+
+// metadataKey returns the "<qualified name>_<version>" prefix used for
+// the icon and manifest file names of a snap
+func metadataKey(qn, version string) string {
+	return fmt.Sprintf("%s_%s", qn, version)
+}
+
+// removeMetadata removes the store icon and manifest of the given
+// snap version, and its reverted mark (if any)
+func removeMetadata(qn, version string) {
+	key := metadataKey(qn, version)
+	for _, fn := range []string{
+		filepath.Join(dirs.SnapIconsDir, key+".png"),
+		filepath.Join(dirs.SnapMetaDir, key+".manifest"),
+		revertedFile(qn, version),
+	} {
+		if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+			logger.Noticef("Failed to remove %q: %v", fn, err)
+		}
+	}
+}
+
+// OrphanedMetadata returns the icons and manifests of snaps that are
+// neither installed nor in the trash anymore
+func OrphanedMetadata() ([]string, error) {
+	known := make(map[string]bool)
+
+	installed, err := NewMetaLocalRepository().Installed()
+	if err != nil {
+		return nil, err
+	}
+	for _, part := range installed {
+		known[metadataKey(QualifiedName(part), part.Version())] = true
+	}
+
+	trashed, err := TrashedSnaps()
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range trashed {
+		known[metadataKey(filepath.Base(filepath.Dir(t.Basedir)), t.Version)] = true
+	}
+
+	var orphans []string
+	for _, glob := range []string{
+		filepath.Join(dirs.SnapIconsDir, "*_*.png"),
+		filepath.Join(dirs.SnapMetaDir, "*_*.manifest"),
+	} {
+		matches, err := filepath.Glob(glob)
+		if err != nil {
+			return nil, err
+		}
+		for _, fn := range matches {
+			base := filepath.Base(fn)
+			key := strings.TrimSuffix(base, filepath.Ext(base))
+			if !known[key] {
+				orphans = append(orphans, fn)
+			}
+		}
+	}
+
+	return orphans, nil
+}
+
+// PruneMetadata removes the icons and manifests of snaps that are
+// neither installed nor in the trash anymore, and returns the number
+// of bytes freed
+func PruneMetadata() (freed int64, err error) {
+	orphans, err := OrphanedMetadata()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, fn := range orphans {
+		st, err := os.Stat(fn)
+		if err != nil {
+			return freed, err
+		}
+		if err := os.Remove(fn); err != nil {
+			return freed, err
+		}
+		freed += st.Size()
+	}
+
+	return freed, nil
+}
@@ -0,0 +1,74 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg"
+)
+
+func (s *SnapTestSuite) TestRemovePermanentlyRemovesManifest(c *C) {
+	makeTwoTestSnaps(c, pkg.TypeApp)
+	manifest := filepath.Join(dirs.SnapMetaDir, fooComposedName+"_2.0.manifest")
+	c.Assert(helpers.FileExists(manifest), Equals, true)
+
+	c.Assert(Remove("foo", DoRemovePermanently, s.meter()), IsNil)
+	c.Check(helpers.FileExists(manifest), Equals, false)
+}
+
+func (s *SnapTestSuite) TestPruneMetadata(c *C) {
+	makeTwoTestSnaps(c, pkg.TypeApp)
+	c.Assert(os.MkdirAll(dirs.SnapIconsDir, 0755), IsNil)
+
+	orphanIcon := filepath.Join(dirs.SnapIconsDir, "bar.baz_1.0.png")
+	c.Assert(ioutil.WriteFile(orphanIcon, []byte("1234"), 0644), IsNil)
+	orphanManifest := filepath.Join(dirs.SnapMetaDir, "bar.baz_1.0.manifest")
+	c.Assert(ioutil.WriteFile(orphanManifest, []byte("123456"), 0644), IsNil)
+	installedIcon := filepath.Join(dirs.SnapIconsDir, fooComposedName+"_2.0.png")
+	c.Assert(ioutil.WriteFile(installedIcon, []byte("1"), 0644), IsNil)
+
+	orphans, err := OrphanedMetadata()
+	c.Assert(err, IsNil)
+	c.Check(orphans, DeepEquals, []string{orphanIcon, orphanManifest})
+
+	freed, err := PruneMetadata()
+	c.Assert(err, IsNil)
+	c.Check(freed, Equals, int64(10))
+	c.Check(helpers.FileExists(orphanIcon), Equals, false)
+	c.Check(helpers.FileExists(orphanManifest), Equals, false)
+	c.Check(helpers.FileExists(installedIcon), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapMetaDir, fooComposedName+"_1.0.manifest")), Equals, true)
+}
+
+func (s *SnapTestSuite) TestPruneMetadataKeepsTrashed(c *C) {
+	makeTwoTestSnaps(c, pkg.TypeApp)
+	c.Assert(Remove("foo", 0, s.meter()), IsNil)
+
+	orphans, err := OrphanedMetadata()
+	c.Assert(err, IsNil)
+	c.Check(orphans, HasLen, 0)
+}
@@ -0,0 +1,166 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// MigrationResult is the outcome of regenerating the files of a snap
+// in MigrateGeneratedState
+type MigrationResult struct {
+	Snap string
+	Err  error
+}
+
+func generatedReleaseFile() string {
+	return filepath.Join(dirs.GlobalRootDir, dirs.SnappyDir, "generated-release")
+}
+
+// GeneratedStateRelease returns the release (its series and flavor, as
+// release.Release.String gives them) the files snappy generated for the
+// installed snaps were (last) generated for, or "" if that is unknown
+func GeneratedStateRelease() string {
+	content, err := ioutil.ReadFile(generatedReleaseFile())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Noticef("Failed to read %s: %v", generatedReleaseFile(), err)
+		}
+		return ""
+	}
+
+	return strings.TrimSpace(string(content))
+}
+
+func setGeneratedStateRelease(rel string) error {
+	fn := generatedReleaseFile()
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+
+	return helpers.AtomicWriteFile(fn, []byte(rel+"\n"), 0644, 0)
+}
+
+// regenerate writes the files snappy generates for the (active) snap
+// again: the click hook symlinks, the security profiles, the binary
+// wrappers, the services and, for the oem snap, its udev rules
+func (s *SnapPart) regenerate(inter interacter) error {
+	if err := installClickHooks(s.basedir, s.m, s.origin, true, backendOf(inter)); err != nil {
+		return err
+	}
+
+	if err := s.m.addSecurityPolicy(s.basedir, backendOf(inter)); err != nil {
+		return err
+	}
+
+	if err := s.m.addPackageBinaries(s.basedir); err != nil {
+		return err
+	}
+
+	if err := s.m.addExportedBinaries(s.basedir); err != nil {
+		return err
+	}
+
+	if err := s.m.addPackageServices(s.basedir, true, inter); err != nil {
+		return err
+	}
+
+	if s.Type().IsGadget() {
+		if err := writeOemHardwareUdevRules(s.m); err != nil {
+			return err
+		}
+		if err := writeApparmorAdditionalFile(s.m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MigrateGeneratedState regenerates the files snappy generated for the
+// active snaps after the system was upgraded from one release (series
+// and flavor, as release.Release.String gives them) to another, as the
+// ones generated for the old release may target policy versions the
+// new one does not have. It returns the result for each snap, and only
+// records the migration as done if all of them (and reloading the rules
+// and units) succeeded, so it runs again otherwise.
+//
+// An unknown old release (fromSeries is "") is that of the snappy that
+// did not record it yet: what it generated is taken to be for the
+// current release, which is only recorded.
+func MigrateGeneratedState(fromSeries, toSeries string, inter progress.Meter) ([]MigrationResult, error) {
+	if fromSeries == "" {
+		return nil, setGeneratedStateRelease(toSeries)
+	}
+	if fromSeries == toSeries {
+		return nil, nil
+	}
+
+	logger.Noticef("Regenerating the generated files of the snaps for the upgrade from %q to %q", fromSeries, toSeries)
+
+	parts, err := ActiveSnapsByType(pkg.TypeApp, pkg.TypeFramework, pkg.TypeGadget, pkg.TypeOem)
+	if err != nil {
+		return nil, err
+	}
+
+	failed := false
+	results := make([]MigrationResult, 0, len(parts))
+	for _, part := range parts {
+		snap, ok := part.(*SnapPart)
+		if !ok {
+			continue
+		}
+
+		err := snap.regenerate(inter)
+		if err != nil {
+			failed = true
+		}
+		results = append(results, MigrationResult{Snap: QualifiedName(snap), Err: err})
+	}
+
+	backend := backendOf(inter)
+
+	// the apparmor profiles are generated from the click hooks
+	if err := regenerateAppArmorRules(backend); err != nil {
+		return results, err
+	}
+
+	if err := newSystemd(inter).DaemonReload(); err != nil {
+		return results, err
+	}
+	if err := activateOemHardwareUdevRules(backend); err != nil {
+		return results, err
+	}
+
+	if failed {
+		return results, nil
+	}
+
+	return results, setGeneratedStateRelease(toSeries)
+}
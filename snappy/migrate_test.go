@@ -0,0 +1,101 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+func (s *SnapTestSuite) TestMigrateGeneratedState(c *C) {
+	yamlFile, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+
+	aaRegenerated := false
+	s.backend.aaClickHook = func(args ...string) ([]byte, error) {
+		aaRegenerated = true
+		return nil, nil
+	}
+
+	c.Check(GeneratedStateRelease(), Equals, "")
+	results, err := MigrateGeneratedState("15.04-core", "rolling-core", s.meter())
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[0].Snap, Equals, "hello-app."+testOrigin)
+	c.Check(results[0].Err, IsNil)
+	c.Check(aaRegenerated, Equals, true)
+
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapBinariesDir, "hello-app.hello")), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapServicesDir, "hello-app_svc1_1.10.service")), Equals, true)
+	c.Check(GeneratedStateRelease(), Equals, "rolling-core")
+}
+
+func (s *SnapTestSuite) TestMigrateGeneratedStateSameSeries(c *C) {
+	s.backend.aaClickHook = func(args ...string) ([]byte, error) {
+		c.Fatal("nothing should be regenerated")
+		return nil, nil
+	}
+
+	results, err := MigrateGeneratedState("rolling-core", "rolling-core", s.meter())
+	c.Check(err, IsNil)
+	c.Check(results, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestMigrateGeneratedStateFailureNotRecorded(c *C) {
+	s.backend.aaClickHook = func(args ...string) ([]byte, error) {
+		return nil, errors.New("aa-clickhook failed")
+	}
+
+	_, err := MigrateGeneratedState("15.04-core", "rolling-core", s.meter())
+	c.Check(err, ErrorMatches, "aa-clickhook failed")
+	c.Check(GeneratedStateRelease(), Equals, "")
+}
+
+func (s *SnapTestSuite) TestMigrateGeneratedStateFlavor(c *C) {
+	aaRegenerated := false
+	s.backend.aaClickHook = func(args ...string) ([]byte, error) {
+		aaRegenerated = true
+		return nil, nil
+	}
+
+	_, err := MigrateGeneratedState("rolling-core", "rolling-personal", s.meter())
+	c.Assert(err, IsNil)
+	c.Check(aaRegenerated, Equals, true)
+	c.Check(GeneratedStateRelease(), Equals, "rolling-personal")
+}
+
+func (s *SnapTestSuite) TestMigrateGeneratedStateSeedsUnknown(c *C) {
+	s.backend.aaClickHook = func(args ...string) ([]byte, error) {
+		c.Fatal("nothing should be regenerated")
+		return nil, nil
+	}
+
+	// as after the upgrade from a snappy that did not record it
+	results, err := MigrateGeneratedState("", "rolling-core", s.meter())
+	c.Assert(err, IsNil)
+	c.Check(results, HasLen, 0)
+	c.Check(GeneratedStateRelease(), Equals, "rolling-core")
+}
@@ -0,0 +1,189 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// mirrorProbeTimeout bounds the latency probe of a download URL
+var mirrorProbeTimeout = 2 * time.Second
+
+// downloadHostStats is what we know about the downloads from a host
+type downloadHostStats struct {
+	// failures is the number of failed downloads (it is reset on a
+	// successful download)
+	failures int
+	// latency of the last probe (-1 if the probe failed)
+	latency time.Duration
+}
+
+var (
+	downloadStatsMu sync.Mutex
+	downloadStats   = make(map[string]*downloadHostStats)
+)
+
+func hostStats(host string) *downloadHostStats {
+	stats, ok := downloadStats[host]
+	if !ok {
+		stats = &downloadHostStats{}
+		downloadStats[host] = stats
+	}
+
+	return stats
+}
+
+func urlHost(rawurl string) string {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return rawurl
+	}
+
+	return u.Host
+}
+
+// recordDownload accounts the outcome of a download from the given URL
+func recordDownload(rawurl string, err error) {
+	downloadStatsMu.Lock()
+	defer downloadStatsMu.Unlock()
+
+	stats := hostStats(urlHost(rawurl))
+	if err != nil {
+		stats.failures++
+	} else {
+		stats.failures = 0
+	}
+}
+
+var probeLatency = probeLatencyImpl
+
+// probeLatencyImpl returns how long a HEAD request to the URL takes (this
+// follows the redirects of CDNs to the actual host)
+func probeLatencyImpl(rawurl string) (time.Duration, error) {
+	client := &http.Client{Timeout: mirrorProbeTimeout}
+
+	start := time.Now()
+	resp, err := client.Head(rawurl)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	return time.Since(start), nil
+}
+
+type rankedURL struct {
+	url     string
+	stats   downloadHostStats
+	initial int
+}
+
+// byDownloadRank sorts the URLs with the fewest failures first, then by
+// latency (failed probes last), and keeps the order of the store otherwise
+type byDownloadRank []rankedURL
+
+func (r byDownloadRank) Len() int      { return len(r) }
+func (r byDownloadRank) Swap(a, b int) { r[a], r[b] = r[b], r[a] }
+func (r byDownloadRank) Less(a, b int) bool {
+	sa, sb := r[a].stats, r[b].stats
+	if sa.failures != sb.failures {
+		return sa.failures < sb.failures
+	}
+	if (sa.latency < 0) != (sb.latency < 0) {
+		return sb.latency < 0
+	}
+	if sa.latency != sb.latency {
+		return sa.latency < sb.latency
+	}
+
+	return r[a].initial < r[b].initial
+}
+
+// rankDownloadURLs probes the latency of the given http(s) URLs (in
+// parallel) and returns them best first; a single URL is not probed
+func rankDownloadURLs(urls []string) []string {
+	if len(urls) < 2 {
+		return urls
+	}
+
+	ranked := make([]rankedURL, len(urls))
+	var wg sync.WaitGroup
+	for i, rawurl := range urls {
+		ranked[i] = rankedURL{url: rawurl, initial: i}
+
+		u, err := url.Parse(rawurl)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+
+		wg.Add(1)
+		go func(r *rankedURL) {
+			defer wg.Done()
+			latency, err := probeLatency(r.url)
+			if err != nil {
+				latency = -1
+			}
+			r.stats.latency = latency
+		}(&ranked[i])
+	}
+	wg.Wait()
+
+	downloadStatsMu.Lock()
+	for i := range ranked {
+		stats := hostStats(urlHost(ranked[i].url))
+		stats.latency = ranked[i].stats.latency
+		ranked[i].stats.failures = stats.failures
+	}
+	downloadStatsMu.Unlock()
+
+	sort.Sort(byDownloadRank(ranked))
+
+	res := make([]string, len(ranked))
+	for i, r := range ranked {
+		res[i] = r.url
+	}
+
+	return res
+}
+
+// downloadURLs returns the URLs the snap can be downloaded from, the
+// primary one first
+func (s *RemoteSnapPart) downloadURLs() []string {
+	// the authenticated download when there are credentials (those
+	// of a buyer, say), the anonymous one otherwise unless the store
+	// insists on credentials
+	primary := s.pkg.AnonDownloadURL
+	if primary == "" || !s.AllowUnauthenticated() || (s.pkg.DownloadURL != "" && s.authenticated()) {
+		primary = s.pkg.DownloadURL
+	}
+
+	urls := []string{primary}
+	for _, mirror := range s.pkg.DownloadMirrors {
+		if mirror != primary {
+			urls = append(urls, mirror)
+		}
+	}
+
+	return urls
+}
@@ -0,0 +1,121 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *SnapTestSuite) mockProbeLatency(latencies map[string]time.Duration) {
+	downloadStats = make(map[string]*downloadHostStats)
+	probeLatency = func(rawurl string) (time.Duration, error) {
+		latency, ok := latencies[rawurl]
+		if !ok {
+			return 0, errors.New("unreachable")
+		}
+		return latency, nil
+	}
+}
+
+func (s *SnapTestSuite) TestRankDownloadURLs(c *C) {
+	s.mockProbeLatency(map[string]time.Duration{
+		"http://slow/snap": time.Second,
+		"http://fast/snap": time.Millisecond,
+	})
+	defer func() { probeLatency = probeLatencyImpl }()
+
+	urls := []string{"http://slow/snap", "http://down/snap", "http://fast/snap"}
+	c.Check(rankDownloadURLs(urls), DeepEquals, []string{"http://fast/snap", "http://slow/snap", "http://down/snap"})
+
+	// hosts that failed to deliver go last
+	recordDownload("http://fast/snap", errors.New("timeout"))
+	c.Check(rankDownloadURLs(urls), DeepEquals, []string{"http://slow/snap", "http://down/snap", "http://fast/snap"})
+
+	// until they succeed again
+	recordDownload("http://fast/snap", nil)
+	c.Check(rankDownloadURLs(urls)[0], Equals, "http://fast/snap")
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadFallsBackToMirror(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/mirror/snap":
+			io.WriteString(w, "snap")
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	primary := mockServer.URL + "/snap"
+	mirror := mockServer.URL + "/mirror/snap"
+	s.mockProbeLatency(map[string]time.Duration{primary: time.Millisecond, mirror: time.Second})
+	defer func() { probeLatency = probeLatencyImpl }()
+
+	snap := RemoteSnapPart{}
+	snap.pkg.Name = "foo"
+	snap.pkg.AnonDownloadURL = primary
+	snap.pkg.DownloadMirrors = []string{primary, mirror}
+
+	fn, err := snap.Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "snap")
+	c.Check(snap.downloadURLs(), DeepEquals, []string{primary, mirror})
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadNoCredentialsToMirrors(c *C) {
+	storeServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("Authorization"), Equals, "Macaroon m")
+		w.WriteHeader(404)
+	}))
+	defer storeServer.Close()
+	mirrorServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("Authorization"), Equals, "")
+		c.Check(r.Header.Get("X-Ubuntu-Architecture"), Equals, "")
+		io.WriteString(w, "snap")
+	}))
+	defer mirrorServer.Close()
+
+	primary := storeServer.URL + "/snap"
+	mirror := mirrorServer.URL + "/snap"
+	s.mockProbeLatency(map[string]time.Duration{primary: time.Millisecond, mirror: time.Second})
+	defer func() { probeLatency = probeLatencyImpl }()
+
+	snap := RemoteSnapPart{auth: &mockAuthenticator{macaroon: "m"}}
+	snap.pkg.Name = "foo"
+	snap.pkg.DownloadURL = primary
+	snap.pkg.DownloadMirrors = []string{mirror}
+
+	fn, err := snap.Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "snap")
+}
@@ -0,0 +1,113 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+// knownArchitectures are the architectures a snap can carry variants
+// of its commands for, in subdirectories named after them next to the
+// command (e.g. bin/armhf/foo for bin/foo)
+var knownArchitectures = []string{"amd64", "arm64", "armhf", "i386", "powerpc", "ppc64el"}
+
+// archVariant returns the variant of the command (a path relative to
+// the snap) for the given architecture if the snap has one, and the
+// command itself otherwise
+func archVariant(pkgPath, command, arch string) string {
+	variant := filepath.Join(filepath.Dir(command), arch, filepath.Base(command))
+	if helpers.FileExists(filepath.Join(pkgPath, variant)) {
+		return variant
+	}
+
+	return command
+}
+
+// archSpecificCommand replaces the executable of the command line with
+// its variant for the architecture of the system, if the snap has one
+func archSpecificCommand(pkgPath, cmdline string) string {
+	if cmdline == "" {
+		return cmdline
+	}
+
+	fields := strings.SplitN(cmdline, " ", 2)
+	fields[0] = archVariant(pkgPath, fields[0], helpers.UbuntuArchitecture())
+
+	return strings.Join(fields, " ")
+}
+
+// archVariants returns the architectures the snap in the given
+// directory has variants of the command for
+func archVariants(pkgPath, command string) []string {
+	var arches []string
+	for _, arch := range knownArchitectures {
+		if archVariant(pkgPath, command, arch) != command {
+			arches = append(arches, arch)
+		}
+	}
+
+	return arches
+}
+
+// verifyArchVariants checks that the commands of the snap in the given
+// directory that have variants per architecture can run on all the
+// architectures of the snap: either they have a variant for each of
+// them, or a generic version to fall back to
+func verifyArchVariants(pkgPath string, m *packageYaml) error {
+	var commands []string
+	for _, binary := range m.Binaries {
+		commands = append(commands, binary.Exec)
+	}
+	for _, service := range m.ServiceYamls {
+		for _, cmdline := range []string{service.Start, service.Stop, service.PostStop} {
+			if cmdline != "" {
+				commands = append(commands, strings.SplitN(cmdline, " ", 2)[0])
+			}
+		}
+	}
+
+	for _, command := range commands {
+		arches := archVariants(pkgPath, command)
+		if len(arches) == 0 || helpers.FileExists(filepath.Join(pkgPath, command)) {
+			continue
+		}
+
+		for _, arch := range m.Architectures {
+			if !hasArch(arches, arch) {
+				return &ErrArchVariantMissing{Command: command, Arch: arch, Variants: arches}
+			}
+		}
+	}
+
+	return nil
+}
+
+func hasArch(arches []string, arch string) bool {
+	for _, a := range arches {
+		if a == arch {
+			return true
+		}
+	}
+
+	return false
+}
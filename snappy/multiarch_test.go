@@ -0,0 +1,92 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+func makeArchVariants(c *C, pkgPath, command string, arches ...string) {
+	for _, arch := range arches {
+		fn := filepath.Join(pkgPath, filepath.Dir(command), arch, filepath.Base(command))
+		c.Assert(os.MkdirAll(filepath.Dir(fn), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(fn, nil, 0755), IsNil)
+	}
+}
+
+func (s *SnapTestSuite) TestArchSpecificCommand(c *C) {
+	pkgPath := c.MkDir()
+	arch := helpers.UbuntuArchitecture()
+
+	c.Check(archSpecificCommand(pkgPath, "bin/foo --bar"), Equals, "bin/foo --bar")
+	c.Check(archSpecificCommand(pkgPath, ""), Equals, "")
+
+	makeArchVariants(c, pkgPath, "bin/foo", arch)
+	c.Check(archSpecificCommand(pkgPath, "bin/foo --bar"), Equals, "bin/"+arch+"/foo --bar")
+	c.Check(archSpecificCommand(pkgPath, "bin/foo"), Equals, "bin/"+arch+"/foo")
+	c.Check(binPathForBinary(pkgPath, Binary{Name: "foo", Exec: "bin/foo"}), Equals, filepath.Join(pkgPath, "bin", arch, "foo"))
+}
+
+func (s *SnapTestSuite) TestArchVariants(c *C) {
+	pkgPath := c.MkDir()
+	makeArchVariants(c, pkgPath, "bin/foo", "armhf", "amd64")
+
+	c.Check(archVariants(pkgPath, "bin/foo"), DeepEquals, []string{"amd64", "armhf"})
+	c.Check(archVariants(pkgPath, "bin/bar"), HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestVerifyArchVariants(c *C) {
+	pkgPath := c.MkDir()
+	makeArchVariants(c, pkgPath, "bin/foo", "armhf", "amd64")
+	makeArchVariants(c, pkgPath, "bin/food", "armhf", "amd64")
+
+	m := &packageYaml{
+		Architectures: []string{"amd64", "armhf"},
+		Binaries:      []Binary{{Name: "foo", Exec: "bin/foo"}},
+		ServiceYamls:  []ServiceYaml{{Name: "food", Start: "bin/food --daemon"}},
+	}
+	c.Check(verifyArchVariants(pkgPath, m), IsNil)
+
+	m.Architectures = []string{"amd64", "armhf", "i386"}
+	err := verifyArchVariants(pkgPath, m)
+	c.Assert(err, FitsTypeOf, &ErrArchVariantMissing{})
+	c.Check(err.(*ErrArchVariantMissing).Arch, Equals, "i386")
+
+	m.Architectures = []string{"all"}
+	c.Check(verifyArchVariants(pkgPath, m), FitsTypeOf, &ErrArchVariantMissing{})
+}
+
+func (s *SnapTestSuite) TestVerifyArchVariantsGenericFallback(c *C) {
+	pkgPath := c.MkDir()
+	makeArchVariants(c, pkgPath, "bin/foo", "armhf")
+	c.Assert(ioutil.WriteFile(filepath.Join(pkgPath, "bin", "foo"), nil, 0755), IsNil)
+
+	m := &packageYaml{
+		Architectures: []string{"all"},
+		Binaries:      []Binary{{Name: "foo", Exec: "bin/foo"}},
+	}
+	c.Check(verifyArchVariants(pkgPath, m), IsNil)
+}
@@ -25,15 +25,16 @@ package snappy
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
 	"github.com/ubuntu-core/snappy/logger"
 	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/progress"
 )
 
 // OEM represents the structure inside the package.yaml for the oem component
@@ -50,11 +51,29 @@ type OEM struct {
 // Store holds information relevant to the store provided by an OEM snap
 type Store struct {
 	ID string `yaml:"id,omitempty"`
+	// DefaultOrigins are the origins preferred (in order) for the
+	// names given without one (see DefaultOrigins)
+	DefaultOrigins []string `yaml:"default-origins,omitempty"`
+	// Pinned pins the device to the store (gadget type only, see
+	// StorePinned)
+	Pinned bool `yaml:"pinned,omitempty"`
+	// Mirrors are the base URLs of replicas of the store, tried in
+	// order when it is unreachable (see StoreMirrors)
+	Mirrors []string `yaml:"mirrors,omitempty"`
 }
 
 // Software describes the installed software provided by an OEM snap
 type Software struct {
 	BuiltIn []string `yaml:"built-in,omitempty"`
+	// AllowedConfinement are the confinement levels of the snaps the
+	// device accepts (see AllowedConfinement)
+	AllowedConfinement []pkg.Confinement `yaml:"allowed-confinement,omitempty"`
+	// DataMode is the permissions and group of the data directories
+	// of the snaps that do not set their own (see DataMode)
+	DataMode *DataMode `yaml:"data-mode,omitempty"`
+	// Scanner is what the snaps get checked with for malware before
+	// they are installed (see SetScanner)
+	Scanner *ScannerConfig `yaml:"scanner,omitempty"`
 }
 
 // BootAssets represent all the artifacts required for booting a system
@@ -127,7 +146,7 @@ func (hw *HardwareAssign) generateUdevRuleContent() (string, error) {
 var getOem = getOemImpl
 
 func getOemImpl() (*packageYaml, error) {
-	oems, _ := ActiveSnapsByType(pkg.TypeOem)
+	oems, _ := ActiveSnapsByType(pkg.TypeGadget, pkg.TypeOem)
 	if len(oems) == 1 {
 		return oems[0].(*SnapPart).m, nil
 	}
@@ -157,14 +176,38 @@ func bootAssetFilePaths() map[string]string {
 	return fileList
 }
 
-// StoreID returns the store id setup by the oem package or an empty string
+// StoreID returns the store id setup by the oem package, or by the
+// store configuration of the system if the oem package has none, or an
+// empty string
 func StoreID() string {
+	if oem, err := getOem(); err == nil && oem.OEM.Store.ID != "" {
+		return oem.OEM.Store.ID
+	}
+
+	if config, err := storeConfig(); err == nil {
+		return config.ID
+	}
+
+	return ""
+}
+
+// storeMirrorsEnv overrides the store mirrors of the oem snap
+const storeMirrorsEnv = "SNAPPY_STORE_MIRRORS"
+
+// StoreMirrors returns the base URLs of the replicas of the store, in
+// the order they are tried when the store is unreachable: those of the
+// comma separated SNAPPY_STORE_MIRRORS if set, of the oem snap otherwise
+func StoreMirrors() []string {
+	if mirrors := os.Getenv(storeMirrorsEnv); mirrors != "" {
+		return strings.Split(mirrors, ",")
+	}
+
 	oem, err := getOem()
 	if err != nil {
-		return ""
+		return nil
 	}
 
-	return oem.OEM.Store.ID
+	return oem.OEM.Store.Mirrors
 }
 
 // IsBuiltInSoftware returns true if the package is part of the built-in software
@@ -220,7 +263,7 @@ func writeOemHardwareUdevRules(m *packageYaml) error {
 			return err
 		}
 		outfile := filepath.Join(dirs.SnapUdevRulesDir, fmt.Sprintf("80-snappy_%s_%s.rules", m.Name, h.PartID))
-		if err := ioutil.WriteFile(outfile, []byte(rulesContent), 0644); err != nil {
+		if err := helpers.AtomicWriteFile(outfile, []byte(rulesContent), 0644, 0); err != nil {
 			return err
 		}
 	}
@@ -228,22 +271,64 @@ func writeOemHardwareUdevRules(m *packageYaml) error {
 	return nil
 }
 
-// var to make testing easier
-var runUdevAdm = runUdevAdmImpl
+// udevSettleTimeout is how long to wait for udev to process the
+// events triggered for the new rules
+var udevSettleTimeout = 30 * time.Second
 
-func runUdevAdmImpl(args ...string) error {
-	cmd := exec.Command(args[0], args[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// runUdevAdm runs udevadm with the given args through the backend
+func runUdevAdm(backend Backend, args ...string) ([]byte, error) {
+	output, err := backend.UdevAdm(args...)
+	if err != nil {
+		return nil, &ErrUdevAdm{Args: append([]string{"udevadm"}, args...), Err: err}
+	}
+
+	return output, nil
 }
 
-func activateOemHardwareUdevRules() error {
-	if err := runUdevAdm("udevadm", "control", "--reload-rules"); err != nil {
+// streamUdevAdm runs udevadm with the given args through the backend,
+// streaming its output
+func streamUdevAdm(backend Backend, args ...string) error {
+	if err := backend.RunUdevAdm(args...); err != nil {
+		return &ErrUdevAdm{Args: append([]string{"udevadm"}, args...), Err: err}
+	}
+
+	return nil
+}
+
+// activateOemHardwareUdevRules makes udev apply the new rules to the
+// existing devices and waits (up to udevSettleTimeout) until it did
+func activateOemHardwareUdevRules(backend Backend) error {
+	if err := streamUdevAdm(backend, "control", "--reload-rules"); err != nil {
+		return err
+	}
+
+	if err := streamUdevAdm(backend, "trigger"); err != nil {
 		return err
 	}
 
-	return runUdevAdm("udevadm", "trigger")
+	return streamUdevAdm(backend, "settle", fmt.Sprintf("--timeout=%d", int(udevSettleTimeout/time.Second)))
+}
+
+// AssignedDevices returns the (sysfs paths of the) devices that are
+// assigned to the given part by the udev rules
+func AssignedDevices(partID string, meter progress.Meter) ([]string, error) {
+	return assignedDevices(backendOf(meter), partID)
+}
+
+func assignedDevices(backend Backend, partID string) ([]string, error) {
+	output, err := runUdevAdm(backend, "trigger", "--dry-run", "--verbose", "--tag-match=snappy-assign", "--property-match=SNAPPY_APP="+partID)
+	if err != nil {
+		return nil, err
+	}
+
+	var devices []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			devices = append(devices, line)
+		}
+	}
+
+	return devices, nil
 }
 
 const apparmorAdditionalContent = `{
@@ -269,7 +354,7 @@ func writeApparmorAdditionalFile(m *packageYaml) error {
 
 	for _, h := range m.OEM.Hardware.Assign {
 		jsonAdditionalPath := filepath.Join(dirs.SnapAppArmorDir, fmt.Sprintf("%s.json.additional", h.PartID))
-		if err := ioutil.WriteFile(jsonAdditionalPath, []byte(apparmorAdditionalContent), 0644); err != nil {
+		if err := helpers.AtomicWriteFile(jsonAdditionalPath, []byte(apparmorAdditionalContent), 0644, 0); err != nil {
 			return err
 		}
 	}
@@ -277,18 +362,68 @@ func writeApparmorAdditionalFile(m *packageYaml) error {
 	return nil
 }
 
-func installOemHardwareUdevRules(m *packageYaml) error {
+// assignDevices gives the part access to the given devices (on top of
+// what it has already)
+func assignDevices(partID string, devices []string) error {
+	appArmorAdditional, err := readHWAccessJSONFile(partID)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	assigned := make(map[string]bool)
+	for _, p := range appArmorAdditional.WritePath {
+		assigned[p] = true
+	}
+	for _, device := range devices {
+		if !assigned[device] {
+			appArmorAdditional.WritePath = append(appArmorAdditional.WritePath, device)
+			assigned[device] = true
+		}
+	}
+
+	return writeHWAccessJSONFile(partID, appArmorAdditional)
+}
+
+func installOemHardwareUdevRules(m *packageYaml, backend Backend) (err error) {
 	if err := writeOemHardwareUdevRules(m); err != nil {
 		return err
 	}
 
+	// the rules (and the access) are all there or not at all
+	defer func() {
+		if err == nil {
+			return
+		}
+		if err := cleanupOemHardwareUdevRules(m); err != nil {
+			logger.Noticef("Failed to remove the udev rules of %s: %v", m.Name, err)
+		}
+		if err := streamUdevAdm(backend, "control", "--reload-rules"); err != nil {
+			logger.Noticef("Failed to reload the udev rules: %v", err)
+		}
+	}()
+
 	if err := writeApparmorAdditionalFile(m); err != nil {
 		return err
 	}
 
-	if err := activateOemHardwareUdevRules(); err != nil {
+	if err := activateOemHardwareUdevRules(backend); err != nil {
 		return err
 	}
 
+	for _, h := range m.OEM.Hardware.Assign {
+		devices, err := assignedDevices(backend, h.PartID)
+		if err != nil {
+			return err
+		}
+		if len(devices) == 0 {
+			logger.Noticef("No devices assigned to %s", h.PartID)
+			continue
+		}
+		logger.Noticef("Devices assigned to %s: %s", h.PartID, strings.Join(devices, ", "))
+		if err := assignDevices(h.PartID, devices); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
@@ -45,8 +45,8 @@ func (s *OemSuite) SetUpTest(c *C) {
 	getOem = func() (*packageYaml, error) {
 		return &packageYaml{
 			OEM: OEM{
-				Software: Software{[]string{"makeuppackage", "anotherpackage"}},
-				Store:    Store{"ninjablocks"},
+				Software: Software{BuiltIn: []string{"makeuppackage", "anotherpackage"}},
+				Store:    Store{ID: "ninjablocks"},
 			},
 		}, nil
 	}
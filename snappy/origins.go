@@ -0,0 +1,96 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+)
+
+func defaultOriginsFile() string {
+	return filepath.Join(dirs.GlobalRootDir, dirs.SnappyDir, "default-origins.yaml")
+}
+
+// DefaultOrigins returns the origins that are preferred (in order) for
+// the names given without an origin, as set with SetDefaultOrigins or
+// else by the oem package
+func DefaultOrigins() []string {
+	content, err := ioutil.ReadFile(defaultOriginsFile())
+	if err == nil {
+		var origins []string
+		if err := yaml.Unmarshal(content, &origins); err == nil {
+			return origins
+		}
+		logger.Noticef("Failed to parse %s: %v", defaultOriginsFile(), err)
+	} else if !os.IsNotExist(err) {
+		logger.Noticef("Failed to read %s: %v", defaultOriginsFile(), err)
+	}
+
+	oem, err := getOem()
+	if err != nil {
+		return nil
+	}
+
+	return oem.OEM.Store.DefaultOrigins
+}
+
+// SetDefaultOrigins sets the origins that are preferred (in order) for
+// the names given without an origin; nil goes back to the ones of the
+// oem package
+func SetDefaultOrigins(origins []string) error {
+	fn := defaultOriginsFile()
+	if origins == nil {
+		if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	content, err := yaml.Marshal(origins)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+
+	return helpers.AtomicWriteFile(fn, content, 0644, 0)
+}
+
+// preferredOrigin returns the first of the DefaultOrigins that has one
+// of the given parts ("" if none of them does)
+func preferredOrigin(parts []Part) string {
+	for _, origin := range DefaultOrigins() {
+		for _, part := range parts {
+			if part.Origin() == origin {
+				return origin
+			}
+		}
+	}
+
+	return ""
+}
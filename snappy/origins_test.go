@@ -0,0 +1,136 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+func mockOemDefaultOrigins(origins ...string) func() {
+	getOem = func() (*packageYaml, error) {
+		return &packageYaml{OEM: OEM{Store: Store{DefaultOrigins: origins}}}, nil
+	}
+
+	return func() { getOem = getOemImpl }
+}
+
+func (s *SnapTestSuite) TestDefaultOriginsNone(c *C) {
+	c.Check(DefaultOrigins(), HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestDefaultOriginsFromOem(c *C) {
+	defer mockOemDefaultOrigins("alice", "bob")()
+
+	c.Check(DefaultOrigins(), DeepEquals, []string{"alice", "bob"})
+}
+
+func (s *SnapTestSuite) TestSetDefaultOrigins(c *C) {
+	defer mockOemDefaultOrigins("alice")()
+
+	c.Assert(SetDefaultOrigins([]string{"bob", "carol"}), IsNil)
+	c.Check(DefaultOrigins(), DeepEquals, []string{"bob", "carol"})
+
+	// back to the ones of the oem
+	c.Assert(SetDefaultOrigins(nil), IsNil)
+	c.Check(DefaultOrigins(), DeepEquals, []string{"alice"})
+	c.Assert(SetDefaultOrigins(nil), IsNil)
+}
+
+// mockStoreWithOrigins returns a store where "foo" is available from
+// alice (its alias) and bob
+func mockStoreWithOrigins(c *C) *httptest.Server {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/search"):
+			io.WriteString(w, `{"_embedded": {"clickindex:package": [
+{"package_name": "foo", "origin": "alice", "version": "1", "alias": "foo"},
+{"package_name": "foo", "origin": "bob", "version": "2"}
+]}}`)
+		case strings.HasSuffix(r.URL.Path, "/foo.bob"):
+			io.WriteString(w, `{"package_name": "foo", "origin": "bob", "version": "2"}`)
+		case strings.HasSuffix(r.URL.Path, "/foo"), strings.HasSuffix(r.URL.Path, "/foo.alice"):
+			io.WriteString(w, `{"package_name": "foo", "origin": "alice", "version": "1"}`)
+		default:
+			w.WriteHeader(404)
+			io.WriteString(w, MockNoDetailsJSON)
+		}
+	}))
+	c.Assert(mockServer, NotNil)
+
+	var err error
+	storeSearchURI, err = url.Parse(mockServer.URL + "/search")
+	c.Assert(err, IsNil)
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	return mockServer
+}
+
+func (s *SnapTestSuite) TestDetailsPrefersAlias(c *C) {
+	mockServer := mockStoreWithOrigins(c)
+	defer mockServer.Close()
+
+	parts, err := NewUbuntuStoreSnapRepository().Details("foo", "")
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 1)
+	c.Check(parts[0].Origin(), Equals, "alice")
+}
+
+func (s *SnapTestSuite) TestDetailsPrefersDefaultOrigins(c *C) {
+	mockServer := mockStoreWithOrigins(c)
+	defer mockServer.Close()
+	defer mockOemDefaultOrigins("carol", "bob")()
+
+	parts, err := NewUbuntuStoreSnapRepository().Details("foo", "")
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 1)
+	c.Check(parts[0].Origin(), Equals, "bob")
+
+	// an origin that is given wins
+	parts, err = NewUbuntuStoreSnapRepository().Details("foo", "alice")
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 1)
+	c.Check(parts[0].Origin(), Equals, "alice")
+}
+
+func (s *SnapTestSuite) TestDetailsDefaultOriginsNotApplying(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"_embedded": {"clickindex:package": [
+{"package_name": "foo", "origin": "alice", "version": "1"},
+{"package_name": "foo", "origin": "bob", "version": "2"}
+]}}`)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+	defer mockOemDefaultOrigins("carol")()
+
+	var err error
+	storeSearchURI, err = url.Parse(mockServer.URL + "/search")
+	c.Assert(err, IsNil)
+
+	_, err = NewUbuntuStoreSnapRepository().Details("foo", "")
+	c.Check(err, DeepEquals, &ErrAmbiguousName{Name: "foo", Origins: []string{"alice", "bob"}})
+}
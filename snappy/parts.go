@@ -48,7 +48,7 @@ type Configuration interface {
 // QualifiedName of a Part is the Name, in most cases qualified with the
 // Origin
 func QualifiedName(p Part) string {
-	if t := p.Type(); t == pkg.TypeFramework || t == pkg.TypeOem {
+	if t := p.Type(); t == pkg.TypeFramework || t.IsGadget() {
 		return p.Name()
 	}
 	return p.Name() + "." + p.Origin()
@@ -64,6 +64,51 @@ func FullName(p Part) string {
 	return p.Name() + "." + p.Origin()
 }
 
+// unpublisher is a Part that knows if it was unpublished from the
+// store
+type unpublisher interface {
+	Unpublished() bool
+}
+
+// IsUnpublished returns true if the part (as returned by the Updates
+// of a store) was unpublished from the store
+func IsUnpublished(p Part) bool {
+	u, ok := p.(unpublisher)
+
+	return ok && u.Unpublished()
+}
+
+// publishedOnly returns the parts (as returned by the Updates of a
+// store) but the unpublished ones, which are no updates
+func publishedOnly(parts []Part) []Part {
+	var published []Part
+	for _, part := range parts {
+		if !IsUnpublished(part) {
+			published = append(published, part)
+		}
+	}
+
+	return published
+}
+
+// changeloger is a Part that knows what changed in its version
+type changeloger interface {
+	Changelog() string
+}
+
+// Changelogs returns the changelogs of the updates (as returned by
+// Updates), by qualified name; the updates without one are left out
+func Changelogs(updates []Part) map[string]string {
+	changelogs := make(map[string]string)
+	for _, part := range updates {
+		if c, ok := part.(changeloger); ok && c.Changelog() != "" {
+			changelogs[QualifiedName(part)] = c.Changelog()
+		}
+	}
+
+	return changelogs
+}
+
 // FullNameWithChannel returns the FullName, with the channel appended
 // if it has one.
 func fullNameWithChannel(p Part) string {
@@ -212,14 +257,22 @@ func (m *MetaRepository) All() ([]Part, error) {
 	return parts, nil
 }
 
-// Updates returns all updatable parts
+// Updates returns all updatable parts, but those of the held snaps (see
+// SetHold)
 func (m *MetaRepository) Updates() (parts []Part, err error) {
 	for _, r := range m.all {
 		updates, err := r.Updates()
 		if err != nil {
 			return parts, err
 		}
-		parts = append(parts, updates...)
+		for _, part := range updates {
+			// held snaps stay at their version, and the
+			// reverted versions do not come back
+			if IsHeld(part) || IsReverted(part) {
+				continue
+			}
+			parts = append(parts, part)
+		}
 	}
 
 	return parts, err
@@ -247,30 +300,144 @@ func (m *MetaRepository) Details(name string, origin string) ([]Part, error) {
 	return parts, nil
 }
 
-// ActiveSnapsByType returns all installed snaps with the given type
-func ActiveSnapsByType(snapTs ...pkg.Type) (res []Part, err error) {
-	m := NewMetaRepository()
-	installed, err := m.Installed()
+// revisionDetailer is a Repository that can return the details of a
+// given version of a snap
+type revisionDetailer interface {
+	DetailsRevision(name, origin, version string) ([]Part, error)
+}
+
+// DetailsRevision returns the parts with the given name, origin and
+// version from the repositories that support looking up versions
+func (m *MetaRepository) DetailsRevision(name, origin, version string) ([]Part, error) {
+	var parts []Part
+
+	for _, r := range m.all {
+		rd, ok := r.(revisionDetailer)
+		if !ok {
+			continue
+		}
+		results, err := rd.DetailsRevision(name, origin, version)
+		// ignore network errors here, like Details does
+		_, netError := err.(net.Error)
+		_, urlError := err.(*url.Error)
+		switch {
+		case err == ErrPackageNotFound || netError || urlError:
+			continue
+		case err != nil:
+			return nil, err
+		}
+		parts = append(parts, results...)
+	}
+
+	return parts, nil
+}
+
+// manyDetailer is a Repository that can return the details of many
+// snaps at once
+type manyDetailer interface {
+	DetailsMany(names []string) ([]Part, error)
+}
+
+// DetailsMany returns the parts with the given names (with or without
+// their origin), asking the repositories that can for all of them at
+// once and the others for one after the other
+func (m *MetaRepository) DetailsMany(names []string) ([]Part, error) {
+	var parts []Part
+
+	for _, r := range m.all {
+		var results []Part
+		var err error
+		if md, ok := r.(manyDetailer); ok {
+			results, err = md.DetailsMany(names)
+		} else {
+			for _, name := range names {
+				var found []Part
+				found, err = r.Details(SplitOrigin(name))
+				if err == ErrPackageNotFound {
+					err = nil
+					continue
+				}
+				if err != nil {
+					break
+				}
+				results = append(results, found...)
+			}
+		}
+		// ignore network errors here, like Details does
+		_, netError := err.(net.Error)
+		_, urlError := err.(*url.Error)
+		switch {
+		case err == ErrPackageNotFound || netError || urlError:
+			continue
+		case err != nil:
+			return nil, err
+		}
+		parts = append(parts, results...)
+	}
+
+	return parts, nil
+}
+
+// InstalledOptions narrow down the snaps InstalledByType returns
+type InstalledOptions struct {
+	// ActiveOnly skips the versions of the snaps that are not active
+	ActiveOnly bool
+	// Origin skips the snaps from other origins ("" for any origin)
+	Origin string
+}
+
+// InstalledByType returns the installed snaps with any of the given
+// types (all of them if no type is given) that match the options, in
+// the order of the installed repositories. This is the query to use
+// outside of snappy, its semantics do not change.
+func InstalledByType(opts InstalledOptions, types ...pkg.Type) ([]Part, error) {
+	installed, err := NewMetaRepository().Installed()
 	if err != nil {
 		return nil, err
 	}
 
+	var res []Part
 	for _, part := range installed {
-		if !part.IsActive() {
+		if opts.ActiveOnly && !part.IsActive() {
 			continue
 		}
-		for i := range snapTs {
-			if part.Type() == snapTs[i] {
-				res = append(res, part)
-			}
+		if opts.Origin != "" && part.Origin() != opts.Origin {
+			continue
+		}
+		if len(types) > 0 && !hasType(types, part.Type()) {
+			continue
 		}
+		res = append(res, part)
 	}
 
 	return res, nil
 }
 
+func hasType(types []pkg.Type, t pkg.Type) bool {
+	for _, typ := range types {
+		if typ == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ActiveSnapsByType returns all active snaps with the given type (none
+// if no type is given)
+func ActiveSnapsByType(snapTs ...pkg.Type) (res []Part, err error) {
+	if len(snapTs) == 0 {
+		return nil, nil
+	}
+
+	return InstalledByType(InstalledOptions{ActiveOnly: true}, snapTs...)
+}
+
 // ActiveSnapIterByType returns the result of applying the given
 // function to all active snaps with the given type.
+//
+// It is swapped out in the tests, use InstalledByType instead outside
+// of snappy.
 var ActiveSnapIterByType = activeSnapIterByTypeImpl
 
 func activeSnapIterByTypeImpl(f func(Part) string, snapTs ...pkg.Type) ([]string, error) {
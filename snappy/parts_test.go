@@ -28,7 +28,7 @@ import (
 
 	"github.com/ubuntu-core/snappy/dirs"
 	"github.com/ubuntu-core/snappy/pkg"
-	"github.com/ubuntu-core/snappy/progress"
+	"github.com/ubuntu-core/snappy/pkg/remote"
 )
 
 func (s *SnapTestSuite) TestActiveSnapByType(c *C) {
@@ -58,6 +58,51 @@ icon: meta/hello.svg`)
 	c.Assert(parts[0].Name(), Equals, "framework1")
 }
 
+func (s *SnapTestSuite) TestInstalledByType(c *C) {
+	_, err := makeInstalledMockSnap(s.tempdir, `name: app1
+version: 1.0
+vendor: example.com`)
+	c.Assert(err, IsNil)
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, `name: app1
+version: 2.0
+vendor: example.com`)
+	c.Assert(err, IsNil)
+	makeSnapActive(yamlPath)
+
+	yamlPath, err = makeInstalledMockSnap(s.tempdir, `name: framework1
+version: 1.0
+type: framework
+vendor: example.com`)
+	c.Assert(err, IsNil)
+	makeSnapActive(yamlPath)
+
+	parts, err := InstalledByType(InstalledOptions{}, pkg.TypeApp)
+	c.Assert(err, IsNil)
+	c.Check(parts, HasLen, 2)
+
+	parts, err = InstalledByType(InstalledOptions{ActiveOnly: true}, pkg.TypeApp)
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 1)
+	c.Check(parts[0].Version(), Equals, "2.0")
+
+	parts, err = InstalledByType(InstalledOptions{ActiveOnly: true}, pkg.TypeApp, pkg.TypeFramework)
+	c.Assert(err, IsNil)
+	c.Check(parts, HasLen, 2)
+
+	parts, err = InstalledByType(InstalledOptions{Origin: testOrigin}, pkg.TypeApp)
+	c.Assert(err, IsNil)
+	c.Check(parts, HasLen, 2)
+
+	parts, err = InstalledByType(InstalledOptions{Origin: "other"}, pkg.TypeApp)
+	c.Assert(err, IsNil)
+	c.Check(parts, HasLen, 0)
+
+	// no type is any type
+	parts, err = InstalledByType(InstalledOptions{Origin: testOrigin})
+	c.Assert(err, IsNil)
+	c.Check(parts, HasLen, 3)
+}
+
 func (s *SnapTestSuite) TestActiveSnapIterByType(c *C) {
 	yamlPath, err := makeInstalledMockSnap(s.tempdir, `name: app
 version: 1.10
@@ -184,7 +229,7 @@ func (s *SnapTestSuite) TestPackageNameInstalled(c *C) {
 
 	c.Assert(os.MkdirAll(filepath.Join(pkgdir, ".click", "info"), 0755), IsNil)
 	c.Assert(ioutil.WriteFile(filepath.Join(pkgdir, ".click", "info", "hello-app.manifest"), []byte(`{"name": "hello-app"}`), 0644), IsNil)
-	ag := &progress.NullProgress{}
+	ag := s.meter()
 
 	part, err := NewInstalledSnapPart(yamlFile, testOrigin)
 	c.Assert(err, IsNil)
@@ -237,3 +282,20 @@ func (s *SnapTestSuite) TestFindSnapsByNameAndVersionFmk(c *C) {
 	parts = FindSnapsByNameAndVersion("fmk", "2", installed)
 	c.Check(parts, HasLen, 0)
 }
+
+func (s *SnapTestSuite) TestChangelogs(c *C) {
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	installed, err := NewInstalledSnapPart(yamlPath, testOrigin)
+	c.Assert(err, IsNil)
+
+	updates := []Part{
+		NewRemoteSnapPart(remote.Snap{Name: "foo", Origin: "bar", Version: "2", Changelog: "* fixed the frobnicator"}),
+		NewRemoteSnapPart(remote.Snap{Name: "baz", Origin: "bar", Version: "3"}),
+		installed,
+	}
+
+	c.Check(Changelogs(updates), DeepEquals, map[string]string{
+		"foo.bar": "* fixed the frobnicator",
+	})
+}
@@ -27,13 +27,14 @@ import (
 
 	"github.com/ubuntu-core/snappy/pkg/clickdeb"
 	"github.com/ubuntu-core/snappy/pkg/snapfs"
+	"github.com/ubuntu-core/snappy/progress"
 )
 
 // PackageFile is the interface to interact with the low-level snap files
 type PackageFile interface {
 	Verify(allowUnauthenticated bool) error
 	Close() error
-	UnpackWithDropPrivs(targetDir, rootDir string) error
+	UnpackWithDropPrivs(targetDir, rootDir string, pbar progress.Meter) error
 	ControlMember(name string) ([]byte, error)
 	MetaMember(name string) ([]byte, error)
 	ExtractHashes(targetDir string) error
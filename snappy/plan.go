@@ -0,0 +1,364 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/ubuntu-core/snappy/pkg"
+)
+
+// PlannedChange is the change to one snap that an install or update
+// would make
+type PlannedChange struct {
+	// Snap is the qualified name of the snap
+	Snap string
+	// From is the version replaced (empty for a fresh install)
+	From string
+	// To is the version installed
+	To string
+	// DownloadSize is the size of the download (0 for a local snap)
+	DownloadSize int64
+	// Frameworks are the frameworks the snap needs that are neither
+	// installed nor in the plan
+	Frameworks []string
+	// Restarts are the services that get restarted
+	Restarts []string
+}
+
+// the reasons a PlannedRemoval removes a version
+const (
+	// RemovalUnpublished is the removal of a snap that got unpublished
+	// (see UnpublishedRemove)
+	RemovalUnpublished = "unpublished"
+	// RemovalGC is the garbage collection of an old version
+	RemovalGC = "gc"
+)
+
+// PlannedRemoval is a version of a snap that an install or update
+// would remove
+type PlannedRemoval struct {
+	// Snap is the qualified name of the snap
+	Snap    string
+	Version string
+	// Reason is why it gets removed (RemovalUnpublished or RemovalGC)
+	Reason string
+}
+
+// Plan is what an install or update would do, worked out without
+// making any change (see PlanInstall and PlanUpdate)
+type Plan struct {
+	Changes  []PlannedChange
+	Removals []PlannedRemoval
+	// Unpublished are the snaps that got unpublished, and are kept
+	// without getting updates
+	Unpublished []string
+}
+
+// DownloadSize returns the size of all the downloads of the plan
+func (p *Plan) DownloadSize() (size int64) {
+	for _, change := range p.Changes {
+		size += change.DownloadSize
+	}
+
+	return size
+}
+
+// PlanInstall works out what InstallWithOptions would do, without
+// downloading or changing anything (but a snap from an URL, that is
+// downloaded to a temporary file to read what it is)
+func PlanInstall(name string, opts InstallOptions) (*Plan, error) {
+	var part Part
+	var urlSize int64
+
+	source := name
+	if u, ok := snapURL(name); ok {
+		snapFile, err := opts.downloadSnapURL(u, opts.meter())
+		if err != nil {
+			return nil, &ErrInstallFailed{Snap: name, OrigErr: err}
+		}
+		defer os.Remove(snapFile)
+		source = snapFile
+	}
+
+	if fi, err := os.Stat(source); err == nil && fi.Mode().IsRegular() {
+		m, err := snapFileYaml(source)
+		if err != nil {
+			return nil, err
+		}
+		part = &SnapPart{m: m, origin: SideloadedOrigin}
+		if source != name {
+			urlSize = fi.Size()
+		}
+	} else {
+		mStore := opts.configureStore(NewMetaStoreRepository())
+		if part, err = planInstallFromStore(name, opts.Version, mStore); err != nil {
+			return nil, err
+		}
+	}
+
+	plan, err := newPlan([]Part{part}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if urlSize > 0 {
+		plan.Changes[0].DownloadSize = urlSize
+	}
+
+	return plan, nil
+}
+
+// planInstallFromStore returns the snap of the store that
+// InstallWithOptions would install
+func planInstallFromStore(name, version string, mStore *MetaRepository) (Part, error) {
+	installed, err := NewMetaLocalRepository().Installed()
+	if err != nil {
+		return nil, err
+	}
+
+	origin := ""
+	if idx := strings.IndexRune(name, '.'); idx > -1 {
+		origin = name[idx+1:]
+		name = name[:idx]
+	}
+
+	var found []Part
+	if version != "" {
+		found, err = mStore.DetailsRevision(name, origin, version)
+	} else {
+		found, err = mStore.Details(name, origin)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, ErrPackageNotFound
+	}
+
+	part := found[0]
+	if err := checkInstallable(part, installed); err != nil {
+		return nil, err
+	}
+
+	return part, nil
+}
+
+// checkInstallable returns the reason the part of the store cannot be
+// installed next to the installed ones, if any
+func checkInstallable(part Part, installed []Part) error {
+	if len(FindSnapsByNameAndVersion(QualifiedName(part), part.Version(), installed)) != 0 {
+		return ErrAlreadyInstalled
+	}
+	if PackageNameActive(part.Name()) {
+		return ErrPackageNameAlreadyInstalled
+	}
+
+	return nil
+}
+
+// PlanUpdate works out what UpdateWithOptions would do, without
+// downloading or changing anything
+func PlanUpdate(opts InstallOptions) (*Plan, error) {
+	return planUpdate(opts.configureStore(NewMetaRepository()), opts)
+}
+
+func planUpdate(m *MetaRepository, opts InstallOptions) (*Plan, error) {
+	all, err := m.Updates()
+	if err != nil {
+		return nil, err
+	}
+
+	var updates, unpublished []Part
+	for _, part := range all {
+		if IsUnpublished(part) {
+			unpublished = append(unpublished, part)
+		} else {
+			updates = append(updates, part)
+		}
+	}
+	// frameworks before the apps that need them
+	sort.Stable(byInstallOrder(updates))
+
+	plan, err := newPlan(updates, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// what handleUnpublished does with them
+	if len(unpublished) > 0 {
+		installed, err := NewMetaLocalRepository().Installed()
+		if err != nil {
+			return nil, err
+		}
+		for _, part := range unpublished {
+			if opts.Unpublished != UnpublishedRemove {
+				plan.Unpublished = append(plan.Unpublished, QualifiedName(part))
+				continue
+			}
+			for _, version := range FindSnapsByName(part.Name(), installed) {
+				plan.Removals = append(plan.Removals, PlannedRemoval{
+					Snap:    QualifiedName(version),
+					Version: version.Version(),
+					Reason:  RemovalUnpublished,
+				})
+			}
+		}
+	}
+
+	return plan, nil
+}
+
+// newPlan works out the changes of installing the given parts
+func newPlan(parts []Part, opts InstallOptions) (*Plan, error) {
+	flags := opts.flags()
+
+	active, err := ActiveSnapIterByType(BareName, pkg.TypeFramework)
+	if err != nil {
+		return nil, err
+	}
+	frameworks := make(map[string]bool)
+	for _, name := range active {
+		frameworks[name] = true
+	}
+	for _, part := range parts {
+		if part.Type() == pkg.TypeFramework {
+			frameworks[part.Name()] = true
+		}
+	}
+
+	plan := &Plan{}
+	for _, part := range parts {
+		change := PlannedChange{
+			Snap: QualifiedName(part),
+			To:   part.Version(),
+		}
+		// a local snap does not get downloaded
+		if size := part.DownloadSize(); size > 0 {
+			change.DownloadSize = size
+		}
+
+		fmks, err := part.Frameworks()
+		if err != nil {
+			return nil, err
+		}
+		for _, fmk := range fmks {
+			if !frameworks[fmk] {
+				change.Frameworks = append(change.Frameworks, fmk)
+			}
+		}
+
+		if current, ok := ActiveSnapByName(part.Name()).(*SnapPart); ok {
+			change.From = current.Version()
+			// the new version takes over from the services of
+			// the current one, unless it is left inactive
+			if flags&LeaveInactive == 0 {
+				if change.Restarts, err = current.plannedRestarts(part, flags); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		plan.Changes = append(plan.Changes, change)
+
+		if flags&LeaveInactive == 0 && opts.gcKeep() > 0 {
+			removals, err := plannedGC(part, opts.gcKeep())
+			if err != nil {
+				return nil, err
+			}
+			plan.Removals = append(plan.Removals, removals...)
+		}
+	}
+
+	return plan, nil
+}
+
+// plannedGC returns the versions that the garbage collection after
+// installing the part (and making it active) removes
+func plannedGC(part Part, keep int) ([]PlannedRemoval, error) {
+	installed, err := NewMetaLocalRepository().Installed()
+	if err != nil {
+		return nil, err
+	}
+
+	var older BySnapVersion
+	for _, version := range FindSnapsByName(part.Name(), installed) {
+		if version.NeedsReboot() {
+			// no gc on parts that need reboot
+			return nil, nil
+		}
+		if CompareVersions(version, part) < 0 {
+			older = append(older, version)
+		}
+	}
+	if len(older) <= keep {
+		return nil, nil
+	}
+	sort.Sort(older)
+
+	var removals []PlannedRemoval
+	for _, version := range older[:len(older)-keep] {
+		removals = append(removals, PlannedRemoval{
+			Snap:    QualifiedName(version),
+			Version: version.Version(),
+			Reason:  RemovalGC,
+		})
+	}
+
+	return removals, nil
+}
+
+// plannedRestarts returns the services that an upgrade of the snap to
+// the given part restarts: those of the new version (taken to be the
+// current ones, for a snap of the store that is not downloaded yet),
+// and those of its dependents (that pick up the new security policy)
+func (s *SnapPart) plannedRestarts(part Part, flags InstallFlags) ([]string, error) {
+	services := s.ServiceYamls()
+	if local, ok := part.(*SnapPart); ok {
+		services = local.ServiceYamls()
+	}
+	m := &packageYaml{Name: s.m.Name, Version: part.Version()}
+
+	var restarts []string
+	for _, svc := range services {
+		restarts = append(restarts, filepath.Base(generateServiceFileName(m, svc)))
+	}
+
+	if flags&(InhibitHooks|InhibitRestart) != 0 {
+		return restarts, nil
+	}
+
+	deps, err := s.Dependents()
+	if err != nil {
+		return nil, err
+	}
+	for _, dep := range deps {
+		if !dep.IsActive() {
+			continue
+		}
+		for _, svc := range dep.ServiceYamls() {
+			restarts = append(restarts, filepath.Base(generateServiceFileName(dep.m, svc)))
+		}
+	}
+
+	return restarts, nil
+}
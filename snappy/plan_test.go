@@ -0,0 +1,181 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/pkg/remote"
+)
+
+func (s *SnapTestSuite) TestPlanUpdate(c *C) {
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	m := &MetaRepository{all: []Repository{NewSnapDirRepository(makeSnapDir(c))}}
+
+	plan, err := planUpdate(m, InstallOptions{})
+	c.Assert(err, IsNil)
+	c.Check(plan.Changes, DeepEquals, []PlannedChange{{
+		Snap:         helloAppComposedName,
+		From:         "1.10",
+		To:           "1.11",
+		DownloadSize: int64(len("hello-app 1.11")),
+		Restarts:     []string{"hello-app_svc1_1.11.service"},
+	}})
+	c.Check(plan.DownloadSize(), Equals, int64(len("hello-app 1.11")))
+
+	// nothing changed
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, helloAppComposedName, "1.11")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestPlanUpdateGC(c *C) {
+	for _, version := range []string{"1.08", "1.09"} {
+		_, err := makeInstalledMockSnap(s.tempdir, "name: hello-app\nversion: "+version+"\nvendor: Foo <foo@example.com>")
+		c.Assert(err, IsNil)
+	}
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	m := &MetaRepository{all: []Repository{NewSnapDirRepository(makeSnapDir(c))}}
+
+	// only 1.10 is kept
+	plan, err := planUpdate(m, InstallOptions{Flags: DoInstallGC})
+	c.Assert(err, IsNil)
+	c.Check(plan.Removals, DeepEquals, []PlannedRemoval{
+		{Snap: helloAppComposedName, Version: "1.08", Reason: RemovalGC},
+		{Snap: helloAppComposedName, Version: "1.09", Reason: RemovalGC},
+	})
+
+	plan, err = planUpdate(m, InstallOptions{GCKeep: DefaultGCKeep})
+	c.Assert(err, IsNil)
+	c.Check(plan.Removals, DeepEquals, []PlannedRemoval{
+		{Snap: helloAppComposedName, Version: "1.08", Reason: RemovalGC},
+	})
+
+	plan, err = planUpdate(m, InstallOptions{})
+	c.Assert(err, IsNil)
+	c.Check(plan.Removals, HasLen, 0)
+}
+
+// updatesRepository is a Repository with the given updates
+type updatesRepository struct {
+	Repository
+	updates []Part
+}
+
+func (r *updatesRepository) Updates() ([]Part, error) {
+	return r.updates, nil
+}
+
+func (s *SnapTestSuite) TestPlanUpdateUnpublished(c *C) {
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	m := &MetaRepository{all: []Repository{&updatesRepository{updates: []Part{
+		&RemoteSnapPart{pkg: remote.Snap{Name: "hello-app", Origin: testOrigin, Version: "1.10", Status: remote.StatusUnpublished}},
+	}}}}
+
+	plan, err := planUpdate(m, InstallOptions{Unpublished: UnpublishedRemove})
+	c.Assert(err, IsNil)
+	c.Check(plan.Changes, HasLen, 0)
+	c.Check(plan.Unpublished, HasLen, 0)
+	c.Check(plan.Removals, DeepEquals, []PlannedRemoval{
+		{Snap: helloAppComposedName, Version: "1.10", Reason: RemovalUnpublished},
+	})
+
+	plan, err = planUpdate(m, InstallOptions{})
+	c.Assert(err, IsNil)
+	c.Check(plan.Removals, HasLen, 0)
+	c.Check(plan.Unpublished, DeepEquals, []string{helloAppComposedName})
+}
+
+func (s *SnapTestSuite) TestPlanUpdateLeaveInactive(c *C) {
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	m := &MetaRepository{all: []Repository{NewSnapDirRepository(makeSnapDir(c))}}
+
+	plan, err := planUpdate(m, InstallOptions{LeaveInactive: true})
+	c.Assert(err, IsNil)
+	c.Assert(plan.Changes, HasLen, 1)
+	c.Check(plan.Changes[0].Restarts, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestPlanInstall(c *C) {
+	plan, err := PlanInstall("foo", InstallOptions{SnapDir: makeSnapDir(c)})
+	c.Assert(err, IsNil)
+	c.Check(plan.Changes, DeepEquals, []PlannedChange{{
+		Snap:         "foo." + testOrigin,
+		To:           "1.0",
+		DownloadSize: int64(len("foo 1.0")),
+	}})
+
+	_, err = PlanInstall("no-such-snap", InstallOptions{SnapDir: makeSnapDir(c)})
+	c.Check(err, Equals, ErrPackageNotFound)
+}
+
+func (s *SnapTestSuite) TestPlanInstallFromURL(c *C) {
+	snapFile := makeTestSnapPackage(c, "name: foo\nvendor: Foo Bar <foo@example.com>\nversion: 1.0")
+	content, err := ioutil.ReadFile(snapFile)
+	c.Assert(err, IsNil)
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(content)
+	}))
+	defer mockServer.Close()
+
+	// the hash is checked, whatever its case
+	opts := InstallOptions{Sha512: strings.ToUpper(sha512sum(string(content)))}
+	plan, err := PlanInstall(mockServer.URL+"/foo.snap", opts)
+	c.Assert(err, IsNil)
+	c.Check(plan.Changes, DeepEquals, []PlannedChange{{
+		Snap:         "foo." + SideloadedOrigin,
+		To:           "1.0",
+		DownloadSize: int64(len(content)),
+	}})
+}
+
+func (s *SnapTestSuite) TestPlanFrameworks(c *C) {
+	parts := []Part{
+		NewRemoteSnapPart(remote.Snap{Name: "fmk1", Version: "1", Type: pkg.TypeFramework}),
+		NewRemoteSnapPart(remote.Snap{Name: "foo", Origin: testOrigin, Version: "1", Frameworks: []string{"fmk1", "fmk2"}}),
+	}
+
+	plan, err := newPlan(parts, InstallOptions{})
+	c.Assert(err, IsNil)
+	c.Assert(plan.Changes, HasLen, 2)
+	c.Check(plan.Changes[0].Frameworks, HasLen, 0)
+	// fmk1 comes with the plan
+	c.Check(plan.Changes[1].Frameworks, DeepEquals, []string{"fmk2"})
+}
@@ -0,0 +1,82 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+var (
+	proxyTransportsMu sync.Mutex
+	proxyTransports   = make(map[string]*http.Transport)
+)
+
+// proxyTransport returns the transport that goes through the given
+// proxy (as the default one does through those of the environment, if
+// nil), and verifies the https connections with the given
+// storeTLSConfig (if not nil). The credentials of the URL, if any,
+// authenticate to the proxy, with the plain http requests as with the
+// CONNECT of the https ones.
+func proxyTransport(proxy *url.URL, tlsConfig *storeTLSConfig) *http.Transport {
+	proxyTransportsMu.Lock()
+	defer proxyTransportsMu.Unlock()
+
+	// one per proxy, for the connections to be reused
+	key := "env"
+	if proxy != nil {
+		key = proxy.String()
+	}
+	if tlsConfig != nil {
+		key += " " + tlsConfig.key
+	}
+	if t, ok := proxyTransports[key]; ok {
+		return t
+	}
+
+	proxyFunc := http.ProxyFromEnvironment
+	if proxy != nil {
+		proxyFunc = http.ProxyURL(proxy)
+	}
+	t := &http.Transport{
+		Proxy: proxyFunc,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	if tlsConfig != nil {
+		t.TLSClientConfig = tlsConfig.config
+	}
+	proxyTransports[key] = t
+
+	return t
+}
+
+// SetProxy makes the requests to the store, and the downloads of the
+// snaps it returns, go through the given proxy (instead of those of the
+// environment, if any); nil goes back to the environment ones
+func (s *SnapUbuntuStoreRepository) SetProxy(proxy *url.URL) {
+	s.proxy = proxy
+}
@@ -0,0 +1,92 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	. "gopkg.in/check.v1"
+)
+
+// mockProxy answers the plain http requests as the store would, and
+// refuses the CONNECTs; it records what it got
+func mockProxy(got *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*got = append(*got, r.Method+" "+r.Host+" "+r.Header.Get("Proxy-Authorization"))
+		if r.Method == "CONNECT" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		io.WriteString(w, `{"origin": "foo", "package_name": "bar", "version": "1.0", "anon_download_url": "http://store.example/dl"}`)
+	}))
+}
+
+func (s *SnapTestSuite) TestStoreRequestsThroughProxy(c *C) {
+	var got []string
+	proxy := mockProxy(&got)
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	c.Assert(err, IsNil)
+	proxyURL.User = url.UserPassword("user", "secret")
+
+	storeDetailsURI, err = url.Parse("http://store.example/details/")
+	c.Assert(err, IsNil)
+	repo := NewUbuntuStoreSnapRepository()
+	repo.SetProxy(proxyURL)
+
+	parts, err := repo.Details("bar", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 1)
+	// and so do the downloads
+	c.Check(parts[0].(*RemoteSnapPart).proxy, Equals, proxyURL)
+
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	c.Check(got, DeepEquals, []string{"GET store.example " + auth})
+}
+
+func (s *SnapTestSuite) TestStoreHTTPSRequestsConnectThroughProxy(c *C) {
+	var got []string
+	proxy := mockProxy(&got)
+	defer proxy.Close()
+
+	proxyURL, err := url.Parse(proxy.URL)
+	c.Assert(err, IsNil)
+	proxyURL.User = url.UserPassword("user", "secret")
+
+	req, err := http.NewRequest("GET", "https://store.example/details/bar", nil)
+	c.Assert(err, IsNil)
+	_, err = (*Trace)(nil).httpClient(0, proxyURL, nil).Do(req)
+	c.Assert(err, NotNil)
+
+	auth := "Basic " + base64.StdEncoding.EncodeToString([]byte("user:secret"))
+	c.Check(got, DeepEquals, []string{"CONNECT store.example:443 " + auth})
+}
+
+func (s *SnapTestSuite) TestProxyTransportIsShared(c *C) {
+	proxyURL, err := url.Parse("http://proxy.example:3128")
+	c.Assert(err, IsNil)
+
+	c.Check(proxyTransport(proxyURL, nil), Equals, proxyTransport(proxyURL, nil))
+}
@@ -0,0 +1,169 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// Prices returns the prices of the snap in the store, by currency (none
+// if it is free)
+func (s *RemoteSnapPart) Prices() map[string]float64 {
+	return s.pkg.Prices
+}
+
+// Paid returns true if the snap needs buying before it can be
+// downloaded
+func (s *RemoteSnapPart) Paid() bool {
+	for _, price := range s.pkg.Prices {
+		if price > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isDownloadRefused returns true if the error is the store refusing
+// the download, as it does with the paid snaps that were not bought
+func isDownloadRefused(err error) bool {
+	e, ok := err.(*ErrDownload)
+	if !ok {
+		return false
+	}
+
+	switch e.Code {
+	case http.StatusUnauthorized, http.StatusPaymentRequired, http.StatusForbidden:
+		return true
+	}
+
+	return false
+}
+
+type purchase struct {
+	Name     string  `json:"name"`
+	Price    float64 `json:"price"`
+	Currency string  `json:"currency"`
+}
+
+// Buy buys the given snap of the store in the given currency, at the
+// price the store gave for it, with the credentials of the user
+func (s *SnapUbuntuStoreRepository) Buy(part *RemoteSnapPart, currency string) error {
+	price, ok := part.Prices()[currency]
+	if !ok {
+		return fmt.Errorf("%s can not be bought in %s", part.Name(), currency)
+	}
+
+	body, err := json.Marshal(purchase{
+		Name:     QualifiedName(part),
+		Price:    price,
+		Currency: currency,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := newStoreRequest(s.ctx, "POST", s.purchasesURI.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	// there is no buying without credentials
+	if err := authenticate(s.auth, req); err != nil {
+		return err
+	}
+	setStoreConfigHeaders(req)
+	setUbuntuStoreHeaders(req, s.auth)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+
+	// not doStoreRequest, whose retries could buy the snap twice
+	client := s.trace.httpClient(s.timeout, s.proxy, s.tlsConfig)
+	resp, err := doStoreRequestOnce(client, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return nil
+	case http.StatusUnauthorized:
+		return ErrAuthenticationNeeded
+	case http.StatusPaymentRequired:
+		return ErrPaymentDeclined
+	}
+
+	return fmt.Errorf("SnapUbuntuStoreRepository: unexpected http statusCode %v when buying %s", resp.StatusCode, part.Name())
+}
+
+// buy buys the snap of the given name in the stores of the given
+// MetaRepository, in the given currency: if it costs no more than
+// maxPrice, or the user agrees to its price when maxPrice is 0
+func buy(name, currency string, maxPrice float64, mStore *MetaRepository, meter progress.Meter) error {
+	name, origin := SplitOrigin(name)
+	for _, repo := range mStore.all {
+		store, ok := repo.(*SnapUbuntuStoreRepository)
+		if !ok {
+			continue
+		}
+
+		found, err := store.Details(name, origin)
+		if err != nil {
+			return err
+		}
+		for _, part := range found {
+			part, ok := part.(*RemoteSnapPart)
+			if !ok || !part.Paid() {
+				continue
+			}
+			price, ok := part.Prices()[currency]
+			if !ok {
+				return fmt.Errorf("%s can not be bought in %s", part.Name(), currency)
+			}
+			switch {
+			case maxPrice > 0 && price > maxPrice:
+				return &ErrPriceTooHigh{Snap: QualifiedName(part), Price: price, MaxPrice: maxPrice, Currency: currency}
+			case maxPrice == 0:
+				msg := fmt.Sprintf("%s costs %.2f %s, which buying it charges you", QualifiedName(part), price, currency)
+				if !meter.Agreed(msg, "") {
+					return ErrPriceNotAccepted
+				}
+			}
+			progress.NotifyMessage(meter, progress.NewMessage(progress.MsgBuying, QualifiedName(part), fmt.Sprintf("%.2f", price), currency))
+
+			return store.Buy(part, currency)
+		}
+	}
+
+	return ErrPackageNotFound
+}
+
+// isPurchaseNeeded returns true if the install failed for the snap not
+// being bought
+func isPurchaseNeeded(err error) bool {
+	e, ok := err.(*ErrInstallFailed)
+	return ok && e.OrigErr == ErrPurchaseNeeded
+}
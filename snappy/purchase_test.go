@@ -0,0 +1,181 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/pkg/remote"
+)
+
+func paidSnapPart() *RemoteSnapPart {
+	return NewRemoteSnapPart(remote.Snap{
+		Name:    "foo",
+		Origin:  testOrigin,
+		Version: "1.0",
+		Prices:  map[string]float64{"USD": 1.99, "EUR": 1.79},
+	})
+}
+
+func mockPurchasesStore(c *C, status int, purchases *[]purchase) (*httptest.Server, *SnapUbuntuStoreRepository) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "POST")
+		c.Check(r.Header.Get("Authorization"), Equals, "Macaroon m")
+
+		var p purchase
+		c.Check(json.NewDecoder(r.Body).Decode(&p), IsNil)
+		*purchases = append(*purchases, p)
+		w.WriteHeader(status)
+	}))
+
+	var err error
+	repo := NewUbuntuStoreSnapRepository()
+	repo.purchasesURI, err = url.Parse(mockServer.URL + "/purchases/")
+	c.Assert(err, IsNil)
+	repo.SetAuthenticator(&mockAuthenticator{macaroon: "m"})
+
+	return mockServer, repo
+}
+
+func (s *SnapTestSuite) TestRemoteSnapPaid(c *C) {
+	c.Check(paidSnapPart().Paid(), Equals, true)
+	c.Check(NewRemoteSnapPart(remote.Snap{}).Paid(), Equals, false)
+	c.Check(NewRemoteSnapPart(remote.Snap{Prices: map[string]float64{"USD": 0}}).Paid(), Equals, false)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryBuy(c *C) {
+	var purchases []purchase
+	mockServer, repo := mockPurchasesStore(c, http.StatusCreated, &purchases)
+	defer mockServer.Close()
+
+	c.Assert(repo.Buy(paidSnapPart(), "EUR"), IsNil)
+	c.Check(purchases, DeepEquals, []purchase{{Name: "foo." + testOrigin, Price: 1.79, Currency: "EUR"}})
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryBuyDeclined(c *C) {
+	var purchases []purchase
+	mockServer, repo := mockPurchasesStore(c, http.StatusPaymentRequired, &purchases)
+	defer mockServer.Close()
+
+	c.Check(repo.Buy(paidSnapPart(), "USD"), Equals, ErrPaymentDeclined)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryBuyNotRetried(c *C) {
+	var purchases []purchase
+	mockServer, repo := mockPurchasesStore(c, http.StatusInternalServerError, &purchases)
+	defer mockServer.Close()
+
+	c.Check(repo.Buy(paidSnapPart(), "USD"), ErrorMatches, ".* unexpected http statusCode 500 when buying foo")
+	c.Check(purchases, HasLen, 1)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryBuyNeedsCredentials(c *C) {
+	var purchases []purchase
+	mockServer, repo := mockPurchasesStore(c, http.StatusCreated, &purchases)
+	defer mockServer.Close()
+	repo.SetAuthenticator(&mockAuthenticator{})
+
+	c.Check(repo.Buy(paidSnapPart(), "USD"), Equals, ErrAuthenticationNeeded)
+	c.Check(purchases, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryBuyUnknownCurrency(c *C) {
+	var purchases []purchase
+	mockServer, repo := mockPurchasesStore(c, http.StatusCreated, &purchases)
+	defer mockServer.Close()
+
+	c.Check(repo.Buy(paidSnapPart(), "GBP"), ErrorMatches, "foo can not be bought in GBP")
+	c.Check(purchases, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadPurchaseNeeded(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer mockServer.Close()
+
+	snap := paidSnapPart()
+	snap.pkg.AnonDownloadURL = mockServer.URL + "/snap"
+	snap.pkg.DownloadSha512 = sha512sum("foo")
+
+	_, err := snap.Download(&MockProgressMeter{})
+	c.Check(err, Equals, ErrPurchaseNeeded)
+}
+
+// mockPaidStore serves the details of paidSnapPart, and records its
+// purchases
+func mockPaidStore(c *C, purchases *[]purchase) (*httptest.Server, *MetaRepository) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			c.Check(json.NewEncoder(w).Encode(paidSnapPart().pkg), IsNil)
+			return
+		}
+		var p purchase
+		c.Check(json.NewDecoder(r.Body).Decode(&p), IsNil)
+		*purchases = append(*purchases, p)
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+	repo := NewUbuntuStoreSnapRepository()
+	repo.purchasesURI, err = url.Parse(mockServer.URL + "/purchases/")
+	c.Assert(err, IsNil)
+	repo.SetAuthenticator(&mockAuthenticator{macaroon: "m"})
+
+	return mockServer, &MetaRepository{all: []Repository{repo}}
+}
+
+func (s *SnapTestSuite) TestBuyAsksForThePrice(c *C) {
+	var purchases []purchase
+	mockServer, mStore := mockPaidStore(c, &purchases)
+	defer mockServer.Close()
+
+	meter := &MockProgressMeter{}
+	c.Check(buy("foo."+testOrigin, "USD", 0, mStore, meter), Equals, ErrPriceNotAccepted)
+	c.Check(meter.intro, Matches, "foo.* costs 1.99 USD, .*")
+	c.Check(purchases, HasLen, 0)
+
+	meter.y = true
+	c.Assert(buy("foo."+testOrigin, "USD", 0, mStore, meter), IsNil)
+	c.Check(purchases, DeepEquals, []purchase{{Name: "foo." + testOrigin, Price: 1.99, Currency: "USD"}})
+}
+
+func (s *SnapTestSuite) TestBuyMaxPrice(c *C) {
+	var purchases []purchase
+	mockServer, mStore := mockPaidStore(c, &purchases)
+	defer mockServer.Close()
+
+	meter := &MockProgressMeter{}
+	err := buy("foo."+testOrigin, "USD", 1.5, mStore, meter)
+	c.Check(err, FitsTypeOf, &ErrPriceTooHigh{})
+	c.Check(purchases, HasLen, 0)
+
+	// no asking when it costs less
+	c.Assert(buy("foo."+testOrigin, "EUR", 1.8, mStore, meter), IsNil)
+	c.Check(meter.intro, Equals, "")
+	c.Check(purchases, HasLen, 1)
+}
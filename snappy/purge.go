@@ -20,10 +20,12 @@
 package snappy
 
 import (
-	"fmt"
+	"os"
 	"path/filepath"
 
 	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
 	"github.com/ubuntu-core/snappy/progress"
 )
 
@@ -40,7 +42,12 @@ var remove = removeSnapData
 
 // Purge a part by a partSpec string, name[.origin][=version]
 func Purge(partSpec string, flags PurgeFlags, meter progress.Meter) error {
-	var e error
+	unlock, err := lockSystem(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	datadirs := DataDirs(partSpec)
 	if len(datadirs) == 0 {
 		return ErrPackageNotFound
@@ -49,6 +56,7 @@ func Purge(partSpec string, flags PurgeFlags, meter progress.Meter) error {
 	purgeActive := flags&DoPurgeActive != 0
 
 	var active []*SnapPart
+	var installed []*SnapPart
 
 	for _, datadir := range datadirs {
 		yamlPath := filepath.Join(dirs.SnapAppsDir, datadir.QualifiedName(), datadir.Version, "meta", "package.yaml")
@@ -63,32 +71,107 @@ func Purge(partSpec string, flags PurgeFlags, meter progress.Meter) error {
 			}
 			active = append(active, part)
 		}
+		installed = append(installed, part)
+	}
+
+	// the data is gone for good after this, give the snaps a chance
+	// to save it first
+	if err := exportDataBeforePurge(installed, meter); err != nil {
+		return err
 	}
 
 	for i, pkg := range active {
 		err := pkg.deactivate(false, meter)
 		if err != nil {
-			meter.Notify(fmt.Sprintf("Unable to deactivate %s: %s", pkg.Name(), err))
-			meter.Notify("Purge continues.")
+			progress.NotifyMessage(meter, progress.NewMessage(progress.MsgDeactivateFailed, pkg.Name(), err))
+			progress.NotifyMessage(meter, progress.NewMessage(progress.MsgPurgeContinues))
 			active[i] = nil // don't reactivate
 		}
 	}
 
+	// the data of a version is in the homes and in the system data
+	// dir, but it is removed from all of them at once
+	var todo []purgedVersion
+	seen := make(map[purgedVersion]bool)
 	for _, datadir := range datadirs {
-		if err := remove(datadir.QualifiedName(), datadir.Version); err != nil {
-			e = err
-			meter.Notify(fmt.Sprintf("unable to purge %s version %s: %s", datadir.QualifiedName(), datadir.Version, err.Error()))
+		p := purgedVersion{datadir.QualifiedName(), datadir.Version}
+		if !seen[p] {
+			seen[p] = true
+			todo = append(todo, p)
 		}
 	}
+	e := purgeVersions(partSpec, todo, meter)
 
 	for _, pkg := range active {
 		if pkg == nil {
 			continue
 		}
 		if err := pkg.activate(false, meter); err != nil {
-			meter.Notify(fmt.Sprintf("Unable to activate %s: %s", pkg.Name(), err))
+			progress.NotifyMessage(meter, progress.NewMessage(progress.MsgActivateFailed, pkg.Name(), err))
 		}
 	}
 
 	return e
 }
+
+// exportDataBeforePurge gives the snaps whose data is about to be
+// purged the chance to export it first (see SnapPart.exportDataBeforePurge)
+func exportDataBeforePurge(parts []*SnapPart, meter progress.Meter) error {
+	for _, part := range parts {
+		if err := part.exportDataBeforePurge(meter); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// purgedVersion is a version of a snap, by qualified name, whose data
+// gets purged
+type purgedVersion struct{ qn, version string }
+
+// purgeVersions removes the data of the given versions, reporting the
+// progress under the given name, and then what is left of the snaps
+// that are gone for good. It carries on after a failure, returning the
+// last one.
+func purgeVersions(name string, todo []purgedVersion, meter progress.Meter) error {
+	var e error
+
+	meter.Start(name, float64(len(todo)))
+	for i, p := range todo {
+		if err := remove(p.qn, p.version); err != nil {
+			e = err
+			progress.NotifyMessage(meter, progress.NewMessage(progress.MsgPurgeFailed, p.qn, p.version, err))
+		}
+		meter.Set(float64(i + 1))
+	}
+	meter.Finished()
+
+	for i, p := range todo {
+		if i == 0 || p.qn != todo[i-1].qn {
+			purgeLeftovers(p.qn)
+		}
+	}
+
+	return e
+}
+
+// purgeLeftovers removes what is left of the snap with the given
+// qualified name once it is gone and so is all of its data: its data
+// dirs (with their current symlinks), its hold and its reverted marks
+func purgeLeftovers(qn string) {
+	if helpers.FileExists(filepath.Join(dirs.SnapAppsDir, qn)) || len(DataDirs(qn)) > 0 {
+		return
+	}
+
+	leftovers, _ := filepath.Glob(filepath.Join(dirs.SnapDataHomeGlob, qn))
+	leftovers = append(leftovers, filepath.Join(dirs.SnapDataDir, qn), holdFile(qn))
+	reverted, _ := filepath.Glob(revertedFile(qn, "*"))
+	leftovers = append(leftovers, reverted...)
+
+	for _, fn := range leftovers {
+		if err := os.RemoveAll(fn); err != nil {
+			logger.Noticef("Failed to remove %q: %v", fn, err)
+		}
+	}
+}
@@ -29,11 +29,11 @@ import (
 
 	"github.com/ubuntu-core/snappy/dirs"
 	"github.com/ubuntu-core/snappy/helpers"
-	"github.com/ubuntu-core/snappy/systemd"
 )
 
 type purgeSuite struct {
 	tempdir string
+	backend *mockBackend
 }
 
 var _ = Suite(&purgeSuite{})
@@ -43,12 +43,9 @@ func (s *purgeSuite) SetUpTest(c *C) {
 	dirs.SetRootDir(s.tempdir)
 	os.MkdirAll(dirs.SnapMetaDir, 0755)
 	os.MkdirAll(filepath.Join(dirs.SnapServicesDir, "multi-user.target.wants"), 0755)
-	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
-		return []byte("ActiveState=inactive\n"), nil
-	}
+	s.backend = newMockBackend()
 
 	dirs.SnapSeccompDir = c.MkDir()
-	runScFilterGen = mockRunScFilterGen
 }
 
 func (s *purgeSuite) TestPurgeNonExistingRaisesError(c *C) {
@@ -126,14 +123,14 @@ func (s *purgeSuite) TestPurgeActiveExplicitOK(c *C) {
 }
 
 func (s *purgeSuite) TestPurgeActiveRestartServices(c *C) {
-	inter := &MockProgressMeter{}
+	inter := &MockProgressMeter{backend: s.backend}
 	ddir, part := s.mkpkg(c, "v1", "services:\n - name: svc")
 	c.Assert(part.activate(true, inter), IsNil)
 	canary := filepath.Join(ddir, "canary")
 	c.Assert(os.Mkdir(canary, 0755), IsNil)
 
 	called := [][]string{}
-	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
+	s.backend.systemctl = func(cmd ...string) ([]byte, error) {
 		called = append(called, cmd)
 		return []byte("ActiveState=inactive\n"), nil
 	}
@@ -155,11 +152,19 @@ func (s *purgeSuite) TestPurgeMultiOK(c *C) {
 	ddir0, _ := s.mkpkg(c, "v0")
 	ddir1, _ := s.mkpkg(c, "v1")
 
+	// the home data of a version is purged with the system one
+	homeDir := filepath.Join(s.tempdir, "home", "user1", "apps", "hello-app."+testOrigin, "v0")
+	c.Assert(os.MkdirAll(homeDir, 0755), IsNil)
+
 	err := Purge("hello-app", 0, inter)
 	c.Check(err, IsNil)
 	c.Check(helpers.FileExists(ddir0), Equals, false)
 	c.Check(helpers.FileExists(ddir1), Equals, false)
+	c.Check(helpers.FileExists(homeDir), Equals, false)
 	c.Check(inter.notified, HasLen, 0)
+	c.Check(inter.total, Equals, 2.0)
+	c.Check(inter.progress, DeepEquals, []float64{1, 2})
+	c.Check(inter.finished, Equals, true)
 }
 
 func (s *purgeSuite) TestPurgeMultiContinuesOnFail(c *C) {
@@ -202,5 +207,27 @@ func (s *purgeSuite) TestPurgeRemovedWorks(c *C) {
 	c.Check(helpers.FileExists(ddir), Equals, false)
 }
 
+func (s *purgeSuite) TestPurgeRemovedCleansUp(c *C) {
+	inter := &MockProgressMeter{}
+	ddir, part := s.mkpkg(c)
+	c.Assert(part.activate(true, inter), IsNil)
+	c.Assert(SetHold("hello-app", true), IsNil)
+	c.Assert(part.remove(inter), IsNil)
+
+	c.Assert(Purge("hello-app", 0, inter), IsNil)
+	c.Check(helpers.FileExists(filepath.Dir(ddir)), Equals, false)
+	c.Check(helpers.FileExists(holdFile("hello-app."+testOrigin)), Equals, false)
+}
+
+func (s *purgeSuite) TestRemovePurge(c *C) {
+	inter := &MockProgressMeter{}
+	ddir, part := s.mkpkg(c)
+	c.Assert(part.activate(true, inter), IsNil)
+
+	c.Assert(Remove("hello-app", DoRemovePurge, inter), IsNil)
+	c.Check(helpers.FileExists(part.basedir), Equals, false)
+	c.Check(helpers.FileExists(filepath.Dir(ddir)), Equals, false)
+}
+
 func (s *purgeSuite) TestPurgeBogusNameFails(c *C) {
 }
@@ -0,0 +1,235 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+// The recovery API: low-level operations for repairing a system on
+// which the normal install, remove and activation paths are broken
+// (for the emergency shell). They do as much as they can and log what
+// they could not do, rather than stopping at the first error; do not
+// use them in the normal course of things.
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// validRecoveryPathElement tells whether the name or version can be
+// used as (a single element of) a path: it must not lead out of the
+// apps tree
+func validRecoveryPathElement(s string) bool {
+	return s != "" && s != "." && !strings.Contains(s, "/") && !strings.Contains(s, "..")
+}
+
+// isRecoveryBasedir tells whether basedir is the directory of a version
+// of a snap, i.e. two levels below the apps (or oem) directory
+func isRecoveryBasedir(basedir string) bool {
+	root := filepath.Dir(filepath.Dir(filepath.Clean(basedir)))
+
+	return root == filepath.Clean(dirs.SnapAppsDir) || root == filepath.Clean(dirs.SnapOemDir)
+}
+
+// recoveryBasedir returns the directory of the given version of the
+// snap, name is its qualified name (the name of its directory)
+func recoveryBasedir(name, version string) (string, error) {
+	if !validRecoveryPathElement(name) || !validRecoveryPathElement(version) {
+		return "", &ErrInvalidRecoveryTarget{Name: name, Version: version}
+	}
+
+	for _, root := range []string{dirs.SnapAppsDir, dirs.SnapOemDir} {
+		basedir := filepath.Join(root, name, version)
+		if !isRecoveryBasedir(basedir) {
+			continue
+		}
+		if st, err := os.Stat(basedir); err == nil && st.IsDir() {
+			return basedir, nil
+		}
+	}
+
+	return "", ErrPackageNotFound
+}
+
+// removeIfPointsTo removes the symlink if it points to the target
+func removeIfPointsTo(symlink, target string) {
+	dest, err := filepath.EvalSymlinks(symlink)
+	if err != nil || dest != target {
+		return
+	}
+
+	if err := os.Remove(symlink); err != nil {
+		logger.Noticef("Failed to remove %q: %v", symlink, err)
+	}
+}
+
+// ForceRemove removes the given version of the snap (by its qualified
+// name), along with its data for that version, even if it is active
+// or its package.yaml can not be read anymore
+func ForceRemove(name, version string, inter progress.Meter) error {
+	basedir, err := recoveryBasedir(name, version)
+	if err != nil {
+		return err
+	}
+
+	part, err := NewInstalledSnapPart(filepath.Join(basedir, "meta", "package.yaml"), originFromBasedir(basedir))
+	if err != nil {
+		logger.Noticef("Failed to read %s %s, leaving its generated files behind: %v", name, version, err)
+	} else {
+		if err := removeClickHooks(part.m, part.origin, false, backendOf(inter)); err != nil {
+			logger.Noticef("Failed to remove the hooks of %s %s: %v", name, version, err)
+		}
+		if err := part.deactivate(false, inter); err != nil && err != ErrSnapNotActive {
+			logger.Noticef("Failed to deactivate %s %s: %v", name, version, err)
+		}
+	}
+
+	// never remove anything but a version of a snap
+	dataDir := filepath.Join(dirs.SnapDataDir, name, version)
+	if !isRecoveryBasedir(basedir) || filepath.Dir(filepath.Dir(dataDir)) != filepath.Clean(dirs.SnapDataDir) {
+		return &ErrInvalidRecoveryTarget{Name: name, Version: version}
+	}
+	removeIfPointsTo(filepath.Join(filepath.Dir(basedir), "current"), basedir)
+	removeIfPointsTo(filepath.Join(filepath.Dir(dataDir), "current"), dataDir)
+
+	if err := os.RemoveAll(basedir); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dataDir); err != nil {
+		return err
+	}
+
+	// best effort, they are only empty if it was the last version
+	os.Remove(filepath.Dir(basedir))
+	os.Remove(filepath.Dir(dataDir))
+
+	removeMetadata(name, version)
+
+	return nil
+}
+
+// ForceActivate makes the given version of the snap (by its qualified
+// name) the active one, even if the active version can not be
+// deactivated cleanly
+func ForceActivate(name, version string, inter progress.Meter) error {
+	basedir, err := recoveryBasedir(name, version)
+	if err != nil {
+		return err
+	}
+
+	part, err := NewInstalledSnapPart(filepath.Join(basedir, "meta", "package.yaml"), originFromBasedir(basedir))
+	if err != nil {
+		return err
+	}
+
+	currentSymlink := filepath.Join(filepath.Dir(basedir), "current")
+	if currentDir, err := filepath.EvalSymlinks(currentSymlink); err == nil && currentDir != basedir {
+		oldPart, err := NewInstalledSnapPart(filepath.Join(currentDir, "meta", "package.yaml"), part.origin)
+		if err == nil {
+			err = oldPart.deactivate(false, inter)
+		}
+		if err != nil {
+			logger.Noticef("Failed to deactivate %s %s: %v", name, filepath.Base(currentDir), err)
+		}
+	}
+
+	// whatever is left of the old active version
+	if err := os.Remove(currentSymlink); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return part.activate(false, inter)
+}
+
+// activeRecoveryPart returns the active version of the snap with the
+// given (optionally qualified) name
+func activeRecoveryPart(name string) (*SnapPart, error) {
+	active, err := InstalledByType(InstalledOptions{ActiveOnly: true}, pkg.TypeApp, pkg.TypeFramework, pkg.TypeGadget, pkg.TypeOem)
+	if err != nil {
+		return nil, err
+	}
+
+	found := FindSnapsByName(name, active)
+	switch len(found) {
+	case 0:
+		return nil, ErrSnapNotActive
+	case 1:
+		part, ok := found[0].(*SnapPart)
+		if !ok {
+			return nil, ErrSnapNotActive
+		}
+		return part, nil
+	default:
+		origins := make([]string, len(found))
+		for i, part := range found {
+			origins[i] = part.Origin()
+		}
+		return nil, &ErrAmbiguousName{Name: name, Origins: origins}
+	}
+}
+
+// RebuildWrappers writes the wrappers of the binaries of the active
+// version of the snap (and the exported ones) again
+func RebuildWrappers(name string) error {
+	part, err := activeRecoveryPart(name)
+	if err != nil {
+		return err
+	}
+
+	if err := part.m.removePackageBinaries(part.basedir); err != nil {
+		logger.Noticef("Failed to remove the wrappers of %s: %v", name, err)
+	}
+	if err := part.m.removeExportedBinaries(); err != nil {
+		logger.Noticef("Failed to remove the exported binaries of %s: %v", name, err)
+	}
+
+	if err := part.m.addPackageBinaries(part.basedir); err != nil {
+		return err
+	}
+
+	return part.m.addExportedBinaries(part.basedir)
+}
+
+// ResetSecurity throws away the security profiles of the active
+// version of the snap and generates them again from its package.yaml
+func ResetSecurity(name string, meter progress.Meter) error {
+	part, err := activeRecoveryPart(name)
+	if err != nil {
+		return err
+	}
+
+	backend := backendOf(meter)
+	if err := part.m.removeSecurityPolicy(part.basedir, backend); err != nil {
+		logger.Noticef("Failed to remove the security profiles of %s: %v", name, err)
+	}
+
+	if err := installClickHooks(part.basedir, part.m, part.origin, true, backend); err != nil {
+		return err
+	}
+	if err := part.m.addSecurityPolicy(part.basedir, backend); err != nil {
+		return err
+	}
+
+	// the apparmor profiles are generated from the click hooks
+	return regenerateAppArmorRules(backend)
+}
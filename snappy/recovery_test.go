@@ -0,0 +1,127 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+func (s *SnapTestSuite) makeRecoverySnaps(c *C) (v1, v2 string) {
+	yaml1, err := makeInstalledMockSnap(s.tempdir, "name: hello-app\nversion: 1.0\nvendor: example.com\nbinaries:\n - name: bin/hello\n")
+	c.Assert(err, IsNil)
+	yaml2, err := makeInstalledMockSnap(s.tempdir, "name: hello-app\nversion: 2.0\nvendor: example.com\nbinaries:\n - name: bin/hello\n")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yaml2), IsNil)
+
+	return filepath.Dir(filepath.Dir(yaml1)), filepath.Dir(filepath.Dir(yaml2))
+}
+
+func (s *SnapTestSuite) TestForceRemoveActive(c *C) {
+	_, v2 := s.makeRecoverySnaps(c)
+	qn := "hello-app." + testOrigin
+	dataDir := filepath.Join(dirs.SnapDataDir, qn, "2.0")
+	c.Assert(os.MkdirAll(dataDir, 0755), IsNil)
+	c.Assert(os.Symlink(dataDir, filepath.Join(dirs.SnapDataDir, qn, "current")), IsNil)
+
+	c.Assert(ForceRemove(qn, "2.0", s.meter()), IsNil)
+	c.Check(helpers.FileExists(v2), Equals, false)
+	c.Check(helpers.FileExists(dataDir), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, qn, "1.0")), Equals, true)
+
+	_, err := os.Lstat(filepath.Join(dirs.SnapAppsDir, qn, "current"))
+	c.Check(os.IsNotExist(err), Equals, true)
+	_, err = os.Lstat(filepath.Join(dirs.SnapDataDir, qn, "current"))
+	c.Check(os.IsNotExist(err), Equals, true)
+}
+
+func (s *SnapTestSuite) TestForceRemoveBrokenYaml(c *C) {
+	v1, _ := s.makeRecoverySnaps(c)
+	c.Assert(os.Remove(filepath.Join(v1, "meta", "package.yaml")), IsNil)
+
+	c.Assert(ForceRemove("hello-app."+testOrigin, "1.0", s.meter()), IsNil)
+	c.Check(helpers.FileExists(v1), Equals, false)
+}
+
+func (s *SnapTestSuite) TestForceRemoveNotInstalled(c *C) {
+	c.Check(ForceRemove("hello-app."+testOrigin, "3.0", s.meter()), Equals, ErrPackageNotFound)
+}
+
+func (s *SnapTestSuite) TestForceRemoveRefusesPaths(c *C) {
+	s.makeRecoverySnaps(c)
+
+	for _, t := range [][2]string{
+		{"../..", ""},
+		{"..", "apps"},
+		{"hello-app." + testOrigin + "/2.0", "."},
+		{"hello-app." + testOrigin, "../1.0"},
+		{"", "2.0"},
+	} {
+		err := ForceRemove(t[0], t[1], s.meter())
+		c.Check(err, FitsTypeOf, &ErrInvalidRecoveryTarget{}, Commentf("%q", t))
+	}
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, "hello-app."+testOrigin, "2.0")), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, "hello-app."+testOrigin, "1.0")), Equals, true)
+}
+
+func (s *SnapTestSuite) TestForceActivate(c *C) {
+	v1, v2 := s.makeRecoverySnaps(c)
+	// the active version is broken beyond deactivating it cleanly
+	c.Assert(os.Remove(filepath.Join(v2, "meta", "package.yaml")), IsNil)
+
+	c.Assert(ForceActivate("hello-app."+testOrigin, "1.0", s.meter()), IsNil)
+	current, err := filepath.EvalSymlinks(filepath.Join(dirs.SnapAppsDir, "hello-app."+testOrigin, "current"))
+	c.Assert(err, IsNil)
+	c.Check(current, Equals, v1)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapBinariesDir, "hello-app.hello")), Equals, true)
+}
+
+func (s *SnapTestSuite) TestRebuildWrappers(c *C) {
+	s.makeRecoverySnaps(c)
+	wrapper := filepath.Join(dirs.SnapBinariesDir, "hello-app.hello")
+	c.Check(helpers.FileExists(wrapper), Equals, false)
+
+	c.Assert(RebuildWrappers("hello-app"), IsNil)
+	c.Check(helpers.FileExists(wrapper), Equals, true)
+
+	c.Check(RebuildWrappers("not-installed"), Equals, ErrSnapNotActive)
+}
+
+func (s *SnapTestSuite) TestResetSecurity(c *C) {
+	s.makeRecoverySnaps(c)
+
+	regenerated := false
+	s.backend.aaClickHook = func(args ...string) ([]byte, error) {
+		regenerated = true
+		return nil, nil
+	}
+	c.Assert(ResetSecurity("hello-app."+testOrigin, s.meter()), IsNil)
+	c.Check(regenerated, Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapSeccompDir, "hello-app."+testOrigin+"_hello_2.0")), Equals, true)
+
+	s.backend.aaClickHook = func(args ...string) ([]byte, error) { return nil, errors.New("aa-clickhook failed") }
+	c.Check(ResetSecurity("hello-app", s.meter()), ErrorMatches, "aa-clickhook failed")
+}
@@ -0,0 +1,132 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// reinstallSuffix is that of the installed snap while it gets
+// reinstalled
+const reinstallSuffix = ".reinstall"
+
+// Reinstall gets the active version of the snap again, from the
+// download cache or else from the store, and unpacks it over the
+// installed one, regenerating its security profiles and systemd units,
+// to repair a corrupted install. The data of the snap is left as is;
+// if anything fails the installed snap is put back.
+func Reinstall(name string, meter progress.Meter) (err error) {
+	part, ok := ActiveSnapByName(name).(*SnapPart)
+	if !ok {
+		return ErrPackageNotFound
+	}
+	// a manifest without anywhere to download from is as good as none
+	if part.remoteM == nil || (part.remoteM.AnonDownloadURL == "" && part.remoteM.DownloadURL == "") {
+		return ErrReinstallSideloaded
+	}
+
+	// the hash of the store is checked whether it comes from the
+	// cache or gets downloaded
+	snapFile, err := NewRemoteSnapPart(*part.remoteM).Download(meter)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(snapFile)
+
+	fresh, err := NewSnapPartFromSnapFile(snapFile, part.origin, SideloadOptions{})
+	if err != nil {
+		return err
+	}
+	defer fresh.deb.Close()
+	if fresh.basedir != part.basedir {
+		return fmt.Errorf("cannot reinstall %s: got version %s instead of %s", QualifiedName(part), fresh.Version(), part.Version())
+	}
+	// it keeps the confinement it got installed with (e.g. developer
+	// mode)
+	fresh.m.Confinement = part.m.Confinement
+
+	unlock, err := lockSystem(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tx := &transaction{op: "the reinstall of " + QualifiedName(part) + " " + part.Version()}
+	defer func() {
+		if err != nil {
+			tx.rollback()
+		}
+	}()
+
+	if err := tx.do("the deactivation", func() error {
+		return part.deactivate(false, meter)
+	}, func() error {
+		return part.activate(false, meter)
+	}); err != nil {
+		return err
+	}
+
+	// the installed snap is kept aside until the new one is active
+	aside := part.basedir + reinstallSuffix
+	if err := tx.do("the move aside", func() error {
+		return os.Rename(part.basedir, aside)
+	}, func() error {
+		if !helpers.IsDirectory(aside) {
+			return nil
+		}
+		if err := os.RemoveAll(part.basedir); err != nil {
+			return err
+		}
+		return os.Rename(aside, part.basedir)
+	}); err != nil {
+		return err
+	}
+
+	if err := fresh.unpack(meter); err != nil {
+		return err
+	}
+
+	reinstalled, err := NewInstalledSnapPart(filepath.Join(part.basedir, "meta", "package.yaml"), part.origin)
+	if err != nil {
+		return err
+	}
+	if err := tx.do("the activation", func() error {
+		return reinstalled.activate(false, meter)
+	}, func() error {
+		if err := reinstalled.deactivate(false, meter); err != ErrSnapNotActive {
+			return err
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if err := os.RemoveAll(aside); err != nil {
+		logger.Noticef("Failed to remove %q: %v", aside, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	. "gopkg.in/check.v1"
+)
+
+func (s *SnapTestSuite) TestReinstallNotInstalled(c *C) {
+	c.Check(Reinstall("no-such-snap", &MockProgressMeter{}), Equals, ErrPackageNotFound)
+}
+
+func (s *SnapTestSuite) TestReinstallSideloaded(c *C) {
+	s.activeHelloApp(c, "1.10")
+
+	c.Check(Reinstall("hello-app", &MockProgressMeter{}), Equals, ErrReinstallSideloaded)
+	c.Check(ActiveSnapByName("hello-app").Version(), Equals, "1.10")
+}
@@ -0,0 +1,249 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/pkg"
+)
+
+var validRelationName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`).MatchString
+
+// relationFile is where snappy keeps what the provider published under
+// the relation (only root can read it)
+func relationFile(relation, provider string) string {
+	return filepath.Join(dirs.SnapRelationsDir, relation, provider+".yaml")
+}
+
+// relationDataFile is the copy of the relation data that a snap that
+// requires it can read
+func relationDataFile(part *SnapPart, relation string) string {
+	return filepath.Join(dirs.SnapDataDir, QualifiedName(part), part.Version(), "relations", relation+".yaml")
+}
+
+func declaresRelation(relations []string, relation string) bool {
+	for _, r := range relations {
+		if r == relation {
+			return true
+		}
+	}
+
+	return false
+}
+
+// activeSnapPart returns the active SnapPart with the given name
+func activeSnapPart(name string) (*SnapPart, error) {
+	part, ok := ActiveSnapByName(name).(*SnapPart)
+	if !ok {
+		return nil, ErrPackageNotFound
+	}
+
+	return part, nil
+}
+
+// readRelation returns what was published under the relation, by the
+// qualified name of the provider
+func readRelation(relation string) (map[string]map[string]string, error) {
+	matches, err := filepath.Glob(relationFile(relation, "*"))
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]map[string]string, len(matches))
+	for _, fn := range matches {
+		content, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return nil, err
+		}
+
+		var values map[string]string
+		if err := yaml.Unmarshal(content, &values); err != nil {
+			return nil, &ErrInvalidYaml{File: fn, Err: err, Yaml: content}
+		}
+		data[strings.TrimSuffix(filepath.Base(fn), ".yaml")] = values
+	}
+
+	return data, nil
+}
+
+// SetRelationData publishes the key/values of the given snap under the
+// relation, which the snap must provide; nil data withdraws them. The
+// snaps that require the relation get the data, and their config hook
+// is run with it.
+func SetRelationData(name, relation string, data map[string]string) error {
+	part, err := activeSnapPart(name)
+	if err != nil {
+		return err
+	}
+	if !declaresRelation(part.m.Provides, relation) {
+		return &ErrRelationNotDeclared{Snap: name, Relation: relation, Provides: true}
+	}
+
+	fn := relationFile(relation, QualifiedName(part))
+	if data == nil {
+		if err := os.Remove(fn); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else {
+		content, err := yaml.Marshal(data)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+			return err
+		}
+		if err := helpers.AtomicWriteFile(fn, content, 0600, 0); err != nil {
+			return err
+		}
+	}
+
+	return refreshRelation(relation)
+}
+
+// RelationData returns what was published under the relation, by the
+// qualified name of the provider, for a snap that requires it
+func RelationData(name, relation string) (map[string]map[string]string, error) {
+	part, err := activeSnapPart(name)
+	if err != nil {
+		return nil, err
+	}
+	if !declaresRelation(part.m.Requires, relation) {
+		return nil, &ErrRelationNotDeclared{Snap: name, Relation: relation}
+	}
+
+	return readRelation(relation)
+}
+
+// relationConfig is what the config hook of a snap that requires a
+// relation gets (under config: <name>:) when the relation data changes
+type relationConfig struct {
+	Relations map[string]map[string]map[string]string `yaml:"relations"`
+}
+
+// refreshRelation hands the relation data to the active snaps that
+// require the relation, running the config hook of those for which it
+// changed
+func refreshRelation(relation string) error {
+	data, err := readRelation(relation)
+	if err != nil {
+		return err
+	}
+	content, err := yaml.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	active, err := ActiveSnapsByType(pkg.TypeApp, pkg.TypeFramework)
+	if err != nil {
+		return err
+	}
+
+	var requirers []*SnapPart
+	for _, p := range active {
+		if part, ok := p.(*SnapPart); ok && declaresRelation(part.m.Requires, relation) {
+			requirers = append(requirers, part)
+		}
+	}
+	sort.Sort(byQualifiedName(requirers))
+
+	for _, part := range requirers {
+		fn := relationDataFile(part, relation)
+		if old, err := ioutil.ReadFile(fn); err == nil && bytes.Equal(old, content) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			return err
+		}
+		// the data may well be credentials, only the snap gets to
+		// read it
+		if err := helpers.AtomicWriteFile(fn, content, 0600, 0); err != nil {
+			return err
+		}
+		if err := chownLikeDataDir(part, fn); err != nil {
+			return err
+		}
+
+		rawConfig, err := yaml.Marshal(map[string]map[string]relationConfig{
+			"config": {
+				part.Name(): {Relations: map[string]map[string]map[string]string{relation: data}},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := part.Config(rawConfig); err != nil && err != ErrConfigNotFound {
+			logger.Noticef("Failed to hand the relation %s to %s: %v", relation, QualifiedName(part), err)
+		}
+	}
+
+	return nil
+}
+
+// chownLikeDataDir gives the file to the owner of the data directory
+// of the snap
+func chownLikeDataDir(part *SnapPart, fn string) error {
+	fi, err := os.Stat(filepath.Join(dirs.SnapDataDir, QualifiedName(part), part.Version()))
+	if err != nil {
+		return err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+
+	return chown(fn, int(st.Uid), int(st.Gid))
+}
+
+// withdrawRelations removes what the snap published under the
+// relations it provides, and hands that to the snaps that require them
+func withdrawRelations(part *SnapPart) error {
+	for _, relation := range part.m.Provides {
+		if err := os.Remove(relationFile(relation, QualifiedName(part))); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		if err := refreshRelation(relation); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type byQualifiedName []*SnapPart
+
+func (p byQualifiedName) Len() int           { return len(p) }
+func (p byQualifiedName) Less(i, j int) bool { return QualifiedName(p[i]) < QualifiedName(p[j]) }
+func (p byQualifiedName) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
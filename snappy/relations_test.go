@@ -0,0 +1,159 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+func (s *SnapTestSuite) makeRelationSnaps(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, `name: postgres
+version: 1.0
+vendor: foo
+provides: [db]
+`)
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+
+	snapDir, err := s.makeInstalledMockSnapWithConfig(c, "#!/bin/sh\n", `name: webapp
+version: 2.0
+vendor: foo
+requires: [db]
+`)
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(filepath.Join(snapDir, "meta", "package.yaml")), IsNil)
+}
+
+func (s *SnapTestSuite) TestRelationData(c *C) {
+	s.makeRelationSnaps(c)
+
+	var configs []string
+	runConfigScript = func(cs, aa, rc string, env []string) (string, error) {
+		c.Check(aa, Equals, "webapp."+testOrigin+"_snappy-config_2.0")
+		configs = append(configs, rc)
+		return "", nil
+	}
+	defer func() { runConfigScript = runConfigScriptImpl }()
+
+	c.Assert(SetRelationData("postgres", "db", map[string]string{"user": "app"}), IsNil)
+
+	data, err := RelationData("webapp", "db")
+	c.Assert(err, IsNil)
+	c.Check(data, DeepEquals, map[string]map[string]string{
+		"postgres." + testOrigin: {"user": "app"},
+	})
+
+	// the requirer can read it from its data dir, nobody else can
+	fn := filepath.Join(dirs.SnapDataDir, "webapp."+testOrigin, "2.0", "relations", "db.yaml")
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "postgres."+testOrigin+":\n  user: app\n")
+	st, err := os.Stat(fn)
+	c.Assert(err, IsNil)
+	c.Check(st.Mode().Perm(), Equals, os.FileMode(0600))
+
+	// and its config hook got it
+	c.Assert(configs, HasLen, 1)
+	c.Check(configs[0], Equals, `config:
+  webapp:
+    relations:
+      db:
+        postgres.`+testOrigin+`:
+          user: app
+`)
+
+	// the config hook only runs on changes
+	c.Assert(SetRelationData("postgres", "db", map[string]string{"user": "app"}), IsNil)
+	c.Check(configs, HasLen, 1)
+
+	c.Assert(SetRelationData("postgres", "db", nil), IsNil)
+	data, err = RelationData("webapp", "db")
+	c.Assert(err, IsNil)
+	c.Check(data, HasLen, 0)
+	c.Check(configs, HasLen, 2)
+}
+
+func (s *SnapTestSuite) TestRelationDataOwnedByRequirer(c *C) {
+	s.makeRelationSnaps(c)
+
+	chowned := make(map[string]int)
+	chown = func(name string, uid, gid int) error {
+		chowned[name] = uid
+		return nil
+	}
+	defer func() { chown = os.Chown }()
+
+	c.Assert(SetRelationData("postgres", "db", map[string]string{"password": "secret"}), IsNil)
+
+	dataDir := filepath.Join(dirs.SnapDataDir, "webapp."+testOrigin, "2.0")
+	st, err := os.Stat(dataDir)
+	c.Assert(err, IsNil)
+	uid := int(st.Sys().(*syscall.Stat_t).Uid)
+	c.Check(chowned, DeepEquals, map[string]int{
+		filepath.Join(dataDir, "relations", "db.yaml"): uid,
+	})
+}
+
+func (s *SnapTestSuite) TestRelationWithdrawnOnProviderRemoval(c *C) {
+	s.makeRelationSnaps(c)
+
+	c.Assert(SetRelationData("postgres", "db", map[string]string{"password": "secret"}), IsNil)
+	c.Assert(Remove("postgres", 0, s.meter()), IsNil)
+
+	c.Check(helpers.FileExists(relationFile("db", "postgres."+testOrigin)), Equals, false)
+	data, err := RelationData("webapp", "db")
+	c.Assert(err, IsNil)
+	c.Check(data, HasLen, 0)
+
+	content, err := ioutil.ReadFile(filepath.Join(dirs.SnapDataDir, "webapp."+testOrigin, "2.0", "relations", "db.yaml"))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "{}\n")
+}
+
+func (s *SnapTestSuite) TestRelationNotDeclared(c *C) {
+	s.makeRelationSnaps(c)
+
+	err := SetRelationData("webapp", "db", map[string]string{"user": "app"})
+	c.Check(err, DeepEquals, &ErrRelationNotDeclared{Snap: "webapp", Relation: "db", Provides: true})
+	c.Check(err, ErrorMatches, `webapp does not provide the relation "db"`)
+
+	_, err = RelationData("postgres", "db")
+	c.Check(err, ErrorMatches, `postgres does not require the relation "db"`)
+
+	_, err = RelationData("not-installed", "db")
+	c.Check(err, Equals, ErrPackageNotFound)
+}
+
+func (s *SnapTestSuite) TestRelationNameValidated(c *C) {
+	_, err := parsePackageYamlData([]byte(`name: foo
+version: 1.0
+vendor: foo
+requires: [../db]
+`), false)
+	c.Check(err, ErrorMatches, `.*invalid relation name "../db".*`)
+}
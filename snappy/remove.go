@@ -22,6 +22,7 @@ package snappy
 import (
 	"strings"
 
+	"github.com/ubuntu-core/snappy/logger"
 	"github.com/ubuntu-core/snappy/progress"
 )
 
@@ -32,6 +33,15 @@ const (
 	// DoRemoveGC will ensure that garbage collection is done, unless a
 	// version is specified.
 	DoRemoveGC RemoveFlags = 1 << iota
+	// DoRemovePermanently will remove the snap right away instead of
+	// moving it to the trash
+	DoRemovePermanently
+	// DoRemoveData will move the data of the snap to the trash
+	// together with the snap
+	DoRemoveData
+	// DoRemovePurge will remove the snap right away, and purge its
+	// data (see Purge)
+	DoRemovePurge
 )
 
 // Remove a part by a partSpec string, name[.origin][=version]
@@ -62,8 +72,19 @@ func Remove(partSpec string, flags RemoveFlags, meter progress.Meter) error {
 		return ErrPackageNotFound
 	}
 
+	// a good time to get rid of what was removed long enough ago
+	if err := PurgeExpiredTrash(); err != nil {
+		logger.Noticef("Failed to purge the trash: %v", err)
+	}
+
 	for _, part := range parts {
-		if err := part.Uninstall(meter); err != nil {
+		var err error
+		if snap, ok := part.(*SnapPart); ok {
+			err = snap.uninstall(meter, flags)
+		} else {
+			err = part.Uninstall(meter)
+		}
+		if err != nil {
 			return err
 		}
 	}
@@ -23,12 +23,11 @@ import (
 	. "gopkg.in/check.v1"
 
 	"github.com/ubuntu-core/snappy/pkg"
-	"github.com/ubuntu-core/snappy/progress"
 )
 
 func (s *SnapTestSuite) TestRemoveNonExistingRaisesError(c *C) {
 	pkgName := "some-random-non-existing-stuff"
-	err := Remove(pkgName, 0, &progress.NullProgress{})
+	err := Remove(pkgName, 0, s.meter())
 	c.Assert(err, NotNil)
 	c.Assert(err, Equals, ErrPackageNotFound)
 }
@@ -36,7 +35,7 @@ func (s *SnapTestSuite) TestRemoveNonExistingRaisesError(c *C) {
 func (s *SnapTestSuite) TestSnapRemoveByVersion(c *C) {
 	makeTwoTestSnaps(c, pkg.TypeApp)
 
-	err := Remove("foo=1.0", 0, &progress.NullProgress{})
+	err := Remove("foo=1.0", 0, s.meter())
 
 	m := NewMetaRepository()
 	installed, err := m.Installed()
@@ -47,7 +46,7 @@ func (s *SnapTestSuite) TestSnapRemoveByVersion(c *C) {
 func (s *SnapTestSuite) TestSnapRemoveActive(c *C) {
 	makeTwoTestSnaps(c, pkg.TypeApp)
 
-	err := Remove("foo", 0, &progress.NullProgress{})
+	err := Remove("foo", 0, s.meter())
 
 	m := NewMetaRepository()
 	installed, err := m.Installed()
@@ -58,13 +57,13 @@ func (s *SnapTestSuite) TestSnapRemoveActive(c *C) {
 func (s *SnapTestSuite) TestSnapRemoveActiveOemFails(c *C) {
 	makeTwoTestSnaps(c, pkg.TypeOem)
 
-	err := Remove("foo", 0, &progress.NullProgress{})
+	err := Remove("foo", 0, s.meter())
 	c.Assert(err, DeepEquals, ErrPackageNotRemovable)
 
-	err = Remove("foo=1.0", 0, &progress.NullProgress{})
+	err = Remove("foo=1.0", 0, s.meter())
 	c.Assert(err, IsNil)
 
-	err = Remove("foo", 0, &progress.NullProgress{})
+	err = Remove("foo", 0, s.meter())
 	c.Assert(err, DeepEquals, ErrPackageNotRemovable)
 
 	m := NewMetaRepository()
@@ -78,7 +77,7 @@ func (s *SnapTestSuite) TestSnapRemoveActiveOemFails(c *C) {
 
 func (s *SnapTestSuite) TestSnapRemoveGC(c *C) {
 	makeTwoTestSnaps(c, pkg.TypeApp)
-	err := Remove("foo", DoRemoveGC, &progress.NullProgress{})
+	err := Remove("foo", DoRemoveGC, s.meter())
 	c.Assert(err, IsNil)
 	m := NewMetaRepository()
 	installed, err := m.Installed()
@@ -0,0 +1,228 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ubuntu-core/snappy/logger"
+)
+
+// storeAttemptsEnv sets how many times a request to the store is
+// tried, for connections flakier (or steadier) than the default expects
+const storeAttemptsEnv = "SNAPPY_STORE_ATTEMPTS"
+
+const defaultStoreAttempts = 5
+
+var (
+	// storeRetryDelay is the delay before the first retry, doubled
+	// for each of the next ones up to storeRetryMaxDelay
+	storeRetryDelay    = 500 * time.Millisecond
+	storeRetryMaxDelay = 30 * time.Second
+
+	retrySleep = sleepContext
+)
+
+func storeAttempts() int {
+	if n, err := strconv.Atoi(os.Getenv(storeAttemptsEnv)); err == nil && n > 0 {
+		return n
+	}
+
+	return defaultStoreAttempts
+}
+
+// retryDelay returns the delay before the given retry (0 for the
+// first), with jitter for the devices that failed together not to
+// retry together
+func retryDelay(retry int) time.Duration {
+	d := storeRetryMaxDelay
+	if retry < 16 && storeRetryDelay<<uint(retry) < storeRetryMaxDelay {
+		d = storeRetryDelay << uint(retry)
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// isTransientError tells if the error of a request is worth retrying
+// it for: timeouts, connection resets and the like
+func isTransientError(err error) bool {
+	if uerr, ok := err.(*url.Error); ok {
+		err = uerr.Err
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if nerr, ok := err.(net.Error); ok && (nerr.Timeout() || nerr.Temporary()) {
+		return true
+	}
+	if operr, ok := err.(*net.OpError); ok {
+		err = operr.Err
+		if serr, ok := err.(*os.SyscallError); ok {
+			err = serr.Err
+		}
+		return err == syscall.ECONNRESET || err == syscall.ECONNABORTED || err == syscall.ETIMEDOUT
+	}
+
+	return false
+}
+
+// doStoreRequest does the request (see doStoreRequestOnce), retrying it
+// with exponential backoff while it fails transiently: on a timeout, a
+// reset connection or a 5xx status code. ErrStoreUnavailable is
+// returned once it ran out of attempts. If the store is unreachable,
+// its mirrors (see StoreMirrors) are tried in turn.
+func doStoreRequest(client *http.Client, req *http.Request) (*http.Response, error) {
+	// the body of a retry is that of the first try
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := retryStoreRequest(client, req, body)
+	if _, skewed := err.(*ErrClockSkew); err == nil || skewed {
+		return resp, err
+	}
+
+	for _, mirrorURL := range storeMirrorURLs(req.URL) {
+		if req.Context().Err() != nil {
+			break
+		}
+		logger.Noticef("Trying the store mirror %s: %v", mirrorURL.Host, err)
+
+		mirrorReq, merr := mirrorRequest(req, mirrorURL)
+		if merr != nil {
+			logger.Noticef("Skipping the store mirror %s: %v", mirrorURL.Host, merr)
+			continue
+		}
+		if resp, merr := retryStoreRequest(client, mirrorReq, body); merr == nil {
+			return resp, nil
+		}
+	}
+
+	return nil, err
+}
+
+// mirrorRequest returns req for the mirror at mirrorURL, without what
+// is only for the store: the credentials of the user, the identity of
+// the device and the headers of the store configuration. The requests
+// that were authenticated only go to https mirrors.
+func mirrorRequest(req *http.Request, mirrorURL *url.URL) (*http.Request, error) {
+	if req.Header.Get("Authorization") != "" && mirrorURL.Scheme != "https" {
+		return nil, fmt.Errorf("it is not https, and the request is authenticated")
+	}
+
+	mirrorReq := *req
+	mirrorReq.URL = mirrorURL
+	mirrorReq.Host = mirrorURL.Host
+	mirrorReq.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		mirrorReq.Header[k] = v
+	}
+
+	mirrorReq.Header.Del("Authorization")
+	for _, header := range deviceIdentityHeaders {
+		mirrorReq.Header.Del(header)
+	}
+	if config, err := storeConfig(); err == nil {
+		for header := range config.Headers {
+			mirrorReq.Header.Del(header)
+		}
+	}
+
+	return &mirrorReq, nil
+}
+
+// storeMirrorURLs returns the URLs of what u is at on the store
+// mirrors, if u is on the store
+func storeMirrorURLs(u *url.URL) []*url.URL {
+	base := storeBase().String()
+	if !strings.HasPrefix(u.String(), base) {
+		return nil
+	}
+	rest := strings.TrimPrefix(u.String(), base)
+
+	var urls []*url.URL
+	for _, mirror := range StoreMirrors() {
+		mirrorURL, err := url.Parse(strings.TrimSuffix(mirror, "/") + "/" + rest)
+		if err != nil {
+			logger.Noticef("Skipping the store mirror %q: %v", mirror, err)
+			continue
+		}
+		urls = append(urls, mirrorURL)
+	}
+
+	return urls
+}
+
+// retryStoreRequest does the retries of doStoreRequest, with the given
+// body for each of them
+func retryStoreRequest(client *http.Client, req *http.Request, body []byte) (*http.Response, error) {
+	attempts := storeAttempts()
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 {
+			delay := retryDelay(attempt - 1)
+			logger.Noticef("Retrying %s in %v: %v", req.URL, delay, err)
+			retrySleep(req.Context(), delay)
+			// no retrying what the caller gave up on
+			if err := req.Context().Err(); err != nil {
+				return nil, err
+			}
+		}
+
+		if body != nil {
+			req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+
+		var resp *http.Response
+		resp, err = doStoreRequestOnce(client, req)
+		if err != nil {
+			if !isTransientError(err) {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode < 500 {
+			return resp, nil
+		}
+
+		resp.Body.Close()
+		err = fmt.Errorf("unexpected http statusCode %v", resp.StatusCode)
+	}
+
+	return nil, &ErrStoreUnavailable{URL: req.URL, Attempts: attempts, Err: err}
+}
@@ -0,0 +1,210 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+// mockFlakyStore fails the first fails requests with the given status
+// code, and echoes the body of the next ones
+func mockFlakyStore(fails, code int, requests *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*requests++
+		if *requests <= fails {
+			w.WriteHeader(code)
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+}
+
+func (s *SnapTestSuite) TestDoStoreRequestRetries(c *C) {
+	var delays []time.Duration
+	retrySleep = func(_ context.Context, d time.Duration) { delays = append(delays, d) }
+
+	var requests int
+	mockServer := mockFlakyStore(2, 503, &requests)
+	defer mockServer.Close()
+
+	req, err := http.NewRequest("POST", mockServer.URL, strings.NewReader("hello"))
+	c.Assert(err, IsNil)
+	resp, err := doStoreRequest(&http.Client{}, req)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	c.Check(string(body), Equals, "hello")
+	c.Check(requests, Equals, 3)
+	c.Assert(delays, HasLen, 2)
+	c.Check(delays[0] >= storeRetryDelay/2 && delays[0] <= storeRetryDelay, Equals, true)
+	c.Check(delays[1] >= storeRetryDelay && delays[1] <= 2*storeRetryDelay, Equals, true)
+}
+
+func (s *SnapTestSuite) TestDoStoreRequestGivesUp(c *C) {
+	os.Setenv(storeAttemptsEnv, "3")
+	defer os.Unsetenv(storeAttemptsEnv)
+
+	var requests int
+	mockServer := mockFlakyStore(10, 500, &requests)
+	defer mockServer.Close()
+
+	req, err := http.NewRequest("GET", mockServer.URL, nil)
+	c.Assert(err, IsNil)
+	_, err = doStoreRequest(&http.Client{}, req)
+	c.Assert(err, FitsTypeOf, &ErrStoreUnavailable{})
+	c.Check(err.(*ErrStoreUnavailable).Attempts, Equals, 3)
+	c.Check(requests, Equals, 3)
+}
+
+func (s *SnapTestSuite) TestDoStoreRequestNoRetryOnClientError(c *C) {
+	var requests int
+	mockServer := mockFlakyStore(10, 404, &requests)
+	defer mockServer.Close()
+
+	req, err := http.NewRequest("GET", mockServer.URL, nil)
+	c.Assert(err, IsNil)
+	resp, err := doStoreRequest(&http.Client{}, req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Check(resp.StatusCode, Equals, 404)
+	c.Check(requests, Equals, 1)
+}
+
+func (s *SnapTestSuite) TestDoStoreRequestRetriesTimeouts(c *C) {
+	var requests int
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}))
+	defer mockServer.Close()
+
+	req, err := http.NewRequest("GET", mockServer.URL, nil)
+	c.Assert(err, IsNil)
+	resp, err := doStoreRequest(&http.Client{Timeout: 50 * time.Millisecond}, req)
+	c.Assert(err, IsNil)
+	resp.Body.Close()
+	c.Check(requests, Equals, 2)
+}
+
+func (s *SnapTestSuite) TestRetryDelayIsCapped(c *C) {
+	for _, retry := range []int{10, 20, 100} {
+		d := retryDelay(retry)
+		c.Check(d >= storeRetryMaxDelay/2 && d <= storeRetryMaxDelay, Equals, true)
+	}
+}
+
+func (s *SnapTestSuite) TestDoStoreRequestFallsBackToMirrors(c *C) {
+	// the store, and the first mirror, are unreachable
+	dead := httptest.NewServer(nil)
+	dead.Close()
+
+	var got []string
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Host+r.URL.RequestURI())
+		io.WriteString(w, "hello")
+	}))
+	defer mirror.Close()
+	mirrorURL, err := url.Parse(mirror.URL)
+	c.Assert(err, IsNil)
+
+	oldBase := storeBaseURI
+	defer func() { storeBaseURI = oldBase }()
+	storeBaseURI, err = url.Parse(dead.URL + "/api/v1/")
+	c.Assert(err, IsNil)
+
+	os.Setenv(storeMirrorsEnv, dead.URL+"/api/v1,"+mirror.URL+"/replica/api/")
+	defer os.Unsetenv(storeMirrorsEnv)
+
+	req, err := http.NewRequest("GET", dead.URL+"/api/v1/package/foo?fields=name", nil)
+	c.Assert(err, IsNil)
+	resp, err := doStoreRequest(&http.Client{}, req)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	c.Check(string(body), Equals, "hello")
+	c.Check(got, DeepEquals, []string{mirrorURL.Host + "/replica/api/package/foo?fields=name"})
+}
+
+func (s *SnapTestSuite) TestStoreMirrorURLsOnlyForTheStore(c *C) {
+	os.Setenv(storeMirrorsEnv, "http://replica.example/api")
+	defer os.Unsetenv(storeMirrorsEnv)
+
+	u, err := url.Parse("http://elsewhere.example/api/v1/package/foo")
+	c.Assert(err, IsNil)
+	c.Check(storeMirrorURLs(u), HasLen, 0)
+
+	u, err = storeBaseURI.Parse("package/foo")
+	c.Assert(err, IsNil)
+	urls := storeMirrorURLs(u)
+	c.Assert(urls, HasLen, 1)
+	c.Check(urls[0].String(), Equals, "http://replica.example/api/package/foo")
+}
+
+func (s *SnapTestSuite) TestMirrorRequestOnlyForTheStore(c *C) {
+	makeStoreConfig(c, "headers:\n  X-Test-Run: \"42\"\n")
+
+	req, err := http.NewRequest("GET", "https://store.example/api/v1/package/foo", nil)
+	c.Assert(err, IsNil)
+	req.Header.Set("Accept", "application/hal+json")
+	req.Header.Set("Authorization", "OAuth secret")
+	req.Header.Set("X-Ubuntu-Device-Serial", "1234")
+	req.Header.Set("X-Test-Run", "42")
+
+	mirrorURL, err := url.Parse("https://replica.example/api/package/foo")
+	c.Assert(err, IsNil)
+	mirrorReq, err := mirrorRequest(req, mirrorURL)
+	c.Assert(err, IsNil)
+	c.Check(mirrorReq.Host, Equals, "replica.example")
+	c.Check(mirrorReq.Header, DeepEquals, http.Header{"Accept": []string{"application/hal+json"}})
+	// the request to the store itself keeps them
+	c.Check(req.Header.Get("Authorization"), Equals, "OAuth secret")
+
+	mirrorURL.Scheme = "http"
+	_, err = mirrorRequest(req, mirrorURL)
+	c.Check(err, NotNil)
+}
+
+func (s *SnapTestSuite) TestStoreMirrorURLsForTheConfiguredStore(c *C) {
+	makeStoreConfig(c, "url: https://store.example/api/v1\n")
+	os.Setenv(storeMirrorsEnv, "https://replica.example/api")
+	defer os.Unsetenv(storeMirrorsEnv)
+
+	u, err := url.Parse("https://store.example/api/v1/package/foo")
+	c.Assert(err, IsNil)
+	urls := storeMirrorURLs(u)
+	c.Assert(urls, HasLen, 1)
+	c.Check(urls[0].String(), Equals, "https://replica.example/api/package/foo")
+}
@@ -0,0 +1,124 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Review is a review of a snap in the store
+type Review struct {
+	// Stars the reviewer gave the snap (1 to 5)
+	Stars int `json:"rating"`
+	// Author is the (display) name of the reviewer
+	Author string `json:"reviewer_displayname"`
+	// Summary is the title of the review
+	Summary string `json:"summary"`
+	// Text of the review
+	Text string `json:"review_text"`
+	// Version of the snap that got reviewed
+	Version string `json:"version"`
+	// Date the review was written
+	Date time.Time `json:"date_created"`
+}
+
+// Ratings sum up the stars of the reviews of a snap
+type Ratings struct {
+	// Average of the stars
+	Average float64
+	// Total number of reviews
+	Total int
+	// Stars is how many reviews gave one star, two stars... (in
+	// Stars[0], Stars[1]...)
+	Stars [5]int
+}
+
+// Reviews returns the reviews of the given snap in the store, newest
+// first
+func (s *SnapUbuntuStoreRepository) Reviews(name, origin string) ([]Review, error) {
+	// a copy, so the other requests are not affected
+	reviewsURI := *s.reviewsURI
+	if origin != "" {
+		name += "." + origin
+	}
+	q := reviewsURI.Query()
+	q.Set("package_name", name)
+	reviewsURI.RawQuery = q.Encode()
+
+	req, err := newStoreRequest(s.ctx, "GET", reviewsURI.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// set headers
+	setStoreConfigHeaders(req)
+	setUbuntuStoreHeaders(req, s.auth)
+	req.Header.Set("Accept", "application/json")
+
+	client := s.trace.httpClient(s.timeout, s.proxy, s.tlsConfig)
+	resp, err := doStoreRequest(client, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := storeError(resp, "reviews of "+name); err != nil {
+		return nil, err
+	}
+
+	var reviews []Review
+	dec := json.NewDecoder(resp.Body)
+	if err := dec.Decode(&reviews); err != nil {
+		return nil, err
+	}
+
+	return reviews, nil
+}
+
+// Ratings returns the Ratings of the given snap, from its reviews in
+// the store
+func (s *SnapUbuntuStoreRepository) Ratings(name, origin string) (*Ratings, error) {
+	reviews, err := s.Reviews(name, origin)
+	if err != nil {
+		return nil, err
+	}
+
+	var ratings Ratings
+	sum := 0
+	for _, review := range reviews {
+		if review.Stars < 1 || review.Stars > len(ratings.Stars) {
+			continue
+		}
+		ratings.Stars[review.Stars-1]++
+		ratings.Total++
+		sum += review.Stars
+	}
+	if ratings.Total > 0 {
+		ratings.Average = float64(sum) / float64(ratings.Total)
+	}
+
+	return &ratings, nil
+}
+
+// Reviews returns the reviews of the given snap in the store
+func Reviews(name, origin string) ([]Review, error) {
+	return NewUbuntuStoreSnapRepository().Reviews(name, origin)
+}
@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+const mockReviewsJSON = `[
+    {
+        "id": 2,
+        "package_name": "hello-world.canonical",
+        "version": "1.0.1",
+        "rating": 5,
+        "summary": "Does what it says",
+        "review_text": "Says hello, to the world.",
+        "reviewer_displayname": "Jane Doe",
+        "date_created": "2015-07-02T10:00:00Z"
+    },
+    {
+        "id": 1,
+        "package_name": "hello-world.canonical",
+        "version": "1.0",
+        "rating": 2,
+        "summary": "Meh",
+        "review_text": "Only says hello.",
+        "reviewer_displayname": "John Doe",
+        "date_created": "2015-06-01T10:00:00Z"
+    }
+]`
+
+func mockReviewsStore(c *C, content string) (*httptest.Server, *SnapUbuntuStoreRepository) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("package_name") != "hello-world.canonical" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, content)
+	}))
+
+	var err error
+	repo := NewUbuntuStoreSnapRepository()
+	repo.reviewsURI, err = url.Parse(mockServer.URL + "/reviews/")
+	c.Assert(err, IsNil)
+
+	return mockServer, repo
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryReviews(c *C) {
+	mockServer, repo := mockReviewsStore(c, mockReviewsJSON)
+	defer mockServer.Close()
+
+	reviews, err := repo.Reviews("hello-world", "canonical")
+	c.Assert(err, IsNil)
+	c.Assert(reviews, HasLen, 2)
+	c.Check(reviews[0], DeepEquals, Review{
+		Stars:   5,
+		Author:  "Jane Doe",
+		Summary: "Does what it says",
+		Text:    "Says hello, to the world.",
+		Version: "1.0.1",
+		Date:    time.Date(2015, 7, 2, 10, 0, 0, 0, time.UTC),
+	})
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryReviewsNotFound(c *C) {
+	mockServer, repo := mockReviewsStore(c, mockReviewsJSON)
+	defer mockServer.Close()
+
+	_, err := repo.Reviews("hello-world", "someone-else")
+	c.Check(err, Equals, ErrPackageNotFound)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryRatings(c *C) {
+	mockServer, repo := mockReviewsStore(c, mockReviewsJSON)
+	defer mockServer.Close()
+
+	ratings, err := repo.Ratings("hello-world", "canonical")
+	c.Assert(err, IsNil)
+	c.Check(ratings, DeepEquals, &Ratings{
+		Average: 3.5,
+		Total:   2,
+		Stars:   [5]int{0, 1, 0, 0, 1},
+	})
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryRatingsNoReviews(c *C) {
+	mockServer, repo := mockReviewsStore(c, "[]")
+	defer mockServer.Close()
+
+	ratings, err := repo.Ratings("hello-world", "canonical")
+	c.Assert(err, IsNil)
+	c.Check(ratings, DeepEquals, &Ratings{})
+}
@@ -21,8 +21,12 @@ package snappy
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 	"sort"
 
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
 	"github.com/ubuntu-core/snappy/progress"
 )
 
@@ -52,5 +56,93 @@ func Rollback(pkg, ver string, inter progress.Meter) (version string, err error)
 		return "", err
 	}
 
+	// rolling back (or forward) to a reverted version explicitly
+	// clears the mark
+	if part := ActiveSnapByName(pkg); part != nil {
+		if err := os.Remove(revertedFile(QualifiedName(part), ver)); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
 	return ver, nil
 }
+
+// revertedFile returns the file that marks the given version of the
+// snap with the given qualified name as reverted, next to its
+// manifests
+func revertedFile(qn, ver string) string {
+	return filepath.Join(dirs.SnapMetaDir, fmt.Sprintf("%s_%s.reverted", qn, ver))
+}
+
+// Revert makes the version of pkg that was active before the active
+// one active again, and marks the one it reverts as bad so that Updates
+// leaves it out. It returns the version reverted to.
+func Revert(pkg string, inter progress.Meter) (version string, err error) {
+	active := ActiveSnapByName(pkg)
+	if active == nil {
+		return "", ErrPackageNotFound
+	}
+
+	m := NewMetaRepository()
+	installed, err := m.Installed()
+	if err != nil {
+		return "", err
+	}
+
+	previous, err := previousPart(active, FindSnapsByName(QualifiedName(active), installed))
+	if err != nil {
+		return "", err
+	}
+
+	if err := previous.SetActive(true, inter); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dirs.SnapMetaDir, 0755); err != nil {
+		return "", err
+	}
+	if err := helpers.AtomicWriteFile(revertedFile(QualifiedName(active), active.Version()), nil, 0644, 0); err != nil {
+		return "", err
+	}
+
+	return previous.Version(), nil
+}
+
+// previousPart returns the most recently active of the installed
+// versions (but the active one) as the history has it, or the newest
+// one older than the active one if the history does not say (e.g. it
+// predates the activations being recorded)
+func previousPart(active Part, installed []Part) (Part, error) {
+	versions, err := previouslyActive(QualifiedName(active), active.Version())
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range versions {
+		for _, part := range installed {
+			if part.Version() == version {
+				return part, nil
+			}
+		}
+	}
+
+	var previous Part
+	for _, part := range installed {
+		if CompareVersions(part, active) >= 0 {
+			continue
+		}
+		if previous == nil || CompareVersions(part, previous) > 0 {
+			previous = part
+		}
+	}
+	if previous == nil {
+		return nil, fmt.Errorf("no version to revert to")
+	}
+
+	return previous, nil
+}
+
+// IsReverted returns true if the version of the snap was reverted (see
+// Revert)
+func IsReverted(part Part) bool {
+	return helpers.FileExists(revertedFile(QualifiedName(part), part.Version()))
+}
@@ -22,6 +22,7 @@ package snappy
 import (
 	. "gopkg.in/check.v1"
 
+	"github.com/ubuntu-core/snappy/helpers"
 	"github.com/ubuntu-core/snappy/pkg"
 )
 
@@ -61,3 +62,82 @@ func (s *SnapTestSuite) TestRollbackService(c *C) {
 	c.Assert(err, IsNil)
 	c.Check(version, Equals, "1.0")
 }
+
+func (s *SnapTestSuite) TestRevert(c *C) {
+	for _, version := range []string{"1.10", "1.09"} {
+		_, err := makeInstalledMockSnap(s.tempdir, "name: hello-app\nversion: "+version+"\nvendor: foo")
+		c.Assert(err, IsNil)
+	}
+	part := s.activeHelloApp(c, "1.11")
+	m := &MetaRepository{all: []Repository{NewSnapDirRepository(makeSnapDir(c))}}
+
+	version, err := Revert("hello-app", &MockProgressMeter{})
+	c.Assert(err, IsNil)
+	c.Check(version, Equals, "1.10")
+	c.Check(ActiveSnapByName("hello-app").Version(), Equals, "1.10")
+	c.Check(IsReverted(part), Equals, true)
+
+	// the snap dir has 1.11, which got reverted
+	updates, err := m.Updates()
+	c.Assert(err, IsNil)
+	c.Check(updates, HasLen, 0)
+
+	// rolling forward to it clears the mark
+	version, err = Rollback("hello-app", "1.11", &MockProgressMeter{})
+	c.Assert(err, IsNil)
+	c.Check(version, Equals, "1.11")
+	c.Check(IsReverted(part), Equals, false)
+}
+
+func (s *SnapTestSuite) TestRevertAfterRollingForward(c *C) {
+	for _, version := range []string{"1.10", "1.11"} {
+		_, err := makeInstalledMockSnap(s.tempdir, "name: hello-app\nversion: "+version+"\nvendor: foo")
+		c.Assert(err, IsNil)
+	}
+	s.activeHelloApp(c, "1.09")
+	_, err := Rollback("hello-app", "1.11", &MockProgressMeter{})
+	c.Assert(err, IsNil)
+
+	// back to what was active, not to the newest older version
+	version, err := Revert("hello-app", &MockProgressMeter{})
+	c.Assert(err, IsNil)
+	c.Check(version, Equals, "1.09")
+	c.Check(ActiveSnapByName("hello-app").Version(), Equals, "1.09")
+}
+
+func (s *SnapTestSuite) TestRevertedMarkRemovedWithVersion(c *C) {
+	_, err := makeInstalledMockSnap(s.tempdir, "name: hello-app\nversion: 1.10\nvendor: foo")
+	c.Assert(err, IsNil)
+	s.activeHelloApp(c, "1.11")
+
+	_, err = Revert("hello-app", &MockProgressMeter{})
+	c.Assert(err, IsNil)
+	c.Assert(helpers.FileExists(revertedFile("hello-app."+testOrigin, "1.11")), Equals, true)
+
+	c.Assert(Remove("hello-app=1.11", DoRemovePermanently, s.meter()), IsNil)
+	c.Check(helpers.FileExists(revertedFile("hello-app."+testOrigin, "1.11")), Equals, false)
+}
+
+func (s *SnapTestSuite) TestRevertNothingBefore(c *C) {
+	_, err := makeInstalledMockSnap(s.tempdir, "name: hello-app\nversion: 1.12\nvendor: foo")
+	c.Assert(err, IsNil)
+	s.activeHelloApp(c, "1.10")
+
+	_, err = Revert("hello-app", &MockProgressMeter{})
+	c.Check(err, ErrorMatches, "no version to revert to")
+
+	_, err = Revert("no-such-snap", &MockProgressMeter{})
+	c.Check(err, Equals, ErrPackageNotFound)
+}
+
+// activeHelloApp installs the given version of hello-app, and makes it
+// active
+func (s *SnapTestSuite) activeHelloApp(c *C, version string) Part {
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, "name: hello-app\nversion: "+version+"\nvendor: foo")
+	c.Assert(err, IsNil)
+	part, err := NewInstalledSnapPart(yamlPath, testOrigin)
+	c.Assert(err, IsNil)
+	c.Assert(part.SetActive(true, &MockProgressMeter{}), IsNil)
+
+	return part
+}
@@ -0,0 +1,218 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// Scanner checks a snap for malware before it gets unpacked
+type Scanner interface {
+	// Scan returns an ErrMalwareDetected if the snap file is
+	// infected, any other error if it could not be scanned (and the
+	// install fails either way)
+	Scan(snapFile string) error
+}
+
+// NoopScanner is the Scanner that finds nothing, the default
+type NoopScanner struct{}
+
+// Scan does nothing
+func (NoopScanner) Scan(snapFile string) error {
+	return nil
+}
+
+// ClamdScanner scans snaps with the clamd daemon of ClamAV
+type ClamdScanner struct {
+	// Socket is the unix socket clamd listens on (its LocalSocket)
+	Socket string
+	// Timeout limits the whole scan (clamdScanTimeout if 0)
+	Timeout time.Duration
+	// StreamMaxLength is the StreamMaxLength of clamd, the most it
+	// scans in one go (clamdStreamMaxLength if 0); larger snaps are
+	// scanned in overlapping segments of that length
+	StreamMaxLength int64
+}
+
+const (
+	clamdScanTimeout = 5 * time.Minute
+	// clamd reads the stream in chunks of at most this size
+	clamdChunkSize = 64 * 1024
+	// the default StreamMaxLength of clamd
+	clamdStreamMaxLength = 25 * 1024 * 1024
+	// the segments of a large snap overlap by this much, for what
+	// spans two of them to be found as well
+	clamdSegmentOverlap = 1024 * 1024
+)
+
+// Scan streams the snap file to clamd (INSTREAM), in segments of at
+// most StreamMaxLength
+func (s *ClamdScanner) Scan(snapFile string) error {
+	f, err := os.Open(snapFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = clamdScanTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	segment := s.StreamMaxLength
+	if segment <= 0 {
+		segment = clamdStreamMaxLength
+	}
+	overlap := int64(clamdSegmentOverlap)
+	if overlap > segment/2 {
+		overlap = segment / 2
+	}
+
+	for offset := int64(0); ; offset += segment - overlap {
+		if err := s.scanStream(snapFile, io.NewSectionReader(f, offset, segment), deadline); err != nil {
+			return err
+		}
+		if offset+segment >= st.Size() {
+			return nil
+		}
+	}
+}
+
+// scanStream streams r to clamd (INSTREAM) and returns what it found
+func (s *ClamdScanner) scanStream(snapFile string, r io.Reader, deadline time.Time) error {
+	conn, err := net.Dial("unix", s.Socket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(conn, "zINSTREAM\x00"); err != nil {
+		return err
+	}
+
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return err
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	// a zero length chunk ends the stream
+	binary.BigEndian.PutUint32(size, 0)
+	if _, err := conn.Write(size); err != nil {
+		return err
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	return parseClamdReply(snapFile, strings.TrimRight(reply, "\x00\n"))
+}
+
+// parseClamdReply makes sense of "stream: OK", "stream: <signature>
+// FOUND" and "<reason> ERROR"
+func parseClamdReply(snapFile, reply string) error {
+	switch {
+	case strings.HasSuffix(reply, " OK"):
+		return nil
+	case strings.HasSuffix(reply, " FOUND"):
+		signature := strings.TrimSuffix(reply, " FOUND")
+		if i := strings.Index(signature, ": "); i >= 0 {
+			signature = signature[i+2:]
+		}
+		return &ErrMalwareDetected{Snap: snapFile, Signature: signature}
+	default:
+		return fmt.Errorf("clamd failed to scan %s: %q", snapFile, reply)
+	}
+}
+
+// ScannerConfig picks the Scanner of the device (in the oem package)
+type ScannerConfig struct {
+	// Clamd is the socket of the clamd to scan the snaps with
+	Clamd string `yaml:"clamd,omitempty"`
+	// StreamMaxLength is the StreamMaxLength of that clamd (in
+	// bytes), if not the default
+	StreamMaxLength int64 `yaml:"stream-max-length,omitempty"`
+}
+
+// customScanner is set by SetScanner, nil if the oem package decides
+var customScanner Scanner
+
+// SetScanner sets the Scanner the snaps are checked with before they
+// are installed, overriding the one of the oem package (nil goes back
+// to it)
+func SetScanner(s Scanner) {
+	customScanner = s
+}
+
+// activeScanner returns the Scanner to check the snaps with
+func activeScanner() Scanner {
+	if customScanner != nil {
+		return customScanner
+	}
+
+	oem, err := getOem()
+	if err != nil || oem.OEM.Software.Scanner == nil || oem.OEM.Software.Scanner.Clamd == "" {
+		return NoopScanner{}
+	}
+
+	return &ClamdScanner{
+		Socket:          oem.OEM.Software.Scanner.Clamd,
+		StreamMaxLength: oem.OEM.Software.Scanner.StreamMaxLength,
+	}
+}
+
+// scanSnap checks the snap file for malware, the install must not go
+// on if it returns an error
+func scanSnap(snapFile string) error {
+	return activeScanner().Scan(snapFile)
+}
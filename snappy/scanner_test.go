@@ -0,0 +1,204 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// mockClamd serves INSTREAM requests, replying with what reply returns
+// for the streamed data
+func mockClamd(c *C, reply func(data []byte) string) string {
+	socket := filepath.Join(c.MkDir(), "clamd.ctl")
+	l, err := net.Listen("unix", socket)
+	c.Assert(err, IsNil)
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+
+		cmd := make([]byte, len("zINSTREAM\x00"))
+		if _, err := io.ReadFull(conn, cmd); err != nil || string(cmd) != "zINSTREAM\x00" {
+			io.WriteString(conn, "UNKNOWN COMMAND\x00")
+			return
+		}
+
+		var data bytes.Buffer
+		for {
+			var size uint32
+			if err := binary.Read(conn, binary.BigEndian, &size); err != nil {
+				return
+			}
+			if size == 0 {
+				break
+			}
+			if _, err := io.CopyN(&data, conn, int64(size)); err != nil {
+				return
+			}
+		}
+		io.WriteString(conn, reply(data.Bytes())+"\x00")
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			serve(conn)
+		}
+	}()
+
+	return socket
+}
+
+func makeScanFile(c *C, content []byte) string {
+	fn := filepath.Join(c.MkDir(), "foo_1.0_all.snap")
+	c.Assert(ioutil.WriteFile(fn, content, 0644), IsNil)
+
+	return fn
+}
+
+func (s *SnapTestSuite) TestClamdScannerClean(c *C) {
+	content := bytes.Repeat([]byte("x"), 3*clamdChunkSize+7)
+	socket := mockClamd(c, func(data []byte) string {
+		c.Check(data, DeepEquals, content)
+		return "stream: OK"
+	})
+
+	scanner := &ClamdScanner{Socket: socket}
+	c.Check(scanner.Scan(makeScanFile(c, content)), IsNil)
+}
+
+func (s *SnapTestSuite) TestClamdScannerSegments(c *C) {
+	content := make([]byte, 5*clamdSegmentOverlap+3)
+	for i := range content {
+		content[i] = byte(i % 251)
+	}
+
+	var segments [][]byte
+	socket := mockClamd(c, func(data []byte) string {
+		segments = append(segments, data)
+		return "stream: OK"
+	})
+
+	scanner := &ClamdScanner{Socket: socket, StreamMaxLength: 2 * clamdSegmentOverlap}
+	c.Assert(scanner.Scan(makeScanFile(c, content)), IsNil)
+
+	// none is longer than the limit, and they overlap
+	c.Assert(len(segments), Equals, 5)
+	for i, segment := range segments {
+		start := i * clamdSegmentOverlap
+		end := start + 2*clamdSegmentOverlap
+		if end > len(content) {
+			end = len(content)
+		}
+		c.Check(bytes.Equal(segment, content[start:end]), Equals, true, Commentf("segment %d", i))
+	}
+}
+
+func (s *SnapTestSuite) TestClamdScannerFoundInLaterSegment(c *C) {
+	content := make([]byte, 3*clamdSegmentOverlap)
+	copy(content[len(content)-5:], "eicar")
+
+	socket := mockClamd(c, func(data []byte) string {
+		if bytes.Contains(data, []byte("eicar")) {
+			return "stream: Eicar-Test-Signature FOUND"
+		}
+		return "stream: OK"
+	})
+
+	scanner := &ClamdScanner{Socket: socket, StreamMaxLength: 2 * clamdSegmentOverlap}
+	c.Check(scanner.Scan(makeScanFile(c, content)), FitsTypeOf, &ErrMalwareDetected{})
+}
+
+func (s *SnapTestSuite) TestClamdScannerFound(c *C) {
+	socket := mockClamd(c, func(data []byte) string {
+		return "stream: Eicar-Test-Signature FOUND"
+	})
+
+	fn := makeScanFile(c, []byte("eicar"))
+	err := (&ClamdScanner{Socket: socket}).Scan(fn)
+	c.Assert(err, FitsTypeOf, &ErrMalwareDetected{})
+	c.Check(err.(*ErrMalwareDetected).Signature, Equals, "Eicar-Test-Signature")
+	c.Check(err, ErrorMatches, ".*foo_1.0_all.snap is infected with Eicar-Test-Signature, refusing to install it")
+}
+
+func (s *SnapTestSuite) TestClamdScannerError(c *C) {
+	socket := mockClamd(c, func(data []byte) string {
+		return "INSTREAM size limit exceeded. ERROR"
+	})
+
+	err := (&ClamdScanner{Socket: socket}).Scan(makeScanFile(c, []byte("x")))
+	c.Check(err, ErrorMatches, `clamd failed to scan .*: "INSTREAM size limit exceeded. ERROR"`)
+}
+
+func (s *SnapTestSuite) TestClamdScannerNotRunning(c *C) {
+	err := (&ClamdScanner{Socket: filepath.Join(c.MkDir(), "nothing")}).Scan(makeScanFile(c, []byte("x")))
+	c.Check(err, NotNil)
+}
+
+func (s *SnapTestSuite) TestActiveScanner(c *C) {
+	c.Check(activeScanner(), Equals, NoopScanner{})
+
+	getOem = func() (*packageYaml, error) {
+		return &packageYaml{OEM: OEM{Software: Software{Scanner: &ScannerConfig{Clamd: "/run/clamav/clamd.ctl"}}}}, nil
+	}
+	defer func() { getOem = getOemImpl }()
+	c.Check(activeScanner(), DeepEquals, &ClamdScanner{Socket: "/run/clamav/clamd.ctl"})
+
+	getOem = func() (*packageYaml, error) {
+		return &packageYaml{OEM: OEM{Software: Software{Scanner: &ScannerConfig{Clamd: "/run/clamav/clamd.ctl", StreamMaxLength: 100 << 20}}}}, nil
+	}
+	c.Check(activeScanner(), DeepEquals, &ClamdScanner{Socket: "/run/clamav/clamd.ctl", StreamMaxLength: 100 << 20})
+
+	SetScanner(NoopScanner{})
+	defer SetScanner(nil)
+	c.Check(activeScanner(), Equals, NoopScanner{})
+}
+
+type mockScanner struct {
+	scanned []string
+	err     error
+}
+
+func (s *mockScanner) Scan(snapFile string) error {
+	s.scanned = append(s.scanned, snapFile)
+	return s.err
+}
+
+func (s *SnapTestSuite) TestInstallClickScansFirst(c *C) {
+	scanner := &mockScanner{err: &ErrMalwareDetected{Snap: "foo", Signature: "bad"}}
+	SetScanner(scanner)
+	defer SetScanner(nil)
+
+	// the file is not even opened
+	_, err := installClick("/no/such/foo.snap", 0, &progress.NullProgress{}, testOrigin)
+	c.Check(err, FitsTypeOf, &ErrMalwareDetected{})
+	c.Check(scanner.scanned, DeepEquals, []string{"/no/such/foo.snap"})
+}
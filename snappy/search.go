@@ -19,7 +19,13 @@
 
 package snappy
 
-import "strings"
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/ubuntu-core/snappy/logger"
+)
 
 // Search searches all repositories with the given keywords in the args slice
 func Search(args []string) (SharedNames, error) {
@@ -27,3 +33,126 @@ func Search(args []string) (SharedNames, error) {
 
 	return m.Search(strings.Join(args, ","))
 }
+
+// SearchWithOptions is Search with SearchOptions
+func SearchWithOptions(opts SearchOptions) (SharedNames, error) {
+	return NewUbuntuStoreSnapRepository().SearchWithOptions(opts)
+}
+
+// maxSearchPages is how many pages of results a search goes through at
+// most, should a store keep sending next links
+const maxSearchPages = 50
+
+// SearchPager goes through the results of a search of the store page by
+// page, following the next links the store sends
+type SearchPager struct {
+	repo  *SnapUbuntuStoreRepository
+	next  *url.URL
+	pages int
+}
+
+// searchQuery returns the URL of the search for the given searchTerm
+func (s *SnapUbuntuStoreRepository) searchQuery(searchTerm string) *url.URL {
+	u := *s.searchURI
+	q := u.Query()
+	q.Set("q", searchTerm)
+	u.RawQuery = q.Encode()
+
+	return &u
+}
+
+func (s *SnapUbuntuStoreRepository) pager(searchURI *url.URL) *SearchPager {
+	return &SearchPager{repo: s, next: searchURI}
+}
+
+// SearchWithOptions searches the repository for opts.Query, with the
+// page, size and order of the results of opts
+func (s *SnapUbuntuStoreRepository) SearchWithOptions(opts SearchOptions) (SharedNames, error) {
+	searchURI := s.searchQuery(opts.Query)
+	q := searchURI.Query()
+	if err := opts.setQuery(q); err != nil {
+		return nil, err
+	}
+	searchURI.RawQuery = q.Encode()
+
+	return s.search(searchURI)
+}
+
+// SearchPager returns a SearchPager for the given searchTerm, for the
+// callers that want the first results without waiting for all of them
+func (s *SnapUbuntuStoreRepository) SearchPager(searchTerm string) *SearchPager {
+	return s.pager(s.searchQuery(searchTerm))
+}
+
+// More returns true if there are more pages of results
+func (p *SearchPager) More() bool {
+	return p.next != nil
+}
+
+// Next fetches the next page of results
+func (p *SearchPager) Next() ([]Part, error) {
+	if p.next == nil {
+		return nil, nil
+	}
+
+	s := p.repo
+	req, err := newStoreRequest(s.ctx, "GET", p.next.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// set headers
+	setStoreConfigHeaders(req)
+	setUbuntuStoreHeaders(req, s.auth)
+	setDeviceIdentityHeaders(req)
+	acceptStoreAPIs(req, storeAPIv1)
+
+	client := s.trace.httpClient(0, s.proxy, s.tlsConfig)
+	resp, err := doStoreRequest(client, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := storeError(resp, "search"); err != nil {
+		return nil, err
+	}
+
+	packages, next, err := decodeSearchResults(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	parts := make([]Part, len(packages))
+	for i, pkg := range packages {
+		snap := NewRemoteSnapPart(pkg)
+		snap.proxy = s.proxy
+		snap.tlsConfig = s.tlsConfig.forDownloads()
+		snap.ctx = s.ctx
+		snap.auth = s.auth
+		parts[i] = snap
+	}
+
+	p.pages++
+	switch {
+	case next == "":
+		p.next = nil
+	case p.pages >= maxSearchPages:
+		logger.Noticef("Stopping the search %s after %d pages", req.URL, p.pages)
+		p.next = nil
+	default:
+		// the link may well be relative, but it must be to the store:
+		// the next request carries the credentials of the user
+		nextURL, err := req.URL.Parse(next)
+		if err != nil {
+			return nil, err
+		}
+		if nextURL.Scheme != req.URL.Scheme || nextURL.Host != req.URL.Host {
+			p.next = nil
+			return nil, fmt.Errorf("the next page of the search %s is not on the store: %s", req.URL, nextURL)
+		}
+		p.next = nextURL
+	}
+
+	return parts, nil
+}
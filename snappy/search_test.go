@@ -0,0 +1,175 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	. "gopkg.in/check.v1"
+)
+
+// mockSearchPage is a page of search results with one snap, and the
+// link to the next page if any
+const mockSearchPage = `{
+    "_embedded": {
+        "clickindex:package": [
+            {"package_name": "snap%d", "origin": "foo", "version": "1.0"}
+        ]
+    },
+    "_links": {
+        "next": {"href": "%s"}
+    }
+}`
+
+// mockPagedSearchStore serves pages pages of results
+func mockPagedSearchStore(pages int, got *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*got = append(*got, r.URL.RequestURI())
+
+		var page int
+		fmt.Sscan(r.URL.Query().Get("page"), &page)
+		next := ""
+		if page+1 < pages {
+			next = fmt.Sprintf("/search?q=%s&page=%d", r.URL.Query().Get("q"), page+1)
+		}
+		fmt.Fprintf(w, mockSearchPage, page, next)
+	}))
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositorySearchFollowsPages(c *C) {
+	var got []string
+	mockServer := mockPagedSearchStore(3, &got)
+	defer mockServer.Close()
+
+	var err error
+	storeSearchURI, err = url.Parse(mockServer.URL + "/search")
+	c.Assert(err, IsNil)
+
+	results, err := NewUbuntuStoreSnapRepository().Search("foo")
+	c.Assert(err, IsNil)
+	c.Check(results, HasLen, 3)
+	for i := 0; i < 3; i++ {
+		c.Check(results[fmt.Sprintf("snap%d", i)], NotNil)
+	}
+	c.Check(got, DeepEquals, []string{"/search?q=foo", "/search?q=foo&page=1", "/search?q=foo&page=2"})
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositorySearchWithOptions(c *C) {
+	var got []string
+	mockServer := mockPagedSearchStore(12, &got)
+	defer mockServer.Close()
+
+	var err error
+	storeSearchURI, err = url.Parse(mockServer.URL + "/search")
+	c.Assert(err, IsNil)
+
+	results, err := NewUbuntuStoreSnapRepository().SearchWithOptions(SearchOptions{Query: "foo", Size: 1, Sort: SortByRating})
+	c.Assert(err, IsNil)
+	c.Check(got[0], Equals, "/search?q=foo&size=1&sort=ratings_average%3Adesc")
+
+	// in the order of the store, not that of the names
+	var expected []string
+	for i := 0; i < 12; i++ {
+		expected = append(expected, fmt.Sprintf("snap%d", i))
+	}
+	c.Check(results.Names(), DeepEquals, expected)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositorySearchWithOptionsInvalidSort(c *C) {
+	_, err := NewUbuntuStoreSnapRepository().SearchWithOptions(SearchOptions{Query: "foo", Sort: "popularity"})
+	c.Check(err, ErrorMatches, `invalid search order "popularity"`)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositorySearchPager(c *C) {
+	var got []string
+	mockServer := mockPagedSearchStore(2, &got)
+	defer mockServer.Close()
+
+	var err error
+	storeSearchURI, err = url.Parse(mockServer.URL + "/search")
+	c.Assert(err, IsNil)
+
+	pager := NewUbuntuStoreSnapRepository().SearchPager("foo")
+	c.Assert(pager.More(), Equals, true)
+	page, err := pager.Next()
+	c.Assert(err, IsNil)
+	c.Assert(page, HasLen, 1)
+	c.Check(page[0].Name(), Equals, "snap0")
+	// one page at a time
+	c.Check(got, HasLen, 1)
+
+	c.Assert(pager.More(), Equals, true)
+	page, err = pager.Next()
+	c.Assert(err, IsNil)
+	c.Assert(page, HasLen, 1)
+	c.Check(page[0].Name(), Equals, "snap1")
+	c.Check(pager.More(), Equals, false)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositorySearchPagesAreCapped(c *C) {
+	var got []string
+	mockServer := mockPagedSearchStore(maxSearchPages+10, &got)
+	defer mockServer.Close()
+
+	var err error
+	storeSearchURI, err = url.Parse(mockServer.URL + "/search")
+	c.Assert(err, IsNil)
+
+	results, err := NewUbuntuStoreSnapRepository().Search("foo")
+	c.Assert(err, IsNil)
+	c.Check(results, HasLen, maxSearchPages)
+	c.Check(got, HasLen, maxSearchPages)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositorySearchKeepsSearchURI(c *C) {
+	var err error
+	storeSearchURI, err = url.Parse("http://store.example/search")
+	c.Assert(err, IsNil)
+
+	repo := NewUbuntuStoreSnapRepository()
+	c.Check(repo.searchQuery("foo").String(), Equals, "http://store.example/search?q=foo")
+	c.Check(repo.searchURI.String(), Equals, "http://store.example/search")
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositorySearchPagerStaysOnTheStore(c *C) {
+	var got int
+	elsewhere := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got++
+	}))
+	defer elsewhere.Close()
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, mockSearchPage, 0, elsewhere.URL+"/search?q=foo&page=1")
+	}))
+	defer mockServer.Close()
+
+	var err error
+	storeSearchURI, err = url.Parse(mockServer.URL + "/search")
+	c.Assert(err, IsNil)
+
+	pager := NewUbuntuStoreSnapRepository().SearchPager("foo")
+	_, err = pager.Next()
+	c.Check(err, ErrorMatches, "the next page of the search .* is not on the store: .*")
+	c.Check(pager.More(), Equals, false)
+	c.Check(got, Equals, 0)
+}
@@ -0,0 +1,303 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+)
+
+// SeccompReport describes the syscalls that got denied while running an
+// app with DebugSeccomp
+type SeccompReport struct {
+	// Profile is the security profile of the app
+	Profile string
+	// Denied are the denied syscalls, in the order they got hit
+	Denied []string
+	// Caps maps the caps (policy groups) that allow some of the
+	// denied syscalls to these syscalls
+	Caps map[string][]string
+	// Unmatched are the denied syscalls that no cap allows, they can
+	// only be added via the "syscalls" of a security-override
+	Unmatched []string
+}
+
+// debugSeccompMaxRuns is the maximum number of times DebugSeccomp
+// (re)starts the app
+var debugSeccompMaxRuns = 20
+
+// debugSeccompTimeout is how long the app may run each time before
+// DebugSeccomp stops it
+var debugSeccompTimeout = 10 * time.Second
+
+// seccompDenial is a seccomp denial as found in the kernel log
+type seccompDenial struct {
+	pid     int
+	exe     string
+	syscall string
+}
+
+// e.g. audit: type=1326 audit(1430766107.122:16): auid=1000 uid=1000 gid=1000 ses=15 pid=1491 comm="env" exe="/bin/bash" sig=31 arch=40000028 syscall=983045 compat=0 ip=0xb6fb0bd6 code=0x0
+var seccompDenialRegexp = regexp.MustCompile(`type=1326 .* pid=([0-9]+) .*exe="([^"]*)" .*syscall=([0-9]+)`)
+
+func parseSeccompDenials(log []byte) (denials []seccompDenial) {
+	scanner := bufio.NewScanner(bytes.NewReader(log))
+	for scanner.Scan() {
+		match := seccompDenialRegexp.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		pid, err := strconv.Atoi(match[1])
+		if err != nil {
+			continue
+		}
+		denials = append(denials, seccompDenial{pid: pid, exe: match[2], syscall: match[3]})
+	}
+
+	return denials
+}
+
+var seccompDenials = seccompDenialsImpl
+
+func seccompDenialsImpl(since time.Time) ([]seccompDenial, error) {
+	log, err := kernelLog(since)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseSeccompDenials(log), nil
+}
+
+var resolveSyscall = resolveSyscallImpl
+
+func resolveSyscallImpl(nr string) (string, error) {
+	output, err := exec.Command("scmp_sys_resolver", nr).Output()
+	if err != nil {
+		return "", fmt.Errorf("can not resolve syscall %s: %v", nr, err)
+	}
+
+	return strings.TrimSpace(string(output)), nil
+}
+
+var runSeccompDebugApp = runSeccompDebugAppImpl
+
+// runSeccompDebugAppImpl runs the given command until it exits or the
+// timeout expires and returns its pid
+func runSeccompDebugAppImpl(argv []string, timeout time.Duration) (int, error) {
+	cmd := exec.Command(argv[0], argv[1:]...)
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		// the app getting killed by seccomp is what we are after
+		logger.Noticef("%v exited: %v", argv, err)
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		<-done
+	}
+
+	return cmd.Process.Pid, nil
+}
+
+// seccompDebugCmd returns the command line that runs the given app of
+// the snap under its confinement
+func seccompDebugCmd(part *SnapPart, app string) (argv []string, profile string, err error) {
+	m := part.m
+	baseDir := stripGlobalRootDir(part.basedir)
+
+	for _, binary := range m.Binaries {
+		if filepath.Base(binary.Name) == app {
+			argv = []string{binPathForBinary(baseDir, binary)}
+			break
+		}
+	}
+	if argv == nil {
+		for _, service := range m.ServiceYamls {
+			if service.Name == app && service.Start != "" {
+				argv = strings.Fields(service.Start)
+				argv[0] = filepath.Join(baseDir, argv[0])
+				break
+			}
+		}
+	}
+	if argv == nil {
+		return nil, "", ErrAppNotFound
+	}
+
+	profile, err = getSecurityProfile(m, app, part.basedir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return append([]string{"ubuntu-core-launcher", m.qualifiedName(part.origin), profile}, argv...), profile, nil
+}
+
+// DebugSeccomp runs the given app of the (active) snap and collects the
+// syscalls its seccomp profile denies. Every denied syscall is allowed
+// temporarily and the app run again, so that all of them are found
+// and not only the first one. The original profile is restored
+// afterwards. The report suggests the caps that allow the denied
+// syscalls.
+func DebugSeccomp(snap, app string) (*SeccompReport, error) {
+	installed, err := NewMetaLocalRepository().Installed()
+	if err != nil {
+		return nil, err
+	}
+
+	var part *SnapPart
+	for _, p := range FindSnapsByName(snap, installed) {
+		if p.IsActive() {
+			part, _ = p.(*SnapPart)
+			break
+		}
+	}
+	if part == nil {
+		return nil, ErrPackageNotFound
+	}
+
+	argv, profile, err := seccompDebugCmd(part, app)
+	if err != nil {
+		return nil, err
+	}
+
+	fn := filepath.Join(dirs.SnapSeccompDir, profile)
+	orig, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := helpers.AtomicWriteFile(fn, orig, 0644, 0); err != nil {
+			logger.Noticef("Failed to restore the seccomp profile %q: %v", fn, err)
+		}
+	}()
+
+	report := &SeccompReport{Profile: profile}
+	seen := make(map[string]bool)
+	allowed := append([]byte(nil), orig...)
+	for i := 0; i < debugSeccompMaxRuns; i++ {
+		start := time.Now()
+		pid, err := runSeccompDebugApp(argv, debugSeccompTimeout)
+		if err != nil {
+			return nil, err
+		}
+		denials, err := seccompDenials(start)
+		if err != nil {
+			return nil, err
+		}
+
+		found := false
+		for _, denial := range denials {
+			if denial.pid != pid && !strings.HasPrefix(denial.exe, part.basedir) {
+				continue
+			}
+			name, err := resolveSyscall(denial.syscall)
+			if err != nil {
+				return nil, err
+			}
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			found = true
+			report.Denied = append(report.Denied, name)
+			allowed = append(allowed, []byte(name+"\n")...)
+		}
+		if !found {
+			break
+		}
+
+		if err := helpers.AtomicWriteFile(fn, allowed, 0644, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	report.Caps, report.Unmatched, err = suggestSeccompCaps(report.Denied)
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// seccompPolicyGroupDirs returns the directories with the seccomp policy
+// groups of the system and of the installed frameworks
+func seccompPolicyGroupDirs() []string {
+	return []string{
+		filepath.Join(dirs.GlobalRootDir, "/usr/share/seccomp/policygroups", defaultPolicyVendor, fmt.Sprintf("%.2f", defaultPolicyVersion)),
+		filepath.Join(filepath.Dir(dirs.SnapSeccompDir), "policygroups"),
+	}
+}
+
+// suggestSeccompCaps finds the caps that allow the given syscalls
+func suggestSeccompCaps(syscalls []string) (caps map[string][]string, unmatched []string, err error) {
+	allowedBy := make(map[string][]string)
+	for _, dir := range seccompPolicyGroupDirs() {
+		groups, err := filepath.Glob(filepath.Join(dir, "*"))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, group := range groups {
+			content, err := ioutil.ReadFile(group)
+			if err != nil {
+				return nil, nil, err
+			}
+			for _, line := range strings.Split(string(content), "\n") {
+				line = strings.TrimSpace(line)
+				if line == "" || strings.HasPrefix(line, "#") {
+					continue
+				}
+				allowedBy[line] = append(allowedBy[line], filepath.Base(group))
+			}
+		}
+	}
+
+	caps = make(map[string][]string)
+	for _, syscall := range syscalls {
+		groups := allowedBy[syscall]
+		if len(groups) == 0 {
+			unmatched = append(unmatched, syscall)
+			continue
+		}
+		for _, group := range groups {
+			caps[group] = append(caps[group], syscall)
+		}
+	}
+
+	return caps, unmatched, nil
+}
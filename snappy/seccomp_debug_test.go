@@ -0,0 +1,106 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+)
+
+func (s *SnapTestSuite) TestParseSeccompDenials(c *C) {
+	log := []byte(`audit: type=1400 audit(1431384420.408:319): apparmor="DENIED" operation="mkdir" profile="foo_bar_0.1" name="/var/lib/foo" pid=637 comm="bar"
+audit: type=1326 audit(1430766107.122:16): auid=1000 uid=1000 gid=1000 ses=15 pid=1491 comm="env" exe="/bin/bash" sig=31 arch=40000028 syscall=983045 compat=0 ip=0xb6fb0bd6 code=0x0
+`)
+	c.Check(parseSeccompDenials(log), DeepEquals, []seccompDenial{
+		{pid: 1491, exe: "/bin/bash", syscall: "983045"},
+	})
+}
+
+func (s *SnapTestSuite) TestDebugSeccomp(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+
+	profile := helloAppComposedName + "_hello_1.10"
+	c.Assert(os.MkdirAll(dirs.SnapSeccompDir, 0755), IsNil)
+	fn := filepath.Join(dirs.SnapSeccompDir, profile)
+	c.Assert(ioutil.WriteFile(fn, []byte("read\n"), 0644), IsNil)
+
+	groupDir := filepath.Join(filepath.Dir(dirs.SnapSeccompDir), "policygroups")
+	c.Assert(os.MkdirAll(groupDir, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(groupDir, "network-service"), []byte("# comment\nbind\nlisten\n"), 0644), IsNil)
+
+	// every run hits one more syscall
+	var runs [][]string
+	pending := []string{"49", "50", "1000"}
+	runSeccompDebugApp = func(argv []string, timeout time.Duration) (int, error) {
+		runs = append(runs, argv)
+		return 42, nil
+	}
+	seccompDenials = func(since time.Time) ([]seccompDenial, error) {
+		if len(pending) == 0 {
+			return nil, nil
+		}
+		nr := pending[0]
+		pending = pending[1:]
+		return []seccompDenial{
+			{pid: 1, exe: "/bin/unrelated", syscall: "1"},
+			{pid: 42, exe: "/bin/hello", syscall: nr},
+		}, nil
+	}
+	resolveSyscall = func(nr string) (string, error) {
+		return map[string]string{"49": "bind", "50": "listen", "1000": "frobnicate"}[nr], nil
+	}
+	defer func() {
+		runSeccompDebugApp = runSeccompDebugAppImpl
+		seccompDenials = seccompDenialsImpl
+		resolveSyscall = resolveSyscallImpl
+	}()
+
+	report, err := DebugSeccomp("hello-app", "hello")
+	c.Assert(err, IsNil)
+	c.Check(report.Profile, Equals, profile)
+	c.Check(report.Denied, DeepEquals, []string{"bind", "listen", "frobnicate"})
+	c.Check(report.Caps, DeepEquals, map[string][]string{"network-service": {"bind", "listen"}})
+	c.Check(report.Unmatched, DeepEquals, []string{"frobnicate"})
+
+	c.Assert(runs, HasLen, 4)
+	c.Check(runs[0], DeepEquals, []string{"ubuntu-core-launcher", helloAppComposedName, profile, "/apps/" + helloAppComposedName + "/1.10/bin/hello"})
+
+	// the original profile is back
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "read\n")
+}
+
+func (s *SnapTestSuite) TestDebugSeccompUnknownApp(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+
+	_, err = DebugSeccomp("hello-app", "no-such-app")
+	c.Check(err, Equals, ErrAppNotFound)
+}
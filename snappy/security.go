@@ -24,7 +24,6 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 
@@ -33,6 +32,7 @@ import (
 	"github.com/ubuntu-core/snappy/dirs"
 	"github.com/ubuntu-core/snappy/logger"
 	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/release"
 )
 
 type apparmorJSONTemplate struct {
@@ -40,6 +40,7 @@ type apparmorJSONTemplate struct {
 	PolicyGroups  []string `json:"policy_groups"`
 	PolicyVendor  string   `json:"policy_vendor"`
 	PolicyVersion float64  `json:"policy_version"`
+	WritePath     []string `json:"write_path,omitempty"`
 }
 
 type securitySeccompOverride struct {
@@ -54,6 +55,10 @@ const defaultTemplate = "default"
 
 var defaultPolicyGroups = []string{"network-client"}
 
+// userDataWritePath allows access to the per-user data directories
+// ($HOME/apps/<name.origin>/<version>/) on personal
+var userDataWritePath = []string{"@{HOME}/apps/@{APP_PKGNAME}/**"}
+
 // TODO: autodetect, this won't work for personal
 const defaultPolicyVendor = "ubuntu-core"
 const defaultPolicyVersion = 15.04
@@ -66,6 +71,10 @@ func (s *SecurityDefinitions) generateApparmorJSONContent() ([]byte, error) {
 		PolicyVersion: defaultPolicyVersion,
 	}
 
+	if release.Get().Flavor == "personal" {
+		t.WritePath = userDataWritePath
+	}
+
 	// FIXME: this is snappy specific, on other systems like the
 	//        phone we may want different defaults.
 	if t.Template == "" && t.PolicyGroups == nil {
@@ -112,7 +121,7 @@ func handleApparmor(buildDir string, m *packageYaml, hookName string, s *Securit
 
 func getSecurityProfile(m *packageYaml, appName, baseDir string) (string, error) {
 	cleanedName := strings.Replace(appName, "/", "-", -1)
-	if m.Type == pkg.TypeFramework || m.Type == pkg.TypeOem {
+	if m.Type == pkg.TypeFramework || m.Type.IsGadget() {
 		return fmt.Sprintf("%s_%s_%s", m.Name, cleanedName, m.Version), nil
 	}
 
@@ -121,15 +130,8 @@ func getSecurityProfile(m *packageYaml, appName, baseDir string) (string, error)
 	return fmt.Sprintf("%s.%s_%s_%s", m.Name, origin, cleanedName, m.Version), err
 }
 
-var runScFilterGen = runScFilterGenImpl
-
-func runScFilterGenImpl(argv ...string) ([]byte, error) {
-	cmd := exec.Command(argv[0], argv[1:]...)
-	return cmd.Output()
-}
-
 // seccomp specific
-func generateSeccompPolicy(baseDir, appName string, sd SecurityDefinitions) ([]byte, error) {
+func generateSeccompPolicy(backend Backend, baseDir, appName string, sd SecurityDefinitions) ([]byte, error) {
 	if sd.SecurityPolicy != nil && sd.SecurityPolicy.Seccomp != "" {
 		fn := filepath.Join(baseDir, sd.SecurityPolicy.Seccomp)
 		content, err := ioutil.ReadFile(fn)
@@ -187,7 +189,6 @@ func generateSeccompPolicy(baseDir, appName string, sd SecurityDefinitions) ([]b
 
 	// Build up the command line
 	args := []string{
-		"sc-filtergen",
 		fmt.Sprintf("--include-policy-dir=%s", filepath.Dir(dirs.SnapSeccompDir)),
 		fmt.Sprintf("--policy-vendor=%s", policyVendor),
 		fmt.Sprintf("--policy-version=%.2f", policyVersion),
@@ -200,7 +201,7 @@ func generateSeccompPolicy(baseDir, appName string, sd SecurityDefinitions) ([]b
 		args = append(args, fmt.Sprintf("--syscalls=%s", strings.Join(syscalls, ",")))
 	}
 
-	content, err := runScFilterGen(args...)
+	content, err := backend.ScFilterGen(args...)
 	if err != nil {
 		logger.Noticef("%v failed", args)
 	}
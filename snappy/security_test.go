@@ -29,6 +29,7 @@ import (
 
 	"github.com/ubuntu-core/snappy/dirs"
 	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/release"
 )
 
 type SecurityTestSuite struct {
@@ -36,6 +37,7 @@ type SecurityTestSuite struct {
 	m                     *packageYaml
 	scFilterGenCall       []string
 	scFilterGenCallReturn []byte
+	backend               *mockBackend
 }
 
 var _ = Suite(&SecurityTestSuite{})
@@ -50,9 +52,12 @@ func (a *SecurityTestSuite) SetUpTest(c *C) {
 		Integration: make(map[string]clickAppHook),
 	}
 
+	release.Override(release.Release{Flavor: "core", Series: "15.04"})
+
 	a.scFilterGenCall = nil
 	a.scFilterGenCallReturn = nil
-	runScFilterGen = func(argv ...string) ([]byte, error) {
+	a.backend = newMockBackend()
+	a.backend.scFilterGen = func(argv ...string) ([]byte, error) {
 		a.scFilterGenCall = append(a.scFilterGenCall, argv...)
 		return a.scFilterGenCallReturn, nil
 	}
@@ -72,7 +77,7 @@ func (a *SecurityTestSuite) verifyApparmorFile(c *C, expected string) {
 func (a *SecurityTestSuite) TestSnappyNoSeccompOverrideEntry(c *C) {
 	sd := SecurityDefinitions{SecurityOverride: &SecurityOverrideDefinition{}}
 
-	_, err := generateSeccompPolicy(c.MkDir(), "appName", sd)
+	_, err := generateSeccompPolicy(a.backend, c.MkDir(), "appName", sd)
 	c.Assert(err, Equals, ErrNoSeccompPolicy)
 }
 
@@ -97,6 +102,30 @@ func (a *SecurityTestSuite) TestSnappyHandleApparmorSecurityDefault(c *C) {
 }`)
 }
 
+func (a *SecurityTestSuite) TestSnappyHandleApparmorPersonal(c *C) {
+	release.Override(release.Release{Flavor: "personal", Series: "15.04"})
+	sec := &SecurityDefinitions{}
+
+	a.m.Binaries = append(a.m.Binaries, Binary{Name: "app", SecurityDefinitions: *sec})
+	a.m.legacyIntegration(false)
+
+	err := handleApparmor(a.buildDir, a.m, "app", sec)
+	c.Assert(err, IsNil)
+
+	// verify file content
+	a.verifyApparmorFile(c, `{
+  "template": "default",
+  "policy_groups": [
+    "network-client"
+  ],
+  "policy_vendor": "ubuntu-core",
+  "policy_version": 15.04,
+  "write_path": [
+    "@{HOME}/apps/@{APP_PKGNAME}/**"
+  ]
+}`)
+}
+
 func (a *SecurityTestSuite) TestSnappyHandleApparmorCaps(c *C) {
 	sec := &SecurityDefinitions{
 		SecurityCaps: []string{"cap1", "cap2"},
@@ -211,12 +240,11 @@ func (a *SecurityTestSuite) TestSnappySeccompSecurityTemplate(c *C) {
 		SecurityTemplate: "something",
 	}
 
-	_, err := generateSeccompPolicy(c.MkDir(), "appName", sd)
+	_, err := generateSeccompPolicy(a.backend, c.MkDir(), "appName", sd)
 	c.Assert(err, IsNil)
 
 	// sc-filtergen is called with mostly defaults
 	c.Assert(a.scFilterGenCall, DeepEquals, []string{
-		"sc-filtergen",
 		fmt.Sprintf("--include-policy-dir=%s", filepath.Dir(dirs.SnapSeccompDir)),
 		"--policy-vendor=ubuntu-core",
 		"--policy-version=15.04",
@@ -232,12 +260,11 @@ func (a *SecurityTestSuite) TestSnappySeccompSecurityCaps(c *C) {
 		SecurityCaps:     []string{"cap1", "cap2"},
 	}
 
-	_, err := generateSeccompPolicy(c.MkDir(), "appName", sd)
+	_, err := generateSeccompPolicy(a.backend, c.MkDir(), "appName", sd)
 	c.Assert(err, IsNil)
 
 	// sc-filtergen is called with mostly defaults
 	c.Assert(a.scFilterGenCall, DeepEquals, []string{
-		"sc-filtergen",
 		fmt.Sprintf("--include-policy-dir=%s", filepath.Dir(dirs.SnapSeccompDir)),
 		"--policy-vendor=ubuntu-core",
 		"--policy-version=15.04",
@@ -264,12 +291,11 @@ policy-version: 18.10`), 0644)
 		},
 	}
 
-	_, err = generateSeccompPolicy(baseDir, "appName", sd)
+	_, err = generateSeccompPolicy(a.backend, baseDir, "appName", sd)
 	c.Assert(err, IsNil)
 
 	// sc-filtergen is called with custom seccomp options
 	c.Assert(a.scFilterGenCall, DeepEquals, []string{
-		"sc-filtergen",
 		fmt.Sprintf("--include-policy-dir=%s", filepath.Dir(dirs.SnapSeccompDir)),
 		"--policy-vendor=policy-vendor",
 		"--policy-version=18.10",
@@ -295,7 +321,7 @@ write`), 0644)
 		},
 	}
 
-	_, err = generateSeccompPolicy(baseDir, "appName", sd)
+	_, err = generateSeccompPolicy(a.backend, baseDir, "appName", sd)
 	c.Assert(err, IsNil)
 
 	// sc-filtergen is not called at all
@@ -0,0 +1,144 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+)
+
+// DefaultSelfTestTimeout is how long a self-test may run when the
+// binary does not specify a self-test-timeout
+var DefaultSelfTestTimeout = Timeout(30 * time.Second)
+
+// errSelfTestTimeout is returned if a self-test did not finish in time
+var errSelfTestTimeout = errors.New("timed out")
+
+// runSelfTestCmd runs the command, killing it if it takes longer
+// than the timeout
+func runSelfTestCmd(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	select {
+	case err := <-done:
+		return out.Bytes(), err
+	case <-time.After(timeout):
+		cmd.Process.Kill()
+		<-done
+		return out.Bytes(), errSelfTestTimeout
+	}
+}
+
+// selfTestCmd returns the command that runs the self-test of the
+// binary, confined like the binary itself
+func selfTestCmd(m *packageYaml, binary Binary, baseDir string) (*exec.Cmd, error) {
+	if err := verifyBinariesYaml(binary); err != nil {
+		return nil, err
+	}
+
+	argv := strings.Fields(binary.SelfTest)
+	if len(argv) == 0 {
+		return nil, fmt.Errorf("empty self-test for %s", binary.Name)
+	}
+
+	aaProfile, err := getSecurityProfile(m, binary.Name, baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	// it's fine for this to error out; we might be in a framework or sth
+	origin := originFromBasedir(baseDir)
+
+	envData := struct {
+		AppName     string
+		AppArch     string
+		AppPath     string
+		Version     string
+		UdevAppName string
+		Origin      string
+	}{
+		AppName:     m.Name,
+		AppArch:     helpers.UbuntuArchitecture(),
+		AppPath:     baseDir,
+		Version:     m.Version,
+		UdevAppName: m.qualifiedName(origin),
+		Origin:      origin,
+	}
+
+	args := append([]string{envData.UdevAppName, aaProfile, filepath.Join(baseDir, argv[0])}, argv[1:]...)
+	cmd := exec.Command("ubuntu-core-launcher", args...)
+	cmd.Dir = baseDir
+	cmd.Env = append(os.Environ(), helpers.GetBasicSnapEnvVars(envData)...)
+
+	return cmd, nil
+}
+
+// runSelfTests runs the self-tests of the binaries of the snap through
+// the given Backend. A failing self-test marks the install as degraded;
+// the returned error is set only if one of the failing binaries asked
+// for a rollback.
+func (s *SnapPart) runSelfTests(backend Backend) error {
+	var rollbackErr error
+
+	for _, binary := range s.m.Binaries {
+		if binary.SelfTest == "" {
+			continue
+		}
+
+		timeout := time.Duration(binary.SelfTestTimeout)
+		if timeout == 0 {
+			timeout = time.Duration(DefaultSelfTestTimeout)
+		}
+
+		cmd, err := selfTestCmd(s.m, binary, s.basedir)
+		var output []byte
+		if err == nil {
+			output, err = backend.RunSelfTest(cmd, timeout)
+		}
+		if err == nil {
+			continue
+		}
+
+		logger.Noticef("Install of %s %s is degraded: self-test of %s failed: %v (%q)", s.Name(), s.Version(), binary.Name, err, output)
+		if binary.SelfTestRollback && rollbackErr == nil {
+			rollbackErr = &ErrSelfTestFailed{Binary: binary.Name, Output: string(output), Err: err}
+		}
+	}
+
+	return rollbackErr
+}
@@ -0,0 +1,118 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+const packageSelfTest = `name: hello-app
+version: 1.10
+vendor: Michael Vogt <mvo@ubuntu.com>
+binaries:
+ - name: bin/hello
+   self-test: bin/hello --check
+ - name: bin/slow
+   self-test: bin/slow
+   self-test-timeout: 120000000000
+   self-test-rollback: true
+ - name: bin/other
+`
+
+func (s *SnapTestSuite) TestSelfTestCmd(c *C) {
+	yamlFile, err := s.makeInstalledMockSnap(packageSelfTest)
+	c.Assert(err, IsNil)
+	part, err := NewInstalledSnapPart(yamlFile, testOrigin)
+	c.Assert(err, IsNil)
+
+	cmd, err := selfTestCmd(part.m, part.m.Binaries[0], part.basedir)
+	c.Assert(err, IsNil)
+	c.Check(cmd.Args, DeepEquals, []string{
+		"ubuntu-core-launcher",
+		"hello-app." + testOrigin,
+		"hello-app." + testOrigin + "_hello_1.10",
+		filepath.Join(part.basedir, "bin/hello"),
+		"--check",
+	})
+	c.Check(cmd.Dir, Equals, part.basedir)
+	c.Check(strings.Join(cmd.Env, "\n"), Matches, "(?s).*\nSNAP_APP_PATH="+part.basedir+"\n.*")
+}
+
+func (s *SnapTestSuite) TestRunSelfTests(c *C) {
+	yamlFile, err := s.makeInstalledMockSnap(packageSelfTest)
+	c.Assert(err, IsNil)
+	part, err := NewInstalledSnapPart(yamlFile, testOrigin)
+	c.Assert(err, IsNil)
+
+	var ran []string
+	timeouts := map[string]time.Duration{}
+	s.backend.runSelfTest = func(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+		bin := filepath.Base(cmd.Args[3])
+		ran = append(ran, bin)
+		timeouts[bin] = timeout
+		return nil, nil
+	}
+
+	c.Assert(part.runSelfTests(s.backend), IsNil)
+	c.Check(ran, DeepEquals, []string{"hello", "slow"})
+	c.Check(timeouts["hello"], Equals, time.Duration(DefaultSelfTestTimeout))
+	c.Check(timeouts["slow"], Equals, 2*time.Minute)
+}
+
+func (s *SnapTestSuite) TestRunSelfTestsFailing(c *C) {
+	yamlFile, err := s.makeInstalledMockSnap(packageSelfTest)
+	c.Assert(err, IsNil)
+	part, err := NewInstalledSnapPart(yamlFile, testOrigin)
+	c.Assert(err, IsNil)
+
+	failing := "hello"
+	s.backend.runSelfTest = func(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+		if filepath.Base(cmd.Args[3]) == failing {
+			return []byte("broken"), errors.New("exit status 1")
+		}
+		return nil, nil
+	}
+
+	// only degraded
+	c.Check(part.runSelfTests(s.backend), IsNil)
+
+	// the binary asked for a rollback
+	failing = "slow"
+	err = part.runSelfTests(s.backend)
+	c.Assert(err, FitsTypeOf, &ErrSelfTestFailed{})
+	c.Check(err.(*ErrSelfTestFailed).Binary, Equals, "slow")
+	c.Check(err.(*ErrSelfTestFailed).Output, Equals, "broken")
+}
+
+func (s *SnapTestSuite) TestRunSelfTestCmdTimeout(c *C) {
+	output, err := runSelfTestCmd(exec.Command("sh", "-c", "echo started; sleep 10"), 100*time.Millisecond)
+	c.Check(err, Equals, errSelfTestTimeout)
+	c.Check(string(output), Equals, "started\n")
+
+	output, err = runSelfTestCmd(exec.Command("sh", "-c", "echo ok"), time.Minute)
+	c.Check(err, IsNil)
+	c.Check(string(output), Equals, "ok\n")
+}
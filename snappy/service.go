@@ -24,7 +24,6 @@ import (
 	"path/filepath"
 	"time"
 
-	"github.com/ubuntu-core/snappy/dirs"
 	"github.com/ubuntu-core/snappy/i18n"
 	"github.com/ubuntu-core/snappy/progress"
 	"github.com/ubuntu-core/snappy/systemd"
@@ -110,7 +109,7 @@ func FindServices(snapName string, serviceName string, pb progress.Meter) (Servi
 	return &serviceActor{
 		svcs: svcs,
 		pb:   pb,
-		sysd: systemd.New(dirs.GlobalRootDir, pb),
+		sysd: newSystemd(pb),
 	}, nil
 }
 
@@ -79,7 +79,6 @@ func (s *ServiceActorSuite) SetUpTest(c *C) {
 	c.Assert(os.MkdirAll(dirs.SnapMetaDir, 0755), IsNil)
 	// TODO: this mkdir hack is so enable doesn't fail; remove when enable is the same as the rest
 	c.Assert(os.MkdirAll(filepath.Join(dirs.GlobalRootDir, "/etc/systemd/system/multi-user.target.wants"), 0755), IsNil)
-	systemd.SystemctlCmd = s.myRun
 	systemd.JournalctlCmd = s.myJctl
 	_, err := makeInstalledMockSnap(dirs.GlobalRootDir, `name: hello-app
 version: 1.09
@@ -106,7 +105,10 @@ services:
 	s.jsvcs = nil
 	s.jouts = nil
 	s.jerrs = nil
-	s.pb = &MockProgressMeter{}
+	// the services are driven through the backend the meter carries
+	backend := newMockBackend()
+	backend.systemctl = s.myRun
+	s.pb = WithBackend(&MockProgressMeter{}, backend)
 }
 
 func (s *ServiceActorSuite) TestFindServicesNoPackages(c *C) {
@@ -0,0 +1,75 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package snappy
+
+import (
+	"os"
+
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// SideloadOptions are the options for loading and installing a local
+// snap file (see NewSnapPartFromSnapFile and Sideload)
+type SideloadOptions struct {
+	// AllowUnauthenticated allows snaps that can not be authenticated
+	AllowUnauthenticated bool
+	// DeveloperMode installs the snap with its security policy in
+	// complain mode (see SnapPart.DevMode); it implies
+	// AllowUnauthenticated
+	DeveloperMode bool
+	// SkipHooks does not run the hooks of the snap
+	SkipHooks bool
+}
+
+func (opts SideloadOptions) flags() InstallFlags {
+	var flags InstallFlags
+	if opts.AllowUnauthenticated {
+		flags |= AllowUnauthenticated
+	}
+	if opts.DeveloperMode {
+		flags |= AllowUnauthenticated | DeveloperMode
+	}
+	if opts.SkipHooks {
+		flags |= InhibitHooks
+	}
+
+	return flags
+}
+
+func sideloadOptionsFromFlags(flags InstallFlags) SideloadOptions {
+	return SideloadOptions{
+		AllowUnauthenticated: flags&AllowUnauthenticated != 0,
+		DeveloperMode:        flags&DeveloperMode != 0,
+		SkipHooks:            flags&InhibitHooks != 0,
+	}
+}
+
+// Sideload installs the given local snap file as a sideloaded snap,
+// returning its name. Unlike Install it never looks in the store.
+func Sideload(snapFile string, opts SideloadOptions, meter progress.Meter) (string, error) {
+	fi, err := os.Stat(snapFile)
+	if err != nil {
+		return "", &ErrInstallFailed{Snap: snapFile, OrigErr: err}
+	}
+	if !fi.Mode().IsRegular() {
+		return "", &ErrInstallFailed{Snap: snapFile, OrigErr: ErrNotASnapFile}
+	}
+
+	return InstallWithOptions(snapFile, InstallOptions{Flags: opts.flags(), Meter: meter})
+}
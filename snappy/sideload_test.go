@@ -0,0 +1,52 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package snappy
+
+import (
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *SnapTestSuite) TestSideloadOptionsFlags(c *C) {
+	c.Check(SideloadOptions{}.flags(), Equals, InstallFlags(0))
+	c.Check(SideloadOptions{AllowUnauthenticated: true}.flags(), Equals, AllowUnauthenticated)
+	c.Check(SideloadOptions{DeveloperMode: true}.flags(), Equals, AllowUnauthenticated|DeveloperMode)
+	c.Check(SideloadOptions{SkipHooks: true}.flags(), Equals, InhibitHooks)
+}
+
+func (s *SnapTestSuite) TestSideloadOptionsFromFlags(c *C) {
+	opts := sideloadOptionsFromFlags(AllowUnauthenticated | InhibitHooks | AllowOEM)
+	c.Check(opts, DeepEquals, SideloadOptions{AllowUnauthenticated: true, SkipHooks: true})
+	c.Check(opts.flags(), Equals, AllowUnauthenticated|InhibitHooks)
+
+	opts = sideloadOptionsFromFlags(AllowUnauthenticated | DeveloperMode)
+	c.Check(opts, DeepEquals, SideloadOptions{AllowUnauthenticated: true, DeveloperMode: true})
+}
+
+func (s *SnapTestSuite) TestSideloadMissingFile(c *C) {
+	_, err := Sideload(filepath.Join(s.tempdir, "no-such.snap"), SideloadOptions{}, &MockProgressMeter{})
+	c.Assert(err, FitsTypeOf, &ErrInstallFailed{})
+}
+
+func (s *SnapTestSuite) TestSideloadNotAFile(c *C) {
+	_, err := Sideload(s.tempdir, SideloadOptions{}, &MockProgressMeter{})
+	c.Assert(err, FitsTypeOf, &ErrInstallFailed{})
+	c.Check(err.(*ErrInstallFailed).OrigErr, Equals, ErrNotASnapFile)
+}
@@ -0,0 +1,215 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/pkg/remote"
+)
+
+// SnapDirRepository is a repository of the snaps in a directory instead
+// of the store, for the devices that are offline to install and update
+// from a USB stick or a pre-seeded cache. Each foo.snap in it comes with
+// its store manifest, foo.manifest (as saved in dirs.SnapMetaDir on
+// install), and optionally its icon, foo.icon.
+type SnapDirRepository struct {
+	dir string
+}
+
+// NewSnapDirRepository returns the SnapDirRepository of the given
+// directory
+func NewSnapDirRepository(dir string) *SnapDirRepository {
+	return &SnapDirRepository{dir: dir}
+}
+
+// Description describes the repository
+func (s *SnapDirRepository) Description() string {
+	return fmt.Sprintf("Snap directory repository for %s", s.dir)
+}
+
+func fileURL(path string) string {
+	return (&url.URL{Scheme: "file", Path: path}).String()
+}
+
+// parts returns the snaps of the directory
+func (s *SnapDirRepository) parts() ([]*RemoteSnapPart, error) {
+	dir, err := filepath.Abs(s.dir)
+	if err != nil {
+		return nil, err
+	}
+	manifests, err := filepath.Glob(filepath.Join(dir, "*.manifest"))
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []*RemoteSnapPart
+	for _, manifest := range manifests {
+		base := strings.TrimSuffix(manifest, ".manifest")
+
+		snapFile := base + ".snap"
+		fi, err := os.Stat(snapFile)
+		if err != nil {
+			logger.Noticef("Skipping %s: %v", manifest, err)
+			continue
+		}
+
+		content, err := ioutil.ReadFile(manifest)
+		if err != nil {
+			return nil, err
+		}
+		var pkg remote.Snap
+		if err := yaml.Unmarshal(content, &pkg); err != nil {
+			return nil, fmt.Errorf("can not read %s: %v", manifest, err)
+		}
+
+		// everything comes from the directory, credentials or not
+		pkg.AnonDownloadURL = fileURL(snapFile)
+		pkg.DownloadURL = pkg.AnonDownloadURL
+		pkg.DownloadMirrors = nil
+		pkg.Deltas = nil
+		pkg.AllowUnauthenticated = nil
+		if pkg.DownloadSize == 0 {
+			pkg.DownloadSize = fi.Size()
+		}
+		pkg.IconURL = ""
+		if helpers.FileExists(base + ".icon") {
+			pkg.IconURL = fileURL(base + ".icon")
+		}
+
+		part := NewRemoteSnapPart(pkg)
+		part.localFiles = true
+		parts = append(parts, part)
+	}
+
+	return parts, nil
+}
+
+// isSnap tells if the part is the snap of the given name (and origin,
+// if given)
+func isSnap(part Part, name, origin string) bool {
+	return part.Name() == name && (origin == "" || part.Origin() == origin)
+}
+
+// newest returns the newest version of the snap of the given name (and
+// origin, if given) in the directory, or nil
+func (s *SnapDirRepository) newest(name, origin string) (*RemoteSnapPart, error) {
+	parts, err := s.parts()
+	if err != nil {
+		return nil, err
+	}
+
+	var newest *RemoteSnapPart
+	for _, part := range parts {
+		if !isSnap(part, name, origin) {
+			continue
+		}
+		if newest == nil || CompareVersions(part, newest) > 0 {
+			newest = part
+		}
+	}
+
+	return newest, nil
+}
+
+// Details returns the newest version of the snap in the directory
+func (s *SnapDirRepository) Details(name string, origin string) ([]Part, error) {
+	part, err := s.newest(name, origin)
+	if err != nil {
+		return nil, err
+	}
+	if part == nil {
+		return nil, ErrPackageNotFound
+	}
+
+	return []Part{part}, nil
+}
+
+// DetailsRevision returns the given version of the snap in the
+// directory
+func (s *SnapDirRepository) DetailsRevision(name, origin, version string) ([]Part, error) {
+	parts, err := s.parts()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, part := range parts {
+		if isSnap(part, name, origin) && part.Version() == version {
+			return []Part{part}, nil
+		}
+	}
+
+	return nil, ErrPackageNotFound
+}
+
+// Updates returns the snaps in the directory that are newer than the
+// installed ones
+func (s *SnapDirRepository) Updates() ([]Part, error) {
+	installed, err := NewMetaLocalRepository().Installed()
+	if err != nil {
+		return nil, err
+	}
+
+	var updates []Part
+	for _, current := range installed {
+		if !current.IsActive() {
+			continue
+		}
+		part, err := s.newest(current.Name(), current.Origin())
+		if err != nil {
+			return nil, err
+		}
+		if part != nil && CompareVersions(part, current) > 0 {
+			updates = append(updates, part)
+		}
+	}
+
+	return updates, nil
+}
+
+// Installed returns the installed snaps, which the directory has none
+// of
+func (s *SnapDirRepository) Installed() ([]Part, error) {
+	return nil, nil
+}
+
+// All returns all the snaps in the directory
+func (s *SnapDirRepository) All() ([]Part, error) {
+	parts, err := s.parts()
+	if err != nil {
+		return nil, err
+	}
+
+	all := make([]Part, len(parts))
+	for i, part := range parts {
+		all[i] = part
+	}
+
+	return all, nil
+}
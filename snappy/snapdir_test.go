@@ -0,0 +1,148 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/pkg/remote"
+)
+
+// makeSnapDir makes a snap directory with hello-app 1.10 and 1.11 and
+// foo 1.0 in it
+func makeSnapDir(c *C) string {
+	dir := c.MkDir()
+	for _, snap := range []struct{ name, version string }{
+		{"hello-app", "1.10"},
+		{"hello-app", "1.11"},
+		{"foo", "1.0"},
+	} {
+		content := snap.name + " " + snap.version
+		manifest, err := yaml.Marshal(remote.Snap{
+			Name:            snap.name,
+			Origin:          testOrigin,
+			Version:         snap.version,
+			AnonDownloadURL: "https://store/" + snap.name,
+			IconURL:         "https://store/icon",
+			DownloadSha512:  sha512sum(content),
+		})
+		c.Assert(err, IsNil)
+
+		base := filepath.Join(dir, snap.name+"_"+snap.version)
+		c.Assert(ioutil.WriteFile(base+".manifest", manifest, 0644), IsNil)
+		c.Assert(ioutil.WriteFile(base+".snap", []byte(content), 0644), IsNil)
+	}
+
+	// a manifest without its snap is ignored
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "hello-app_2.0.manifest"), []byte("name: hello-app\nversion: 2.0\n"), 0644), IsNil)
+
+	return dir
+}
+
+func (s *SnapTestSuite) TestSnapDirRepositoryDetails(c *C) {
+	dir := makeSnapDir(c)
+	repo := NewSnapDirRepository(dir)
+
+	parts, err := repo.Details("hello-app", "")
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 1)
+	c.Check(parts[0].Version(), Equals, "1.11")
+	c.Check(parts[0].Origin(), Equals, testOrigin)
+	c.Check(parts[0].Icon(), Equals, "")
+	c.Check(parts[0].DownloadSize(), Equals, int64(len("hello-app 1.11")))
+
+	parts, err = repo.DetailsRevision("hello-app", testOrigin, "1.10")
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 1)
+	c.Check(parts[0].Version(), Equals, "1.10")
+
+	_, err = repo.Details("hello-app", "other-origin")
+	c.Check(err, Equals, ErrPackageNotFound)
+	_, err = repo.Details("bar", "")
+	c.Check(err, Equals, ErrPackageNotFound)
+
+	all, err := repo.All()
+	c.Assert(err, IsNil)
+	c.Check(all, HasLen, 3)
+}
+
+func (s *SnapTestSuite) TestSnapDirRepositoryDownload(c *C) {
+	dir := makeSnapDir(c)
+
+	parts, err := NewSnapDirRepository(dir).Details("foo", testOrigin)
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 1)
+
+	fn, err := parts[0].(*RemoteSnapPart).Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	defer os.Remove(fn)
+
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "foo 1.0")
+}
+
+func (s *SnapTestSuite) TestSnapDirRepositoryUpdates(c *C) {
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	updates, err := NewSnapDirRepository(makeSnapDir(c)).Updates()
+	c.Assert(err, IsNil)
+	c.Assert(updates, HasLen, 1)
+	c.Check(updates[0].Name(), Equals, "hello-app")
+	c.Check(updates[0].Version(), Equals, "1.11")
+}
+
+func (s *SnapTestSuite) TestSnapDirRepositoryUpdatesEpoch(c *C) {
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	// a lower version, but of a new epoch
+	dir := makeSnapDir(c)
+	manifest, err := yaml.Marshal(remote.Snap{
+		Name:    "hello-app",
+		Origin:  testOrigin,
+		Version: "0.1",
+		Epoch:   1,
+	})
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "hello-app_0.1.manifest"), manifest, 0644), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dir, "hello-app_0.1.snap"), []byte("hello-app 0.1"), 0644), IsNil)
+
+	updates, err := NewSnapDirRepository(dir).Updates()
+	c.Assert(err, IsNil)
+	c.Assert(updates, HasLen, 1)
+	c.Check(updates[0].Version(), Equals, "0.1")
+	c.Check(Epoch(updates[0]), Equals, 1)
+}
+
+func (s *SnapTestSuite) TestInstallOptionsSnapDirReplacesStore(c *C) {
+	opts := InstallOptions{SnapDir: "/some/dir"}
+	m := opts.configureStore(NewMetaStoreRepository())
+	c.Assert(m.all, HasLen, 1)
+	c.Check(m.all[0], DeepEquals, NewSnapDirRepository("/some/dir"))
+}
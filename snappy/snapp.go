@@ -21,6 +21,7 @@ package snappy
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -28,12 +29,13 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"reflect"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -41,13 +43,11 @@ import (
 	"github.com/ubuntu-core/snappy/dirs"
 	"github.com/ubuntu-core/snappy/helpers"
 	"github.com/ubuntu-core/snappy/logger"
-	"github.com/ubuntu-core/snappy/oauth"
 	"github.com/ubuntu-core/snappy/pkg"
 	"github.com/ubuntu-core/snappy/pkg/remote"
 	"github.com/ubuntu-core/snappy/policy"
 	"github.com/ubuntu-core/snappy/progress"
 	"github.com/ubuntu-core/snappy/release"
-	"github.com/ubuntu-core/snappy/systemd"
 )
 
 const (
@@ -60,11 +60,37 @@ const (
 type SharedName struct {
 	Alias Part
 	Parts []Part
+	// Rank is the position of the name in the results of the search
+	// (see SharedNames.Names)
+	Rank int
 }
 
 // SharedNames is a list of all packages and it's SharedName structure.
 type SharedNames map[string]*SharedName
 
+// Names returns the names, in the order of the results of the search
+func (s SharedNames) Names() []string {
+	names := sharedNamesByRank{shared: s, names: make([]string, 0, len(s))}
+	for name := range s {
+		names.names = append(names.names, name)
+	}
+	sort.Sort(names)
+
+	return names.names
+}
+
+// sharedNamesByRank sorts the names of SharedNames by their Rank
+type sharedNamesByRank struct {
+	shared SharedNames
+	names  []string
+}
+
+func (s sharedNamesByRank) Len() int      { return len(s.names) }
+func (s sharedNamesByRank) Swap(i, j int) { s.names[i], s.names[j] = s.names[j], s.names[i] }
+func (s sharedNamesByRank) Less(i, j int) bool {
+	return s.shared[s.names[i]].Rank < s.shared[s.names[j]].Rank
+}
+
 // IsAlias determines if origin is the one that is an alias for the
 // shared name.
 func (f *SharedName) IsAlias(origin string) bool {
@@ -161,6 +187,20 @@ type ServiceYaml struct {
 	// must be a pointer so that it can be "nil" and omitempty works
 	Ports *Ports `yaml:"ports,omitempty" json:"ports,omitempty"`
 
+	LogLimit *LogLimit `yaml:"log-limit,omitempty" json:"log-limit,omitempty"`
+
+	// start the service only once the network is up or the clock
+	// is synchronized (e.g. for TLS on boards without a RTC)
+	NeedsNetwork  bool `yaml:"needs-network,omitempty" json:"needs-network,omitempty"`
+	NeedsTimeSync bool `yaml:"needs-time-sync,omitempty" json:"needs-time-sync,omitempty"`
+
+	// UpgradeMode is how the service gets upgraded (see
+	// UpgradeModeBlueGreen), HealthCheck is a command that tells if
+	// the service works (for blue-green services)
+	UpgradeMode        string  `yaml:"upgrade-mode,omitempty" json:"upgrade-mode,omitempty"`
+	HealthCheck        string  `yaml:"health-check,omitempty" json:"health-check,omitempty"`
+	HealthCheckTimeout Timeout `yaml:"health-check-timeout,omitempty" json:"health-check-timeout,omitempty"`
+
 	SecurityDefinitions `yaml:",inline"`
 }
 
@@ -169,6 +209,17 @@ type Binary struct {
 	Name string `yaml:"name"`
 	Exec string `yaml:"exec"`
 
+	// Export makes a framework binary available to the apps that
+	// depend on the framework
+	Export bool `yaml:"export,omitempty"`
+
+	// SelfTest is a command run (confined) right after the snap is
+	// installed or upgraded; if it fails the install is degraded
+	SelfTest        string  `yaml:"self-test,omitempty"`
+	SelfTestTimeout Timeout `yaml:"self-test-timeout,omitempty"`
+	// SelfTestRollback rolls an upgrade back if the self-test fails
+	SelfTestRollback bool `yaml:"self-test-rollback,omitempty"`
+
 	SecurityDefinitions `yaml:",inline"`
 }
 
@@ -183,6 +234,19 @@ type SnapPart struct {
 	description string
 	deb         PackageFile
 	basedir     string
+
+	// keepServices are the services deactivate leaves running, for
+	// the new version to take over
+	keepServices map[string]bool
+
+	// pendingHook is the hook activate runs (see Install), with
+	// pendingHookEnv added to its environment
+	pendingHook    string
+	pendingHookEnv []string
+
+	// sideloadOpts are the options the part was loaded from its
+	// snap file with (see NewSnapPartFromSnapFile)
+	sideloadOpts SideloadOptions
 }
 
 var commasplitter = regexp.MustCompile(`\s*,\s*`).Split
@@ -216,6 +280,10 @@ type packageYaml struct {
 	Icon    string
 	Type    pkg.Type
 
+	// Epoch is bumped by the publisher when the versions start over
+	// (e.g. on a new versioning scheme), see CompareVersions
+	Epoch int `yaml:"epoch,omitempty"`
+
 	// the spec allows a string or a list here *ick* so we need
 	// to convert that into something sensible via reflect
 	DeprecatedArchitecture deprecarch `yaml:"architecture"`
@@ -224,11 +292,30 @@ type packageYaml struct {
 	DeprecatedFramework string   `yaml:"framework,omitempty"`
 	Frameworks          []string `yaml:"frameworks,omitempty"`
 
+	// the relations the snap publishes data under, and reads the data
+	// of (see SetRelationData)
+	Provides []string `yaml:"provides,omitempty"`
+	Requires []string `yaml:"requires,omitempty"`
+
+	// Confinement is how much the snap expects to be confined, it
+	// is strict if not given
+	Confinement pkg.Confinement `yaml:"confinement,omitempty"`
+
+	// DataMode is the permissions and group of the data directory
+	// of the snap (the default of the oem package if not given)
+	DataMode *DataMode `yaml:"data-mode,omitempty"`
+
 	ServiceYamls []ServiceYaml `yaml:"services,omitempty"`
 	Binaries     []Binary      `yaml:"binaries,omitempty"`
 
-	// oem snap only
+	// Hooks are the executables of the snap run at points of its
+	// life cycle (see runHook)
+	Hooks Hooks `yaml:"hooks,omitempty"`
+
+	// gadget snap only; the oem stanza is that of the legacy oem
+	// type, and is read as (and into) the gadget one
 	OEM    OEM          `yaml:"oem,omitempty"`
+	Gadget *Gadget      `yaml:"gadget,omitempty"`
 	Config SystemConfig `yaml:"config,omitempty"`
 
 	// this is a bit ugly, but right now integration is a one:one
@@ -243,6 +330,11 @@ type searchResults struct {
 	Payload struct {
 		Packages []remote.Snap `json:"clickindex:package"`
 	} `json:"_embedded"`
+	Links struct {
+		Next struct {
+			Href string `json:"href"`
+		} `json:"next"`
+	} `json:"_links"`
 }
 
 func parsePackageYamlFile(yamlPath string) (*packageYaml, error) {
@@ -275,23 +367,68 @@ func validatePackageYamlData(file string, yamlData []byte, m *packageYaml) error
 		}
 	}
 
+	if m.Epoch < 0 {
+		return &ErrInvalidYaml{
+			File: file,
+			Yaml: yamlData,
+			Err:  fmt.Errorf("negative epoch %d", m.Epoch),
+		}
+	}
+
 	// this is to prevent installation of legacy packages such as those that
 	// contain the origin/origin in the package name.
 	if strings.ContainsRune(m.Name, '.') {
 		return ErrPackageNameNotSupported
 	}
 
+	for hook, exec := range m.hooks() {
+		if err := verifyHookYaml(hook, exec); err != nil {
+			return &ErrInvalidYaml{File: file, Yaml: yamlData, Err: err}
+		}
+	}
+
 	// do all checks here
 	for _, binary := range m.Binaries {
 		if err := verifyBinariesYaml(binary); err != nil {
 			return err
 		}
+		if binary.Export && m.Type != pkg.TypeFramework {
+			return ErrExportNotFramework
+		}
 	}
 	for _, service := range m.ServiceYamls {
 		if err := verifyServiceYaml(service); err != nil {
 			return err
 		}
 	}
+	for _, relation := range append(m.Provides, m.Requires...) {
+		if !validRelationName(relation) {
+			return &ErrInvalidYaml{
+				File: file,
+				Yaml: yamlData,
+				Err:  fmt.Errorf("invalid relation name %q", relation),
+			}
+		}
+	}
+	if m.Confinement != "" && !isKnownConfinement(m.Confinement) {
+		return &ErrInvalidYaml{
+			File: file,
+			Yaml: yamlData,
+			Err:  fmt.Errorf("invalid confinement %q", m.Confinement),
+		}
+	}
+	if err := validateGadgetYaml(m); err != nil {
+		return &ErrInvalidYaml{File: file, Yaml: yamlData, Err: err}
+	}
+	oemSoftware := m.OEM.Software
+	if m.Gadget != nil {
+		oemSoftware = m.Gadget.Software
+	}
+	for _, mode := range []*DataMode{m.DataMode, oemSoftware.DataMode} {
+		if err := mode.validate(); err != nil {
+			return &ErrInvalidYaml{File: file, Yaml: yamlData, Err: err}
+		}
+	}
 
 	return nil
 }
@@ -325,6 +462,8 @@ func parsePackageYamlData(yamlData []byte, hasConfig bool) (*packageYaml, error)
 		m.DeprecatedFramework = ""
 	}
 
+	m.readGadget()
+
 	// For backward compatiblity we allow that there is no "exec:" line
 	// in the binary definition and that its derived from the name.
 	//
@@ -348,7 +487,7 @@ func parsePackageYamlData(yamlData []byte, hasConfig bool) (*packageYaml, error)
 }
 
 func (m *packageYaml) qualifiedName(origin string) string {
-	if m.Type == pkg.TypeFramework || m.Type == pkg.TypeOem {
+	if m.Type == pkg.TypeFramework || m.Type.IsGadget() {
 		return m.Name
 	}
 	return m.Name + "." + origin
@@ -368,6 +507,37 @@ func (m *packageYaml) checkForNameClashes() error {
 	return nil
 }
 
+// checkForBinaryClashes checks the binary wrappers of the snap would
+// not overwrite those of another active snap (e.g. the same binary of
+// two frameworks)
+func (m *packageYaml) checkForBinaryClashes() error {
+	wrappers := make(map[string]bool)
+	for _, bin := range m.Binaries {
+		wrappers[generateBinaryName(m, bin)] = true
+	}
+	if len(wrappers) == 0 {
+		return nil
+	}
+
+	installed, err := NewMetaLocalRepository().Installed()
+	if err != nil {
+		return err
+	}
+	for _, part := range installed {
+		snap, ok := part.(*SnapPart)
+		if !ok || !snap.IsActive() || snap.Name() == m.Name {
+			continue
+		}
+		for _, bin := range snap.m.Binaries {
+			if wrapper := generateBinaryName(snap.m, bin); wrappers[wrapper] {
+				return &ErrBinaryNameClash{Binary: filepath.Base(wrapper), Snap: QualifiedName(snap)}
+			}
+		}
+	}
+
+	return nil
+}
+
 func (m *packageYaml) checkForPackageInstalled(origin string) error {
 	part := ActiveSnapByName(m.Name)
 	if part == nil {
@@ -519,6 +689,11 @@ func (m *packageYaml) legacyIntegration(hasConfig bool) {
 	if hasConfig {
 		m.Integration["snappy-config"] = clickAppHook{"apparmor": "meta/snappy-config.apparmor"}
 	}
+
+	for hook := range m.hooks() {
+		integration := hookIntegration(hook)
+		m.Integration[integration] = clickAppHook{"apparmor": filepath.Join("meta", integration+".apparmor")}
+	}
 }
 
 // NewInstalledSnapPart returns a new SnapPart from the given yamlPath
@@ -538,15 +713,16 @@ func NewInstalledSnapPart(yamlPath, origin string) (*SnapPart, error) {
 }
 
 // NewSnapPartFromSnapFile loads a snap from the given (clickdeb) snap file.
+// The options are honoured when the part is installed.
 // Caller should call Close on the pkg.
 // TODO: expose that Close.
-func NewSnapPartFromSnapFile(snapFile string, origin string, unauthOk bool) (*SnapPart, error) {
+func NewSnapPartFromSnapFile(snapFile string, origin string, opts SideloadOptions) (*SnapPart, error) {
 	d, err := OpenPackageFile(snapFile)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := d.Verify(unauthOk); err != nil {
+	if err := d.Verify(opts.flags()&AllowUnauthenticated != 0); err != nil {
 		return nil, err
 	}
 
@@ -564,8 +740,8 @@ func NewSnapPartFromSnapFile(snapFile string, origin string, unauthOk bool) (*Sn
 	}
 
 	targetDir := dirs.SnapAppsDir
-	// the "oem" parts are special
-	if m.Type == pkg.TypeOem {
+	// the gadget parts are special
+	if m.Type.IsGadget() {
 		targetDir = dirs.SnapOemDir
 	}
 
@@ -577,10 +753,11 @@ func NewSnapPartFromSnapFile(snapFile string, origin string, unauthOk bool) (*Sn
 	instDir := filepath.Join(targetDir, fullName, m.Version)
 
 	return &SnapPart{
-		basedir: instDir,
-		origin:  origin,
-		m:       m,
-		deb:     d,
+		basedir:      instDir,
+		origin:       origin,
+		m:            m,
+		deb:          d,
+		sideloadOpts: opts,
 	}, nil
 }
 
@@ -612,6 +789,12 @@ func NewSnapPartFromYaml(yamlPath, origin string, m *packageYaml) (*SnapPart, er
 		part.isActive = true
 	}
 
+	// the confinement the snap got installed with (e.g. developer
+	// mode) is recorded in its manifest
+	if cm, err := readClickManifestFromClickDir(part.basedir); err == nil && cm.Confinement != "" {
+		m.Confinement = cm.Confinement
+	}
+
 	// get the click *title* from readme.md and use that as the *description*.
 	if description, _, err := parseReadme(filepath.Join(part.basedir, "meta", "readme.md")); err == nil {
 		part.description = description
@@ -699,6 +882,11 @@ func (s *SnapPart) Vendor() string {
 	return s.m.Vendor
 }
 
+// Epoch returns the epoch of the version (see CompareVersions)
+func (s *SnapPart) Epoch() int {
+	return s.m.Epoch
+}
+
 // Hash returns the hash
 func (s *SnapPart) Hash() string {
 	return s.hash
@@ -733,16 +921,33 @@ func (s *SnapPart) IsInstalled() bool {
 	return s.isInstalled
 }
 
-// InstalledSize returns the size of the installed snap
+// installedSizes caches the sizes of the installed snaps, by their
+// basedir and its modification time (the same version can get
+// installed again, after it got removed)
+var (
+	installedSizesMu sync.Mutex
+	installedSizes   = make(map[string]int64)
+)
+
+// InstalledSize returns the size of the installed snap, -1 if it is
+// not there (anymore)
 func (s *SnapPart) InstalledSize() int64 {
-	// FIXME: cache this at install time maybe?
-	totalSize := int64(0)
-	f := func(_ string, info os.FileInfo, err error) error {
-		totalSize += info.Size()
-		return err
+	st, err := os.Stat(s.basedir)
+	if err != nil {
+		return -1
+	}
+	key := fmt.Sprintf("%s@%d", s.basedir, st.ModTime().UnixNano())
+
+	installedSizesMu.Lock()
+	defer installedSizesMu.Unlock()
+
+	size, ok := installedSizes[key]
+	if !ok {
+		size = treeSize(s.basedir)
+		installedSizes[key] = size
 	}
-	filepath.Walk(s.basedir, f)
-	return totalSize
+
+	return size
 }
 
 // DownloadSize returns the dowload size
@@ -781,26 +986,44 @@ func (s *SnapPart) OemConfig() SystemConfig {
 
 // Install installs the snap
 func (s *SnapPart) Install(inter progress.Meter, flags InstallFlags) (name string, err error) {
+	flags |= s.sideloadOpts.flags()
 	allowOEM := (flags & AllowOEM) != 0
 	inhibitHooks := (flags & InhibitHooks) != 0
+	inhibitRestart := (flags & InhibitRestart) != 0
+	leaveInactive := (flags & LeaveInactive) != 0
+
+	unlock, err := lockInstallRemove()
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
 
 	if s.IsInstalled() {
 		return "", ErrAlreadyInstalled
 	}
 
+	// developer mode overrides the confinement the snap asks for (if
+	// the device accepts it, see CanInstall); it gets recorded in the
+	// manifest on unpacking
+	if (flags & DeveloperMode) != 0 {
+		s.m.Confinement = pkg.ConfinementDevMode
+	}
+
 	if err := s.CanInstall(allowOEM, inter); err != nil {
 		return "", err
 	}
 
-	manifestData, err := s.deb.ControlMember("manifest")
-	if err != nil {
-		logger.Noticef("Snap inspect failed for %q: %v", s.Name(), err)
+	if (flags & DryRun) != 0 {
+		return s.Name(), nil
+	}
+
+	if err := checkSpace(s.Name(), s.spaceNeeded()); err != nil {
 		return "", err
 	}
 
-	// the "oem" parts are special
-	if s.Type() == pkg.TypeOem {
-		if err := installOemHardwareUdevRules(s.m); err != nil {
+	// the gadget parts are special
+	if s.Type().IsGadget() {
+		if err := installOemHardwareUdevRules(s.m, backendOf(inter)); err != nil {
 			return "", err
 		}
 	}
@@ -816,38 +1039,20 @@ func (s *SnapPart) Install(inter progress.Meter, flags InstallFlags) (name strin
 		}
 	}
 
-	if err := os.MkdirAll(s.basedir, 0755); err != nil {
-		logger.Noticef("Can not create %q: %v", s.basedir, err)
-		return "", err
-	}
-
-	// if anything goes wrong here we cleanup
+	// if anything goes wrong here we undo what was done, down to
+	// the unpacking
+	tx := &transaction{op: "the install of " + fullName + " " + s.Version()}
 	defer func() {
 		if err != nil {
-			if e := os.RemoveAll(s.basedir); e != nil && !os.IsNotExist(e) {
-				logger.Noticef("Failed to remove %q: %v", s.basedir, e)
-			}
+			tx.rollback()
 		}
 	}()
 
-	// we need to call the external helper so that we can reliable drop
-	// privs
-	if err := s.deb.UnpackWithDropPrivs(s.basedir, dirs.GlobalRootDir); err != nil {
-		return "", err
-	}
-
-	// legacy, the hooks (e.g. apparmor) need this. Once we converted
-	// all hooks this can go away
-	clickMetaDir := filepath.Join(s.basedir, ".click", "info")
-	if err := os.MkdirAll(clickMetaDir, 0755); err != nil {
-		return "", err
-	}
-	if err := writeCompatManifestJSON(clickMetaDir, manifestData, s.origin); err != nil {
-		return "", err
-	}
-
-	// write the hashes now
-	if err := s.deb.ExtractHashes(filepath.Join(s.basedir, "meta")); err != nil {
+	if err := tx.do("the unpacking", func() error {
+		return s.unpack(inter)
+	}, func() error {
+		return os.RemoveAll(s.basedir)
+	}); err != nil {
 		return "", err
 	}
 
@@ -858,47 +1063,72 @@ func (s *SnapPart) Install(inter progress.Meter, flags InstallFlags) (name strin
 	// started then copy the data
 	//
 	// otherwise just create a empty data dir
-	if oldPart != nil {
-		// we need to stop making it active
-		err = oldPart.deactivate(inhibitHooks, inter)
-		defer func() {
-			if err != nil {
-				if cerr := oldPart.activate(inhibitHooks, inter); cerr != nil {
-					logger.Noticef("Setting old version back to active failed: %v", cerr)
-				}
+	//
+	// when the new version is left inactive the previous version
+	// keeps running, so its data is copied as is
+	makeData := func() error {
+		if oldPart != nil {
+			if err := copySnapData(fullName, oldPart.Version(), s.Version()); err != nil {
+				return err
 			}
-		}()
-		if err != nil {
+		}
+
+		return makeDataDir(dataDir, s.m.dataMode())
+	}
+	removeData := func() error {
+		return removeSnapData(fullName, s.Version())
+	}
+
+	if leaveInactive {
+		if err := tx.do("the data", makeData, removeData); err != nil {
 			return "", err
 		}
 
-		err = copySnapData(fullName, oldPart.Version(), s.Version())
-	} else {
-		err = os.MkdirAll(dataDir, 0755)
+		return s.Name(), nil
 	}
 
-	defer func() {
-		if err != nil {
-			if cerr := removeSnapData(fullName, s.Version()); cerr != nil {
-				logger.Noticef("When cleaning up data for %s %s: %v", s.Name(), s.Version(), cerr)
-			}
+	if oldPart != nil {
+		// the blue/green services keep running until the new
+		// version is healthy
+		if !inhibitHooks {
+			oldPart.keepServices = blueGreenHandover(oldPart.m, s.m)
 		}
-	}()
 
-	if err != nil {
+		// we need to stop making it active
+		if err := tx.do("the deactivation of "+oldPart.Version(), func() error {
+			return oldPart.deactivate(inhibitHooks, inter)
+		}, func() error {
+			return oldPart.reactivate(inhibitHooks, inter)
+		}); err != nil {
+			return "", err
+		}
+	}
+
+	if err := tx.do("the data", makeData, removeData); err != nil {
 		return "", err
 	}
 
-	// and finally make active
-	err = s.activate(inhibitHooks, inter)
-	defer func() {
-		if err != nil && oldPart != nil {
-			if cerr := oldPart.activate(inhibitHooks, inter); cerr != nil {
-				logger.Noticef("When setting old %s version back to active: %v", s.Name(), cerr)
-			}
+	// and finally make active, running the install (or upgrade)
+	// hook; activate undoes itself if it fails, so the new version
+	// is only active if it succeeded
+	s.pendingHook = "install"
+	if oldPart != nil {
+		// the data is copied over by now, for the hook to migrate
+		s.pendingHook = "upgrade"
+		s.pendingHookEnv = []string{"OLD_VERSION=" + oldPart.Version(), "NEW_VERSION=" + s.Version()}
+	}
+	if err := tx.do("the activation", func() error {
+		return s.activate(inhibitHooks, inter)
+	}, func() error {
+		if err := s.deactivate(inhibitHooks, inter); err != ErrSnapNotActive {
+			return err
 		}
-	}()
-	if err != nil {
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	if err = s.switchBlueGreen(oldPart, inhibitHooks, inter); err != nil {
 		return "", err
 	}
 
@@ -909,27 +1139,29 @@ func (s *SnapPart) Install(inter progress.Meter, flags InstallFlags) (name strin
 			return "", err
 		}
 
-		sysd := systemd.New(dirs.GlobalRootDir, inter)
+		sysd := newSystemd(inter)
 		stopped := make(map[string]time.Duration)
 		defer func() {
 			if err != nil {
 				for serviceName := range stopped {
 					if e := sysd.Start(serviceName); e != nil {
-						inter.Notify(fmt.Sprintf("unable to restart %s with the old %s: %s", serviceName, s.Name(), e))
+						progress.NotifyMessage(inter, progress.NewMessage(progress.MsgRestartOldFailed, serviceName, s.Name(), e))
 					}
 				}
 			}
 		}()
 
 		for _, dep := range deps {
-			if !dep.IsActive() {
+			// the services of the dependents pick up the new
+			// security policy when they get restarted next
+			if !dep.IsActive() || inhibitRestart {
 				continue
 			}
 			for _, svc := range dep.ServiceYamls() {
 				serviceName := filepath.Base(generateServiceFileName(dep.m, svc))
 				timeout := time.Duration(svc.StopTimeout)
 				if err = sysd.Stop(serviceName, timeout); err != nil {
-					inter.Notify(fmt.Sprintf("unable to stop %s; aborting install: %s", serviceName, err))
+					progress.NotifyMessage(inter, progress.NewMessage(progress.MsgStopFailed, serviceName, err))
 					return "", err
 				}
 				stopped[serviceName] = timeout
@@ -945,25 +1177,89 @@ func (s *SnapPart) Install(inter progress.Meter, flags InstallFlags) (name strin
 			if err != nil {
 				for serviceName, timeout := range started {
 					if e := sysd.Stop(serviceName, timeout); e != nil {
-						inter.Notify(fmt.Sprintf("unable to stop %s with the old %s: %s", serviceName, s.Name(), e))
+						progress.NotifyMessage(inter, progress.NewMessage(progress.MsgStopOldFailed, serviceName, s.Name(), e))
 					}
 				}
 			}
 		}()
 		for serviceName, timeout := range stopped {
 			if err = sysd.Start(serviceName); err != nil {
-				inter.Notify(fmt.Sprintf("unable to restart %s; aborting install: %s", serviceName, err))
+				progress.NotifyMessage(inter, progress.NewMessage(progress.MsgRestartFailed, serviceName, err))
 				return "", err
 			}
 			started[serviceName] = timeout
 		}
+
+		// hand the snap what was published under its relations
+		for _, relation := range s.m.Requires {
+			if e := refreshRelation(relation); e != nil {
+				logger.Noticef("Failed to refresh the relation %s: %v", relation, e)
+			}
+		}
+
+		// a failing self-test only undoes an upgrade; there is
+		// nothing to roll back to on a fresh install
+		if err = s.runSelfTests(backendOf(inter)); err != nil && oldPart != nil {
+			return "", err
+		}
 	}
 
 	return s.Name(), nil
 }
 
+// unpack unpacks the snap into its basedir, with its hashes and the
+// legacy click manifest
+func (s *SnapPart) unpack(inter progress.Meter) error {
+	manifestData, err := s.deb.ControlMember("manifest")
+	if err != nil {
+		logger.Noticef("Snap inspect failed for %q: %v", s.Name(), err)
+		return err
+	}
+
+	// the same version may be there already (e.g. it gets installed
+	// again): the archive is unpacked into a clean directory, so that
+	// only its files get verified and end up in the snap
+	if err := os.RemoveAll(s.basedir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.basedir, 0755); err != nil {
+		logger.Noticef("Can not create %q: %v", s.basedir, err)
+		return err
+	}
+
+	// we need to call the external helper so that we can reliable
+	// drop privs
+	if err := s.deb.UnpackWithDropPrivs(s.basedir, dirs.GlobalRootDir, inter); err != nil {
+		return err
+	}
+
+	// write the hashes now, and make sure the archive had just what
+	// they list
+	if err := s.deb.ExtractHashes(filepath.Join(s.basedir, "meta")); err != nil {
+		return err
+	}
+	if err := verifyUnpackedFiles(QualifiedName(s), s.basedir); err != nil {
+		return err
+	}
+
+	// legacy, the hooks (e.g. apparmor) need this. Once we converted
+	// all hooks this can go away
+	clickMetaDir := filepath.Join(s.basedir, ".click", "info")
+	if err := os.MkdirAll(clickMetaDir, 0755); err != nil {
+		return err
+	}
+
+	return writeCompatManifestJSON(clickMetaDir, manifestData, s.origin, s.m.Confinement)
+}
+
 // SetActive sets the snap active
 func (s *SnapPart) SetActive(active bool, pb progress.Meter) (err error) {
+	unlock, err := lockSystem(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	if active {
 		return s.activate(false, pb)
 	}
@@ -971,7 +1267,15 @@ func (s *SnapPart) SetActive(active bool, pb progress.Meter) (err error) {
 	return s.deactivate(false, pb)
 }
 
-func (s *SnapPart) activate(inhibitHooks bool, inter interacter) error {
+// activate makes the snap the active version; if any of the steps
+// fails the ones done are undone, and the previously active version
+// (if any) is made active again
+func (s *SnapPart) activate(inhibitHooks bool, inter interacter) (err error) {
+	if s.Type().IsGadget() {
+		// the oem package says which confinement the device accepts
+		defer forgetAllowedConfinement()
+	}
+
 	currentActiveSymlink := filepath.Join(s.basedir, "..", "current")
 	currentActiveDir, _ := filepath.EvalSymlinks(currentActiveSymlink)
 
@@ -980,6 +1284,13 @@ func (s *SnapPart) activate(inhibitHooks bool, inter interacter) error {
 		return nil
 	}
 
+	tx := &transaction{op: "the activation of " + QualifiedName(s) + " " + s.Version()}
+	defer func() {
+		if err != nil {
+			tx.rollback()
+		}
+	}()
+
 	// there is already an active part
 	if currentActiveDir != "" {
 		// TODO: support switching origins
@@ -988,34 +1299,83 @@ func (s *SnapPart) activate(inhibitHooks bool, inter interacter) error {
 		if err != nil {
 			return err
 		}
-		if err := oldPart.deactivate(inhibitHooks, inter); err != nil {
+		if err := tx.do("the deactivation of "+oldPart.Version(), func() error {
+			return oldPart.deactivate(inhibitHooks, inter)
+		}, func() error {
+			return oldPart.reactivate(inhibitHooks, inter)
+		}); err != nil {
 			return err
 		}
 	}
 
+	if err := ensureStorageMount(QualifiedName(s), inter); err != nil {
+		return err
+	}
+
 	if s.Type() == pkg.TypeFramework {
-		if err := policy.Install(s.Name(), s.basedir, dirs.GlobalRootDir); err != nil {
+		if err := tx.do("the framework policy", func() error {
+			return policy.Install(s.Name(), s.basedir, dirs.GlobalRootDir)
+		}, func() error {
+			return policy.Remove(s.Name(), s.basedir, dirs.GlobalRootDir)
+		}); err != nil {
 			return err
 		}
 	}
 
-	if err := installClickHooks(s.basedir, s.m, s.origin, inhibitHooks); err != nil {
-		// cleanup the failed hooks
-		removeClickHooks(s.m, s.origin, inhibitHooks)
+	if err := tx.do("the gadget connections", func() error {
+		return connectSnap(s.m, s.origin)
+	}, func() error {
+		return disconnectSnap(s.m, s.origin)
+	}); err != nil {
+		return err
+	}
+
+	if err := tx.do("the click hooks", func() error {
+		return installClickHooks(s.basedir, s.m, s.origin, inhibitHooks, backendOf(inter))
+	}, func() error {
+		return removeClickHooks(s.m, s.origin, inhibitHooks, backendOf(inter))
+	}); err != nil {
 		return err
 	}
 
 	// generate the security policy from the package.yaml
-	if err := s.m.addSecurityPolicy(s.basedir); err != nil {
+	if err := tx.do("the security policy", func() error {
+		return s.m.addSecurityPolicy(s.basedir, backendOf(inter))
+	}, func() error {
+		return s.m.removeSecurityPolicy(s.basedir, backendOf(inter))
+	}); err != nil {
 		return err
 	}
 
 	// add the "binaries:" from the package.yaml
-	if err := s.m.addPackageBinaries(s.basedir); err != nil {
+	if err := tx.do("the binaries", func() error {
+		return s.m.addPackageBinaries(s.basedir)
+	}, func() error {
+		return s.m.removePackageBinaries(s.basedir)
+	}); err != nil {
+		return err
+	}
+	if err := tx.do("the exported binaries", func() error {
+		return s.m.addExportedBinaries(s.basedir)
+	}, func() error {
+		return s.m.removeExportedBinaries()
+	}); err != nil {
 		return err
 	}
+	// a pending hook (that of the install or upgrade) sets things
+	// up before the services start
+	if s.pendingHook != "" && !inhibitHooks {
+		if err := s.runHook(s.pendingHook, backendOf(inter), s.pendingHookEnv...); err != nil {
+			return err
+		}
+	}
+
 	// add the "services:" from the package.yaml
-	if err := s.m.addPackageServices(s.basedir, inhibitHooks, inter); err != nil {
+	if err := tx.do("the services", func() error {
+		return s.m.addPackageServices(s.basedir, inhibitHooks, inter)
+	}, func() error {
+		return s.m.removePackageServices(s.basedir, inter)
+	}); err != nil {
 		return err
 	}
 
@@ -1030,7 +1390,14 @@ func (s *SnapPart) activate(inhibitHooks bool, inter interacter) error {
 	}
 
 	// symlink is relative to parent dir
-	if err := os.Symlink(filepath.Base(s.basedir), currentActiveSymlink); err != nil {
+	if err := tx.do("the current symlink", func() error {
+		return os.Symlink(filepath.Base(s.basedir), currentActiveSymlink)
+	}, func() error {
+		if err := os.Remove(currentActiveSymlink); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}); err != nil {
 		return err
 	}
 
@@ -1038,10 +1405,33 @@ func (s *SnapPart) activate(inhibitHooks bool, inter interacter) error {
 		return err
 	}
 
-	return os.Symlink(filepath.Base(s.basedir), currentDataSymlink)
+	if err := os.Symlink(filepath.Base(s.basedir), currentDataSymlink); err != nil {
+		return err
+	}
+
+	// what Revert goes back to
+	recordOperation(historyActivate, QualifiedName(s), s.Version(), nil, nil)
+
+	return nil
+}
+
+// reactivate makes the snap active again after its deactivation, which
+// may have failed halfway and left the current symlink behind; without
+// that activate has it all redone
+func (s *SnapPart) reactivate(inhibitHooks bool, inter interacter) error {
+	currentSymlink := filepath.Join(s.basedir, "..", "current")
+	if err := os.Remove(currentSymlink); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return s.activate(inhibitHooks, inter)
 }
 
 func (s *SnapPart) deactivate(inhibitHooks bool, inter interacter) error {
+	if s.Type().IsGadget() {
+		defer forgetAllowedConfinement()
+	}
+
 	currentSymlink := filepath.Join(s.basedir, "..", "current")
 
 	// sanity check
@@ -1061,11 +1451,15 @@ func (s *SnapPart) deactivate(inhibitHooks bool, inter interacter) error {
 		return err
 	}
 
-	if err := s.m.removePackageServices(s.basedir, inter); err != nil {
+	if err := s.m.removeExportedBinaries(); err != nil {
+		return err
+	}
+
+	if err := s.m.removePackageServicesKeeping(s.basedir, s.keepServices, inter); err != nil {
 		return err
 	}
 
-	if err := s.m.removeSecurityPolicy(s.basedir); err != nil {
+	if err := s.m.removeSecurityPolicy(s.basedir, backendOf(inter)); err != nil {
 		return err
 	}
 
@@ -1075,7 +1469,7 @@ func (s *SnapPart) deactivate(inhibitHooks bool, inter interacter) error {
 		}
 	}
 
-	if err := removeClickHooks(s.m, s.origin, inhibitHooks); err != nil {
+	if err := removeClickHooks(s.m, s.origin, inhibitHooks, backendOf(inter)); err != nil {
 		return err
 	}
 
@@ -1094,10 +1488,16 @@ func (s *SnapPart) deactivate(inhibitHooks bool, inter interacter) error {
 
 // Uninstall remove the snap from the system
 func (s *SnapPart) Uninstall(pb progress.Meter) (err error) {
+	return s.uninstall(pb, DoRemovePermanently)
+}
+
+// uninstall removes the snap from the system, either permanently or
+// by moving it to the trash (see RestoreRemoved), depending on flags
+func (s *SnapPart) uninstall(pb progress.Meter, flags RemoveFlags) (err error) {
 	// OEM snaps should not be removed as they are a key
 	// building block for OEMs. Prunning non active ones
 	// is acceptible.
-	if s.m.Type == pkg.TypeOem && s.IsActive() {
+	if s.m.Type.IsGadget() && s.IsActive() {
 		return ErrPackageNotRemovable
 	}
 
@@ -1105,6 +1505,15 @@ func (s *SnapPart) Uninstall(pb progress.Meter) (err error) {
 		return ErrPackageNotRemovable
 	}
 
+	// the dependents need to be evaluated with the lock held, so
+	// that a parallel removal (or install) of a dependent can not
+	// interleave between the check and the removal itself
+	unlock, err := lockInstallRemove()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
 	deps, err := s.DependentNames()
 	if err != nil {
 		return err
@@ -1113,18 +1522,68 @@ func (s *SnapPart) Uninstall(pb progress.Meter) (err error) {
 		return ErrFrameworkInUse(deps)
 	}
 
-	if err := s.remove(pb); err != nil {
+	// a parallel removal may have got there first
+	if !helpers.FileExists(s.basedir) {
+		return ErrPackageNotFound
+	}
+
+	// the data is gone for good with a purge, the snap gets the
+	// chance to save it while it is all there
+	if flags&DoRemovePurge != 0 {
+		if err := exportDataBeforePurge([]*SnapPart{s}, pb); err != nil {
+			return err
+		}
+	}
+
+	// the remove hook runs while the snap is all there; its profile
+	// is only loaded while the snap is active, and removing an
+	// inactive version does not remove the snap anyway
+	wasActive := s.IsActive()
+	if wasActive {
+		if err := s.runHook("remove", backendOf(pb)); err != nil {
+			return err
+		}
+	}
+
+	if flags&(DoRemovePermanently|DoRemovePurge) != 0 {
+		err = s.remove(pb)
+	} else {
+		err = s.moveToTrash(pb, flags&DoRemoveData != 0)
+	}
+	if err != nil {
 		return err
 	}
 
-	return RemoveAllHWAccess(QualifiedName(s))
+	// what the snap published is gone with it
+	if wasActive {
+		if err := withdrawRelations(s); err != nil {
+			logger.Noticef("Failed to withdraw the relations of %s: %v", QualifiedName(s), err)
+		}
+	}
+
+	// the hold is on the snap, not on a version
+	versions, err := filepath.Glob(filepath.Join(filepath.Dir(s.basedir), "*", "meta", "package.yaml"))
+	if err == nil && len(versions) == 0 {
+		removeHold(QualifiedName(s))
+	}
+
+	if flags&DoRemovePurge != 0 {
+		todo := []purgedVersion{{QualifiedName(s), s.Version()}}
+		if err := purgeVersions(QualifiedName(s), todo, pb); err != nil {
+			return err
+		}
+	}
+
+	return RemoveAllHWAccess(QualifiedName(s), pb)
 }
 
-func (s *SnapPart) remove(inter interacter) (err error) {
+// unlink removes the hooks and, if active, all the generated
+// artifacts of the snap; the snap itself is left on disk
+func (s *SnapPart) unlink(inter interacter) error {
 	// TODO[JRL]: check the logic here. I'm not sure “remove
 	// everything if active, and the click hooks if not” makes
 	// sense. E.g. are we removing fmk bins on fmk upgrade? Etc.
-	if err := removeClickHooks(s.m, s.origin, false); err != nil {
+	if err := removeClickHooks(s.m, s.origin, false, backendOf(inter)); err != nil {
 		return err
 	}
 
@@ -1132,7 +1591,15 @@ func (s *SnapPart) remove(inter interacter) (err error) {
 		return err
 	}
 
-	err = os.RemoveAll(s.basedir)
+	return nil
+}
+
+func (s *SnapPart) remove(inter interacter) (err error) {
+	if err := s.unlink(inter); err != nil {
+		return err
+	}
+
+	err = os.RemoveAll(s.basedir)
 	if err != nil {
 		return err
 	}
@@ -1140,18 +1607,29 @@ func (s *SnapPart) remove(inter interacter) (err error) {
 	// best effort(?)
 	os.Remove(filepath.Dir(s.basedir))
 
+	// nothing of the snap should be left behind
+	removeMetadata(QualifiedName(s), s.Version())
+
+	return nil
+}
+
+func (s *SnapPart) removeIcon() {
 	// don't fail if icon can't be removed
 	if helpers.FileExists(iconPath(s)) {
 		if err := os.Remove(iconPath(s)); err != nil {
 			logger.Noticef("Failed to remove store icon %s: %s", iconPath(s), err)
 		}
 	}
-
-	return nil
 }
 
 // Config is used to to configure the snap
 func (s *SnapPart) Config(configuration []byte) (new string, err error) {
+	unlock, err := lockSystem(true)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
 	return snapConfig(s.basedir, s.origin, string(configuration))
 }
 
@@ -1234,15 +1712,23 @@ func (s *SnapPart) CanInstall(allowOEM bool, inter interacter) error {
 		return &ErrArchitectureNotSupported{s.m.Architectures}
 	}
 
+	if err := checkConfinement(s.Name(), s.m.confinement()); err != nil {
+		return err
+	}
+
 	if err := s.m.checkForNameClashes(); err != nil {
 		return err
 	}
 
+	if err := s.m.checkForBinaryClashes(); err != nil {
+		return err
+	}
+
 	if err := s.m.checkForFrameworks(); err != nil {
 		return err
 	}
 
-	if s.Type() == pkg.TypeOem {
+	if s.Type().IsGadget() {
 		if !allowOEM {
 			if currentOEM, err := getOem(); err == nil {
 				if currentOEM.Name != s.Name() {
@@ -1263,29 +1749,30 @@ func (s *SnapPart) CanInstall(allowOEM bool, inter interacter) error {
 	return nil
 }
 
-var timestampUpdater = helpers.UpdateTimestamp
-
-func updateAppArmorJSONTimestamp(fullName, thing, version string) error {
+func updateAppArmorJSONTimestamp(backend Backend, fullName, thing, version string) error {
 	fn := filepath.Join(dirs.SnapAppArmorDir, fmt.Sprintf("%s_%s_%s.json", fullName, thing, version))
-	return timestampUpdater(fn)
+	return backend.UpdateTimestamp(fn)
 }
 
 // RequestAppArmorUpdate checks whether changes to the given policies and
 // templates impacts the snap, and updates the timestamp of the relevant json
-// symlinks (thus requesting aaClickHookCmd regenerate the appropriate bits).
-func (s *SnapPart) RequestAppArmorUpdate(policies, templates map[string]bool) error {
+// symlinks (thus requesting aa-clickhook regenerate the appropriate bits).
+func (s *SnapPart) RequestAppArmorUpdate(policies, templates map[string]bool, meter progress.Meter) error {
+	return s.requestAppArmorUpdate(backendOf(meter), policies, templates)
+}
 
+func (s *SnapPart) requestAppArmorUpdate(backend Backend, policies, templates map[string]bool) error {
 	fullName := QualifiedName(s)
 	for _, svc := range s.ServiceYamls() {
 		if svc.NeedsAppArmorUpdate(policies, templates) {
-			if err := updateAppArmorJSONTimestamp(fullName, svc.Name, s.Version()); err != nil {
+			if err := updateAppArmorJSONTimestamp(backend, fullName, svc.Name, s.Version()); err != nil {
 				return err
 			}
 		}
 	}
 	for _, bin := range s.Binaries() {
 		if bin.NeedsAppArmorUpdate(policies, templates) {
-			if err := updateAppArmorJSONTimestamp(fullName, bin.Name, s.Version()); err != nil {
+			if err := updateAppArmorJSONTimestamp(backend, fullName, bin.Name, s.Version()); err != nil {
 				return err
 			}
 		}
@@ -1307,15 +1794,15 @@ func (s *SnapPart) RefreshDependentsSecurity(oldPart *SnapPart, inter interacter
 		return err
 	}
 
+	backend := backendOf(inter)
 	for _, dep := range deps {
-		err := dep.RequestAppArmorUpdate(upPol, upTpl)
+		err := dep.requestAppArmorUpdate(backend, upPol, upTpl)
 		if err != nil {
 			return err
 		}
 	}
 
-	cmd := exec.Command(aaClickHookCmd)
-	if output, err := cmd.CombinedOutput(); err != nil {
+	if output, err := backend.AaClickHook(); err != nil {
 		if exitCode, err := helpers.ExitCode(err); err == nil {
 			return &ErrApparmorGenerate{
 				ExitCode: exitCode,
@@ -1369,6 +1856,12 @@ func (s *SnapLocalRepository) Updates() (parts []Part, err error) {
 	return nil, err
 }
 
+// Revert reverts the snap to the version that was active before (see
+// Revert)
+func (s *SnapLocalRepository) Revert(name string, inter progress.Meter) (version string, err error) {
+	return Revert(name, inter)
+}
+
 // Installed returns the installed snaps from this repository
 func (s *SnapLocalRepository) Installed() (parts []Part, err error) {
 	globExpr := filepath.Join(s.path, "*", "*", "meta", "package.yaml")
@@ -1433,6 +1926,25 @@ func originFromYamlPath(path string) (string, error) {
 // RemoteSnapPart represents a snap available on the server
 type RemoteSnapPart struct {
 	pkg remote.Snap
+
+	// timeout for the downloads (0 for none)
+	timeout time.Duration
+	// proxy for the downloads (nil for those of the environment)
+	proxy *url.URL
+	// tlsConfig verifies the https downloads (nil for the system CAs),
+	// without the pins of the store API (see StoreTLS)
+	tlsConfig *storeTLSConfig
+	// ctx stops the downloads when done (nil for never)
+	ctx context.Context
+	// auth authenticates the downloads (nil for the default)
+	auth Authenticator
+	// trace of the operation the snap is fetched for (if any)
+	trace *Trace
+	// downloaded is the snap file, when downloaded ahead of the install
+	downloaded string
+	// localFiles is set for the snaps of a SnapDirRepository, whose
+	// URLs are files (no others may be)
+	localFiles bool
 }
 
 // Type returns the type of the SnapPart (app, oem, ...)
@@ -1475,6 +1987,38 @@ func (s *RemoteSnapPart) Channel() string {
 	return s.pkg.Channel
 }
 
+// AllowUnauthenticated returns true if the snap can be downloaded
+// without store credentials (the default if the store does not say)
+func (s *RemoteSnapPart) AllowUnauthenticated() bool {
+	return s.pkg.AllowUnauthenticated == nil || *s.pkg.AllowUnauthenticated
+}
+
+// Unpublished returns true if the snap was unpublished from the store,
+// so it will not get any updates
+func (s *RemoteSnapPart) Unpublished() bool {
+	return s.pkg.Status == remote.StatusUnpublished
+}
+
+// Epoch returns the epoch of the version (see CompareVersions)
+func (s *RemoteSnapPart) Epoch() int {
+	return s.pkg.Epoch
+}
+
+// Changelog returns what changed in this version of the snap, as the
+// store has it
+func (s *RemoteSnapPart) Changelog() string {
+	return s.pkg.Changelog
+}
+
+// Confinement returns the confinement the store says the snap asks for
+func (s *RemoteSnapPart) Confinement() pkg.Confinement {
+	if s.pkg.Confinement == "" {
+		return pkg.ConfinementStrict
+	}
+
+	return s.pkg.Confinement
+}
+
 // Icon returns the icon
 func (s *RemoteSnapPart) Icon() string {
 	return s.pkg.IconURL
@@ -1520,10 +2064,8 @@ func (s *RemoteSnapPart) Date() time.Time {
 }
 
 // download writes an http.Request showing a progress.Meter
-func download(name string, w io.Writer, req *http.Request, pbar progress.Meter) error {
-	client := &http.Client{}
-
-	resp, err := client.Do(req)
+func download(name string, w io.Writer, req *http.Request, client *http.Client, pbar progress.Meter) error {
+	resp, err := doStoreRequest(client, req)
 	if err != nil {
 		return err
 	}
@@ -1533,63 +2075,246 @@ func download(name string, w io.Writer, req *http.Request, pbar progress.Meter)
 		return &ErrDownload{Code: resp.StatusCode, URL: req.URL}
 	}
 
+	return copyWithMeter(name, w, resp.Body, 0, resp.ContentLength, pbar)
+}
+
+// copyWithMeter copies the size bytes of r to w, the done bytes before
+// them being already there, showing a progress.Meter
+func copyWithMeter(name string, w io.Writer, r io.Reader, done, size int64, pbar progress.Meter) (err error) {
 	if pbar != nil {
-		pbar.Start(name, float64(resp.ContentLength))
-		mw := io.MultiWriter(w, pbar)
-		_, err = io.Copy(mw, resp.Body)
+		pbar.Start(name, float64(done+size))
+		pbar.Set(float64(done))
+		mw := io.MultiWriter(w, progress.NewTransferWriter(pbar, done, done+size))
+		_, err = io.Copy(mw, r)
 		pbar.Finished()
 	} else {
-		_, err = io.Copy(w, resp.Body)
+		_, err = io.Copy(w, r)
 	}
 
 	return err
 }
 
 // Download downloads the snap and returns the filename
-func (s *RemoteSnapPart) Download(pbar progress.Meter) (string, error) {
-	w, err := ioutil.TempFile("", s.pkg.Name)
+func (s *RemoteSnapPart) Download(pbar progress.Meter) (fn string, err error) {
+	if s.downloaded != "" {
+		fn, s.downloaded = s.downloaded, ""
+		return fn, nil
+	}
+
+	// fail early rather than after trying every mirror
+	if !s.AllowUnauthenticated() && !s.authenticated() {
+		return "", ErrAuthenticationNeeded
+	}
+
+	if fn, err := s.fromCache(); err == nil {
+		return fn, nil
+	} else if err != errNotCached {
+		logger.Noticef("Failed to get %s from the download cache: %v", s.Name(), err)
+	}
+
+	fn, err = s.fetchSnap(pbar)
+	if err != nil {
+		if s.Paid() && isDownloadRefused(err) {
+			return "", ErrPurchaseNeeded
+		}
+		return "", err
+	}
+
+	if err := s.addToCache(fn); err != nil {
+		logger.Noticef("Failed to add %s to the download cache: %v", s.Name(), err)
+	}
+
+	return fn, nil
+}
+
+// fetchSnap gets the snap from the store (or a mirror), as a delta when it
+// can, and returns the filename
+func (s *RemoteSnapPart) fetchSnap(pbar progress.Meter) (fn string, err error) {
+	// a delta from the installed version is (much) smaller, when
+	// there is one
+	if fn, err := s.downloadDelta(pbar); err == nil {
+		return fn, nil
+	} else if err != errNoDelta {
+		logger.Noticef("Failed to update %s with a delta, downloading it all: %v", s.Name(), err)
+	}
+
+	w, resumable, err := s.openDownload()
 	if err != nil {
 		return "", err
 	}
 	defer func() {
-		if err != nil {
+		// a partial download is kept for the next try to resume,
+		// unless it is not worth it
+		if err != nil && (!resumable || isHashMismatch(err)) {
 			os.Remove(w.Name())
 		}
 	}()
 	defer w.Close()
 
-	// try anonymous download first and fallback to authenticated
-	url := s.pkg.AnonDownloadURL
-	if url == "" {
-		url = s.pkg.DownloadURL
+	// try the mirrors (if any) best first
+	for _, url := range rankDownloadURLs(s.downloadURLs()) {
+		err = s.downloadFrom(url, w, resumable, pbar)
+		recordDownload(url, err)
+		if err == nil {
+			break
+		}
+		logger.Noticef("Failed to download %s from %s: %v", s.Name(), url, err)
 	}
-	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return "", err
 	}
-	setUbuntuStoreHeaders(req)
 
-	if err := download(s.Name(), w, req, pbar); err != nil {
+	if !resumable {
+		return w.Name(), nil
+	}
+
+	// only now that it is verified is the download done
+	fn = strings.TrimSuffix(w.Name(), partialSuffix)
+	if err = os.Rename(w.Name(), fn); err != nil {
 		return "", err
 	}
 
-	return w.Name(), w.Sync()
+	return fn, nil
 }
 
-func (s *RemoteSnapPart) downloadIcon(pbar progress.Meter) error {
-	if err := os.MkdirAll(dirs.SnapIconsDir, 0755); err != nil {
+// partialSuffix is that of the partial downloads, that are resumed
+// from where they stopped
+const partialSuffix = ".partial"
+
+// partialMaxAge is how long a partial download is kept for a try to
+// resume it, that of a snap the store no longer has is never resumed
+const partialMaxAge = 7 * 24 * time.Hour
+
+// prunePartialDownloads removes the partial downloads that were not
+// resumed for partialMaxAge, unless they are being downloaded to
+func prunePartialDownloads() {
+	partials, err := filepath.Glob(filepath.Join(dirs.SnapDownloadsDir, "*"+partialSuffix))
+	if err != nil {
+		return
+	}
+
+	for _, partial := range partials {
+		fi, err := os.Stat(partial)
+		if err != nil || time.Since(fi.ModTime()) < partialMaxAge {
+			continue
+		}
+		f, err := os.Open(partial)
+		if err != nil {
+			continue
+		}
+		if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err == nil {
+			if err := os.Remove(partial); err != nil {
+				logger.Noticef("Failed to remove the partial download %q: %v", partial, err)
+			}
+		}
+		f.Close()
+	}
+}
+
+// openDownload opens the file to download the snap to: if the store
+// gives its hash, a partial download (named after the hash, so that it
+// is resumed only with the very same snap) that is kept if the
+// download fails; a temporary file otherwise, or if that partial
+// download is already being downloaded to
+func (s *RemoteSnapPart) openDownload() (w *os.File, resumable bool, err error) {
+	if s.pkg.DownloadSha512 != "" {
+		if err := os.MkdirAll(dirs.SnapDownloadsDir, 0700); err != nil {
+			return nil, false, err
+		}
+		prunePartialDownloads()
+
+		partial := filepath.Join(dirs.SnapDownloadsDir, s.pkg.DownloadSha512+partialSuffix)
+		w, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, false, err
+		}
+		if err := syscall.Flock(int(w.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err == nil {
+			return w, true, nil
+		}
+		w.Close()
+	}
+
+	w, err = ioutil.TempFile("", s.pkg.Name)
+	return w, false, err
+}
+
+func isHashMismatch(err error) bool {
+	_, ok := err.(*ErrHashMismatch)
+	return ok
+}
+
+// downloadFrom downloads the snap from the given URL to w, resuming
+// what is already in w if it is resumable (and replacing it otherwise)
+func (s *RemoteSnapPart) downloadFrom(url string, w *os.File, resumable bool, pbar progress.Meter) error {
+	if !resumable {
+		if err := truncateFile(w); err != nil {
+			return err
+		}
+	} else if fi, err := w.Stat(); err == nil && fi.Size() > 0 && s.pkg.DownloadSize > 0 && fi.Size() >= s.pkg.DownloadSize {
+		if fi.Size() == s.pkg.DownloadSize && s.verifyDownload(w) == nil {
+			// the previous download got it all
+			return nil
+		}
+		// there is nothing to resume past the end
+		if err := truncateFile(w); err != nil {
+			return err
+		}
+	}
+
+	if err := s.fetchResuming(s.Name(), url, w, pbar); err != nil {
 		return err
 	}
 
-	iconPath := iconPath(s)
-	if helpers.FileExists(iconPath) {
+	if err := w.Sync(); err != nil {
+		return err
+	}
+
+	if err := s.verifyDownload(w); err != nil {
+		// start over next time
+		if terr := truncateFile(w); terr != nil {
+			logger.Noticef("Failed to truncate %q: %v", w.Name(), terr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// verifyDownload checks that, whatever the transport or mirror, the
+// downloaded snap is what the store says it is
+func (s *RemoteSnapPart) verifyDownload(w *os.File) error {
+	if s.pkg.DownloadSha512 == "" {
 		return nil
 	}
 
-	req, err := http.NewRequest("GET", s.Icon(), nil)
+	sha512, err := helpers.Sha512sum(w.Name())
 	if err != nil {
 		return err
 	}
+	if sha512 != s.pkg.DownloadSha512 {
+		return &ErrHashMismatch{Snap: s.Name(), Expected: s.pkg.DownloadSha512, Got: sha512}
+	}
+
+	return nil
+}
+
+func truncateFile(w *os.File) error {
+	if _, err := w.Seek(0, 0); err != nil {
+		return err
+	}
+
+	return w.Truncate(0)
+}
+
+func (s *RemoteSnapPart) downloadIcon(pbar progress.Meter) error {
+	if err := os.MkdirAll(dirs.SnapIconsDir, 0755); err != nil {
+		return err
+	}
+
+	iconPath := iconPath(s)
+	if s.Icon() == "" || helpers.FileExists(iconPath) {
+		return nil
+	}
 
 	w, err := os.OpenFile(iconPath, os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
@@ -1597,7 +2322,8 @@ func (s *RemoteSnapPart) downloadIcon(pbar progress.Meter) error {
 	}
 	defer w.Close()
 
-	if err := download("icon for package", w, req, pbar); err != nil {
+	if err := s.fetch("icon for package", s.Icon(), false, w, pbar); err != nil {
+		os.Remove(iconPath)
 		return err
 	}
 
@@ -1614,12 +2340,26 @@ func (s *RemoteSnapPart) saveStoreManifest() error {
 		return err
 	}
 
-	// don't worry about previous contents
-	return ioutil.WriteFile(RemoteManifestPath(s), content, 0644)
+	// a truncated manifest would break loading the installed snap, so
+	// make sure it is either fully written or not there at all
+	return helpers.AtomicWriteFile(RemoteManifestPath(s), content, 0644, 0)
 }
 
 // Install installs the snap
 func (s *RemoteSnapPart) Install(pbar progress.Meter, flags InstallFlags) (string, error) {
+	// no point in downloading what would be refused
+	if err := checkConfinement(s.Name(), s.Confinement()); err != nil {
+		return "", err
+	}
+	if err := checkSpace(s.Name(), s.spaceNeeded()); err != nil {
+		return "", err
+	}
+
+	// a dry run downloads and writes nothing
+	if (flags & DryRun) != 0 {
+		return s.Name(), nil
+	}
+
 	downloadedSnap, err := s.Download(pbar)
 	if err != nil {
 		return "", err
@@ -1659,7 +2399,7 @@ func (s *RemoteSnapPart) NeedsReboot() bool {
 
 // Frameworks returns the list of frameworks needed by the snap
 func (s *RemoteSnapPart) Frameworks() ([]string, error) {
-	return nil, ErrNotImplemented
+	return s.pkg.Frameworks, nil
 }
 
 // NewRemoteSnapPart returns a new RemoteSnapPart from the given
@@ -1670,15 +2410,40 @@ func NewRemoteSnapPart(data remote.Snap) *RemoteSnapPart {
 
 // SnapUbuntuStoreRepository represents the ubuntu snap store
 type SnapUbuntuStoreRepository struct {
-	searchURI  *url.URL
-	detailsURI *url.URL
-	bulkURI    string
+	searchURI      *url.URL
+	detailsURI     *url.URL
+	bulkURI        string
+	departmentsURI *url.URL
+	reviewsURI     *url.URL
+	purchasesURI   *url.URL
+
+	// channel overrides the channel of the system (if set)
+	channel string
+	// timeout for the requests (0 for none)
+	timeout time.Duration
+	// proxy for the requests (nil for those of the environment)
+	proxy *url.URL
+	// tlsConfig verifies the https requests (nil for the system CAs)
+	tlsConfig *storeTLSConfig
+	// ctx stops the requests when done (nil for never)
+	ctx context.Context
+	// auth authenticates the requests (nil for the default)
+	auth Authenticator
+	// trace of the operation (nil if it is not traced)
+	trace *Trace
 }
 
 var (
-	storeSearchURI  *url.URL
-	storeDetailsURI *url.URL
-	storeBulkURI    *url.URL
+	storeSearchURI      *url.URL
+	storeDetailsURI     *url.URL
+	storeBulkURI        *url.URL
+	storeDepartmentsURI *url.URL
+	storeReviewsURI     *url.URL
+	storePurchasesURI   *url.URL
+
+	// storeBaseURI is what the URIs of the store start with, that
+	// of a mirror replaces it when the store is unreachable
+	storeBaseURI *url.URL
 )
 
 func getStructFields(s interface{}) []string {
@@ -1707,32 +2472,47 @@ func cpiURL() string {
 	return "https://search.apps.ubuntu.com/api/v1/"
 }
 
+func reviewsURL() string {
+	if os.Getenv("SNAPPY_USE_STAGING_CPI") != "" {
+		return "https://reviews.staging.ubuntu.com/click/api/1.0/"
+	}
+
+	return "https://reviews.ubuntu.com/click/api/1.0/"
+}
+
+func purchasesURL() string {
+	if os.Getenv("SNAPPY_USE_STAGING_CPI") != "" {
+		return "https://myapps.developer.staging.ubuntu.com/api/2.0/click/purchases/"
+	}
+
+	return "https://myapps.developer.ubuntu.com/api/2.0/click/purchases/"
+}
+
 func init() {
-	storeBaseURI, err := url.Parse(cpiURL())
+	var err error
+	storeBaseURI, err = url.Parse(cpiURL())
 	if err != nil {
 		panic(err)
 	}
 
-	storeSearchURI, err = storeBaseURI.Parse("search")
+	uris, err := newStoreURIs(storeBaseURI)
 	if err != nil {
 		panic(err)
 	}
+	storeSearchURI = uris.search
+	storeDetailsURI = uris.details
+	storeBulkURI = uris.bulk
+	storeDepartmentsURI = uris.departments
 
-	v := url.Values{}
-	v.Set("fields", strings.Join(getStructFields(remote.Snap{}), ","))
-	storeSearchURI.RawQuery = v.Encode()
-
-	storeDetailsURI, err = storeBaseURI.Parse("package/")
-
+	storeReviewsURI, err = url.Parse(reviewsURL() + "reviews/")
 	if err != nil {
 		panic(err)
 	}
 
-	storeBulkURI, err = storeBaseURI.Parse("click-metadata")
+	storePurchasesURI, err = url.Parse(purchasesURL())
 	if err != nil {
 		panic(err)
 	}
-	storeBulkURI.RawQuery = v.Encode()
 }
 
 // NewUbuntuStoreSnapRepository creates a new SnapUbuntuStoreRepository
@@ -1740,16 +2520,87 @@ func NewUbuntuStoreSnapRepository() *SnapUbuntuStoreRepository {
 	if storeSearchURI == nil && storeDetailsURI == nil && storeBulkURI == nil {
 		return nil
 	}
+	uris := &storeURIs{
+		search:      storeSearchURI,
+		details:     storeDetailsURI,
+		bulk:        storeBulkURI,
+		departments: storeDepartmentsURI,
+		reviews:     storeReviewsURI,
+		purchases:   storePurchasesURI,
+	}
+
+	// the store of the system configuration, unless asked for the
+	// staging one
+	if os.Getenv("SNAPPY_USE_STAGING_CPI") == "" {
+		if configured, err := configuredStoreURIs(uris); err != nil {
+			logger.Noticef("Cannot use the store configuration in %q: %v", dirs.SnapStoreConfigFile, err)
+		} else {
+			uris = configured
+		}
+	}
+
 	// see https://wiki.ubuntu.com/AppStore/Interfaces/ClickPackageIndex
 	return &SnapUbuntuStoreRepository{
-		searchURI:  storeSearchURI,
-		detailsURI: storeDetailsURI,
-		bulkURI:    storeBulkURI.String(),
+		searchURI:      uris.search,
+		detailsURI:     uris.details,
+		bulkURI:        uris.bulk.String(),
+		departmentsURI: uris.departments,
+		reviewsURI:     uris.reviews,
+		purchasesURI:   uris.purchases,
 	}
 }
 
+// configuredStoreURIs returns the endpoints of the system configuration,
+// those of uris for what it does not configure
+func configuredStoreURIs(uris *storeURIs) (*storeURIs, error) {
+	config, err := storeConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	configured := *uris
+	if config.URL != "" {
+		base, err := url.Parse(config.URL)
+		if err != nil {
+			return nil, err
+		}
+		api, err := newStoreURIs(base)
+		if err != nil {
+			return nil, err
+		}
+		configured.search = api.search
+		configured.details = api.details
+		configured.bulk = api.bulk
+		configured.departments = api.departments
+	}
+	if config.ReviewsURL != "" {
+		base, err := url.Parse(config.ReviewsURL)
+		if err != nil {
+			return nil, err
+		}
+		if configured.reviews, err = asDirURL(base).Parse("reviews/"); err != nil {
+			return nil, err
+		}
+	}
+	if config.PurchasesURL != "" {
+		if configured.purchases, err = url.Parse(config.PurchasesURL); err != nil {
+			return nil, err
+		}
+	}
+
+	return &configured, nil
+}
+
+// SetChannel makes the repository get the snaps from the given channel
+// (edge, beta, stable...) instead of the channel of the system, and
+// their updates from it instead of the channels they were installed
+// from; the snaps installed from the repository then track it
+func (s *SnapUbuntuStoreRepository) SetChannel(channel string) {
+	s.channel = channel
+}
+
 // small helper that sets the correct http headers for the ubuntu store
-func setUbuntuStoreHeaders(req *http.Request) {
+func setUbuntuStoreHeaders(req *http.Request, auth Authenticator) {
 	req.Header.Set("Accept", "application/hal+json")
 
 	// frameworks
@@ -1759,16 +2610,26 @@ func setUbuntuStoreHeaders(req *http.Request) {
 	req.Header.Set("X-Ubuntu-Release", release.String())
 	req.Header.Set("X-Ubuntu-Device-Channel", release.Get().Channel)
 
-	if storeID := os.Getenv("UBUNTU_STORE_ID"); storeID != "" {
+	var confinement []string
+	for _, c := range AllowedConfinement() {
+		confinement = append(confinement, string(c))
+	}
+	req.Header.Set("X-Ubuntu-Confinement", strings.Join(confinement, ","))
+
+	// the deltas the store may send instead of the whole snap
+	if formats := supportedDeltaFormats(); len(formats) > 0 {
+		req.Header.Set("X-Ubuntu-Delta-Formats", strings.Join(formats, ","))
+	}
+
+	if storeID := os.Getenv("UBUNTU_STORE_ID"); storeID != "" && !StorePinned() {
 		req.Header.Set("X-Ubuntu-Store", storeID)
 	} else if storeID := StoreID(); storeID != "" {
 		req.Header.Set("X-Ubuntu-Store", storeID)
 	}
 
-	// sso
-	ssoToken, err := ReadStoreToken()
-	if err == nil {
-		req.Header.Set("Authorization", oauth.MakePlaintextSignature(&ssoToken.Token))
+	// the credentials of the user, if any
+	if err := authenticate(auth, req); err != nil && err != ErrAuthenticationNeeded {
+		logger.Noticef("Failed to authenticate to the store: %v", err)
 	}
 }
 
@@ -1777,173 +2638,455 @@ func (s *SnapUbuntuStoreRepository) Description() string {
 	return fmt.Sprintf("Snap remote repository for %s", s.searchURI)
 }
 
-// Details returns details for the given snap in this repository
+// Details returns details for the given snap in this repository. If
+// no origin is given the name is resolved via the DefaultOrigins, or
+// else via its alias.
 func (s *SnapUbuntuStoreRepository) Details(name string, origin string) (parts []Part, err error) {
-	snapName := name
 	if origin != "" {
-		snapName = name + "." + origin
+		return s.details(name+"."+origin, "")
 	}
 
-	url, err := s.detailsURI.Parse(snapName)
+	// the store would prefer the alias over the default origins
+	if len(DefaultOrigins()) > 0 {
+		origin, err = s.resolveOrigin(name)
+		if err != nil {
+			return nil, err
+		}
+
+		return s.details(name+"."+origin, "")
+	}
+
+	// the store resolves the alias of the name itself ...
+	parts, err = s.details(name, "")
+	if err != ErrPackageNotFound {
+		return parts, err
+	}
+
+	// ... but not for all names, so look for the alias via search
+	origin, err = s.resolveOrigin(name)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("GET", url.String(), nil)
+	return s.details(name+"."+origin, "")
+}
+
+// DetailsRevision returns details for the given version of the snap
+// (instead of the latest one) in this repository. If the store does
+// not have that version (anymore) ErrVersionNotAvailable is returned.
+func (s *SnapUbuntuStoreRepository) DetailsRevision(name, origin, version string) ([]Part, error) {
+	if version == "" {
+		return s.Details(name, origin)
+	}
+
+	if origin == "" {
+		var err error
+		origin, err = s.resolveOrigin(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return s.details(name+"."+origin, version)
+}
+
+// resolveOrigin returns the first of the DefaultOrigins that has the
+// given name, the origin of the alias for the name, or the origin of
+// the only package with that name
+func (s *SnapUbuntuStoreRepository) resolveOrigin(name string) (string, error) {
+	origins, err := s.resolveOrigins([]string{name})
 	if err != nil {
-		return nil, err
+		return "", err
 	}
 
-	// set headers
-	setUbuntuStoreHeaders(req)
+	origin, ok := origins[name]
+	if !ok {
+		return "", ErrPackageNotFound
+	}
+
+	return origin, nil
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// resolveOrigins resolves the origins of the given names (see
+// resolveOrigin) with a single search; the names the store does not
+// have are left out
+func (s *SnapUbuntuStoreRepository) resolveOrigins(names []string) (map[string]string, error) {
+	sharedNames, err := s.Search(strings.Join(names, ","))
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	// check statusCode
-	switch {
-	case resp.StatusCode == 404:
-		return nil, ErrPackageNotFound
-	case resp.StatusCode != 200:
-		return parts, fmt.Errorf("SnapUbuntuStoreRepository: unexpected http statusCode %v for %s", resp.StatusCode, snapName)
+	origins := make(map[string]string, len(names))
+	for _, name := range names {
+		sharedName, ok := sharedNames[name]
+		if !ok || len(sharedName.Parts) == 0 {
+			continue
+		}
+		origin, err := sharedNameOrigin(name, sharedName)
+		if err != nil {
+			return nil, err
+		}
+		origins[name] = origin
 	}
 
-	// and decode json
-	var detailsData remote.Snap
-	dec := json.NewDecoder(resp.Body)
-	if err := dec.Decode(&detailsData); err != nil {
-		return nil, err
+	return origins, nil
+}
+
+// sharedNameOrigin returns the origin the name resolves to among the
+// snaps that share it
+func sharedNameOrigin(name string, sharedName *SharedName) (string, error) {
+	if origin := preferredOrigin(sharedName.Parts); origin != "" {
+		return origin, nil
+	}
+	if sharedName.Alias != nil {
+		return sharedName.Alias.Origin(), nil
+	}
+	if len(sharedName.Parts) == 1 {
+		return sharedName.Parts[0].Origin(), nil
 	}
 
-	snap := NewRemoteSnapPart(detailsData)
-	parts = append(parts, snap)
+	origins := make([]string, len(sharedName.Parts))
+	for i, part := range sharedName.Parts {
+		origins[i] = part.Origin()
+	}
 
-	return parts, nil
+	return "", &ErrAmbiguousName{Name: name, Origins: origins}
 }
 
-// All (installable) parts from the store
-func (s *SnapUbuntuStoreRepository) All() ([]Part, error) {
-	req, err := http.NewRequest("GET", s.searchURI.String(), nil)
+// details returns the details of the snap, of the given version if
+// not ""
+func (s *SnapUbuntuStoreRepository) details(snapName, version string) (parts []Part, err error) {
+	url, err := s.detailsURI.Parse(snapName)
+	if err != nil {
+		return nil, err
+	}
+	if version != "" {
+		q := url.Query()
+		q.Set("version", version)
+		url.RawQuery = q.Encode()
+	}
+
+	req, err := newStoreRequest(s.ctx, "GET", url.String(), nil)
 	if err != nil {
 		return nil, err
 	}
 
 	// set headers
-	setUbuntuStoreHeaders(req)
+	setStoreConfigHeaders(req)
+	setUbuntuStoreHeaders(req, s.auth)
+	setDeviceIdentityHeaders(req)
+	acceptStoreAPIs(req, storeAPIv1)
+	if s.channel != "" {
+		req.Header.Set("X-Ubuntu-Device-Channel", s.channel)
+	}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	client := s.trace.httpClient(s.timeout, s.proxy, s.tlsConfig)
+	resp, err := doCachedStoreRequest(client, req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	var searchData searchResults
+	if err := storeError(resp, snapName); err != nil {
+		return nil, err
+	}
 
-	dec := json.NewDecoder(resp.Body)
-	if err := dec.Decode(&searchData); err != nil {
+	// and decode json
+	detailsData, err := decodeDetails(resp)
+	if err != nil {
 		return nil, err
 	}
 
-	parts := make([]Part, len(searchData.Payload.Packages))
-	for i, pkg := range searchData.Payload.Packages {
-		parts[i] = NewRemoteSnapPart(pkg)
+	// stores that do not know about versions return the latest one
+	if version != "" && detailsData.Version != version {
+		return nil, &ErrVersionNotAvailable{Snap: snapName, Version: version}
 	}
 
+	// what was asked for is what the snap tracks, whatever the
+	// store says
+	if s.channel != "" {
+		detailsData.Channel = s.channel
+	}
+
+	snap := NewRemoteSnapPart(*detailsData)
+	snap.timeout = s.timeout
+	snap.proxy = s.proxy
+	snap.tlsConfig = s.tlsConfig.forDownloads()
+	snap.ctx = s.ctx
+	snap.auth = s.auth
+	snap.trace = s.trace
+	parts = append(parts, snap)
+
 	return parts, nil
 }
 
-// Search searches the repository for the given searchTerm
-func (s *SnapUbuntuStoreRepository) Search(searchTerm string) (SharedNames, error) {
-	q := s.searchURI.Query()
-	q.Set("q", searchTerm)
-	s.searchURI.RawQuery = q.Encode()
-	req, err := http.NewRequest("GET", s.searchURI.String(), nil)
+// DetailsMany returns the details of the given snaps, in the order of
+// the names, asking the store for all of them at once instead of one
+// by one; the snaps the store does not have are left out. The origin
+// of the names that have none is resolved as with Details, with a
+// single search for all of them.
+func (s *SnapUbuntuStoreRepository) DetailsMany(names []string) ([]Part, error) {
+	var unresolved []string
+	for _, name := range names {
+		if _, origin := SplitOrigin(name); origin == "" {
+			unresolved = append(unresolved, name)
+		}
+	}
+	var origins map[string]string
+	if len(unresolved) > 0 {
+		var err error
+		if origins, err = s.resolveOrigins(unresolved); err != nil {
+			return nil, err
+		}
+	}
+
+	var fullNames, bulkNames []string
+	for _, name := range names {
+		name, origin := SplitOrigin(name)
+		if origin == "" {
+			var ok bool
+			if origin, ok = origins[name]; !ok {
+				continue
+			}
+		}
+		fullName := name + "." + origin
+		fullNames = append(fullNames, fullName)
+		if s.channel != "" {
+			fullName += "/" + s.channel
+		}
+		bulkNames = append(bulkNames, fullName)
+	}
+	if len(fullNames) == 0 {
+		return nil, nil
+	}
+
+	jsonData, err := json.Marshal(bulkUpdatesRequest{Name: bulkNames})
 	if err != nil {
 		return nil, err
 	}
 
+	req, err := newStoreRequest(s.ctx, "POST", s.bulkURI, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
 	// set headers
-	setUbuntuStoreHeaders(req)
+	setStoreConfigHeaders(req)
+	setUbuntuStoreHeaders(req, s.auth)
+	setDeviceIdentityHeaders(req)
+	acceptStoreAPIs(req, storeAPIv1Bulk)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	client := s.trace.httpClient(s.timeout, s.proxy, s.tlsConfig)
+	resp, err := doCachedStoreRequest(client, req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	var searchData searchResults
+	if err := storeError(resp, fmt.Sprintf("the details of %d snaps", len(names))); err != nil {
+		return nil, err
+	}
 
-	dec := json.NewDecoder(resp.Body)
-	if err := dec.Decode(&searchData); err != nil {
+	detailsData, err := decodeBulk(resp)
+	if err != nil {
 		return nil, err
 	}
 
-	sharedNames := make(SharedNames, len(searchData.Payload.Packages))
-	for _, pkg := range searchData.Payload.Packages {
+	byName := make(map[string]remote.Snap, len(detailsData))
+	for _, raw := range detailsData {
+		var pkg remote.Snap
+		if err := json.Unmarshal(raw, &pkg); err != nil {
+			return nil, err
+		}
+		byName[pkg.Name+"."+pkg.Origin] = pkg
+	}
+
+	var parts []Part
+	for _, fullName := range fullNames {
+		pkg, ok := byName[fullName]
+		if !ok {
+			continue
+		}
+		if s.channel != "" {
+			pkg.Channel = s.channel
+		}
+
 		snap := NewRemoteSnapPart(pkg)
-		pkgName := snap.Name()
+		snap.timeout = s.timeout
+		snap.proxy = s.proxy
+		snap.tlsConfig = s.tlsConfig.forDownloads()
+		snap.ctx = s.ctx
+		snap.auth = s.auth
+		snap.trace = s.trace
+		parts = append(parts, snap)
+	}
 
-		if _, ok := sharedNames[snap.Name()]; !ok {
-			sharedNames[pkgName] = new(SharedName)
+	return parts, nil
+}
+
+// All (installable) parts from the store
+func (s *SnapUbuntuStoreRepository) All() ([]Part, error) {
+	var parts []Part
+	pager := s.pager(s.searchURI)
+	for pager.More() {
+		page, err := pager.Next()
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, page...)
+	}
+
+	return parts, nil
+}
+
+// Search searches the repository for the given searchTerm
+func (s *SnapUbuntuStoreRepository) Search(searchTerm string) (SharedNames, error) {
+	return s.search(s.searchQuery(searchTerm))
+}
+
+// search does the given search query, going through all the pages of
+// results, and groups the results by name
+func (s *SnapUbuntuStoreRepository) search(searchURI *url.URL) (SharedNames, error) {
+	sharedNames := make(SharedNames)
+	pager := s.pager(searchURI)
+	for pager.More() {
+		page, err := pager.Next()
+		if err != nil {
+			return nil, err
 		}
 
-		sharedNames[pkgName].Parts = append(sharedNames[pkgName].Parts, snap)
-		if pkg.Alias != "" {
-			sharedNames[pkgName].Alias = snap
+		for _, part := range page {
+			snap := part.(*RemoteSnapPart)
+			pkgName := snap.Name()
+
+			if _, ok := sharedNames[pkgName]; !ok {
+				sharedNames[pkgName] = &SharedName{Rank: len(sharedNames)}
+			}
+
+			sharedNames[pkgName].Parts = append(sharedNames[pkgName].Parts, snap)
+			if snap.pkg.Alias != "" {
+				sharedNames[pkgName].Alias = snap
+			}
 		}
 	}
 
 	return sharedNames, nil
 }
 
-// Updates returns the available updates
+// bulkUpdatesRequest is what Updates asks the store for
+type bulkUpdatesRequest struct {
+	// Name are the (full) names of the installed snaps, with their
+	// channel
+	Name []string `json:"name"`
+	// Versions are the installed versions by (full) name, so the
+	// stores that support it only send the snaps that changed
+	Versions map[string]string `json:"versions,omitempty"`
+	// Epochs are the epochs of the installed versions by (full) name,
+	// for those that have one
+	Epochs map[string]int `json:"epochs,omitempty"`
+}
+
+// bulkUpdatesHint is the part of a snap in the reply to the
+// bulkUpdatesRequest that tells if it is installed already
+type bulkUpdatesHint struct {
+	Name    string `json:"package_name"`
+	Origin  string `json:"origin"`
+	Version string `json:"version"`
+	Status  string `json:"status"`
+}
+
+// Updates returns the available updates, and the installed snaps that
+// got unpublished (see IsUnpublished)
 func (s *SnapUbuntuStoreRepository) Updates() (parts []Part, err error) {
 	// the store only supports apps, oem and frameworks currently, so no
 	// sense in sending it our ubuntu-core snap
 	//
 	// NOTE this *will* send .sideload apps to the store.
-	installed, err := ActiveSnapIterByType(fullNameWithChannel, pkg.TypeApp, pkg.TypeFramework, pkg.TypeOem)
+	nameWithChannel := fullNameWithChannel
+	if s.channel != "" {
+		nameWithChannel = func(p Part) string {
+			return fmt.Sprintf("%s/%s", FullName(p), s.channel)
+		}
+	}
+	versions := make(map[string]string)
+	var epochs map[string]int
+	installed, err := ActiveSnapIterByType(func(p Part) string {
+		versions[FullName(p)] = p.Version()
+		if epoch := Epoch(p); epoch > 0 {
+			if epochs == nil {
+				epochs = make(map[string]int)
+			}
+			epochs[FullName(p)] = epoch
+		}
+		return nameWithChannel(p)
+	}, pkg.TypeApp, pkg.TypeFramework, pkg.TypeGadget, pkg.TypeOem)
 	if err != nil || len(installed) == 0 {
 		return nil, err
 	}
-	jsonData, err := json.Marshal(map[string][]string{"name": installed})
+	jsonData, err := json.Marshal(bulkUpdatesRequest{Name: installed, Versions: versions, Epochs: epochs})
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := http.NewRequest("POST", s.bulkURI, bytes.NewBuffer([]byte(jsonData)))
+	req, err := newStoreRequest(s.ctx, "POST", s.bulkURI, bytes.NewBuffer([]byte(jsonData)))
 	if err != nil {
 		return nil, err
 	}
 	// set headers
-	setUbuntuStoreHeaders(req)
-	// the updates call is a special snowflake right now
-	// (see LP: #1427155)
-	req.Header.Set("Accept", "application/json")
+	setStoreConfigHeaders(req)
+	setUbuntuStoreHeaders(req, s.auth)
+	setDeviceIdentityHeaders(req)
+	acceptStoreAPIs(req, storeAPIv1Bulk)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	client := s.trace.httpClient(s.timeout, s.proxy, s.tlsConfig)
+	resp, err := doCachedStoreRequest(client, req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	var updateData []remote.Snap
-	dec := json.NewDecoder(resp.Body)
-	if err := dec.Decode(&updateData); err != nil {
+	if err := storeError(resp, "updates"); err != nil {
+		return nil, err
+	}
+
+	updateData, err := decodeBulk(resp)
+	if err != nil {
 		return nil, err
 	}
 
-	for _, pkg := range updateData {
+	for _, raw := range updateData {
+		// stores that do not know about the versions send the
+		// snaps that are up to date too; skip those without
+		// parsing all of their metadata (unless they got
+		// unpublished, which the callers need to know about)
+		var hint bulkUpdatesHint
+		if err := json.Unmarshal(raw, &hint); err != nil {
+			return nil, err
+		}
+		version, ok := versions[hint.Name+"."+hint.Origin]
+		if ok && version == hint.Version && hint.Status != remote.StatusUnpublished {
+			continue
+		}
+
+		var pkg remote.Snap
+		if err := json.Unmarshal(raw, &pkg); err != nil {
+			return nil, err
+		}
+
 		current := ActiveSnapByName(pkg.Name)
-		if current == nil || current.Version() != pkg.Version {
+		if current == nil || current.Version() != pkg.Version || pkg.Status == remote.StatusUnpublished {
+			if s.channel != "" {
+				pkg.Channel = s.channel
+			} else if pkg.Channel == "" && current != nil {
+				// it keeps tracking its channel
+				pkg.Channel = current.Channel()
+			}
 			snap := NewRemoteSnapPart(pkg)
+			snap.timeout = s.timeout
+			snap.proxy = s.proxy
+			snap.tlsConfig = s.tlsConfig.forDownloads()
+			snap.ctx = s.ctx
+			snap.auth = s.auth
+			snap.trace = s.trace
 			parts = append(parts, snap)
 		}
 	}
@@ -33,8 +33,6 @@ type SnapfsTestSuite struct {
 }
 
 func (s *SnapfsTestSuite) SetUpTest(c *C) {
-	// mocks
-	aaClickHookCmd = "/bin/true"
 	dirs.SetRootDir(c.MkDir())
 
 	// ensure we use the right builder func (snapfs)
@@ -55,7 +53,7 @@ icon: meta/hello.svg
 
 func (s *SnapfsTestSuite) TestMakeSnapMakesSnapfs(c *C) {
 	snapPkg := makeTestSnapPackage(c, packageHello)
-	part, err := NewSnapPartFromSnapFile(snapPkg, "origin", true)
+	part, err := NewSnapPartFromSnapFile(snapPkg, "origin", SideloadOptions{AllowUnauthenticated: true})
 	c.Assert(err, IsNil)
 
 	// ensure the right backend got picked up
@@ -64,7 +62,7 @@ func (s *SnapfsTestSuite) TestMakeSnapMakesSnapfs(c *C) {
 
 func (s *SnapfsTestSuite) TestInstallViaSnapfsWorks(c *C) {
 	snapPkg := makeTestSnapPackage(c, packageHello)
-	part, err := NewSnapPartFromSnapFile(snapPkg, "origin", true)
+	part, err := NewSnapPartFromSnapFile(snapPkg, "origin", SideloadOptions{AllowUnauthenticated: true})
 	c.Assert(err, IsNil)
 
 	_, err = part.Install(&MockProgressMeter{}, 0)
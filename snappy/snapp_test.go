@@ -20,6 +20,9 @@
 package snappy
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -29,30 +32,32 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/ubuntu-core/snappy/dirs"
 	"github.com/ubuntu-core/snappy/helpers"
 	"github.com/ubuntu-core/snappy/partition"
 	"github.com/ubuntu-core/snappy/pkg"
 	"github.com/ubuntu-core/snappy/pkg/clickdeb"
+	"github.com/ubuntu-core/snappy/pkg/remote"
 	"github.com/ubuntu-core/snappy/policy"
 	"github.com/ubuntu-core/snappy/release"
-	"github.com/ubuntu-core/snappy/systemd"
 
 	. "gopkg.in/check.v1"
+	"gopkg.in/yaml.v2"
 )
 
 type SnapTestSuite struct {
-	tempdir   string
-	clickhook string
-	secbase   string
+	tempdir string
+	secbase string
+	backend *mockBackend
 }
 
 var _ = Suite(&SnapTestSuite{})
 
 func (s *SnapTestSuite) SetUpTest(c *C) {
-	s.clickhook = aaClickHookCmd
-	aaClickHookCmd = "/bin/true"
+	s.backend = newMockBackend()
 	s.secbase = policy.SecBase
 	s.tempdir = c.MkDir()
 	newPartition = func() (p partition.Interface) {
@@ -76,40 +81,46 @@ func (s *SnapTestSuite) SetUpTest(c *C) {
 	// we may not have debsig-verify installed (and we don't need it
 	// for the unittests)
 	clickdeb.VerifyCmd = "true"
-	systemd.SystemctlCmd = func(cmd ...string) ([]byte, error) {
-		return []byte("ActiveState=inactive\n"), nil
-	}
 
 	// fake "du"
 	duCmd = makeFakeDuCommand(c)
 
-	// fake udevadm
-	runUdevAdm = func(args ...string) error {
-		return nil
-	}
-
 	// do not attempt to hit the real store servers in the tests
 	storeSearchURI, _ = url.Parse("")
 	storeDetailsURI, _ = url.Parse("")
 	storeBulkURI, _ = url.Parse("")
+	// nor to wait before retrying the requests to the mock ones
+	retrySleep = func(context.Context, time.Duration) {}
 
 	aaExec = filepath.Join(s.tempdir, "aa-exec")
 	err := ioutil.WriteFile(aaExec, []byte(mockAaExecScript), 0755)
 	c.Assert(err, IsNil)
+}
 
-	runScFilterGen = mockRunScFilterGen
+// meter returns a meter for an operation that uses the backend of the
+// test
+func (s *SnapTestSuite) meter() *MockProgressMeter {
+	return &MockProgressMeter{backend: s.backend}
 }
 
 func (s *SnapTestSuite) TearDownTest(c *C) {
 	// ensure all functions are back to their original state
-	aaClickHookCmd = s.clickhook
 	policy.SecBase = s.secbase
-	regenerateAppArmorRules = regenerateAppArmorRulesImpl
 	ActiveSnapIterByType = activeSnapIterByTypeImpl
+	autoRefreshNow = time.Now
+	autoRefreshUpgrade = UpgradeAll
 	duCmd = "du"
 	stripGlobalRootDir = stripGlobalRootDirImpl
-	runScFilterGen = runScFilterGenImpl
-	runUdevAdm = runUdevAdmImpl
+	freePort = freePortImpl
+	freeSpace = freeSpaceImpl
+	fsID = fsIDImpl
+	supportedDeltaFormats = supportedDeltaFormatsImpl
+	applyDelta = applyDeltaImpl
+	retrySleep = sleepContext
+	SetDeviceIdentityProvider(nil)
+	forgetStoreConfig()
+	forgetAllowedConfinement()
+	deviceIdentityProvider = nil
 }
 
 func (s *SnapTestSuite) makeInstalledMockSnap(yamls ...string) (yamlFile string, err error) {
@@ -160,6 +171,27 @@ func (s *SnapTestSuite) TestLocalSnapSimple(c *C) {
 	c.Assert(snap.InstalledSize(), Not(Equals), -1)
 }
 
+func (s *SnapTestSuite) TestLocalSnapInstalledSize(c *C) {
+	snapYaml, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	snap, err := NewInstalledSnapPart(snapYaml, testOrigin)
+	c.Assert(err, IsNil)
+
+	size := snap.InstalledSize()
+	c.Assert(size > 0, Equals, true)
+
+	// the size is cached
+	f, err := os.Create(filepath.Join(snap.basedir, "meta", "big"))
+	c.Assert(err, IsNil)
+	c.Assert(f.Truncate(1<<20), IsNil)
+	f.Close()
+	c.Check(snap.InstalledSize(), Equals, size)
+
+	// and a snap that is gone has no size
+	c.Assert(os.RemoveAll(snap.basedir), IsNil)
+	c.Check(snap.InstalledSize(), Equals, int64(-1))
+}
+
 func (s *SnapTestSuite) TestLocalSnapHash(c *C) {
 	snapYaml, err := s.makeInstalledMockSnap()
 	c.Assert(err, IsNil)
@@ -281,7 +313,7 @@ const MockUpdatesJSON = `[
         "name": "8nzc1x4iim2xj1g2ul64.chipaca",
         "package_name": "8nzc1x4iim2xj1g2ul64",
         "origin": "chipaca",
-        "changelog": "",
+        "changelog": "* more funk",
         "icon_url": "https://myapps.developer.ubuntu.com/site_media/appmedia/2015/04/hello.svg_Dlrd3L4.png",
         "title": "Returns for store credit only.",
         "binary_filesize": 65375,
@@ -543,6 +575,135 @@ func (s *SnapTestSuite) TestUbuntuStoreRepositoryUpdates(c *C) {
 	c.Assert(results, HasLen, 1)
 	c.Assert(results[0].Name(), Equals, funkyAppName)
 	c.Assert(results[0].Version(), Equals, "42")
+	c.Check(results[0].(*RemoteSnapPart).Changelog(), Equals, "* more funk")
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryDetailsMany(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Method, Equals, "POST")
+		jsonReq, err := ioutil.ReadAll(r.Body)
+		c.Assert(err, IsNil)
+		c.Check(string(jsonReq), Equals, `{"name":["not-there.chipaca/edge","`+funkyAppName+`.chipaca/edge"]}`)
+		io.WriteString(w, MockUpdatesJSON)
+	}))
+
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	snap := NewUbuntuStoreSnapRepository()
+	c.Assert(snap, NotNil)
+	snap.bulkURI = mockServer.URL + "/updates/"
+	snap.SetChannel("edge")
+
+	results, err := snap.DetailsMany([]string{"not-there.chipaca", funkyAppName + ".chipaca"})
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[0].Name(), Equals, funkyAppName)
+	c.Check(results[0].Version(), Equals, "42")
+	c.Check(results[0].Channel(), Equals, "edge")
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryDetailsManyResolvesOriginsAtOnce(c *C) {
+	var searches []string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			searches = append(searches, r.URL.Query().Get("q"))
+			io.WriteString(w, `{"_embedded": {"clickindex:package": [
+				{"package_name": "foo", "origin": "alice", "version": "1.0"},
+				{"package_name": "`+funkyAppName+`", "origin": "chipaca", "version": "42"}
+			]}}`)
+			return
+		}
+		jsonReq, err := ioutil.ReadAll(r.Body)
+		c.Assert(err, IsNil)
+		c.Check(string(jsonReq), Equals, `{"name":["`+funkyAppName+`.chipaca","foo.alice"]}`)
+		io.WriteString(w, MockUpdatesJSON)
+	}))
+	defer mockServer.Close()
+
+	var err error
+	storeSearchURI, err = url.Parse(mockServer.URL + "/search")
+	c.Assert(err, IsNil)
+	snap := NewUbuntuStoreSnapRepository()
+	c.Assert(snap, NotNil)
+	snap.bulkURI = mockServer.URL + "/updates/"
+
+	results, err := snap.DetailsMany([]string{funkyAppName, "foo", "not-there"})
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[0].Name(), Equals, funkyAppName)
+	c.Check(searches, DeepEquals, []string{funkyAppName + ",foo,not-there"})
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryDetailsManyNone(c *C) {
+	snap := NewUbuntuStoreSnapRepository()
+	c.Assert(snap, NotNil)
+	snap.bulkURI = "http://i-do.not-exist.really-not"
+
+	results, err := snap.DetailsMany(nil)
+	c.Assert(err, IsNil)
+	c.Check(results, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryUpdatesSendsVersions(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		jsonReq, err := ioutil.ReadAll(r.Body)
+		c.Assert(err, IsNil)
+		c.Assert(string(jsonReq), Equals, `{"name":["`+funkyAppName+`.chipaca/stable"],"versions":{"`+funkyAppName+`.chipaca":"42"}}`)
+		// this store does not know about versions, and sends it anyway
+		io.WriteString(w, MockUpdatesJSON)
+	}))
+
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	var err error
+	storeBulkURI, err = url.Parse(mockServer.URL + "/updates/")
+	c.Assert(err, IsNil)
+	snap := NewUbuntuStoreSnapRepository()
+	c.Assert(snap, NotNil)
+
+	part := &SnapPart{m: &packageYaml{Name: funkyAppName, Version: "42"}, origin: "chipaca"}
+	ActiveSnapIterByType = func(f func(Part) string, snapTs ...pkg.Type) ([]string, error) {
+		return []string{f(part)}, nil
+	}
+
+	results, err := snap.Updates()
+	c.Assert(err, IsNil)
+	c.Check(results, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryUpdatesUnpublished(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Replace(MockUpdatesJSON, `"Published"`, `"Unpublished"`, 1))
+	}))
+
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	var err error
+	storeBulkURI, err = url.Parse(mockServer.URL + "/updates/")
+	c.Assert(err, IsNil)
+	snap := NewUbuntuStoreSnapRepository()
+	c.Assert(snap, NotNil)
+
+	// the same version is installed, but it got unpublished
+	part := &SnapPart{m: &packageYaml{Name: funkyAppName, Version: "42"}, origin: "chipaca"}
+	ActiveSnapIterByType = func(f func(Part) string, snapTs ...pkg.Type) ([]string, error) {
+		return []string{f(part)}, nil
+	}
+
+	results, err := snap.Updates()
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[0].Name(), Equals, funkyAppName)
+	c.Check(IsUnpublished(results[0]), Equals, true)
+}
+
+func (s *SnapTestSuite) TestIsUnpublished(c *C) {
+	c.Check(IsUnpublished(&RemoteSnapPart{pkg: remote.Snap{Status: remote.StatusPublished}}), Equals, false)
+	c.Check(IsUnpublished(&RemoteSnapPart{pkg: remote.Snap{Status: remote.StatusUnpublished}}), Equals, true)
+	c.Check(IsUnpublished(&SnapPart{}), Equals, false)
 }
 
 func (s *SnapTestSuite) TestUbuntuStoreRepositoryUpdatesNoSnaps(c *C) {
@@ -568,7 +729,7 @@ func (s *SnapTestSuite) TestUbuntuStoreRepositoryHeaders(c *C) {
 	req, err := http.NewRequest("GET", "http://example.com", nil)
 	c.Assert(err, IsNil)
 
-	setUbuntuStoreHeaders(req)
+	setUbuntuStoreHeaders(req, nil)
 
 	c.Assert(req.Header.Get("X-Ubuntu-Release"), Equals, release.String())
 }
@@ -606,6 +767,148 @@ func (s *SnapTestSuite) TestUbuntuStoreRepositoryDetails(c *C) {
 	c.Check(results[0].Channel(), Equals, "edge")
 }
 
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryDetailsChannel(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("X-Ubuntu-Device-Channel"), Equals, "edge")
+		io.WriteString(w, MockDetailsJSON)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+	snap := NewUbuntuStoreSnapRepository()
+	c.Assert(snap, NotNil)
+	snap.channel = "edge"
+
+	results, err := snap.Details(funkyAppName, funkyAppOrigin)
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryDetailsSetChannel(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("X-Ubuntu-Device-Channel"), Equals, "beta")
+		// the store says edge
+		io.WriteString(w, MockDetailsJSON)
+	}))
+	defer mockServer.Close()
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+	repo := NewUbuntuStoreSnapRepository()
+	repo.SetChannel("beta")
+
+	results, err := repo.Details(funkyAppName, funkyAppOrigin)
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[0].Channel(), Equals, "beta")
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryUpdatesSetChannel(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, MockUpdatesJSON)
+	}))
+	defer mockServer.Close()
+
+	var err error
+	storeBulkURI, err = url.Parse(mockServer.URL + "/updates/")
+	c.Assert(err, IsNil)
+	repo := NewUbuntuStoreSnapRepository()
+	repo.SetChannel("beta")
+	mockActiveSnapIterByType([]string{funkyAppName})
+
+	results, err := repo.Updates()
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[0].Channel(), Equals, "beta")
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryUpdatesTrackChannels(c *C) {
+	yamlPath, err := s.makeInstalledMockSnap()
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req bulkUpdatesRequest
+		c.Assert(json.NewDecoder(r.Body).Decode(&req), IsNil)
+		// the channel it was installed from
+		c.Check(req.Name, DeepEquals, []string{"hello-app." + testOrigin + "/remote-channel"})
+		// that the store does not repeat
+		io.WriteString(w, `[{"package_name": "hello-app", "origin": "`+testOrigin+`", "version": "2.0"}]`)
+	}))
+	defer mockServer.Close()
+
+	storeBulkURI, err = url.Parse(mockServer.URL + "/updates/")
+	c.Assert(err, IsNil)
+
+	results, err := NewUbuntuStoreSnapRepository().Updates()
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[0].Channel(), Equals, "remote-channel")
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryDetailsResolvesOrigin(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/search"):
+			c.Check(r.URL.Query().Get("q"), Equals, funkyAppName)
+			io.WriteString(w, MockSearchJSON)
+		case r.URL.Path == "/details/"+funkyAppName:
+			w.WriteHeader(404)
+			io.WriteString(w, MockNoDetailsJSON)
+		case r.URL.Path == "/details/"+funkyAppName+"."+funkyAppOrigin:
+			io.WriteString(w, MockDetailsJSON)
+		default:
+			c.Fatalf("unexpected request to %s", r.URL)
+		}
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	var err error
+	storeSearchURI, err = url.Parse(mockServer.URL + "/search")
+	c.Assert(err, IsNil)
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+	snap := NewUbuntuStoreSnapRepository()
+	c.Assert(snap, NotNil)
+
+	results, err := snap.Details(funkyAppName, "")
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[0].Origin(), Equals, funkyAppOrigin)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryDetailsAmbiguous(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/search") {
+			io.WriteString(w, `{"_embedded": {"clickindex:package": [
+{"package_name": "foo", "origin": "alice", "version": "1"},
+{"package_name": "foo", "origin": "bob", "version": "2"}
+]}}`)
+			return
+		}
+		w.WriteHeader(404)
+		io.WriteString(w, MockNoDetailsJSON)
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	var err error
+	storeSearchURI, err = url.Parse(mockServer.URL + "/search")
+	c.Assert(err, IsNil)
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+	snap := NewUbuntuStoreSnapRepository()
+	c.Assert(snap, NotNil)
+
+	_, err = snap.Details("foo", "")
+	c.Check(err, DeepEquals, &ErrAmbiguousName{Name: "foo", Origins: []string{"alice", "bob"}})
+}
+
 func (s *SnapTestSuite) TestUbuntuStoreRepositoryNoDetails(c *C) {
 	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		c.Assert(strings.HasSuffix(r.URL.String(), "no-such-pkg"), Equals, true)
@@ -755,7 +1058,7 @@ architectures:
 `
 
 	snapPkg := makeTestSnapPackage(c, packageHello)
-	part, err := NewSnapPartFromSnapFile(snapPkg, "original", true)
+	part, err := NewSnapPartFromSnapFile(snapPkg, "original", SideloadOptions{AllowUnauthenticated: true})
 	c.Assert(err, IsNil)
 
 	_, err = part.Install(&MockProgressMeter{}, 0)
@@ -1074,6 +1377,23 @@ framework: three, four
 	c.Assert(err, Equals, ErrInvalidFrameworkSpecInYaml)
 }
 
+func (s *SnapTestSuite) TestPackageYamlEpochParsing(c *C) {
+	m, err := parsePackageYamlData([]byte(`name: foo
+version: 1.0
+vendor: foo
+epoch: 2
+`), false)
+	c.Assert(err, IsNil)
+	c.Check(m.Epoch, Equals, 2)
+
+	_, err = parsePackageYamlData([]byte(`name: foo
+version: 1.0
+vendor: foo
+epoch: -1
+`), false)
+	c.Assert(err, ErrorMatches, ".*negative epoch -1.*")
+}
+
 func (s *SnapTestSuite) TestDetectsAlreadyInstalled(c *C) {
 	data := "name: afoo\nversion: 1\nvendor: foo"
 	yamlPath, err := makeInstalledMockSnap(s.tempdir, data)
@@ -1160,6 +1480,40 @@ binaries:
 	c.Assert(err, ErrorMatches, ".*binary and service both called foo.*")
 }
 
+func (s *SnapTestSuite) TestDetectsBinaryClash(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, `name: fmk1
+version: 1.0
+vendor: foo
+type: framework
+binaries:
+ - name: tool
+`)
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+
+	yaml, err := parsePackageYamlData([]byte(`name: fmk2
+version: 1.0
+vendor: foo
+type: framework
+binaries:
+ - name: bin/tool
+`), false)
+	c.Assert(err, IsNil)
+	err = yaml.checkForBinaryClashes()
+	c.Assert(err, DeepEquals, &ErrBinaryNameClash{Binary: "tool", Snap: "fmk1"})
+	c.Check(err, ErrorMatches, `binary "tool" clashes with that of fmk1`)
+
+	// the wrappers of apps have the name of the app in theirs
+	yaml, err = parsePackageYamlData([]byte("name: afoo\nversion: 1.0\nvendor: foo\nbinaries:\n - name: tool\n"), false)
+	c.Assert(err, IsNil)
+	c.Check(yaml.checkForBinaryClashes(), IsNil)
+
+	// and a new version of the framework replaces its own
+	yaml, err = parsePackageYamlData([]byte("name: fmk1\nversion: 2.0\nvendor: foo\ntype: framework\nbinaries:\n - name: tool\n"), false)
+	c.Assert(err, IsNil)
+	c.Check(yaml.checkForBinaryClashes(), IsNil)
+}
+
 func (s *SnapTestSuite) TestDetectsMissingFrameworks(c *C) {
 	data := []byte(`name: afoo
 version: 1.0
@@ -1203,13 +1557,12 @@ func (s *SnapTestSuite) TestRefreshDependentsSecurity(c *C) {
 	oldDir := dirs.SnapAppArmorDir
 	defer func() {
 		dirs.SnapAppArmorDir = oldDir
-		timestampUpdater = helpers.UpdateTimestamp
 	}()
 	touched := []string{}
 	dirs.SnapAppArmorDir = c.MkDir()
 	fn := filepath.Join(dirs.SnapAppArmorDir, "foo."+testOrigin+"_hello_1.0.json")
 	c.Assert(os.Symlink(fn, fn), IsNil)
-	timestampUpdater = func(s string) error {
+	s.backend.updateTimestamp = func(s string) error {
 		touched = append(touched, s)
 		return nil
 	}
@@ -1241,7 +1594,7 @@ binaries:
 	c.Assert(os.MkdirAll(filepath.Join(d2, dp), 0755), IsNil)
 	c.Assert(ioutil.WriteFile(filepath.Join(d2, dp, "foo"), []byte("x"), 0644), IsNil)
 
-	pb := &MockProgressMeter{}
+	pb := s.meter()
 	m, err := parsePackageYamlData([]byte(yaml), false)
 	part := &SnapPart{m: m, origin: testOrigin, basedir: d1}
 	c.Assert(part.RefreshDependentsSecurity(&SnapPart{basedir: d2}, pb), IsNil)
@@ -1269,6 +1622,108 @@ frameworks:
 	c.Check(err, ErrorMatches, `framework still in use by: foo`)
 }
 
+func (s *SnapTestSuite) TestConcurrentUninstallFrameworkAndDependent(c *C) {
+	fmkYaml, err := makeInstalledMockSnap(s.tempdir, `name: fmk
+version: 1.0
+vendor: foo
+type: framework`)
+	c.Assert(err, IsNil)
+	appYaml, err := makeInstalledMockSnap(s.tempdir, `name: foo
+version: 1.0
+vendor: foo
+frameworks:
+ - fmk
+`)
+	c.Assert(err, IsNil)
+
+	fmk, err := NewInstalledSnapPart(fmkYaml, testOrigin)
+	c.Assert(err, IsNil)
+	app, err := NewInstalledSnapPart(appYaml, testOrigin)
+	c.Assert(err, IsNil)
+
+	var wg sync.WaitGroup
+	var fmkErr, appErr error
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		fmkErr = fmk.Uninstall(new(MockProgressMeter))
+	}()
+	go func() {
+		defer wg.Done()
+		appErr = app.Uninstall(new(MockProgressMeter))
+	}()
+	wg.Wait()
+
+	c.Assert(appErr, IsNil)
+	c.Check(helpers.FileExists(app.basedir), Equals, false)
+
+	// either the framework went away after its dependent, or it
+	// was (still) in use and is left alone; nothing else is ok
+	if fmkErr != nil {
+		c.Check(fmkErr, ErrorMatches, `framework still in use by: foo`)
+		c.Check(helpers.FileExists(fmk.basedir), Equals, true)
+	} else {
+		c.Check(helpers.FileExists(fmk.basedir), Equals, false)
+	}
+}
+
+func (s *SnapTestSuite) TestConcurrentUninstallSameFramework(c *C) {
+	fmkYaml, err := makeInstalledMockSnap(s.tempdir, `name: fmk
+version: 1.0
+vendor: foo
+type: framework`)
+	c.Assert(err, IsNil)
+	appYaml, err := makeInstalledMockSnap(s.tempdir, `name: foo
+version: 1.0
+vendor: foo
+frameworks:
+ - fmk
+`)
+	c.Assert(err, IsNil)
+
+	app, err := NewInstalledSnapPart(appYaml, testOrigin)
+	c.Assert(err, IsNil)
+	fmks := make([]*SnapPart, 4)
+	for i := range fmks {
+		fmks[i], err = NewInstalledSnapPart(fmkYaml, testOrigin)
+		c.Assert(err, IsNil)
+	}
+
+	var wg sync.WaitGroup
+	var appErr error
+	errs := make([]error, len(fmks))
+	wg.Add(len(fmks) + 1)
+	go func() {
+		defer wg.Done()
+		appErr = app.Uninstall(s.meter())
+	}()
+	for i := range fmks {
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = fmks[i].Uninstall(s.meter())
+		}(i)
+	}
+	wg.Wait()
+
+	c.Assert(appErr, IsNil)
+
+	// each removal of the framework either found it in use, or
+	// removed it, or found it removed already; it is gone if (and
+	// only if) exactly one of them removed it
+	removed := 0
+	for _, err := range errs {
+		switch err {
+		case nil:
+			removed++
+		case ErrPackageNotFound:
+		default:
+			c.Check(err, ErrorMatches, `framework still in use by: foo`)
+		}
+	}
+	c.Check(removed <= 1, Equals, true)
+	c.Check(helpers.FileExists(filepath.Dir(fmkYaml)), Equals, removed == 0)
+}
+
 func (s *SnapTestSuite) TestNeedsAppArmorUpdateSecurityPolicy(c *C) {
 	// if a security policy is defined, never flag for update
 	sd := &SecurityDefinitions{SecurityPolicy: &SecurityPolicyDefinition{}}
@@ -1307,15 +1762,14 @@ func (s *SnapTestSuite) TestNeedsAppArmorUpdatePolicyAbsent(c *C) {
 
 func (s *SnapTestSuite) TestRequestAppArmorUpdateService(c *C) {
 	var updated []string
-	timestampUpdater = func(s string) error {
+	s.backend.updateTimestamp = func(s string) error {
 		updated = append(updated, s)
 		return nil
 	}
-	defer func() { timestampUpdater = helpers.UpdateTimestamp }()
 	// if one of the services needs updating, it's updated and returned
 	svc := ServiceYaml{Name: "svc", SecurityDefinitions: SecurityDefinitions{SecurityTemplate: "foo"}}
 	part := &SnapPart{m: &packageYaml{Name: "part", ServiceYamls: []ServiceYaml{svc}, Version: "42"}, origin: testOrigin}
-	err := part.RequestAppArmorUpdate(nil, map[string]bool{"foo": true})
+	err := part.RequestAppArmorUpdate(nil, map[string]bool{"foo": true}, s.meter())
 	c.Assert(err, IsNil)
 	c.Assert(updated, HasLen, 1)
 	c.Check(filepath.Base(updated[0]), Equals, "part."+testOrigin+"_svc_42.json")
@@ -1323,15 +1777,14 @@ func (s *SnapTestSuite) TestRequestAppArmorUpdateService(c *C) {
 
 func (s *SnapTestSuite) TestRequestAppArmorUpdateBinary(c *C) {
 	var updated []string
-	timestampUpdater = func(s string) error {
+	s.backend.updateTimestamp = func(s string) error {
 		updated = append(updated, s)
 		return nil
 	}
-	defer func() { timestampUpdater = helpers.UpdateTimestamp }()
 	// if one of the binaries needs updating, the part needs updating
 	bin := Binary{Name: "echo", SecurityDefinitions: SecurityDefinitions{SecurityTemplate: "foo"}}
 	part := &SnapPart{m: &packageYaml{Name: "part", Binaries: []Binary{bin}, Version: "42"}, origin: testOrigin}
-	err := part.RequestAppArmorUpdate(nil, map[string]bool{"foo": true})
+	err := part.RequestAppArmorUpdate(nil, map[string]bool{"foo": true}, s.meter())
 	c.Assert(err, IsNil)
 	c.Assert(updated, HasLen, 1)
 	c.Check(filepath.Base(updated[0]), Equals, "part."+testOrigin+"_echo_42.json")
@@ -1339,15 +1792,14 @@ func (s *SnapTestSuite) TestRequestAppArmorUpdateBinary(c *C) {
 
 func (s *SnapTestSuite) TestRequestAppArmorUpdateNothing(c *C) {
 	var updated []string
-	timestampUpdater = func(s string) error {
+	s.backend.updateTimestamp = func(s string) error {
 		updated = append(updated, s)
 		return nil
 	}
-	defer func() { timestampUpdater = helpers.UpdateTimestamp }()
 	svc := ServiceYaml{Name: "svc", SecurityDefinitions: SecurityDefinitions{SecurityTemplate: "foo"}}
 	bin := Binary{Name: "echo", SecurityDefinitions: SecurityDefinitions{SecurityTemplate: "foo"}}
 	part := &SnapPart{m: &packageYaml{ServiceYamls: []ServiceYaml{svc}, Binaries: []Binary{bin}, Version: "42"}, origin: testOrigin}
-	err := part.RequestAppArmorUpdate(nil, nil)
+	err := part.RequestAppArmorUpdate(nil, nil, s.meter())
 	c.Check(err, IsNil)
 	c.Check(updated, HasLen, 0)
 }
@@ -1487,17 +1939,83 @@ func (s *SnapTestSuite) TestWriteHardwareUdevActivate(c *C) {
 	type aCmd []string
 	var cmds = []aCmd{}
 
-	runUdevAdm = func(args ...string) error {
+	s.backend.udevAdm = func(args ...string) ([]byte, error) {
 		cmds = append(cmds, args)
-		return nil
+		return nil, nil
+	}
+
+	err := activateOemHardwareUdevRules(s.backend)
+	c.Assert(err, IsNil)
+	c.Assert(cmds[0], DeepEquals, aCmd{"control", "--reload-rules"})
+	c.Assert(cmds[1], DeepEquals, aCmd{"trigger"})
+	c.Assert(cmds[2], DeepEquals, aCmd{"settle", "--timeout=30"})
+	c.Assert(cmds, HasLen, 3)
+}
+
+func (s *SnapTestSuite) TestWriteHardwareUdevActivateSettleFails(c *C) {
+	s.backend.udevAdm = func(args ...string) ([]byte, error) {
+		if args[0] == "settle" {
+			return nil, errors.New("exit status 1")
+		}
+		return nil, nil
+	}
+
+	err := activateOemHardwareUdevRules(s.backend)
+	c.Assert(err, FitsTypeOf, &ErrUdevAdm{})
+	c.Check(err, ErrorMatches, "udevadm settle --timeout=30 failed: exit status 1")
+}
+
+func (s *SnapTestSuite) TestAssignedDevices(c *C) {
+	var args []string
+	s.backend.udevAdm = func(a ...string) ([]byte, error) {
+		args = a
+		return []byte("/sys/devices/pnp0/00:04/tty/ttyS0\n/sys/devices/pnp0/00:05/tty/ttyS1\n"), nil
+	}
+
+	devices, err := AssignedDevices("device-hive-iot-hal", s.meter())
+	c.Assert(err, IsNil)
+	c.Check(devices, DeepEquals, []string{"/sys/devices/pnp0/00:04/tty/ttyS0", "/sys/devices/pnp0/00:05/tty/ttyS1"})
+	c.Check(args, DeepEquals, []string{"trigger", "--dry-run", "--verbose", "--tag-match=snappy-assign", "--property-match=SNAPPY_APP=device-hive-iot-hal"})
+}
+
+func (s *SnapTestSuite) TestInstallOemHardwareUdevRulesAssigns(c *C) {
+	m, err := parsePackageYamlData(hardwareYaml, false)
+	c.Assert(err, IsNil)
+
+	s.backend.udevAdm = func(args ...string) ([]byte, error) {
+		if len(args) > 1 && args[1] == "--dry-run" {
+			return []byte("/sys/devices/pnp0/00:04/tty/ttyS0\n"), nil
+		}
+		return nil, nil
 	}
-	defer func() { runUdevAdm = runUdevAdmImpl }()
 
-	err := activateOemHardwareUdevRules()
+	c.Assert(installOemHardwareUdevRules(m, s.backend), IsNil)
+
+	access, err := readHWAccessJSONFile("device-hive-iot-hal")
 	c.Assert(err, IsNil)
-	c.Assert(cmds[0], DeepEquals, aCmd{"udevadm", "control", "--reload-rules"})
-	c.Assert(cmds[1], DeepEquals, aCmd{"udevadm", "trigger"})
-	c.Assert(cmds, HasLen, 2)
+	c.Check(access.WritePath, DeepEquals, []string{"/dev/**", "/sys/devices/pnp0/00:04/tty/ttyS0"})
+}
+
+func (s *SnapTestSuite) TestInstallOemHardwareUdevRulesRollback(c *C) {
+	m, err := parsePackageYamlData(hardwareYaml, false)
+	c.Assert(err, IsNil)
+
+	var cmds [][]string
+	s.backend.udevAdm = func(args ...string) ([]byte, error) {
+		cmds = append(cmds, args)
+		if len(args) > 1 && args[1] == "--dry-run" {
+			return nil, errors.New("exit status 1")
+		}
+		return nil, nil
+	}
+
+	err = installOemHardwareUdevRules(m, s.backend)
+	c.Assert(err, FitsTypeOf, &ErrUdevAdm{})
+
+	// nothing is left active
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapUdevRulesDir, "80-snappy_oem-foo_device-hive-iot-hal.rules")), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppArmorDir, "device-hive-iot-hal.json.additional")), Equals, false)
+	c.Check(cmds[len(cmds)-1], DeepEquals, []string{"control", "--reload-rules"})
 }
 
 func (s *SnapTestSuite) TestLegacyConfigHook(c *C) {
@@ -1658,3 +2176,23 @@ func (s *SnapTestSuite) TestChannelFromLocalManifest(c *C) {
 	snap, err := NewInstalledSnapPart(snapYaml, testOrigin)
 	c.Assert(snap.Channel(), Equals, "remote-channel")
 }
+
+func (s *SnapTestSuite) TestSaveStoreManifestReplacesContent(c *C) {
+	snap := RemoteSnapPart{pkg: remote.Snap{Name: "foo", Origin: testOrigin, Version: "1.0", Channel: "edge"}}
+	fn := RemoteManifestPath(&snap)
+
+	c.Assert(os.MkdirAll(filepath.Dir(fn), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(fn, []byte("truncated garbage that is longer than the manifest itself"), 0644), IsNil)
+	c.Assert(snap.saveStoreManifest(), IsNil)
+
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	var r remote.Snap
+	c.Assert(yaml.Unmarshal(content, &r), IsNil)
+	c.Check(r.Channel, Equals, "edge")
+
+	// no temporary files are left behind
+	matches, err := filepath.Glob(fn + ".*")
+	c.Assert(err, IsNil)
+	c.Check(matches, HasLen, 0)
+}
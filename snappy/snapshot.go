@@ -0,0 +1,291 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// the names of the files of a snapshot
+const (
+	snapshotInfoFile = "snapshot.yaml"
+	snapshotDataFile = "data.tar"
+)
+
+// SnapshotInfo describes a snapshot of the data of a snap
+type SnapshotInfo struct {
+	ID      string `yaml:"id"`
+	Name    string `yaml:"name"`
+	Origin  string `yaml:"origin,omitempty"`
+	Version string `yaml:"version"`
+	// Taken is the time of the snapshot (in seconds since the epoch)
+	Taken int64 `yaml:"taken"`
+	// Sha512 is the hash of the archive of the data
+	Sha512 string `yaml:"sha512"`
+	// DataDirs are the data directories in the archive (the system
+	// one and those of the users)
+	DataDirs []string `yaml:"data-dirs,omitempty"`
+}
+
+// TakenAt returns the time the snapshot was taken
+func (si *SnapshotInfo) TakenAt() time.Time {
+	return time.Unix(si.Taken, 0)
+}
+
+func (si *SnapshotInfo) dir() string {
+	return filepath.Join(dirs.SnapSnapshotsDir, si.ID)
+}
+
+// byTaken sorts snapshots, most recently taken first
+type byTaken []*SnapshotInfo
+
+func (ss byTaken) Len() int           { return len(ss) }
+func (ss byTaken) Swap(a, b int)      { ss[a], ss[b] = ss[b], ss[a] }
+func (ss byTaken) Less(a, b int) bool { return ss[a].Taken > ss[b].Taken }
+
+// runTar runs tar on the files (given as absolute paths) relative to
+// the root directory
+func runTar(args ...string) error {
+	cmd := exec.Command("tar", append([]string{"-C", "/"}, args...)...)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("tar failed with: '%s' (%v)", output, err)
+	}
+
+	return nil
+}
+
+// Snapshot archives the data of the active version of the snap with
+// the given name (that of the system and of the users) in the
+// snapshots directory, to be restored with RestoreSnapshot. The data
+// is archived as is: services of the snap writing to it as it is
+// archived could make the snapshot inconsistent.
+func Snapshot(name string) (snapshot *SnapshotInfo, err error) {
+	part, ok := ActiveSnapByName(name).(*SnapPart)
+	if !ok {
+		return nil, ErrPackageNotFound
+	}
+
+	snapshot = &SnapshotInfo{
+		Name:    part.Name(),
+		Origin:  part.origin,
+		Version: part.Version(),
+		Taken:   correctedNow().Unix(),
+		ID:      fmt.Sprintf("%s_%s_%d", QualifiedName(part), part.Version(), time.Now().UnixNano()),
+	}
+
+	dataDirs, err := snapDataDirs(QualifiedName(part), part.Version())
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"-cpf", filepath.Join(snapshot.dir(), snapshotDataFile)}
+	for _, dir := range dataDirs {
+		if !helpers.FileExists(dir) {
+			continue
+		}
+		snapshot.DataDirs = append(snapshot.DataDirs, dir)
+		args = append(args, strings.TrimPrefix(dir, "/"))
+	}
+
+	if err := os.MkdirAll(snapshot.dir(), 0700); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			os.RemoveAll(snapshot.dir())
+		}
+	}()
+
+	if err = runTar(args...); err != nil {
+		return nil, err
+	}
+	snapshot.Sha512, err = helpers.Sha512sum(filepath.Join(snapshot.dir(), snapshotDataFile))
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := yaml.Marshal(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	if err = helpers.AtomicWriteFile(filepath.Join(snapshot.dir(), snapshotInfoFile), content, 0600, 0); err != nil {
+		return nil, err
+	}
+
+	return snapshot, nil
+}
+
+// Snapshots returns the snapshots of the snap with the given
+// name[.origin], or of all snaps if name is empty, most recently taken
+// first
+func Snapshots(name string) ([]*SnapshotInfo, error) {
+	infos, err := filepath.Glob(filepath.Join(dirs.SnapSnapshotsDir, "*", snapshotInfoFile))
+	if err != nil {
+		return nil, err
+	}
+
+	name, origin := SplitOrigin(name)
+	snapshots := make([]*SnapshotInfo, 0, len(infos))
+	for _, info := range infos {
+		content, err := ioutil.ReadFile(info)
+		if err != nil {
+			return nil, err
+		}
+
+		var si SnapshotInfo
+		if err := yaml.Unmarshal(content, &si); err != nil {
+			return nil, &ErrInvalidYaml{File: info, Err: err, Yaml: content}
+		}
+		if name != "" && (si.Name != name || (origin != "" && si.Origin != origin)) {
+			continue
+		}
+
+		snapshots = append(snapshots, &si)
+	}
+	sort.Sort(byTaken(snapshots))
+
+	return snapshots, nil
+}
+
+// RestoreSnapshot puts the data of the snap with the given name[.origin] back
+// as it was when the snapshot with the given id was taken. The data
+// is restored for the version it was taken of, which has to be
+// installed; if it is active it is stopped while its data gets
+// replaced.
+func RestoreSnapshot(name, id string, meter progress.Meter) (err error) {
+	snapshots, err := Snapshots(name)
+	if err != nil {
+		return err
+	}
+	var snapshot *SnapshotInfo
+	for _, si := range snapshots {
+		if si.ID == id {
+			snapshot = si
+			break
+		}
+	}
+	if snapshot == nil {
+		return ErrSnapshotNotFound
+	}
+
+	archive := filepath.Join(snapshot.dir(), snapshotDataFile)
+	sha512, err := helpers.Sha512sum(archive)
+	if err != nil {
+		return err
+	}
+	if sha512 != snapshot.Sha512 {
+		return ErrSnapshotDamaged
+	}
+
+	installed, err := NewMetaLocalRepository().Installed()
+	if err != nil {
+		return err
+	}
+	parts := FindSnapsByNameAndVersion(snapshot.Name+"."+snapshot.Origin, snapshot.Version, installed)
+	if len(parts) == 0 {
+		return fmt.Errorf("cannot restore the snapshot of %s %s: that version is not installed", name, snapshot.Version)
+	}
+	part, ok := parts[0].(*SnapPart)
+	if !ok {
+		return ErrPackageNotFound
+	}
+
+	unlock, err := lockSystem(true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tx := &transaction{op: "the restore of snapshot " + id}
+	defer func() {
+		if err != nil {
+			tx.rollback()
+		}
+	}()
+
+	if part.IsActive() {
+		if err := tx.do("the deactivation", func() error {
+			return part.deactivate(false, meter)
+		}, func() error {
+			return part.activate(false, meter)
+		}); err != nil {
+			return err
+		}
+	}
+
+	// the data as it is now is kept aside until the snapshot is
+	// restored
+	dataDirs, err := snapDataDirs(QualifiedName(part), part.Version())
+	if err != nil {
+		return err
+	}
+	var aside []string
+	for _, dir := range dataDirs {
+		dir := dir
+		if !helpers.FileExists(dir) {
+			continue
+		}
+		if err := tx.do("the move aside of "+dir, func() error {
+			return os.Rename(dir, dir+".restore")
+		}, func() error {
+			if !helpers.FileExists(dir + ".restore") {
+				return nil
+			}
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+			return os.Rename(dir+".restore", dir)
+		}); err != nil {
+			return err
+		}
+		aside = append(aside, dir+".restore")
+	}
+
+	if err := runTar("-xpf", archive); err != nil {
+		return err
+	}
+
+	if part.IsActive() {
+		if err := part.activate(false, meter); err != nil {
+			return err
+		}
+	}
+
+	for _, dir := range aside {
+		if err := os.RemoveAll(dir); err != nil {
+			logger.Noticef("Failed to remove %q: %v", dir, err)
+		}
+	}
+
+	return nil
+}
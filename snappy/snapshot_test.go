@@ -0,0 +1,122 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+// makeHelloAppData writes data of hello-app 1.10, that of the system
+// and of a user, returning the files
+func (s *SnapTestSuite) makeHelloAppData(c *C, content string) []string {
+	files := []string{
+		filepath.Join(dirs.SnapDataDir, helloAppComposedName, "1.10", "db"),
+		filepath.Join(s.tempdir, "home", "user1", "apps", helloAppComposedName, "1.10", "settings"),
+	}
+	for _, fn := range files {
+		c.Assert(os.MkdirAll(filepath.Dir(fn), 0755), IsNil)
+		c.Assert(ioutil.WriteFile(fn, []byte(content), 0644), IsNil)
+	}
+
+	return files
+}
+
+func (s *SnapTestSuite) TestSnapshotRestore(c *C) {
+	s.activeHelloApp(c, "1.10")
+	files := s.makeHelloAppData(c, "before")
+
+	snapshot, err := Snapshot("hello-app")
+	c.Assert(err, IsNil)
+	c.Check(snapshot.Name, Equals, "hello-app")
+	c.Check(snapshot.Version, Equals, "1.10")
+	c.Check(snapshot.DataDirs, DeepEquals, []string{filepath.Dir(files[1]), filepath.Dir(files[0])})
+
+	snapshots, err := Snapshots("hello-app")
+	c.Assert(err, IsNil)
+	c.Assert(snapshots, HasLen, 1)
+	c.Check(snapshots[0], DeepEquals, snapshot)
+
+	// the risky update happens
+	s.makeHelloAppData(c, "after")
+	extra := filepath.Join(filepath.Dir(files[0]), "junk")
+	c.Assert(ioutil.WriteFile(extra, nil, 0644), IsNil)
+
+	c.Assert(RestoreSnapshot("hello-app", snapshot.ID, &MockProgressMeter{}), IsNil)
+	for _, fn := range files {
+		content, err := ioutil.ReadFile(fn)
+		c.Assert(err, IsNil)
+		c.Check(string(content), Equals, "before")
+		c.Check(helpers.FileExists(filepath.Dir(fn)+".restore"), Equals, false)
+	}
+	c.Check(helpers.FileExists(extra), Equals, false)
+	c.Check(ActiveSnapByName("hello-app").Version(), Equals, "1.10")
+}
+
+func (s *SnapTestSuite) TestSnapshots(c *C) {
+	s.activeHelloApp(c, "1.10")
+	s.makeHelloAppData(c, "data")
+
+	first, err := Snapshot("hello-app")
+	c.Assert(err, IsNil)
+	second, err := Snapshot("hello-app")
+	c.Assert(err, IsNil)
+	c.Check(first.ID, Not(Equals), second.ID)
+
+	snapshots, err := Snapshots("")
+	c.Assert(err, IsNil)
+	c.Check(snapshots, HasLen, 2)
+	snapshots, err = Snapshots("hello-app." + testOrigin)
+	c.Assert(err, IsNil)
+	c.Check(snapshots, HasLen, 2)
+	snapshots, err = Snapshots("hello-app.other")
+	c.Assert(err, IsNil)
+	c.Check(snapshots, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestRestoreSnapshotDamaged(c *C) {
+	s.activeHelloApp(c, "1.10")
+	files := s.makeHelloAppData(c, "before")
+
+	snapshot, err := Snapshot("hello-app")
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(snapshot.dir(), snapshotDataFile), []byte("bitrot"), 0600), IsNil)
+
+	s.makeHelloAppData(c, "after")
+	c.Check(RestoreSnapshot("hello-app", snapshot.ID, &MockProgressMeter{}), Equals, ErrSnapshotDamaged)
+
+	// the data is left as is
+	content, err := ioutil.ReadFile(files[0])
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, "after")
+}
+
+func (s *SnapTestSuite) TestSnapshotNotFound(c *C) {
+	_, err := Snapshot("hello-app")
+	c.Check(err, Equals, ErrPackageNotFound)
+
+	c.Check(RestoreSnapshot("hello-app", "no-such-snapshot", &MockProgressMeter{}), Equals, ErrSnapshotNotFound)
+}
@@ -184,6 +184,36 @@ func VersionCompare(va, vb string) (res int) {
 	return compareSubversion(revA, revB)
 }
 
+// epocher is a Part that knows the epoch of its version
+type epocher interface {
+	Epoch() int
+}
+
+// Epoch returns the epoch of the version of the part, 0 if it has none
+func Epoch(p Part) int {
+	if e, ok := p.(epocher); ok {
+		return e.Epoch()
+	}
+
+	return 0
+}
+
+// CompareVersions compares the versions of two parts like
+// VersionCompare, but the version with the bigger epoch is the bigger
+// one whatever the version strings, so a publisher can start over
+// with lower versions
+func CompareVersions(a, b Part) int {
+	ea, eb := Epoch(a), Epoch(b)
+	switch {
+	case ea < eb:
+		return -1
+	case ea > eb:
+		return 1
+	}
+
+	return VersionCompare(a.Version(), b.Version())
+}
+
 // ByVersion provides a sort interface
 type ByVersion []string
 
@@ -201,7 +231,7 @@ func (bv ByVersion) Len() int {
 type BySnapVersion []Part
 
 func (bv BySnapVersion) Less(a, b int) bool {
-	return (VersionCompare(bv[a].Version(), bv[b].Version()) < 0)
+	return CompareVersions(bv[a], bv[b]) < 0
 }
 func (bv BySnapVersion) Swap(a, b int) {
 	bv[a], bv[b] = bv[b], bv[a]
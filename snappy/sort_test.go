@@ -92,6 +92,30 @@ func (s *SortTestSuite) TestSortSnaps(c *C) {
 	c.Assert(snaps[1].Version(), Equals, "2.0")
 }
 
+func (s *SortTestSuite) TestSortSnapsEpoch(c *C) {
+	snaps := []Part{
+		&RemoteSnapPart{pkg: remote.Snap{Version: "2015.10", Epoch: 1}},
+		&RemoteSnapPart{pkg: remote.Snap{Version: "1.0", Epoch: 2}},
+		&RemoteSnapPart{pkg: remote.Snap{Version: "2016.1", Epoch: 1}},
+		&RemoteSnapPart{pkg: remote.Snap{Version: "3.0"}},
+	}
+	sort.Sort(BySnapVersion(snaps))
+	var versions []string
+	for _, snap := range snaps {
+		versions = append(versions, snap.Version())
+	}
+	c.Check(versions, DeepEquals, []string{"3.0", "2015.10", "2016.1", "1.0"})
+}
+
+func (s *SortTestSuite) TestCompareVersions(c *C) {
+	a := &RemoteSnapPart{pkg: remote.Snap{Version: "2.0"}}
+	b := &RemoteSnapPart{pkg: remote.Snap{Version: "1.0", Epoch: 1}}
+	c.Check(CompareVersions(a, b), Equals, -1)
+	c.Check(CompareVersions(b, a), Equals, 1)
+	c.Check(CompareVersions(a, a), Equals, 0)
+	c.Check(Epoch(&SystemImagePart{}), Equals, 0)
+}
+
 func (s *SortTestSuite) TestSideloadVersion(c *C) {
 	n := 1000
 	vs := make(ByVersion, n)
@@ -0,0 +1,329 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/progress"
+	"github.com/ubuntu-core/snappy/systemd"
+)
+
+// the suffix of the internal storage of a snap while it is moved to
+// a storage location
+const storageStagingSuffix = ".moving"
+
+// storageMountTimeout is how long to wait for a storage location to
+// get unmounted
+var storageMountTimeout = time.Minute
+
+// storageLocations maps the qualified name of a snap (or "" for the
+// whole apps tree) to the directory it is stored in
+type storageLocations map[string]string
+
+func storageLocationsFile() string {
+	return filepath.Join(dirs.GlobalRootDir, dirs.SnappyDir, "storage.yaml")
+}
+
+func readStorageLocations() (storageLocations, error) {
+	locations := make(storageLocations)
+
+	content, err := ioutil.ReadFile(storageLocationsFile())
+	if os.IsNotExist(err) {
+		return locations, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(content, &locations); err != nil {
+		return nil, err
+	}
+
+	return locations, nil
+}
+
+func (locations storageLocations) save() error {
+	content, err := yaml.Marshal(locations)
+	if err != nil {
+		return err
+	}
+
+	fn := storageLocationsFile()
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+
+	return helpers.AtomicWriteFile(fn, content, 0644, 0)
+}
+
+// qualifiedName returns the qualified name of the given snap, which
+// might be in a storage location that is not mounted
+func (locations storageLocations) qualifiedName(name string) (string, error) {
+	installed, err := NewMetaLocalRepository().Installed()
+	if err != nil {
+		return "", err
+	}
+	if parts := FindSnapsByName(name, installed); len(parts) > 0 {
+		return QualifiedName(parts[0]), nil
+	}
+
+	for qn := range locations {
+		if qn != "" && (qn == name || strings.HasPrefix(qn, name+".")) {
+			return qn, nil
+		}
+	}
+
+	return "", ErrPackageNotFound
+}
+
+// storageMountPoint returns the directory that gets bind-mounted from
+// the storage location
+func storageMountPoint(qn string) string {
+	if qn == "" {
+		return dirs.SnapAppsDir
+	}
+
+	return filepath.Join(dirs.SnapAppsDir, qn)
+}
+
+func storageMountUnit(qn string) string {
+	return systemd.MountUnitName(stripGlobalRootDir(storageMountPoint(qn)))
+}
+
+// storageDir returns the directory that holds the snap (or the whole
+// apps tree) in the given storage location
+func storageDir(qn, location string) string {
+	if location == "" {
+		return storageMountPoint(qn)
+	}
+
+	return filepath.Join(location, filepath.Base(storageMountPoint(qn)))
+}
+
+// moveDirContents moves everything in src into dst
+func moveDirContents(src, dst string) error {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err := moveDir(filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addStorageMount(qn, location string, inter interacter) error {
+	unit := storageMountUnit(qn)
+	content := newSystemd(nil).GenMountFile(storageDir(qn, location), stripGlobalRootDir(storageMountPoint(qn)))
+	if err := os.MkdirAll(dirs.SnapServicesDir, 0755); err != nil {
+		return err
+	}
+	if err := helpers.AtomicWriteFile(filepath.Join(dirs.SnapServicesDir, unit), []byte(content), 0644, 0); err != nil {
+		return err
+	}
+
+	sysd := newSystemd(inter)
+	if err := sysd.DaemonReload(); err != nil {
+		return err
+	}
+	if err := sysd.Enable(unit); err != nil {
+		return err
+	}
+
+	return sysd.Start(unit)
+}
+
+func removeStorageMount(qn string, inter interacter) error {
+	unit := storageMountUnit(qn)
+	sysd := newSystemd(inter)
+	if err := sysd.Disable(unit); err != nil {
+		return err
+	}
+	if err := sysd.Stop(unit, storageMountTimeout); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(dirs.SnapServicesDir, unit)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return sysd.DaemonReload()
+}
+
+// SetStorageLocation moves the given snap (or, if name is "", the whole
+// apps tree) to the target directory (e.g. on a SD card or USB stick)
+// and bind-mounts it back into place. An empty target moves it back
+// to the internal storage. The new location is mounted and saved
+// before the data is moved, so a failure leaves the data where the
+// saved locations have it.
+func SetStorageLocation(name, target string, meter progress.Meter) error {
+	if target != "" {
+		target = filepath.Clean(target)
+		if !filepath.IsAbs(target) || !helpers.IsDirectory(target) {
+			return &ErrInvalidStorageLocation{Location: target}
+		}
+	}
+
+	locations, err := readStorageLocations()
+	if err != nil {
+		return err
+	}
+
+	qn := ""
+	if name != "" {
+		qn, err = locations.qualifiedName(name)
+		if err != nil {
+			return err
+		}
+	}
+	current := locations[qn]
+	if current == target {
+		return nil
+	}
+
+	src := storageDir(qn, current)
+	unstage := func() {}
+	if current != "" {
+		if err := removeStorageMount(qn, meter); err != nil {
+			return err
+		}
+	} else if helpers.FileExists(src) {
+		// the mount hides what is in the internal storage, so it
+		// is put aside (on the same filesystem) to be moved from
+		staging := src + storageStagingSuffix
+		if err := os.Rename(src, staging); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(src, 0755); err != nil {
+			return err
+		}
+		internal := src
+		unstage = func() {
+			os.Remove(internal)
+			if err := os.Rename(staging, internal); err != nil {
+				logger.Noticef("Failed to restore %q: %v", internal, err)
+			}
+		}
+		src = staging
+	}
+
+	// the location is mounted and recorded before anything is
+	// moved, so that a failure never leaves the data where nothing
+	// mounts it from
+	if target == "" {
+		delete(locations, qn)
+	} else {
+		locations[qn] = target
+		err = os.MkdirAll(storageDir(qn, target), 0755)
+		if err == nil {
+			err = addStorageMount(qn, target, meter)
+		}
+	}
+	if err == nil {
+		err = locations.save()
+	}
+	if err != nil {
+		if target != "" {
+			removeStorageMount(qn, meter)
+		}
+		unstage()
+		return err
+	}
+
+	if err := moveDirContents(src, storageDir(qn, target)); err != nil {
+		return err
+	}
+	if err := os.Remove(src); err != nil {
+		logger.Noticef("Failed to remove %q: %v", src, err)
+	}
+
+	return nil
+}
+
+// ensureStorageMount (re)generates the mount unit of the snap if it
+// has a storage location but no mount unit
+func ensureStorageMount(qn string, inter interacter) error {
+	locations, err := readStorageLocations()
+	if err != nil {
+		return err
+	}
+	location, ok := locations[qn]
+	if !ok || helpers.FileExists(filepath.Join(dirs.SnapServicesDir, storageMountUnit(qn))) {
+		return nil
+	}
+
+	return addStorageMount(qn, location, inter)
+}
+
+// CheckStorageLocations makes sure all the storage locations are
+// mounted, it is meant to be run at boot
+func CheckStorageLocations(meter progress.Meter) error {
+	locations, err := readStorageLocations()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(locations))
+	for qn := range locations {
+		names = append(names, qn)
+	}
+	sort.Strings(names)
+
+	sysd := newSystemd(meter)
+	var problems []string
+	for _, qn := range names {
+		if !helpers.IsDirectory(storageDir(qn, locations[qn])) {
+			problems = append(problems, fmt.Sprintf("%s is missing", storageDir(qn, locations[qn])))
+			continue
+		}
+
+		unit := storageMountUnit(qn)
+		status, err := sysd.ServiceStatus(unit)
+		if err == nil && status.ActiveState == "active" {
+			continue
+		}
+		if err := sysd.Start(unit); err != nil {
+			problems = append(problems, fmt.Sprintf("can not mount %s: %v", storageDir(qn, locations[qn]), err))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("storage locations not available: %s", strings.Join(problems, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,133 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/systemd"
+)
+
+func (s *SnapTestSuite) TestSetStorageLocation(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+
+	var cmds []string
+	s.backend.systemctl = func(cmd ...string) ([]byte, error) {
+		cmds = append(cmds, strings.Join(cmd, " "))
+		return []byte("ActiveState=inactive\n"), nil
+	}
+
+	sd := c.MkDir()
+	c.Assert(SetStorageLocation("hello-app", sd, s.meter()), IsNil)
+
+	// the snap moved to the external storage
+	c.Check(helpers.FileExists(filepath.Join(sd, helloAppComposedName, "1.10", "meta", "package.yaml")), Equals, true)
+	c.Check(helpers.FileExists(yamlFile), Equals, false)
+
+	unit := systemd.MountUnitName("/apps/" + helloAppComposedName)
+	content, err := ioutil.ReadFile(filepath.Join(dirs.SnapServicesDir, unit))
+	c.Assert(err, IsNil)
+	c.Check(string(content), Matches, "(?s).*\nWhat="+filepath.Join(sd, helloAppComposedName)+"\nWhere=/apps/"+helloAppComposedName+"\n.*")
+	_, err = os.Lstat(filepath.Join(dirs.SnapServicesDir, "multi-user.target.wants", unit))
+	c.Check(err, IsNil)
+	c.Check(cmds, DeepEquals, []string{"daemon-reload", "start " + unit})
+
+	locations, err := readStorageLocations()
+	c.Assert(err, IsNil)
+	c.Check(locations, DeepEquals, storageLocations{helloAppComposedName: sd})
+
+	// and back to the internal storage
+	c.Assert(SetStorageLocation("hello-app", "", s.meter()), IsNil)
+	c.Check(helpers.FileExists(yamlFile), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(sd, helloAppComposedName)), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapServicesDir, unit)), Equals, false)
+
+	locations, err = readStorageLocations()
+	c.Assert(err, IsNil)
+	c.Check(locations, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestSetStorageLocationMountFails(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+
+	s.backend.systemctl = func(cmd ...string) ([]byte, error) {
+		if cmd[0] == "start" {
+			return nil, errors.New("no mount for you")
+		}
+		return []byte("ActiveState=inactive\n"), nil
+	}
+
+	sd := c.MkDir()
+	c.Assert(SetStorageLocation("hello-app", sd, s.meter()), NotNil)
+
+	// the data did not move and the location was not saved
+	c.Check(helpers.FileExists(yamlFile), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(sd, helloAppComposedName, "1.10")), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, helloAppComposedName+storageStagingSuffix)), Equals, false)
+
+	locations, err := readStorageLocations()
+	c.Assert(err, IsNil)
+	c.Check(locations, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestSetStorageLocationInvalid(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+
+	err = SetStorageLocation("hello-app", "relative/path", s.meter())
+	c.Assert(err, FitsTypeOf, &ErrInvalidStorageLocation{})
+	err = SetStorageLocation("hello-app", filepath.Join(s.tempdir, "not-there"), s.meter())
+	c.Assert(err, FitsTypeOf, &ErrInvalidStorageLocation{})
+
+	c.Check(SetStorageLocation("not-installed", c.MkDir(), s.meter()), Equals, ErrPackageNotFound)
+}
+
+func (s *SnapTestSuite) TestCheckStorageLocations(c *C) {
+	sd := c.MkDir()
+	c.Assert(os.MkdirAll(filepath.Join(sd, "foo.bar"), 0755), IsNil)
+	c.Assert(storageLocations{"foo.bar": sd, "baz.bar": filepath.Join(s.tempdir, "gone")}.save(), IsNil)
+
+	var cmds []string
+	s.backend.systemctl = func(cmd ...string) ([]byte, error) {
+		cmds = append(cmds, strings.Join(cmd, " "))
+		return []byte("ActiveState=inactive\n"), nil
+	}
+
+	err := CheckStorageLocations(s.meter())
+	c.Assert(err, ErrorMatches, `storage locations not available: .*/gone/baz.bar is missing`)
+	// the not mounted location gets mounted
+	c.Check(cmds, DeepEquals, []string{
+		"show --property=Id,LoadState,ActiveState,SubState,UnitFileState apps-foo.bar.mount",
+		"start apps-foo.bar.mount",
+	})
+}
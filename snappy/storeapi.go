@@ -0,0 +1,176 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"github.com/ubuntu-core/snappy/pkg/remote"
+)
+
+// the media types of the versions of the store API: v1 is HAL (plain
+// JSON for the bulk endpoint, see LP: #1427155), v2 the same data as
+// plain JSON documents, in the same media type on every endpoint
+const (
+	storeAPIv1     = "application/hal+json"
+	storeAPIv1Bulk = "application/json"
+	storeAPIv2     = "application/vnd.snappy.store.v2+json"
+)
+
+// acceptStoreAPIs makes the request accept both versions of the store
+// API, v2 first, for the store to answer in the one it has for the
+// endpoint; v1 is the media type of v1 on the endpoint
+func acceptStoreAPIs(req *http.Request, v1 string) {
+	req.Header.Set("Accept", storeAPIv2+", "+v1+";q=0.9")
+}
+
+// storeAPIVersion returns the version of the store API the response is
+// in, by its media type (v1 unless it says otherwise)
+func storeAPIVersion(resp *http.Response) int {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err == nil && mediaType == storeAPIv2 {
+		return 2
+	}
+
+	return 1
+}
+
+// searchResultsV2 is a page of search results in v2 of the store API
+// (see searchResults for v1)
+type searchResultsV2 struct {
+	Packages []remote.Snap `json:"packages"`
+	// Next is the link to the next page, if any
+	Next string `json:"next,omitempty"`
+}
+
+// decodeSearchResults decodes a page of search results, whatever the
+// version of the store API, returning the packages and the link to the
+// next page
+func decodeSearchResults(resp *http.Response) ([]remote.Snap, string, error) {
+	dec := json.NewDecoder(resp.Body)
+
+	switch v := storeAPIVersion(resp); v {
+	case 1:
+		var searchData searchResults
+		if err := dec.Decode(&searchData); err != nil {
+			return nil, "", err
+		}
+		return searchData.Payload.Packages, searchData.Links.Next.Href, nil
+	case 2:
+		var searchData searchResultsV2
+		if err := dec.Decode(&searchData); err != nil {
+			return nil, "", err
+		}
+		return searchData.Packages, searchData.Next, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported store API version %d", v)
+	}
+}
+
+// departmentsResultsV2 are the departments in v2 of the store API (see
+// departmentsResults for v1)
+type departmentsResultsV2 struct {
+	Departments []Category `json:"departments"`
+}
+
+// decodeDepartments decodes the departments, whatever the version of
+// the store API
+func decodeDepartments(resp *http.Response) ([]Category, error) {
+	dec := json.NewDecoder(resp.Body)
+
+	switch v := storeAPIVersion(resp); v {
+	case 1:
+		var departmentsData departmentsResults
+		if err := dec.Decode(&departmentsData); err != nil {
+			return nil, err
+		}
+		return departmentsData.Payload.Departments, nil
+	case 2:
+		var departmentsData departmentsResultsV2
+		if err := dec.Decode(&departmentsData); err != nil {
+			return nil, err
+		}
+		return departmentsData.Departments, nil
+	default:
+		return nil, fmt.Errorf("unsupported store API version %d", v)
+	}
+}
+
+// detailsResultsV2 are the details of a snap in v2 of the store API
+// (v1 is the remote.Snap itself)
+type detailsResultsV2 struct {
+	Package remote.Snap `json:"package"`
+}
+
+// decodeDetails decodes the details of a snap, whatever the version of
+// the store API
+func decodeDetails(resp *http.Response) (*remote.Snap, error) {
+	dec := json.NewDecoder(resp.Body)
+
+	switch v := storeAPIVersion(resp); v {
+	case 1:
+		var detailsData remote.Snap
+		if err := dec.Decode(&detailsData); err != nil {
+			return nil, err
+		}
+		return &detailsData, nil
+	case 2:
+		var detailsData detailsResultsV2
+		if err := dec.Decode(&detailsData); err != nil {
+			return nil, err
+		}
+		return &detailsData.Package, nil
+	default:
+		return nil, fmt.Errorf("unsupported store API version %d", v)
+	}
+}
+
+// bulkResultsV2 are the snaps of the bulk endpoint (of the details of
+// many snaps, and of the updates) in v2 of the store API (v1 is the
+// list itself)
+type bulkResultsV2 struct {
+	Packages []json.RawMessage `json:"packages"`
+}
+
+// decodeBulk decodes the snaps of the bulk endpoint, whatever the
+// version of the store API, leaving them to be decoded by the caller
+func decodeBulk(resp *http.Response) ([]json.RawMessage, error) {
+	dec := json.NewDecoder(resp.Body)
+
+	switch v := storeAPIVersion(resp); v {
+	case 1:
+		var bulkData []json.RawMessage
+		if err := dec.Decode(&bulkData); err != nil {
+			return nil, err
+		}
+		return bulkData, nil
+	case 2:
+		var bulkData bulkResultsV2
+		if err := dec.Decode(&bulkData); err != nil {
+			return nil, err
+		}
+		return bulkData.Packages, nil
+	default:
+		return nil, fmt.Errorf("unsupported store API version %d", v)
+	}
+}
@@ -0,0 +1,132 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	. "gopkg.in/check.v1"
+)
+
+// mockSearchPageV2 is mockSearchPage in v2 of the store API
+const mockSearchPageV2 = `{
+    "packages": [
+        {"package_name": "snap%d", "origin": "foo", "version": "1.0"}
+    ],
+    "next": "%s"
+}`
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositorySearchAPIv2(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("Accept"), Equals, storeAPIv2+", "+storeAPIv1+";q=0.9")
+
+		next := ""
+		if r.URL.Query().Get("page") == "" {
+			next = "/search?q=foo&page=1"
+		}
+		w.Header().Set("Content-Type", storeAPIv2+"; charset=utf-8")
+		fmt.Fprintf(w, mockSearchPageV2, len(next), next)
+	}))
+	defer mockServer.Close()
+
+	var err error
+	storeSearchURI, err = url.Parse(mockServer.URL + "/search")
+	c.Assert(err, IsNil)
+
+	results, err := NewUbuntuStoreSnapRepository().Search("foo")
+	c.Assert(err, IsNil)
+	c.Check(results, HasLen, 2)
+	c.Check(results["snap0"], NotNil)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryCategoriesAPIv2(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", storeAPIv2)
+		io.WriteString(w, `{"departments": [{"name": "Games", "slug": "games", "has_children": true}]}`)
+	}))
+	defer mockServer.Close()
+
+	var err error
+	storeDepartmentsURI, err = url.Parse(mockServer.URL + "/departments")
+	c.Assert(err, IsNil)
+
+	categories, err := NewUbuntuStoreSnapRepository().Categories()
+	c.Assert(err, IsNil)
+	c.Check(categories, DeepEquals, []Category{{Name: "Games", Slug: "games", HasChildren: true}})
+}
+
+func (s *SnapTestSuite) TestStoreAPIVersion(c *C) {
+	for contentType, version := range map[string]int{
+		"":                         1,
+		"application/hal+json":     1,
+		"application/json":         1,
+		storeAPIv2:                 2,
+		storeAPIv2 + "; charset=x": 2,
+		"garbage;;":                1,
+	} {
+		resp := &http.Response{Header: http.Header{"Content-Type": {contentType}}}
+		c.Check(storeAPIVersion(resp), Equals, version, Commentf("%q", contentType))
+	}
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryDetailsAPIv2(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("Accept"), Equals, storeAPIv2+", "+storeAPIv1+";q=0.9")
+		w.Header().Set("Content-Type", storeAPIv2)
+		fmt.Fprintf(w, `{"package": %s}`, MockDetailsJSON)
+	}))
+	defer mockServer.Close()
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	parts, err := NewUbuntuStoreSnapRepository().Details(funkyAppName, funkyAppOrigin)
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 1)
+	c.Check(parts[0].Version(), Equals, "42")
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryBulkAPIv2(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.Header.Get("Accept"), Equals, storeAPIv2+", "+storeAPIv1Bulk+";q=0.9")
+		w.Header().Set("Content-Type", storeAPIv2)
+		fmt.Fprintf(w, `{"packages": %s}`, MockUpdatesJSON)
+	}))
+	defer mockServer.Close()
+
+	repo := NewUbuntuStoreSnapRepository()
+	repo.bulkURI = mockServer.URL + "/updates/"
+	mockActiveSnapIterByType([]string{funkyAppName})
+
+	results, err := repo.Updates()
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[0].Version(), Equals, "42")
+
+	results, err = repo.DetailsMany([]string{funkyAppName + ".chipaca"})
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[0].Version(), Equals, "42")
+}
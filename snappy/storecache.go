@@ -0,0 +1,192 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+// storeCacheMaxAge is how long a cached response is used for, the
+// older ones are asked for again as if they were not cached
+const storeCacheMaxAge = 7 * 24 * time.Hour
+
+// cachedStoreResponse is a response of the store kept, with what
+// validates it, for the next request to only ask if it changed
+type cachedStoreResponse struct {
+	ETag         string      `json:"etag,omitempty"`
+	LastModified string      `json:"last-modified,omitempty"`
+	Header       http.Header `json:"header"`
+	Body         []byte      `json:"body"`
+	Saved        time.Time   `json:"saved"`
+}
+
+// oauthIdentity matches the consumer and token keys of an OAuth
+// Authorization header, which (unlike its nonce) are the same for all
+// the requests of a user
+var oauthIdentity = regexp.MustCompile(`oauth_(?:consumer_key|token)="[^"]*"`)
+
+// authIdentity returns who the Authorization header authenticates
+func authIdentity(authorization string) string {
+	if strings.HasPrefix(authorization, "OAuth ") {
+		return strings.Join(oauthIdentity.FindAllString(authorization, -1), ",")
+	}
+
+	return authorization
+}
+
+// storeCacheKey returns what the response to the request (with the
+// given body) is cached under: what the store answers depends on the
+// URL, the body, the X-Ubuntu headers and who the user is (the snaps
+// they bought or that are private to them). Only the identity in the
+// credentials is kept, their nonce changes with every request.
+func storeCacheKey(req *http.Request, body []byte) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", req.Method, req.URL)
+	fmt.Fprintf(h, "Authorization: %s\n", authIdentity(req.Header.Get("Authorization")))
+
+	var names []string
+	for name := range req.Header {
+		if strings.HasPrefix(name, "X-Ubuntu-") || name == "Accept" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(h, "%s: %s\n", name, strings.Join(req.Header[name], ","))
+	}
+	h.Write(body)
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// loadCachedStoreResponse returns the response cached under key, nil
+// if there is none or it expired (see storeCacheMaxAge)
+func loadCachedStoreResponse(key string) *cachedStoreResponse {
+	fn := filepath.Join(dirs.SnapStoreCacheDir, key)
+	content, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil
+	}
+
+	var cached cachedStoreResponse
+	if err := json.Unmarshal(content, &cached); err != nil {
+		return nil
+	}
+	if age := time.Since(cached.Saved); age < 0 || age > storeCacheMaxAge {
+		os.Remove(fn)
+		return nil
+	}
+
+	return &cached
+}
+
+// saveCachedStoreResponse caches the response under key, readable by
+// root only: it is what the store answered to the user
+func saveCachedStoreResponse(key string, cached *cachedStoreResponse) error {
+	cached.Saved = time.Now()
+	content, err := json.Marshal(cached)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dirs.SnapStoreCacheDir, 0700); err != nil {
+		return err
+	}
+	// the cache of older versions was readable by all
+	if err := os.Chmod(dirs.SnapStoreCacheDir, 0700); err != nil {
+		return err
+	}
+
+	return helpers.AtomicWriteFile(filepath.Join(dirs.SnapStoreCacheDir, key), content, 0600, 0)
+}
+
+// doCachedStoreRequest does the request like doStoreRequest, but as a
+// conditional request if the response to it is cached, returning the
+// cached response if the store says it was not modified. The responses
+// that say what validates them are cached.
+func doCachedStoreRequest(client *http.Client, req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	key := storeCacheKey(req, body)
+	cached := loadCachedStoreResponse(key)
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := doStoreRequest(client, req)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && cached != nil:
+		resp.Body.Close()
+		resp.StatusCode = http.StatusOK
+		resp.Status = "200 OK"
+		resp.Header = cached.Header
+		resp.ContentLength = int64(len(cached.Body))
+		resp.Body = ioutil.NopCloser(bytes.NewReader(cached.Body))
+	case resp.StatusCode == http.StatusOK && (resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != ""):
+		respBody, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+		// only root can cache, the others just do without
+		saveCachedStoreResponse(key, &cachedStoreResponse{
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			Header:       resp.Header,
+			Body:         respBody,
+		})
+	}
+
+	return resp, nil
+}
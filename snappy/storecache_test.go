@@ -0,0 +1,174 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+)
+
+// mockETagStore serves body with the given ETag, or "not modified" to
+// the requests that have it already
+func mockETagStore(etag, body *string, notModified *int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == *etag {
+			*notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", *etag)
+		io.WriteString(w, *body)
+	}))
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryDetailsConditional(c *C) {
+	etag, body := `"v1"`, MockDetailsJSON
+	var notModified int
+	mockServer := mockETagStore(&etag, &body, &notModified)
+	defer mockServer.Close()
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+	repo := NewUbuntuStoreSnapRepository()
+
+	for i := 0; i < 2; i++ {
+		results, err := repo.Details(funkyAppName, funkyAppOrigin)
+		c.Assert(err, IsNil)
+		c.Assert(results, HasLen, 1)
+		c.Check(results[0].Version(), Equals, "42")
+	}
+	c.Check(notModified, Equals, 1)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryUpdatesConditional(c *C) {
+	etag, body := `"v1"`, MockUpdatesJSON
+	var notModified int
+	mockServer := mockETagStore(&etag, &body, &notModified)
+	defer mockServer.Close()
+
+	var err error
+	storeBulkURI, err = url.Parse(mockServer.URL + "/updates/")
+	c.Assert(err, IsNil)
+	repo := NewUbuntuStoreSnapRepository()
+	mockActiveSnapIterByType([]string{funkyAppName})
+
+	results, err := repo.Updates()
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+
+	results, err = repo.Updates()
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(notModified, Equals, 1)
+
+	// a new version
+	etag, body = `"v2"`, strings.Replace(MockUpdatesJSON, `"42"`, `"43"`, 1)
+	results, err = repo.Updates()
+	c.Assert(err, IsNil)
+	c.Assert(results, HasLen, 1)
+	c.Check(results[0].Version(), Equals, "43")
+	c.Check(notModified, Equals, 1)
+}
+
+func (s *SnapTestSuite) TestDoCachedStoreRequestUncachable(c *C) {
+	var conditional bool
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional = conditional || r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != ""
+		io.WriteString(w, "hello")
+	}))
+	defer mockServer.Close()
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest("GET", mockServer.URL, nil)
+		c.Assert(err, IsNil)
+		resp, err := doCachedStoreRequest(&http.Client{}, req)
+		c.Assert(err, IsNil)
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		c.Assert(err, IsNil)
+		c.Check(string(body), Equals, "hello")
+	}
+	c.Check(conditional, Equals, false)
+}
+
+func (s *SnapTestSuite) TestStoreCacheKey(c *C) {
+	req1, err := http.NewRequest("GET", "http://store.example/details/foo", nil)
+	c.Assert(err, IsNil)
+	req1.Header.Set("X-Ubuntu-Architecture", "amd64")
+	req1.Header.Set("Authorization", `OAuth oauth_nonce="1", oauth_consumer_key="c", oauth_token="t"`)
+
+	req2, err := http.NewRequest("GET", "http://store.example/details/foo", nil)
+	c.Assert(err, IsNil)
+	req2.Header.Set("X-Ubuntu-Architecture", "amd64")
+	req2.Header.Set("Authorization", `OAuth oauth_nonce="2", oauth_consumer_key="c", oauth_token="t"`)
+	c.Check(storeCacheKey(req1, nil), Equals, storeCacheKey(req2, nil))
+
+	// another user
+	req2.Header.Set("Authorization", `OAuth oauth_nonce="2", oauth_consumer_key="c", oauth_token="u"`)
+	c.Check(storeCacheKey(req1, nil), Not(Equals), storeCacheKey(req2, nil))
+	req2.Header.Del("Authorization")
+	c.Check(storeCacheKey(req1, nil), Not(Equals), storeCacheKey(req2, nil))
+	req2.Header.Set("Authorization", req1.Header.Get("Authorization"))
+
+	req2.Header.Set("X-Ubuntu-Architecture", "armhf")
+	c.Check(storeCacheKey(req1, nil), Not(Equals), storeCacheKey(req2, nil))
+	c.Check(storeCacheKey(req1, nil), Not(Equals), storeCacheKey(req1, []byte("body")))
+}
+
+func (s *SnapTestSuite) TestStoreCacheRootOnly(c *C) {
+	c.Assert(saveCachedStoreResponse("key", &cachedStoreResponse{ETag: `"v1"`}), IsNil)
+
+	st, err := os.Stat(dirs.SnapStoreCacheDir)
+	c.Assert(err, IsNil)
+	c.Check(st.Mode().Perm(), Equals, os.FileMode(0700))
+	st, err = os.Stat(filepath.Join(dirs.SnapStoreCacheDir, "key"))
+	c.Assert(err, IsNil)
+	c.Check(st.Mode().Perm(), Equals, os.FileMode(0600))
+}
+
+func (s *SnapTestSuite) TestStoreCacheExpires(c *C) {
+	c.Assert(saveCachedStoreResponse("key", &cachedStoreResponse{ETag: `"v1"`}), IsNil)
+	c.Check(loadCachedStoreResponse("key"), NotNil)
+
+	fn := filepath.Join(dirs.SnapStoreCacheDir, "key")
+	content, err := json.Marshal(&cachedStoreResponse{
+		ETag:  `"v1"`,
+		Saved: time.Now().Add(-storeCacheMaxAge - time.Hour),
+	})
+	c.Assert(err, IsNil)
+	c.Assert(ioutil.WriteFile(fn, content, 0600), IsNil)
+
+	c.Check(loadCachedStoreResponse("key"), IsNil)
+	_, err = os.Stat(fn)
+	c.Check(os.IsNotExist(err), Equals, true)
+}
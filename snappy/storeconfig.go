@@ -0,0 +1,185 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/pkg/remote"
+)
+
+// StoreConfig is the system configuration of the store, for the
+// devices (and the developers) that talk to another store than the
+// default one without an oem snap to point them at it
+type StoreConfig struct {
+	// URL is the base URL of the store API, the search, package/,
+	// click-metadata and departments endpoints are relative to it
+	URL string `yaml:"url,omitempty"`
+	// ReviewsURL is the base URL of the reviews API
+	ReviewsURL string `yaml:"reviews-url,omitempty"`
+	// PurchasesURL is the URL the purchases are made at
+	PurchasesURL string `yaml:"purchases-url,omitempty"`
+	// ID is the branded store to ask for, if the oem snap has none
+	ID string `yaml:"id,omitempty"`
+	// Headers are sent with every request to the store API (not with
+	// the downloads), in addition to those snappy sets itself
+	Headers map[string]string `yaml:"headers,omitempty"`
+}
+
+var (
+	storeConfigMu     sync.Mutex
+	storeConfigLoaded bool
+	storeConfigCached *StoreConfig
+	storeConfigErr    error
+)
+
+// storeConfig returns the store configuration, read once from
+// dirs.SnapStoreConfigFile
+func storeConfig() (*StoreConfig, error) {
+	storeConfigMu.Lock()
+	defer storeConfigMu.Unlock()
+
+	if !storeConfigLoaded {
+		storeConfigCached, storeConfigErr = loadStoreConfig()
+		storeConfigLoaded = true
+	}
+
+	return storeConfigCached, storeConfigErr
+}
+
+// forgetStoreConfig makes the next storeConfig read it again
+func forgetStoreConfig() {
+	storeConfigMu.Lock()
+	defer storeConfigMu.Unlock()
+
+	storeConfigLoaded = false
+	storeConfigCached, storeConfigErr = nil, nil
+}
+
+// loadStoreConfig reads the store configuration in
+// dirs.SnapStoreConfigFile; there is nothing to configure if it does not
+// exist
+func loadStoreConfig() (*StoreConfig, error) {
+	content, err := ioutil.ReadFile(dirs.SnapStoreConfigFile)
+	if os.IsNotExist(err) {
+		return &StoreConfig{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config StoreConfig
+	if err := yaml.Unmarshal(content, &config); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+// storeBase returns the base URI of the store the requests go to: the
+// URL of the store configuration if there is one, storeBaseURI otherwise
+func storeBase() *url.URL {
+	if os.Getenv("SNAPPY_USE_STAGING_CPI") != "" {
+		return storeBaseURI
+	}
+
+	config, err := storeConfig()
+	if err != nil || config.URL == "" {
+		return storeBaseURI
+	}
+
+	base, err := url.Parse(config.URL)
+	if err != nil {
+		return storeBaseURI
+	}
+
+	return asDirURL(base)
+}
+
+// setStoreConfigHeaders sets the headers of the store configuration,
+// which are for the store API only
+func setStoreConfigHeaders(req *http.Request) {
+	if config, err := storeConfig(); err == nil {
+		for k, v := range config.Headers {
+			req.Header.Set(k, v)
+		}
+	}
+}
+
+// asDirURL returns u with a trailing slash, for the URLs relative to
+// it to be in it
+func asDirURL(u *url.URL) *url.URL {
+	if strings.HasSuffix(u.Path, "/") {
+		return u
+	}
+
+	dir := *u
+	dir.Path += "/"
+	return &dir
+}
+
+// storeURIs are the endpoints of a store API
+type storeURIs struct {
+	search      *url.URL
+	details     *url.URL
+	bulk        *url.URL
+	departments *url.URL
+	reviews     *url.URL
+	purchases   *url.URL
+}
+
+// newStoreURIs returns the endpoints of the store API at base
+func newStoreURIs(base *url.URL) (*storeURIs, error) {
+	var uris storeURIs
+	var err error
+
+	base = asDirURL(base)
+
+	v := url.Values{}
+	v.Set("fields", strings.Join(getStructFields(remote.Snap{}), ","))
+
+	if uris.search, err = base.Parse("search"); err != nil {
+		return nil, err
+	}
+	uris.search.RawQuery = v.Encode()
+
+	if uris.details, err = base.Parse("package/"); err != nil {
+		return nil, err
+	}
+
+	if uris.bulk, err = base.Parse("click-metadata"); err != nil {
+		return nil, err
+	}
+	uris.bulk.RawQuery = v.Encode()
+
+	if uris.departments, err = base.Parse("departments"); err != nil {
+		return nil, err
+	}
+
+	return &uris, nil
+}
@@ -0,0 +1,144 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+)
+
+func makeStoreConfig(c *C, content string) {
+	c.Assert(os.MkdirAll(filepath.Dir(dirs.SnapStoreConfigFile), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(dirs.SnapStoreConfigFile, []byte(content), 0644), IsNil)
+	forgetStoreConfig()
+}
+
+func (s *SnapTestSuite) TestStoreConfigMissing(c *C) {
+	config, err := storeConfig()
+	c.Assert(err, IsNil)
+	c.Check(config, DeepEquals, &StoreConfig{})
+}
+
+func (s *SnapTestSuite) TestStoreConfig(c *C) {
+	makeStoreConfig(c, `url: https://store.example.com/api/v1/
+id: my-store
+headers:
+  X-Test-Run: "42"
+`)
+
+	config, err := storeConfig()
+	c.Assert(err, IsNil)
+	c.Check(config, DeepEquals, &StoreConfig{
+		URL:     "https://store.example.com/api/v1/",
+		ID:      "my-store",
+		Headers: map[string]string{"X-Test-Run": "42"},
+	})
+}
+
+func (s *SnapTestSuite) TestStoreConfigInvalid(c *C) {
+	makeStoreConfig(c, "url: [")
+
+	_, err := storeConfig()
+	c.Check(err, NotNil)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryStoreConfigURL(c *C) {
+	makeStoreConfig(c, "url: https://store.example.com/api/v1\n")
+
+	repo := NewUbuntuStoreSnapRepository()
+	c.Assert(repo, NotNil)
+	c.Check(repo.detailsURI.String(), Equals, "https://store.example.com/api/v1/package/")
+	c.Check(repo.searchURI.Path, Equals, "/api/v1/search")
+	c.Check(repo.searchURI.Query().Get("fields"), Not(Equals), "")
+	c.Check(repo.bulkURI, Matches, `https://store\.example\.com/api/v1/click-metadata\?fields=.*`)
+	c.Check(repo.departmentsURI.String(), Equals, "https://store.example.com/api/v1/departments")
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryStagingOverridesStoreConfig(c *C) {
+	makeStoreConfig(c, "url: https://store.example.com/api/v1/\n")
+	os.Setenv("SNAPPY_USE_STAGING_CPI", "1")
+	defer os.Setenv("SNAPPY_USE_STAGING_CPI", "")
+
+	repo := NewUbuntuStoreSnapRepository()
+	c.Assert(repo, NotNil)
+	c.Check(repo.detailsURI, Equals, storeDetailsURI)
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryInvalidStoreConfig(c *C) {
+	makeStoreConfig(c, "url: \"%zz\"\n")
+
+	repo := NewUbuntuStoreSnapRepository()
+	c.Assert(repo, NotNil)
+	c.Check(repo.detailsURI, Equals, storeDetailsURI)
+}
+
+func (s *SnapTestSuite) TestStoreIDFromStoreConfig(c *C) {
+	makeStoreConfig(c, "id: my-store\n")
+
+	c.Check(StoreID(), Equals, "my-store")
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreHeadersStoreConfig(c *C) {
+	makeStoreConfig(c, `id: my-store
+headers:
+  X-Test-Run: "42"
+`)
+
+	req, err := http.NewRequest("GET", "http://example.com", nil)
+	c.Assert(err, IsNil)
+	setStoreConfigHeaders(req)
+	setUbuntuStoreHeaders(req, nil)
+
+	c.Check(req.Header.Get("X-Test-Run"), Equals, "42")
+	c.Check(req.Header.Get("X-Ubuntu-Store"), Equals, "my-store")
+
+	// the downloads do not get them
+	req, err = http.NewRequest("GET", "http://example.com", nil)
+	c.Assert(err, IsNil)
+	setUbuntuStoreHeaders(req, nil)
+	c.Check(req.Header.Get("X-Test-Run"), Equals, "")
+}
+
+func (s *SnapTestSuite) TestStoreConfigReadOnce(c *C) {
+	makeStoreConfig(c, "id: my-store\n")
+	c.Check(StoreID(), Equals, "my-store")
+
+	c.Assert(os.Remove(dirs.SnapStoreConfigFile), IsNil)
+	c.Check(StoreID(), Equals, "my-store")
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryStoreConfigReviewsPurchases(c *C) {
+	makeStoreConfig(c, `reviews-url: https://reviews.example.com/api/1.0
+purchases-url: https://pay.example.com/purchases/
+`)
+
+	repo := NewUbuntuStoreSnapRepository()
+	c.Assert(repo, NotNil)
+	c.Check(repo.reviewsURI.String(), Equals, "https://reviews.example.com/api/1.0/reviews/")
+	c.Check(repo.purchasesURI.String(), Equals, "https://pay.example.com/purchases/")
+	// the store API is the default one
+	c.Check(repo.detailsURI, Equals, storeDetailsURI)
+}
@@ -0,0 +1,76 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// storeErrorMaxSize is how much of an error reply of the store is read
+const storeErrorMaxSize = 64 * 1024
+
+// storeErrorReply is the body of the error replies of the store, like
+// {"errors": ["No such package"], "result": "error"}
+type storeErrorReply struct {
+	Errors []string `json:"errors"`
+	Result string   `json:"result"`
+	// Code is the cause of the error, for the stores that send one
+	Code string `json:"code"`
+}
+
+// the codes of storeErrorReply
+const (
+	storeErrorCountryBlacklisted = "country-blacklisted"
+	storeErrorAuthRequired       = "auth-required"
+	storeErrorNotFound           = "not-found"
+)
+
+// storeError returns the error the store replied to the request for
+// what with, nil if it did not fail. It is ErrPackageNotFound,
+// ErrAuthenticationNeeded, ErrCountryBlacklisted or *ErrStoreUnavailable if the
+// reply tells that was the cause, and *ErrStore otherwise
+func storeError(resp *http.Response, what string) error {
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+
+	var reply storeErrorReply
+	// not every error comes with a reply the store made (e.g. those
+	// of proxies), so it is fine if there is none
+	json.NewDecoder(io.LimitReader(resp.Body, storeErrorMaxSize)).Decode(&reply)
+
+	switch {
+	case reply.Code == storeErrorCountryBlacklisted || resp.StatusCode == http.StatusUnavailableForLegalReasons:
+		return ErrCountryBlacklisted
+	case reply.Code == storeErrorAuthRequired || resp.StatusCode == http.StatusUnauthorized:
+		return ErrAuthenticationNeeded
+	case reply.Code == storeErrorNotFound || resp.StatusCode == http.StatusNotFound:
+		return ErrPackageNotFound
+	}
+
+	err := &ErrStore{What: what, Status: resp.StatusCode, Messages: reply.Errors}
+	if resp.StatusCode >= 500 && resp.Request != nil {
+		return &ErrStoreUnavailable{URL: resp.Request.URL, Attempts: 1, Err: err}
+	}
+
+	return err
+}
@@ -0,0 +1,94 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *SnapTestSuite) TestStoreError(c *C) {
+	var status int
+	var body string
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+	defer mockServer.Close()
+
+	for _, t := range []struct {
+		status int
+		body   string
+		err    error
+	}{
+		{200, "{}", nil},
+		{404, MockNoDetailsJSON, ErrPackageNotFound},
+		{404, "", ErrPackageNotFound},
+		{403, `{"errors": ["not in your country"], "result": "error", "code": "country-blacklisted"}`, ErrCountryBlacklisted},
+		{451, "", ErrCountryBlacklisted},
+		{401, `{"errors": ["expired macaroon"], "result": "error"}`, ErrAuthenticationNeeded},
+		{403, `{"errors": ["login first"], "result": "error", "code": "auth-required"}`, ErrAuthenticationNeeded},
+		{400, `{"errors": ["bad series", "bad arch"], "result": "error"}`, &ErrStore{What: "foo", Status: 400, Messages: []string{"bad series", "bad arch"}}},
+		{400, "<html>proxy says no</html>", &ErrStore{What: "foo", Status: 400}},
+	} {
+		status, body = t.status, t.body
+
+		resp, err := http.Get(mockServer.URL)
+		c.Assert(err, IsNil)
+		c.Check(storeError(resp, "foo"), DeepEquals, t.err, Commentf("%d %s", t.status, t.body))
+		resp.Body.Close()
+	}
+}
+
+func (s *SnapTestSuite) TestStoreErrorUnavailable(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(503)
+		io.WriteString(w, `{"errors": ["down for maintenance"], "result": "error"}`)
+	}))
+	defer mockServer.Close()
+
+	resp, err := http.Get(mockServer.URL)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+
+	err = storeError(resp, "foo")
+	c.Assert(err, FitsTypeOf, &ErrStoreUnavailable{})
+	c.Check(err.(*ErrStoreUnavailable).Err, DeepEquals, &ErrStore{What: "foo", Status: 503, Messages: []string{"down for maintenance"}})
+	c.Check(err, ErrorMatches, ".*unexpected http statusCode 503 for foo: down for maintenance")
+}
+
+func (s *SnapTestSuite) TestUbuntuStoreRepositoryDetailsCountryBlacklisted(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(403)
+		io.WriteString(w, `{"errors": ["not in your country"], "result": "error", "code": "country-blacklisted"}`)
+	}))
+	defer mockServer.Close()
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	_, err = NewUbuntuStoreSnapRepository().Details("xkcd-webserver", "canonical")
+	c.Check(err, Equals, ErrCountryBlacklisted)
+}
@@ -0,0 +1,201 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+// the environment variables that turn on recording the store requests
+// to a directory, and replaying them from one (instead of talking to
+// the store), to reproduce store dependent problems offline
+const (
+	storeRecordEnv = "SNAPPY_STORE_RECORD"
+	storeReplayEnv = "SNAPPY_STORE_REPLAY"
+)
+
+// recordedExchange is a store request and its response, as recorded
+type recordedExchange struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	RequestBody []byte      `json:"request-body,omitempty"`
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header,omitempty"`
+	Body        []byte      `json:"body"`
+}
+
+// storeRecorder is an http.RoundTripper that records the exchanges
+// with the store in dir, or replays them from there. The same request
+// made several times gets the responses in the order they were
+// recorded (the last one once they run out).
+type storeRecorder struct {
+	dir    string
+	replay bool
+	rt     http.RoundTripper
+
+	mu   sync.Mutex
+	seen map[string]int
+}
+
+var (
+	storeRecordersMu sync.Mutex
+	storeRecorders   = make(map[string]*storeRecorder)
+)
+
+// storeTransport returns the transport for the store requests, which
+// records or replays them if the environment asks for that (nil, for
+// the default one, otherwise)
+func storeTransport() http.RoundTripper {
+	dir, replay := os.Getenv(storeRecordEnv), false
+	if replayDir := os.Getenv(storeReplayEnv); replayDir != "" {
+		dir, replay = replayDir, true
+	}
+	if dir == "" {
+		return nil
+	}
+
+	// the requests are made with a client each, the order of the
+	// recorded requests is kept across them
+	storeRecordersMu.Lock()
+	defer storeRecordersMu.Unlock()
+
+	key := fmt.Sprintf("%v:%s", replay, dir)
+	if r, ok := storeRecorders[key]; ok {
+		return r
+	}
+	r := &storeRecorder{dir: dir, replay: replay, rt: http.DefaultTransport, seen: make(map[string]int)}
+	storeRecorders[key] = r
+
+	return r
+}
+
+// exchangeFile returns the file of the nth exchange with the given
+// request
+func (r *storeRecorder) exchangeFile(req *http.Request, body []byte, n int) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s %s\n", req.Method, req.URL)
+	h.Write(body)
+
+	return filepath.Join(r.dir, fmt.Sprintf("%s-%d.json", hex.EncodeToString(h.Sum(nil))[:16], n))
+}
+
+// next returns the file of the next exchange with the given request
+func (r *storeRecorder) next(req *http.Request, body []byte) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := r.exchangeFile(req, body, 0)
+	n := r.seen[key]
+	fn := r.exchangeFile(req, body, n)
+	if r.replay && n > 0 && !helpers.FileExists(fn) {
+		// ran out, keep replaying the last one
+		return r.exchangeFile(req, body, n-1)
+	}
+	r.seen[key] = n + 1
+
+	return fn
+}
+
+func (r *storeRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	fn := r.next(req, body)
+
+	if r.replay {
+		return r.replayExchange(req, fn)
+	}
+
+	resp, err := r.rt.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	respBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+
+	content, err := json.MarshalIndent(recordedExchange{
+		Method:      req.Method,
+		URL:         req.URL.String(),
+		RequestBody: body,
+		Status:      resp.StatusCode,
+		Header:      resp.Header,
+		Body:        respBody,
+	}, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		return nil, err
+	}
+	if err := helpers.AtomicWriteFile(fn, content, 0600, 0); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (r *storeRecorder) replayExchange(req *http.Request, fn string) (*http.Response, error) {
+	content, err := ioutil.ReadFile(fn)
+	if os.IsNotExist(err) {
+		return nil, &ErrNotRecorded{Method: req.Method, URL: req.URL.String()}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var exchange recordedExchange
+	if err := json.Unmarshal(content, &exchange); err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", exchange.Status, http.StatusText(exchange.Status)),
+		StatusCode:    exchange.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        exchange.Header,
+		Body:          ioutil.NopCloser(bytes.NewReader(exchange.Body)),
+		ContentLength: int64(len(exchange.Body)),
+		Request:       req,
+	}, nil
+}
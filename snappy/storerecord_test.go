@@ -0,0 +1,112 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *SnapTestSuite) TestStoreRecordReplay(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, MockDetailsJSON)
+	}))
+	c.Assert(mockServer, NotNil)
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	dir := filepath.Join(c.MkDir(), "recorded")
+	os.Setenv(storeRecordEnv, dir)
+	defer os.Unsetenv(storeRecordEnv)
+
+	recorded, err := NewUbuntuStoreSnapRepository().Details(funkyAppName, funkyAppOrigin)
+	c.Assert(err, IsNil)
+	c.Assert(recorded, HasLen, 1)
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	c.Assert(err, IsNil)
+	c.Check(files, HasLen, 1)
+
+	// the store is gone, the recording is still there
+	mockServer.Close()
+	os.Unsetenv(storeRecordEnv)
+	os.Setenv(storeReplayEnv, dir)
+	defer os.Unsetenv(storeReplayEnv)
+
+	replayed, err := NewUbuntuStoreSnapRepository().Details(funkyAppName, funkyAppOrigin)
+	c.Assert(err, IsNil)
+	c.Assert(replayed, HasLen, 1)
+	c.Check(replayed[0].Version(), Equals, recorded[0].Version())
+	c.Check(replayed[0].Hash(), Equals, recorded[0].Hash())
+}
+
+func (s *SnapTestSuite) TestStoreRecordReplayOrder(c *C) {
+	n := 0
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n++
+		fmt.Fprintf(w, "response %d", n)
+	}))
+	defer mockServer.Close()
+
+	dir := c.MkDir()
+	get := func(rt http.RoundTripper) string {
+		req, err := http.NewRequest("GET", mockServer.URL+"/foo", nil)
+		c.Assert(err, IsNil)
+		resp, err := rt.RoundTrip(req)
+		c.Assert(err, IsNil)
+		defer resp.Body.Close()
+		body := make([]byte, 64)
+		l, _ := io.ReadFull(resp.Body, body)
+		return string(body[:l])
+	}
+
+	recorder := &storeRecorder{dir: dir, rt: http.DefaultTransport, seen: make(map[string]int)}
+	c.Check(get(recorder), Equals, "response 1")
+	c.Check(get(recorder), Equals, "response 2")
+
+	replayer := &storeRecorder{dir: dir, replay: true, seen: make(map[string]int)}
+	c.Check(get(replayer), Equals, "response 1")
+	c.Check(get(replayer), Equals, "response 2")
+	// the last one again once they run out
+	c.Check(get(replayer), Equals, "response 2")
+	c.Check(n, Equals, 2)
+}
+
+func (s *SnapTestSuite) TestStoreReplayNotRecorded(c *C) {
+	replayer := &storeRecorder{dir: c.MkDir(), replay: true, seen: make(map[string]int)}
+
+	req, err := http.NewRequest("GET", "http://example.com/foo", nil)
+	c.Assert(err, IsNil)
+	_, err = replayer.RoundTrip(req)
+	c.Check(err, ErrorMatches, "no recorded response for GET http://example.com/foo")
+}
+
+func (s *SnapTestSuite) TestStoreTransportDefault(c *C) {
+	c.Check(storeTransport(), IsNil)
+}
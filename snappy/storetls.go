@@ -0,0 +1,141 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// StoreTLS is how the https connections to the store (and the downloads
+// of its snaps) are verified, for the branded stores behind a TLS
+// interception proxy
+type StoreTLS struct {
+	// CABundle is the path of a bundle of PEM CA certificates to
+	// verify the store (and the downloads) with instead of the CAs of
+	// the system
+	CABundle string
+	// Fingerprints pin the certificates of the store API: the chain
+	// the store presents must have one whose SHA-256 is in them (in
+	// hex, colons and case do not matter). The downloads, served from
+	// other hosts, are not pinned.
+	Fingerprints []string
+}
+
+// storeTLSConfig is the tls.Config of a StoreTLS
+type storeTLSConfig struct {
+	config *tls.Config
+	// key identifies the StoreTLS, for the connections to be shared
+	key string
+	// downloads is the storeTLSConfig of the downloads: the same,
+	// without the pins
+	downloads *storeTLSConfig
+}
+
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.Replace(fingerprint, ":", "", -1))
+}
+
+// newStoreTLSConfig loads the StoreTLS (nil if it is)
+func newStoreTLSConfig(st *StoreTLS) (*storeTLSConfig, error) {
+	if st == nil || (st.CABundle == "" && len(st.Fingerprints) == 0) {
+		return nil, nil
+	}
+
+	config := &tls.Config{}
+	if st.CABundle != "" {
+		pem, err := ioutil.ReadFile(st.CABundle)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in %s", st.CABundle)
+		}
+	}
+
+	pinned := make(map[string]bool, len(st.Fingerprints))
+	fingerprints := make([]string, 0, len(st.Fingerprints))
+	for _, fingerprint := range st.Fingerprints {
+		fingerprint = normalizeFingerprint(fingerprint)
+		if b, err := hex.DecodeString(fingerprint); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("invalid certificate fingerprint %q", fingerprint)
+		}
+		pinned[fingerprint] = true
+		fingerprints = append(fingerprints, fingerprint)
+	}
+	if len(pinned) > 0 {
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			for _, raw := range rawCerts {
+				sum := sha256.Sum256(raw)
+				if pinned[hex.EncodeToString(sum[:])] {
+					return nil
+				}
+			}
+			return ErrCertificateNotPinned
+		}
+	}
+	sort.Strings(fingerprints)
+
+	tlsConfig := &storeTLSConfig{
+		config: config,
+		key:    st.CABundle + "|" + strings.Join(fingerprints, ","),
+	}
+	switch {
+	case len(pinned) == 0:
+		tlsConfig.downloads = tlsConfig
+	case st.CABundle != "":
+		tlsConfig.downloads = &storeTLSConfig{
+			config: &tls.Config{RootCAs: config.RootCAs},
+			key:    st.CABundle + "|",
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// forDownloads returns the storeTLSConfig of the downloads of the snaps
+// of the store, nil for the CAs of the system
+func (c *storeTLSConfig) forDownloads() *storeTLSConfig {
+	if c == nil {
+		return nil
+	}
+
+	return c.downloads
+}
+
+// SetTLS makes the https connections to the store, and the downloads of
+// the snaps it returns, verified as the given StoreTLS says (nil goes
+// back to the CAs of the system)
+func (s *SnapUbuntuStoreRepository) SetTLS(st *StoreTLS) error {
+	tlsConfig, err := newStoreTLSConfig(st)
+	if err != nil {
+		return err
+	}
+	s.tlsConfig = tlsConfig
+
+	return nil
+}
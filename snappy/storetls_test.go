@@ -0,0 +1,124 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	. "gopkg.in/check.v1"
+)
+
+// mockTLSStore serves the details of a snap over https, and writes its
+// CA to a bundle
+func mockTLSStore(c *C) (*httptest.Server, string) {
+	mockServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"origin": "foo", "package_name": "bar", "version": "1.0"}`)
+	}))
+
+	bundle := filepath.Join(c.MkDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: mockServer.Certificate().Raw})
+	c.Assert(ioutil.WriteFile(bundle, cert, 0644), IsNil)
+
+	var err error
+	storeDetailsURI, err = url.Parse(mockServer.URL + "/details/")
+	c.Assert(err, IsNil)
+
+	return mockServer, bundle
+}
+
+func fingerprint(raw []byte) string {
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *SnapTestSuite) TestStoreTLSCABundle(c *C) {
+	mockServer, bundle := mockTLSStore(c)
+	defer mockServer.Close()
+
+	repo := NewUbuntuStoreSnapRepository()
+	_, err := repo.Details("bar", "foo")
+	c.Assert(err, NotNil)
+
+	c.Assert(repo.SetTLS(&StoreTLS{CABundle: bundle}), IsNil)
+	parts, err := repo.Details("bar", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 1)
+	// and so do the downloads
+	c.Check(parts[0].(*RemoteSnapPart).tlsConfig, Equals, repo.tlsConfig)
+}
+
+func (s *SnapTestSuite) TestStoreTLSPinned(c *C) {
+	mockServer, bundle := mockTLSStore(c)
+	defer mockServer.Close()
+
+	// colons and case do not matter
+	pin := strings.ToUpper(fingerprint(mockServer.Certificate().Raw))
+	pin = pin[:2] + ":" + pin[2:]
+
+	repo := NewUbuntuStoreSnapRepository()
+	c.Assert(repo.SetTLS(&StoreTLS{CABundle: bundle, Fingerprints: []string{fingerprint([]byte("other")), pin}}), IsNil)
+	parts, err := repo.Details("bar", "foo")
+	c.Assert(err, IsNil)
+	c.Assert(parts, HasLen, 1)
+
+	// the downloads are not pinned, they come from other hosts
+	downloads := parts[0].(*RemoteSnapPart).tlsConfig
+	c.Assert(downloads, NotNil)
+	c.Check(downloads.config.VerifyPeerCertificate, IsNil)
+	c.Check(downloads.config.RootCAs, Equals, repo.tlsConfig.config.RootCAs)
+
+	// nor do they need a CA bundle
+	c.Assert(repo.SetTLS(&StoreTLS{Fingerprints: []string{pin}}), IsNil)
+	c.Check(repo.tlsConfig.forDownloads(), IsNil)
+}
+
+func (s *SnapTestSuite) TestStoreTLSNotPinned(c *C) {
+	mockServer, bundle := mockTLSStore(c)
+	defer mockServer.Close()
+
+	repo := NewUbuntuStoreSnapRepository()
+	c.Assert(repo.SetTLS(&StoreTLS{CABundle: bundle, Fingerprints: []string{fingerprint([]byte("other"))}}), IsNil)
+	_, err := repo.Details("bar", "foo")
+	c.Assert(err, ErrorMatches, ".*"+ErrCertificateNotPinned.Error())
+}
+
+func (s *SnapTestSuite) TestStoreTLSInvalid(c *C) {
+	repo := NewUbuntuStoreSnapRepository()
+
+	c.Check(repo.SetTLS(&StoreTLS{Fingerprints: []string{"12:34"}}), ErrorMatches, `invalid certificate fingerprint "1234"`)
+
+	bundle := filepath.Join(c.MkDir(), "ca.pem")
+	c.Assert(ioutil.WriteFile(bundle, []byte("nope"), 0644), IsNil)
+	c.Check(repo.SetTLS(&StoreTLS{CABundle: bundle}), ErrorMatches, "no certificates in .*")
+
+	c.Check(repo.SetTLS(&StoreTLS{CABundle: bundle + ".missing"}), NotNil)
+
+	c.Assert(repo.SetTLS(nil), IsNil)
+	c.Check(repo.tlsConfig, IsNil)
+}
@@ -0,0 +1,128 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"time"
+
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/pkg"
+)
+
+// SystemSummary is an overview of the snaps on the system, for
+// dashboards
+type SystemSummary struct {
+	// ByType is the number of installed snaps (not versions) of
+	// each type
+	ByType map[pkg.Type]int
+	// ActiveVersions and InactiveVersions count the installed
+	// versions of all snaps
+	ActiveVersions   int
+	InactiveVersions int
+	// InstalledSize is the size of the active versions, TotalSize
+	// that of all the installed versions (in bytes)
+	InstalledSize int64
+	TotalSize     int64
+	// PendingUpdates are the updates the last successful update
+	// check found that are not installed yet, by qualified name
+	PendingUpdates map[string]string
+	// LastUpdateCheck is when the store was last asked for updates
+	// successfully, LastUpdate when a snap was last updated
+	// successfully (zero if never)
+	LastUpdateCheck time.Time
+	LastUpdate      time.Time
+	// RebootRequired is true if an update only takes effect on the
+	// next boot
+	RebootRequired bool
+}
+
+// Summary returns the SystemSummary; it only looks at what is on the
+// disk (the store is not asked, the pending updates are those of the
+// last update check), what can not be read is left out
+func Summary() SystemSummary {
+	summary := SystemSummary{
+		ByType:         make(map[pkg.Type]int),
+		PendingUpdates: make(map[string]string),
+	}
+
+	installed, err := NewMetaLocalRepository().Installed()
+	if err != nil {
+		logger.Noticef("Failed to get the installed snaps for the summary: %v", err)
+	}
+
+	snaps := make(map[string]bool)
+	versions := make(map[string]map[string]bool)
+	for _, part := range installed {
+		name := QualifiedName(part)
+		if !snaps[name] {
+			snaps[name] = true
+			summary.ByType[part.Type()]++
+		}
+		if versions[name] == nil {
+			versions[name] = make(map[string]bool)
+		}
+		versions[name][part.Version()] = true
+
+		size := part.InstalledSize()
+		if size > 0 {
+			summary.TotalSize += size
+		}
+		if part.IsActive() {
+			summary.ActiveVersions++
+			if size > 0 {
+				summary.InstalledSize += size
+			}
+		} else {
+			summary.InactiveVersions++
+		}
+		if part.NeedsReboot() {
+			summary.RebootRequired = true
+		}
+	}
+
+	checks, err := readUpdateCheckHistory()
+	if err != nil {
+		logger.Noticef("Failed to read the update checks for the summary: %v", err)
+	}
+	for i := len(checks) - 1; i >= 0; i-- {
+		if checks[i].Error != "" {
+			continue
+		}
+		summary.LastUpdateCheck = checks[i].Last
+		for name, version := range checks[i].Available {
+			if !versions[name][version] {
+				summary.PendingUpdates[name] = version
+			}
+		}
+		break
+	}
+
+	history, err := readHistory()
+	if err != nil {
+		logger.Noticef("Failed to read the history for the summary: %v", err)
+	}
+	for _, entry := range history {
+		if entry.Op == historyUpdate && entry.Error == "" && entry.Time.After(summary.LastUpdate) {
+			summary.LastUpdate = entry.Time
+		}
+	}
+
+	return summary
+}
@@ -0,0 +1,81 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/pkg"
+	"github.com/ubuntu-core/snappy/pkg/remote"
+)
+
+func (s *SnapTestSuite) TestSummaryEmpty(c *C) {
+	summary := Summary()
+	c.Check(summary.ActiveVersions, Equals, 0)
+	c.Check(summary.InactiveVersions, Equals, 0)
+	c.Check(summary.PendingUpdates, HasLen, 0)
+	c.Check(summary.LastUpdate.IsZero(), Equals, true)
+	c.Check(summary.LastUpdateCheck.IsZero(), Equals, true)
+	c.Check(summary.RebootRequired, Equals, false)
+}
+
+func (s *SnapTestSuite) TestSummary(c *C) {
+	for _, version := range []string{"1.0", "2.0"} {
+		yamlFile, err := makeInstalledMockSnap(s.tempdir, "name: foo\nversion: "+version+"\nvendor: foo\n")
+		c.Assert(err, IsNil)
+		if version == "2.0" {
+			c.Assert(makeSnapActive(yamlFile), IsNil)
+		}
+	}
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, "name: fmk\nversion: 1\nvendor: foo\ntype: framework\n")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlFile), IsNil)
+
+	fooName := "foo." + testOrigin
+	available := []Part{
+		// already installed since
+		&RemoteSnapPart{pkg: remote.Snap{Name: "foo", Origin: testOrigin, Version: "2.0"}},
+		&RemoteSnapPart{pkg: remote.Snap{Name: "fmk", Origin: testOrigin, Version: "2"}},
+	}
+	c.Assert(appendUpdateCheck(available, nil), IsNil)
+	// a failed check does not tell what is pending
+	updateCheckCollapseInterval = 0
+	defer func() { updateCheckCollapseInterval = time.Hour }()
+	c.Assert(appendUpdateCheck(nil, errors.New("offline")), IsNil)
+
+	before := time.Now().Add(-time.Second)
+	recordOperation(historyUpdate, fooName, "2.0", nil, nil)
+	recordOperation(historyUpdate, "fmk."+testOrigin, "2", errors.New("boom"), nil)
+
+	summary := Summary()
+	c.Check(summary.ByType[pkg.TypeApp], Equals, 1)
+	c.Check(summary.ByType[pkg.TypeFramework], Equals, 1)
+	c.Check(summary.ActiveVersions, Equals, 2)
+	c.Check(summary.InactiveVersions, Equals, 1)
+	c.Check(summary.TotalSize > summary.InstalledSize, Equals, true)
+	c.Check(summary.InstalledSize > 0, Equals, true)
+	c.Check(summary.PendingUpdates, DeepEquals, map[string]string{"fmk." + testOrigin: "2"})
+	c.Check(summary.LastUpdateCheck.After(before), Equals, true)
+	c.Check(summary.LastUpdate.After(before), Equals, true)
+	c.Check(summary.RebootRequired, Equals, false)
+}
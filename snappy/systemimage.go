@@ -196,6 +196,11 @@ func (s *SystemImagePart) Install(pb progress.Meter, flags InstallFlags) (name s
 		return "", ErrSideLoaded
 	}
 
+	// the system image can not be checked without applying it
+	if (flags & DryRun) != 0 {
+		return s.Name(), nil
+	}
+
 	if pb != nil {
 		// ensure the progress finishes when we are done
 		defer func() {
@@ -207,7 +212,7 @@ func (s *SystemImagePart) Install(pb progress.Meter, flags InstallFlags) (name s
 	// if the update does not provide new versions.
 	if s.needsBootAssetSync() {
 		if pb != nil {
-			pb.Notify("Syncing boot files")
+			progress.NotifyMessage(pb, progress.NewMessage(progress.MsgSyncingBootFiles))
 		}
 		err = s.partition.SyncBootloaderFiles(bootAssetFilePaths())
 		if err != nil {
@@ -245,7 +250,7 @@ func (s *SystemImagePart) Install(pb progress.Meter, flags InstallFlags) (name s
 	//      sync mounted /boot/uboot, so its very slow, tell the user
 	//      at least that something is going on
 	if pb != nil {
-		pb.Notify("Updating boot files")
+		progress.NotifyMessage(pb, progress.NewMessage(progress.MsgUpdatingBootFiles))
 	}
 	if err = s.partition.ToggleNextBoot(); err != nil {
 		return "", err
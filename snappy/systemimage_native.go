@@ -170,7 +170,7 @@ func parseSIProgress(pb progress.Meter, stdout io.Reader) error {
 		}
 	}
 	// ugly: avoid Spin() artifacts
-	pb.Notify("\nApply done")
+	progress.NotifyMessage(pb, progress.NewMessage(progress.MsgSystemImageApplyDone))
 
 	if err := scanner.Err(); err != nil {
 		return err
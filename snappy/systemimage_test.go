@@ -147,6 +147,8 @@ type MockPartition struct {
 	toggleNextBoot            bool
 	markBootSuccessfulCalled  bool
 	syncBootloaderFilesCalled bool
+	bootloaderName            string
+	bootVars                  map[string]string
 }
 
 func (p *MockPartition) ToggleNextBoot() error {
@@ -166,6 +168,12 @@ func (p *MockPartition) IsNextBootOther() bool {
 	return p.toggleNextBoot
 }
 
+func (p *MockPartition) SetBootVars(bootloaderName string, vars map[string]string) error {
+	p.bootloaderName = bootloaderName
+	p.bootVars = vars
+	return nil
+}
+
 func (p *MockPartition) RunWithOther(option partition.MountOption, f func(otherRoot string) (err error)) (err error) {
 	return f("/other")
 }
@@ -0,0 +1,122 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/priv"
+)
+
+// the system lock serializes the operations that change the installed
+// snaps (installs, removals, (de)activations and config writes) across
+// processes. It is taken again and again as operations nest (an
+// install activating the snap, say), so within the process it is only
+// counted.
+var (
+	systemLockMu    sync.Mutex
+	systemLockDepth int
+	systemLockFile  *os.File
+)
+
+// lockSystem takes the system lock, waiting for it if blocking (and
+// failing with priv.ErrAlreadyLocked otherwise) when another process
+// holds it. The returned func gives it back.
+func lockSystem(blocking bool) (unlock func(), err error) {
+	systemLockMu.Lock()
+	defer systemLockMu.Unlock()
+
+	if systemLockDepth == 0 {
+		if err := os.MkdirAll(filepath.Dir(dirs.SnapSystemLock), 0755); err != nil {
+			return nil, err
+		}
+		// the file is never removed: someone waiting on it would
+		// get the lock of a file no one else can see anymore
+		f, err := os.OpenFile(dirs.SnapSystemLock, os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, err
+		}
+
+		how := syscall.LOCK_EX
+		if !blocking {
+			how |= syscall.LOCK_NB
+		}
+		if err := syscall.Flock(int(f.Fd()), how); err != nil {
+			f.Close()
+			if err == syscall.EWOULDBLOCK {
+				return nil, priv.ErrAlreadyLocked
+			}
+			return nil, err
+		}
+		systemLockFile = f
+	}
+	systemLockDepth++
+
+	return unlockSystem, nil
+}
+
+func unlockSystem() {
+	systemLockMu.Lock()
+	defer systemLockMu.Unlock()
+
+	systemLockDepth--
+	if systemLockDepth == 0 {
+		// closing the file drops the lock
+		systemLockFile.Close()
+		systemLockFile = nil
+	}
+}
+
+// installRemoveMutex serializes the installs and removals within the
+// process, which the (counted) system lock does not
+var installRemoveMutex sync.Mutex
+
+// lockInstallRemove takes the system lock for an install or removal,
+// and keeps the other installs and removals of the process out too,
+// so that the framework reference checks (Dependents) hold until the
+// operation is done.
+func lockInstallRemove() (unlock func(), err error) {
+	unlockSys, err := lockSystem(true)
+	if err != nil {
+		return nil, err
+	}
+	installRemoveMutex.Lock()
+
+	return func() {
+		installRemoveMutex.Unlock()
+		unlockSys()
+	}, nil
+}
+
+// WithSystemLock runs f holding the system lock, or returns
+// priv.ErrAlreadyLocked straight away if another process holds it
+func WithSystemLock(f func() error) error {
+	unlock, err := lockSystem(false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return f()
+}
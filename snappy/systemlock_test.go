@@ -0,0 +1,99 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"os"
+	"syscall"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/priv"
+)
+
+// lockFromElsewhere takes the system lock the way another process
+// would, returning the func to give it back
+func lockFromElsewhere(c *C) func() {
+	unlock, err := lockSystem(true)
+	c.Assert(err, IsNil)
+	unlock()
+
+	f, err := os.OpenFile(dirs.SnapSystemLock, os.O_WRONLY, 0600)
+	c.Assert(err, IsNil)
+	c.Assert(syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB), IsNil)
+
+	return func() { f.Close() }
+}
+
+func (s *SnapTestSuite) TestSystemLockNests(c *C) {
+	err := WithSystemLock(func() error {
+		unlock, err := lockSystem(true)
+		c.Assert(err, IsNil)
+		unlock()
+
+		c.Check(systemLockDepth, Equals, 1)
+		return nil
+	})
+	c.Assert(err, IsNil)
+	c.Check(systemLockDepth, Equals, 0)
+	c.Check(systemLockFile, IsNil)
+
+	// and it is free for others again
+	lockFromElsewhere(c)()
+}
+
+func (s *SnapTestSuite) TestSystemLockHeldElsewhere(c *C) {
+	unlock := lockFromElsewhere(c)
+
+	called := false
+	err := WithSystemLock(func() error {
+		called = true
+		return nil
+	})
+	c.Check(err, Equals, priv.ErrAlreadyLocked)
+	c.Check(called, Equals, false)
+	c.Check(systemLockDepth, Equals, 0)
+
+	unlock()
+	c.Check(WithSystemLock(func() error { return nil }), IsNil)
+}
+
+func (s *SnapTestSuite) TestSetActiveWaitsForSystemLock(c *C) {
+	part := s.activeHelloApp(c, "1.10")
+	unlock := lockFromElsewhere(c)
+
+	done := make(chan error)
+	go func() {
+		done <- part.SetActive(false, &MockProgressMeter{})
+	}()
+
+	select {
+	case <-done:
+		c.Fatal("SetActive did not wait for the system lock")
+	case <-time.After(50 * time.Millisecond):
+	}
+	c.Check(ActiveSnapByName("hello-app"), NotNil)
+
+	unlock()
+	c.Assert(<-done, IsNil)
+	c.Check(ActiveSnapByName("hello-app"), IsNil)
+}
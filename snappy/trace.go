@@ -0,0 +1,338 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// the kinds of TraceEvents
+const (
+	TraceExec = "exec"
+	TraceHTTP = "http"
+	TraceFile = "file"
+)
+
+// TraceEvent is a single thing an operation did to the system
+type TraceEvent struct {
+	Time time.Time `yaml:"time"`
+	// Kind is one of TraceExec, TraceHTTP or TraceFile
+	Kind   string `yaml:"kind"`
+	Detail string `yaml:"detail"`
+}
+
+// Trace is what an operation run with the Debug option did: the
+// commands it ran, the requests it sent and the files it changed
+type Trace struct {
+	ID     string       `yaml:"id"`
+	Events []TraceEvent `yaml:"events"`
+
+	mu     sync.Mutex
+	before generatedFiles
+}
+
+// tracedGlobs are the files whose changes are traced, the generated
+// files and the snaps themselves
+var tracedGlobs = append([]generatedGlob{
+	{"snap", func() string { return filepath.Join(dirs.SnapAppsDir, "*", "*") }, nil},
+	{"snap", func() string { return filepath.Join(dirs.SnapOemDir, "*", "*") }, nil},
+	{"data", func() string { return filepath.Join(dirs.SnapDataDir, "*", "*") }, nil},
+}, generatedGlobs...)
+
+func tracesDir() string {
+	return filepath.Join(dirs.GlobalRootDir, dirs.SnappyDir, "traces")
+}
+
+func traceFile(id string) string {
+	return filepath.Join(tracesDir(), id+".yaml")
+}
+
+// newOperationID returns a new (unique enough) ID for an operation
+func newOperationID() string {
+	return strconv.FormatInt(time.Now().UnixNano(), 36)
+}
+
+// newTrace starts the trace of an operation
+func newTrace() *Trace {
+	t := &Trace{ID: newOperationID()}
+
+	before, err := collectGeneratedFiles(tracedGlobs)
+	if err != nil {
+		logger.Noticef("Failed to snapshot the files before the operation: %v", err)
+	}
+	t.before = before
+
+	return t
+}
+
+// add records an event, it does nothing on a nil Trace so the
+// operations need not check if they are traced
+func (t *Trace) add(kind, format string, args ...interface{}) {
+	if t == nil {
+		return
+	}
+
+	detail := fmt.Sprintf(format, args...)
+	logger.Debugf("%s: %s", kind, detail)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.Events = append(t.Events, TraceEvent{
+		Time:   time.Now().UTC(),
+		Kind:   kind,
+		Detail: detail,
+	})
+}
+
+// id returns the ID of the trace ("" on a nil Trace)
+func (t *Trace) id() string {
+	if t == nil {
+		return ""
+	}
+
+	return t.ID
+}
+
+// addFileChanges records the files that changed since the trace started
+func (t *Trace) addFileChanges() error {
+	after, err := collectGeneratedFiles(tracedGlobs)
+	if err != nil {
+		return err
+	}
+
+	// the globs overlap (e.g. the wrappers are in the apps dir)
+	index := func(files generatedFiles) map[string]*generatedFile {
+		m := make(map[string]*generatedFile, len(files))
+		for _, f := range files {
+			m[f.Path] = f
+		}
+		return m
+	}
+	old := index(t.before)
+	cur := index(after)
+
+	for _, f := range after {
+		o, ok := old[f.Path]
+		switch {
+		case cur[f.Path] != f:
+			// a duplicate
+		case !ok:
+			t.add(TraceFile, "created %s", f.Path)
+		case o.Sha512 != f.Sha512 || o.Target != f.Target || o.Mode.mode != f.Mode.mode:
+			t.add(TraceFile, "modified %s", f.Path)
+		}
+	}
+	for _, f := range t.before {
+		if _, ok := cur[f.Path]; !ok && old[f.Path] == f {
+			t.add(TraceFile, "removed %s", f.Path)
+		}
+	}
+
+	return nil
+}
+
+// finish records the file changes and saves the trace so that
+// OperationTrace finds it, it does nothing on a nil Trace
+func (t *Trace) finish(meter progress.Meter) {
+	if t == nil {
+		return
+	}
+
+	if err := t.addFileChanges(); err != nil {
+		logger.Noticef("Failed to trace the changed files: %v", err)
+	}
+
+	t.mu.Lock()
+	content, err := yaml.Marshal(t)
+	t.mu.Unlock()
+	if err == nil {
+		if err = os.MkdirAll(tracesDir(), 0755); err == nil {
+			err = helpers.AtomicWriteFile(traceFile(t.ID), content, 0644, 0)
+		}
+	}
+	if err != nil {
+		logger.Noticef("Failed to save the trace %s: %v", t.ID, err)
+		return
+	}
+
+	progress.NotifyMessage(meter, progress.NewMessage(progress.MsgTraceRecorded, t.ID))
+}
+
+// OperationTrace returns the trace of the operation with the given ID
+// (the ID of its Change), if it was run with the Debug option
+func OperationTrace(opID string) (*Trace, error) {
+	if opID == "" || strings.ContainsRune(opID, os.PathSeparator) {
+		return nil, ErrTraceNotFound
+	}
+
+	history, err := readHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	traceID := ""
+	for _, entry := range history {
+		if entry.ID == opID {
+			traceID = entry.Trace
+			break
+		}
+	}
+	if traceID == "" {
+		return nil, ErrTraceNotFound
+	}
+
+	content, err := ioutil.ReadFile(traceFile(traceID))
+	if os.IsNotExist(err) {
+		return nil, ErrTraceNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var t Trace
+	if err := yaml.Unmarshal(content, &t); err != nil {
+		return nil, err
+	}
+
+	return &t, nil
+}
+
+// tracingTransport is a http.RoundTripper that traces the requests
+type tracingTransport struct {
+	trace *Trace
+	rt    http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil {
+		t.trace.add(TraceHTTP, "%s %s: %v", req.Method, req.URL, err)
+		return nil, err
+	}
+	t.trace.add(TraceHTTP, "%s %s: %s (%d bytes)", req.Method, req.URL, resp.Status, resp.ContentLength)
+
+	return resp, nil
+}
+
+// httpClient returns the client for the requests of a traced operation
+// (a plain one, for the store, on a nil Trace), through the given proxy
+// and verifying the https connections with the given storeTLSConfig, if
+// any
+func (t *Trace) httpClient(timeout time.Duration, proxy *url.URL, tlsConfig *storeTLSConfig) *http.Client {
+	client := &http.Client{Timeout: timeout, Transport: storeTransport()}
+	if client.Transport == nil && (proxy != nil || tlsConfig != nil) {
+		client.Transport = proxyTransport(proxy, tlsConfig)
+	}
+	if t != nil {
+		rt := client.Transport
+		if rt == nil {
+			rt = http.DefaultTransport
+		}
+		client.Transport = &tracingTransport{trace: t, rt: rt}
+	}
+
+	return client
+}
+
+// tracingBackend is a Backend that traces the commands it runs
+type tracingBackend struct {
+	Backend
+	trace *Trace
+}
+
+func (b *tracingBackend) traceExec(tool string, args []string, err error) {
+	cmd := strings.Join(append([]string{tool}, args...), " ")
+	if err != nil {
+		b.trace.add(TraceExec, "%s: %v", cmd, err)
+		return
+	}
+	b.trace.add(TraceExec, "%s", cmd)
+}
+
+func (b *tracingBackend) Systemctl(args ...string) ([]byte, error) {
+	output, err := b.Backend.Systemctl(args...)
+	b.traceExec("systemctl", args, err)
+	return output, err
+}
+
+func (b *tracingBackend) UdevAdm(args ...string) ([]byte, error) {
+	output, err := b.Backend.UdevAdm(args...)
+	b.traceExec("udevadm", args, err)
+	return output, err
+}
+
+func (b *tracingBackend) RunUdevAdm(args ...string) error {
+	err := b.Backend.RunUdevAdm(args...)
+	b.traceExec("udevadm", args, err)
+	return err
+}
+
+func (b *tracingBackend) AaClickHook(args ...string) ([]byte, error) {
+	output, err := b.Backend.AaClickHook(args...)
+	b.traceExec("aa-clickhook", args, err)
+	return output, err
+}
+
+func (b *tracingBackend) ScFilterGen(args ...string) ([]byte, error) {
+	output, err := b.Backend.ScFilterGen(args...)
+	b.traceExec("sc-filtergen", args, err)
+	return output, err
+}
+
+func (b *tracingBackend) UpdateTimestamp(path string) error {
+	err := b.Backend.UpdateTimestamp(path)
+	b.traceExec("touch", []string{path}, err)
+	return err
+}
+
+func (b *tracingBackend) RunMACCmd(argv ...string) error {
+	err := b.Backend.RunMACCmd(argv...)
+	b.traceExec(argv[0], argv[1:], err)
+	return err
+}
+
+func (b *tracingBackend) RunSelfTest(cmd *exec.Cmd, timeout time.Duration) ([]byte, error) {
+	output, err := b.Backend.RunSelfTest(cmd, timeout)
+	b.traceExec(cmd.Path, cmd.Args[1:], err)
+	return output, err
+}
+
+func (b *tracingBackend) RunHook(hook, appArmorProfile string, env []string) error {
+	err := b.Backend.RunHook(hook, appArmorProfile, env)
+	b.traceExec(aaExec, []string{"-p", appArmorProfile, hook}, err)
+	return err
+}
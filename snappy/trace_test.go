@@ -0,0 +1,139 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+)
+
+func (s *SnapTestSuite) TestNilTraceIsNoop(c *C) {
+	var t *Trace
+	t.add(TraceExec, "true")
+	t.finish(&MockProgressMeter{})
+	c.Check(t.id(), Equals, "")
+	c.Check(t.httpClient(0, nil, nil).Transport, IsNil)
+}
+
+func (s *SnapTestSuite) TestTracingBackend(c *C) {
+	s.backend.udevAdm = func(args ...string) ([]byte, error) {
+		return nil, errors.New("boom")
+	}
+	t := newTrace()
+	backend := &tracingBackend{Backend: s.backend, trace: t}
+
+	_, err := backend.Systemctl("daemon-reload")
+	c.Check(err, IsNil)
+	_, err = backend.UdevAdm("trigger")
+	c.Check(err, ErrorMatches, "boom")
+
+	c.Assert(t.Events, HasLen, 2)
+	c.Check(t.Events[0].Kind, Equals, TraceExec)
+	c.Check(t.Events[0].Detail, Equals, "systemctl daemon-reload")
+	c.Check(t.Events[1].Detail, Equals, "udevadm trigger: boom")
+}
+
+func (s *SnapTestSuite) TestTraceFileChanges(c *C) {
+	c.Assert(os.MkdirAll(dirs.SnapBinariesDir, 0755), IsNil)
+	removed := filepath.Join(dirs.SnapBinariesDir, "foo.removed")
+	modified := filepath.Join(dirs.SnapBinariesDir, "foo.modified")
+	c.Assert(ioutil.WriteFile(removed, nil, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(modified, nil, 0755), IsNil)
+
+	t := newTrace()
+	c.Assert(os.Remove(removed), IsNil)
+	c.Assert(ioutil.WriteFile(modified, []byte("new"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(dirs.SnapBinariesDir, "foo.created"), nil, 0755), IsNil)
+	c.Assert(t.addFileChanges(), IsNil)
+
+	var details []string
+	for _, ev := range t.Events {
+		c.Check(ev.Kind, Equals, TraceFile)
+		details = append(details, ev.Detail)
+	}
+	c.Check(details, DeepEquals, []string{
+		"created /apps/bin/foo.created",
+		"modified /apps/bin/foo.modified",
+		"removed /apps/bin/foo.removed",
+	})
+}
+
+func (s *SnapTestSuite) TestInstallDebugRecordsTrace(c *C) {
+	mockServer := mockStoreWithDate(c, 0, 404)
+	defer mockServer.Close()
+
+	meter := &MockProgressMeter{}
+	_, err := InstallWithOptions("foo", InstallOptions{Meter: meter, Debug: true})
+	c.Assert(err, NotNil)
+	c.Assert(meter.notified, HasLen, 1)
+	c.Check(meter.notified[0], Matches, "Recorded the trace .*")
+
+	history, err := readHistory()
+	c.Assert(err, IsNil)
+	c.Assert(history, HasLen, 1)
+	c.Check(history[0].Trace, Not(Equals), "")
+
+	t, err := OperationTrace(history[0].ID)
+	c.Assert(err, IsNil)
+	c.Check(t.ID, Equals, history[0].Trace)
+	c.Assert(len(t.Events) > 0, Equals, true)
+	c.Check(t.Events[0].Kind, Equals, TraceHTTP)
+	c.Check(t.Events[0].Detail, Matches, "GET "+mockServer.URL+"/details/foo.*: 404 Not Found .*")
+}
+
+func (s *SnapTestSuite) TestInstallWithoutDebugRecordsNoTrace(c *C) {
+	mockServer := mockStoreWithDate(c, 0, 404)
+	defer mockServer.Close()
+
+	_, err := InstallWithOptions("foo", InstallOptions{})
+	c.Assert(err, NotNil)
+
+	history, err := readHistory()
+	c.Assert(err, IsNil)
+	c.Assert(history, HasLen, 1)
+	c.Check(history[0].Trace, Equals, "")
+
+	_, err = OperationTrace(history[0].ID)
+	c.Check(err, Equals, ErrTraceNotFound)
+	_, err = OperationTrace("no-such-op")
+	c.Check(err, Equals, ErrTraceNotFound)
+}
+
+func (s *SnapTestSuite) TestRecordOperationTrimsTraces(c *C) {
+	defer func(n int) { historyMaxEntries = n }(historyMaxEntries)
+	historyMaxEntries = 1
+
+	t := newTrace()
+	t.finish(&MockProgressMeter{})
+	_, err := os.Stat(traceFile(t.ID))
+	c.Assert(err, IsNil)
+
+	recordOperation(historyInstall, "a.b", "1", nil, t)
+	recordOperation(historyInstall, "c.d", "1", nil, nil)
+
+	_, err = os.Stat(traceFile(t.ID))
+	c.Check(os.IsNotExist(err), Equals, true)
+}
@@ -0,0 +1,58 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"github.com/ubuntu-core/snappy/logger"
+)
+
+// transaction records the steps of an operation, to undo those that
+// were done (the last one first) if a later one fails
+type transaction struct {
+	// op is the operation, for the logs
+	op    string
+	undos []undoStep
+}
+
+type undoStep struct {
+	what string
+	undo func() error
+}
+
+// do does the step what, and records undo for rollback. The undo is
+// recorded even if the step fails, as it may have been half done, so
+// undo has to cope with that
+func (t *transaction) do(what string, step, undo func() error) error {
+	t.undos = append(t.undos, undoStep{what: what, undo: undo})
+
+	return step()
+}
+
+// rollback undoes the steps, the last one first; the failures are
+// logged, the other steps are undone anyway
+func (t *transaction) rollback() {
+	for i := len(t.undos) - 1; i >= 0; i-- {
+		step := t.undos[i]
+		if err := step.undo(); err != nil {
+			logger.Noticef("When undoing %s of %s: %v", step.what, t.op, err)
+		}
+	}
+	t.undos = nil
+}
@@ -0,0 +1,126 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"errors"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+)
+
+func (s *SnapTestSuite) TestTransactionRollbackUndoesInReverse(c *C) {
+	var undone []string
+	undo := func(what string, err error) func() error {
+		return func() error {
+			undone = append(undone, what)
+			return err
+		}
+	}
+
+	tx := &transaction{op: "the test"}
+	c.Assert(tx.do("one", func() error { return nil }, undo("one", nil)), IsNil)
+	c.Assert(tx.do("two", func() error { return nil }, undo("two", errors.New("meh"))), IsNil)
+	c.Assert(tx.do("three", func() error { return errors.New("failed") }, undo("three", nil)), ErrorMatches, "failed")
+
+	tx.rollback()
+	// the failed step is undone too, and a failing undo does not
+	// stop the others
+	c.Check(undone, DeepEquals, []string{"three", "two", "one"})
+
+	// and there is nothing left to undo
+	tx.rollback()
+	c.Check(undone, HasLen, 3)
+}
+
+func (s *SnapTestSuite) TestActivateFailedServiceRollsBack(c *C) {
+	var disabled []string
+	s.backend.systemctl = func(cmd ...string) ([]byte, error) {
+		switch cmd[0] {
+		case "--root":
+			disabled = append(disabled, cmd[3])
+		case "start":
+			return nil, errors.New("start failed")
+		case "show":
+			return []byte("ActiveState=inactive\n"), nil
+		}
+		return nil, nil
+	}
+
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	part, err := NewInstalledSnapPart(yamlFile, testOrigin)
+	c.Assert(err, IsNil)
+
+	c.Assert(part.activate(false, s.meter()), ErrorMatches, "start failed")
+
+	// nothing of the half done activation is left behind
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapAppsDir, "hello-app."+testOrigin, "current")), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapBinariesDir, "hello-app.hello")), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapServicesDir, "hello-app_svc1_1.10.service")), Equals, false)
+	c.Check(disabled, DeepEquals, []string{"hello-app_svc1_1.10.service"})
+}
+
+func (s *SnapTestSuite) TestActivateFailedServiceRestoresOldVersion(c *C) {
+	yamlFile, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	oldPart, err := NewInstalledSnapPart(yamlFile, testOrigin)
+	c.Assert(err, IsNil)
+	c.Assert(oldPart.activate(true, s.meter()), IsNil)
+
+	yamlFile, err = makeInstalledMockSnap(s.tempdir, `name: hello-app
+version: 2.0
+vendor: Foo Bar <foo@example.com>
+services:
+ - name: svc1
+   start: bin/hello
+`)
+	c.Assert(err, IsNil)
+	part, err := NewInstalledSnapPart(yamlFile, testOrigin)
+	c.Assert(err, IsNil)
+
+	var started []string
+	s.backend.systemctl = func(cmd ...string) ([]byte, error) {
+		switch cmd[0] {
+		case "start":
+			if cmd[1] == "hello-app_svc1_2.0.service" {
+				return nil, errors.New("start failed")
+			}
+			started = append(started, cmd[1])
+		case "show":
+			return []byte("ActiveState=inactive\n"), nil
+		}
+		return nil, nil
+	}
+
+	c.Assert(part.activate(false, s.meter()), ErrorMatches, "start failed")
+
+	// the old version is active again, and its service back up
+	current, err := filepath.EvalSymlinks(filepath.Join(dirs.SnapAppsDir, "hello-app."+testOrigin, "current"))
+	c.Assert(err, IsNil)
+	c.Check(current, Equals, oldPart.basedir)
+	c.Check(started, DeepEquals, []string{"hello-app_svc1_1.10.service"})
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapBinariesDir, "hello-app.hello")), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapServicesDir, "hello-app_svc1_1.10.service")), Equals, true)
+	c.Check(helpers.FileExists(filepath.Join(dirs.SnapServicesDir, "hello-app_svc1_2.0.service")), Equals, false)
+}
@@ -0,0 +1,251 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// Transport fetches the snaps and icons of the store from the URLs of the
+// scheme it is registered for, so that other distribution backends (like
+// BitTorrent or IPFS) can be used. The snaps are verified against the hash
+// from the store whatever the transport.
+type Transport interface {
+	// Fetch writes the content of the URL to w, reporting the progress
+	// to the (optional) meter
+	Fetch(name string, u *url.URL, w io.Writer, pbar progress.Meter) error
+}
+
+var (
+	transportsMu sync.Mutex
+	transports   = make(map[string]Transport)
+)
+
+// RegisterTransport registers the Transport for the given URL scheme; a
+// nil transport unregisters it. The http and https schemes use the
+// built-in transport unless a transport is registered for them.
+func RegisterTransport(scheme string, t Transport) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+
+	if t == nil {
+		delete(transports, scheme)
+		return
+	}
+	transports[scheme] = t
+}
+
+// httpTransport is the built-in Transport for http and https
+type httpTransport struct {
+	// client does the download (it has the timeout, and traces the
+	// request if the operation is traced)
+	client *http.Client
+	// storeHeaders are sent if set (they include the credentials, so
+	// they must not be sent to anyone but the store)
+	storeHeaders bool
+	// auth authenticates the requests with store headers
+	auth Authenticator
+	// ctx stops the download when done (if not nil)
+	ctx context.Context
+}
+
+func (t *httpTransport) Fetch(name string, u *url.URL, w io.Writer, pbar progress.Meter) error {
+	req, err := newStoreRequest(t.ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if t.storeHeaders {
+		setUbuntuStoreHeaders(req, t.auth)
+	}
+
+	return download(name, w, req, t.client, pbar)
+}
+
+// resume fetches the content of the URL from where w ends, or from the
+// start (replacing the content of w) if the server can not resume
+func (t *httpTransport) resume(name string, u *url.URL, w *os.File, pbar progress.Meter) error {
+	offset, err := w.Seek(0, os.SEEK_END)
+	if err != nil {
+		return err
+	}
+
+	req, err := newStoreRequest(t.ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if t.storeHeaders {
+		setUbuntuStoreHeaders(req, t.auth)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := doStoreRequest(t.client, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && strings.HasPrefix(resp.Header.Get("Content-Range"), fmt.Sprintf("bytes %d-", offset)):
+		// resuming
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable && offset > 0:
+		// what w has is not (the start of) what the server has,
+		// start over
+		resp.Body.Close()
+		if err := truncateFile(w); err != nil {
+			return err
+		}
+		return t.resume(name, u, w, pbar)
+	case resp.StatusCode == http.StatusOK:
+		// the server sends it all
+		offset = 0
+		if err := truncateFile(w); err != nil {
+			return err
+		}
+	default:
+		return &ErrDownload{Code: resp.StatusCode, URL: req.URL}
+	}
+
+	return copyWithMeter(name, w, resp.Body, offset, resp.ContentLength, pbar)
+}
+
+// fileTransport is the built-in Transport for the file scheme, that of
+// the snaps of a SnapDirRepository
+type fileTransport struct{}
+
+func (fileTransport) Fetch(name string, u *url.URL, w io.Writer, pbar progress.Meter) error {
+	r, err := os.Open(u.Path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	fi, err := r.Stat()
+	if err != nil {
+		return err
+	}
+
+	return copyWithMeter(name, w, r, 0, fi.Size(), pbar)
+}
+
+// transportFor returns the transport for the scheme of the URL; files
+// are only fetched if localFiles is set (for the snaps of a
+// SnapDirRepository), never for the URLs the store (or a mirror) gives,
+// as root would copy whatever file they point to
+func transportFor(ctx context.Context, u *url.URL, client *http.Client, storeHeaders bool, auth Authenticator, localFiles bool) (Transport, error) {
+	if u.Scheme == "file" && !localFiles {
+		return nil, fmt.Errorf("refusing to fetch %q, only the snaps of a directory can come from files", u)
+	}
+
+	transportsMu.Lock()
+	t, ok := transports[u.Scheme]
+	transportsMu.Unlock()
+	if ok {
+		return t, nil
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		return &httpTransport{client: client, storeHeaders: storeHeaders, auth: auth, ctx: ctx}, nil
+	case "file":
+		return fileTransport{}, nil
+	}
+
+	return nil, fmt.Errorf("no transport for %q", u)
+}
+
+// isStoreDownloadHost tells whether the URL is on the download host of
+// the store itself (that of the download URLs of the snap); the mirrors
+// are third parties, they get neither the credentials nor the other
+// store headers
+func (s *RemoteSnapPart) isStoreDownloadHost(u *url.URL) bool {
+	for _, rawurl := range []string{s.pkg.DownloadURL, s.pkg.AnonDownloadURL} {
+		if rawurl == "" {
+			continue
+		}
+		if su, err := url.Parse(rawurl); err == nil && su.Scheme == u.Scheme && su.Host == u.Host {
+			return true
+		}
+	}
+
+	return false
+}
+
+// transportFor returns the transport for the given URL of the snap,
+// with the store headers (if asked for) only for the store itself
+func (s *RemoteSnapPart) transportFor(u *url.URL, storeHeaders bool) (Transport, error) {
+	client := s.trace.httpClient(s.timeout, s.proxy, s.tlsConfig)
+	if !storeHeaders || !s.isStoreDownloadHost(u) {
+		return transportFor(s.ctx, u, client, false, nil, s.localFiles)
+	}
+
+	return transportFor(s.ctx, u, client, true, s.auth, s.localFiles)
+}
+
+// fetch writes the content of the given URL to w using the transport
+// for its scheme
+func (s *RemoteSnapPart) fetch(name, rawurl string, storeHeaders bool, w io.Writer, pbar progress.Meter) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+
+	t, err := s.transportFor(u, storeHeaders)
+	if err != nil {
+		return err
+	}
+
+	return t.Fetch(name, u, w, pbar)
+}
+
+// fetchResuming writes the content of the given URL to w, resuming from
+// where w ends if the transport for the scheme of the URL can (the
+// built-in http one), replacing the content of w otherwise
+func (s *RemoteSnapPart) fetchResuming(name, rawurl string, w *os.File, pbar progress.Meter) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+
+	t, err := s.transportFor(u, true)
+	if err != nil {
+		return err
+	}
+
+	if t, ok := t.(*httpTransport); ok {
+		return t.resume(name, u, w, pbar)
+	}
+
+	if err := truncateFile(w); err != nil {
+		return err
+	}
+
+	return t.Fetch(name, u, w, pbar)
+}
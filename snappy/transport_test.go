@@ -0,0 +1,339 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+type fakeTransport struct {
+	content map[string]string
+	fetched []string
+}
+
+func (t *fakeTransport) Fetch(name string, u *url.URL, w io.Writer, pbar progress.Meter) error {
+	t.fetched = append(t.fetched, u.String())
+	content, ok := t.content[u.String()]
+	if !ok {
+		return fmt.Errorf("no such blob %s", u)
+	}
+	_, err := io.WriteString(w, content)
+
+	return err
+}
+
+func (s *SnapTestSuite) TestRemoteSnapInstallViaTransport(c *C) {
+	snapPackage := makeTestSnapPackage(c, "")
+	content, err := ioutil.ReadFile(snapPackage)
+	c.Assert(err, IsNil)
+
+	t := &fakeTransport{content: map[string]string{
+		"fake:snap": string(content),
+		"fake:icon": "icon",
+	}}
+	RegisterTransport("fake", t)
+	defer RegisterTransport("fake", nil)
+
+	snap := RemoteSnapPart{}
+	snap.pkg.AnonDownloadURL = "fake:snap"
+	snap.pkg.IconURL = "fake:icon"
+	snap.pkg.Name = "foo"
+	snap.pkg.Origin = "bar"
+	snap.pkg.Version = "1.0"
+	snap.pkg.DownloadSha512, err = helpers.Sha512sum(snapPackage)
+	c.Assert(err, IsNil)
+
+	name, err := snap.Install(&MockProgressMeter{}, 0)
+	c.Assert(err, IsNil)
+	c.Check(name, Equals, "foo")
+	c.Check(t.fetched, DeepEquals, []string{"fake:snap", "fake:icon"})
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadUnknownScheme(c *C) {
+	snap := RemoteSnapPart{}
+	snap.pkg.AnonDownloadURL = "nosuch://snap"
+
+	_, err := snap.Download(&MockProgressMeter{})
+	c.Check(err, ErrorMatches, `no transport for "nosuch://snap"`)
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadRefusesFiles(c *C) {
+	secret := filepath.Join(s.tempdir, "secret")
+	c.Assert(ioutil.WriteFile(secret, []byte("secret"), 0600), IsNil)
+
+	snap := RemoteSnapPart{}
+	snap.pkg.Name = "foo"
+	snap.pkg.AnonDownloadURL = fileURL(secret)
+	snap.pkg.IconURL = fileURL(secret)
+
+	_, err := snap.Download(&MockProgressMeter{})
+	c.Check(err, ErrorMatches, `refusing to fetch "file://.*", only the snaps of a directory can come from files`)
+	c.Check(snap.downloadIcon(&MockProgressMeter{}), NotNil)
+	c.Check(helpers.FileExists(iconPath(&snap)), Equals, false)
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadHashMismatch(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "not the snap")
+	}))
+	c.Assert(mockServer, NotNil)
+	defer mockServer.Close()
+
+	snap := RemoteSnapPart{}
+	snap.pkg.Name = "foo"
+	snap.pkg.AnonDownloadURL = mockServer.URL + "/snap"
+	snap.pkg.DownloadSha512 = "1234"
+
+	fn, err := snap.Download(&MockProgressMeter{})
+	c.Assert(err, FitsTypeOf, &ErrHashMismatch{})
+	c.Check(err.(*ErrHashMismatch).Expected, Equals, "1234")
+	c.Check(fn, Equals, "")
+}
+
+func (s *SnapTestSuite) TestRemoteSnapAllowUnauthenticated(c *C) {
+	snap := RemoteSnapPart{}
+	c.Check(snap.AllowUnauthenticated(), Equals, true)
+
+	allow := false
+	snap.pkg.AllowUnauthenticated = &allow
+	c.Check(snap.AllowUnauthenticated(), Equals, false)
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadNeedsAuthentication(c *C) {
+	os.Setenv("HOME", s.tempdir)
+
+	t := &fakeTransport{}
+	RegisterTransport("fake", t)
+	defer RegisterTransport("fake", nil)
+
+	allow := false
+	snap := RemoteSnapPart{}
+	snap.pkg.Name = "foo"
+	snap.pkg.AnonDownloadURL = "fake:anon"
+	snap.pkg.DownloadURL = "fake:snap"
+	snap.pkg.AllowUnauthenticated = &allow
+
+	_, err := snap.Download(&MockProgressMeter{})
+	c.Check(err, Equals, ErrAuthenticationNeeded)
+	c.Check(t.fetched, HasLen, 0)
+
+	// with credentials the authenticated url is used
+	c.Assert(WriteStoreToken(StoreToken{TokenName: "meep"}), IsNil)
+	c.Check(snap.downloadURLs(), DeepEquals, []string{"fake:snap"})
+}
+
+const resumableSnap = "this is a snap that takes a while to download"
+
+// mockResumableStore serves resumableSnap, failing halfway through the
+// first fails downloads; it records the ranges it is asked for
+func mockResumableStore(fails int, ranges *[]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*ranges = append(*ranges, r.Header.Get("Range"))
+		if fails > 0 {
+			fails--
+			w.Header().Set("Content-Length", fmt.Sprint(len(resumableSnap)))
+			io.WriteString(w, resumableSnap[:20])
+			return
+		}
+		http.ServeContent(w, r, "snap", time.Time{}, strings.NewReader(resumableSnap))
+	}))
+}
+
+func (s *SnapTestSuite) resumableSnapPart(url string) *RemoteSnapPart {
+	snap := &RemoteSnapPart{}
+	snap.pkg.Name = "foo"
+	snap.pkg.AnonDownloadURL = url
+	snap.pkg.DownloadSize = int64(len(resumableSnap))
+	snap.pkg.DownloadSha512 = sha512sum(resumableSnap)
+
+	return snap
+}
+
+func sha512sum(s string) string {
+	h := sha512.Sum512([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadResumes(c *C) {
+	var ranges []string
+	mockServer := mockResumableStore(1, &ranges)
+	defer mockServer.Close()
+
+	snap := s.resumableSnapPart(mockServer.URL + "/snap")
+	partial := filepath.Join(dirs.SnapDownloadsDir, snap.pkg.DownloadSha512+partialSuffix)
+
+	_, err := snap.Download(&MockProgressMeter{})
+	c.Assert(err, NotNil)
+	content, err := ioutil.ReadFile(partial)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, resumableSnap[:20])
+
+	meter := &MockProgressMeter{}
+	fn, err := snap.Download(meter)
+	c.Assert(err, IsNil)
+	defer os.Remove(fn)
+	c.Check(ranges, DeepEquals, []string{"", "bytes=20-"})
+	c.Check(meter.total, Equals, float64(len(resumableSnap)))
+
+	content, err = ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, resumableSnap)
+	c.Check(fn, Equals, strings.TrimSuffix(partial, partialSuffix))
+	c.Check(helpers.FileExists(partial), Equals, false)
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadResumeNotSupported(c *C) {
+	mockServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, resumableSnap)
+	}))
+	defer mockServer.Close()
+
+	snap := s.resumableSnapPart(mockServer.URL + "/snap")
+	c.Assert(os.MkdirAll(dirs.SnapDownloadsDir, 0700), IsNil)
+	partial := filepath.Join(dirs.SnapDownloadsDir, snap.pkg.DownloadSha512+partialSuffix)
+	c.Assert(ioutil.WriteFile(partial, []byte(resumableSnap[:20]), 0600), IsNil)
+
+	fn, err := snap.Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	defer os.Remove(fn)
+
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, resumableSnap)
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadAlreadyComplete(c *C) {
+	var ranges []string
+	mockServer := mockResumableStore(0, &ranges)
+	defer mockServer.Close()
+
+	snap := s.resumableSnapPart(mockServer.URL + "/snap")
+	c.Assert(os.MkdirAll(dirs.SnapDownloadsDir, 0700), IsNil)
+	partial := filepath.Join(dirs.SnapDownloadsDir, snap.pkg.DownloadSha512+partialSuffix)
+	c.Assert(ioutil.WriteFile(partial, []byte(resumableSnap), 0600), IsNil)
+
+	fn, err := snap.Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	defer os.Remove(fn)
+	c.Check(ranges, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadHashMismatchDropsPartial(c *C) {
+	var ranges []string
+	mockServer := mockResumableStore(0, &ranges)
+	defer mockServer.Close()
+
+	snap := s.resumableSnapPart(mockServer.URL + "/snap")
+	c.Assert(os.MkdirAll(dirs.SnapDownloadsDir, 0700), IsNil)
+	partial := filepath.Join(dirs.SnapDownloadsDir, snap.pkg.DownloadSha512+partialSuffix)
+	c.Assert(ioutil.WriteFile(partial, []byte("garbage garbage garbage"), 0600), IsNil)
+
+	_, err := snap.Download(&MockProgressMeter{})
+	c.Assert(err, FitsTypeOf, &ErrHashMismatch{})
+	c.Check(helpers.FileExists(partial), Equals, false)
+
+	// so the next try starts over
+	fn, err := snap.Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	defer os.Remove(fn)
+	c.Check(ranges, DeepEquals, []string{"bytes=23-", ""})
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadPartialTooLong(c *C) {
+	var ranges []string
+	mockServer := mockResumableStore(0, &ranges)
+	defer mockServer.Close()
+
+	snap := s.resumableSnapPart(mockServer.URL + "/snap")
+	c.Assert(os.MkdirAll(dirs.SnapDownloadsDir, 0700), IsNil)
+	partial := filepath.Join(dirs.SnapDownloadsDir, snap.pkg.DownloadSha512+partialSuffix)
+	c.Assert(ioutil.WriteFile(partial, []byte(resumableSnap+"garbage"), 0600), IsNil)
+
+	fn, err := snap.Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	defer os.Remove(fn)
+	c.Check(ranges, DeepEquals, []string{""})
+
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, resumableSnap)
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadRangeNotSatisfiable(c *C) {
+	var ranges []string
+	mockServer := mockResumableStore(0, &ranges)
+	defer mockServer.Close()
+
+	// the store does not say how big it is
+	snap := s.resumableSnapPart(mockServer.URL + "/snap")
+	snap.pkg.DownloadSize = 0
+	c.Assert(os.MkdirAll(dirs.SnapDownloadsDir, 0700), IsNil)
+	partial := filepath.Join(dirs.SnapDownloadsDir, snap.pkg.DownloadSha512+partialSuffix)
+	c.Assert(ioutil.WriteFile(partial, []byte(resumableSnap+"garbage"), 0600), IsNil)
+
+	fn, err := snap.Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	defer os.Remove(fn)
+	c.Check(ranges, DeepEquals, []string{fmt.Sprintf("bytes=%d-", len(resumableSnap)+7), ""})
+
+	content, err := ioutil.ReadFile(fn)
+	c.Assert(err, IsNil)
+	c.Check(string(content), Equals, resumableSnap)
+}
+
+func (s *SnapTestSuite) TestRemoteSnapDownloadPrunesStalePartials(c *C) {
+	var ranges []string
+	mockServer := mockResumableStore(0, &ranges)
+	defer mockServer.Close()
+
+	c.Assert(os.MkdirAll(dirs.SnapDownloadsDir, 0700), IsNil)
+	stale := filepath.Join(dirs.SnapDownloadsDir, sha512sum("gone")+partialSuffix)
+	recent := filepath.Join(dirs.SnapDownloadsDir, sha512sum("other")+partialSuffix)
+	for _, fn := range []string{stale, recent} {
+		c.Assert(ioutil.WriteFile(fn, []byte("part"), 0600), IsNil)
+	}
+	old := time.Now().Add(-partialMaxAge - time.Hour)
+	c.Assert(os.Chtimes(stale, old, old), IsNil)
+
+	snap := s.resumableSnapPart(mockServer.URL + "/snap")
+	fn, err := snap.Download(&MockProgressMeter{})
+	c.Assert(err, IsNil)
+	defer os.Remove(fn)
+
+	c.Check(helpers.FileExists(stale), Equals, false)
+	c.Check(helpers.FileExists(recent), Equals, true)
+}
@@ -0,0 +1,336 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/progress"
+)
+
+// TrashRetention is how long removed snaps are kept in the trash
+// before PurgeExpiredTrash deletes them for good
+var TrashRetention = 30 * 24 * time.Hour
+
+// the name of the file that describes a trash entry
+const trashInfoFile = "trash.yaml"
+
+// TrashPending is the state of a trash entry whose removal is under
+// way, or failed half way
+const TrashPending = "pending"
+
+// TrashedSnap describes a removed snap that is kept in the trash
+type TrashedSnap struct {
+	Name    string `yaml:"name"`
+	Origin  string `yaml:"origin,omitempty"`
+	Version string `yaml:"version"`
+	// Basedir is where the snap was installed
+	Basedir string `yaml:"basedir"`
+	// Active is true if the snap was active when it got removed
+	Active bool `yaml:"active,omitempty"`
+	// DataDirs are the original data directories kept in the trash
+	DataDirs []string `yaml:"data-dirs,omitempty"`
+	// Removed is the time of the removal (in seconds since the epoch)
+	Removed int64 `yaml:"removed"`
+	// State is TrashPending until the snap is all in the trash
+	State string `yaml:"state,omitempty"`
+
+	// the trash entry directory
+	dir string
+}
+
+// RemovedAt returns the time the snap got removed
+func (t *TrashedSnap) RemovedAt() time.Time {
+	return time.Unix(t.Removed, 0)
+}
+
+func (t *TrashedSnap) snapDir() string {
+	return filepath.Join(t.dir, "snap")
+}
+
+func (t *TrashedSnap) dataDir(i int) string {
+	return filepath.Join(t.dir, "data", strconv.Itoa(i))
+}
+
+// seq returns the (nanosecond) sequence number of the trash entry, its
+// directory is named after; it orders the removals within a second
+func (t *TrashedSnap) seq() int64 {
+	base := filepath.Base(t.dir)
+	seq, err := strconv.ParseInt(base[strings.LastIndex(base, "_")+1:], 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return seq
+}
+
+// byRemoved sorts trash entries, most recently removed first
+type byRemoved []*TrashedSnap
+
+func (ts byRemoved) Len() int      { return len(ts) }
+func (ts byRemoved) Swap(a, b int) { ts[a], ts[b] = ts[b], ts[a] }
+func (ts byRemoved) Less(a, b int) bool {
+	if ts[a].Removed != ts[b].Removed {
+		return ts[a].Removed > ts[b].Removed
+	}
+
+	return ts[a].seq() > ts[b].seq()
+}
+
+// moveDir moves a directory, copying it if it can not simply be
+// renamed (e.g. because src and dst are on different filesystems)
+func moveDir(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+
+	cmd := exec.Command("cp", "-a", src, dst)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("can not move %s to %s: %s (%v)", src, dst, output, err)
+	}
+
+	return os.RemoveAll(src)
+}
+
+func (t *TrashedSnap) save() error {
+	content, err := yaml.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	return helpers.AtomicWriteFile(filepath.Join(t.dir, trashInfoFile), content, 0600, 0)
+}
+
+// moveToTrash removes the snap from the system but keeps it (and
+// optionally its data) in the trash so it can be restored later
+func (s *SnapPart) moveToTrash(inter interacter, withData bool) error {
+	trashed := &TrashedSnap{
+		Name:    s.Name(),
+		Origin:  s.origin,
+		Version: s.Version(),
+		Basedir: s.basedir,
+		Active:  s.IsActive(),
+		Removed: correctedNow().Unix(),
+		State:   TrashPending,
+	}
+	trashed.dir = filepath.Join(dirs.SnapTrashDir, fmt.Sprintf("%s_%s_%d", QualifiedName(s), s.Version(), time.Now().UnixNano()))
+
+	if withData {
+		dataDirs, err := snapDataDirs(QualifiedName(s), s.Version())
+		if err != nil {
+			return err
+		}
+		for _, dir := range dataDirs {
+			if helpers.FileExists(dir) {
+				trashed.DataDirs = append(trashed.DataDirs, dir)
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(trashed.dir, "data"), 0700); err != nil {
+		return err
+	}
+
+	// the entry is there before anything changes, so that a removal
+	// that fails half way can still be restored (or purged)
+	if err := trashed.save(); err != nil {
+		return err
+	}
+
+	// (deactivating needs the snap where it is installed)
+	if err := s.unlink(inter); err != nil {
+		return err
+	}
+
+	if err := moveDir(s.basedir, trashed.snapDir()); err != nil {
+		return err
+	}
+
+	// the directory of the snap goes with its last version
+	snapDir := filepath.Dir(s.basedir)
+	if versions, err := ioutil.ReadDir(snapDir); err == nil && len(versions) == 0 {
+		if err := os.Remove(snapDir); err != nil {
+			logger.Noticef("Failed to remove %q: %v", snapDir, err)
+		}
+	}
+
+	for i, dir := range trashed.DataDirs {
+		if err := moveDir(dir, trashed.dataDir(i)); err != nil {
+			return err
+		}
+	}
+
+	s.removeIcon()
+
+	trashed.State = ""
+	return trashed.save()
+}
+
+// TrashedSnaps returns the snaps currently in the trash, most
+// recently removed first
+func TrashedSnaps() ([]*TrashedSnap, error) {
+	infos, err := filepath.Glob(filepath.Join(dirs.SnapTrashDir, "*", trashInfoFile))
+	if err != nil {
+		return nil, err
+	}
+
+	trashed := make([]*TrashedSnap, 0, len(infos))
+	for _, info := range infos {
+		content, err := ioutil.ReadFile(info)
+		if err != nil {
+			return nil, err
+		}
+
+		var t TrashedSnap
+		if err := yaml.Unmarshal(content, &t); err != nil {
+			return nil, &ErrInvalidYaml{File: info, Err: err, Yaml: content}
+		}
+		t.dir = filepath.Dir(info)
+
+		trashed = append(trashed, &t)
+	}
+	sort.Stable(byRemoved(trashed))
+
+	return trashed, nil
+}
+
+// RestoreRemoved restores the most recently removed version of the
+// snap with the given name[.origin] from the trash. The snap is made
+// active again if it was active when removed and no other version of
+// it is active now.
+func RestoreRemoved(name string, meter progress.Meter) error {
+	trashed, err := TrashedSnaps()
+	if err != nil {
+		return err
+	}
+
+	name, origin := SplitOrigin(name)
+	for _, t := range trashed {
+		if t.Name == name && (origin == "" || t.Origin == origin) {
+			return t.restore(meter)
+		}
+	}
+
+	return ErrPackageNotFound
+}
+
+func (t *TrashedSnap) restore(meter progress.Meter) error {
+	unlock, err := lockInstallRemove()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// a removal that failed half way may have left the snap (or
+	// some of its data) where it was
+	pending := t.State == TrashPending
+	if pending && !helpers.FileExists(t.snapDir()) {
+		if !helpers.FileExists(t.Basedir) {
+			return ErrPackageNotFound
+		}
+	} else {
+		if helpers.FileExists(t.Basedir) {
+			return ErrAlreadyInstalled
+		}
+
+		if err := os.MkdirAll(filepath.Dir(t.Basedir), 0755); err != nil {
+			return err
+		}
+		if err := moveDir(t.snapDir(), t.Basedir); err != nil {
+			return err
+		}
+	}
+
+	for i, dir := range t.DataDirs {
+		if pending && !helpers.FileExists(t.dataDir(i)) {
+			continue
+		}
+		// never overwrite data that got created in the meantime
+		if helpers.FileExists(dir) {
+			logger.Noticef("Not restoring data of %s to %q: already exists", t.Name, dir)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+			return err
+		}
+		if err := moveDir(t.dataDir(i), dir); err != nil {
+			return err
+		}
+	}
+
+	if err := os.RemoveAll(t.dir); err != nil {
+		return err
+	}
+
+	part, err := NewInstalledSnapPart(filepath.Join(t.Basedir, "meta", "package.yaml"), t.Origin)
+	if err != nil {
+		return err
+	}
+
+	if !t.Active || PackageNameActive(t.Name) {
+		return nil
+	}
+
+	return part.activate(false, meter)
+}
+
+// PurgeExpiredTrash permanently deletes the snaps that have been in
+// the trash for longer than TrashRetention
+func PurgeExpiredTrash() error {
+	trashed, err := TrashedSnaps()
+	if err != nil {
+		return err
+	}
+
+	// with a wrong clock anything could look expired
+	if !clockTrustworthy() {
+		logger.Noticef("Not purging the trash: the system clock is off")
+		return nil
+	}
+
+	for _, t := range trashed {
+		if time.Since(t.RemovedAt()) < TrashRetention {
+			continue
+		}
+		if err := os.RemoveAll(t.dir); err != nil {
+			return err
+		}
+		// (the snap of a removal that failed half way may still be
+		// installed)
+		if !helpers.FileExists(t.Basedir) {
+			removeMetadata(filepath.Base(filepath.Dir(t.Basedir)), t.Version)
+		}
+	}
+
+	return nil
+}
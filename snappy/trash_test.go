@@ -0,0 +1,170 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/pkg"
+)
+
+func (s *SnapTestSuite) TestRemoveMovesToTrash(c *C) {
+	makeTwoTestSnaps(c, pkg.TypeApp)
+
+	c.Assert(Remove("foo", 0, s.meter()), IsNil)
+
+	trashed, err := TrashedSnaps()
+	c.Assert(err, IsNil)
+	c.Assert(trashed, HasLen, 1)
+	c.Check(trashed[0].Name, Equals, "foo")
+	c.Check(trashed[0].Version, Equals, "2.0")
+	c.Check(trashed[0].Active, Equals, true)
+	c.Check(helpers.FileExists(trashed[0].Basedir), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(trashed[0].snapDir(), "meta", "package.yaml")), Equals, true)
+}
+
+func (s *SnapTestSuite) TestRemovePermanentlySkipsTrash(c *C) {
+	makeTwoTestSnaps(c, pkg.TypeApp)
+
+	c.Assert(Remove("foo", DoRemovePermanently, s.meter()), IsNil)
+
+	trashed, err := TrashedSnaps()
+	c.Assert(err, IsNil)
+	c.Check(trashed, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestRestoreRemoved(c *C) {
+	makeTwoTestSnaps(c, pkg.TypeApp)
+	dataDir := filepath.Join(dirs.SnapDataDir, fooComposedName, "2.0")
+	c.Assert(ioutil.WriteFile(filepath.Join(dataDir, "random"), []byte("data"), 0644), IsNil)
+
+	// remove all versions so nothing is active
+	c.Assert(Remove("foo", DoRemoveGC|DoRemoveData, s.meter()), IsNil)
+	c.Check(helpers.FileExists(dataDir), Equals, false)
+
+	c.Assert(RestoreRemoved("foo", s.meter()), IsNil)
+
+	part := ActiveSnapByName("foo")
+	c.Assert(part, NotNil)
+	c.Check(part.Version(), Equals, "2.0")
+	c.Check(helpers.FileExists(filepath.Join(dataDir, "random")), Equals, true)
+
+	trashed, err := TrashedSnaps()
+	c.Assert(err, IsNil)
+	c.Assert(trashed, HasLen, 1)
+	c.Check(trashed[0].Version, Equals, "1.0")
+}
+
+func (s *SnapTestSuite) TestRestoreRemovedNotFound(c *C) {
+	c.Check(RestoreRemoved("foo", s.meter()), Equals, ErrPackageNotFound)
+}
+
+func (s *SnapTestSuite) TestPurgeExpiredTrash(c *C) {
+	makeTwoTestSnaps(c, pkg.TypeApp)
+	c.Assert(Remove("foo", 0, s.meter()), IsNil)
+
+	c.Assert(PurgeExpiredTrash(), IsNil)
+	trashed, err := TrashedSnaps()
+	c.Assert(err, IsNil)
+	c.Check(trashed, HasLen, 1)
+
+	oldRetention := TrashRetention
+	TrashRetention = -time.Second
+	defer func() { TrashRetention = oldRetention }()
+
+	c.Assert(PurgeExpiredTrash(), IsNil)
+	trashed, err = TrashedSnaps()
+	c.Assert(err, IsNil)
+	c.Check(trashed, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestMoveToTrash(c *C) {
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	part, err := NewInstalledSnapPart(yamlPath, testOrigin)
+	c.Assert(err, IsNil)
+
+	c.Assert(part.moveToTrash(s.meter(), false), IsNil)
+
+	trashed, err := TrashedSnaps()
+	c.Assert(err, IsNil)
+	c.Assert(trashed, HasLen, 1)
+	c.Check(trashed[0].State, Equals, "")
+	c.Check(helpers.FileExists(part.basedir), Equals, false)
+	c.Check(helpers.FileExists(filepath.Join(trashed[0].snapDir(), "meta", "package.yaml")), Equals, true)
+}
+
+func (s *SnapTestSuite) TestRestorePendingTrash(c *C) {
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	basedir := filepath.Dir(filepath.Dir(yamlPath))
+
+	// a removal that failed before the snap got moved
+	t := &TrashedSnap{
+		Name:    "hello-app",
+		Origin:  testOrigin,
+		Version: "1.10",
+		Basedir: basedir,
+		State:   TrashPending,
+		dir:     filepath.Join(dirs.SnapTrashDir, "hello-app_1.10_1"),
+	}
+	c.Assert(os.MkdirAll(t.dir, 0700), IsNil)
+	c.Assert(t.save(), IsNil)
+
+	trashed, err := TrashedSnaps()
+	c.Assert(err, IsNil)
+	c.Assert(trashed, HasLen, 1)
+	c.Check(trashed[0].State, Equals, TrashPending)
+
+	c.Assert(RestoreRemoved("hello-app", s.meter()), IsNil)
+	c.Check(helpers.FileExists(yamlPath), Equals, true)
+
+	trashed, err = TrashedSnaps()
+	c.Assert(err, IsNil)
+	c.Check(trashed, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestTrashedSnapsSameSecond(c *C) {
+	// the entries sort by their sequence within a second (here not in
+	// the order of their names)
+	for _, entry := range []struct{ version, dir string }{
+		{"2.0", "foo_2.0_900"},
+		{"1.0", "foo_1.0_1000"},
+		{"3.0", "foo_3.0_800"},
+	} {
+		t := &TrashedSnap{Name: "foo", Version: entry.version, Removed: 42, dir: filepath.Join(dirs.SnapTrashDir, entry.dir)}
+		c.Assert(os.MkdirAll(t.dir, 0700), IsNil)
+		c.Assert(t.save(), IsNil)
+	}
+
+	trashed, err := TrashedSnaps()
+	c.Assert(err, IsNil)
+	c.Assert(trashed, HasLen, 3)
+	c.Check(trashed[0].Version, Equals, "1.0")
+	c.Check(trashed[1].Version, Equals, "2.0")
+	c.Check(trashed[2].Version, Equals, "3.0")
+}
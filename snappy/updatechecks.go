@@ -0,0 +1,152 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/logger"
+)
+
+// updateCheckHistorySize is the number of update checks kept
+var updateCheckHistorySize = 100
+
+// updateCheckCollapseInterval is how long identical update checks are
+// collapsed into a single UpdateCheck (and logged once)
+var updateCheckCollapseInterval = time.Hour
+
+// UpdateCheck is what the store said on an update check (or on several
+// identical ones in a row)
+type UpdateCheck struct {
+	// Time of the (first) check
+	Time time.Time `yaml:"time"`
+	// Last is the time of the last identical check
+	Last time.Time `yaml:"last"`
+	// Count is the number of identical checks
+	Count int `yaml:"count"`
+	// Available are the versions of the available updates, by
+	// qualified name
+	Available map[string]string `yaml:"available,omitempty"`
+	// Error of the check ("" if it succeeded)
+	Error string `yaml:"error,omitempty"`
+}
+
+// sameOutcome returns true if the checks got the same answer
+func (u *UpdateCheck) sameOutcome(other *UpdateCheck) bool {
+	return u.Error == other.Error && reflect.DeepEqual(u.Available, other.Available)
+}
+
+func updateCheckHistoryFile() string {
+	return filepath.Join(dirs.GlobalRootDir, dirs.SnappyDir, "update-checks.yaml")
+}
+
+func readUpdateCheckHistory() ([]UpdateCheck, error) {
+	content, err := ioutil.ReadFile(updateCheckHistoryFile())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var history []UpdateCheck
+	if err := yaml.Unmarshal(content, &history); err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// appendUpdateCheck adds the outcome of an update check to the history,
+// collapsing it into the previous entry if that had the same outcome
+// recently enough; the new entries are logged to the journal too
+func appendUpdateCheck(available []Part, checkErr error) error {
+	now := correctedNow().UTC()
+	check := UpdateCheck{Time: now, Last: now, Count: 1}
+	if checkErr != nil {
+		check.Error = checkErr.Error()
+	}
+	available = publishedOnly(available)
+	if len(available) > 0 {
+		check.Available = make(map[string]string, len(available))
+		for _, part := range available {
+			check.Available[QualifiedName(part)] = part.Version()
+		}
+	}
+
+	history, err := readUpdateCheckHistory()
+	if err != nil {
+		logger.Noticef("Failed to read the update check history, starting a new one: %v", err)
+	}
+
+	if n := len(history); n > 0 && history[n-1].sameOutcome(&check) && now.Sub(history[n-1].Last) < updateCheckCollapseInterval {
+		history[n-1].Last = now
+		history[n-1].Count++
+	} else {
+		switch {
+		case check.Error != "":
+			logger.Noticef("Update check failed: %s", check.Error)
+		default:
+			logger.Noticef("Update check found %d updates", len(check.Available))
+		}
+		history = append(history, check)
+	}
+	if len(history) > updateCheckHistorySize {
+		history = history[len(history)-updateCheckHistorySize:]
+	}
+
+	content, err := yaml.Marshal(history)
+	if err != nil {
+		return err
+	}
+	fn := updateCheckHistoryFile()
+	if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+		return err
+	}
+
+	return helpers.AtomicWriteFile(fn, content, 0644, 0)
+}
+
+// UpdateCheckHistory returns the last n update checks (all of them if
+// n is 0), the latest first
+func UpdateCheckHistory(n int) ([]UpdateCheck, error) {
+	history, err := readUpdateCheckHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	if n > 0 && len(history) > n {
+		history = history[len(history)-n:]
+	}
+	checks := make([]UpdateCheck, len(history))
+	for i := range history {
+		checks[i] = history[len(history)-1-i]
+	}
+
+	return checks, nil
+}
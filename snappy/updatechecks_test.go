@@ -0,0 +1,138 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2014-2015 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package snappy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"time"
+
+	. "gopkg.in/check.v1"
+
+	"github.com/ubuntu-core/snappy/dirs"
+	"github.com/ubuntu-core/snappy/partition"
+	"github.com/ubuntu-core/snappy/pkg/remote"
+)
+
+func (s *SnapTestSuite) TestUpdateCheckHistoryEmpty(c *C) {
+	checks, err := UpdateCheckHistory(10)
+	c.Assert(err, IsNil)
+	c.Check(checks, HasLen, 0)
+}
+
+func (s *SnapTestSuite) TestUpdateCheckHistory(c *C) {
+	available := []Part{
+		&RemoteSnapPart{pkg: remote.Snap{Name: "foo", Origin: "bar", Version: "2.0"}},
+		// unpublished snaps are no updates
+		&RemoteSnapPart{pkg: remote.Snap{Name: "gone", Origin: "bar", Version: "1.0", Status: remote.StatusUnpublished}},
+	}
+
+	recordUpdateCheck(available, nil)
+	recordUpdateCheck(nil, errors.New("no network"))
+
+	checks, err := UpdateCheckHistory(0)
+	c.Assert(err, IsNil)
+	c.Assert(checks, HasLen, 2)
+	c.Check(checks[0].Error, Equals, "no network")
+	c.Check(checks[0].Available, HasLen, 0)
+	c.Check(checks[1].Error, Equals, "")
+	c.Check(checks[1].Available, DeepEquals, map[string]string{"foo.bar": "2.0"})
+
+	checks, err = UpdateCheckHistory(1)
+	c.Assert(err, IsNil)
+	c.Assert(checks, HasLen, 1)
+	c.Check(checks[0].Error, Equals, "no network")
+}
+
+func (s *SnapTestSuite) TestUpdateCheckHistoryCollapses(c *C) {
+	recordUpdateCheck(nil, errors.New("no network"))
+	recordUpdateCheck(nil, errors.New("no network"))
+	recordUpdateCheck(nil, nil)
+
+	checks, err := UpdateCheckHistory(0)
+	c.Assert(err, IsNil)
+	c.Assert(checks, HasLen, 2)
+	c.Check(checks[0].Count, Equals, 1)
+	c.Check(checks[1].Count, Equals, 2)
+	c.Check(checks[1].Last.Before(checks[1].Time), Equals, false)
+}
+
+func (s *SnapTestSuite) TestUpdateCheckHistoryCollapsesOnlyRecent(c *C) {
+	defer func(d time.Duration) { updateCheckCollapseInterval = d }(updateCheckCollapseInterval)
+	updateCheckCollapseInterval = 0
+
+	recordUpdateCheck(nil, nil)
+	recordUpdateCheck(nil, nil)
+
+	checks, err := UpdateCheckHistory(0)
+	c.Assert(err, IsNil)
+	c.Check(checks, HasLen, 2)
+}
+
+func (s *SnapTestSuite) TestUpdateCheckHistoryIsRing(c *C) {
+	defer func(n int) { updateCheckHistorySize = n }(updateCheckHistorySize)
+	updateCheckHistorySize = 2
+
+	recordUpdateCheck(nil, errors.New("1"))
+	recordUpdateCheck(nil, errors.New("2"))
+	recordUpdateCheck(nil, errors.New("3"))
+
+	checks, err := UpdateCheckHistory(0)
+	c.Assert(err, IsNil)
+	c.Assert(checks, HasLen, 2)
+	c.Check(checks[0].Error, Equals, "3")
+	c.Check(checks[1].Error, Equals, "2")
+}
+
+func (s *SnapTestSuite) TestUpdateFailureIsNoCheckFailure(c *C) {
+	yamlPath, err := makeInstalledMockSnap(s.tempdir, "")
+	c.Assert(err, IsNil)
+	c.Assert(makeSnapActive(yamlPath), IsNil)
+
+	// (with no update of the system image)
+	newPartition = func() (p partition.Interface) {
+		return new(MockPartition)
+	}
+	defer func() { newPartition = newPartitionImpl }()
+	makeFakeSystemImageChannelConfig(c, filepath.Join(dirs.GlobalRootDir, systemImageChannelConfig), "1")
+	makeFakeSystemImageChannelConfig(c, filepath.Join(dirs.GlobalRootDir, "other", systemImageChannelConfig), "1")
+	siServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, fmt.Sprintf(mockSystemImageIndexJSONTemplate, "1"))
+	}))
+	defer siServer.Close()
+	systemImageServer = siServer.URL
+
+	// the check works, but the update of what it found does not
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = UpdateWithOptions(InstallOptions{SnapDir: makeSnapDir(c), Context: ctx})
+	c.Assert(err, Equals, context.Canceled)
+
+	checks, err := UpdateCheckHistory(0)
+	c.Assert(err, IsNil)
+	c.Assert(checks, HasLen, 1)
+	c.Check(checks[0].Error, Equals, "")
+	c.Check(checks[0].Available, DeepEquals, map[string]string{helloAppComposedName: "1.11"})
+}
@@ -28,6 +28,7 @@ import (
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
@@ -35,6 +36,7 @@ import (
 
 	"github.com/ubuntu-core/snappy/helpers"
 	"github.com/ubuntu-core/snappy/logger"
+	"github.com/ubuntu-core/snappy/progress"
 )
 
 var (
@@ -57,9 +59,19 @@ func run(args ...string) ([]byte, error) {
 	return bs, nil
 }
 
-// SystemctlCmd is called from the commands to actually call out to
-// systemctl. It's exported so it can be overridden by testing.
-var SystemctlCmd = run
+// Backend runs systemctl for a Systemd; the reporter given to New can
+// be one to carry the backend of the operation
+type Backend interface {
+	Systemctl(args ...string) ([]byte, error)
+}
+
+// SystemBackend is the Backend that calls out to the system's systemctl
+type SystemBackend struct{}
+
+// Systemctl calls systemctl with the given args
+func (SystemBackend) Systemctl(args ...string) ([]byte, error) {
+	return run(args...)
+}
 
 // jctl calls journalctl to get the JSON logs of the given services, wrapping the error if any.
 func jctl(svcs []string) ([]byte, error) {
@@ -92,6 +104,9 @@ type Systemd interface {
 	Restart(service string, timeout time.Duration) error
 	GenServiceFile(desc *ServiceDescription) string
 	GenSocketFile(desc *ServiceDescription) string
+	GenProxySocketFile(desc *ServiceDescription) string
+	GenProxyServiceFile(desc *ServiceDescription) string
+	GenMountFile(what, where string) string
 	Status(service string) (string, error)
 	ServiceStatus(service string) (*ServiceStatus, error)
 	Logs(services []string) ([]Log, error)
@@ -124,6 +139,17 @@ type ServiceDescription struct {
 	SocketUser      string
 	SocketGroup     string
 	ServiceFileName string
+	NeedsNetwork    bool
+	NeedsTimeSync   bool
+	// LogNamespace is the journal namespace the service logs to
+	LogNamespace         string
+	LogRateLimitInterval time.Duration
+	LogRateLimitBurst    int
+	// Ports are the ports negotiated for the service, it gets them
+	// as $SNAP_PORT_<NAME>
+	Ports map[string]int
+	// ProxyTarget is where the proxy forwards the connections to
+	ProxyTarget string
 }
 
 const (
@@ -141,19 +167,26 @@ type reporter interface {
 	Notify(string)
 }
 
-// New returns a Systemd that uses the given rootDir
+// New returns a Systemd that uses the given rootDir (and the Backend
+// of the reporter, if it is one)
 func New(rootDir string, rep reporter) Systemd {
-	return &systemd{rootDir: rootDir, reporter: rep}
+	backend, ok := rep.(Backend)
+	if !ok {
+		backend = SystemBackend{}
+	}
+
+	return &systemd{rootDir: rootDir, reporter: rep, backend: backend}
 }
 
 type systemd struct {
 	rootDir  string
 	reporter reporter
+	backend  Backend
 }
 
 // DaemonReload reloads systemd's configuration.
-func (*systemd) DaemonReload() error {
-	_, err := SystemctlCmd("daemon-reload")
+func (s *systemd) DaemonReload() error {
+	_, err := s.backend.Systemctl("daemon-reload")
 	return err
 }
 
@@ -172,13 +205,13 @@ func (s *systemd) Enable(serviceName string) error {
 
 // Disable the given service
 func (s *systemd) Disable(serviceName string) error {
-	_, err := SystemctlCmd("--root", s.rootDir, "disable", serviceName)
+	_, err := s.backend.Systemctl("--root", s.rootDir, "disable", serviceName)
 	return err
 }
 
 // Start the given service
-func (*systemd) Start(serviceName string) error {
-	_, err := SystemctlCmd("start", serviceName)
+func (s *systemd) Start(serviceName string) error {
+	_, err := s.backend.Systemctl("start", serviceName)
 	return err
 }
 
@@ -235,7 +268,7 @@ type ServiceStatus struct {
 }
 
 func (s *systemd) ServiceStatus(serviceName string) (*ServiceStatus, error) {
-	bs, err := SystemctlCmd("show", "--property=Id,LoadState,ActiveState,SubState,UnitFileState", serviceName)
+	bs, err := s.backend.Systemctl("show", "--property=Id,LoadState,ActiveState,SubState,UnitFileState", serviceName)
 	if err != nil {
 		return nil, err
 	}
@@ -264,7 +297,7 @@ func (s *systemd) ServiceStatus(serviceName string) (*ServiceStatus, error) {
 
 // Stop the given service, and wait until it has stopped.
 func (s *systemd) Stop(serviceName string, timeout time.Duration) error {
-	if _, err := SystemctlCmd("stop", serviceName); err != nil {
+	if _, err := s.backend.Systemctl("stop", serviceName); err != nil {
 		return err
 	}
 
@@ -272,9 +305,9 @@ func (s *systemd) Stop(serviceName string, timeout time.Duration) error {
 	stopped := false
 	max := time.Now().Add(timeout)
 	for time.Now().Before(max) {
-		s.reporter.Notify(fmt.Sprintf("Waiting for %s to stop.", serviceName))
+		progress.NotifyMessage(s.reporter, progress.NewMessage(progress.MsgWaitingForStop, serviceName))
 		for i := 0; i < stopSteps; i++ {
-			bs, err := SystemctlCmd("show", "--property=ActiveState", serviceName)
+			bs, err := s.backend.Systemctl("show", "--property=ActiveState", serviceName)
 			if err != nil {
 				return err
 			}
@@ -300,7 +333,11 @@ After=ubuntu-snappy.frameworks-pre.target{{ if .Socket }} {{.SocketFileName}}{{e
 Requires=ubuntu-snappy.frameworks-pre.target{{ if .Socket }} {{.SocketFileName}}{{end}}{{else}}After=ubuntu-snappy.frameworks.target{{ if .Socket }} {{.SocketFileName}}{{end}}
 Requires=ubuntu-snappy.frameworks.target{{ if .Socket }} {{.SocketFileName}}{{end}}{{end}}{{if .IsNetworked}}
 After=snappy-wait4network.service
-Requires=snappy-wait4network.service{{end}}
+Requires=snappy-wait4network.service{{end}}{{if .NeedsNetwork}}
+After=network-online.target
+Wants=network-online.target{{end}}{{if .NeedsTimeSync}}
+After=time-sync.target
+Wants=time-sync.target{{end}}
 X-Snappy=yes
 
 [Service]
@@ -311,7 +348,10 @@ Environment="SNAP_APP={{.AppTriple}}" {{.EnvVars}}
 {{if .Stop}}ExecStop=/usr/bin/ubuntu-core-launcher {{.UdevAppName}} {{.AaProfile}} {{.FullPathStop}}{{end}}
 {{if .PostStop}}ExecStopPost=/usr/bin/ubuntu-core-launcher {{.UdevAppName}} {{.AaProfile}} {{.FullPathPostStop}}{{end}}
 {{if .StopTimeout}}TimeoutStopSec={{.StopTimeout.Seconds}}{{end}}
-{{if .BusName}}BusName={{.BusName}}
+{{if .LogNamespace}}LogNamespace={{.LogNamespace}}
+{{end}}{{if .LogRateLimitInterval}}LogRateLimitIntervalSec={{.LogRateLimitInterval.Seconds}}
+{{end}}{{if .LogRateLimitBurst}}LogRateLimitBurst={{.LogRateLimitBurst}}
+{{end}}{{if .BusName}}BusName={{.BusName}}
 Type=dbus{{else}}{{if .Forking}}Type=forking{{end}}
 {{end}}
 
@@ -355,6 +395,7 @@ WantedBy={{.ServiceSystemdTarget}}
 	allVars = append(allVars, helpers.GetUserSnapEnvVars(wrapperData)...)
 	allVars = append(allVars, helpers.GetDeprecatedBasicSnapEnvVars(wrapperData)...)
 	allVars = append(allVars, helpers.GetDeprecatedUserSnapEnvVars(wrapperData)...)
+	allVars = append(allVars, portEnvVars(desc.Ports)...)
 	wrapperData.EnvVars = "\"" + strings.Join(allVars, "\" \"") + "\"" // allVars won't be empty
 
 	if err := t.Execute(&templateOut, wrapperData); err != nil {
@@ -365,6 +406,78 @@ WantedBy={{.ServiceSystemdTarget}}
 	return templateOut.String()
 }
 
+// PortEnvVar is the environment variable a service gets the given
+// negotiated port in
+func PortEnvVar(name string) string {
+	return "SNAP_PORT_" + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+func portEnvVars(ports map[string]int) []string {
+	names := make([]string, 0, len(ports))
+	for name := range ports {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	vars := make([]string, len(names))
+	for i, name := range names {
+		vars[i] = fmt.Sprintf("%s=%d", PortEnvVar(name), ports[name])
+	}
+
+	return vars
+}
+
+// GenProxySocketFile returns the socket the proxy desc.ServiceFileName
+// (of the same name) gets activated by; unlike the sockets of the
+// services it keeps listening when the proxy restarts
+func (s *systemd) GenProxySocketFile(desc *ServiceDescription) string {
+	socketTemplate := `[Unit]
+Description={{.Description}} Proxy Socket
+X-Snappy=yes
+
+[Socket]
+ListenStream={{.ListenStream}}
+
+[Install]
+WantedBy={{.SocketSystemdTarget}}
+`
+	var templateOut bytes.Buffer
+	t := template.Must(template.New("proxy-socket").Parse(socketTemplate))
+	data := struct {
+		ServiceDescription
+		SocketSystemdTarget string
+	}{*desc, socketsSystemdTarget}
+	if err := t.Execute(&templateOut, data); err != nil {
+		// this can never happen, except we forget a variable
+		logger.Panicf("Unable to execute template: %v", err)
+	}
+
+	return templateOut.String()
+}
+
+// GenProxyServiceFile returns the unit of the proxy that forwards the
+// connections of the socket desc.SocketFileName (of the same name) to
+// desc.ProxyTarget
+func (s *systemd) GenProxyServiceFile(desc *ServiceDescription) string {
+	serviceTemplate := `[Unit]
+Description={{.Description}} Proxy
+Requires={{.SocketFileName}}
+After={{.SocketFileName}}
+X-Snappy=yes
+
+[Service]
+ExecStart=/lib/systemd/systemd-socket-proxyd {{.ProxyTarget}}
+`
+	var templateOut bytes.Buffer
+	t := template.Must(template.New("proxy").Parse(serviceTemplate))
+	if err := t.Execute(&templateOut, desc); err != nil {
+		// this can never happen, except we forget a variable
+		logger.Panicf("Unable to execute template: %v", err)
+	}
+
+	return templateOut.String()
+}
+
 func (s *systemd) GenSocketFile(desc *ServiceDescription) string {
 	serviceTemplate := `[Unit]
 Description={{.Description}} Socket Unit File
@@ -411,9 +524,49 @@ WantedBy={{.SocketSystemdTarget}}
 	return templateOut.String()
 }
 
+// GenMountFile returns the content of a mount unit that bind-mounts
+// "what" onto "where"
+func (s *systemd) GenMountFile(what, where string) string {
+	return fmt.Sprintf(`[Unit]
+Description=Mount unit for %s
+X-Snappy=yes
+
+[Mount]
+What=%s
+Where=%s
+Options=bind
+
+[Install]
+WantedBy=%s
+`, where, what, where, servicesSystemdTarget)
+}
+
+// MountUnitName returns the name of the mount unit for the given
+// mount point, escaped like systemd-escape --path does
+func MountUnitName(where string) string {
+	where = strings.Trim(filepath.Clean(where), "/")
+
+	var buf bytes.Buffer
+	for i := 0; i < len(where); i++ {
+		c := where[i]
+		switch {
+		case c == '/':
+			buf.WriteByte('-')
+		case c == '.' && (i == 0 || where[i-1] == '/'):
+			fmt.Fprintf(&buf, `\x%02x`, c)
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == ':', c == '_', c == '.':
+			buf.WriteByte(c)
+		default:
+			fmt.Fprintf(&buf, `\x%02x`, c)
+		}
+	}
+
+	return buf.String() + ".mount"
+}
+
 // Kill all processes of the unit with the given signal
 func (s *systemd) Kill(serviceName, signal string) error {
-	_, err := SystemctlCmd("kill", serviceName, "-s", signal)
+	_, err := s.backend.Systemctl("kill", serviceName, "-s", signal)
 	return err
 }
 
@@ -29,16 +29,31 @@ import (
 	. "gopkg.in/check.v1"
 
 	"github.com/ubuntu-core/snappy/helpers"
+	"github.com/ubuntu-core/snappy/progress"
 )
 
 type testreporter struct {
-	msgs []string
+	msgs      []string
+	systemctl func(args ...string) ([]byte, error)
 }
 
 func (tr *testreporter) Notify(msg string) {
 	tr.msgs = append(tr.msgs, msg)
 }
 
+func (tr *testreporter) Systemctl(args ...string) ([]byte, error) {
+	return tr.systemctl(args...)
+}
+
+type testmessagereporter struct {
+	testreporter
+	messages []*progress.Message
+}
+
+func (tr *testmessagereporter) NotifyMessage(msg *progress.Message) {
+	tr.messages = append(tr.messages, msg)
+}
+
 // Hook up check.v1 into the "go test" runner
 func Test(t *testing.T) { TestingT(t) }
 
@@ -63,7 +78,6 @@ func (s *SystemdTestSuite) SetUpTest(c *C) {
 	// force UTC timezone, for reproducible timestamps
 	os.Setenv("TZ", "")
 
-	SystemctlCmd = s.myRun
 	s.i = 0
 	s.argses = nil
 	s.errors = nil
@@ -75,11 +89,10 @@ func (s *SystemdTestSuite) SetUpTest(c *C) {
 	s.jouts = nil
 	s.jerrs = nil
 
-	s.rep = new(testreporter)
+	s.rep = &testreporter{systemctl: s.myRun}
 }
 
 func (s *SystemdTestSuite) TearDownTest(c *C) {
-	SystemctlCmd = run
 	JournalctlCmd = jctl
 }
 
@@ -183,6 +196,24 @@ func (s *SystemdTestSuite) TestStopTimeout(c *C) {
 	c.Check(s.rep.msgs[0], Equals, "Waiting for foo to stop.")
 }
 
+func (s *SystemdTestSuite) TestStopTimeoutMessage(c *C) {
+	oldSteps := stopSteps
+	oldDelay := stopDelay
+	stopSteps = 2
+	stopDelay = time.Millisecond
+	defer func() {
+		stopSteps = oldSteps
+		stopDelay = oldDelay
+	}()
+
+	rep := &testmessagereporter{testreporter: testreporter{systemctl: s.myRun}}
+	err := New("", rep).Stop("foo", 10*time.Millisecond)
+	c.Assert(err, FitsTypeOf, &Timeout{})
+	c.Assert(rep.messages, Not(HasLen), 0)
+	c.Check(rep.messages[0], DeepEquals, &progress.Message{ID: progress.MsgWaitingForStop, Params: []string{"foo"}})
+	c.Check(rep.msgs, HasLen, 0)
+}
+
 func (s *SystemdTestSuite) TestDisable(c *C) {
 	err := New("xyzzy", s.rep).Disable("foo")
 	c.Assert(err, IsNil)
@@ -335,6 +366,111 @@ func (s *SystemdTestSuite) TestGenServiceFileWithBusName(c *C) {
 	c.Assert(generated, Equals, expectedDbusService)
 }
 
+func (s *SystemdTestSuite) TestGenServiceFileWithLogLimit(c *C) {
+	desc := &ServiceDescription{
+		AppName:              "app",
+		ServiceName:          "service",
+		Version:              "1.0",
+		AppPath:              "/apps/app.mvo/1.0/",
+		Start:                "bin/start",
+		UdevAppName:          "app.mvo",
+		LogNamespace:         "app.mvo",
+		LogRateLimitInterval: 30 * time.Second,
+		LogRateLimitBurst:    1000,
+	}
+
+	generated := New("", nil).GenServiceFile(desc)
+	c.Check(generated, Matches, `(?s).*\nLogNamespace=app.mvo\nLogRateLimitIntervalSec=30\nLogRateLimitBurst=1000\n.*`)
+}
+
+func (s *SystemdTestSuite) TestGenServiceFileNeedsNetworkAndTimeSync(c *C) {
+	desc := &ServiceDescription{
+		AppName:       "app",
+		ServiceName:   "service",
+		Version:       "1.0",
+		AppPath:       "/apps/app.mvo/1.0/",
+		Start:         "bin/start",
+		UdevAppName:   "app.mvo",
+		NeedsNetwork:  true,
+		NeedsTimeSync: true,
+	}
+
+	generated := New("", nil).GenServiceFile(desc)
+	c.Check(generated, Matches, `(?s).*\nAfter=network-online.target\nWants=network-online.target\nAfter=time-sync.target\nWants=time-sync.target\nX-Snappy=yes\n.*`)
+}
+
+func (s *SystemdTestSuite) TestGenServiceFileWithPorts(c *C) {
+	desc := &ServiceDescription{
+		AppName:     "app",
+		ServiceName: "service",
+		Version:     "1.0",
+		AppPath:     "/apps/app.mvo/1.0/",
+		Start:       "bin/start",
+		UdevAppName: "app.mvo",
+		Ports:       map[string]int{"web-ui": 40123, "api": 40124},
+	}
+
+	generated := New("", nil).GenServiceFile(desc)
+	c.Check(generated, Matches, `(?s).*\nEnvironment=.* "SNAP_PORT_API=40124" "SNAP_PORT_WEB_UI=40123"\n.*`)
+}
+
+func (s *SystemdTestSuite) TestGenProxySocketFile(c *C) {
+	desc := &ServiceDescription{
+		Description:  "service for package app",
+		ListenStream: "8080",
+	}
+
+	c.Check(New("", nil).GenProxySocketFile(desc), Equals, `[Unit]
+Description=service for package app Proxy Socket
+X-Snappy=yes
+
+[Socket]
+ListenStream=8080
+
+[Install]
+WantedBy=sockets.target
+`)
+}
+
+func (s *SystemdTestSuite) TestGenProxyServiceFile(c *C) {
+	desc := &ServiceDescription{
+		Description:    "service for package app",
+		SocketFileName: "app_service.proxy.socket",
+		ProxyTarget:    "127.0.0.1:40123",
+	}
+
+	c.Check(New("", nil).GenProxyServiceFile(desc), Equals, `[Unit]
+Description=service for package app Proxy
+Requires=app_service.proxy.socket
+After=app_service.proxy.socket
+X-Snappy=yes
+
+[Service]
+ExecStart=/lib/systemd/systemd-socket-proxyd 127.0.0.1:40123
+`)
+}
+
+func (s *SystemdTestSuite) TestMountUnitName(c *C) {
+	c.Check(MountUnitName("/apps"), Equals, "apps.mount")
+	c.Check(MountUnitName("/apps/foo-bar.mvo/"), Equals, `apps-foo\x2dbar.mvo.mount`)
+	c.Check(MountUnitName("/media/.hidden"), Equals, `media-\x2ehidden.mount`)
+}
+
+func (s *SystemdTestSuite) TestGenMountFile(c *C) {
+	c.Check(New("", nil).GenMountFile("/media/sd/foo.mvo", "/apps/foo.mvo"), Equals, `[Unit]
+Description=Mount unit for /apps/foo.mvo
+X-Snappy=yes
+
+[Mount]
+What=/media/sd/foo.mvo
+Where=/apps/foo.mvo
+Options=bind
+
+[Install]
+WantedBy=multi-user.target
+`)
+}
+
 func (s *SystemdTestSuite) TestRestart(c *C) {
 	s.outs = [][]byte{
 		nil, // for the "stop" itself